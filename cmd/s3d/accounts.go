@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/server"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// accountsDir holds the data directories of the accounts, under the data directory and the mirrors
+// Its leading dot keeps it out of the buckets of the root namespace
+const accountsDir = ".accounts"
+
+// namespaces are the bucket namespaces served, the root one and one for each account
+type namespaces struct {
+	root     *storage.Storage
+	accounts *auth.Accounts
+	stores   map[string]*storage.Storage
+}
+
+// openNamespaces opens the storage of each account of the accounts file next to root,
+// which is the only namespace if no accounts file is configured
+func openNamespaces(cfg *Config, root *storage.Storage) (*namespaces, error) {
+	ns := &namespaces{root: root}
+	if cfg.AccountsFile == "" {
+		return ns, nil
+	}
+	accounts, err := auth.LoadAccounts(cfg.AccountsFile)
+	if err != nil {
+		return nil, err
+	}
+	ns.accounts = accounts
+	ns.stores = map[string]*storage.Storage{}
+	for _, name := range accounts.Names() {
+		store, err := storage.NewStorage(filepath.Join(cfg.DataDir, accountsDir, name), storageOptions(cfg, name)...)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		ns.stores[name] = store
+	}
+	return ns, nil
+}
+
+// all returns the storage of every namespace, the root one first
+func (ns *namespaces) all() []*storage.Storage {
+	stores := []*storage.Storage{ns.root}
+	if ns.accounts != nil {
+		for _, name := range ns.accounts.Names() {
+			stores = append(stores, ns.stores[name])
+		}
+	}
+	return stores
+}
+
+// handler serves each namespace with the handler newHandler creates for its account and storage,
+// routing requests by the account of their access key, the account of the root namespace being empty
+func (ns *namespaces) handler(newHandler func(account string, store *storage.Storage) http.Handler) http.Handler {
+	root := newHandler("", ns.root)
+	if ns.accounts == nil {
+		return root
+	}
+	handlers := map[string]http.Handler{}
+	for name, store := range ns.stores {
+		handlers[name] = newHandler(name, store)
+	}
+	return server.NewAccountHandler(ns.accounts, handlers, root)
+}
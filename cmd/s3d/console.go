@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/server"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// createConsoleServer creates the web console, authenticating the access keys of the S3 server
+// as HTTP Basic credentials, anonymous if authenticator is nil, and limited by limits if not nil
+// Like the WebDAV gateway, console requests do not map onto the S3 operations policies, network rules
+// and the authorization webhook decide on, so they cannot be combined with the console
+func createConsoleServer(cfg *Config, ns *namespaces, authenticator *auth.AWS4Authenticator, limits *auth.Limits) (http.Handler, error) {
+	if len(cfg.RegoPolicy) != 0 || cfg.AuthzWebhook != "" || cfg.NetworkFile != "" {
+		return nil, fmt.Errorf("the web console cannot be combined with -rego-policy, -authz-webhook or -network-file")
+	}
+	h := ns.handler(func(_ string, store *storage.Storage) http.Handler {
+		return server.NewConsoleHandler(store,
+			server.WithConsoleEndpoint(consoleEndpoint(cfg), cfg.Region),
+			server.WithConsoleReadOnly(cfg.ReadOnly || cfg.ReadReplica),
+		)
+	})
+	if limits != nil {
+		h = limits.Middleware(h)
+	}
+	if authenticator == nil {
+		return h, nil
+	}
+	return authenticator.BasicAuthMiddleware("s3d console", h), nil
+}
+
+// consoleEndpoint returns the URL presigned URLs point to, the S3 server on the host of the
+// console unless configured, or none if the S3 server does not listen on a TCP address
+func consoleEndpoint(cfg *Config) string {
+	if cfg.ConsoleEndpoint != "" {
+		return cfg.ConsoleEndpoint
+	}
+	if strings.HasPrefix(cfg.Addr, unixScheme) || strings.HasPrefix(cfg.Addr, systemdScheme) {
+		return ""
+	}
+	if cfg.TLSCert != "" {
+		return "https://" + cfg.Addr
+	}
+	return "http://" + cfg.Addr
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/wzshiming/s3d/pkg/proxy"
+)
+
+// unixScheme starts addresses of Unix domain sockets
+const unixScheme = "unix://"
+
+// serve serves srv on listeners, accepting PROXY protocol headers if enabled and TLS
+// if srv has a TLS configuration, until one of them fails or srv is shut down
+func serve(cfg *Config, trusted proxy.Trusted, srv *http.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		l = &tcpListener{Listener: l, noDelay: cfg.NoDelay}
+		if cfg.ProxyProtocol {
+			l = proxy.NewListener(l, trusted)
+		}
+		go func() {
+			if srv.TLSConfig != nil {
+				errs <- srv.ServeTLS(l, "", "")
+				return
+			}
+			errs <- srv.Serve(l)
+		}()
+	}
+	return <-errs
+}
+
+// newHTTPServer creates the HTTP server of handler with the keep-alive and protocol settings of cfg
+func newHTTPServer(cfg *Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:     handler,
+		IdleTimeout: cfg.IdleTimeout,
+	}
+	if cfg.MaxIdleConns > 0 {
+		limiter := &idleLimiter{max: cfg.MaxIdleConns, idle: map[net.Conn]struct{}{}}
+		srv.ConnState = limiter.track
+	}
+	if cfg.HTTP2 {
+		// There is no TLS to negotiate HTTP/2 with, so clients use it with prior knowledge
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// loadTLSConfig loads the certificate of a server, nil if it is served without TLS
+func loadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// requireClientCertificates makes clients of a TLS server present a certificate issued by one
+// of the certificate authorities of the PEM file caFile
+func requireClientCertificates(config *tls.Config, caFile string) error {
+	if config == nil {
+		return fmt.Errorf("client certificates need the server to be served over TLS")
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// listen opens n listeners on addr, sharing it through SO_REUSEPORT if n is more than one
+// so the kernel spreads new connections over them
+// An addr of unix:///path/s3d.sock opens a single listener on a Unix domain socket instead,
+// and one of systemd:name listens on the sockets of that name passed by systemd
+func listen(addr string, n int) ([]net.Listener, error) {
+	if name, ok := strings.CutPrefix(addr, systemdScheme); ok {
+		return listenActivated(name)
+	}
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		l, err := listenUnix(path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	if n <= 1 {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePort}
+	listeners := make([]net.Listener, 0, n)
+	for range n {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		// The other listeners join the port the first one got, should addr leave it to the system
+		addr = l.Addr().String()
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// tcpListener sets TCP_NODELAY on the connections it accepts
+type tcpListener struct {
+	net.Listener
+	noDelay bool
+}
+
+// Accept waits for the next connection
+func (l *tcpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(l.noDelay)
+	}
+	return conn, nil
+}
+
+// idleLimiter closes keep-alive connections going idle once max connections are idle already,
+// bounding the descriptors and memory held by clients that keep connections open without using them
+type idleLimiter struct {
+	mu   sync.Mutex
+	max  int
+	idle map[net.Conn]struct{}
+}
+
+// track follows the state changes of the connections of a server
+func (l *idleLimiter) track(conn net.Conn, state http.ConnState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state != http.StateIdle {
+		delete(l.idle, conn)
+		return
+	}
+	if len(l.idle) >= l.max {
+		conn.Close()
+		return
+	}
+	l.idle[conn] = struct{}{}
+}
+
+// listenUnix listens on the Unix domain socket at path, replacing a socket left behind
+// by a process that is no longer listening on it
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing socket path in %s address", unixScheme)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == os.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{Listener: l}, nil
+}
+
+// loopbackAddr is the address reported for peers of Unix domain sockets
+var loopbackAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// unixListener reports the peers of the connections it accepts from the loopback address,
+// since they run on the same host, so trusted proxies, PROXY protocol headers and source
+// address conditions apply to them like to local TCP peers
+type unixListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{Conn: conn}, nil
+}
+
+// unixConn is a connection of a Unix domain socket reported from the loopback address
+type unixConn struct {
+	net.Conn
+}
+
+// RemoteAddr returns the loopback address
+func (c *unixConn) RemoteAddr() net.Addr {
+	return loopbackAddr
+}
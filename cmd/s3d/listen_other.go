@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is not supported on this platform, only a single listener can accept on an address
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("multiple listeners need SO_REUSEPORT, which is not supported on this platform")
+}
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"expvar"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/wzshiming/s3d/pkg/accesslog"
 	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/cluster"
+	"github.com/wzshiming/s3d/pkg/minio"
+	"github.com/wzshiming/s3d/pkg/proxy"
 	"github.com/wzshiming/s3d/pkg/server"
 	"github.com/wzshiming/s3d/pkg/storage"
 )
@@ -18,35 +34,352 @@ type Config struct {
 	DataDir     string
 	Credentials string
 	Region      string
+	// Regions are additional regions accepted in request signatures, comma-separated
+	Regions string
+	// Hosts are external hostnames requests may be signed for, comma-separated
+	Hosts       string
+	WebsiteAddr string
+	// TLSCert and TLSKey are the certificate and key files of the S3 server, served over TLS if set
+	TLSCert string
+	TLSKey  string
+	// TLSClientCA is a PEM file of the certificate authorities client certificates of the S3 server
+	// must be issued by, not required if empty
+	TLSClientCA string
+	// ClientCertIdentities is the path of a JSON file mapping client certificate subjects to access keys, disabled if empty
+	ClientCertIdentities string
+	// MaxPartSize is the maximum size of a single PutObject or UploadPart request in bytes
+	MaxPartSize int64
+	// MaxObjectSize is the maximum size of an object in bytes
+	MaxObjectSize int64
+	// MinFreeSpace is the free disk space in bytes below which writes are rejected
+	MinFreeSpace int64
+	// TempDir is the directory uploads are written to before being renamed into place, .temp in the data directory if empty
+	TempDir string
+	// MetricsAddr is the address serving expvar metrics, disabled if empty
+	MetricsAddr string
+	// ReadyTimeout is how long the checks of /readyz on the metrics endpoint may take before it reports not ready
+	ReadyTimeout time.Duration
+	// WebDAVAddr is the address of the WebDAV gateway, disabled if empty
+	WebDAVAddr string
+	// WebDAVTLSCert and WebDAVTLSKey are the certificate and key files of the WebDAV gateway, served over TLS if set
+	WebDAVTLSCert string
+	WebDAVTLSKey  string
+	// ConsoleAddr is the address of the web console, disabled if empty
+	ConsoleAddr string
+	// ConsoleEndpoint is the URL of the S3 server presigned URLs of the console point to
+	ConsoleEndpoint string
+	// ConsoleTLSCert and ConsoleTLSKey are the certificate and key files of the web console, served over TLS if set
+	ConsoleTLSCert string
+	ConsoleTLSKey  string
+	// TrustedProxies are the proxy addresses and networks allowed to report client addresses, comma-separated
+	TrustedProxies string
+	// ProxyProtocol enables PROXY protocol headers on the listeners
+	ProxyProtocol bool
+	// Listeners is the number of listeners accepting on each address through SO_REUSEPORT
+	Listeners int
+	// IdleTimeout is how long keep-alive connections wait for the next request, no limit if 0
+	IdleTimeout time.Duration
+	// MaxIdleConns is the number of idle keep-alive connections kept open, unlimited if 0
+	MaxIdleConns int
+	// NoDelay sets TCP_NODELAY on accepted connections
+	NoDelay bool
+	// HTTP2 serves unencrypted HTTP/2 to clients using it with prior knowledge, alongside HTTP/1
+	HTTP2 bool
+	// ShutdownTimeout is how long in-flight requests may take to finish on SIGTERM or SIGINT
+	ShutdownTimeout time.Duration
+	// Compress enables on-the-fly compression of compressible GET responses
+	Compress bool
+	// SniffContentType stores the Content-Type detected from the content of uploads sent without one
+	SniffContentType bool
+	// AuditLog enables per-bucket audit logs of object changes
+	AuditLog bool
+	// AuthzWebhook is the URL of an external authorization endpoint, disabled if empty
+	AuthzWebhook string
+	// AuthzCacheTTL is how long authorization decisions of the webhook are cached
+	AuthzCacheTTL time.Duration
+	// RegoPolicy are the paths of Rego files and bundle directories whose s3d package decides on every request, disabled if empty
+	RegoPolicy []string
+	// LimitsFile is the path of a JSON file of per access key concurrency and bandwidth limits, disabled if empty
+	LimitsFile string
+	// NetworkFile is the path of a JSON file of the client networks allowed or denied, globally, per access key and per bucket, disabled if empty
+	NetworkFile string
+	// AccountsFile is the path of a JSON file assigning access keys and groups to accounts with bucket namespaces of their own, disabled if empty
+	AccountsFile string
+	// OIDCIssuer is the OpenID Connect provider whose ID tokens are exchanged for temporary credentials, disabled if empty
+	OIDCIssuer string
+	// OIDCAudience is the audience ID tokens must be issued for
+	OIDCAudience string
+	// OIDCGroupsClaim is the claim of ID tokens listing the groups of the user
+	OIDCGroupsClaim string
+	// OIDCJWKS is the URL or path of the key set signing ID tokens, discovered from the issuer if empty
+	OIDCJWKS string
+	// STSRolesFile is the path of a JSON file of the roles ID tokens may assume, any token is exchanged if empty
+	STSRolesFile string
+	// STSMaxDuration is the longest validity of temporary credentials
+	STSMaxDuration time.Duration
+	// CredentialsFile is a file of credentials, one accessKey:secretKey per line, optionally encrypted
+	CredentialsFile string
+	// CredentialsDir is a directory of credentials, one file per access key holding its secret, reloaded on changes
+	CredentialsDir string
+	// MasterKeyFile holds the key of an encrypted credentials file, S3D_MASTER_KEY is used if empty
+	MasterKeyFile string
+	// LockoutThreshold is the number of authentication failures locking a client IP, or an access key sent with wrong signatures, disabled if 0
+	LockoutThreshold int
+	// LockoutDelay is the first lockout duration, doubling with every further failure
+	LockoutDelay time.Duration
+	// LockoutMaxDelay caps the lockout duration
+	LockoutMaxDelay time.Duration
+	// ClockSkew is how far the clocks of clients may be off when checking the validity of presigned URLs
+	ClockSkew time.Duration
+	// DebugSignatures logs the canonical request and string to sign of requests failing signature checks
+	DebugSignatures bool
+	// StrictSecurity rejects requests sent over plain HTTP, unsigned payloads and unsigned content headers
+	StrictSecurity bool
+	// KeyUsageFile is where the last use of every access key is persisted, .key-usage.json in the data directory if empty
+	KeyUsageFile string
+	// KeyUsageInterval is how often the last use of access keys is persisted
+	KeyUsageInterval time.Duration
+	// AdminKeys are the access keys allowed to read the admin API of the metrics endpoint, comma-separated,
+	// the admin API is disabled if empty
+	AdminKeys string
+	// ListCacheTTL is how long ListBuckets and ListObjects responses are cached, disabled if 0
+	ListCacheTTL time.Duration
+	// MaxKeys caps the keys, multipart uploads and parts a listing returns
+	MaxKeys int
+	// CompatProfile names the provider whose quirks are emulated, see server.Profiles
+	CompatProfile string
+	// ExpirationInterval is how often objects past the default TTL of their bucket are deleted
+	// and trashed objects past their retention are purged, disabled if 0
+	ExpirationInterval time.Duration
+	// HeavyWorkers bounds the disk-bound operations served at once, unbounded if 0
+	HeavyWorkers int
+	// HeavyQueue is the number of disk-bound operations waiting for a worker before further ones are rejected
+	HeavyQueue int
+	// ReadOnly rejects every request that could change data
+	ReadOnly bool
+	// ReadOnlyKeys are access keys limited to reading, comma-separated
+	ReadOnlyKeys string
+	// ReadReplica serves a data directory written by another process, implying ReadOnly
+	ReadReplica bool
+	// MetadataCacheMaxAge is how long cached object metadata is trusted before it is reloaded, 0 for no limit
+	MetadataCacheMaxAge time.Duration
+	// PackThreshold is the size up to which object contents are packed into shared segment files, disabled if 0
+	PackThreshold int64
+	// PackCompactInterval is how often segments mostly left unreferenced by deletes are compacted, disabled if 0
+	PackCompactInterval time.Duration
+	// ShardFanout is the number of hashed sub-directories the entries of every directory of object keys are spread over,
+	// only accepted on data directories without buckets, disabled if 0
+	ShardFanout int
+	// CompressAtRest compresses the data of objects with compressible content types in the data directory
+	CompressAtRest bool
+	// Mirrors are directories, typically on other disks, holding a copy of every object's data, comma-separated
+	Mirrors string
+	// RepairMirrors copies missing and damaged object data to the mirrors in the background at startup
+	RepairMirrors bool
+	// RepairMissingOnly only copies missing object data during the repair, without hashing every copy to find damaged ones
+	RepairMissingOnly bool
+	// RepairRate is the maximum number of bytes per second copied by the repair, 0 for no limit
+	RepairRate int64
+	// ClusterNode is the base URL of this node in a cluster, clustering is disabled if empty
+	ClusterNode string
+	// ClusterNodes are the base URLs of the seed nodes this node joins the cluster through, comma-separated
+	ClusterNodes string
+	// ClusterHeartbeat is the interval between gossip rounds with the other nodes
+	ClusterHeartbeat time.Duration
+	// ClusterSecretFile holds the secret nodes sign forwarded requests with, S3D_CLUSTER_SECRET is used if empty
+	ClusterSecretFile string
+	// AccessLog controls the buffering of access logs, changed at runtime by the admin keys with /admin/access-log on the metrics endpoint
+	AccessLog accesslog.Options
 }
 
 // parseCredentials parses comma-separated credentials and adds them to the authenticator
 func parseCredentials(credString string, authenticator *auth.AWS4Authenticator) error {
-	if credString == "" {
-		return nil
+	credentials, err := auth.ParseCredentials(credString)
+	if err != nil {
+		return err
 	}
+	addCredentials(credentials, authenticator)
+	return nil
+}
 
-	credList := strings.Split(credString, ",")
-	for _, cred := range credList {
-		parts := strings.SplitN(strings.TrimSpace(cred), ":", 2)
-		if len(parts) == 2 {
-			authenticator.AddCredentials(parts[0], parts[1])
-			log.Printf("Added credentials for access key: %s", parts[0])
-		}
+// addCredentials adds credentials to the authenticator
+func addCredentials(credentials []auth.Credentials, authenticator *auth.AWS4Authenticator) {
+	for _, cred := range credentials {
+		authenticator.AddCredentials(cred.AccessKeyID, cred.SecretAccessKey)
+		log.Printf("Added credentials for access key: %s", cred.AccessKeyID)
 	}
-	return nil
 }
 
-// createServer creates and configures the S3 server
-func createServer(cfg *Config) (http.Handler, error) {
-	// Create storage
-	store, err := storage.NewStorage(cfg.DataDir)
+// masterKey returns the key encrypting the credentials file, read from
+// the S3D_MASTER_KEY environment variable or the master key file
+func masterKey(cfg *Config) ([]byte, error) {
+	if cfg.MasterKeyFile == "" {
+		return []byte(os.Getenv("S3D_MASTER_KEY")), nil
+	}
+	key, err := os.ReadFile(cfg.MasterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(key), nil
+}
+
+// clusterSecret returns the secret shared by the cluster nodes
+func clusterSecret(cfg *Config) ([]byte, error) {
+	if cfg.ClusterSecretFile == "" {
+		return []byte(os.Getenv("S3D_CLUSTER_SECRET")), nil
+	}
+	secret, err := os.ReadFile(cfg.ClusterSecretFile)
 	if err != nil {
 		return nil, err
 	}
-	s := server.NewS3Handler(store, server.WithRegion(cfg.Region))
-	if cfg.Credentials == "" {
-		return s, nil
+	return bytes.TrimSpace(secret), nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// accessLogFormatter writes access logs in the Apache Combined Log Format,
+// followed by the request ID of the request
+func accessLogFormatter(w io.Writer, params handlers.LogFormatterParams) {
+	host, _, err := net.SplitHostPort(params.Request.RemoteAddr)
+	if err != nil {
+		host = params.Request.RemoteAddr
+	}
+
+	username := "-"
+	if params.URL.User != nil && params.URL.User.Username() != "" {
+		username = params.URL.User.Username()
+	}
+
+	requestID := server.RequestIDFromContext(params.Request.Context())
+	if requestID == "" {
+		requestID = "-"
+	}
+
+	fmt.Fprintf(w, "%s - %s [%s] %q %d %d %q %q %s\n",
+		host,
+		username,
+		params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		params.Request.Method+" "+params.Request.RequestURI+" "+params.Request.Proto,
+		params.StatusCode,
+		params.Size,
+		params.Request.Referer(),
+		params.Request.UserAgent(),
+		requestID,
+	)
+}
+
+// runMirrorRepair copies missing and damaged object data to the mirrors, logging the progress
+func runMirrorRepair(cfg *Config, store *storage.Storage) {
+	log.Printf("Starting mirror repair")
+	var last time.Time
+	progress, err := store.RepairMirrors(context.Background(), storage.RepairOptions{
+		MissingOnly: cfg.RepairMissingOnly,
+		RateLimit:   cfg.RepairRate,
+		Progress: func(progress storage.RepairProgress) {
+			if time.Since(last) >= 10*time.Second {
+				last = time.Now()
+				log.Printf("Mirror repair: checked %d/%d objects, repaired %d copies", progress.Checked, progress.Objects, progress.Repaired)
+			}
+		},
+	})
+	if err != nil {
+		log.Printf("Mirror repair failed: %v", err)
+		return
+	}
+	log.Printf("Mirror repair done: checked %d objects, repaired %d copies (%d bytes), %d failed", progress.Checked, progress.Repaired, progress.Bytes, progress.Failed)
+}
+
+// runExpiration deletes the objects past the default TTL of their bucket, and purges the trashed
+// objects past their retention, every ExpirationInterval
+func runExpiration(cfg *Config, store *storage.Storage) {
+	ticker := time.NewTicker(cfg.ExpirationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := store.ExpireObjects(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Object expiration failed after %d objects: %v", expired, err)
+		} else if expired > 0 {
+			log.Printf("Object expiration deleted %d objects", expired)
+		}
+
+		purged, err := store.PurgeTrash(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Trash purge failed after %d objects: %v", purged, err)
+		} else if purged > 0 {
+			log.Printf("Trash purge removed %d objects", purged)
+		}
+	}
+}
+
+// runPackCompaction reclaims the space of deleted packed contents every PackCompactInterval
+func runPackCompaction(cfg *Config, store *storage.Storage) {
+	ticker := time.NewTicker(cfg.PackCompactInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reclaimed, err := store.CompactPacks(context.Background())
+		if err != nil {
+			log.Printf("Pack compaction failed after reclaiming %d bytes: %v", reclaimed, err)
+		} else if reclaimed > 0 {
+			log.Printf("Pack compaction reclaimed %d bytes", reclaimed)
+		}
+	}
+}
+
+// storageOptions returns the options of the storage of the root namespace, or of account
+func storageOptions(cfg *Config, account string) []storage.Option {
+	storageOpts := []storage.Option{
+		storage.WithMaxPartSize(cfg.MaxPartSize),
+		storage.WithMaxObjectSize(cfg.MaxObjectSize),
+		storage.WithMinFreeSpace(cfg.MinFreeSpace),
+		storage.WithTempDir(cfg.TempDir),
+		storage.WithMetadataCacheMaxAge(cfg.MetadataCacheMaxAge),
+		storage.WithMigrationProgress(func(version int, description string, done, total int) {
+			log.Printf("Migrating data directory to layout version %d (%s): %d/%d", version, description, done, total)
+		}),
+	}
+	if cfg.ReadReplica {
+		storageOpts = append(storageOpts, storage.WithReadReplica())
+	}
+	if mirrors := splitList(cfg.Mirrors); len(mirrors) != 0 {
+		if account != "" {
+			for i, mirror := range mirrors {
+				mirrors[i] = filepath.Join(mirror, accountsDir, account)
+			}
+		}
+		storageOpts = append(storageOpts, storage.WithMirrors(mirrors...))
+	}
+	if cfg.PackThreshold > 0 {
+		storageOpts = append(storageOpts, storage.WithPacking(cfg.PackThreshold))
+	}
+	if cfg.ShardFanout > 0 {
+		storageOpts = append(storageOpts, storage.WithDirectorySharding(cfg.ShardFanout))
+	}
+	if cfg.CompressAtRest {
+		storageOpts = append(storageOpts, storage.WithCompression())
+	}
+	return storageOpts
+}
+
+// newAuthenticator creates the authenticator of the configured credentials, nil if none are configured
+func newAuthenticator(cfg *Config) (*auth.AWS4Authenticator, error) {
+	if cfg.Credentials == "" && cfg.CredentialsFile == "" && cfg.CredentialsDir == "" && cfg.OIDCIssuer == "" && cfg.ClientCertIdentities == "" {
+		if cfg.StrictSecurity {
+			return nil, fmt.Errorf("-strict-security needs credentials, anonymous requests are not signed")
+		}
+		return nil, nil
+	}
+	if cfg.StrictSecurity && cfg.TLSCert == "" && cfg.TrustedProxies == "" {
+		return nil, fmt.Errorf("-strict-security needs -tls-cert, or -trusted-proxies terminating TLS")
 	}
 
 	// Create authenticator
@@ -56,41 +389,622 @@ func createServer(cfg *Config) (http.Handler, error) {
 	if err := parseCredentials(cfg.Credentials, authenticator); err != nil {
 		return nil, err
 	}
+	if cfg.CredentialsFile != "" {
+		key, err := masterKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		credentials, err := auth.LoadCredentialsFile(cfg.CredentialsFile, key)
+		if err != nil {
+			return nil, err
+		}
+		addCredentials(credentials, authenticator)
+	}
+	if cfg.CredentialsDir != "" {
+		if err := authenticator.WatchCredentialsDir(context.Background(), cfg.CredentialsDir, auth.DefaultCredentialsDirInterval); err != nil {
+			return nil, err
+		}
+		log.Printf("Watching credentials directory: %s", cfg.CredentialsDir)
+	}
+
+	// Restrict signing regions only when extra regions are configured,
+	// so a single-region setup keeps accepting any region
+	if regions := splitList(cfg.Regions); len(regions) != 0 {
+		authenticator.AddRegion(cfg.Region)
+		for _, region := range regions {
+			authenticator.AddRegion(region)
+		}
+	}
+	for _, host := range splitList(cfg.Hosts) {
+		authenticator.AddHost(host)
+	}
+	if cfg.ClientCertIdentities != "" {
+		if cfg.TLSClientCA == "" {
+			return nil, fmt.Errorf("-client-cert-identities needs -tls-client-ca to verify client certificates")
+		}
+		identities, err := auth.LoadCertificateIdentities(cfg.ClientCertIdentities)
+		if err != nil {
+			return nil, err
+		}
+		for subject, accessKeyID := range identities {
+			authenticator.AddCertificateIdentity(subject, accessKeyID)
+		}
+	}
+	for _, accessKeyID := range splitList(cfg.ReadOnlyKeys) {
+		authenticator.AddReadOnlyKey(accessKeyID)
+	}
+	if cfg.LockoutThreshold > 0 {
+		authenticator.SetLockout(auth.NewLockout(cfg.LockoutThreshold, cfg.LockoutDelay, cfg.LockoutMaxDelay))
+	}
+	authenticator.SetClockSkew(cfg.ClockSkew)
+	if cfg.DebugSignatures {
+		authenticator.SetSignatureLog(log.Default())
+	}
+	authenticator.SetStrict(cfg.StrictSecurity)
+	return authenticator, nil
+}
+
+// createServer creates and configures the S3 server
+// Requests are authenticated by authenticator, anonymous if it is nil, and limited by limits if not nil
+func createServer(cfg *Config, ns *namespaces, authenticator *auth.AWS4Authenticator, limits *auth.Limits) (http.Handler, error) {
+	profile, err := server.ParseProfile(cfg.CompatProfile)
+	if err != nil {
+		return nil, err
+	}
+	h := ns.handler(func(account string, store *storage.Storage) http.Handler {
+		return server.NewS3Handler(store, server.WithAccount(account), server.WithRegion(cfg.Region), server.WithCompression(cfg.Compress), server.WithContentTypeSniffing(cfg.SniffContentType), server.WithAuditLog(cfg.AuditLog), server.WithReadOnly(cfg.ReadOnly || cfg.ReadReplica), server.WithHeavyOperationQueue(cfg.HeavyWorkers, cfg.HeavyQueue), server.WithListCache(cfg.ListCacheTTL), server.WithMaxKeys(cfg.MaxKeys), server.WithProfile(profile))
+	})
+	// Limits are applied after authorization, so denied requests do not take a slot
+	if limits != nil {
+		h = limits.Middleware(h)
+	}
+	if cfg.AuthzWebhook != "" {
+		h = auth.NewWebhookAuthorizer(cfg.AuthzWebhook, cfg.AuthzCacheTTL).Middleware(h)
+	}
+	if len(cfg.RegoPolicy) != 0 {
+		policy, err := auth.LoadRegoPolicy(context.Background(), cfg.RegoPolicy...)
+		if err != nil {
+			return nil, err
+		}
+		h = policy.Middleware(h)
+	}
+	if cfg.NetworkFile != "" {
+		rules, err := auth.LoadNetworkRules(cfg.NetworkFile)
+		if err != nil {
+			return nil, err
+		}
+		h = rules.Middleware(h)
+	}
+	if authenticator == nil {
+		return h, nil
+	}
+
+	h = authenticator.AuthMiddleware(h)
+	if cfg.OIDCIssuer != "" {
+		verifier := auth.NewOIDCVerifier(cfg.OIDCIssuer, cfg.OIDCAudience)
+		if cfg.OIDCJWKS != "" {
+			verifier.SetJWKS(cfg.OIDCJWKS)
+		}
+		sts := auth.NewSTS(authenticator, verifier, cfg.OIDCGroupsClaim, cfg.STSMaxDuration)
+		if cfg.STSRolesFile != "" {
+			roles, err := auth.LoadRoles(cfg.STSRolesFile)
+			if err != nil {
+				return nil, err
+			}
+			sts.SetRoles(roles)
+		}
+		h = sts.Middleware(h)
+	}
 
 	// Create server
-	return authenticator.AuthMiddleware(s), nil
+	return h, nil
 }
 
 func main() {
-	addr := flag.String("addr", ":8080", "Server address")
+	addr := flag.String("addr", ":8080", "Server address, or unix:///path/s3d.sock to listen on a Unix domain socket")
 	dataDir := flag.String("data", "./data", "Data directory for storage")
 	credentials := flag.String("credentials", "", "Credentials in format accessKeyID:secretAccessKey (can specify multiple separated by comma)")
 	region := flag.String("region", "us-east-1", "AWS region name")
+	regions := flag.String("regions", "", "Additional regions accepted in request signatures, separated by comma (any region if empty)")
+	hosts := flag.String("hosts", "", "External hostnames requests may be signed for when behind a proxy, separated by comma")
+	tlsCert := flag.String("tls-cert", "", "Certificate file of the S3 server, served over TLS along with -tls-key")
+	tlsKey := flag.String("tls-key", "", "Private key file of the -tls-cert certificate")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM file of the certificate authorities client certificates must be issued by, requiring clients of the S3 server to present one, not available with -cluster-node (not required if empty)")
+	clientCertIdentities := flag.String("client-cert-identities", "", "Path of a JSON file mapping client certificate subjects, distinguished names or URIs such as SPIFFE IDs, to the access keys unsigned requests presenting them are authenticated as (disabled if empty)")
+	websiteAddr := flag.String("website-addr", "", "Static website endpoint address serving the buckets of the root namespace, or unix:///path.sock for a Unix domain socket (disabled if empty)")
+	webdavAddr := flag.String("webdav-addr", "", "WebDAV gateway address for clients without S3 support such as Windows Explorer and macOS Finder, authenticating with the access keys as HTTP Basic credentials, not available with -cluster-node (disabled if empty)")
+	webdavTLSCert := flag.String("webdav-tls-cert", "", "Certificate file of the WebDAV gateway, served over TLS along with -webdav-tls-key so Basic credentials are not sent in the clear")
+	webdavTLSKey := flag.String("webdav-tls-key", "", "Private key file of the -webdav-tls-cert certificate")
+	consoleAddr := flag.String("console-addr", "", "Web console address for browsing buckets, uploading and downloading objects and sharing presigned URLs, authenticating with the access keys as HTTP Basic credentials, not available with -cluster-node (disabled if empty)")
+	consoleEndpoint := flag.String("console-endpoint", "", "URL of the S3 server presigned URLs of the console point to, a missing host being taken from the console request (http:// followed by -addr if empty)")
+	consoleTLSCert := flag.String("console-tls-cert", "", "Certificate file of the web console, served over TLS along with -console-tls-key so Basic credentials are not sent in the clear")
+	consoleTLSKey := flag.String("console-tls-key", "", "Private key file of the -console-tls-cert certificate")
+	maxPartSize := flag.Int64("max-part-size", storage.DefaultMaxPartSize, "Maximum size in bytes of a single object or part upload")
+	maxObjectSize := flag.Int64("max-object-size", storage.DefaultMaxObjectSize, "Maximum size in bytes of an object, including multipart uploads")
+	minFreeSpace := flag.Int64("min-free-space", 0, "Reject writes when free disk space in bytes drops below this value (disabled if 0)")
+	tempDir := flag.String("temp-dir", "", "Directory uploads are written to before being renamed into place, on the filesystem of the data directory so storing them does not copy them (.temp in the data directory if empty)")
+	trustedProxies := flag.String("trusted-proxies", "", "Proxy addresses or CIDR networks trusted for X-Forwarded-For, X-Real-IP and PROXY protocol, separated by comma")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "Accept PROXY protocol v1/v2 headers on the listeners")
+	listeners := flag.Int("listeners", 1, "Listeners accepting on each address through SO_REUSEPORT, spreading accepts over cores on busy hosts")
+	idleTimeout := flag.Duration("idle-timeout", 0, "How long keep-alive connections wait for the next request before being closed (no limit if 0)")
+	maxIdleConns := flag.Int("max-idle-conns", 0, "Idle keep-alive connections kept open, further ones are closed once their response is sent (unlimited if 0)")
+	noDelay := flag.Bool("tcp-nodelay", true, "Set TCP_NODELAY on accepted connections, sending small responses without waiting to coalesce them")
+	http2 := flag.Bool("http2", false, "Serve unencrypted HTTP/2 (h2c) to clients using it with prior knowledge, alongside HTTP/1")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long in-flight requests may take to finish on SIGTERM or SIGINT before the server exits")
+	compress := flag.Bool("compress", false, "Compress compressible GET responses with gzip or deflate when the client accepts it")
+	sniffContentType := flag.Bool("sniff-content-type", false, "Detect the Content-Type of uploads sent without one from their first 512 bytes instead of serving application/octet-stream")
+	auditLog := flag.Bool("audit-log", false, "Record object writes and deletes in per-bucket audit logs, queryable with GET /bucket?audit")
+	authzWebhook := flag.String("authz-webhook", "", "URL of an external authorization endpoint deciding on every request (disabled if empty)")
+	authzCacheTTL := flag.Duration("authz-cache-ttl", time.Minute, "How long authorization webhook decisions are cached (0 disables caching)")
+	regoPolicy := flag.String("rego-policy", "", "Comma-separated paths of Rego files and bundle directories evaluated by an embedded OPA for every request, allowing it if data.s3d.allow is true (disabled if empty)")
+	networkFile := flag.String("network-file", "", "Path of a JSON file of the client addresses and CIDR networks allowed or denied, globally, per access key and per bucket (disabled if empty)")
+	limitsFile := flag.String("limits-file", "", "Path of a JSON file of per access key concurrent request and bandwidth limits (disabled if empty)")
+	accountsFile := flag.String("accounts-file", "", "Path of a JSON file assigning access keys and identity provider groups to accounts, each seeing only its own buckets stored under "+accountsDir+" in the data directory (disabled if empty)")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL whose ID tokens are exchanged for temporary credentials with AssumeRoleWithWebIdentity (disabled if empty)")
+	oidcAudience := flag.String("oidc-audience", "s3d", "Audience ID tokens must be issued for")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", "groups", "ID token claim listing the groups of the user, passed to the authorization webhook and Rego policies")
+	oidcJWKS := flag.String("oidc-jwks", "", "URL or path of the JSON Web Key Set signing ID tokens, such as the /openid/v1/jwks of a Kubernetes API server, instead of discovering it from the issuer")
+	stsRolesFile := flag.String("sts-roles-file", "", "Path of a JSON file of the roles ID tokens may assume, mapping token subjects such as Kubernetes service accounts to groups (any token is exchanged if empty)")
+	stsMaxDuration := flag.Duration("sts-max-duration", time.Hour, "Longest validity of temporary credentials")
+	credentialsDir := flag.String("credentials-dir", "", "Directory of credentials, one file per access key holding its secret, such as a mounted Kubernetes Secret, reloaded on changes")
+	credentialsFile := flag.String("credentials-file", "", "File of credentials, one accessKey:secretKey per line, optionally encrypted with -encrypt-credentials")
+	masterKeyFile := flag.String("master-key-file", "", "File holding the key of an encrypted credentials file (S3D_MASTER_KEY is used if empty)")
+	encryptCredentials := flag.Bool("encrypt-credentials", false, "Encrypt plain text credentials read from stdin with the master key, write them to stdout and exit")
+	lockoutThreshold := flag.Int("lockout-threshold", 0, "Authentication failures after which a client IP, or an access key sent with wrong signatures, is locked out (disabled if 0)")
+	lockoutDelay := flag.Duration("lockout-delay", time.Second, "First lockout duration, doubling with every further failure")
+	lockoutMaxDelay := flag.Duration("lockout-max-delay", 15*time.Minute, "Longest lockout duration")
+	clockSkew := flag.Duration("clock-skew", 0, "How far the clocks of clients may be off, extending the validity of presigned URLs on both ends")
+	strictSecurity := flag.Bool("strict-security", false, "Reject requests sent over plain HTTP, payloads not covered by the signature and signatures not covering Content-Type and Content-Length, for regulated environments")
+	keyUsageFile := flag.String("key-usage-file", "", "File the last use of every access key is persisted to, served to -admin-keys by /admin/access-keys on the metrics endpoint (.key-usage.json in the data directory if empty)")
+	keyUsageInterval := flag.Duration("key-usage-interval", time.Minute, "How often the last use of access keys is persisted")
+	adminKeys := flag.String("admin-keys", "", "Access keys, separated by comma, allowed to read the admin API of the metrics endpoint with signed requests: /admin/access-keys and /admin/access-log (disabled if empty)")
+	debugSignatures := flag.Bool("debug-signatures", false, "Log the canonical request and string to sign of requests whose signature does not match, with session tokens redacted, to debug SignatureDoesNotMatch errors of new SDKs")
+	maxKeys := flag.Int("max-keys", 1000, "Most keys, multipart uploads and parts a listing returns, capping larger max-keys, max-uploads and max-parts (1000 like S3, raise only on trusted networks)")
+	compatProfile := flag.String("compat-profile", "s3d", "Provider quirks to emulate: s3d, aws (hex ETags, storage class validation), minio-strict (aws plus MinIO errors for encryption and unsupported configurations) or lenient (empty unset bucket configurations)")
+	listCacheTTL := flag.Duration("list-cache-ttl", 0, "Cache ListBuckets and ListObjects responses for pollers such as dashboards, invalidated by writes to the bucket (disabled if 0)")
+	expirationInterval := flag.Duration("expiration-interval", time.Hour, "How often objects past the default TTL of their bucket (PUT /bucket?default-ttl) are deleted and trashed objects past their retention (PUT /bucket?trash) are purged (disabled if 0)")
+	heavyWorkers := flag.Int("heavy-workers", 0, "Disk-bound operations such as completing multipart uploads, copies and listings served at once (unbounded if 0)")
+	heavyQueue := flag.Int("heavy-queue", 64, "Disk-bound operations waiting for a worker before further ones are rejected with 503 SlowDown")
+	readOnly := flag.Bool("read-only", false, "Serve in read-only maintenance mode, rejecting every write with 503 Service Unavailable")
+	readOnlyKeys := flag.String("read-only-keys", "", "Access keys limited to reading, separated by comma")
+	readReplica := flag.Bool("read-replica", false, "Serve reads from a data directory written by another s3d process, without locking it")
+	metadataCacheMaxAge := flag.Duration("metadata-cache-max-age", 0, "Reload cached object metadata older than this even if the file looks unchanged, for replicas on shared filesystems such as NFS (disabled if 0)")
+	packThreshold := flag.Int64("pack-threshold", 0, "Pack the contents of objects up to this size in bytes into shared segment files instead of a file each, saving inodes for many small objects (disabled if 0, cannot be combined with -mirrors)")
+	packCompactInterval := flag.Duration("pack-compact-interval", time.Hour, "How often pack segments mostly left unreferenced by deletes are compacted to reclaim their space (disabled if 0)")
+	shardFanout := flag.Int("shard-fanout", 0, "Spread the entries of every directory of object keys over this many hashed sub-directories, for prefixes with millions of keys (2 to 256, disabled if 0, only on new data directories and recorded there)")
+	compressAtRest := flag.Bool("compress-at-rest", false, "Compress the data of objects with compressible content types such as text and JSON in the data directory in zstd seekable frames, decompressing it on reads")
+	mirrors := flag.String("mirrors", "", "Directories on other disks keeping a copy of every object's data, separated by comma, used to heal reads when the data directory copy is lost")
+	repairMirrors := flag.Bool("repair-mirrors", false, "Copy object data missing from the mirrors or not matching its digest in the background at startup, e.g. after replacing a disk")
+	repairMissingOnly := flag.Bool("repair-missing-only", false, "Only copy missing object data during the mirror repair, without hashing every copy to rewrite damaged ones")
+	repairRate := flag.Int64("repair-rate", 0, "Maximum bytes per second copied by the mirror repair (unlimited if 0)")
+	clusterNode := flag.String("cluster-node", "", "Base URL of this node, such as http://10.0.0.1:8080, joining the cluster through the nodes of -cluster-nodes (disabled if empty)")
+	clusterNodes := flag.String("cluster-nodes", "", "Base URLs of seed nodes of the cluster, separated by comma; the other nodes are learned from them by gossip, and buckets are spread over all nodes by consistent hashing")
+	clusterHeartbeat := flag.Duration("cluster-heartbeat", cluster.DefaultHeartbeatInterval, "Interval between gossip rounds exchanging heartbeats with other cluster nodes, which are marked down once their heartbeats stop for 4 rounds")
+	clusterSecretFile := flag.String("cluster-secret-file", "", "File holding the secret shared by the cluster nodes to sign the requests they forward to each other (S3D_CLUSTER_SECRET is used if empty)")
+	importMinio := flag.String("import-minio", "", "Import the objects of a single-drive MinIO data directory into the data directory and exit")
+	metricsAddr := flag.String("metrics-addr", "", "Metrics endpoint address serving /debug/vars and /readyz (disabled if empty)")
+	readyTimeout := flag.Duration("ready-timeout", 5*time.Second, "How long the checks of /readyz, including the write probe of /readyz?deep, may take before it reports not ready")
+	accessLogBufferSize := flag.Int("access-log-buffer-size", 0, "Bytes of access log lines buffered in memory before they are written (written unbuffered if 0)")
+	accessLogFlushInterval := flag.Duration("access-log-flush-interval", time.Second, "How often buffered access log lines are written (disabled if 0)")
+	accessLogCacheTTL := flag.Duration("access-log-cache-ttl", 0, "Longest time an access log line stays buffered, checked when the next line is logged (disabled if 0)")
 	flag.Parse()
 
 	cfg := &Config{
-		Addr:        *addr,
-		DataDir:     *dataDir,
-		Credentials: *credentials,
-		Region:      *region,
+		Addr:          *addr,
+		DataDir:       *dataDir,
+		Credentials:   *credentials,
+		Region:        *region,
+		Regions:       *regions,
+		Hosts:         *hosts,
+		WebsiteAddr:   *websiteAddr,
+		MaxPartSize:   *maxPartSize,
+		MaxObjectSize: *maxObjectSize,
+		MinFreeSpace:  *minFreeSpace,
+		TempDir:       *tempDir,
+		MetricsAddr:   *metricsAddr,
+		ReadyTimeout:  *readyTimeout,
+
+		WebDAVAddr:    *webdavAddr,
+		WebDAVTLSCert: *webdavTLSCert,
+		WebDAVTLSKey:  *webdavTLSKey,
+
+		ConsoleAddr:     *consoleAddr,
+		ConsoleEndpoint: *consoleEndpoint,
+		ConsoleTLSCert:  *consoleTLSCert,
+		ConsoleTLSKey:   *consoleTLSKey,
+
+		TrustedProxies:   *trustedProxies,
+		ProxyProtocol:    *proxyProtocol,
+		Listeners:        *listeners,
+		IdleTimeout:      *idleTimeout,
+		MaxIdleConns:     *maxIdleConns,
+		NoDelay:          *noDelay,
+		HTTP2:            *http2,
+		ShutdownTimeout:  *shutdownTimeout,
+		Compress:         *compress,
+		SniffContentType: *sniffContentType,
+		AuditLog:         *auditLog,
+		AuthzWebhook:     *authzWebhook,
+		AuthzCacheTTL:    *authzCacheTTL,
+		RegoPolicy:       splitList(*regoPolicy),
+		LimitsFile:       *limitsFile,
+		NetworkFile:      *networkFile,
+		AccountsFile:     *accountsFile,
+
+		TLSCert:              *tlsCert,
+		TLSKey:               *tlsKey,
+		TLSClientCA:          *tlsClientCA,
+		ClientCertIdentities: *clientCertIdentities,
+
+		OIDCIssuer:      *oidcIssuer,
+		OIDCAudience:    *oidcAudience,
+		OIDCGroupsClaim: *oidcGroupsClaim,
+		OIDCJWKS:        *oidcJWKS,
+		STSRolesFile:    *stsRolesFile,
+		STSMaxDuration:  *stsMaxDuration,
+
+		CredentialsFile: *credentialsFile,
+		CredentialsDir:  *credentialsDir,
+		MasterKeyFile:   *masterKeyFile,
+
+		LockoutThreshold: *lockoutThreshold,
+		LockoutDelay:     *lockoutDelay,
+		LockoutMaxDelay:  *lockoutMaxDelay,
+		ClockSkew:        *clockSkew,
+		DebugSignatures:  *debugSignatures,
+		StrictSecurity:   *strictSecurity,
+		KeyUsageFile:     *keyUsageFile,
+		KeyUsageInterval: *keyUsageInterval,
+		AdminKeys:        *adminKeys,
+
+		ListCacheTTL:       *listCacheTTL,
+		MaxKeys:            *maxKeys,
+		CompatProfile:      *compatProfile,
+		ExpirationInterval: *expirationInterval,
+		HeavyWorkers:       *heavyWorkers,
+		HeavyQueue:         *heavyQueue,
+
+		ReadOnly:     *readOnly,
+		ReadOnlyKeys: *readOnlyKeys,
+		ReadReplica:  *readReplica,
+
+		MetadataCacheMaxAge: *metadataCacheMaxAge,
+		PackThreshold:       *packThreshold,
+		PackCompactInterval: *packCompactInterval,
+		ShardFanout:         *shardFanout,
+		CompressAtRest:      *compressAtRest,
+
+		Mirrors:           *mirrors,
+		RepairMirrors:     *repairMirrors,
+		RepairMissingOnly: *repairMissingOnly,
+		RepairRate:        *repairRate,
+
+		ClusterNode:       *clusterNode,
+		ClusterNodes:      *clusterNodes,
+		ClusterHeartbeat:  *clusterHeartbeat,
+		ClusterSecretFile: *clusterSecretFile,
+
+		AccessLog: accesslog.Options{
+			CacheTTL:      *accessLogCacheTTL,
+			MaxBufferSize: *accessLogBufferSize,
+			FlushInterval: *accessLogFlushInterval,
+		},
+	}
+
+	if *encryptCredentials {
+		key, err := masterKey(cfg)
+		if err != nil {
+			log.Fatalf("Failed to read master key: %v", err)
+		}
+		if len(key) == 0 {
+			log.Fatalf("A master key is required, set S3D_MASTER_KEY or -master-key-file")
+		}
+		plaintext, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read credentials: %v", err)
+		}
+		if _, err := auth.ParseCredentials(string(plaintext)); err != nil {
+			log.Fatalf("Invalid credentials: %v", err)
+		}
+		encrypted, err := auth.EncryptCredentials(plaintext, key)
+		if err != nil {
+			log.Fatalf("Failed to encrypt credentials: %v", err)
+		}
+		os.Stdout.Write(append(encrypted, '\n'))
+		return
+	}
+
+	trusted, err := proxy.ParseTrusted(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	// Create storage
+	store, err := storage.NewStorage(cfg.DataDir, storageOptions(cfg, "")...)
+	if err != nil {
+		log.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if *importMinio != "" {
+		stats, err := minio.Import(*importMinio, store, func(bucket, key string, err error) {
+			if err != nil {
+				log.Printf("Skipped %s/%s: %v", bucket, key, err)
+				return
+			}
+			log.Printf("Imported %s/%s", bucket, key)
+		})
+		store.Close()
+		if err != nil {
+			log.Fatalf("Failed to import MinIO data directory: %v", err)
+		}
+		log.Printf("Imported %d objects into %d new and %d existing buckets, skipped %d objects", stats.Objects, stats.Buckets, stats.ExistingBuckets, stats.Skipped)
+		return
+	}
+
+	ns, err := openNamespaces(cfg, store)
+	if err != nil {
+		log.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, store := range ns.all() {
+		if cfg.RepairMirrors {
+			go runMirrorRepair(cfg, store)
+		}
+		// Read-only servers leave expired and trashed objects to the process writing the data directory
+		if cfg.ExpirationInterval > 0 && !cfg.ReadOnly && !cfg.ReadReplica {
+			go runExpiration(cfg, store)
+		}
+		// Segments written before packing was disabled still get compacted
+		if cfg.PackCompactInterval > 0 && !cfg.ReadOnly && !cfg.ReadReplica {
+			go runPackCompaction(cfg, store)
+		}
 	}
 
-	handler, err := createServer(cfg)
+	authenticator, err := newAuthenticator(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	if cfg.AdminKeys != "" && authenticator == nil {
+		log.Fatalf("Failed to create server: -admin-keys needs credentials to sign admin requests with")
+	}
+	var keyUsage *auth.KeyUsage
+	if authenticator != nil {
+		keyUsage, err = auth.LoadKeyUsage(keyUsagePath(cfg))
+		if err != nil {
+			log.Fatalf("Failed to create server: %v", err)
+		}
+		authenticator.SetKeyUsage(keyUsage)
+		go keyUsage.Run(context.Background(), cfg.KeyUsageInterval)
+	}
+	if ns.accounts != nil {
+		if authenticator == nil {
+			log.Fatalf("Failed to create server: accounts need credentials to tell the requests of their access keys apart")
+		}
+		log.Printf("Accounts: %s", strings.Join(ns.accounts.Names(), ", "))
+	}
+	// Limits are shared by the S3 server, the WebDAV gateway and the web console
+	var limits *auth.Limits
+	if cfg.LimitsFile != "" {
+		limits, err = auth.LoadLimits(cfg.LimitsFile)
+		if err != nil {
+			log.Fatalf("Failed to create server: %v", err)
+		}
+	}
+	handler, err := createServer(cfg, ns, authenticator, limits)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	if cfg.ClusterNode != "" {
+		// The gateway and the console write to the local store whatever node owns the bucket,
+		// out of reach of S3 requests
+		if cfg.WebDAVAddr != "" {
+			log.Fatalf("Failed to create cluster: -webdav-addr serves the local store only and cannot be used with -cluster-node")
+		}
+		if cfg.ConsoleAddr != "" {
+			log.Fatalf("Failed to create cluster: -console-addr serves the local store only and cannot be used with -cluster-node")
+		}
+		// Nodes present no client certificate to each other, and forwarded requests lose the one of the client
+		if cfg.TLSClientCA != "" {
+			log.Fatalf("Failed to create cluster: -tls-client-ca cannot be used with -cluster-node, nodes do not forward client certificates")
+		}
+		seeds := splitList(cfg.ClusterNodes)
+		secret, err := clusterSecret(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create cluster: %v", err)
+		}
+		if len(secret) == 0 {
+			log.Fatalf("Failed to create cluster: -cluster-secret-file or S3D_CLUSTER_SECRET must hold the secret shared by the nodes")
+		}
+		members, err := cluster.NewMembership(cfg.ClusterNode, seeds, secret, cfg.ClusterHeartbeat)
+		if err != nil {
+			log.Fatalf("Failed to create cluster: %v", err)
+		}
+		go members.Run(context.Background())
+		// Requests are forwarded before authentication, the owning node verifies them
+		handler = cluster.New(members, cluster.DefaultVirtualNodes).Middleware(handler)
+		log.Printf("Cluster node %s joining through %d seed nodes", cfg.ClusterNode, len(seeds))
+	}
 
 	// Start server
 	log.Printf("Starting S3-compatible server on %s", cfg.Addr)
 	log.Printf("Data directory: %s", cfg.DataDir)
 	log.Printf("Region: %s", cfg.Region)
+	if cfg.ReadReplica {
+		log.Printf("Serving as a read replica, writes are rejected")
+	}
 
-	if cfg.Credentials == "" {
+	if authenticator == nil {
 		log.Printf("WARNING: Running without authentication (no credentials configured)")
 	}
 
-	handler = handlers.CombinedLoggingHandler(log.Writer(), handler)
-	if err := http.ListenAndServe(cfg.Addr, handler); err != nil {
+	accessLog := accesslog.NewWriter(log.Writer(), cfg.AccessLog)
+	go accessLog.Run(context.Background())
+
+	var websiteServer *http.Server
+	if cfg.WebsiteAddr != "" {
+		// Website endpoints are anonymous and read-only, like S3 website endpoints
+		// They serve the root namespace only, accounts cannot configure websites
+		log.Printf("Starting static website endpoint on %s", cfg.WebsiteAddr)
+		websiteHandler := server.RequestIDMiddleware(handlers.CustomLoggingHandler(accessLog, server.NewWebsiteHandler(store, server.WithWebsiteCompression(cfg.Compress)), accessLogFormatter))
+		websiteHandler = proxy.RealIPMiddleware(trusted, websiteHandler)
+		websiteListeners, err := listen(cfg.WebsiteAddr, cfg.Listeners)
+		if err != nil {
+			log.Fatalf("Website server failed: %v", err)
+		}
+		websiteServer = newHTTPServer(cfg, websiteHandler)
+		go func() {
+			if err := serve(cfg, trusted, websiteServer, websiteListeners); err != http.ErrServerClosed {
+				log.Fatalf("Website server failed: %v", err)
+			}
+		}()
+	}
+
+	var webdavServer *http.Server
+	if cfg.WebDAVAddr != "" {
+		log.Printf("Starting WebDAV gateway on %s", cfg.WebDAVAddr)
+		webdavHandler, err := createWebDAVServer(cfg, ns, authenticator, limits)
+		if err != nil {
+			log.Fatalf("WebDAV server failed: %v", err)
+		}
+		webdavHandler = server.RequestIDMiddleware(handlers.CustomLoggingHandler(accessLog, webdavHandler, accessLogFormatter))
+		webdavHandler = proxy.RealIPMiddleware(trusted, webdavHandler)
+		webdavListeners, err := listen(cfg.WebDAVAddr, cfg.Listeners)
+		if err != nil {
+			log.Fatalf("WebDAV server failed: %v", err)
+		}
+		webdavServer = newHTTPServer(cfg, webdavHandler)
+		if webdavServer.TLSConfig, err = loadTLSConfig(cfg.WebDAVTLSCert, cfg.WebDAVTLSKey); err != nil {
+			log.Fatalf("WebDAV server failed: %v", err)
+		}
+		if webdavServer.TLSConfig == nil && authenticator != nil {
+			log.Printf("WARNING: WebDAV gateway accepts Basic credentials without TLS, set -webdav-tls-cert and -webdav-tls-key unless a proxy terminates TLS")
+		}
+		go func() {
+			if err := serve(cfg, trusted, webdavServer, webdavListeners); err != http.ErrServerClosed {
+				log.Fatalf("WebDAV server failed: %v", err)
+			}
+		}()
+	}
+
+	var consoleServer *http.Server
+	if cfg.ConsoleAddr != "" {
+		log.Printf("Starting web console on %s", cfg.ConsoleAddr)
+		consoleHandler, err := createConsoleServer(cfg, ns, authenticator, limits)
+		if err != nil {
+			log.Fatalf("Console server failed: %v", err)
+		}
+		consoleHandler = server.RequestIDMiddleware(handlers.CustomLoggingHandler(accessLog, consoleHandler, accessLogFormatter))
+		consoleHandler = proxy.RealIPMiddleware(trusted, consoleHandler)
+		consoleListeners, err := listen(cfg.ConsoleAddr, cfg.Listeners)
+		if err != nil {
+			log.Fatalf("Console server failed: %v", err)
+		}
+		consoleServer = newHTTPServer(cfg, consoleHandler)
+		if consoleServer.TLSConfig, err = loadTLSConfig(cfg.ConsoleTLSCert, cfg.ConsoleTLSKey); err != nil {
+			log.Fatalf("Console server failed: %v", err)
+		}
+		if consoleServer.TLSConfig == nil && authenticator != nil {
+			log.Printf("WARNING: Web console accepts Basic credentials without TLS, set -console-tls-cert and -console-tls-key unless a proxy terminates TLS")
+		}
+		go func() {
+			if err := serve(cfg, trusted, consoleServer, consoleListeners); err != http.ErrServerClosed {
+				log.Fatalf("Console server failed: %v", err)
+			}
+		}()
+	}
+
+	if cfg.MetricsAddr != "" {
+		log.Printf("Starting metrics endpoint on %s", cfg.MetricsAddr)
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/readyz", server.NewReadyHandler(ns.all(), cfg.ReadyTimeout))
+		// The admin API lists access keys and changes the access log, so it is only served to the admin keys
+		if adminKeys := splitList(cfg.AdminKeys); authenticator != nil && len(adminKeys) != 0 {
+			mux.Handle("/admin/access-keys", authenticator.KeyUsageHandler(adminKeys))
+			mux.Handle("/admin/access-log", authenticator.AdminMiddleware(adminKeys, accessLog.Handler()))
+		}
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	handler = server.RequestIDMiddleware(handlers.CustomLoggingHandler(accessLog, handler, accessLogFormatter))
+	handler = proxy.RealIPMiddleware(trusted, handler)
+	mainListeners, err := listen(cfg.Addr, cfg.Listeners)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	mainServer := newHTTPServer(cfg, handler)
+	if mainServer.TLSConfig, err = loadTLSConfig(cfg.TLSCert, cfg.TLSKey); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	if cfg.TLSClientCA != "" {
+		if err := requireClientCertificates(mainServer.TLSConfig, cfg.TLSClientCA); err != nil {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve(cfg, trusted, mainServer, mainListeners)
+	}()
+
+	// Every listener is open, so a service manager may route connections here
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Failed to notify readiness to systemd: %v", err)
+	}
+
+	select {
+	case err := <-errs:
 		log.Fatalf("Server failed: %v", err)
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Printf("Failed to notify stopping to systemd: %v", err)
+	}
+	shutdown(cfg, mainServer, websiteServer, webdavServer, consoleServer)
+	if err := accessLog.Flush(); err != nil {
+		log.Printf("Failed to flush access log: %v", err)
+	}
+	if keyUsage != nil {
+		if err := keyUsage.Save(); err != nil {
+			log.Printf("Failed to save key usage: %v", err)
+		}
+	}
+}
+
+// keyUsagePath returns the file the last use of access keys is persisted to
+// Read replicas keep it in memory, leaving the data directory to the process writing it
+func keyUsagePath(cfg *Config) string {
+	if cfg.ReadReplica {
+		return ""
+	}
+	if cfg.KeyUsageFile != "" {
+		return cfg.KeyUsageFile
+	}
+	return filepath.Join(cfg.DataDir, ".key-usage.json")
+}
+
+// shutdown stops the servers from accepting connections and waits for their in-flight requests
+// to finish, for at most the shutdown timeout
+func shutdown(cfg *Config, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		if srv == nil {
+			continue
+		}
+		wg.Go(func() {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("Failed to shut down gracefully: %v", err)
+			}
+		})
 	}
+	wg.Wait()
 }
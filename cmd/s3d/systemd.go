@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// systemdScheme starts addresses of sockets passed by systemd socket activation,
+	// followed by the FileDescriptorName= of the sockets to use, or nothing to use all of them
+	systemdScheme = "systemd:"
+	// listenFDsStart is the first file descriptor passed by socket activation
+	listenFDsStart = 3
+)
+
+// activation holds the sockets passed by systemd, read once from the environment
+var activation struct {
+	once  sync.Once
+	files []*os.File
+	names []string
+	err   error
+}
+
+// loadActivation reads the sockets passed through LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES,
+// unsetting them so they are not passed on to child processes
+func loadActivation() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		activation.err = fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+		return
+	}
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	for i := range n {
+		// systemd names sockets "unknown" when it was not given names
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+		activation.files = append(activation.files, os.NewFile(uintptr(listenFDsStart+i), name))
+		activation.names = append(activation.names, name)
+	}
+}
+
+// listenActivated returns listeners on the sockets passed by systemd with the given name, or on all of them
+// if name is empty, each socket being taken once
+func listenActivated(name string) ([]net.Listener, error) {
+	activation.once.Do(loadActivation)
+	if activation.err != nil {
+		return nil, activation.err
+	}
+
+	var listeners []net.Listener
+	for i, file := range activation.files {
+		if file == nil || (name != "" && activation.names[i] != name) {
+			continue
+		}
+		l, err := net.FileListener(file)
+		file.Close()
+		activation.files[i] = nil
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket %s passed by systemd: %w", activation.names[i], err)
+		}
+		if _, ok := l.(*net.UnixListener); ok {
+			l = &unixListener{Listener: l}
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no socket named %s was passed by systemd", name)
+		}
+		return nil, fmt.Errorf("no socket was passed by systemd")
+	}
+	return listeners, nil
+}
+
+// sdNotify sends a state such as READY=1 or STOPPING=1 to the service manager through NOTIFY_SOCKET,
+// doing nothing when the process does not run under one
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Names of abstract sockets start with a NUL byte, which systemd writes as @
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
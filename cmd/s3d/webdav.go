@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/server"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// createWebDAVServer creates the WebDAV gateway, authenticating the access keys of the S3 server
+// as HTTP Basic credentials, anonymous if authenticator is nil, and limited by limits if not nil
+// Policies, network rules and the authorization webhook decide on S3 operations, which WebDAV requests
+// such as a MOVE to another bucket do not map onto, so they cannot be combined with the gateway
+func createWebDAVServer(cfg *Config, ns *namespaces, authenticator *auth.AWS4Authenticator, limits *auth.Limits) (http.Handler, error) {
+	if len(cfg.RegoPolicy) != 0 || cfg.AuthzWebhook != "" || cfg.NetworkFile != "" {
+		return nil, fmt.Errorf("the WebDAV gateway cannot be combined with -rego-policy, -authz-webhook or -network-file")
+	}
+	h := ns.handler(func(_ string, store *storage.Storage) http.Handler {
+		return server.NewWebDAVHandler(store, server.WithWebDAVReadOnly(cfg.ReadOnly || cfg.ReadReplica))
+	})
+	if limits != nil {
+		h = limits.Middleware(h)
+	}
+	if authenticator == nil {
+		return h, nil
+	}
+	return authenticator.BasicAuthMiddleware("s3d", h), nil
+}
@@ -0,0 +1,508 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// filesystem is a bucket mounted as a filesystem
+// Objects are files named by their key, and the slashes of keys separate directories,
+// which exist as long as keys start with their prefix or as directory objects
+type filesystem struct {
+	store    *storage.Storage
+	bucket   string
+	readOnly bool
+	uid      uint32
+	gid      uint32
+	// created is the creation time of the bucket, the time of directories without a directory object
+	created time.Time
+}
+
+// node is a file or directory of the mounted bucket
+// Its key is derived from its path on every request, so it follows renames of its parents
+type node struct {
+	fs.Inode
+	fsys *filesystem
+}
+
+var (
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeSetattrer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeCreater   = (*node)(nil)
+	_ fs.NodeMkdirer   = (*node)(nil)
+	_ fs.NodeUnlinker  = (*node)(nil)
+	_ fs.NodeRmdirer   = (*node)(nil)
+	_ fs.NodeRenamer   = (*node)(nil)
+
+	_ fs.NodeSetxattrer    = (*node)(nil)
+	_ fs.NodeRemovexattrer = (*node)(nil)
+)
+
+// key returns the object key of the node, empty for the root
+func (n *node) key() string {
+	return n.Path(nil)
+}
+
+// prefix returns the prefix of the keys in the node as a directory
+func (n *node) prefix() string {
+	if key := n.key(); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+// childKey returns the key of the entry name of the node as a directory
+func (n *node) childKey(name string) string {
+	return n.prefix() + name
+}
+
+// entry describes a file or directory of the bucket
+type entry struct {
+	dir     bool
+	size    int64
+	modTime time.Time
+}
+
+// stat looks up the entry of key, a directory if keys start with key followed by a slash,
+// a file if an object has the key
+func (f *filesystem) stat(key string) (*entry, error) {
+	if key == "" {
+		return &entry{dir: true, modTime: f.created}, nil
+	}
+
+	objects, prefixes, err := f.store.ListObjects(f.bucket, key+"/", "/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) != 0 || len(prefixes) != 0 {
+		e := &entry{dir: true, modTime: f.created}
+		if len(objects) != 0 && objects[0].Key == key+"/" {
+			e.modTime = objects[0].ModTime
+		}
+		return e, nil
+	}
+
+	objects, _, err = f.store.ListObjects(f.bucket, key, "", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 || objects[0].Key != key {
+		return nil, storage.ErrObjectNotFound
+	}
+	return &entry{size: objects[0].Size, modTime: objects[0].ModTime}, nil
+}
+
+// fillAttr fills the attributes of an entry
+func (f *filesystem) fillAttr(attr *fuse.Attr, e *entry) {
+	mode := uint32(0644)
+	if e.dir {
+		mode = 0755 | fuse.S_IFDIR
+	} else {
+		mode |= fuse.S_IFREG
+	}
+	if f.readOnly {
+		mode &^= 0222
+	}
+	attr.Mode = mode
+	attr.Nlink = 1
+	attr.Size = uint64(e.size)
+	attr.Blocks = (attr.Size + 511) / 512
+	attr.Owner = fuse.Owner{Uid: f.uid, Gid: f.gid}
+	attr.SetTimes(&e.modTime, &e.modTime, &e.modTime)
+}
+
+// newChild returns the inode of the entry name of n, reusing the one it already has
+// so the inode number stays the same while the entry is in use
+func (n *node) newChild(ctx context.Context, name string, e *entry, out *fuse.EntryOut) *fs.Inode {
+	n.fsys.fillAttr(&out.Attr, e)
+	mode := uint32(fuse.S_IFREG)
+	if e.dir {
+		mode = fuse.S_IFDIR
+	}
+	if child := n.GetChild(name); child != nil && child.Mode() == mode {
+		return child
+	}
+	return n.NewInode(ctx, &node{fsys: n.fsys}, fs.StableAttr{Mode: mode})
+}
+
+// Lookup finds the entry name of the directory
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	e, err := n.fsys.stat(n.childKey(name))
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	return n.newChild(ctx, name, e, out), 0
+}
+
+// Readdir lists the entries of the directory
+// A key that is both an object and the prefix of other keys is listed as a directory
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	prefix := n.prefix()
+	objects, prefixes, err := n.fsys.store.ListObjects(n.fsys.bucket, prefix, "/", "", 0)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	dirs := make(map[string]bool, len(prefixes))
+	entries := make([]fuse.DirEntry, 0, len(objects)+len(prefixes))
+	for _, p := range prefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/")
+		if name == "" {
+			continue
+		}
+		dirs[name] = true
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFDIR})
+	}
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		// The directory object of the directory itself
+		if name == "" || dirs[name] {
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFREG})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+// Getattr returns the attributes of the node, those of the data being written through f if it is open for writing
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := f.(*fileHandle); ok && h.tmp != nil {
+		return h.Getattr(ctx, out)
+	}
+	e, err := n.fsys.stat(n.key())
+	if err != nil {
+		return toErrno(err)
+	}
+	n.fsys.fillAttr(&out.Attr, e)
+	return 0
+}
+
+// Setattr changes the size of the file, other attributes are fixed and left as they are
+func (n *node) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if n.fsys.readOnly {
+			return syscall.EROFS
+		}
+		h, ok := f.(*fileHandle)
+		if !ok || h.tmp == nil {
+			var errno syscall.Errno
+			h, errno = n.openWrite(true)
+			if errno != 0 {
+				return errno
+			}
+			defer h.Release(ctx)
+		}
+		if errno := h.truncate(int64(size)); errno != 0 {
+			return errno
+		}
+		if errno := h.Flush(ctx); errno != 0 {
+			return errno
+		}
+	}
+	return n.Getattr(ctx, f, out)
+}
+
+// Open opens the file, buffering its data in a temporary file if it is opened for writing
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		if n.fsys.readOnly {
+			return nil, 0, syscall.EROFS
+		}
+		h, errno := n.openWrite(flags&syscall.O_TRUNC == 0)
+		if errno != 0 {
+			return nil, 0, errno
+		}
+		if flags&syscall.O_TRUNC != 0 {
+			h.dirty = true
+		}
+		return h, 0, 0
+	}
+
+	reader, _, err := n.fsys.store.GetObject(n.fsys.bucket, n.key())
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	return &fileHandle{fsys: n.fsys, key: n.key(), reader: reader}, 0, 0
+}
+
+// openWrite returns a handle writing the file, starting with its current data if keep is set
+func (n *node) openWrite(keep bool) (*fileHandle, syscall.Errno) {
+	tmp, err := os.CreateTemp("", "s3dfs-*")
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	h := &fileHandle{fsys: n.fsys, key: n.key(), tmp: tmp}
+	if keep {
+		reader, _, err := n.fsys.store.GetObject(n.fsys.bucket, h.key)
+		if err == nil {
+			_, err = io.Copy(tmp, reader)
+			reader.Close()
+		}
+		if err != nil {
+			h.Release(context.Background())
+			return nil, toErrno(err)
+		}
+	}
+	return h, 0
+}
+
+// Create creates an empty file and opens it for writing
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	if n.fsys.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	key := n.childKey(name)
+	info, err := n.fsys.put(key, strings.NewReader(""))
+	if err != nil {
+		return nil, nil, 0, toErrno(err)
+	}
+	tmp, err := os.CreateTemp("", "s3dfs-*")
+	if err != nil {
+		return nil, nil, 0, toErrno(err)
+	}
+	child := n.newChild(ctx, name, &entry{modTime: info.ModTime}, out)
+	return child, &fileHandle{fsys: n.fsys, key: key, tmp: tmp}, 0, 0
+}
+
+// Mkdir creates a directory as a directory object
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if n.fsys.readOnly {
+		return nil, syscall.EROFS
+	}
+	key := n.childKey(name)
+	if _, err := n.fsys.stat(key); err == nil {
+		return nil, syscall.EEXIST
+	}
+	info, err := n.fsys.put(key+"/", strings.NewReader(""))
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	return n.newChild(ctx, name, &entry{dir: true, modTime: info.ModTime}, out), 0
+}
+
+// Unlink deletes the object of a file
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	if n.fsys.readOnly {
+		return syscall.EROFS
+	}
+	return toErrno(n.fsys.store.DeleteObject(n.fsys.bucket, n.childKey(name)))
+}
+
+// Rmdir deletes the directory object of an empty directory
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	if n.fsys.readOnly {
+		return syscall.EROFS
+	}
+	prefix := n.childKey(name) + "/"
+	objects, prefixes, err := n.fsys.store.ListObjects(n.fsys.bucket, prefix, "/", "", 2)
+	if err != nil {
+		return toErrno(err)
+	}
+	if len(prefixes) != 0 || len(objects) > 1 || (len(objects) == 1 && objects[0].Key != prefix) {
+		return syscall.ENOTEMPTY
+	}
+	if err := n.fsys.store.DeleteObject(n.fsys.bucket, prefix); err != nil && err != storage.ErrObjectNotFound {
+		return toErrno(err)
+	}
+	return 0
+}
+
+// Rename renames the object of a file
+// Directories would need every key below them renamed, EXDEV makes tools such as mv copy them instead
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if n.fsys.readOnly {
+		return syscall.EROFS
+	}
+	if flags != 0 {
+		return syscall.EINVAL
+	}
+	parent, ok := newParent.(*node)
+	if !ok {
+		return syscall.EXDEV
+	}
+	src, dst := n.childKey(name), parent.childKey(newName)
+	e, err := n.fsys.stat(src)
+	if err != nil {
+		return toErrno(err)
+	}
+	if e.dir {
+		return syscall.EXDEV
+	}
+	return toErrno(n.fsys.store.RenameObject(n.fsys.bucket, src, dst))
+}
+
+// Setxattr rejects extended attributes, which objects do not have, so tools copying them fall back to the mode
+func (n *node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
+	return syscall.ENOTSUP
+}
+
+// Removexattr rejects extended attributes like Setxattr
+func (n *node) Removexattr(ctx context.Context, attr string) syscall.Errno {
+	return syscall.ENOTSUP
+}
+
+// put stores the object key with the content type of its extension
+func (f *filesystem) put(key string, data io.Reader) (*storage.ObjectInfo, error) {
+	metadata := storage.Metadata{ContentType: mime.TypeByExtension(path.Ext(key))}
+	return f.store.PutObject(context.Background(), f.bucket, key, data, metadata, "")
+}
+
+// fileHandle is an open file
+// Files opened for reading read the object, files opened for writing read and write a temporary
+// copy of it that replaces the object when it is flushed
+type fileHandle struct {
+	mu     sync.Mutex
+	fsys   *filesystem
+	key    string
+	reader io.ReadSeekCloser
+	tmp    *os.File
+	// dirty is set once tmp differs from the object
+	dirty bool
+}
+
+var (
+	_ fs.FileReader    = (*fileHandle)(nil)
+	_ fs.FileWriter    = (*fileHandle)(nil)
+	_ fs.FileGetattrer = (*fileHandle)(nil)
+	_ fs.FileFlusher   = (*fileHandle)(nil)
+	_ fs.FileFsyncer   = (*fileHandle)(nil)
+	_ fs.FileReleaser  = (*fileHandle)(nil)
+)
+
+// Read reads the file at off
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var n int
+	var err error
+	if h.tmp != nil {
+		n, err = h.tmp.ReadAt(dest, off)
+	} else if _, err = h.reader.Seek(off, io.SeekStart); err == nil {
+		n, err = io.ReadFull(h.reader, dest)
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, toErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// Write writes the file at off
+func (h *fileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tmp == nil {
+		return 0, syscall.EBADF
+	}
+	n, err := h.tmp.WriteAt(data, off)
+	if n > 0 {
+		h.dirty = true
+	}
+	if err != nil {
+		return uint32(n), toErrno(err)
+	}
+	return uint32(n), 0
+}
+
+// truncate changes the size of the file
+func (h *fileHandle) truncate(size int64) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.tmp.Truncate(size); err != nil {
+		return toErrno(err)
+	}
+	h.dirty = true
+	return 0
+}
+
+// Getattr returns the attributes of the file being written
+func (h *fileHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	info, err := h.tmp.Stat()
+	if err != nil {
+		return toErrno(err)
+	}
+	h.fsys.fillAttr(&out.Attr, &entry{size: info.Size(), modTime: info.ModTime()})
+	return 0
+}
+
+// Flush stores the data written since the last flush as the object, called on every close of the file
+func (h *fileHandle) Flush(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty {
+		return 0
+	}
+	if _, err := h.fsys.put(h.key, io.NewSectionReader(h.tmp, 0, 1<<62)); err != nil {
+		return toErrno(err)
+	}
+	h.dirty = false
+	return 0
+}
+
+// Fsync stores the data written so far like Flush
+func (h *fileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return h.Flush(ctx)
+}
+
+// Release closes the file, discarding its temporary copy
+func (h *fileHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.reader != nil {
+		h.reader.Close()
+	}
+	if h.tmp != nil {
+		h.tmp.Close()
+		os.Remove(h.tmp.Name())
+	}
+	return 0
+}
+
+// toErrno maps storage errors to the errno reported for them
+func toErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, storage.ErrObjectNotFound), errors.Is(err, storage.ErrBucketNotFound), os.IsNotExist(err):
+		return syscall.ENOENT
+	case errors.Is(err, storage.ErrInvalidObjectKey):
+		return syscall.EINVAL
+	case errors.Is(err, storage.ErrObjectLocked):
+		return syscall.EPERM
+	case errors.Is(err, storage.ErrBucketFrozen), errors.Is(err, storage.ErrReadReplica):
+		return syscall.EROFS
+	case errors.Is(err, storage.ErrInsufficientStorage):
+		return syscall.ENOSPC
+	case errors.Is(err, storage.ErrEntityTooLarge):
+		return syscall.EFBIG
+	default:
+		return syscall.EIO
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd
+
+// Command s3dfs mounts a bucket of an s3d data directory as a filesystem through FUSE,
+// reading and writing it through the storage package directly rather than over HTTP
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func main() {
+	dataDir := flag.String("data", "./data", "Data directory for storage")
+	bucket := flag.String("bucket", "", "Bucket to mount")
+	mountpoint := flag.String("mount", "", "Directory to mount the bucket on")
+	readReplica := flag.Bool("read-replica", false, "Mount read-only from a data directory written by a running s3d process, without locking it")
+	allowOther := flag.Bool("allow-other", false, "Allow users other than the one mounting to access the filesystem")
+	debug := flag.Bool("debug", false, "Log every FUSE request")
+	flag.Parse()
+
+	if *bucket == "" || *mountpoint == "" {
+		log.Fatalf("Both -bucket and -mount are required")
+	}
+
+	// Without -read-replica the data directory lock is taken, so s3d cannot write it meanwhile
+	var storageOpts []storage.Option
+	if *readReplica {
+		storageOpts = append(storageOpts, storage.WithReadReplica())
+	}
+	store, err := storage.NewStorage(*dataDir, storageOpts...)
+	if err != nil {
+		log.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	created, err := store.GetBucketCreationDate(*bucket)
+	if err != nil {
+		log.Fatalf("Failed to open bucket %s: %v", *bucket, err)
+	}
+
+	root := &node{fsys: &filesystem{
+		store:    store,
+		bucket:   *bucket,
+		readOnly: *readReplica,
+		uid:      uint32(os.Getuid()),
+		gid:      uint32(os.Getgid()),
+		created:  created,
+	}}
+	server, err := fs.Mount(*mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "s3d:" + *bucket,
+			Name:        "s3dfs",
+			AllowOther:  *allowOther,
+			Debug:       *debug,
+			DirectMount: true,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to mount %s: %v", *mountpoint, err)
+	}
+	log.Printf("Mounted bucket %s of %s on %s", *bucket, *dataDir, *mountpoint)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, unmounting", sig)
+		if err := server.Unmount(); err != nil {
+			log.Printf("Failed to unmount: %v", err)
+		}
+	}()
+	server.Wait()
+}
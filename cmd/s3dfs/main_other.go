@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd)
+
+// Command s3dfs mounts a bucket of an s3d data directory as a filesystem through FUSE,
+// reading and writing it through the storage package directly rather than over HTTP
+package main
+
+import (
+	"log"
+)
+
+func main() {
+	log.Fatalf("s3dfs needs FUSE, which is not supported on this platform")
+}
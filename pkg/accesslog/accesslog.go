@@ -0,0 +1,254 @@
+// Package accesslog buffers access log lines in memory and writes them in batches,
+// with options that may be changed while the server runs
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics exposes the occupancy of the access log buffer through expvar
+var metrics = expvar.NewMap("s3d_accesslog")
+
+// Options control when buffered lines are written
+type Options struct {
+	// CacheTTL is how long a line may stay buffered, the next line written after that flushes the buffer
+	CacheTTL time.Duration
+	// MaxBufferSize is the number of bytes buffered before they are flushed, lines are written unbuffered if 0
+	MaxBufferSize int
+	// FlushInterval is how often the buffer is flushed in the background, disabled if 0
+	FlushInterval time.Duration
+}
+
+// Writer buffers the lines written to it and writes them to an underlying writer in batches
+type Writer struct {
+	w       io.Writer
+	changed chan struct{} // signals Run that the flush interval changed
+
+	mu      sync.Mutex
+	options Options
+	buf     bytes.Buffer
+	lines   int       // number of lines in buf
+	oldest  time.Time // when the first line of buf was written
+	flushes int64     // number of times buf was written to w
+	now     func() time.Time
+
+	// bufferedBytes, bufferedLines and flushCount are published through expvar
+	bufferedBytes, bufferedLines, flushCount *expvar.Int
+}
+
+// Stats describe the options and the occupancy of the buffer
+type Stats struct {
+	CacheTTL      string `json:"cacheTTL"`
+	MaxBufferSize int    `json:"maxBufferSize"`
+	FlushInterval string `json:"flushInterval"`
+	BufferedBytes int    `json:"bufferedBytes"`
+	BufferedLines int    `json:"bufferedLines"`
+	Flushes       int64  `json:"flushes"`
+}
+
+// NewWriter returns a Writer buffering the lines written to w according to options
+// The occupancy of its buffer is published through expvar, replacing that of earlier Writers
+func NewWriter(w io.Writer, options Options) *Writer {
+	writer := &Writer{
+		w:             w,
+		changed:       make(chan struct{}, 1),
+		options:       options,
+		now:           time.Now,
+		bufferedBytes: new(expvar.Int),
+		bufferedLines: new(expvar.Int),
+		flushCount:    new(expvar.Int),
+	}
+	metrics.Set("buffered_bytes", writer.bufferedBytes)
+	metrics.Set("buffered_lines", writer.bufferedLines)
+	metrics.Set("flushes", writer.flushCount)
+	return writer
+}
+
+// Write buffers p, a complete line, and flushes the buffer if it is full or its oldest line expired
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if w.buf.Len() == 0 {
+		w.oldest = now
+	}
+	w.buf.Write(p)
+	w.lines++
+	if w.buf.Len() >= w.options.MaxBufferSize || w.options.CacheTTL > 0 && now.Sub(w.oldest) >= w.options.CacheTTL {
+		if err := w.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	w.publishLocked()
+	return len(p), nil
+}
+
+// Flush writes the buffered lines
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flushLocked()
+	w.publishLocked()
+	return err
+}
+
+// flushLocked writes the buffered lines, which are dropped if writing them fails
+func (w *Writer) flushLocked() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf.Bytes())
+	w.buf.Reset()
+	w.lines = 0
+	w.flushes++
+	return err
+}
+
+// publishLocked exposes the occupancy of the buffer through expvar
+func (w *Writer) publishLocked() {
+	w.bufferedBytes.Set(int64(w.buf.Len()))
+	w.bufferedLines.Set(int64(w.lines))
+	w.flushCount.Set(w.flushes)
+}
+
+// Options returns the current options
+func (w *Writer) Options() Options {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.options
+}
+
+// SetOptions replaces the options, flushing the buffer if it no longer fits
+func (w *Writer) SetOptions(options Options) error {
+	w.mu.Lock()
+	w.options = options
+	var err error
+	if w.buf.Len() >= options.MaxBufferSize {
+		err = w.flushLocked()
+	}
+	w.publishLocked()
+	w.mu.Unlock()
+
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Stats returns the options and the occupancy of the buffer
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{
+		CacheTTL:      w.options.CacheTTL.String(),
+		MaxBufferSize: w.options.MaxBufferSize,
+		FlushInterval: w.options.FlushInterval.String(),
+		BufferedBytes: w.buf.Len(),
+		BufferedLines: w.lines,
+		Flushes:       w.flushes,
+	}
+}
+
+// Run flushes the buffer every flush interval until ctx is done, and once more before returning
+func (w *Writer) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		timer.Stop()
+		var tick <-chan time.Time
+		if interval := w.Options().FlushInterval; interval > 0 {
+			timer.Reset(interval)
+			tick = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if err := w.Flush(); err != nil {
+				log.Printf("Failed to flush access log: %v", err)
+			}
+			return
+		case <-w.changed:
+		case <-tick:
+			if err := w.Flush(); err != nil {
+				log.Printf("Failed to flush access log: %v", err)
+			}
+		}
+	}
+}
+
+// Handler serves the options and the occupancy of the buffer as JSON
+// PUT requests change the options given by the cacheTTL, maxBufferSize and flushInterval query parameters,
+// such as ?maxBufferSize=65536&flushInterval=5s, and POST requests flush the buffer
+func (w *Writer) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			options, err := parseOptions(w.Options(), r)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := w.SetOptions(options); err != nil {
+				http.Error(rw, "failed to flush: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPost:
+			if err := w.Flush(); err != nil {
+				http.Error(rw, "failed to flush: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			rw.Header().Set("Allow", "GET, HEAD, PUT, POST")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(w.Stats())
+	})
+}
+
+// parseOptions returns options with the values of the query parameters of r
+func parseOptions(options Options, r *http.Request) (Options, error) {
+	query := r.URL.Query()
+	var err error
+	if s := query.Get("cacheTTL"); s != "" {
+		if options.CacheTTL, err = parseDuration(s); err != nil {
+			return options, fmt.Errorf("invalid cacheTTL: %w", err)
+		}
+	}
+	if s := query.Get("maxBufferSize"); s != "" {
+		if options.MaxBufferSize, err = strconv.Atoi(s); err != nil || options.MaxBufferSize < 0 {
+			return options, fmt.Errorf("invalid maxBufferSize %q", s)
+		}
+	}
+	if s := query.Get("flushInterval"); s != "" {
+		if options.FlushInterval, err = parseDuration(s); err != nil {
+			return options, fmt.Errorf("invalid flushInterval: %w", err)
+		}
+	}
+	return options, nil
+}
+
+// parseDuration parses a non-negative duration
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %s", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,172 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWriterUnbuffered(t *testing.T) {
+	var out syncBuffer
+	w := NewWriter(&out, Options{})
+	w.Write([]byte("line 1\n"))
+	if out.String() != "line 1\n" {
+		t.Errorf("Expected lines to be written unbuffered, got %q", out.String())
+	}
+}
+
+func TestWriterBuffered(t *testing.T) {
+	var out syncBuffer
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := NewWriter(&out, Options{MaxBufferSize: 20, CacheTTL: time.Minute})
+	w.now = func() time.Time { return now }
+
+	w.Write([]byte("line 1\n"))
+	w.Write([]byte("line 2\n"))
+	if out.String() != "" {
+		t.Fatalf("Expected lines to be buffered, got %q", out.String())
+	}
+	if stats := w.Stats(); stats.BufferedBytes != 14 || stats.BufferedLines != 2 {
+		t.Errorf("Expected 2 buffered lines of 14 bytes, got %+v", stats)
+	}
+
+	// Filling the buffer flushes it
+	w.Write([]byte("line 3\n"))
+	if out.String() != "line 1\nline 2\nline 3\n" {
+		t.Errorf("Expected a full buffer to be flushed, got %q", out.String())
+	}
+	if stats := w.Stats(); stats.BufferedBytes != 0 || stats.Flushes != 1 {
+		t.Errorf("Expected an empty buffer after a flush, got %+v", stats)
+	}
+
+	// Lines buffered for longer than the cache TTL are flushed by the next line
+	w.Write([]byte("line 4\n"))
+	now = now.Add(time.Minute)
+	w.Write([]byte("line 5\n"))
+	if out.String() != "line 1\nline 2\nline 3\nline 4\nline 5\n" {
+		t.Errorf("Expected expired lines to be flushed, got %q", out.String())
+	}
+
+	// Shrinking the buffer flushes the lines that no longer fit
+	w.Write([]byte("line 6\n"))
+	if err := w.SetOptions(Options{MaxBufferSize: 4}); err != nil {
+		t.Fatalf("Failed to set options: %v", err)
+	}
+	if out.String() != "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\n" {
+		t.Errorf("Expected a smaller buffer to flush the lines, got %q", out.String())
+	}
+}
+
+func TestWriterMetrics(t *testing.T) {
+	var out syncBuffer
+	w := NewWriter(&out, Options{MaxBufferSize: 1 << 10})
+	published := metrics.Get("buffered_bytes")
+
+	w.Write([]byte("line 1\n"))
+	if got := metrics.Get("buffered_bytes"); got != published {
+		t.Fatal("Expected the variables published at construction to be updated in place")
+	}
+	if got := metrics.Get("buffered_lines").String(); got != "1" {
+		t.Errorf("Expected 1 buffered line published, got %s", got)
+	}
+	w.Flush()
+	if got := metrics.Get("buffered_bytes").String(); got != "0" {
+		t.Errorf("Expected no buffered bytes published after a flush, got %s", got)
+	}
+	if got := metrics.Get("flushes").String(); got != "1" {
+		t.Errorf("Expected 1 flush published, got %s", got)
+	}
+}
+
+func TestWriterRun(t *testing.T) {
+	var out syncBuffer
+	w := NewWriter(&out, Options{MaxBufferSize: 1024})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	w.Write([]byte("line 1\n"))
+	w.SetOptions(Options{MaxBufferSize: 1024, FlushInterval: 10 * time.Millisecond})
+	deadline := time.Now().Add(5 * time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if out.String() != "line 1\n" {
+		t.Errorf("Expected the buffer to be flushed every interval, got %q", out.String())
+	}
+
+	w.SetOptions(Options{MaxBufferSize: 1024})
+	w.Write([]byte("line 2\n"))
+	cancel()
+	<-done
+	if out.String() != "line 1\nline 2\n" {
+		t.Errorf("Expected the buffer to be flushed on return, got %q", out.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var out syncBuffer
+	w := NewWriter(&out, Options{MaxBufferSize: 1024, FlushInterval: time.Second})
+	handler := w.Handler()
+	serve := func(method, target string) (int, Stats) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		var stats Stats
+		json.Unmarshal(rec.Body.Bytes(), &stats)
+		return rec.Code, stats
+	}
+
+	w.Write([]byte("line 1\n"))
+	code, stats := serve(http.MethodGet, "/admin/access-log")
+	if code != http.StatusOK || stats.MaxBufferSize != 1024 || stats.FlushInterval != "1s" || stats.BufferedLines != 1 {
+		t.Errorf("Expected the options and occupancy, got %d: %+v", code, stats)
+	}
+
+	code, stats = serve(http.MethodPut, "/admin/access-log?maxBufferSize=4096&cacheTTL=30s")
+	if code != http.StatusOK || stats.MaxBufferSize != 4096 || stats.CacheTTL != "30s" || stats.FlushInterval != "1s" {
+		t.Errorf("Expected the given options to change, got %d: %+v", code, stats)
+	}
+	if got := w.Options(); got.MaxBufferSize != 4096 || got.CacheTTL != 30*time.Second {
+		t.Errorf("Expected the writer to use the new options, got %+v", got)
+	}
+
+	code, stats = serve(http.MethodPost, "/admin/access-log")
+	if code != http.StatusOK || stats.BufferedLines != 0 || out.String() != "line 1\n" {
+		t.Errorf("Expected a forced flush, got %d: %+v", code, stats)
+	}
+
+	for _, target := range []string{"?maxBufferSize=-1", "?flushInterval=soon", "?cacheTTL=-1s"} {
+		if code, _ := serve(http.MethodPut, "/admin/access-log"+target); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", target, code)
+		}
+	}
+	if code, _ := serve(http.MethodDelete, "/admin/access-log"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected DELETE to be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// accountNamePattern matches account names, which name directories and appear in canonical IDs
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ErrAmbiguousAccount is returned for temporary credentials whose groups belong to several accounts
+var ErrAmbiguousAccount = errors.New("the groups of the credentials belong to several accounts")
+
+// Accounts assigns access keys and identity provider groups to accounts, each with a bucket namespace
+// of its own, so tenants of a shared instance cannot see each other's buckets and may reuse bucket names
+type Accounts struct {
+	// Accounts are the accounts by name
+	Accounts map[string]Account `json:"accounts"`
+
+	accountOf      map[string]string
+	accountOfGroup map[string]string
+}
+
+// Account is a tenant of the server
+type Account struct {
+	// AccessKeys are the access keys of the account
+	AccessKeys []string `json:"accessKeys"`
+	// Groups are the identity provider groups whose temporary credentials belong to the account
+	Groups []string `json:"groups,omitempty"`
+}
+
+// LoadAccounts reads accounts from a JSON file
+func LoadAccounts(path string) (*Accounts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file Accounts
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid accounts %s: %w", path, err)
+	}
+	accounts, err := NewAccounts(file.Accounts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid accounts %s: %w", path, err)
+	}
+	return accounts, nil
+}
+
+// NewAccounts creates accounts, rejecting invalid names and access keys or groups shared by accounts
+func NewAccounts(accounts map[string]Account) (*Accounts, error) {
+	a := &Accounts{Accounts: accounts, accountOf: map[string]string{}, accountOfGroup: map[string]string{}}
+	for _, name := range a.Names() {
+		if !accountNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("account name %q must be lowercase letters, digits and hyphens", name)
+		}
+		for _, accessKeyID := range accounts[name].AccessKeys {
+			if other, ok := a.accountOf[accessKeyID]; ok {
+				return nil, fmt.Errorf("access key %q belongs to both accounts %q and %q", accessKeyID, other, name)
+			}
+			a.accountOf[accessKeyID] = name
+		}
+		for _, group := range accounts[name].Groups {
+			if group == "" {
+				return nil, fmt.Errorf("account %q has an empty group", name)
+			}
+			if other, ok := a.accountOfGroup[group]; ok {
+				return nil, fmt.Errorf("group %q belongs to both accounts %q and %q", group, other, name)
+			}
+			a.accountOfGroup[group] = name
+		}
+	}
+	return a, nil
+}
+
+// Names returns the names of the accounts in order
+func (a *Accounts) Names() []string {
+	names := make([]string, 0, len(a.Accounts))
+	for name := range a.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AccountOf returns the account of accessKeyID, empty for access keys of the root namespace
+func (a *Accounts) AccountOf(accessKeyID string) string {
+	return a.accountOf[accessKeyID]
+}
+
+// Resolve returns the account of a request authenticated with accessKeyID, or with temporary credentials
+// of the identity provider groups, the account of the access key coming first; empty if neither belongs to one
+func (a *Accounts) Resolve(accessKeyID string, groups []string) (string, error) {
+	if account := a.accountOf[accessKeyID]; account != "" {
+		return account, nil
+	}
+	account := ""
+	for _, group := range groups {
+		other := a.accountOfGroup[group]
+		if other == "" || other == account {
+			continue
+		}
+		if account != "" {
+			return "", ErrAmbiguousAccount
+		}
+		account = other
+	}
+	return account, nil
+}
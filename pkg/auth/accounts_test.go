@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAccounts(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"accounts":{"team-a":{"accessKeys":["a1","a2"],"groups":["developers"]},"team-b":{"accessKeys":["b1"],"groups":["analysts","auditors"]}}}`), 0644)
+	accounts, err := LoadAccounts(valid)
+	if err != nil {
+		t.Fatalf("Failed to load accounts: %v", err)
+	}
+	if names := accounts.Names(); len(names) != 2 || names[0] != "team-a" || names[1] != "team-b" {
+		t.Errorf("Expected the sorted account names, got %v", names)
+	}
+	for accessKeyID, want := range map[string]string{"a1": "team-a", "a2": "team-a", "b1": "team-b", "root": "", "": ""} {
+		if got := accounts.AccountOf(accessKeyID); got != want {
+			t.Errorf("Expected access key %q in account %q, got %q", accessKeyID, want, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		accessKeyID string
+		groups      []string
+		want        string
+		err         error
+	}{
+		{"a1", []string{"analysts"}, "team-a", nil},
+		{"session", []string{"other", "auditors"}, "team-b", nil},
+		{"session", []string{"analysts", "auditors"}, "team-b", nil},
+		{"session", []string{"developers", "analysts"}, "", ErrAmbiguousAccount},
+		{"session", []string{"other"}, "", nil},
+		{"root", nil, "", nil},
+	} {
+		if got, err := accounts.Resolve(tt.accessKeyID, tt.groups); got != tt.want || err != tt.err {
+			t.Errorf("Expected %s with groups %v in account %q (%v), got %q (%v)", tt.accessKeyID, tt.groups, tt.want, tt.err, got, err)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"shared.json":  `{"accounts":{"team-a":{"accessKeys":["k"]},"team-b":{"accessKeys":["k"]}}}`,
+		"group.json":   `{"accounts":{"team-a":{"groups":["g"]},"team-b":{"groups":["g"]}}}`,
+		"name.json":    `{"accounts":{"../team":{"accessKeys":["k"]}}}`,
+		"invalid.json": `{"accounts":[]}`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadAccounts(path); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// AdminMiddleware is HTTP middleware that only passes requests signed with one of adminKeys to next,
+// guarding the admin API such as the key rotation audit report
+func (a *AWS4Authenticator) AdminMiddleware(adminKeys []string, next http.Handler) http.Handler {
+	return a.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !containsString(adminKeys, AccessKeyIDFromContext(r.Context())) {
+			writeError(w, "AccessDenied", "The access key is not an admin key", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
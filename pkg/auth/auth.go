@@ -5,17 +5,20 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,12 +31,45 @@ type Credentials struct {
 // AWS4Authenticator handles authentication
 type AWS4Authenticator struct {
 	credentials map[string]string // accessKeyID -> secretAccessKey
+	regions     []string          // accepted signing regions, any region if empty
+	hosts       []string          // additional hosts requests may be signed for
+	readOnly    map[string]bool   // access keys limited to reading
+
+	mu       sync.RWMutex
+	sessions map[string]session // accessKeyID -> temporary credentials
+	watched  map[string]string  // accessKeyID -> secretAccessKey loaded from a watched directory
+
+	lockout *Lockout // brute-force protection, disabled if nil
+
+	signatureLog *log.Logger // logs what mismatching signatures were checked against, disabled if nil
+
+	signingKeys signingKeyCache // signing keys derived for the latest signing date
+
+	certificates map[string]string // client certificate subject -> accessKeyID
+
+	strict bool // rejects plain HTTP and unsigned payloads and content headers
+
+	keyUsage *KeyUsage // last use of access keys, untracked if nil
+
+	now       func() time.Time // clock presigned URLs and sessions expire against
+	clockSkew time.Duration    // tolerated clock difference of clients for presigned URLs
+}
+
+// session holds temporary credentials, which are only valid along with their session token
+type session struct {
+	secretAccessKey string
+	sessionToken    string
+	groups          []string
+	expires         time.Time
 }
 
 // NewAWS4Authenticator creates a new authenticator
 func NewAWS4Authenticator() *AWS4Authenticator {
 	return &AWS4Authenticator{
 		credentials: make(map[string]string),
+		readOnly:    make(map[string]bool),
+		sessions:    make(map[string]session),
+		now:         time.Now,
 	}
 }
 
@@ -42,10 +78,231 @@ func (a *AWS4Authenticator) AddCredentials(accessKeyID, secretAccessKey string)
 	a.credentials[accessKeyID] = secretAccessKey
 }
 
+// AddReadOnlyKey limits an access key to requests that cannot change data
+func (a *AWS4Authenticator) AddReadOnlyKey(accessKeyID string) {
+	a.readOnly[accessKeyID] = true
+}
+
+// AddSession adds temporary credentials valid until expires
+// Requests signed with them must carry the session token in the X-Amz-Security-Token header or query parameter
+func (a *AWS4Authenticator) AddSession(accessKeyID, secretAccessKey, sessionToken string, groups []string, expires time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Drop expired sessions so they do not accumulate
+	now := a.now()
+	for id, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, id)
+		}
+	}
+	a.sessions[accessKeyID] = session{
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		groups:          groups,
+		expires:         expires,
+	}
+}
+
+// secretAccessKey returns the secret of accessKeyID, checking the session token of temporary credentials
+func (a *AWS4Authenticator) secretAccessKey(r *http.Request, accessKeyID string) (string, error) {
+	if secret, ok := a.credentials[accessKeyID]; ok {
+		return secret, nil
+	}
+
+	a.mu.RLock()
+	secret, watched := a.watched[accessKeyID]
+	s, ok := a.sessions[accessKeyID]
+	a.mu.RUnlock()
+	if watched {
+		return secret, nil
+	}
+	if !ok {
+		return "", NewAuthError("InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records")
+	}
+
+	token := r.Header.Get("X-Amz-Security-Token")
+	if token == "" {
+		token = r.URL.Query().Get("X-Amz-Security-Token")
+	}
+	if !hmac.Equal([]byte(token), []byte(s.sessionToken)) {
+		return "", NewAuthError("InvalidToken", "The provided token is malformed or otherwise invalid")
+	}
+	if a.now().After(s.expires) {
+		return "", NewAuthError("ExpiredToken", "The provided token has expired")
+	}
+	return s.secretAccessKey, nil
+}
+
+// sessionGroups returns the groups of temporary credentials, or nil for static credentials
+func (a *AWS4Authenticator) sessionGroups(accessKeyID string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.sessions[accessKeyID].groups
+}
+
+// AddRegion adds a region that requests may be signed for
+// Once a region is added, requests signed for other regions are rejected
+func (a *AWS4Authenticator) AddRegion(region string) {
+	a.regions = append(a.regions, region)
+}
+
+// AddHost adds a host that requests may be signed for, in addition to the Host header of the request
+// This allows requests signed for an external hostname to verify behind a proxy that rewrites the Host header,
+// the X-Forwarded-Host header is trusted when it matches an added host or one of its subdomains
+func (a *AWS4Authenticator) AddHost(host string) {
+	a.hosts = append(a.hosts, strings.ToLower(host))
+}
+
+// checkRegion returns an error if the region is not accepted
+func (a *AWS4Authenticator) checkRegion(region, code string) error {
+	if len(a.regions) == 0 {
+		return nil
+	}
+	for _, r := range a.regions {
+		if r == region {
+			return nil
+		}
+	}
+	return NewAuthError(code, fmt.Sprintf("the region '%s' is wrong; expecting '%s'", region, a.regions[0]))
+}
+
+// isAllowedHost reports whether host is an added host or a subdomain of one (virtual-hosted style)
+func (a *AWS4Authenticator) isAllowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range a.hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// signingHosts returns the hosts the request may have been signed for, the Host header first
+func (a *AWS4Authenticator) signingHosts(r *http.Request) []string {
+	hosts := []string{r.Host}
+	if len(a.hosts) == 0 {
+		return hosts
+	}
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && forwarded != r.Host && a.isAllowedHost(forwarded) {
+		hosts = append(hosts, forwarded)
+	}
+	for _, h := range a.hosts {
+		if h != r.Host {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// signatureMismatchCode is the error code of requests whose signature does not match
+const signatureMismatchCode = "XAmzContentSHA256Mismatch"
+
+// verifySignature checks the signature against each host the request may have been signed for
+// sign returns the canonical request and the string to sign of a request, which is signed with signingKey
+func (a *AWS4Authenticator) verifySignature(r *http.Request, accessKeyID, signature string, signingKey []byte, sign func(r *http.Request) (string, string)) error {
+	for i, host := range a.signingHosts(r) {
+		req := r
+		if i > 0 {
+			req = r.Clone(r.Context())
+			req.Host = host
+		}
+		_, stringToSign := sign(req)
+		expectedSignature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+		if hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+			return nil
+		}
+	}
+	if a.signatureLog != nil {
+		canonicalRequest, stringToSign := sign(r)
+		a.logSignatureMismatch(r, accessKeyID, signature, canonicalRequest, stringToSign, signingKey)
+	}
+	return NewAuthError(signatureMismatchCode, "The request signature we calculated does not match the signature you provided")
+}
+
+// accessKeyIDKey is the context key of the authenticated access key ID
+type accessKeyIDKey struct{}
+
+// AccessKeyIDFromContext returns the access key ID the request was authenticated with,
+// or an empty string for anonymous requests
+func AccessKeyIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(accessKeyIDKey{}).(string)
+	return id
+}
+
+// groupsKey is the context key of the groups of the authenticated identity
+type groupsKey struct{}
+
+// GroupsFromContext returns the identity provider groups of the temporary credentials the request
+// was authenticated with, or nil for static credentials and anonymous requests
+func GroupsFromContext(ctx context.Context) []string {
+	groups, _ := ctx.Value(groupsKey{}).([]string)
+	return groups
+}
+
+// runtimeCredentialsKey is the context key marking requests authenticated with runtime credentials
+type runtimeCredentialsKey struct{}
+
+// RuntimeCredentialsFromContext reports whether the request was authenticated with credentials added
+// while the server runs, temporary credentials or those of a watched directory, rather than static ones
+func RuntimeCredentialsFromContext(ctx context.Context) bool {
+	runtime, _ := ctx.Value(runtimeCredentialsKey{}).(bool)
+	return runtime
+}
+
+// withIdentity returns ctx carrying the identity of a request authenticated with accessKeyID
+func (a *AWS4Authenticator) withIdentity(ctx context.Context, accessKeyID string) context.Context {
+	ctx = context.WithValue(ctx, accessKeyIDKey{}, accessKeyID)
+	if groups := a.sessionGroups(accessKeyID); groups != nil {
+		ctx = context.WithValue(ctx, groupsKey{}, groups)
+	}
+	if a.runtimeCredentials(accessKeyID) {
+		ctx = context.WithValue(ctx, runtimeCredentialsKey{}, true)
+	}
+	return ctx
+}
+
+// runtimeCredentials reports whether accessKeyID is the access key of temporary or watched credentials
+func (a *AWS4Authenticator) runtimeCredentials(accessKeyID string) bool {
+	if _, ok := a.credentials[accessKeyID]; ok {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, session := a.sessions[accessKeyID]
+	_, watched := a.watched[accessKeyID]
+	return session || watched
+}
+
 // AuthMiddleware is HTTP middleware for authentication
 func (a *AWS4Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := a.authenticate(r)
+		// Credentials are not looked at unless the request was sent securely
+		if a.rejectsInsecure(r) {
+			metrics.Add("rejected_insecure", 1)
+			writeError(w, "AccessDenied", "Requests must be sent over HTTPS", http.StatusForbidden)
+			return
+		}
+
+		var lockoutKeys []string
+		if a.lockout != nil {
+			lockoutKeys = requestLockoutKeys(r)
+			if wait := a.lockout.retryAfter(lockoutKeys); wait > 0 {
+				metrics.Add("rejected_locked", 1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				writeError(w, "AccessDenied", "Too many failed authentication attempts, try again later", http.StatusForbidden)
+				return
+			}
+		}
+
+		accessKeyID, err := a.authenticate(r)
+		if a.lockout != nil {
+			if err != nil {
+				a.lockout.fail(failedLockoutKeys(lockoutKeys, isSignatureMismatch(err)))
+			} else {
+				a.lockout.succeed(lockoutKeys)
+			}
+		}
 		if err != nil {
 			// Use specific error code if AuthError is returned
 			var authErr *AuthError
@@ -63,6 +320,10 @@ func (a *AWS4Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 				}
 			}
 
+			// Request IDs are assigned by an outer middleware, if any
+			errResp.RequestId = w.Header().Get("x-amz-request-id")
+			errResp.HostId = w.Header().Get("x-amz-id-2")
+
 			w.Header().Set("Content-Type", "application/xml")
 			w.WriteHeader(http.StatusForbidden)
 
@@ -75,6 +336,23 @@ func (a *AWS4Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		a.recordKeyUsage(accessKeyID)
+
+		if a.readOnly[accessKeyID] && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			writeError(w, "AccessDenied", "The access key is read-only", http.StatusForbidden)
+			return
+		}
+
+		r, err = a.verifyPayload(r)
+		if err == ErrContentSHA256Mismatch {
+			writeError(w, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed.", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeError(w, "IncompleteBody", "The request body could not be read.", http.StatusBadRequest)
+			return
+		}
+
 		// Wrap chunked upload requests with signature-validating reader
 		if IsChunkedUpload(r) {
 			wrappedReq, wrapErr := a.WrapChunkedRequest(r)
@@ -93,6 +371,9 @@ func (a *AWS4Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 					}
 				}
 
+				errResp.RequestId = w.Header().Get("x-amz-request-id")
+				errResp.HostId = w.Header().Get("x-amz-id-2")
+
 				w.Header().Set("Content-Type", "application/xml")
 				w.WriteHeader(http.StatusForbidden)
 
@@ -107,7 +388,7 @@ func (a *AWS4Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 			r = wrappedReq
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(a.withIdentity(r.Context(), accessKeyID)))
 	})
 }
 
@@ -128,6 +409,11 @@ func (a *AWS4Authenticator) authenticate(r *http.Request) (string, error) {
 		return "", NewAuthError("InvalidArgument", "Unsupported authorization type")
 	}
 
+	// Signed requests are authenticated by their signature, whatever the certificate of the connection
+	if accessKeyID, ok := a.authenticateCertificate(r); ok {
+		return accessKeyID, nil
+	}
+
 	return "", NewAuthError("AccessDenied", "Missing or invalid authentication information")
 }
 
@@ -163,40 +449,28 @@ func (a *AWS4Authenticator) authenticateV4Query(r *http.Request) (string, error)
 	service := credParts[3]
 
 	// Check if credentials exist
-	secretAccessKey, exists := a.credentials[accessKeyID]
-	if !exists {
-		return "", NewAuthError("InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records")
+	secretAccessKey, err := a.secretAccessKey(r, accessKeyID)
+	if err != nil {
+		return "", err
 	}
 
-	// Validate expiration if provided
-	if expires != "" {
-		// Parse the X-Amz-Date timestamp (format: 20230101T000000Z)
-		requestTime, err := parseAmzDate(date)
-		if err != nil {
-			return "", NewAuthError("InvalidArgument", fmt.Sprintf("Invalid X-Amz-Date format: %v", err))
-		}
-
-		// Parse expires duration (in seconds)
-		expiresSeconds, err := strconv.Atoi(expires)
-		if err != nil {
-			return "", NewAuthError("InvalidArgument", fmt.Sprintf("Invalid X-Amz-Expires value: %v", err))
-		}
+	if err := a.checkRegion(region, "AuthorizationQueryParametersError"); err != nil {
+		return "", err
+	}
 
-		// Check if URL has expired
-		expirationTime := requestTime.Add(time.Duration(expiresSeconds) * time.Second)
-		if time.Now().After(expirationTime) {
-			return "", NewAuthError("AccessDenied", "Presigned URL has expired")
-		}
+	if err := a.checkPresignedTime(date, credDate, expires); err != nil {
+		return "", err
 	}
 
-	// Calculate expected signature
-	expectedSignature, err := a.calculateSignatureV4Query(r, secretAccessKey, credDate, region, service, signedHeaders)
+	// Verify signature
+	err = a.verifySignatureCached(r, accessKeyID, secretAccessKey, signature, credDate, region, service, func(r *http.Request) (string, string) {
+		return a.stringToSignV4Query(r, credDate, region, service, signedHeaders)
+	})
 	if err != nil {
 		return "", err
 	}
-
-	if signature != expectedSignature {
-		return "", NewAuthError("XAmzContentSHA256Mismatch", "The request signature we calculated does not match the signature you provided")
+	if err := a.checkSignedPayload(r, signedHeaders, true); err != nil {
+		return "", err
 	}
 
 	return accessKeyID, nil
@@ -239,19 +513,24 @@ func (a *AWS4Authenticator) authenticateV4Header(r *http.Request, authHeader str
 	service := credParts[3]
 
 	// Check if credentials exist
-	secretAccessKey, exists := a.credentials[accessKeyID]
-	if !exists {
-		return "", NewAuthError("InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records")
+	secretAccessKey, err := a.secretAccessKey(r, accessKeyID)
+	if err != nil {
+		return "", err
 	}
 
-	// Calculate expected signature
-	expectedSignature, err := a.calculateSignatureV4Header(r, secretAccessKey, date, region, service, signedHeaders)
-	if err != nil {
+	if err := a.checkRegion(region, "AuthorizationHeaderMalformed"); err != nil {
 		return "", err
 	}
 
-	if signature != expectedSignature {
-		return "", NewAuthError("XAmzContentSHA256Mismatch", "The request signature we calculated does not match the signature you provided")
+	// Verify signature
+	err = a.verifySignatureCached(r, accessKeyID, secretAccessKey, signature, date, region, service, func(r *http.Request) (string, string) {
+		return a.stringToSignV4Header(r, date, region, service, signedHeaders)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := a.checkSignedPayload(r, signedHeaders, false); err != nil {
+		return "", err
 	}
 
 	return accessKeyID, nil
@@ -259,61 +538,44 @@ func (a *AWS4Authenticator) authenticateV4Header(r *http.Request, authHeader str
 
 // calculateSignatureV4Query calculates AWS Signature Version 4 for query string authentication
 func (a *AWS4Authenticator) calculateSignatureV4Query(r *http.Request, secretAccessKey, date, region, service, signedHeaders string) (string, error) {
-	// Step 1: Create canonical request (excluding signature from query string)
-	canonicalRequest := a.createCanonicalRequestQuery(r, signedHeaders)
-
-	// Step 2: Create string to sign
-	algorithm := "AWS4-HMAC-SHA256"
-	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
-
-	hashedCanonicalRequest := sha256Hash(canonicalRequest)
-
-	timestamp := r.URL.Query().Get("X-Amz-Date")
-
-	stringToSign := strings.Join([]string{
-		algorithm,
-		timestamp,
-		credentialScope,
-		hashedCanonicalRequest,
-	}, "\n")
-
-	// Step 3: Calculate signature
+	_, stringToSign := a.stringToSignV4Query(r, date, region, service, signedHeaders)
 	signingKey := CalculateSigningKey(secretAccessKey, date, region, service)
-
-	signature := hmacSHA256(signingKey, []byte(stringToSign))
-
-	return hex.EncodeToString(signature), nil
+	return hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign))), nil
 }
 
 // calculateSignatureV4Header calculates AWS Signature Version 4
 func (a *AWS4Authenticator) calculateSignatureV4Header(r *http.Request, secretAccessKey, date, region, service, signedHeaders string) (string, error) {
-	// Step 1: Create canonical request
-	canonicalRequest := a.createCanonicalRequestHeader(r, signedHeaders)
-
-	// Step 2: Create string to sign
-	algorithm := "AWS4-HMAC-SHA256"
-	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	_, stringToSign := a.stringToSignV4Header(r, date, region, service, signedHeaders)
+	signingKey := CalculateSigningKey(secretAccessKey, date, region, service)
+	return hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign))), nil
+}
 
-	hashedCanonicalRequest := sha256Hash(canonicalRequest)
+// stringToSignV4Query returns the canonical request and the string to sign for query string authentication
+func (a *AWS4Authenticator) stringToSignV4Query(r *http.Request, date, region, service, signedHeaders string) (string, string) {
+	// Create canonical request (excluding signature from query string)
+	canonicalRequest := a.createCanonicalRequestQuery(r, signedHeaders)
+	return canonicalRequest, stringToSignV4(r.URL.Query().Get("X-Amz-Date"), date, region, service, canonicalRequest)
+}
 
+// stringToSignV4Header returns the canonical request and the string to sign for header authentication
+func (a *AWS4Authenticator) stringToSignV4Header(r *http.Request, date, region, service, signedHeaders string) (string, string) {
+	canonicalRequest := a.createCanonicalRequestHeader(r, signedHeaders)
 	timestamp := r.Header.Get("X-Amz-Date")
 	if timestamp == "" {
 		timestamp = r.Header.Get("Date")
 	}
+	return canonicalRequest, stringToSignV4(timestamp, date, region, service, canonicalRequest)
+}
 
-	stringToSign := strings.Join([]string{
-		algorithm,
+// stringToSignV4 returns the string to sign of a canonical request signed at timestamp
+func stringToSignV4(timestamp, date, region, service, canonicalRequest string) string {
+	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	return strings.Join([]string{
+		"AWS4-HMAC-SHA256",
 		timestamp,
 		credentialScope,
-		hashedCanonicalRequest,
+		sha256Hash(canonicalRequest),
 	}, "\n")
-
-	// Step 3: Calculate signature
-	signingKey := CalculateSigningKey(secretAccessKey, date, region, service)
-
-	signature := hmacSHA256(signingKey, []byte(stringToSign))
-
-	return hex.EncodeToString(signature), nil
 }
 
 // createCanonicalRequestQuery creates a canonical request for AWS Signature V4 query string authentication
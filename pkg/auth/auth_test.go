@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -372,6 +374,7 @@ func TestAuthenticateV4QueryInvalidAccessKey(t *testing.T) {
 func TestAuthenticateV4QueryValid(t *testing.T) {
 	auth := NewAWS4Authenticator()
 	auth.AddCredentials("test-key", "test-secret")
+	auth.SetClock(func() time.Time { return time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC) })
 
 	req := httptest.NewRequest("GET", "/bucket/object", nil)
 	req.Host = "example.amazonaws.com"
@@ -380,6 +383,7 @@ func TestAuthenticateV4QueryValid(t *testing.T) {
 	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
 	q.Set("X-Amz-Credential", "test-key/20230101/us-east-1/s3/aws4_request")
 	q.Set("X-Amz-Date", "20230101T000000Z")
+	q.Set("X-Amz-Expires", "3600")
 	q.Set("X-Amz-SignedHeaders", "host")
 	req.URL.RawQuery = q.Encode()
 
@@ -508,3 +512,193 @@ func TestCreateCanonicalRequestQuery(t *testing.T) {
 		t.Fatal("Canonical request for query auth should contain UNSIGNED-PAYLOAD")
 	}
 }
+
+// signRequestForHost signs req with the Authorization header as if it was sent to host
+func signRequestForHost(t *testing.T, auth *AWS4Authenticator, req *http.Request, host, region string) {
+	t.Helper()
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	req.Header.Set("X-Amz-Date", "20230101T000000Z")
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	signed := req.Clone(req.Context())
+	signed.Host = host
+	signature, err := auth.calculateSignatureV4Header(signed, "test-secret", "20230101", region, "s3", signedHeaders)
+	if err != nil {
+		t.Fatalf("Failed to calculate signature: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=test-key/20230101/%s/s3/aws4_request, SignedHeaders=%s, Signature=%s", region, signedHeaders, signature))
+}
+
+func TestAuthenticateRegions(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+
+	// Any region is accepted until regions are added
+	req := httptest.NewRequest("GET", "/bucket/object", nil)
+	signRequestForHost(t, auth, req, req.Host, "eu-west-1")
+	if _, err := auth.authenticate(req); err != nil {
+		t.Fatalf("Expected any region to be accepted: %v", err)
+	}
+
+	auth.AddRegion("us-east-1")
+	auth.AddRegion("us-west-2")
+
+	tests := []struct {
+		region    string
+		expectErr bool
+	}{
+		{"us-east-1", false},
+		{"us-west-2", false},
+		{"eu-west-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/bucket/object", nil)
+			signRequestForHost(t, auth, req, req.Host, tt.region)
+
+			_, err := auth.authenticate(req)
+			if tt.expectErr {
+				var authErr *AuthError
+				if !errors.As(err, &authErr) || authErr.Code != "AuthorizationHeaderMalformed" {
+					t.Fatalf("Expected AuthorizationHeaderMalformed, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected region %s to be accepted: %v", tt.region, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateHosts(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddHost("s3.example.com")
+
+	tests := []struct {
+		name          string
+		signedHost    string
+		forwardedHost string
+		expectErr     bool
+	}{
+		{name: "RequestHost", signedHost: "backend:8080"},
+		{name: "AllowedHost", signedHost: "s3.example.com"},
+		{name: "ForwardedVirtualHost", signedHost: "bucket.s3.example.com", forwardedHost: "bucket.s3.example.com"},
+		{name: "ForwardedUnknownHost", signedHost: "evil.example.org", forwardedHost: "evil.example.org", expectErr: true},
+		{name: "UnknownHost", signedHost: "other.example.com", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/bucket/object", nil)
+			req.Host = "backend:8080"
+			if tt.forwardedHost != "" {
+				req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+			}
+			signRequestForHost(t, auth, req, tt.signedHost, "us-east-1")
+
+			_, err := auth.authenticate(req)
+			if tt.expectErr && err == nil {
+				t.Fatal("Expected authentication to fail")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("Expected authentication to succeed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAccessKeyIDFromContext(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+
+	var accessKeyID string
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKeyID = AccessKeyIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/bucket/object", nil)
+	signRequestForHost(t, auth, req, req.Host, "us-east-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected request to be authenticated, got status %d", rec.Code)
+	}
+	if accessKeyID != "test-key" {
+		t.Errorf("Expected access key ID test-key, got %q", accessKeyID)
+	}
+}
+
+func TestReadOnlyKey(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddReadOnlyKey("test-key")
+
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodHead:   http.StatusOK,
+		http.MethodPut:    http.StatusForbidden,
+		http.MethodPost:   http.StatusForbidden,
+		http.MethodDelete: http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(method, "/bucket/object", nil)
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", method, want, rec.Code)
+		}
+	}
+}
+
+func FuzzAuthenticateHeader(f *testing.F) {
+	for _, seed := range []string{
+		"AWS4-HMAC-SHA256 Credential=valid-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=b06c3241e27bbe06de4271ac6617cd88056188eb970ce7123f5aa7ce5e5b05bf",
+		"AWS4-HMAC-SHA256 Credential=valid-key, SignedHeaders=, Signature=",
+		"AWS4-HMAC-SHA256 Credential=valid-key/////, SignedHeaders=;;;, Signature=x",
+		"AWS AKIA:signature",
+		"AWS4-HMAC-SHA256 ",
+	} {
+		f.Add(seed, "20230101T000000Z")
+	}
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("valid-key", "valid-secret")
+	auth.AddRegion("us-east-1")
+
+	f.Fuzz(func(t *testing.T, authorization, date string) {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		req.Host = "example.amazonaws.com"
+		req.Header.Set("X-Amz-Date", date)
+		req.Header.Set("Authorization", authorization)
+		if accessKeyID, err := auth.authenticate(req); err == nil && accessKeyID != "valid-key" {
+			t.Fatalf("Authenticated unknown access key %q", accessKeyID)
+		}
+	})
+}
+
+func FuzzAuthenticateQuery(f *testing.F) {
+	for _, seed := range []string{
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=valid-key%2F20230101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20230101T000000Z&X-Amz-Expires=3600&X-Amz-SignedHeaders=host&X-Amz-Signature=abc",
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=%2F%2F%2F%2F&X-Amz-Expires=-1",
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Expires=99999999999999999999",
+		"X-Amz-Algorithm=x",
+	} {
+		f.Add(seed)
+	}
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("valid-key", "valid-secret")
+
+	f.Fuzz(func(t *testing.T, query string) {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		req.URL.RawQuery = query
+		req.Host = "example.amazonaws.com"
+		if accessKeyID, err := auth.authenticate(req); err == nil && accessKeyID != "valid-key" {
+			t.Fatalf("Authenticated unknown access key %q", accessKeyID)
+		}
+	})
+}
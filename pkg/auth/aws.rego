@@ -0,0 +1,103 @@
+# Package s3d.aws evaluates the Condition elements of AWS bucket policies against the condition keys
+# of the request in input.conditions, so that statements can be carried over from bucket policies:
+#
+#	import data.s3d.aws
+#
+#	allow if {
+#		input.bucket == "assets"
+#		aws.condition({"StringLike": {"aws:Referer": ["https://example.com/*"]}})
+#	}
+#
+# The operators are StringEquals, StringNotEquals, StringLike, StringNotLike, IpAddress and NotIpAddress,
+# with or without the IfExists suffix, and Null. Like in AWS, a key absent from the request fails the
+# positive operators and passes the negated ones, and conditions using any other operator never hold.
+package s3d.aws
+
+# condition is true if every operator of block holds for every key it lists
+# The values of a key are either a single value or a list of which any may match
+condition(block) if {
+	is_object(block)
+	every operator, keys in block {
+		is_object(keys)
+		every key, values in keys {
+			holds(operator, key, as_list(values))
+		}
+	}
+}
+
+as_list(values) := values if is_array(values)
+
+as_list(values) := [values] if not is_array(values)
+
+present(key) if _ = input.conditions[key]
+
+# holds is true if operator holds for the values of key
+holds(operator, key, _) if {
+	endswith(operator, "IfExists")
+	supported(trim_suffix(operator, "IfExists"))
+	not present(key)
+}
+
+holds(operator, key, values) if {
+	operator_ := trim_suffix(operator, "IfExists")
+	supported(operator_)
+	not negation(operator_)
+	matches(operator_, input.conditions[key], values)
+}
+
+holds(operator, key, values) if {
+	positive := negation(trim_suffix(operator, "IfExists"))
+	not matches(positive, input.conditions[key], values)
+}
+
+holds("Null", key, values) if {
+	some value in values
+	lower(sprintf("%v", [value])) == "true"
+	not present(key)
+}
+
+holds("Null", key, values) if {
+	some value in values
+	lower(sprintf("%v", [value])) == "false"
+	present(key)
+}
+
+supported("StringEquals")
+
+supported("StringLike")
+
+supported("IpAddress")
+
+supported(operator) if negation(operator)
+
+negation("StringNotEquals") := "StringEquals"
+
+negation("StringNotLike") := "StringLike"
+
+negation("NotIpAddress") := "IpAddress"
+
+# matches is true if value matches any of values with the positive operator
+matches("StringEquals", value, values) if value in values
+
+# Like in AWS, "*" matches any sequence of characters, including "/", and "?" any single character
+matches("StringLike", value, values) if {
+	some pattern in values
+	glob.match(pattern, null, value)
+}
+
+matches("IpAddress", value, values) if {
+	some network in values
+	contains(network, "/")
+	net.cidr_contains(network, value)
+}
+
+# A single address is its own network
+matches("IpAddress", value, values) if {
+	some network in values
+	not contains(network, "/")
+	net.cidr_contains(sprintf("%s/%d", [network, address_bits(network)]), value)
+}
+
+address_bits(address) := 128 if contains(address, ":")
+
+address_bits(address) := 32 if not contains(address, ":")
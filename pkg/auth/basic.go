@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+)
+
+// BasicAuthMiddleware is HTTP middleware authenticating requests with HTTP Basic credentials,
+// the access key ID as user name and the secret access key as password, for clients such as
+// WebDAV ones that cannot sign requests
+// Temporary credentials are not accepted, as Basic credentials have no room for the session token,
+// and the secret is sent as is, so the middleware must only be served over TLS
+func (a *AWS4Authenticator) BasicAuthMiddleware(realm string, next http.Handler) http.Handler {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.rejectsInsecure(r) {
+			metrics.Add("rejected_insecure", 1)
+			http.Error(w, "Requests must be sent over HTTPS", http.StatusForbidden)
+			return
+		}
+
+		var lockoutKeys []string
+		if a.lockout != nil {
+			lockoutKeys = requestLockoutKeys(r)
+			if wait := a.lockout.retryAfter(lockoutKeys); wait > 0 {
+				metrics.Add("rejected_locked", 1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "Too many failed authentication attempts, try again later", http.StatusForbidden)
+				return
+			}
+		}
+
+		accessKeyID, secretAccessKey, ok := r.BasicAuth()
+		if !ok {
+			// Clients send credentials once challenged, which is not a failed attempt
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ok = a.checkSecret(accessKeyID, secretAccessKey)
+		if a.lockout != nil {
+			if !ok {
+				_, exists := a.staticSecret(accessKeyID)
+				a.lockout.fail(failedLockoutKeys(lockoutKeys, exists))
+			} else {
+				a.lockout.succeed(lockoutKeys)
+			}
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		a.recordKeyUsage(accessKeyID)
+
+		// PROPFIND lists properties, the only WebDAV method that reads besides the HTTP ones
+		if a.readOnly[accessKeyID] && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions && r.Method != "PROPFIND" {
+			http.Error(w, "The access key is read-only", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(a.withIdentity(r.Context(), accessKeyID)))
+	})
+}
+
+// checkSecret reports whether secretAccessKey is the secret of the static or watched credentials of accessKeyID
+func (a *AWS4Authenticator) checkSecret(accessKeyID, secretAccessKey string) bool {
+	secret, ok := a.staticSecret(accessKeyID)
+	return ok && subtle.ConstantTimeCompare([]byte(secret), []byte(secretAccessKey)) == 1
+}
+
+// staticSecret returns the secret of the static or watched credentials of accessKeyID, if it has any
+func (a *AWS4Authenticator) staticSecret(accessKeyID string) (string, bool) {
+	if secret, ok := a.credentials[accessKeyID]; ok {
+		return secret, true
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	secret, ok := a.watched[accessKeyID]
+	return secret, ok
+}
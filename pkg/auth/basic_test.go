@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddCredentials("reader-key", "reader-secret")
+	auth.AddReadOnlyKey("reader-key")
+	auth.AddSession("session-key", "session-secret", "token", nil, time.Now().Add(time.Hour))
+
+	var accessKeyID string
+	handler := auth.BasicAuthMiddleware("s3d", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKeyID = AccessKeyIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		password string
+		status   int
+	}{
+		{"NoCredentials", "PROPFIND", "", "", http.StatusUnauthorized},
+		{"Valid", http.MethodPut, "test-key", "test-secret", http.StatusOK},
+		{"WrongSecret", http.MethodGet, "test-key", "wrong", http.StatusUnauthorized},
+		{"UnknownKey", http.MethodGet, "unknown", "test-secret", http.StatusUnauthorized},
+		{"Session", http.MethodGet, "session-key", "session-secret", http.StatusUnauthorized},
+		{"ReadOnlyList", "PROPFIND", "reader-key", "reader-secret", http.StatusOK},
+		{"ReadOnlyWrite", "MKCOL", "reader-key", "reader-secret", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessKeyID = ""
+			req := httptest.NewRequest(tt.method, "/bucket/object", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="s3d", charset="UTF-8"` {
+				t.Errorf("Expected a Basic challenge, got %q", rec.Header().Get("WWW-Authenticate"))
+			}
+			if tt.status == http.StatusOK && accessKeyID != tt.user {
+				t.Errorf("Expected access key %q in the context, got %q", tt.user, accessKeyID)
+			}
+		})
+	}
+}
+
+func TestBasicAuthMiddlewareLockout(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.SetLockout(NewLockout(2, time.Minute, time.Hour))
+	handler := auth.BasicAuthMiddleware("s3d", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/bucket/object", nil)
+		req.SetBasicAuth("test-key", password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for range 2 {
+		if code := request("wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("Expected a wrong secret to be rejected, got %d", code)
+		}
+	}
+	if code := request("test-secret"); code != http.StatusForbidden {
+		t.Errorf("Expected the access key to be locked out, got %d", code)
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// LoadCertificateIdentities reads a JSON object mapping the subjects of client certificates
+// to the access keys requests presenting them are authenticated as
+func LoadCertificateIdentities(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var identities map[string]string
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("invalid certificate identities %s: %w", path, err)
+	}
+	for subject, accessKeyID := range identities {
+		if subject == "" || accessKeyID == "" {
+			return nil, fmt.Errorf("invalid certificate identities %s: empty subject or access key", path)
+		}
+	}
+	return identities, nil
+}
+
+// AddCertificateIdentity authenticates requests without a signature presenting a verified client
+// certificate of subject as accessKeyID, for zero-trust deployments issuing certificates to workloads
+// The subject is either the distinguished name of the certificate, such as "CN=reporter,O=analytics",
+// or one of its URI names, such as the SPIFFE ID "spiffe://example.org/ns/analytics/sa/reporter"
+func (a *AWS4Authenticator) AddCertificateIdentity(subject, accessKeyID string) {
+	if a.certificates == nil {
+		a.certificates = map[string]string{}
+	}
+	a.certificates[subject] = accessKeyID
+}
+
+// authenticateCertificate returns the access key of the verified client certificate of r
+func (a *AWS4Authenticator) authenticateCertificate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if accessKeyID, ok := a.certificates[cert.Subject.String()]; ok {
+		return accessKeyID, true
+	}
+	for _, uri := range cert.URIs {
+		if accessKeyID, ok := a.certificates[uri.String()]; ok {
+			return accessKeyID, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCertificateIdentities(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"CN=reporter,O=analytics":"reporter-key","spiffe://example.org/ns/analytics/sa/loader":"loader-key"}`), 0644)
+	identities, err := LoadCertificateIdentities(valid)
+	if err != nil {
+		t.Fatalf("Failed to load certificate identities: %v", err)
+	}
+	if identities["CN=reporter,O=analytics"] != "reporter-key" || len(identities) != 2 {
+		t.Errorf("Expected the identities of the file, got %v", identities)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"CN=reporter":""}`), 0644)
+	if _, err := LoadCertificateIdentities(invalid); err == nil {
+		t.Error("Expected an error for an empty access key")
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddCertificateIdentity("CN=reporter,O=analytics", "reporter-key")
+	auth.AddCertificateIdentity("spiffe://example.org/ns/analytics/sa/loader", "loader-key")
+
+	var accessKeyID string
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKeyID = AccessKeyIDFromContext(r.Context())
+	}))
+	serve := func(req *http.Request) int {
+		accessKeyID = ""
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	withCertificate := func(req *http.Request, cert *x509.Certificate, verified bool) *http.Request {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return req
+	}
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/analytics/sa/loader")
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		verified bool
+		want     string
+	}{
+		{"Subject", &x509.Certificate{Subject: pkix.Name{CommonName: "reporter", Organization: []string{"analytics"}}}, true, "reporter-key"},
+		{"URI", &x509.Certificate{Subject: pkix.Name{CommonName: "loader"}, URIs: []*url.URL{spiffeID}}, true, "loader-key"},
+		{"Unmapped", &x509.Certificate{Subject: pkix.Name{CommonName: "other"}}, true, ""},
+		{"Unverified", &x509.Certificate{Subject: pkix.Name{CommonName: "reporter", Organization: []string{"analytics"}}}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := serve(withCertificate(httptest.NewRequest(http.MethodGet, "/bucket/object", nil), tt.cert, tt.verified))
+			if tt.want == "" {
+				if code != http.StatusForbidden {
+					t.Errorf("Expected the request to be rejected, got %d", code)
+				}
+				return
+			}
+			if code != http.StatusOK || accessKeyID != tt.want {
+				t.Errorf("Expected the request to authenticate as %s, got %d as %q", tt.want, code, accessKeyID)
+			}
+		})
+	}
+
+	// A signature takes precedence over the certificate of the connection
+	req := httptest.NewRequest(http.MethodGet, "/bucket/object", nil)
+	signRequestForHost(t, auth, req, req.Host, "us-east-1")
+	withCertificate(req, tests[0].cert, true)
+	if code := serve(req); code != http.StatusOK || accessKeyID != "test-key" {
+		t.Errorf("Expected the signed request to authenticate as test-key, got %d as %q", code, accessKeyID)
+	}
+	req.Header.Set("X-Amz-Date", "20230102T000000Z")
+	if code := serve(req); code != http.StatusForbidden {
+		t.Errorf("Expected a request with a bad signature to be rejected despite its certificate, got %d", code)
+	}
+}
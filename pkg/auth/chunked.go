@@ -12,13 +12,17 @@ package auth
 import (
 	"bufio"
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // streamingPayloadHash is the payload hash value for streaming uploads
@@ -39,18 +43,32 @@ var ErrInvalidChunkFormat = errors.New("invalid chunk format")
 // ErrChunkSignatureMismatch is returned when a chunk signature doesn't match
 var ErrChunkSignatureMismatch = errors.New("chunk signature mismatch")
 
+// chunkedBufferSize is the size of the buffered readers of chunked uploads
+// Chunk headers must fit in it, reads of chunk data larger than it bypass it
+const chunkedBufferSize = 4 << 10
+
+// chunkedReaderPool holds the buffered readers of chunked uploads between requests
+var chunkedReaderPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReaderSize(nil, chunkedBufferSize)
+	},
+}
+
 // ChunkedReader reads and validates AWS SigV4 chunked encoded data.
-// It verifies each chunk's signature as it reads.
+// Chunk data is hashed as it is read and each signature is verified at the end of its chunk,
+// so the data of a chunk is returned before its signature is known to match.
+// Consumers must read to io.EOF and discard what they read if an error is returned.
 type ChunkedReader struct {
-	reader        *bufio.Reader
+	body          io.Reader
+	reader        *bufio.Reader // taken from chunkedReaderPool on the first read, returned once done
+	hash          hash.Hash     // SHA256 of the data of the current chunk read so far
 	signingKey    []byte
 	credScope     string
 	timestamp     string
 	prevSignature string
-	chunkData     []byte
-	chunkOffset   int
-	eof           bool
-	err           error
+	signature     string // signature of the current chunk
+	remaining     int64  // bytes of the current chunk not read yet
+	err           error  // sticky, io.EOF once the final chunk is verified
 }
 
 // NewChunkedReader creates a new ChunkedReader for validating chunked uploads.
@@ -61,7 +79,8 @@ type ChunkedReader struct {
 // - seedSignature: the signature from the Authorization header
 func NewChunkedReader(r io.Reader, signingKey []byte, credScope, timestamp, seedSignature string) io.Reader {
 	return &ChunkedReader{
-		reader:        bufio.NewReader(r),
+		body:          r,
+		hash:          sha256.New(),
 		signingKey:    signingKey,
 		credScope:     credScope,
 		timestamp:     timestamp,
@@ -74,50 +93,75 @@ func (c *ChunkedReader) Read(p []byte) (int, error) {
 	if c.err != nil {
 		return 0, c.err
 	}
-	if c.eof {
-		return 0, io.EOF
+	if c.reader == nil {
+		c.reader = chunkedReaderPool.Get().(*bufio.Reader)
+		c.reader.Reset(c.body)
 	}
 
-	// If we have remaining data from the current chunk, return it
-	if c.chunkOffset < len(c.chunkData) {
-		n := copy(p, c.chunkData[c.chunkOffset:])
-		c.chunkOffset += n
-		return n, nil
+	if c.remaining == 0 {
+		if err := c.readChunkHeader(); err != nil {
+			return 0, c.fail(err)
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Read straight into p, never past the end of the chunk
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
 	}
+	n, err := c.reader.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
 
-	// Read the next chunk
-	if err := c.readNextChunk(); err != nil {
-		c.err = err
+	if c.remaining == 0 {
+		if err := c.finishChunk(); err != nil {
+			return n, c.fail(err)
+		}
+		return n, nil
+	}
+	if err != nil {
 		if errors.Is(err, io.EOF) {
-			c.eof = true
-			return 0, io.EOF
+			err = io.ErrUnexpectedEOF
 		}
-		return 0, err
+		return n, c.fail(fmt.Errorf("failed to read chunk data: %w", err))
 	}
-
-	// Return data from the new chunk
-	n := copy(p, c.chunkData[c.chunkOffset:])
-	c.chunkOffset += n
 	return n, nil
 }
 
-// readNextChunk reads and validates the next chunk from the stream
-func (c *ChunkedReader) readNextChunk() error {
+// fail makes err sticky and returns the buffered reader to the pool
+func (c *ChunkedReader) fail(err error) error {
+	c.err = err
+	if c.reader != nil {
+		c.reader.Reset(nil)
+		chunkedReaderPool.Put(c.reader)
+		c.reader = nil
+	}
+	return err
+}
+
+// readChunkHeader reads the header of the next chunk, verifying the final chunk
+// and returning io.EOF once it is reached
+func (c *ChunkedReader) readChunkHeader() error {
 	// Read the chunk header line: hex-size;chunk-signature=signature
-	headerLine, err := c.reader.ReadString('\n')
+	line, err := c.reader.ReadSlice('\n')
 	if err != nil {
-		if errors.Is(err, io.EOF) && headerLine == "" {
+		if errors.Is(err, io.EOF) && len(line) == 0 {
 			return io.EOF
 		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return ErrInvalidChunkFormat
+		}
 		return fmt.Errorf("failed to read chunk header: %w", err)
 	}
 
 	// Remove trailing \r\n
-	headerLine = strings.TrimSuffix(headerLine, "\n")
-	headerLine = strings.TrimSuffix(headerLine, "\r")
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
 
 	// Parse chunk header
-	chunkSize, signature, err := parseChunkHeader(headerLine)
+	chunkSize, signature, err := parseChunkHeader(string(line))
 	if err != nil {
 		return err
 	}
@@ -125,46 +169,47 @@ func (c *ChunkedReader) readNextChunk() error {
 	// A chunk size of 0 indicates the final chunk
 	if chunkSize == 0 {
 		// Validate final chunk signature
-		expectedSig := c.calculateChunkSignature(nil)
-		if signature != expectedSig {
-			return fmt.Errorf("%w: expected %s, got %s", ErrChunkSignatureMismatch, expectedSig, signature)
+		if !hmac.Equal([]byte(signature), []byte(c.chunkSignature(emptyStringSHA256))) {
+			return ErrChunkSignatureMismatch
 		}
 		c.prevSignature = signature
 		return io.EOF
 	}
 
-	// Read the chunk data
-	chunkData := make([]byte, chunkSize)
-	_, err = io.ReadFull(c.reader, chunkData)
-	if err != nil {
-		return fmt.Errorf("failed to read chunk data: %w", err)
-	}
+	c.signature = signature
+	c.remaining = chunkSize
+	c.hash.Reset()
+	return nil
+}
 
+// finishChunk reads the trailer of the current chunk once all its data is read and verifies its signature
+func (c *ChunkedReader) finishChunk() error {
 	// Read the trailing \r\n after chunk data
-	trailer := make([]byte, 2)
-	_, err = io.ReadFull(c.reader, trailer)
+	trailer, err := c.reader.Peek(2)
 	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
 		return fmt.Errorf("failed to read chunk trailer: %w", err)
 	}
-	if !bytes.Equal(trailer, []byte("\r\n")) {
+	if trailer[0] != '\r' || trailer[1] != '\n' {
 		return ErrInvalidChunkFormat
 	}
+	c.reader.Discard(2)
 
 	// Validate chunk signature
-	expectedSig := c.calculateChunkSignature(chunkData)
-	if signature != expectedSig {
-		return fmt.Errorf("%w: expected %s, got %s", ErrChunkSignatureMismatch, expectedSig, signature)
+	var sum [sha256.Size]byte
+	chunkHash := hex.EncodeToString(c.hash.Sum(sum[:0]))
+	if !hmac.Equal([]byte(c.signature), []byte(c.chunkSignature(chunkHash))) {
+		return ErrChunkSignatureMismatch
 	}
 
 	// Update state for next chunk
-	c.prevSignature = signature
-	c.chunkData = chunkData
-	c.chunkOffset = 0
-
+	c.prevSignature = c.signature
 	return nil
 }
 
-// calculateChunkSignature calculates the signature for a chunk
+// chunkSignature calculates the signature for a chunk from the hex SHA256 of its data
 // According to AWS docs, the string to sign for chunk signatures is:
 // AWS4-HMAC-SHA256-PAYLOAD
 // timestamp
@@ -172,14 +217,7 @@ func (c *ChunkedReader) readNextChunk() error {
 // previous_signature
 // hash(empty_string) for chunk-extensions (we don't use extensions)
 // hash(current_chunk_data)
-func (c *ChunkedReader) calculateChunkSignature(chunkData []byte) string {
-	var chunkHash string
-	if len(chunkData) == 0 {
-		chunkHash = emptyStringSHA256
-	} else {
-		chunkHash = sha256Hash(string(chunkData))
-	}
-
+func (c *ChunkedReader) chunkSignature(chunkHash string) string {
 	stringToSign := strings.Join([]string{
 		"AWS4-HMAC-SHA256-PAYLOAD",
 		c.timestamp,
@@ -206,6 +244,9 @@ func parseChunkHeader(header string) (size int64, signature string, err error) {
 	if err != nil {
 		return 0, "", fmt.Errorf("invalid chunk size: %w", err)
 	}
+	if size < 0 {
+		return 0, "", ErrInvalidChunkFormat
+	}
 
 	// Parse signature
 	sigPart := strings.TrimSpace(parts[1])
@@ -283,9 +324,9 @@ func (a *AWS4Authenticator) WrapChunkedRequest(r *http.Request) (*http.Request,
 	credScope := strings.Join(credParts[1:], "/")
 
 	// Get secret key
-	secretAccessKey, exists := a.credentials[accessKeyID]
-	if !exists {
-		return nil, NewAuthError("InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records")
+	secretAccessKey, err := a.secretAccessKey(r, accessKeyID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get timestamp
@@ -295,7 +336,7 @@ func (a *AWS4Authenticator) WrapChunkedRequest(r *http.Request) (*http.Request,
 	}
 
 	// Calculate signing key
-	signingKey := CalculateSigningKey(secretAccessKey, date, region, service)
+	signingKey, _ := a.signingKeys.get(accessKeyID, secretAccessKey, date, region, service)
 
 	// Create chunked reader
 	chunkedReader := NewChunkedReader(r.Body, signingKey, credScope, timestamp, seedSignature)
@@ -3,11 +3,13 @@ package auth
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseChunkHeader(t *testing.T) {
@@ -578,3 +580,126 @@ func TestAuthMiddlewareChunkedUpload(t *testing.T) {
 		t.Errorf("expected body %q, got %q", string(testData), string(receivedBody))
 	}
 }
+
+func FuzzParseChunkHeader(f *testing.F) {
+	for _, seed := range []string{
+		"10000;chunk-signature=ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648",
+		"0;chunk-signature=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"-1;chunk-signature=abc",
+		"7fffffffffffffff;chunk-signature=abc",
+		";",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		size, _, err := parseChunkHeader(header)
+		if err == nil && size < 0 {
+			t.Fatalf("parseChunkHeader(%q) accepted negative size %d", header, size)
+		}
+	})
+}
+
+func FuzzChunkedReader(f *testing.F) {
+	signingKey := CalculateSigningKey("test-secret", "20230101", "us-east-1", "s3")
+	credScope := "20230101/us-east-1/s3/aws4_request"
+	timestamp := "20230101T000000Z"
+
+	for _, seed := range []string{
+		"d;chunk-signature=abc\r\nHello, World!\r\n0;chunk-signature=def\r\n",
+		"7fffffffffffffff;chunk-signature=abc\r\n",
+		"-10;chunk-signature=abc\r\n",
+		"5;chunk-signature=abc\r\nab",
+		"",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		reader := NewChunkedReader(bytes.NewReader(body), signingKey, credScope, timestamp, "seed-signature")
+		data, err := io.ReadAll(reader)
+		if err == nil && len(data) > len(body) {
+			t.Fatalf("Read %d bytes out of a %d bytes body", len(data), len(body))
+		}
+	})
+}
+
+func TestChunkedReaderStreaming(t *testing.T) {
+	signingKey := CalculateSigningKey("test-secret", "20230101", "us-east-1", "s3")
+	credScope := "20230101/us-east-1/s3/aws4_request"
+	timestamp := "20230101T000000Z"
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	body := signedChunkedBody(data, 64<<10, signingKey, credScope, timestamp, "seed-signature")
+
+	t.Run("one byte reads", func(t *testing.T) {
+		reader := NewChunkedReader(iotest.OneByteReader(bytes.NewReader(body)), signingKey, credScope, timestamp, "seed-signature")
+		got, err := io.ReadAll(iotest.OneByteReader(reader))
+		if err != nil {
+			t.Fatalf("Failed to read chunked body: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Expected %d bytes of data, got %d", len(data), len(got))
+		}
+	})
+
+	t.Run("tampered second chunk", func(t *testing.T) {
+		tampered := bytes.Clone(body)
+		tampered[len(tampered)-100] ^= 1
+		reader := NewChunkedReader(bytes.NewReader(tampered), signingKey, credScope, timestamp, "seed-signature")
+		got, err := io.ReadAll(reader)
+		if err != ErrChunkSignatureMismatch {
+			t.Fatalf("Expected ErrChunkSignatureMismatch, got %v", err)
+		}
+		if len(got) != len(data) {
+			t.Errorf("Expected the mismatch at the end of the tampered chunk, got it after %d bytes", len(got))
+		}
+		if _, err := reader.Read(make([]byte, 1)); err != ErrChunkSignatureMismatch {
+			t.Errorf("Expected the error to be sticky, got %v", err)
+		}
+	})
+
+	t.Run("header longer than the buffer", func(t *testing.T) {
+		header := "1;chunk-signature=" + strings.Repeat("0", chunkedBufferSize) + "\r\n"
+		reader := NewChunkedReader(strings.NewReader(header), signingKey, credScope, timestamp, "seed-signature")
+		if _, err := io.ReadAll(reader); err != ErrInvalidChunkFormat {
+			t.Errorf("Expected ErrInvalidChunkFormat, got %v", err)
+		}
+	})
+}
+
+// signedChunkedBody encodes data as an aws-chunked body of chunkSize chunks signed with signingKey
+func signedChunkedBody(data []byte, chunkSize int, signingKey []byte, credScope, timestamp, seedSignature string) []byte {
+	c := &ChunkedReader{signingKey: signingKey, credScope: credScope, timestamp: timestamp, prevSignature: seedSignature}
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		n := min(chunkSize, len(data))
+		signature := c.chunkSignature(sha256Hash(string(data[:n])))
+		fmt.Fprintf(&buf, "%x;chunk-signature=%s\r\n", n, signature)
+		buf.Write(data[:n])
+		buf.WriteString("\r\n")
+		c.prevSignature = signature
+		data = data[n:]
+	}
+	fmt.Fprintf(&buf, "0;chunk-signature=%s\r\n", c.chunkSignature(emptyStringSHA256))
+	return buf.Bytes()
+}
+
+func BenchmarkChunkedReader(b *testing.B) {
+	signingKey := CalculateSigningKey("test-secret", "20230101", "us-east-1", "s3")
+	credScope := "20230101/us-east-1/s3/aws4_request"
+	timestamp := "20230101T000000Z"
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<19) // 8 MiB
+	for _, chunkSize := range []int{8 << 10, 64 << 10, 1 << 20} {
+		body := signedChunkedBody(data, chunkSize, signingKey, credScope, timestamp, "seed-signature")
+		b.Run(fmt.Sprintf("Chunk%dKB", chunkSize>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				reader := NewChunkedReader(bytes.NewReader(body), signingKey, credScope, timestamp, "seed-signature")
+				if _, err := io.Copy(io.Discard, reader); err != nil {
+					b.Fatalf("Failed to read chunked body: %v", err)
+				}
+			}
+		})
+	}
+}
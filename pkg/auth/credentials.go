@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedCredentialsVersion identifies the format of encrypted credentials files
+const encryptedCredentialsVersion = 1
+
+// pbkdf2Iterations is the work factor of the key derivation from the master key
+const pbkdf2Iterations = 600000
+
+// ErrMasterKeyRequired is returned when an encrypted credentials file is loaded without a master key
+var ErrMasterKeyRequired = errors.New("credentials file is encrypted but no master key was given")
+
+// encryptedCredentials is the on-disk form of an encrypted credentials file
+// Secrets cannot be stored as one-way verifiers, since signature V4 needs the secret itself to compute the expected signature
+type encryptedCredentials struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ParseCredentials parses credentials of the form accessKeyID:secretAccessKey,
+// separated by commas or newlines; empty lines and lines starting with # are ignored
+func ParseCredentials(data string) ([]Credentials, error) {
+	var credentials []Credentials
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, cred := range strings.Split(line, ",") {
+			cred = strings.TrimSpace(cred)
+			if cred == "" {
+				continue
+			}
+			accessKeyID, secretAccessKey, ok := strings.Cut(cred, ":")
+			if !ok || accessKeyID == "" || secretAccessKey == "" {
+				return nil, fmt.Errorf("invalid credentials entry for %q, expecting accessKeyID:secretAccessKey", accessKeyID)
+			}
+			credentials = append(credentials, Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey})
+		}
+	}
+	return credentials, nil
+}
+
+// LoadCredentialsFile reads credentials from a file, either in plain text or encrypted with EncryptCredentials
+// Encrypted files require masterKey, which is ignored for plain text files
+func LoadCredentialsFile(path string, masterKey []byte) ([]Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if len(masterKey) == 0 {
+			return nil, ErrMasterKeyRequired
+		}
+		data, err = DecryptCredentials(data, masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+	}
+	return ParseCredentials(string(data))
+}
+
+// EncryptCredentials encrypts plain text credentials with AES-256-GCM,
+// using a key derived from masterKey with PBKDF2-SHA256
+func EncryptCredentials(plaintext, masterKey []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := credentialsCipher(masterKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedCredentials{
+		Version:    encryptedCredentialsVersion,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+}
+
+// DecryptCredentials decrypts credentials encrypted with EncryptCredentials
+func DecryptCredentials(data, masterKey []byte) ([]byte, error) {
+	var encrypted encryptedCredentials
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return nil, err
+	}
+	if encrypted.Version != encryptedCredentialsVersion {
+		return nil, fmt.Errorf("unsupported encrypted credentials version %d", encrypted.Version)
+	}
+	aead, err := credentialsCipher(masterKey, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong master key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+// credentialsCipher derives the AES-256-GCM cipher of the master key and salt
+func credentialsCipher(masterKey, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(masterKey), salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
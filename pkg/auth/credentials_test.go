@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	credentials, err := ParseCredentials("# admins\nadmin:secret1, backup:secret2\n\nreader:sec:ret3\n")
+	if err != nil {
+		t.Fatalf("Failed to parse credentials: %v", err)
+	}
+	want := []Credentials{
+		{AccessKeyID: "admin", SecretAccessKey: "secret1"},
+		{AccessKeyID: "backup", SecretAccessKey: "secret2"},
+		{AccessKeyID: "reader", SecretAccessKey: "sec:ret3"},
+	}
+	if len(credentials) != len(want) {
+		t.Fatalf("Expected %d credentials, got %d", len(want), len(credentials))
+	}
+	for i := range want {
+		if credentials[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], credentials[i])
+		}
+	}
+
+	if _, err := ParseCredentials("missing-secret"); err == nil {
+		t.Error("Expected an error for an entry without secret")
+	}
+}
+
+func TestLoadCredentialsFile(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := "admin:secret1\nreader:secret2\n"
+
+	plainPath := filepath.Join(dir, "plain")
+	os.WriteFile(plainPath, []byte(plaintext), 0600)
+	credentials, err := LoadCredentialsFile(plainPath, nil)
+	if err != nil || len(credentials) != 2 {
+		t.Fatalf("Expected 2 plain text credentials, got %v (%v)", credentials, err)
+	}
+
+	encrypted, err := EncryptCredentials([]byte(plaintext), []byte("master key"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt credentials: %v", err)
+	}
+	if strings.Contains(string(encrypted), "secret1") {
+		t.Fatal("Encrypted file contains a plain text secret")
+	}
+	encryptedPath := filepath.Join(dir, "encrypted")
+	os.WriteFile(encryptedPath, encrypted, 0600)
+
+	credentials, err = LoadCredentialsFile(encryptedPath, []byte("master key"))
+	if err != nil {
+		t.Fatalf("Failed to load encrypted credentials: %v", err)
+	}
+	if len(credentials) != 2 || credentials[0].SecretAccessKey != "secret1" {
+		t.Errorf("Unexpected decrypted credentials: %+v", credentials)
+	}
+
+	if _, err := LoadCredentialsFile(encryptedPath, nil); !errors.Is(err, ErrMasterKeyRequired) {
+		t.Errorf("Expected ErrMasterKeyRequired, got %v", err)
+	}
+	if _, err := LoadCredentialsFile(encryptedPath, []byte("wrong key")); err == nil {
+		t.Error("Expected an error for a wrong master key")
+	}
+}
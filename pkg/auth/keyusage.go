@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyUsage tracks when each access key last authenticated a request, so stale credentials can be retired
+type KeyUsage struct {
+	path string // file the timestamps are persisted to, kept in memory only if empty
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time // accessKeyID -> time of its latest authenticated request
+	dirty    bool                 // lastUsed changed since it was last saved
+}
+
+// LoadKeyUsage reads the timestamps persisted to path, which is created on the first save if missing
+// An empty path keeps the timestamps in memory only
+func LoadKeyUsage(path string) (*KeyUsage, error) {
+	u := &KeyUsage{path: path, lastUsed: map[string]time.Time{}}
+	if path == "" {
+		return u, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &u.lastUsed); err != nil {
+		return nil, fmt.Errorf("invalid key usage %s: %w", path, err)
+	}
+	return u, nil
+}
+
+// record marks accessKeyID as used at t
+func (u *KeyUsage) record(accessKeyID string, t time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if t.After(u.lastUsed[accessKeyID]) {
+		u.lastUsed[accessKeyID] = t
+		u.dirty = true
+	}
+}
+
+// LastUsed returns when accessKeyID last authenticated a request, and false if it never did
+func (u *KeyUsage) LastUsed(accessKeyID string) (time.Time, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t, ok := u.lastUsed[accessKeyID]
+	return t, ok
+}
+
+// Save persists the timestamps if they changed since they were last saved
+// The file is written next to its path and renamed over it, so a crash never leaves it partially written
+func (u *KeyUsage) Save() error {
+	if u.path == "" {
+		return nil
+	}
+	u.mu.Lock()
+	if !u.dirty {
+		u.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(u.lastUsed)
+	u.dirty = false
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(u.path, data); err != nil {
+		// Retry on the next save
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes data next to path and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Run saves the timestamps every interval until ctx is done
+func (u *KeyUsage) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.Save(); err != nil {
+				log.Printf("Failed to save key usage: %v", err)
+			}
+		}
+	}
+}
+
+// SetKeyUsage tracks the last use of the access keys authenticating requests in u, disabled if nil
+// Temporary credentials issued by the STS endpoint expire on their own and are not tracked
+func (a *AWS4Authenticator) SetKeyUsage(u *KeyUsage) {
+	a.keyUsage = u
+}
+
+// recordKeyUsage marks accessKeyID as used now, unless it is a temporary credential
+func (a *AWS4Authenticator) recordKeyUsage(accessKeyID string) {
+	if a.keyUsage == nil || accessKeyID == "" {
+		return
+	}
+	a.mu.RLock()
+	_, temporary := a.sessions[accessKeyID]
+	a.mu.RUnlock()
+	if !temporary {
+		a.keyUsage.record(accessKeyID, a.now())
+	}
+}
+
+// KeyUsageReport describes when a configured access key was last used
+type KeyUsageReport struct {
+	AccessKeyID string     `json:"accessKeyId"`
+	Source      string     `json:"source"`   // "static", "watched" or "certificate"
+	LastUsed    *time.Time `json:"lastUsed"` // null if the key was never used
+}
+
+// KeyUsageReports returns the last use of every configured access key, sorted by access key
+func (a *AWS4Authenticator) KeyUsageReports() []KeyUsageReport {
+	sources := map[string]string{}
+	for accessKeyID := range a.credentials {
+		sources[accessKeyID] = "static"
+	}
+	a.mu.RLock()
+	for accessKeyID := range a.watched {
+		sources[accessKeyID] = "watched"
+	}
+	a.mu.RUnlock()
+	for _, accessKeyID := range a.certificates {
+		if _, ok := sources[accessKeyID]; !ok {
+			sources[accessKeyID] = "certificate"
+		}
+	}
+
+	reports := make([]KeyUsageReport, 0, len(sources))
+	for accessKeyID, source := range sources {
+		report := KeyUsageReport{AccessKeyID: accessKeyID, Source: source}
+		if a.keyUsage != nil {
+			if t, ok := a.keyUsage.LastUsed(accessKeyID); ok {
+				report.LastUsed = &t
+			}
+		}
+		reports = append(reports, report)
+	}
+	slices.SortFunc(reports, func(x, y KeyUsageReport) int {
+		return strings.Compare(x.AccessKeyID, y.AccessKeyID)
+	})
+	return reports
+}
+
+// KeyUsageHandler serves the key rotation audit report as JSON to requests signed with one of adminKeys
+// The staleFor query parameter, such as ?staleFor=720h, limits the report to the keys not used for that long
+func (a *AWS4Authenticator) KeyUsageHandler(adminKeys []string) http.Handler {
+	return a.AdminMiddleware(adminKeys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports := a.KeyUsageReports()
+		if s := r.URL.Query().Get("staleFor"); s != "" {
+			staleFor, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "invalid staleFor: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			cutoff := a.now().Add(-staleFor)
+			reports = slices.DeleteFunc(reports, func(report KeyUsageReport) bool {
+				return report.LastUsed != nil && report.LastUsed.After(cutoff)
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}))
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-usage.json")
+	usage, err := LoadKeyUsage(path)
+	if err != nil {
+		t.Fatalf("Failed to load missing key usage: %v", err)
+	}
+
+	used := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	usage.record("test-key", used)
+	usage.record("test-key", used.Add(-time.Hour))
+	if err := usage.Save(); err != nil {
+		t.Fatalf("Failed to save key usage: %v", err)
+	}
+
+	loaded, err := LoadKeyUsage(path)
+	if err != nil {
+		t.Fatalf("Failed to load key usage: %v", err)
+	}
+	if got, ok := loaded.LastUsed("test-key"); !ok || !got.Equal(used) {
+		t.Errorf("Expected the latest use to be persisted, got %v", got)
+	}
+	if _, ok := loaded.LastUsed("other-key"); ok {
+		t.Error("Expected an unused key to have no last use")
+	}
+
+	// Saving without changes leaves the file alone
+	os.Remove(path)
+	if err := usage.Save(); err != nil {
+		t.Fatalf("Failed to save key usage: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected an unchanged key usage not to be written, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("not json"), 0600)
+	if _, err := LoadKeyUsage(path); err == nil {
+		t.Error("Expected an error for an invalid key usage file")
+	}
+}
+
+func TestKeyUsageHandler(t *testing.T) {
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddCredentials("stale-key", "stale-secret")
+	auth.AddCredentials("unused-key", "unused-secret")
+	auth.AddCertificateIdentity("CN=reporter,O=analytics", "reporter-key")
+	auth.SetClock(func() time.Time { return now })
+	usage, _ := LoadKeyUsage("")
+	auth.SetKeyUsage(usage)
+	usage.record("stale-key", now.AddDate(0, -2, 0))
+
+	// Signed requests and Basic credentials both count as a use
+	req := httptest.NewRequest(http.MethodGet, "/bucket/object", nil)
+	signRequestForHost(t, auth, req, req.Host, "us-east-1")
+	auth.SetClock(func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) })
+	auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := usage.LastUsed("test-key"); !ok {
+		t.Error("Expected a signed request to count as a use")
+	}
+	auth.SetClock(func() time.Time { return now })
+	basic := httptest.NewRequest(http.MethodGet, "/", nil)
+	basic.SetBasicAuth("test-key", "test-secret")
+	auth.BasicAuthMiddleware("s3d", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), basic)
+
+	handler := auth.KeyUsageHandler([]string{"test-key"})
+	report := func(query string) (int, []KeyUsageReport) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/access-keys"+query, nil)
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		handler.ServeHTTP(rec, req)
+		var reports []KeyUsageReport
+		json.Unmarshal(rec.Body.Bytes(), &reports)
+		return rec.Code, reports
+	}
+
+	code, reports := report("")
+	if code != http.StatusOK || len(reports) != 4 {
+		t.Fatalf("Expected every configured key to be reported, got %d: %+v", code, reports)
+	}
+	want := []struct {
+		accessKeyID, source string
+		lastUsed            time.Time
+	}{
+		{"reporter-key", "certificate", time.Time{}},
+		{"stale-key", "static", now.AddDate(0, -2, 0)},
+		{"test-key", "static", now},
+		{"unused-key", "static", time.Time{}},
+	}
+	for i, w := range want {
+		r := reports[i]
+		if r.AccessKeyID != w.accessKeyID || r.Source != w.source {
+			t.Errorf("Expected %s from %s, got %+v", w.accessKeyID, w.source, r)
+		}
+		if w.lastUsed.IsZero() != (r.LastUsed == nil) || r.LastUsed != nil && !r.LastUsed.Equal(w.lastUsed) {
+			t.Errorf("Expected %s to be last used at %v, got %v", w.accessKeyID, w.lastUsed, r.LastUsed)
+		}
+	}
+
+	code, reports = report("?staleFor=720h")
+	if code != http.StatusOK || len(reports) != 3 {
+		t.Fatalf("Expected the keys not used for 30 days, got %d: %+v", code, reports)
+	}
+	for _, r := range reports {
+		if r.AccessKeyID == "test-key" {
+			t.Errorf("Expected a recently used key not to be reported as stale")
+		}
+	}
+
+	if code, _ := report("?staleFor=month"); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid duration to be rejected, got %d", code)
+	}
+
+	// The report is only served to requests signed with an admin key
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/access-keys", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected an anonymous request to be rejected, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/admin/access-keys", nil)
+	signRequestForHost(t, auth, req, req.Host, "us-east-1")
+	auth.KeyUsageHandler([]string{"admin-key"}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a request signed with another key to be rejected, got %d", rec.Code)
+	}
+}
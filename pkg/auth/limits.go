@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Limits caps the concurrent requests and the bandwidth of each access key,
+// so one heavy tenant cannot starve the others on a shared instance
+type Limits struct {
+	// Default applies to access keys without their own limits, including anonymous requests
+	Default KeyLimits `json:"default"`
+	// AccessKeys are the limits of individual access keys, replacing the default ones
+	AccessKeys map[string]KeyLimits `json:"accessKeys,omitempty"`
+
+	mu     sync.Mutex
+	states map[string]*limitState
+	// lastPrune is when idle states were last dropped
+	lastPrune time.Time
+}
+
+// KeyLimits are the limits of an access key, 0 meaning unlimited
+type KeyLimits struct {
+	// MaxConcurrent is the number of requests served at once, further requests are rejected with SlowDown
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// UploadRate is the number of request body bytes read per second, shared by all requests of the key
+	UploadRate int64 `json:"uploadRate,omitempty"`
+	// DownloadRate is the number of response body bytes written per second, shared by all requests of the key
+	DownloadRate int64 `json:"downloadRate,omitempty"`
+}
+
+// limitState tracks the requests in flight and the bandwidth used by an access key
+type limitState struct {
+	active   int
+	upload   *bandwidth
+	download *bandwidth
+}
+
+// LoadLimits reads limits from a JSON file
+func LoadLimits(path string) (*Limits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var limits Limits
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("invalid limits %s: %w", path, err)
+	}
+	if err := limits.Default.validate(); err != nil {
+		return nil, fmt.Errorf("invalid limits %s: default: %w", path, err)
+	}
+	for accessKeyID, keyLimits := range limits.AccessKeys {
+		if err := keyLimits.validate(); err != nil {
+			return nil, fmt.Errorf("invalid limits %s: access key %q: %w", path, accessKeyID, err)
+		}
+	}
+	return &limits, nil
+}
+
+// validate rejects negative limits
+func (k KeyLimits) validate() error {
+	if k.MaxConcurrent < 0 || k.UploadRate < 0 || k.DownloadRate < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	return nil
+}
+
+// limitsOf returns the limits applying to accessKeyID
+func (l *Limits) limitsOf(accessKeyID string) KeyLimits {
+	if keyLimits, ok := l.AccessKeys[accessKeyID]; ok {
+		return keyLimits
+	}
+	return l.Default
+}
+
+// Middleware is HTTP middleware enforcing the limits of the access key of each request
+// It must run after AuthMiddleware so that the access key of the request is known
+func (l *Limits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKeyID := AccessKeyIDFromContext(r.Context())
+		keyLimits := l.limitsOf(accessKeyID)
+
+		state, ok := l.acquire(accessKeyID, keyLimits)
+		if !ok {
+			metrics.Add("rejected_concurrency", 1)
+			writeError(w, "SlowDown", "Too many concurrent requests for this access key, please reduce your request rate", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release(accessKeyID)
+
+		if state.upload != nil && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &throttledBody{ReadCloser: r.Body, ctx: r.Context(), bandwidth: state.upload}
+		}
+		if state.download != nil {
+			w = &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), bandwidth: state.download}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire counts a request of accessKeyID in flight, reporting false if it exceeds the concurrency limit
+func (l *Limits) acquire(accessKeyID string, keyLimits KeyLimits) (*limitState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.states == nil {
+		l.states = map[string]*limitState{}
+	}
+	if now := time.Now(); now.Sub(l.lastPrune) >= time.Minute {
+		l.prune(now)
+	}
+	state, ok := l.states[accessKeyID]
+	if !ok {
+		state = &limitState{
+			upload:   newBandwidth(keyLimits.UploadRate),
+			download: newBandwidth(keyLimits.DownloadRate),
+		}
+		l.states[accessKeyID] = state
+	}
+	if keyLimits.MaxConcurrent > 0 && state.active >= keyLimits.MaxConcurrent {
+		return nil, false
+	}
+	state.active++
+	return state, true
+}
+
+// release ends a request of accessKeyID, dropping its state once idle so temporary credentials do not accumulate
+// A state whose bandwidth is still being paid off is kept, and dropped by a later prune
+func (l *Limits) release(accessKeyID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.states[accessKeyID]
+	state.active--
+	if state.idle(time.Now()) {
+		delete(l.states, accessKeyID)
+	}
+}
+
+// prune drops the states that became idle after their last request ended
+func (l *Limits) prune(now time.Time) {
+	l.lastPrune = now
+	for accessKeyID, state := range l.states {
+		if state.idle(now) {
+			delete(l.states, accessKeyID)
+		}
+	}
+}
+
+// idle reports whether no request is in flight and the bandwidth used is paid off,
+// so dropping the state loses nothing
+func (s *limitState) idle(now time.Time) bool {
+	return s.active == 0 && !s.upload.busy(now) && !s.download.busy(now)
+}
+
+// bandwidth schedules transfers so they do not exceed a number of bytes per second
+type bandwidth struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time // when the bytes transferred so far are paid off
+}
+
+// newBandwidth creates a bandwidth of rate bytes per second, or nil for no limit
+func newBandwidth(rate int64) *bandwidth {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidth{rate: rate}
+}
+
+// busy reports whether transfers made before now are still being paid off
+func (b *bandwidth) busy(now time.Time) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.next.After(now)
+}
+
+// chunk returns the largest transfer size keeping the throttling smooth
+func (b *bandwidth) chunk(n int) int {
+	return int(min(int64(n), max(b.rate, 1)))
+}
+
+// wait blocks until n more transferred bytes fit in the rate
+func (b *bandwidth) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(float64(n) / float64(b.rate) * float64(time.Second)))
+	delay := b.next.Sub(now)
+	b.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledBody is a request body read within a bandwidth
+type throttledBody struct {
+	io.ReadCloser
+	ctx       context.Context
+	bandwidth *bandwidth
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p[:t.bandwidth.chunk(len(p))])
+	if n > 0 {
+		if werr := t.bandwidth.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledResponseWriter is a response writer whose body is written within a bandwidth
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	bandwidth *bandwidth
+}
+
+func (t *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := t.ResponseWriter.Write(p[:t.bandwidth.chunk(len(p))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := t.bandwidth.wait(t.ctx, n); err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying response writer
+func (t *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
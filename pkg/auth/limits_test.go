@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLimits(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"default":{"maxConcurrent":4},"accessKeys":{"batch":{"uploadRate":1048576}}}`), 0644)
+	limits, err := LoadLimits(valid)
+	if err != nil {
+		t.Fatalf("Failed to load limits: %v", err)
+	}
+	if got := limits.limitsOf("other"); got.MaxConcurrent != 4 {
+		t.Errorf("Expected the default limits, got %+v", got)
+	}
+	if got := limits.limitsOf("batch"); got.MaxConcurrent != 0 || got.UploadRate != 1048576 {
+		t.Errorf("Expected the limits of the access key, got %+v", got)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"accessKeys":{"batch":{"downloadRate":-1}}}`), 0644)
+	if _, err := LoadLimits(invalid); err == nil {
+		t.Error("Expected an error for a negative limit")
+	}
+}
+
+func TestLimitsConcurrency(t *testing.T) {
+	limits := &Limits{
+		Default:    KeyLimits{MaxConcurrent: 1},
+		AccessKeys: map[string]KeyLimits{"unlimited": {}},
+	}
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := limits.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(target, accessKeyID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(context.WithValue(req.Context(), accessKeyIDKey{}, accessKeyID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- serve("/slow", "tenant").Code
+	}()
+	<-started
+
+	if rec := serve("/fast", "tenant"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a second concurrent request to be rejected, got %d", rec.Code)
+	}
+	if rec := serve("/fast", "other"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another access key to be served, got %d", rec.Code)
+	}
+	if rec := serve("/fast", "unlimited"); rec.Code != http.StatusOK {
+		t.Errorf("Expected an access key without limits to be served, got %d", rec.Code)
+	}
+
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected the first request to be served, got %d", code)
+	}
+	if rec := serve("/fast", "tenant"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a request after the first one finished to be served, got %d", rec.Code)
+	}
+	if len(limits.states) != 0 {
+		t.Errorf("Expected idle access keys to be dropped, got %d", len(limits.states))
+	}
+}
+
+func TestLimitsBandwidth(t *testing.T) {
+	const rate = 100 * 1024
+	limits := &Limits{Default: KeyLimits{UploadRate: rate, DownloadRate: rate}}
+	handler := limits.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	}))
+
+	// 20KB each way at 100KB/s takes at least 400ms
+	data := bytes.Repeat([]byte("x"), 20*1024)
+	req := httptest.NewRequest(http.MethodPut, "/bucket/key", bytes.NewReader(data))
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("Expected the body to be echoed, got %d bytes", rec.Body.Len())
+	}
+	if elapsed < 350*time.Millisecond {
+		t.Errorf("Expected the transfer to be throttled, took %v", elapsed)
+	}
+}
+
+func TestLimitsPrune(t *testing.T) {
+	limits := &Limits{Default: KeyLimits{DownloadRate: 1}}
+
+	// The bandwidth of the request is still being paid off when it ends
+	state, ok := limits.acquire("temporary", limits.Default)
+	if !ok {
+		t.Fatal("Expected the request to be accepted")
+	}
+	state.download.next = time.Now().Add(time.Hour)
+	limits.release("temporary")
+	if _, ok := limits.states["temporary"]; !ok {
+		t.Fatal("Expected the busy state to be kept")
+	}
+
+	// Once paid off, it is dropped by the next prune
+	state.download.next = time.Now().Add(-time.Second)
+	limits.lastPrune = time.Now().Add(-time.Minute)
+	if _, ok := limits.acquire("other", limits.Default); !ok {
+		t.Fatal("Expected the request to be accepted")
+	}
+	limits.release("other")
+	if len(limits.states) != 0 {
+		t.Errorf("Expected idle states to be dropped, got %d", len(limits.states))
+	}
+}
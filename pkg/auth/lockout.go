@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics exposes authentication related counters through expvar
+var metrics = expvar.NewMap("s3d_auth")
+
+// maxLockoutEntries caps the access keys and client IPs tracked at once,
+// failures of others are not counted while the entries are full of recent failures
+const maxLockoutEntries = 100000
+
+// Lockout slows down credential stuffing by rejecting clients after repeated authentication failures
+// Failures are counted per client IP, and wrong signatures also per access key; once either reaches
+// the threshold it is locked for a delay that doubles with every further failure, up to the maximum delay
+type Lockout struct {
+	threshold int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+	lastPrune time.Time
+}
+
+// lockoutEntry tracks the failures of an access key or client IP
+type lockoutEntry struct {
+	failures    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// NewLockout creates a lockout locking after threshold consecutive failures
+func NewLockout(threshold int, baseDelay, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		threshold: threshold,
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+		entries:   map[string]*lockoutEntry{},
+	}
+}
+
+// SetLockout enables brute-force protection of the authenticator
+func (a *AWS4Authenticator) SetLockout(lockout *Lockout) {
+	a.lockout = lockout
+}
+
+// requestLockoutKeys returns the keys r is locked out under, its client IP first
+func requestLockoutKeys(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"ip:" + host}
+	if accessKeyID := requestAccessKeyID(r); accessKeyID != "" {
+		keys = append(keys, "key:"+accessKeyID)
+	}
+	return keys
+}
+
+// failedLockoutKeys returns the keys of a request whose authentication failed that the failure is counted under
+// Every failure counts against the client IP, but only a wrong signature or secret of an existing access key
+// counts against the key, so that made-up access key IDs, expired credentials or skewed clocks
+// neither lock a key out for everyone nor fill the entries
+func failedLockoutKeys(keys []string, wrongSecret bool) []string {
+	if wrongSecret {
+		return keys
+	}
+	return keys[:1]
+}
+
+// isSignatureMismatch reports whether err is the authentication error of a signature that does not match,
+// calculated with the secret of an existing access key
+func isSignatureMismatch(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.Code == signatureMismatchCode
+}
+
+// requestAccessKeyID returns the access key a request claims to be signed with, or to be sent
+// as HTTP Basic user name, before verifying it
+func requestAccessKeyID(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	credential := r.URL.Query().Get("X-Amz-Credential")
+	if credential == "" {
+		_, after, ok := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+		if !ok {
+			return ""
+		}
+		credential, _, _ = strings.Cut(after, ",")
+	}
+	accessKeyID, _, _ := strings.Cut(credential, "/")
+	return accessKeyID
+}
+
+// retryAfter returns how long the keys remain locked, or 0 if none is locked
+func (l *Lockout) retryAfter(keys []string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		if entry, ok := l.entries[key]; ok {
+			if d := entry.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// fail records an authentication failure for the keys
+func (l *Lockout) fail(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	metrics.Add("failures", 1)
+	now := time.Now()
+	if now.Sub(l.lastPrune) >= time.Minute {
+		l.prune(now)
+	}
+
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			if len(l.entries) >= maxLockoutEntries {
+				l.prune(now)
+			}
+			if len(l.entries) >= maxLockoutEntries {
+				metrics.Add("lockout_entries_full", 1)
+				continue
+			}
+			entry = &lockoutEntry{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures < l.threshold {
+			continue
+		}
+
+		delay := l.maxDelay
+		if shift := entry.failures - l.threshold; shift < 32 {
+			if d := l.baseDelay << shift; d > 0 && d < l.maxDelay {
+				delay = d
+			}
+		}
+		entry.lockedUntil = now.Add(delay)
+		metrics.Add("lockouts", 1)
+		log.Printf("Locked out %s for %s after %d failed authentications", key, delay, entry.failures)
+	}
+}
+
+// succeed forgets the failures of the access key of a request that authenticated
+// The failures of the client IP are kept, so that valid requests such as presigned URLs sent
+// between guesses do not reset them; they are forgotten once the IP stops failing for the maximum delay
+func (l *Lockout) succeed(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "ip:") {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// prune drops entries without failures for longer than the maximum delay, so the map does not grow without bound
+// It runs at most once a minute, unless the entries are full
+func (l *Lockout) prune(now time.Time) {
+	l.lastPrune = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFailure) > l.maxDelay && now.After(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestAccessKeyID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/bucket/object", nil)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=test-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc")
+	if id := requestAccessKeyID(req); id != "test-key" {
+		t.Errorf("Expected test-key from the header, got %q", id)
+	}
+
+	req = httptest.NewRequest("GET", "/bucket/object?X-Amz-Credential=query-key%2F20230101%2Fus-east-1%2Fs3%2Faws4_request", nil)
+	if id := requestAccessKeyID(req); id != "query-key" {
+		t.Errorf("Expected query-key from the query, got %q", id)
+	}
+
+	if id := requestAccessKeyID(httptest.NewRequest("GET", "/", nil)); id != "" {
+		t.Errorf("Expected no access key for an unsigned request, got %q", id)
+	}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	lockout := NewLockout(3, time.Minute, 5*time.Minute)
+	keys := []string{"ip:192.0.2.1", "key:test-key"}
+
+	for i := 0; i < 2; i++ {
+		lockout.fail(keys)
+	}
+	if wait := lockout.retryAfter(keys); wait != 0 {
+		t.Fatalf("Expected no lockout below the threshold, got %v", wait)
+	}
+
+	// The delay doubles with every failure past the threshold, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		lockout.fail(keys)
+		if wait := lockout.retryAfter(keys); wait > want || wait < want-time.Second {
+			t.Errorf("Expected a lockout of %v, got %v", want, wait)
+		}
+	}
+
+	// Success clears the access key but not the client IP
+	lockout.succeed(keys)
+	if wait := lockout.retryAfter(keys[1:]); wait != 0 {
+		t.Errorf("Expected success to clear the lockout of the access key, got %v", wait)
+	}
+	if wait := lockout.retryAfter(keys[:1]); wait == 0 {
+		t.Error("Expected success to keep the lockout of the client IP")
+	}
+}
+
+func TestLockoutEntriesLimit(t *testing.T) {
+	lockout := NewLockout(3, time.Minute, time.Hour)
+	for i := range maxLockoutEntries + 10 {
+		lockout.fail([]string{fmt.Sprintf("ip:%d", i)})
+	}
+	if n := len(lockout.entries); n != maxLockoutEntries {
+		t.Errorf("Expected %d entries, got %d", maxLockoutEntries, n)
+	}
+
+	// Stale entries make room for new ones
+	for _, entry := range lockout.entries {
+		entry.lastFailure = entry.lastFailure.Add(-2 * time.Hour)
+	}
+	lockout.fail([]string{"ip:new"})
+	if _, ok := lockout.entries["ip:new"]; !ok || len(lockout.entries) != 1 {
+		t.Errorf("Expected stale entries to be pruned, got %d entries", len(lockout.entries))
+	}
+}
+
+func TestAuthMiddlewareLockout(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.SetLockout(NewLockout(2, time.Minute, time.Hour))
+
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(remoteAddr string, valid bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		req.RemoteAddr = remoteAddr
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		if !valid {
+			req.Header.Set("X-Amz-Date", "20230102T000000Z")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("192.0.2.1:1234", true); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a valid request to pass, got %d", rec.Code)
+	}
+	for i := 0; i < 2; i++ {
+		serve("192.0.2.1:1234", false)
+	}
+
+	rec := serve("192.0.2.1:1234", true)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected the client to be locked out, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	// The access key is locked as well, so switching addresses does not help
+	if rec := serve("198.51.100.1:1234", true); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the access key to be locked out, got %d", rec.Code)
+	}
+}
+
+func TestAuthMiddlewareLockoutKeys(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.SetLockout(NewLockout(3, time.Minute, time.Hour))
+
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(remoteAddr, accessKeyID string, valid bool) int {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		req.RemoteAddr = remoteAddr
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		if accessKeyID != "test-key" {
+			req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "Credential=test-key/", "Credential="+accessKeyID+"/", 1))
+		}
+		if !valid {
+			req.Header.Set("X-Amz-Date", "20230102T000000Z")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Unknown access key IDs lock out the client IP, but are not tracked as keys
+	for i := range 3 {
+		serve("192.0.2.1:1234", fmt.Sprintf("unknown-%d", i), false)
+	}
+	if code := serve("192.0.2.1:1234", "test-key", true); code != http.StatusForbidden {
+		t.Errorf("Expected the client IP to be locked out, got %d", code)
+	}
+	for key := range auth.lockout.entries {
+		if strings.HasPrefix(key, "key:") {
+			t.Errorf("Expected no access key to be tracked, got %s", key)
+		}
+	}
+
+	// Valid requests between guesses do not reset the failures of the client IP
+	serve("192.0.2.2:1234", "test-key", false)
+	serve("192.0.2.2:1234", "test-key", true)
+	serve("192.0.2.2:1234", "test-key", false)
+	serve("192.0.2.2:1234", "test-key", true)
+	serve("192.0.2.2:1234", "test-key", false)
+	if code := serve("192.0.2.2:1234", "test-key", true); code != http.StatusForbidden {
+		t.Errorf("Expected the client IP to be locked out, got %d", code)
+	}
+	// The key itself had its failures reset by the valid requests
+	if code := serve("198.51.100.1:1234", "test-key", true); code != http.StatusOK {
+		t.Errorf("Expected the access key to stay usable, got %d", code)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+)
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether s matches any of patterns
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether s matches pattern, where "*" matches any sequence of characters
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"bucket", "bucket", true},
+		{"bucket", "bucket2", false},
+		{"*", "", true},
+		{"logs/*", "logs/2024/01/app.log", true},
+		{"logs/*", "other/app.log", false},
+		{"*.log", "logs/app.log", true},
+		{"*.log", "logs/app.txt", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxcyyb", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// NetworkRules restrict the client addresses requests are accepted from, for every request,
+// per access key and per bucket
+// A request is rejected if its address is denied by any rule applying to it, or if a rule
+// applying to it allows some addresses but not its own
+type NetworkRules struct {
+	// Default applies to every request, including anonymous ones
+	Default NetworkRule `json:"default"`
+	// AccessKeys are the rules of requests authenticated with an access key, "" being anonymous requests
+	AccessKeys map[string]NetworkRule `json:"accessKeys,omitempty"`
+	// Buckets are the rules of requests to a bucket and its objects
+	Buckets map[string]NetworkRule `json:"buckets,omitempty"`
+}
+
+// NetworkRule lists the addresses and CIDR networks requests are allowed or denied from
+type NetworkRule struct {
+	// Allow are the only addresses requests are accepted from, any address if empty
+	Allow []string `json:"allow,omitempty"`
+	// Deny are addresses requests are rejected from, even if allowed
+	Deny []string `json:"deny,omitempty"`
+
+	allow, deny []netip.Prefix
+}
+
+// LoadNetworkRules reads network rules from a JSON file
+func LoadNetworkRules(path string) (*NetworkRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules NetworkRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid network rules %s: %w", path, err)
+	}
+	if err := rules.Default.parse(); err != nil {
+		return nil, fmt.Errorf("invalid network rules %s: default: %w", path, err)
+	}
+	for accessKeyID, rule := range rules.AccessKeys {
+		if err := rule.parse(); err != nil {
+			return nil, fmt.Errorf("invalid network rules %s: access key %q: %w", path, accessKeyID, err)
+		}
+		rules.AccessKeys[accessKeyID] = rule
+	}
+	for bucket, rule := range rules.Buckets {
+		if err := rule.parse(); err != nil {
+			return nil, fmt.Errorf("invalid network rules %s: bucket %q: %w", path, bucket, err)
+		}
+		rules.Buckets[bucket] = rule
+	}
+	return &rules, nil
+}
+
+// parse parses the addresses and networks of the rule
+func (r *NetworkRule) parse() error {
+	var err error
+	if r.allow, err = parsePrefixes(r.Allow); err != nil {
+		return err
+	}
+	r.deny, err = parsePrefixes(r.Deny)
+	return err
+}
+
+// parsePrefixes parses IP addresses and CIDR networks
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// allows reports whether the rule accepts requests from addr
+func (r *NetworkRule) allows(addr netip.Addr) bool {
+	for _, prefix := range r.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, prefix := range r.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware is HTTP middleware that rejects requests from addresses the rules do not allow
+// It must run after AuthMiddleware so that the access key of the request is known, and after the
+// client address of requests from trusted proxies is recovered
+func (n *NetworkRules) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !n.allows(r) {
+			metrics.Add("rejected_network", 1)
+			writeError(w, "AccessDenied", "Access from your address is not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allows reports whether the rules applying to r accept requests from its address
+func (n *NetworkRules) allows(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	if !n.Default.allows(addr) {
+		return false
+	}
+	req := webhookRequest(r)
+	if rule, ok := n.AccessKeys[req.AccessKeyID]; ok && !rule.allows(addr) {
+		return false
+	}
+	if rule, ok := n.Buckets[req.Bucket]; ok && req.Bucket != "" && !rule.allows(addr) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNetworkRules(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"default":{"deny":["192.0.2.1"]},"accessKeys":{"ci":{"allow":["10.0.0.0/8","2001:db8::/32"]}},"buckets":{"private":{"allow":["10.1.0.0/16"]}}}`), 0644)
+	rules, err := LoadNetworkRules(valid)
+	if err != nil {
+		t.Fatalf("Failed to load network rules: %v", err)
+	}
+	if len(rules.Default.deny) != 1 || len(rules.AccessKeys["ci"].allow) != 2 || len(rules.Buckets["private"].allow) != 1 {
+		t.Errorf("Expected the networks to be parsed, got %+v", rules)
+	}
+
+	for _, data := range []string{
+		`{"default":{"allow":["10.0.0.0/33"]}}`,
+		`{"accessKeys":{"ci":{"deny":["not-an-address"]}}}`,
+		`{"buckets":{"private":{"allow":["10.0.0.1/8/8"]}}}`,
+	} {
+		invalid := filepath.Join(dir, "invalid.json")
+		os.WriteFile(invalid, []byte(data), 0644)
+		if _, err := LoadNetworkRules(invalid); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestNetworkRulesMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "network.json")
+	os.WriteFile(path, []byte(`{
+		"default": {"deny": ["10.9.0.0/16"]},
+		"accessKeys": {"ci": {"allow": ["10.0.0.0/8", "2001:db8::/32"]}, "": {"allow": ["127.0.0.1"]}},
+		"buckets": {"private": {"allow": ["10.1.0.0/16"]}}
+	}`), 0644)
+	rules, err := LoadNetworkRules(path)
+	if err != nil {
+		t.Fatalf("Failed to load network rules: %v", err)
+	}
+	handler := rules.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		accessKeyID string
+		remoteAddr  string
+		target      string
+		status      int
+	}{
+		{"OtherKey", "other", "192.0.2.1:1234", "/bucket/key", http.StatusOK},
+		{"DeniedEverywhere", "other", "10.9.1.1:1234", "/bucket/key", http.StatusForbidden},
+		{"AllowedKeyNetwork", "ci", "10.2.0.1:1234", "/bucket/key", http.StatusOK},
+		{"AllowedKeyIPv6", "ci", "[2001:db8::1]:1234", "/bucket/key", http.StatusOK},
+		{"AllowedKeyMappedIPv4", "ci", "[::ffff:10.2.0.1]:1234", "/bucket/key", http.StatusOK},
+		{"OutsideKeyNetwork", "ci", "192.0.2.1:1234", "/bucket/key", http.StatusForbidden},
+		{"DenyOverridesAllow", "ci", "10.9.0.1:1234", "/bucket/key", http.StatusForbidden},
+		{"AllowedBucketNetwork", "ci", "10.1.0.1:1234", "/private/key", http.StatusOK},
+		{"OutsideBucketNetwork", "ci", "10.2.0.1:1234", "/private/key", http.StatusForbidden},
+		{"OutsideBucketNetworkOtherKey", "other", "192.0.2.1:1234", "/private", http.StatusForbidden},
+		{"AnonymousLoopback", "", "127.0.0.1:1234", "/bucket/key", http.StatusOK},
+		{"AnonymousRemote", "", "192.0.2.1:1234", "/bucket/key", http.StatusForbidden},
+		{"ServiceRequest", "other", "192.0.2.1:1234", "/", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req = req.WithContext(context.WithValue(req.Context(), accessKeyIDKey{}, tt.accessKeyID))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
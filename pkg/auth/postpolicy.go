@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operators of POST policy conditions
+const (
+	PostPolicyEq                 = "eq"
+	PostPolicyStartsWith         = "starts-with"
+	PostPolicyContentLengthRange = "content-length-range"
+)
+
+// postPolicyExpirationFormat is the format of the expiration of POST policies
+const postPolicyExpirationFormat = "2006-01-02T15:04:05.000Z"
+
+// PostPolicy is the policy document of a browser-based POST upload,
+// sent base64 encoded in the policy form field
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-HTTPPOSTConstructPolicy.html
+type PostPolicy struct {
+	Expiration time.Time
+	Conditions []PostPolicyCondition
+}
+
+// PostPolicyCondition is a condition of a POST policy
+type PostPolicyCondition struct {
+	// Operator is PostPolicyEq, PostPolicyStartsWith or PostPolicyContentLengthRange
+	Operator string
+	// Field is the lowercase name of the form field without its leading $, empty for content-length-range
+	Field string
+	// Value is the value or prefix the field must have
+	Value string
+	// Min and Max bound the size of the uploaded file for content-length-range
+	Min, Max int64
+}
+
+// postPolicyDocument is the JSON form of a POST policy
+type postPolicyDocument struct {
+	Expiration string                `json:"expiration"`
+	Conditions []PostPolicyCondition `json:"conditions"`
+}
+
+// ParsePostPolicy parses a POST policy document, decoded from the base64 of the policy form field
+func ParsePostPolicy(data []byte) (*PostPolicy, error) {
+	var doc postPolicyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, NewAuthError("InvalidPolicyDocument", "Invalid Policy: "+err.Error())
+	}
+	if doc.Expiration == "" {
+		return nil, NewAuthError("InvalidPolicyDocument", "Invalid Policy: Policy missing expiration.")
+	}
+	expiration, err := time.Parse(time.RFC3339Nano, doc.Expiration)
+	if err != nil {
+		return nil, NewAuthError("InvalidPolicyDocument", "Invalid Policy: Invalid 'expiration' value: '"+doc.Expiration+"'")
+	}
+	return &PostPolicy{
+		Expiration: expiration,
+		Conditions: doc.Conditions,
+	}, nil
+}
+
+// MarshalJSON encodes the policy document, for embedders generating their own policies
+func (p PostPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(postPolicyDocument{
+		Expiration: p.Expiration.UTC().Format(postPolicyExpirationFormat),
+		Conditions: p.Conditions,
+	})
+}
+
+// UnmarshalJSON decodes a condition, either {"field": "value"} for an exact match,
+// ["eq" or "starts-with", "$field", "value"] or ["content-length-range", min, max]
+func (c *PostPolicyCondition) UnmarshalJSON(data []byte) error {
+	var match map[string]json.RawMessage
+	if err := json.Unmarshal(data, &match); err == nil {
+		if len(match) != 1 {
+			return fmt.Errorf("condition %s must have exactly one field", data)
+		}
+		for field, raw := range match {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("condition %s must have a string value", data)
+			}
+			*c = PostPolicyCondition{Operator: PostPolicyEq, Field: strings.ToLower(strings.TrimPrefix(field, "$")), Value: value}
+		}
+		return nil
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil || len(list) != 3 {
+		return fmt.Errorf("condition %s must be an object or an array of three elements", data)
+	}
+	var operator string
+	if err := json.Unmarshal(list[0], &operator); err != nil {
+		return fmt.Errorf("condition %s must start with its operator", data)
+	}
+
+	switch operator = strings.ToLower(operator); operator {
+	case PostPolicyEq, PostPolicyStartsWith:
+		var field, value string
+		if err := json.Unmarshal(list[1], &field); err != nil || !strings.HasPrefix(field, "$") {
+			return fmt.Errorf("condition %s must name a field starting with $", data)
+		}
+		if err := json.Unmarshal(list[2], &value); err != nil {
+			return fmt.Errorf("condition %s must have a string value", data)
+		}
+		*c = PostPolicyCondition{Operator: operator, Field: strings.ToLower(field[1:]), Value: value}
+	case PostPolicyContentLengthRange:
+		minimum, err := parsePolicyInt(list[1])
+		if err != nil {
+			return fmt.Errorf("condition %s must have an integer minimum", data)
+		}
+		maximum, err := parsePolicyInt(list[2])
+		if err != nil {
+			return fmt.Errorf("condition %s must have an integer maximum", data)
+		}
+		if minimum < 0 || maximum < minimum {
+			return fmt.Errorf("condition %s must have a range of non-negative sizes", data)
+		}
+		*c = PostPolicyCondition{Operator: operator, Min: minimum, Max: maximum}
+	default:
+		return fmt.Errorf("condition %s has an unknown operator", data)
+	}
+	return nil
+}
+
+// parsePolicyInt parses a JSON integer, which some clients send as a string
+func parsePolicyInt(raw json.RawMessage) (int64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return 0, err
+		}
+		s = n.String()
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// MarshalJSON encodes the condition in its array form
+func (c PostPolicyCondition) MarshalJSON() ([]byte, error) {
+	if c.Operator == PostPolicyContentLengthRange {
+		return json.Marshal([]any{c.Operator, c.Min, c.Max})
+	}
+	return json.Marshal([]string{c.Operator, "$" + c.Field, c.Value})
+}
+
+// postPolicyUncheckedFields are the form fields that need no condition
+var postPolicyUncheckedFields = map[string]bool{
+	"file":            true,
+	"policy":          true,
+	"x-amz-signature": true,
+}
+
+// Check verifies the form fields of a POST upload against the policy at now
+// Field names are case-insensitive and bucket, the bucket of the upload, must be among them
+// Every field needs a condition apart from file, policy, x-amz-signature and those prefixed x-ignore-,
+// and fields a condition names but the form lacks are taken as empty
+func (p *PostPolicy) Check(form map[string]string, now time.Time) error {
+	if now.After(p.Expiration) {
+		return NewAuthError("AccessDenied", "Invalid according to Policy: Policy expired.")
+	}
+
+	fields := make(map[string]string, len(form))
+	for name, value := range form {
+		fields[strings.ToLower(name)] = value
+	}
+
+	covered := map[string]bool{}
+	for _, c := range p.Conditions {
+		if c.Operator == PostPolicyContentLengthRange {
+			continue
+		}
+		covered[c.Field] = true
+		if !c.matches(fields[c.Field]) {
+			condition, _ := json.Marshal(c)
+			return NewAuthError("AccessDenied", "Invalid according to Policy: Policy Condition failed: "+string(condition))
+		}
+	}
+
+	for name := range fields {
+		if postPolicyUncheckedFields[name] || strings.HasPrefix(name, "x-ignore-") || covered[name] {
+			continue
+		}
+		return NewAuthError("AccessDenied", "Invalid according to Policy: Extra input fields: "+name)
+	}
+	return nil
+}
+
+// matches reports whether value satisfies an eq or starts-with condition
+// Content-Type may list several types separated by commas, each of which must start with the prefix
+func (c PostPolicyCondition) matches(value string) bool {
+	if c.Operator == PostPolicyEq {
+		return value == c.Value
+	}
+	if c.Field == "content-type" {
+		for _, v := range strings.Split(value, ",") {
+			if !strings.HasPrefix(strings.TrimSpace(v), c.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return strings.HasPrefix(value, c.Value)
+}
+
+// ContentLengthRange returns the size bounds of the uploaded file, reporting false if the policy has none
+// Several ranges narrow each other
+func (p *PostPolicy) ContentLengthRange() (minimum, maximum int64, ok bool) {
+	for _, c := range p.Conditions {
+		if c.Operator != PostPolicyContentLengthRange {
+			continue
+		}
+		if !ok {
+			minimum, maximum, ok = c.Min, c.Max, true
+			continue
+		}
+		minimum = max(minimum, c.Min)
+		maximum = min(maximum, c.Max)
+	}
+	return minimum, maximum, ok
+}
+
+// CheckContentLength verifies the size of the uploaded file against the content-length-range of the policy
+func (p *PostPolicy) CheckContentLength(size int64) error {
+	minimum, maximum, ok := p.ContentLengthRange()
+	if !ok {
+		return nil
+	}
+	if size < minimum {
+		return NewAuthError("EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size")
+	}
+	if size > maximum {
+		return NewAuthError("EntityTooLarge", "Your proposed upload exceeds the maximum allowed size")
+	}
+	return nil
+}
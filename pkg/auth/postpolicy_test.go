@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePostPolicy(t *testing.T) {
+	policy, err := ParsePostPolicy([]byte(`{
+		"expiration": "2030-12-30T12:00:00.000Z",
+		"conditions": [
+			{"bucket": "sigv4examplebucket"},
+			["starts-with", "$key", "user/user1/"],
+			{"acl": "public-read"},
+			["eq", "$X-Amz-Algorithm", "AWS4-HMAC-SHA256"],
+			["content-length-range", 1048576, "10485760"]
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParsePostPolicy failed: %v", err)
+	}
+	if want := time.Date(2030, 12, 30, 12, 0, 0, 0, time.UTC); !policy.Expiration.Equal(want) {
+		t.Errorf("Expected expiration %v, got %v", want, policy.Expiration)
+	}
+	want := []PostPolicyCondition{
+		{Operator: PostPolicyEq, Field: "bucket", Value: "sigv4examplebucket"},
+		{Operator: PostPolicyStartsWith, Field: "key", Value: "user/user1/"},
+		{Operator: PostPolicyEq, Field: "acl", Value: "public-read"},
+		{Operator: PostPolicyEq, Field: "x-amz-algorithm", Value: "AWS4-HMAC-SHA256"},
+		{Operator: PostPolicyContentLengthRange, Min: 1048576, Max: 10485760},
+	}
+	if len(policy.Conditions) != len(want) {
+		t.Fatalf("Expected %d conditions, got %+v", len(want), policy.Conditions)
+	}
+	for i := range want {
+		if policy.Conditions[i] != want[i] {
+			t.Errorf("Condition %d: expected %+v, got %+v", i, want[i], policy.Conditions[i])
+		}
+	}
+
+	// Generated policies parse back to the same document
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	again, err := ParsePostPolicy(data)
+	if err != nil {
+		t.Fatalf("Failed to parse marshaled policy %s: %v", data, err)
+	}
+	if !again.Expiration.Equal(policy.Expiration) || len(again.Conditions) != len(want) {
+		t.Fatalf("Expected the marshaled policy to round-trip, got %s", data)
+	}
+	for i := range want {
+		if again.Conditions[i] != want[i] {
+			t.Errorf("Round-tripped condition %d: expected %+v, got %+v", i, want[i], again.Conditions[i])
+		}
+	}
+}
+
+func TestParsePostPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{"NotJSON", `policy`},
+		{"MissingExpiration", `{"conditions": []}`},
+		{"InvalidExpiration", `{"expiration": "tomorrow", "conditions": []}`},
+		{"UnknownOperator", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["ends-with", "$key", "x"]]}`},
+		{"FieldWithoutDollar", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["eq", "key", "x"]]}`},
+		{"TwoFields", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [{"key": "x", "acl": "y"}]}`},
+		{"NonStringValue", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [{"key": 1}]}`},
+		{"TooFewElements", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["eq", "$key"]]}`},
+		{"FractionalRange", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["content-length-range", 0, 1.5]]}`},
+		{"NegativeRange", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["content-length-range", -1, 10]]}`},
+		{"InvertedRange", `{"expiration": "2030-01-01T00:00:00.000Z", "conditions": [["content-length-range", 10, 1]]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePostPolicy([]byte(tt.policy))
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.Code != "InvalidPolicyDocument" {
+				t.Errorf("Expected InvalidPolicyDocument, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPostPolicyCheck(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := &PostPolicy{
+		Expiration: now.Add(time.Hour),
+		Conditions: []PostPolicyCondition{
+			{Operator: PostPolicyEq, Field: "bucket", Value: "uploads"},
+			{Operator: PostPolicyStartsWith, Field: "key", Value: "user/eric/"},
+			{Operator: PostPolicyStartsWith, Field: "content-type", Value: "image/"},
+			{Operator: PostPolicyStartsWith, Field: "x-amz-meta-tag", Value: ""},
+			{Operator: PostPolicyEq, Field: "acl", Value: "private"},
+			{Operator: PostPolicyContentLengthRange, Min: 1, Max: 100},
+		},
+	}
+	valid := func() map[string]string {
+		return map[string]string{
+			"bucket":          "uploads",
+			"Key":             "user/eric/photo.jpg",
+			"Content-Type":    "image/jpeg",
+			"acl":             "private",
+			"policy":          "eyJ9",
+			"X-Amz-Signature": "abc",
+			"x-ignore-submit": "Upload",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(form map[string]string)
+		at     time.Time
+		want   string
+	}{
+		{name: "Valid", modify: func(form map[string]string) {}},
+		{name: "AnyValueOfEmptyPrefix", modify: func(form map[string]string) { form["x-amz-meta-tag"] = "anything" }},
+		{name: "Expired", modify: func(form map[string]string) {}, at: now.Add(2 * time.Hour), want: "Invalid according to Policy: Policy expired."},
+		{name: "WrongBucket", modify: func(form map[string]string) { form["bucket"] = "other" }, want: `Invalid according to Policy: Policy Condition failed: ["eq","$bucket","uploads"]`},
+		{name: "WrongPrefix", modify: func(form map[string]string) { form["Key"] = "user/bob/photo.jpg" }, want: `Invalid according to Policy: Policy Condition failed: ["starts-with","$key","user/eric/"]`},
+		{name: "ExactMatchIsCaseSensitive", modify: func(form map[string]string) { form["acl"] = "Private" }, want: `Invalid according to Policy: Policy Condition failed: ["eq","$acl","private"]`},
+		{name: "MissingField", modify: func(form map[string]string) { delete(form, "acl") }, want: `Invalid according to Policy: Policy Condition failed: ["eq","$acl","private"]`},
+		{name: "EveryContentType", modify: func(form map[string]string) { form["Content-Type"] = "image/png, image/gif" }},
+		{name: "OneContentTypeOff", modify: func(form map[string]string) { form["Content-Type"] = "image/png, text/html" }, want: `Invalid according to Policy: Policy Condition failed: ["starts-with","$content-type","image/"]`},
+		{name: "ExtraField", modify: func(form map[string]string) { form["x-amz-meta-other"] = "value" }, want: "Invalid according to Policy: Extra input fields: x-amz-meta-other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := valid()
+			tt.modify(form)
+			at := tt.at
+			if at.IsZero() {
+				at = now
+			}
+			err := policy.Check(form, at)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Expected the form to satisfy the policy, got %v", err)
+				}
+				return
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.Code != "AccessDenied" || authErr.Message != tt.want {
+				t.Errorf("Expected AccessDenied %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestPostPolicyContentLength(t *testing.T) {
+	policy := &PostPolicy{Conditions: []PostPolicyCondition{
+		{Operator: PostPolicyContentLengthRange, Min: 10, Max: 1000},
+		{Operator: PostPolicyContentLengthRange, Min: 0, Max: 100},
+	}}
+	if minimum, maximum, ok := policy.ContentLengthRange(); !ok || minimum != 10 || maximum != 100 {
+		t.Errorf("Expected the ranges to narrow to 10-100, got %d-%d (%v)", minimum, maximum, ok)
+	}
+
+	tests := []struct {
+		size int64
+		code string
+	}{
+		{size: 9, code: "EntityTooSmall"},
+		{size: 10},
+		{size: 100},
+		{size: 101, code: "EntityTooLarge"},
+	}
+	for _, tt := range tests {
+		err := policy.CheckContentLength(tt.size)
+		var authErr *AuthError
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("Expected size %d to be allowed, got %v", tt.size, err)
+		case tt.code != "" && (!errors.As(err, &authErr) || authErr.Code != tt.code):
+			t.Errorf("Expected %s for size %d, got %v", tt.code, tt.size, err)
+		}
+	}
+
+	if err := (&PostPolicy{}).CheckContentLength(1 << 40); err != nil {
+		t.Errorf("Expected no limit without a content-length-range, got %v", err)
+	}
+}
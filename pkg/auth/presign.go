@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPresignExpires is the longest validity of a presigned URL, the same 7 days as AWS
+const MaxPresignExpires = 7 * 24 * time.Hour
+
+// presignFutureTolerance is how far in the future X-Amz-Date may be on top of the clock skew,
+// the 15 minutes AWS accepts, so that URLs presigned on a clock slightly ahead work right away
+const presignFutureTolerance = 15 * time.Minute
+
+// SetClock replaces the clock expiry of presigned URLs and temporary credentials is checked against,
+// time.Now by default
+func (a *AWS4Authenticator) SetClock(now func() time.Time) {
+	a.now = now
+}
+
+// SetClockSkew sets how far the clocks of clients may be off, extending the validity of presigned URLs
+// by skew on both ends
+func (a *AWS4Authenticator) SetClockSkew(skew time.Duration) {
+	a.clockSkew = skew
+}
+
+// checkPresignedTime checks X-Amz-Date and X-Amz-Expires of a presigned URL against the clock
+// credDate is the date of the credential scope, which must be the day of X-Amz-Date
+func (a *AWS4Authenticator) checkPresignedTime(date, credDate, expires string) error {
+	requestTime, err := parseAmzDate(date)
+	if err != nil {
+		return NewAuthError("AuthorizationQueryParametersError", `X-Amz-Date must be in the ISO8601 Long Format "yyyyMMdd'T'HHmmss'Z'"`)
+	}
+	if credDate != date[:8] {
+		return NewAuthError("AuthorizationQueryParametersError", "The date of X-Amz-Credential does not match X-Amz-Date")
+	}
+
+	now := a.now()
+	if requestTime.After(now.Add(a.clockSkew + presignFutureTolerance)) {
+		return NewAuthError("AccessDenied", "Request is not valid yet")
+	}
+
+	// SigV4 requires X-Amz-Expires, a URL without it would never expire
+	if expires == "" {
+		return NewAuthError("AuthorizationQueryParametersError", "Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters.")
+	}
+	seconds, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return NewAuthError("AuthorizationQueryParametersError", "X-Amz-Expires should be a number")
+	}
+	if seconds < 0 {
+		return NewAuthError("AuthorizationQueryParametersError", "X-Amz-Expires must be non-negative")
+	}
+	if seconds > int64(MaxPresignExpires/time.Second) {
+		return NewAuthError("AuthorizationQueryParametersError", "X-Amz-Expires must be less than a week (in seconds) that is 604800")
+	}
+
+	expirationTime := requestTime.Add(time.Duration(seconds)*time.Second + a.clockSkew)
+	if now.After(expirationTime) {
+		return NewAuthError("AccessDenied", "Presigned URL has expired")
+	}
+	return nil
+}
+
+// PresignURL returns u signed with the credentials for method requests to the region,
+// valid for expires from now and at most MaxPresignExpires
+// The URL is signed for its host, the Host header requests carry when sent to it directly
+func PresignURL(method string, u *url.URL, credentials Credentials, region string, now time.Time, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > MaxPresignExpires {
+		return "", fmt.Errorf("presigned URLs must expire within %v, got %v", MaxPresignExpires, expires)
+	}
+
+	date := now.UTC().Format("20060102T150405Z")
+	signed := *u
+	// The path of a request always starts with a slash, even if the URL leaves it out after the host
+	if !strings.HasPrefix(signed.Path, "/") {
+		signed.Path = "/" + signed.Path
+		signed.RawPath = ""
+	}
+	q := signed.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", fmt.Sprintf("%s/%s/%s/s3/aws4_request", credentials.AccessKeyID, date[:8], region))
+	q.Set("X-Amz-Date", date)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	signed.RawQuery = q.Encode()
+
+	r := &http.Request{Method: method, URL: &signed, Host: signed.Host}
+	signature, err := (&AWS4Authenticator{}).calculateSignatureV4Query(r, credentials.SecretAccessKey, date[:8], region, "s3", "host")
+	if err != nil {
+		return "", err
+	}
+	q.Set("X-Amz-Signature", signature)
+	signed.RawQuery = q.Encode()
+	return signed.String(), nil
+}
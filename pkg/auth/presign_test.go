@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckPresignedTime(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		skew     time.Duration
+		date     string
+		credDate string
+		expires  string
+		wantErr  string
+	}{
+		{"Valid", 0, "20230101T115000Z", "20230101", "3600", ""},
+		{"NoExpires", 0, "20230101T000000Z", "20230101", "", "Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters."},
+		{"Expired", 0, "20230101T100000Z", "20230101", "3600", "Presigned URL has expired"},
+		{"ExpiredWithinSkew", 2 * time.Hour, "20230101T100000Z", "20230101", "3600", ""},
+		{"MaxExpires", 0, "20230101T000000Z", "20230101", "604800", ""},
+		{"ExpiresTooLong", 0, "20230101T000000Z", "20230101", "604801", "X-Amz-Expires must be less than a week (in seconds) that is 604800"},
+		{"NegativeExpires", 0, "20230101T000000Z", "20230101", "-1", "X-Amz-Expires must be non-negative"},
+		{"InvalidExpires", 0, "20230101T000000Z", "20230101", "1h", "X-Amz-Expires should be a number"},
+		{"InvalidDate", 0, "2023-01-01T00:00:00Z", "20230101", "3600", `X-Amz-Date must be in the ISO8601 Long Format "yyyyMMdd'T'HHmmss'Z'"`},
+		{"CredentialDateMismatch", 0, "20230101T115000Z", "20221231", "3600", "The date of X-Amz-Credential does not match X-Amz-Date"},
+		{"SlightlyInFuture", 0, "20230101T121000Z", "20230101", "3600", ""},
+		{"InFuture", 0, "20230101T130000Z", "20230101", "3600", "Request is not valid yet"},
+		{"InFutureWithinSkew", time.Hour, "20230101T130000Z", "20230101", "3600", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewAWS4Authenticator()
+			auth.SetClock(func() time.Time { return now })
+			auth.SetClockSkew(tt.skew)
+
+			err := auth.checkPresignedTime(tt.date, tt.credDate, tt.expires)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected the URL to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateV4QueryClock(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+
+	req := httptest.NewRequest("GET", "/bucket/object", nil)
+	req.Host = "example.amazonaws.com"
+
+	q := req.URL.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", "test-key/20230101/us-east-1/s3/aws4_request")
+	q.Set("X-Amz-Date", "20230101T000000Z")
+	q.Set("X-Amz-Expires", "3600")
+	q.Set("X-Amz-SignedHeaders", "host")
+	req.URL.RawQuery = q.Encode()
+
+	signature, err := auth.calculateSignatureV4Query(req, "test-secret", "20230101", "us-east-1", "s3", "host")
+	if err != nil {
+		t.Fatalf("Failed to calculate signature: %v", err)
+	}
+	q.Set("X-Amz-Signature", signature)
+	req.URL.RawQuery = q.Encode()
+
+	for _, elapsed := range []time.Duration{0, time.Hour, time.Hour + time.Second} {
+		t.Run(fmt.Sprint(elapsed), func(t *testing.T) {
+			auth.SetClock(func() time.Time {
+				return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Add(elapsed)
+			})
+			_, err := auth.authenticate(req)
+			if elapsed <= time.Hour && err != nil {
+				t.Fatalf("Expected the URL to be valid after %v, got %v", elapsed, err)
+			}
+			if elapsed > time.Hour && err == nil {
+				t.Fatalf("Expected the URL to have expired after %v", elapsed)
+			}
+		})
+	}
+
+	t.Run("NoExpires", func(t *testing.T) {
+		auth.SetClock(func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) })
+		q := req.URL.Query()
+		q.Del("X-Amz-Expires")
+		q.Del("X-Amz-Signature")
+		unbounded := req.Clone(req.Context())
+		unbounded.URL.RawQuery = q.Encode()
+		signature, err := auth.calculateSignatureV4Query(unbounded, "test-secret", "20230101", "us-east-1", "s3", "host")
+		if err != nil {
+			t.Fatalf("Failed to calculate signature: %v", err)
+		}
+		q.Set("X-Amz-Signature", signature)
+		unbounded.URL.RawQuery = q.Encode()
+
+		var authErr *AuthError
+		if _, err := auth.authenticate(unbounded); !errors.As(err, &authErr) || authErr.Code != "AuthorizationQueryParametersError" {
+			t.Fatalf("Expected AuthorizationQueryParametersError for a URL without X-Amz-Expires, got %v", err)
+		}
+	})
+}
+
+func TestPresignURL(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	u := &url.URL{Scheme: "http", Host: "s3.example.com:8080", Path: "/bucket/dir/an object.txt"}
+	credentials := Credentials{AccessKeyID: "test-key", SecretAccessKey: "test-secret"}
+	presigned, err := PresignURL("GET", u, credentials, "us-east-1", now, time.Hour)
+	if err != nil {
+		t.Fatalf("PresignURL failed: %v", err)
+	}
+
+	for _, elapsed := range []time.Duration{0, time.Hour + time.Second} {
+		auth.SetClock(func() time.Time {
+			return now.Add(elapsed)
+		})
+		accessKeyID, err := auth.authenticate(httptest.NewRequest("GET", presigned, nil))
+		if elapsed == 0 && (err != nil || accessKeyID != "test-key") {
+			t.Errorf("Expected the presigned URL to be valid, got %q (%v)", accessKeyID, err)
+		}
+		if elapsed != 0 && err == nil {
+			t.Errorf("Expected the presigned URL to have expired after %v", elapsed)
+		}
+	}
+
+	credentials.SecretAccessKey = "wrong-secret"
+	presigned, err = PresignURL("GET", u, credentials, "us-east-1", now, time.Hour)
+	if err != nil {
+		t.Fatalf("PresignURL failed: %v", err)
+	}
+	auth.SetClock(func() time.Time { return now })
+	if _, err := auth.authenticate(httptest.NewRequest("GET", presigned, nil)); err == nil {
+		t.Error("Expected a URL signed with the wrong secret to be rejected")
+	}
+
+	if _, err := PresignURL("GET", u, credentials, "us-east-1", now, MaxPresignExpires+time.Second); err == nil {
+		t.Error("Expected an expiry past a week to be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// RegoPolicy is a Rego policy, evaluated with an embedded OPA for every request
+// The policy decides in the s3d package: a request is allowed only if data.s3d.allow is true,
+// and data.s3d.reason, if defined, is returned to the client as the error message of denied requests
+type RegoPolicy struct {
+	query rego.PreparedEvalQuery
+}
+
+// awsModule is the s3d.aws package evaluating the Condition elements of AWS bucket policies,
+// compiled along with every policy
+//
+//go:embed aws.rego
+var awsModule string
+
+// RegoInput is the input document of the policy, the request as sent to the authorization webhook with its tags
+type RegoInput struct {
+	WebhookRequest
+	// Tags are the tags of the x-amz-tagging header of the request
+	Tags map[string]string `json:"tags"`
+	// Conditions are the values of the condition keys of AWS bucket policies present in the request:
+	// aws:SourceIp, aws:Referer and, for object listings, s3:prefix
+	// Policies evaluate conditions on them with aws.condition of the bundled s3d.aws package
+	Conditions map[string]string `json:"conditions"`
+}
+
+// LoadRegoPolicy compiles the Rego files, data files and bundle directories at paths along with the s3d.aws package
+func LoadRegoPolicy(ctx context.Context, paths ...string) (*RegoPolicy, error) {
+	query, err := rego.New(
+		rego.Query("data.s3d"),
+		rego.Module("s3d/aws.rego", awsModule),
+		rego.Load(paths, nil),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Rego policy: %w", err)
+	}
+	return &RegoPolicy{query: query}, nil
+}
+
+// Middleware is HTTP middleware that rejects requests not allowed by the policy
+// It must run after AuthMiddleware so that the access key of the request is known
+// Requests are denied if the policy fails to evaluate
+func (p *RegoPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, reason, err := p.evaluate(r.Context(), regoInput(r))
+		if err != nil {
+			log.Printf("Failed to evaluate Rego policy: %v", err)
+			writeError(w, "AccessDenied", "Access Denied", http.StatusForbidden)
+			return
+		}
+		if !allowed {
+			if reason == "" {
+				reason = "Access Denied"
+			}
+			writeError(w, "AccessDenied", reason, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// regoInput returns the input document of r
+func regoInput(r *http.Request) RegoInput {
+	query, _ := url.ParseQuery(r.Header.Get("x-amz-tagging"))
+	tags := make(map[string]string, len(query))
+	for name := range query {
+		tags[name] = query.Get(name)
+	}
+	req := webhookRequest(r)
+	return RegoInput{WebhookRequest: req, Tags: tags, Conditions: conditionValues(r, req)}
+}
+
+// listObjectsParameters are the query parameters of ListObjects and ListObjectsV2
+var listObjectsParameters = []string{"list-type", "prefix", "delimiter", "marker", "max-keys", "continuation-token", "start-after", "fetch-owner", "encoding-type"}
+
+// conditionValues returns the values of the condition keys of r, described by req, keys absent from r are left out
+// Source addresses are unmapped, so that IPv4 clients of dual-stack listeners match IPv4 networks
+func conditionValues(r *http.Request, req WebhookRequest) map[string]string {
+	values := map[string]string{}
+	if addr, err := netip.ParseAddr(sourceIP(r)); err == nil {
+		values["aws:SourceIp"] = addr.Unmap().WithZone("").String()
+	}
+	if referer := r.Header.Get("Referer"); referer != "" {
+		values["aws:Referer"] = referer
+	}
+	if isListObjects(req) {
+		if query := r.URL.Query(); query.Has("prefix") {
+			values["s3:prefix"] = query.Get("prefix")
+		}
+	}
+	return values
+}
+
+// isListObjects reports whether req lists the objects of a bucket, the only operation taking s3:prefix
+func isListObjects(req WebhookRequest) bool {
+	if req.Method != http.MethodGet || req.Bucket == "" || req.Key != "" {
+		return false
+	}
+	for _, name := range req.Subresources {
+		if !containsString(listObjectsParameters, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate decides on input, returning the reason defined by the policy if any
+func (p *RegoPolicy) evaluate(ctx context.Context, input RegoInput) (bool, string, error) {
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return false, "", err
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		// The s3d package is not defined
+		return false, "", nil
+	}
+	decision, ok := results[0].Expressions[0].Value.(map[string]any)
+	if !ok {
+		return false, "", nil
+	}
+	allowed, _ := decision["allow"].(bool)
+	reason, _ := decision["reason"].(string)
+	return allowed, reason, nil
+}
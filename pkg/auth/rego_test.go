@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRegoPolicy = `package s3d
+
+default allow := false
+
+allow if {
+	input.method in {"GET", "HEAD"}
+	input.bucket == "public"
+	not secret
+}
+
+allow if {
+	input.accessKeyId == "writer"
+	startswith(input.bucket, "data-")
+	not input.tags.classification == "restricted"
+	not secret
+}
+
+secret if startswith(input.key, "secret/")
+
+reason := "secrets are off limits" if secret
+`
+
+func TestLoadRegoPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "policy.rego")
+	os.WriteFile(valid, []byte(testRegoPolicy), 0644)
+	if _, err := LoadRegoPolicy(context.Background(), valid); err != nil {
+		t.Fatalf("Failed to load Rego policy: %v", err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.rego")
+	os.WriteFile(invalid, []byte("package s3d\n\nallow if {"), 0644)
+	if _, err := LoadRegoPolicy(context.Background(), invalid); err == nil {
+		t.Error("Expected an error for an invalid Rego policy")
+	}
+
+	if _, err := LoadRegoPolicy(context.Background(), filepath.Join(dir, "missing.rego")); err == nil {
+		t.Error("Expected an error for a missing Rego policy")
+	}
+}
+
+func TestRegoPolicyMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	os.WriteFile(path, []byte(testRegoPolicy), 0644)
+	policy, err := LoadRegoPolicy(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Failed to load Rego policy: %v", err)
+	}
+	handler := policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, target, accessKeyID, tagging string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if accessKeyID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), accessKeyIDKey{}, accessKeyID))
+		}
+		if tagging != "" {
+			req.Header.Set("x-amz-tagging", tagging)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		accessKeyID string
+		tagging     string
+		want        int
+	}{
+		{"AnonymousRead", http.MethodGet, "/public/object", "", "", http.StatusOK},
+		{"AnonymousWrite", http.MethodPut, "/public/object", "", "", http.StatusForbidden},
+		{"NoMatchingRule", http.MethodGet, "/private/object", "", "", http.StatusForbidden},
+		{"WriterAllowed", http.MethodPut, "/data-1/object", "writer", "", http.StatusOK},
+		{"OtherKeyDenied", http.MethodPut, "/data-1/object", "reader", "", http.StatusForbidden},
+		{"SecretDenied", http.MethodGet, "/public/secret/key", "", "", http.StatusForbidden},
+		{"TagDenied", http.MethodPut, "/data-1/object", "writer", "classification=restricted&team=a", http.StatusForbidden},
+		{"OtherTagAllowed", http.MethodPut, "/data-1/object", "writer", "classification=public", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.target, tt.accessKeyID, tt.tagging)
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	rec := serve(http.MethodGet, "/public/secret/key", "", "")
+	if !strings.Contains(rec.Body.String(), "secrets are off limits") {
+		t.Errorf("Expected the policy reason in the error, got %s", rec.Body.String())
+	}
+
+	// A policy outside the s3d package denies every request
+	other := filepath.Join(t.TempDir(), "other.rego")
+	os.WriteFile(other, []byte("package other\n\nallow := true\n"), 0644)
+	policy, err = LoadRegoPolicy(context.Background(), other)
+	if err != nil {
+		t.Fatalf("Failed to load Rego policy: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/public/object", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a request to be denied without an s3d package, got %d", rec.Code)
+	}
+}
+
+// testRegoConditionsPolicy carries over the condition blocks of the bucket policy examples of the AWS documentation
+const testRegoConditionsPolicy = `package s3d
+
+import data.s3d.aws
+
+default allow := false
+
+# Restricting access to a specific HTTP referer
+allow if {
+	input.method == "GET"
+	input.bucket == "assets"
+	aws.condition({"StringLike": {"aws:Referer": ["https://example.com/*", "https://www.example.com/*"]}})
+}
+
+# Managing access based on specific IP addresses
+allow if {
+	input.bucket == "internal"
+	aws.condition({
+		"IpAddress": {"aws:SourceIp": ["10.1.0.0/16", "2001:db8::/32"]},
+		"NotIpAddress": {"aws:SourceIp": "10.1.2.3"},
+	})
+}
+
+# Granting users access to their own prefix of a shared bucket
+allow if {
+	input.bucket == "home"
+	aws.condition({"StringLike": {"s3:prefix": ["", "home/", concat("", ["home/", input.accessKeyId, "/*"])]}})
+}
+
+# Denying listings outside of a prefix unless no prefix is given
+allow if {
+	input.bucket == "reports"
+	aws.condition({"StringEqualsIfExists": {"s3:prefix": "public/"}})
+}
+
+# Denying requests without a referer
+allow if {
+	input.bucket == "hotlink"
+	aws.condition({"Null": {"aws:Referer": "false"}, "StringNotLike": {"aws:Referer": "https://spam.example/*"}})
+}
+`
+
+func TestRegoPolicyConditions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	os.WriteFile(path, []byte(testRegoConditionsPolicy), 0644)
+	policy, err := LoadRegoPolicy(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Failed to load Rego policy: %v", err)
+	}
+	handler := policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		target      string
+		accessKeyID string
+		remoteAddr  string
+		referer     string
+		want        int
+	}{
+		{"RefererAllowed", "/assets/logo.png", "", "192.0.2.1:1234", "https://example.com/index.html", http.StatusOK},
+		{"OtherRefererAllowed", "/assets/logo.png", "", "192.0.2.1:1234", "https://www.example.com/a/b", http.StatusOK},
+		{"OtherReferer", "/assets/logo.png", "", "192.0.2.1:1234", "https://example.org/", http.StatusForbidden},
+		{"MissingReferer", "/assets/logo.png", "", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"SourceIpAllowed", "/internal/report", "", "10.1.0.1:1234", "", http.StatusOK},
+		{"SourceIPv6Allowed", "/internal/report", "", "[2001:db8::1]:1234", "", http.StatusOK},
+		{"SourceIpMappedIPv4", "/internal/report", "", "[::ffff:10.1.0.1]:1234", "", http.StatusOK},
+		{"SourceIpExcluded", "/internal/report", "", "10.1.2.3:1234", "", http.StatusForbidden},
+		{"SourceIpOutside", "/internal/report", "", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"PrefixAllowed", "/home?list-type=2&prefix=home/alice/docs/", "alice", "192.0.2.1:1234", "", http.StatusOK},
+		{"EmptyPrefixAllowed", "/home?prefix=", "alice", "192.0.2.1:1234", "", http.StatusOK},
+		{"OtherPrefix", "/home?list-type=2&prefix=home/bob/", "alice", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"MissingPrefix", "/home?list-type=2", "alice", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"PrefixOfOtherOperation", "/home?uploads&prefix=home/alice/", "alice", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"PrefixIfExistsMatching", "/reports?prefix=public/", "", "192.0.2.1:1234", "", http.StatusOK},
+		{"PrefixIfExistsMissing", "/reports", "", "192.0.2.1:1234", "", http.StatusOK},
+		{"PrefixIfExistsOther", "/reports?prefix=private/", "", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"NullRefererPresent", "/hotlink/image.png", "", "192.0.2.1:1234", "https://example.com/", http.StatusOK},
+		{"NullRefererMissing", "/hotlink/image.png", "", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"NotLikeReferer", "/hotlink/image.png", "", "192.0.2.1:1234", "https://spam.example/page", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.accessKeyID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), accessKeyIDKey{}, tt.accessKeyID))
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Roles are the roles identity tokens may assume with AssumeRoleWithWebIdentity,
+// such as those of the service accounts of Kubernetes workloads projecting tokens for s3d
+type Roles struct {
+	// Roles are the roles by name, the last segment of the ARNs clients send
+	Roles map[string]Role `json:"roles"`
+}
+
+// Role maps the subjects of identity tokens to the groups of the credentials issued to them
+type Role struct {
+	// Subjects are patterns of the sub claim of the tokens that may assume the role, where "*" matches
+	// any sequence of characters, such as "system:serviceaccount:analytics:*" for a Kubernetes namespace
+	Subjects []string `json:"subjects"`
+	// Groups are attached to the credentials, so policies can grant permissions to the role
+	Groups []string `json:"groups,omitempty"`
+}
+
+// LoadRoles reads roles from a JSON file
+func LoadRoles(path string) (*Roles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roles Roles
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("invalid roles %s: %w", path, err)
+	}
+	for name, role := range roles.Roles {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid roles %s: invalid role name %q", path, name)
+		}
+		if len(role.Subjects) == 0 {
+			return nil, fmt.Errorf("invalid roles %s: role %q has no subjects", path, name)
+		}
+	}
+	return &roles, nil
+}
+
+// assume returns the role named by roleARN, either an ARN such as "arn:aws:iam::123456789012:role/NAME"
+// or the bare name, if subject may assume it
+func (r *Roles) assume(roleARN, subject string) (Role, bool) {
+	name := roleARN
+	if strings.HasPrefix(roleARN, "arn:") {
+		_, resource, ok := strings.Cut(roleARN, ":role/")
+		if !ok {
+			return Role{}, false
+		}
+		name = resource[strings.LastIndex(resource, "/")+1:]
+	}
+	role, ok := r.Roles[name]
+	if !ok || subject == "" || !matchAny(role.Subjects, subject) {
+		return Role{}, false
+	}
+	return role, true
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRoles(t *testing.T) {
+	dir := t.TempDir()
+	load := func(data string) (*Roles, error) {
+		path := filepath.Join(dir, "roles.json")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("Failed to write roles: %v", err)
+		}
+		return LoadRoles(path)
+	}
+
+	roles, err := load(`{"roles": {"analytics": {"subjects": ["system:serviceaccount:analytics:*"], "groups": ["analytics-readers"]}}}`)
+	if err != nil {
+		t.Fatalf("LoadRoles failed: %v", err)
+	}
+	if role := roles.Roles["analytics"]; len(role.Groups) != 1 || role.Groups[0] != "analytics-readers" {
+		t.Errorf("Expected the groups of the role, got %+v", role)
+	}
+
+	for _, data := range []string{
+		`not json`,
+		`{"roles": {"analytics": {"groups": ["analytics-readers"]}}}`,
+		`{"roles": {"team/analytics": {"subjects": ["*"]}}}`,
+	} {
+		if _, err := load(data); err == nil {
+			t.Errorf("Expected %s to be rejected", data)
+		}
+	}
+}
+
+func TestRolesAssume(t *testing.T) {
+	roles := &Roles{Roles: map[string]Role{
+		"analytics": {Subjects: []string{"system:serviceaccount:analytics:*", "system:serviceaccount:ops:backup"}},
+	}}
+
+	tests := []struct {
+		roleARN, subject string
+		want             bool
+	}{
+		{"arn:aws:iam::123456789012:role/analytics", "system:serviceaccount:analytics:reporter", true},
+		{"arn:aws:iam::123456789012:role/service/analytics", "system:serviceaccount:ops:backup", true},
+		{"analytics", "system:serviceaccount:analytics:reporter", true},
+		{"arn:aws:iam::123456789012:role/analytics", "system:serviceaccount:ops:deploy", false},
+		{"arn:aws:iam::123456789012:user/analytics", "system:serviceaccount:analytics:reporter", false},
+		{"arn:aws:iam::123456789012:role/billing", "system:serviceaccount:analytics:reporter", false},
+		{"analytics", "", false},
+	}
+	for _, tt := range tests {
+		if _, ok := roles.assume(tt.roleARN, tt.subject); ok != tt.want {
+			t.Errorf("Expected assuming %s as %q to be %v", tt.roleARN, tt.subject, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCredentialsDirInterval is the default interval between checks of a credentials directory
+const DefaultCredentialsDirInterval = 10 * time.Second
+
+// LoadCredentialsDir reads a directory of credentials, one file per access key holding its secret
+// Names starting with a dot are skipped, which covers the ..data links of mounted Kubernetes Secrets
+func LoadCredentialsDir(dir string) ([]Credentials, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []Credentials
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// Follow links, mounted Secrets expose their keys as links into a timestamped directory
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("empty secret for access key %q", name)
+		}
+		credentials = append(credentials, Credentials{AccessKeyID: name, SecretAccessKey: secret})
+	}
+	return credentials, nil
+}
+
+// SetWatchedCredentials atomically replaces the credentials loaded from a watched directory
+// They are kept apart from those of AddCredentials, so removing a file never revokes other credentials
+func (a *AWS4Authenticator) SetWatchedCredentials(credentials []Credentials) {
+	watched := make(map[string]string, len(credentials))
+	for _, cred := range credentials {
+		watched[cred.AccessKeyID] = cred.SecretAccessKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watched = watched
+}
+
+// WatchCredentialsDir loads the credentials of dir and applies its changes every interval until ctx is done
+// The directory is polled rather than watched for events, since mounted Secrets are updated by swapping a link
+// A directory that cannot be read, for instance in the middle of an update, keeps the previous credentials
+func (a *AWS4Authenticator) WatchCredentialsDir(ctx context.Context, dir string, interval time.Duration) error {
+	credentials, err := LoadCredentialsDir(dir)
+	if err != nil {
+		return err
+	}
+	a.SetWatchedCredentials(credentials)
+	last := credentialsFingerprint(credentials)
+
+	if interval <= 0 {
+		interval = DefaultCredentialsDirInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			credentials, err := LoadCredentialsDir(dir)
+			if err != nil {
+				log.Printf("Failed to reload credentials directory %s, keeping the previous credentials: %v", dir, err)
+				continue
+			}
+			fingerprint := credentialsFingerprint(credentials)
+			if fingerprint == last {
+				continue
+			}
+			a.SetWatchedCredentials(credentials)
+			last = fingerprint
+			log.Printf("Reloaded %d access keys from credentials directory %s", len(credentials), dir)
+		}
+	}()
+	return nil
+}
+
+// credentialsFingerprint summarizes credentials to detect changes without keeping secrets around
+func credentialsFingerprint(credentials []Credentials) [sha256.Size]byte {
+	sorted := append([]Credentials(nil), credentials...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].AccessKeyID < sorted[j].AccessKeyID })
+
+	hash := sha256.New()
+	for _, cred := range sorted {
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(cred.AccessKeyID), cred.AccessKeyID, len(cred.SecretAccessKey), cred.SecretAccessKey)
+	}
+	var sum [sha256.Size]byte
+	hash.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSecretDir lays out credentials like a mounted Kubernetes Secret,
+// files in a timestamped directory reached through the ..data link
+func writeSecretDir(t *testing.T, dir, version string, secrets map[string]string) {
+	t.Helper()
+	versionDir := filepath.Join(dir, "..", filepath.Base(dir)+"-"+version)
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, secret := range secrets {
+		if err := os.WriteFile(filepath.Join(versionDir, key), []byte(secret+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Swap the ..data link atomically, then link the keys through it
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(versionDir, tmpLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != "..data" {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	for key := range secrets {
+		if err := os.Symlink(filepath.Join("..data", key), filepath.Join(dir, key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadCredentialsDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "secret")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSecretDir(t, dir, "1", map[string]string{"key-a": "secret-a", "key-b": "secret-b"})
+
+	credentials, err := LoadCredentialsDir(dir)
+	if err != nil {
+		t.Fatalf("LoadCredentialsDir failed: %v", err)
+	}
+	if len(credentials) != 2 || credentials[0] != (Credentials{"key-a", "secret-a"}) || credentials[1] != (Credentials{"key-b", "secret-b"}) {
+		t.Errorf("Unexpected credentials: %+v", credentials)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "key-empty"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCredentialsDir(dir); err == nil {
+		t.Error("Expected an error for an empty secret")
+	}
+}
+
+func TestWatchCredentialsDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "secret")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSecretDir(t, dir, "1", map[string]string{"key-a": "secret-a"})
+
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("static-key", "static-secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := auth.WatchCredentialsDir(ctx, dir, 10*time.Millisecond); err != nil {
+		t.Fatalf("WatchCredentialsDir failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if secret, err := auth.secretAccessKey(req, "key-a"); err != nil || secret != "secret-a" {
+		t.Fatalf("Expected key-a from the directory, got %q, %v", secret, err)
+	}
+
+	// Keys are added and removed together, static credentials are kept
+	writeSecretDir(t, dir, "2", map[string]string{"key-b": "secret-b"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := auth.secretAccessKey(req, "key-b"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected key-b to be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := auth.secretAccessKey(req, "key-a"); err == nil {
+		t.Error("Expected key-a to be removed")
+	}
+	if _, err := auth.secretAccessKey(req, "static-key"); err != nil {
+		t.Errorf("Expected static credentials to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// calculatedSignaturePrefix is how many hex digits of the calculated signature of a mismatch are logged,
+// enough to tell signatures apart while the whole one would authenticate the logged request
+const calculatedSignaturePrefix = 8
+
+// securityTokenPattern matches the session tokens of canonical requests, in headers and presigned URLs
+var securityTokenPattern = regexp.MustCompile(`(?im)(^x-amz-security-token:|x-amz-security-token=)[^&\n]*`)
+
+// SetSignatureLog logs the canonical request and the string to sign of every request whose signature
+// does not match to logger, so that they can be compared with those of the client when debugging
+// SignatureDoesNotMatch errors of a new SDK; disabled if logger is nil
+// Session tokens are redacted and only a prefix of the calculated signature is logged
+// Each mismatch is one line, the canonical request and the string to sign being quoted
+func (a *AWS4Authenticator) SetSignatureLog(logger *log.Logger) {
+	a.signatureLog = logger
+}
+
+// logSignatureMismatch logs what the signature of r was checked against
+func (a *AWS4Authenticator) logSignatureMismatch(r *http.Request, accessKeyID, signature, canonicalRequest, stringToSign string, signingKey []byte) {
+	calculated := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+	a.signatureLog.Printf("Signature mismatch for access key %s on %s %s%s: canonical request %q, string to sign %q, provided signature %s, calculated signature %s...",
+		accessKeyID, r.Method, r.Host, r.URL.EscapedPath(),
+		securityTokenPattern.ReplaceAllString(canonicalRequest, "${1}REDACTED"),
+		stringToSign, signature, calculated[:calculatedSignaturePrefix])
+}
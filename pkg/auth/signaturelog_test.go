@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureLog(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.AddSession("session-key", "session-secret", "session-token", nil, time.Now().Add(time.Hour))
+
+	var buf bytes.Buffer
+	auth.SetSignatureLog(log.New(&buf, "", 0))
+	entries := func() []string {
+		t.Helper()
+		entries := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if entries[0] == "" {
+			entries = nil
+		}
+		buf.Reset()
+		return entries
+	}
+
+	t.Run("Header", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/bucket/object?tagging", nil)
+		signedHeaders := "host;x-amz-content-sha256;x-amz-date;x-amz-security-token"
+		req.Header.Set("X-Amz-Date", "20230101T000000Z")
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		req.Header.Set("X-Amz-Security-Token", "session-token")
+		wrong, _ := auth.calculateSignatureV4Header(req, "wrong-secret", "20230101", "us-east-1", "s3", signedHeaders)
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=session-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=%s, Signature=%s", signedHeaders, wrong))
+		if _, err := auth.authenticate(req); err == nil {
+			t.Fatal("Expected the signature to mismatch")
+		}
+
+		logged := entries()
+		if len(logged) != 1 {
+			t.Fatalf("Expected one log entry, got %v", logged)
+		}
+		entry := logged[0]
+		canonicalRequest, stringToSign := auth.stringToSignV4Header(req, "20230101", "us-east-1", "s3", signedHeaders)
+		calculated, _ := auth.calculateSignatureV4Header(req, "session-secret", "20230101", "us-east-1", "s3", signedHeaders)
+		if !strings.HasPrefix(entry, "Signature mismatch for access key session-key on PUT example.com/bucket/object: ") || !strings.Contains(entry, "provided signature "+wrong) {
+			t.Errorf("Expected the request to be described, got %q", entry)
+		}
+		if !strings.Contains(entry, "string to sign "+strconv.Quote(stringToSign)) {
+			t.Errorf("Expected string to sign %q, got %q", stringToSign, entry)
+		}
+		if want := strings.Replace(canonicalRequest, "x-amz-security-token:session-token", "x-amz-security-token:REDACTED", 1); !strings.Contains(entry, "canonical request "+strconv.Quote(want)) {
+			t.Errorf("Expected canonical request %q, got %q", want, entry)
+		}
+		if !strings.HasSuffix(entry, "calculated signature "+calculated[:calculatedSignaturePrefix]+"...") {
+			t.Errorf("Expected the prefix of %s, got %q", calculated, entry)
+		}
+		for _, secret := range []string{"session-token", "session-secret", calculated} {
+			if strings.Contains(entry, secret) {
+				t.Errorf("Expected %q to be kept out of the log, got %q", secret, entry)
+			}
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bucket/object?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=session-key%2F20230101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20230101T000000Z&X-Amz-Expires=3600&X-Amz-Security-Token=session-token&X-Amz-SignedHeaders=host&X-Amz-Signature=0000", nil)
+		auth.SetClock(func() time.Time { return time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC) })
+		defer auth.SetClock(time.Now)
+		if _, err := auth.authenticate(req); err == nil {
+			t.Fatal("Expected the signature to mismatch")
+		}
+
+		logged := entries()
+		if len(logged) != 1 {
+			t.Fatalf("Expected one log entry, got %v", logged)
+		}
+		if entry := logged[0]; !strings.Contains(entry, "X-Amz-Security-Token=REDACTED&") || strings.Contains(entry, "session-token") {
+			t.Errorf("Expected the session token of the query to be redacted, got %q", entry)
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		if _, err := auth.authenticate(req); err != nil {
+			t.Fatalf("Expected the request to authenticate: %v", err)
+		}
+		auth.SetSignatureLog(nil)
+		req.Header.Set("X-Amz-Date", "20230102T000000Z")
+		if _, err := auth.authenticate(req); err == nil {
+			t.Fatal("Expected the signature to mismatch")
+		}
+		if logged := entries(); len(logged) != 0 {
+			t.Errorf("Expected nothing to be logged, got %v", logged)
+		}
+	})
+}
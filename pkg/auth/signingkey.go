@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSigningKeys bounds how many derived signing keys are cached for the current signing date
+const maxSigningKeys = 4096
+
+// signingKeyID identifies a derived signing key
+type signingKeyID struct {
+	accessKeyID string
+	date        string
+	region      string
+	service     string
+}
+
+// cachedSigningKey is a derived signing key with the secret it was derived from,
+// so that a key is not used anymore once its secret is rotated
+type cachedSigningKey struct {
+	secretAccessKey string
+	key             []byte
+}
+
+// signingKeyCache caches the signing keys derived for the latest signing date requests were
+// authenticated with, saving the four HMACs of deriving them on every request
+// Keys are dropped when a request signed on a later date is authenticated, at date rollover
+// The zero signingKeyCache is empty and ready to use
+type signingKeyCache struct {
+	mu   sync.RWMutex
+	date string
+	keys map[signingKeyID]cachedSigningKey
+}
+
+// get returns the signing key of the access key for the date, region and service,
+// deriving it if it is not cached; it reports whether it was cached
+func (c *signingKeyCache) get(accessKeyID, secretAccessKey, date, region, service string) ([]byte, bool) {
+	c.mu.RLock()
+	cached, ok := c.keys[signingKeyID{accessKeyID, date, region, service}]
+	c.mu.RUnlock()
+	if ok && cached.secretAccessKey == secretAccessKey {
+		return cached.key, true
+	}
+	return CalculateSigningKey(secretAccessKey, date, region, service), false
+}
+
+// put caches a signing key once a request signed with it is authenticated, so that forged
+// requests can neither fill the cache nor flush it with dates in the future
+// Keys dated more than a day past now are not cached, so that a request signed with a far future
+// credential date cannot pin the cache to that date and keep every current key out of it
+func (c *signingKeyCache) put(accessKeyID, secretAccessKey, date, region, service string, key []byte, now time.Time) {
+	if date > now.UTC().Add(24*time.Hour).Format("20060102") {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case date < c.date:
+		return
+	case date > c.date || c.keys == nil:
+		c.date = date
+		c.keys = map[signingKeyID]cachedSigningKey{}
+	case len(c.keys) >= maxSigningKeys:
+		return
+	}
+	c.keys[signingKeyID{accessKeyID, date, region, service}] = cachedSigningKey{secretAccessKey, key}
+}
+
+// verifySignatureCached verifies the signature of r with the signing key of the access key,
+// taken from the cache and cached once verified
+func (a *AWS4Authenticator) verifySignatureCached(r *http.Request, accessKeyID, secretAccessKey, signature, date, region, service string, sign func(r *http.Request) (string, string)) error {
+	signingKey, cached := a.signingKeys.get(accessKeyID, secretAccessKey, date, region, service)
+	if err := a.verifySignature(r, accessKeyID, signature, signingKey, sign); err != nil {
+		return err
+	}
+	if cached {
+		metrics.Add("signing_key_hits", 1)
+	} else {
+		metrics.Add("signing_key_misses", 1)
+		a.signingKeys.put(accessKeyID, secretAccessKey, date, region, service, signingKey, a.now())
+	}
+	return nil
+}
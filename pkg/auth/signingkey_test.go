@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSigningKeyCache(t *testing.T) {
+	var cache signingKeyCache
+	now := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	derived := CalculateSigningKey("secret", "20230101", "us-east-1", "s3")
+
+	key, cached := cache.get("key", "secret", "20230101", "us-east-1", "s3")
+	if cached || !bytes.Equal(key, derived) {
+		t.Fatalf("Expected the key to be derived on a miss, got %x (cached %v)", key, cached)
+	}
+	cache.put("key", "secret", "20230101", "us-east-1", "s3", key, now)
+	if key, cached := cache.get("key", "secret", "20230101", "us-east-1", "s3"); !cached || !bytes.Equal(key, derived) {
+		t.Errorf("Expected the key to be cached, got %x (cached %v)", key, cached)
+	}
+	if _, cached := cache.get("key", "rotated", "20230101", "us-east-1", "s3"); cached {
+		t.Error("Expected the key of a rotated secret not to be cached")
+	}
+	if _, cached := cache.get("key", "secret", "20230101", "eu-west-1", "s3"); cached {
+		t.Error("Expected the key of another region not to be cached")
+	}
+
+	// Keys of earlier dates are not cached anymore once a later date is seen
+	cache.put("key", "secret", "20230102", "us-east-1", "s3", CalculateSigningKey("secret", "20230102", "us-east-1", "s3"), now)
+	if _, cached := cache.get("key", "secret", "20230101", "us-east-1", "s3"); cached {
+		t.Error("Expected the keys of the previous date to be evicted")
+	}
+	cache.put("key", "secret", "20230101", "us-east-1", "s3", derived, now)
+	if _, cached := cache.get("key", "secret", "20230101", "us-east-1", "s3"); cached {
+		t.Error("Expected a key of an earlier date not to be cached")
+	}
+	if _, cached := cache.get("key", "secret", "20230102", "us-east-1", "s3"); !cached {
+		t.Error("Expected the key of the latest date to stay cached")
+	}
+
+	// A far future date neither evicts the keys of the current date nor is cached
+	cache.put("key", "secret", "20991231", "us-east-1", "s3", CalculateSigningKey("secret", "20991231", "us-east-1", "s3"), now)
+	if _, cached := cache.get("key", "secret", "20991231", "us-east-1", "s3"); cached {
+		t.Error("Expected a key of a far future date not to be cached")
+	}
+	if _, cached := cache.get("key", "secret", "20230102", "us-east-1", "s3"); !cached {
+		t.Error("Expected the key of the current date to stay cached after a far future date")
+	}
+
+	for i := range maxSigningKeys + 10 {
+		cache.put(fmt.Sprintf("key-%d", i), "secret", "20230102", "us-east-1", "s3", derived, now)
+	}
+	if len(cache.keys) != maxSigningKeys {
+		t.Errorf("Expected the cache to be capped at %d keys, got %d", maxSigningKeys, len(cache.keys))
+	}
+}
+
+func TestAuthenticateCachesSigningKeys(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+
+	// A forged signature does not get its key cached
+	req := httptest.NewRequest("GET", "/bucket/object", nil)
+	signRequestForHost(t, auth, req, "forged.example.com", "us-east-1")
+	if _, err := auth.authenticate(req); err == nil {
+		t.Fatal("Expected the forged signature to be rejected")
+	}
+	if _, cached := auth.signingKeys.get("test-key", "test-secret", "20230101", "us-east-1", "s3"); cached {
+		t.Error("Expected the key of a rejected request not to be cached")
+	}
+
+	for i := range 2 {
+		req := httptest.NewRequest("GET", "/bucket/object", nil)
+		signRequestForHost(t, auth, req, req.Host, "us-east-1")
+		if _, err := auth.authenticate(req); err != nil {
+			t.Fatalf("Expected request %d to authenticate: %v", i, err)
+		}
+		if _, cached := auth.signingKeys.get("test-key", "test-secret", "20230101", "us-east-1", "s3"); !cached {
+			t.Errorf("Expected the key to be cached after request %d", i)
+		}
+	}
+
+	// Requests signed with a rotated secret are rejected despite the cached key
+	auth.AddCredentials("test-key", "rotated-secret")
+	req = httptest.NewRequest("GET", "/bucket/object", nil)
+	signRequestForHost(t, auth, req, req.Host, "us-east-1")
+	if _, err := auth.authenticate(req); err == nil {
+		t.Error("Expected a request signed with the previous secret to be rejected")
+	}
+}
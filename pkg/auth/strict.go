@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/proxy"
+)
+
+// ErrContentSHA256Mismatch is returned when a payload does not match its signed x-amz-content-sha256
+var ErrContentSHA256Mismatch = errors.New("payload does not match x-amz-content-sha256")
+
+// SetStrict enables the strict security mode of regulated environments, rejecting requests sent over
+// plain HTTP, payloads not covered by the signature, and signatures not covering the Content-Type
+// and the Content-Length of payloads sent; payloads are checked against the hash they were signed with
+// Requests authenticated by a client certificate or with Basic credentials carry no signature
+// and only need to be sent over TLS
+func (a *AWS4Authenticator) SetStrict(strict bool) {
+	a.strict = strict
+}
+
+// rejectsInsecure reports whether r is rejected in strict mode for being sent over plain HTTP
+func (a *AWS4Authenticator) rejectsInsecure(r *http.Request) bool {
+	return a.strict && !proxy.Secure(r)
+}
+
+// checkSignedPayload rejects in strict mode the requests whose payload or content headers are not signed
+func (a *AWS4Authenticator) checkSignedPayload(r *http.Request, signedHeaders string, isQueryAuth bool) error {
+	if !a.strict {
+		return nil
+	}
+	// Presigned URLs cannot sign a payload, so they may only be used for requests without one
+	if isQueryAuth {
+		if r.ContentLength != 0 {
+			return NewAuthError("AccessDenied", "Presigned URLs cannot be used to send a payload")
+		}
+		return nil
+	}
+
+	switch payloadHash := r.Header.Get("X-Amz-Content-Sha256"); {
+	case payloadHash == streamingPayloadHash:
+	case payloadHash == "" || strings.HasPrefix(payloadHash, "UNSIGNED-PAYLOAD") || strings.HasPrefix(payloadHash, "STREAMING-UNSIGNED-PAYLOAD"):
+		return NewAuthError("AccessDenied", "Unsigned payloads are not accepted")
+	default:
+		if sum, err := hex.DecodeString(payloadHash); err != nil || len(sum) != sha256.Size {
+			return NewAuthError("InvalidArgument", "x-amz-content-sha256 must be the SHA-256 of the payload or "+streamingPayloadHash)
+		}
+	}
+
+	// The length of requests without a payload, which clients do not sign, has nothing to protect
+	signed := strings.Split(signedHeaders, ";")
+	if r.Header.Get("Content-Type") != "" && !containsString(signed, "content-type") {
+		return NewAuthError("AccessDenied", "The Content-Type header must be signed")
+	}
+	if r.ContentLength > 0 && !containsString(signed, "content-length") {
+		return NewAuthError("AccessDenied", "The Content-Length header must be signed")
+	}
+	return nil
+}
+
+// maxBufferedPayloadSize is the size up to which payloads are read and verified in strict mode
+// before the request is handled, which covers every configuration document
+const maxBufferedPayloadSize = 1 << 20
+
+// verifyPayload checks in strict mode the body of r against the hash it was signed with
+// Payloads of up to maxBufferedPayloadSize are read and verified before r is handled, so handlers
+// that stop reading early, such as XML decoders, never see a payload that does not match its hash
+// Larger payloads are verified as they are read and fail with ErrContentSHA256Mismatch at their end;
+// handlers storing them must read them to their end and discard them on error before committing,
+// as storage writes do with their temporary files
+// Streaming uploads are checked chunk by chunk against their chunk signatures instead
+func (a *AWS4Authenticator) verifyPayload(r *http.Request) (*http.Request, error) {
+	if !a.strict || r.Body == nil || r.Body == http.NoBody || IsChunkedUpload(r) {
+		return r, nil
+	}
+	expected, err := hex.DecodeString(r.Header.Get("X-Amz-Content-Sha256"))
+	if err != nil {
+		return r, nil
+	}
+
+	if r.ContentLength < 0 || r.ContentLength > maxBufferedPayloadSize {
+		r = r.Clone(r.Context())
+		r.Body = &payloadVerifier{ReadCloser: r.Body, hash: sha256.New(), expected: expected}
+		return r, nil
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedPayloadSize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], expected) {
+		return nil, ErrContentSHA256Mismatch
+	}
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+	return r, nil
+}
+
+// payloadVerifier hashes the body it reads and fails at its end if it does not match the signed hash
+type payloadVerifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+// Read reads from the body, returning ErrContentSHA256Mismatch instead of io.EOF on a mismatch
+func (v *payloadVerifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hash.Sum(nil), v.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrict(t *testing.T) {
+	auth := NewAWS4Authenticator()
+	auth.AddCredentials("test-key", "test-secret")
+	auth.SetStrict(true)
+
+	var body []byte
+	var readErr error
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, readErr = io.ReadAll(r.Body)
+	}))
+
+	// sign signs a PUT of payload over TLS claiming payloadHash, covering the headers of signedHeaders
+	sign := func(payload, payloadHash, signedHeaders string) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/bucket/object", strings.NewReader(payload))
+		req.TLS = &tls.ConnectionState{}
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Length", fmt.Sprint(len(payload)))
+		req.Header.Set("X-Amz-Date", "20230101T000000Z")
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		signature, err := auth.calculateSignatureV4Header(req, "test-secret", "20230101", "us-east-1", "s3", signedHeaders)
+		if err != nil {
+			t.Fatalf("Failed to calculate signature: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=test-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=%s, Signature=%s", signedHeaders, signature))
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		body, readErr = nil, nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const allHeaders = "content-length;content-type;host;x-amz-content-sha256;x-amz-date"
+	sum := sha256.Sum256([]byte("data"))
+	payloadHash := hex.EncodeToString(sum[:])
+
+	if rec := serve(sign("data", payloadHash, allHeaders)); rec.Code != http.StatusOK || readErr != nil || string(body) != "data" {
+		t.Fatalf("Expected a signed payload to be accepted, got %d (%v): %s", rec.Code, readErr, rec.Body.String())
+	}
+	if rec := serve(sign("tampered", payloadHash, allHeaders)); rec.Code != http.StatusBadRequest || body != nil || !strings.Contains(rec.Body.String(), "XAmzContentSHA256Mismatch") {
+		t.Errorf("Expected a payload not matching its hash to be rejected before it is handled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Payloads too large to be buffered are verified as they are read
+	large := strings.Repeat("a", maxBufferedPayloadSize+1)
+	largeSum := sha256.Sum256([]byte(large))
+	if rec := serve(sign(large, hex.EncodeToString(largeSum[:]), allHeaders)); rec.Code != http.StatusOK || readErr != nil || string(body) != large {
+		t.Errorf("Expected a large signed payload to be accepted, got %d (%v)", rec.Code, readErr)
+	}
+	if rec := serve(sign(large, payloadHash, allHeaders)); rec.Code != http.StatusOK || !errors.Is(readErr, ErrContentSHA256Mismatch) {
+		t.Errorf("Expected a large payload not matching its hash to fail reading, got %d (%v)", rec.Code, readErr)
+	}
+
+	// Clients do not sign the length of requests without a payload
+	empty := sha256.Sum256(nil)
+	if rec := serve(sign("", hex.EncodeToString(empty[:]), "content-type;host;x-amz-content-sha256;x-amz-date")); rec.Code != http.StatusOK {
+		t.Errorf("Expected a request without a payload to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		name, payloadHash, signedHeaders, want string
+	}{
+		{"UnsignedPayload", "UNSIGNED-PAYLOAD", allHeaders, "Unsigned payloads are not accepted"},
+		{"UnsignedStreaming", "STREAMING-UNSIGNED-PAYLOAD-TRAILER", allHeaders, "Unsigned payloads are not accepted"},
+		{"InvalidHash", "not-a-hash", allHeaders, "<Code>InvalidArgument</Code>"},
+		{"UnsignedContentType", payloadHash, "content-length;host;x-amz-content-sha256;x-amz-date", "The Content-Type header must be signed"},
+		{"UnsignedContentLength", payloadHash, "content-type;host;x-amz-content-sha256;x-amz-date", "The Content-Length header must be signed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(sign("data", tt.payloadHash, tt.signedHeaders)); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("Expected %q, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("PlainHTTP", func(t *testing.T) {
+		req := sign("data", payloadHash, allHeaders)
+		req.TLS = nil
+		if rec := serve(req); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "HTTPS") {
+			t.Errorf("Expected a request over plain HTTP to be rejected, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Presigned", func(t *testing.T) {
+		auth.SetClock(func() time.Time { return time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC) })
+		defer auth.SetClock(time.Now)
+		presign := func(method string, payload io.Reader) *http.Request {
+			req := httptest.NewRequest(method, "/bucket/object?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test-key%2F20230101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20230101T000000Z&X-Amz-Expires=3600&X-Amz-SignedHeaders=host", payload)
+			req.TLS = &tls.ConnectionState{}
+			signature, err := auth.calculateSignatureV4Query(req, "test-secret", "20230101", "us-east-1", "s3", "host")
+			if err != nil {
+				t.Fatalf("Failed to calculate signature: %v", err)
+			}
+			req.URL.RawQuery += "&X-Amz-Signature=" + signature
+			return req
+		}
+		if rec := serve(presign(http.MethodGet, nil)); rec.Code != http.StatusOK {
+			t.Errorf("Expected a presigned GET to be accepted, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve(presign(http.MethodPut, strings.NewReader("data"))); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected a presigned PUT of a payload to be rejected, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		auth.SetStrict(false)
+		defer auth.SetStrict(true)
+		req := sign("data", "UNSIGNED-PAYLOAD", "host;x-amz-content-sha256;x-amz-date")
+		req.TLS = nil
+		if rec := serve(req); rec.Code != http.StatusOK {
+			t.Errorf("Expected an unsigned payload over plain HTTP to be accepted, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stsNamespace is the XML namespace of STS responses
+const stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// ErrInvalidToken is returned when an identity token cannot be verified
+var ErrInvalidToken = errors.New("invalid identity token")
+
+// OIDCVerifier verifies RS256 ID tokens issued by an OpenID Connect provider
+// The signing keys are discovered from the issuer, or read from a configured key set,
+// and refreshed when a token uses an unknown key
+type OIDCVerifier struct {
+	issuer   string
+	audience string
+	jwks     string // URL or path of the key set, discovered from the issuer if empty
+	client   *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey // kid -> key, replaced rather than modified by fetches
+	fetched  time.Time                 // when the key set was last fetched
+	fetchErr error                     // error of the last fetch
+	fetching chan struct{}             // closed once the fetch in flight completes, nil if none
+}
+
+// minKeyRefresh is the shortest interval between fetches of the key set, however many unknown keys tokens use
+const minKeyRefresh = time.Minute
+
+// NewOIDCVerifier creates a verifier accepting tokens of issuer intended for audience
+func NewOIDCVerifier(issuer, audience string) *OIDCVerifier {
+	return &OIDCVerifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// SetJWKS reads the signing keys from the JSON Web Key Set at location, an http(s) URL or the path of a file,
+// instead of discovering them from the issuer, for issuers such as Kubernetes API servers whose discovery
+// endpoints are not reachable anonymously; files are read again when a token uses an unknown key
+func (v *OIDCVerifier) SetJWKS(location string) {
+	v.jwks = location
+}
+
+// Verify checks the signature, issuer, audience and validity period of token and returns its claims
+func (v *OIDCVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+	}
+	if !containsString(stringsClaim(claims, "aud"), v.audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now >= exp {
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// key returns the signing key with the given ID, fetching the key set again if it is unknown
+// Fetches happen without holding the lock and at most once per minKeyRefresh, tokens with unknown keys
+// waiting for the fetch in flight, so tokens of made up keys neither block verification nor flood the issuer
+func (v *OIDCVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	if key, ok := v.keys[kid]; ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+	fetching := v.fetching
+	if fetching == nil && (v.fetched.IsZero() || time.Since(v.fetched) >= minKeyRefresh) {
+		fetching = make(chan struct{})
+		v.fetching = fetching
+		v.fetched = time.Now()
+		v.mu.Unlock()
+
+		keys, err := v.fetchKeys()
+
+		v.mu.Lock()
+		if err == nil {
+			v.keys = keys
+		}
+		v.fetchErr = err
+		v.fetching = nil
+		close(fetching)
+		v.mu.Unlock()
+	} else {
+		v.mu.Unlock()
+		if fetching != nil {
+			<-fetching
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.fetchErr != nil {
+		return nil, v.fetchErr
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// fetchKeys retrieves the RSA keys of the issuer from the configured key set,
+// or through OpenID Connect discovery
+func (v *OIDCVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	jwksURI := v.jwks
+	if jwksURI == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		jwksURI = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if strings.HasPrefix(jwksURI, "http://") || strings.HasPrefix(jwksURI, "https://") {
+		if err := v.getJSON(jwksURI, &jwks); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := os.ReadFile(jwksURI)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &jwks); err != nil {
+			return nil, fmt.Errorf("invalid key set %s: %w", jwksURI, err)
+		}
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// getJSON decodes the JSON document at url into v
+func (v *OIDCVerifier) getJSON(url string, out any) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// stringsClaim returns a claim that is either a string or a list of strings
+func stringsClaim(claims map[string]any, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// STS issues temporary credentials in exchange for OpenID Connect ID tokens,
+// implementing the AssumeRoleWithWebIdentity action of the AWS Security Token Service
+// The groups claim of the token is attached to the credentials, so policies can grant permissions per group
+type STS struct {
+	authenticator *AWS4Authenticator
+	verifier      *OIDCVerifier
+	groupsClaim   string
+	maxDuration   time.Duration
+	roles         *Roles // roles tokens must assume, any token is exchanged if nil
+}
+
+// NewSTS creates an STS adding credentials valid for at most maxDuration to authenticator
+func NewSTS(authenticator *AWS4Authenticator, verifier *OIDCVerifier, groupsClaim string, maxDuration time.Duration) *STS {
+	return &STS{
+		authenticator: authenticator,
+		verifier:      verifier,
+		groupsClaim:   groupsClaim,
+		maxDuration:   maxDuration,
+	}
+}
+
+// SetRoles requires tokens to assume one of roles, named by the RoleArn parameter, whose groups
+// are attached to the credentials besides those of the groups claim
+func (s *STS) SetRoles(roles *Roles) {
+	s.roles = roles
+}
+
+// AssumeRoleWithWebIdentityResponse is the response of the AssumeRoleWithWebIdentity action
+type AssumeRoleWithWebIdentityResponse struct {
+	XMLName          xml.Name                        `xml:"AssumeRoleWithWebIdentityResponse"`
+	Xmlns            string                          `xml:"xmlns,attr"`
+	Result           AssumeRoleWithWebIdentityResult `xml:"AssumeRoleWithWebIdentityResult"`
+	ResponseMetadata struct {
+		RequestId string `xml:"RequestId"`
+	} `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleWithWebIdentityResult holds the issued credentials
+type AssumeRoleWithWebIdentityResult struct {
+	Credentials                 STSCredentials `xml:"Credentials"`
+	SubjectFromWebIdentityToken string         `xml:"SubjectFromWebIdentityToken"`
+	Audience                    string         `xml:"Audience"`
+	Provider                    string         `xml:"Provider"`
+}
+
+// STSCredentials are temporary credentials
+type STSCredentials struct {
+	AccessKeyId     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// Middleware is HTTP middleware answering STS requests, which are form POSTs to the root path
+// It must run before AuthMiddleware, since these requests are authenticated by the identity token instead of a signature
+func (s *STS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/" ||
+			!strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeError(w, "InvalidParameterValue", "Invalid form body", http.StatusBadRequest)
+			return
+		}
+		if action := r.PostForm.Get("Action"); action != "AssumeRoleWithWebIdentity" {
+			writeError(w, "InvalidAction", fmt.Sprintf("Could not find operation %s", action), http.StatusBadRequest)
+			return
+		}
+		s.assumeRoleWithWebIdentity(w, r)
+	})
+}
+
+// assumeRoleWithWebIdentity exchanges the WebIdentityToken of the form for temporary credentials
+func (s *STS) assumeRoleWithWebIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.verifier.Verify(r.PostForm.Get("WebIdentityToken"))
+	if err != nil {
+		writeError(w, "InvalidIdentityToken", err.Error(), http.StatusBadRequest)
+		return
+	}
+	subject, _ := claims["sub"].(string)
+	groups := stringsClaim(claims, s.groupsClaim)
+	if s.roles != nil {
+		role, ok := s.roles.assume(r.PostForm.Get("RoleArn"), subject)
+		if !ok {
+			writeError(w, "AccessDenied", "Not authorized to perform sts:AssumeRoleWithWebIdentity", http.StatusForbidden)
+			return
+		}
+		groups = append(groups, role.Groups...)
+	}
+
+	duration := s.maxDuration
+	if value := r.PostForm.Get("DurationSeconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			writeError(w, "InvalidParameterValue", "Invalid DurationSeconds", http.StatusBadRequest)
+			return
+		}
+		if d := time.Duration(seconds) * time.Second; d < duration {
+			duration = d
+		}
+	}
+	// Credentials do not outlive the token they were issued for
+	if exp := time.Unix(int64(claims["exp"].(float64)), 0); time.Until(exp) < duration {
+		duration = time.Until(exp)
+	}
+
+	credentials := STSCredentials{
+		AccessKeyId:     "ASIA" + strings.ToUpper(randomHex(8)),
+		SecretAccessKey: randomHex(20),
+		SessionToken:    randomHex(32),
+		Expiration:      time.Now().Add(duration).UTC().Truncate(time.Second),
+	}
+	s.authenticator.AddSession(credentials.AccessKeyId, credentials.SecretAccessKey, credentials.SessionToken,
+		groups, credentials.Expiration)
+
+	resp := AssumeRoleWithWebIdentityResponse{
+		Xmlns: stsNamespace,
+		Result: AssumeRoleWithWebIdentityResult{
+			Credentials:                 credentials,
+			SubjectFromWebIdentityToken: subject,
+			Audience:                    s.verifier.audience,
+			Provider:                    s.verifier.issuer,
+		},
+	}
+	resp.ResponseMetadata.RequestId = w.Header().Get("x-amz-request-id")
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return
+	}
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID Connect provider signing tokens with a generated key
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": issuer.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(issuer.jwks())
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// jwks returns the key set of the issuer
+func (i *testIssuer) jwks() []byte {
+	data, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+	return data
+}
+
+// token signs claims, filling in the issuer unless they have one
+func (i *testIssuer) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = i.URL
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := NewOIDCVerifier(issuer.URL, "s3d")
+	exp := time.Now().Add(time.Hour).Unix()
+
+	if _, err := verifier.Verify(issuer.token(t, map[string]any{"sub": "alice", "aud": "s3d", "exp": exp})); err != nil {
+		t.Fatalf("Expected token to verify: %v", err)
+	}
+	if _, err := verifier.Verify(issuer.token(t, map[string]any{"sub": "alice", "aud": []string{"other", "s3d"}, "exp": exp})); err != nil {
+		t.Errorf("Expected token with audience list to verify: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"WrongAudience", issuer.token(t, map[string]any{"aud": "other", "exp": exp})},
+		{"Expired", issuer.token(t, map[string]any{"aud": "s3d", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{"Tampered", issuer.token(t, map[string]any{"aud": "s3d", "exp": exp}) + "x"},
+		{"Malformed", "not-a-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.Verify(tt.token); err == nil {
+				t.Error("Expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierJWKS(t *testing.T) {
+	// Kubernetes issuers are not reachable, their key set is exported to a file
+	const issuerURL = "https://kubernetes.default.svc.cluster.local"
+	issuer := newTestIssuer(t)
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwks, []byte(`{"keys":[]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write key set: %v", err)
+	}
+	verifier := NewOIDCVerifier(issuerURL, "s3d")
+	verifier.SetJWKS(jwks)
+	token := issuer.token(t, map[string]any{
+		"iss": issuerURL,
+		"sub": "system:serviceaccount:analytics:reporter",
+		"aud": []string{"s3d"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	if _, err := verifier.Verify(token); err == nil {
+		t.Fatal("Expected a token of a key missing from the key set to fail")
+	}
+	// The file is read again for keys it did not have, following key rotation, once the refresh interval passed
+	if err := os.WriteFile(jwks, issuer.jwks(), 0o600); err != nil {
+		t.Fatalf("Failed to write key set: %v", err)
+	}
+	if _, err := verifier.Verify(token); err == nil {
+		t.Fatal("Expected the key set not to be read again within the refresh interval")
+	}
+	verifier.fetched = verifier.fetched.Add(-minKeyRefresh)
+	if _, err := verifier.Verify(token); err != nil {
+		t.Errorf("Expected the token to verify against the rotated key set: %v", err)
+	}
+
+	verifier = NewOIDCVerifier(issuerURL, "s3d")
+	verifier.SetJWKS(issuer.URL + "/keys")
+	if _, err := verifier.Verify(token); err != nil {
+		t.Errorf("Expected the token to verify against the key set URL: %v", err)
+	}
+}
+
+func TestOIDCVerifierKeyRefreshLimited(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer keys.Close()
+	verifier := NewOIDCVerifier("https://issuer.example.com", "s3d")
+	verifier.SetJWKS(keys.URL)
+
+	// Tokens of unknown keys share the fetch in flight, without holding the lock during it
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			if _, err := verifier.key(fmt.Sprintf("unknown-%d", i)); err == nil {
+				t.Errorf("Expected an unknown key to fail")
+			}
+		})
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Known keys are still served while the key set is fetched
+	verifier.mu.Lock()
+	verifier.keys = map[string]*rsa.PublicKey{"known": &rsa.PublicKey{}}
+	verifier.mu.Unlock()
+	if _, err := verifier.key("known"); err != nil {
+		t.Errorf("Expected a known key during the fetch, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if _, err := verifier.key("another"); err == nil {
+		t.Error("Expected an unknown key to fail")
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected a single fetch within the refresh interval, got %d", got)
+	}
+}
+
+func TestSTSAssumeRoleWithWebIdentity(t *testing.T) {
+	issuer := newTestIssuer(t)
+	authenticator := NewAWS4Authenticator()
+	sts := NewSTS(authenticator, NewOIDCVerifier(issuer.URL, "s3d"), "groups", time.Hour)
+
+	var accessKeyID string
+	var groups []string
+	handler := sts.Middleware(authenticator.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKeyID = AccessKeyIDFromContext(r.Context())
+		groups = GroupsFromContext(r.Context())
+	})))
+
+	assume := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"Action":           {"AssumeRoleWithWebIdentity"},
+			"WebIdentityToken": {token},
+			"DurationSeconds":  {"900"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := assume("invalid"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an invalid token, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	token := issuer.token(t, map[string]any{
+		"sub":    "alice",
+		"aud":    "s3d",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"readers", "writers"},
+	})
+	rec := assume(token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp AssumeRoleWithWebIdentityResponse
+	if err := xml.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	creds := resp.Result.Credentials
+	if resp.Result.SubjectFromWebIdentityToken != "alice" {
+		t.Errorf("Expected subject alice, got %q", resp.Result.SubjectFromWebIdentityToken)
+	}
+	if d := time.Until(creds.Expiration); d > 15*time.Minute || d < 14*time.Minute {
+		t.Errorf("Expected credentials to expire in 15 minutes, got %v", d)
+	}
+
+	sign := func(sessionToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/bucket/object", nil)
+		signedHeaders := "host;x-amz-content-sha256;x-amz-date;x-amz-security-token"
+		req.Header.Set("X-Amz-Date", "20230101T000000Z")
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+		signature, err := authenticator.calculateSignatureV4Header(req, creds.SecretAccessKey, "20230101", "us-east-1", "s3", signedHeaders)
+		if err != nil {
+			t.Fatalf("Failed to calculate signature: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/20230101/us-east-1/s3/aws4_request, SignedHeaders=%s, Signature=%s", creds.AccessKeyId, signedHeaders, signature))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := sign(creds.SessionToken); rec.Code != http.StatusOK {
+		t.Fatalf("Expected temporary credentials to authenticate, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if accessKeyID != creds.AccessKeyId {
+		t.Errorf("Expected access key ID %s, got %q", creds.AccessKeyId, accessKeyID)
+	}
+	if len(groups) != 2 || groups[0] != "readers" || groups[1] != "writers" {
+		t.Errorf("Expected groups from the token, got %v", groups)
+	}
+
+	if rec := sign("wrong-token"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "InvalidToken") {
+		t.Errorf("Expected InvalidToken for a wrong session token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	authenticator.AddSession(creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken, nil, time.Now().Add(-time.Second))
+	if rec := sign(creds.SessionToken); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "ExpiredToken") {
+		t.Errorf("Expected ExpiredToken for expired credentials, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSTSRoles(t *testing.T) {
+	issuer := newTestIssuer(t)
+	authenticator := NewAWS4Authenticator()
+	sts := NewSTS(authenticator, NewOIDCVerifier(issuer.URL, "s3d"), "groups", time.Hour)
+	sts.SetRoles(&Roles{Roles: map[string]Role{
+		"analytics": {Subjects: []string{"system:serviceaccount:analytics:*"}, Groups: []string{"analytics-readers"}},
+	}})
+	handler := sts.Middleware(http.NotFoundHandler())
+
+	assume := func(roleARN, subject string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"Action":          {"AssumeRoleWithWebIdentity"},
+			"RoleArn":         {roleARN},
+			"RoleSessionName": {"session"},
+			"WebIdentityToken": {issuer.token(t, map[string]any{
+				"sub": subject,
+				"aud": "s3d",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			})},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := assume("arn:aws:iam::123456789012:role/analytics", "system:serviceaccount:analytics:reporter")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the service account to assume the role, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp AssumeRoleWithWebIdentityResponse
+	if err := xml.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	authenticator.mu.RLock()
+	session := authenticator.sessions[resp.Result.Credentials.AccessKeyId]
+	authenticator.mu.RUnlock()
+	if len(session.groups) != 1 || session.groups[0] != "analytics-readers" {
+		t.Errorf("Expected the credentials to have the groups of the role, got %v", session.groups)
+	}
+
+	tests := []struct {
+		name, roleARN, subject string
+	}{
+		{"OtherNamespace", "arn:aws:iam::123456789012:role/analytics", "system:serviceaccount:billing:reporter"},
+		{"UnknownRole", "arn:aws:iam::123456789012:role/billing", "system:serviceaccount:analytics:reporter"},
+		{"MissingRole", "", "system:serviceaccount:analytics:reporter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := assume(tt.roleARN, tt.subject); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "AccessDenied") {
+				t.Errorf("Expected AccessDenied, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 
 // Error represents an S3 error response
 type Error struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    string   `xml:"Code"`
-	Message string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestId string   `xml:"RequestId,omitempty"`
+	HostId    string   `xml:"HostId,omitempty"`
 }
 
 // AuthError represents an authentication error with specific error code
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookRequest is the body sent to the authorization webhook
+type WebhookRequest struct {
+	// AccessKeyID is the access key the request was signed with, empty for anonymous requests
+	AccessKeyID string `json:"accessKeyId"`
+	// Groups are the identity provider groups of temporary credentials
+	Groups []string `json:"groups,omitempty"`
+	Method string   `json:"method"`
+	Bucket string   `json:"bucket"`
+	Key    string   `json:"key"`
+	// Subresources are the names of the query parameters, such as "uploads" or "tagging"
+	Subresources []string `json:"subresources,omitempty"`
+	// SourceIP is the IP address of the client, without the port which changes with every connection
+	SourceIP string `json:"sourceIp"`
+}
+
+// WebhookResponse is the decision returned by the authorization webhook
+type WebhookResponse struct {
+	Allow bool `json:"allow"`
+	// Reason is returned to the client as the error message of denied requests
+	Reason string `json:"reason,omitempty"`
+	// Constraints further restrict allowed requests
+	Constraints WebhookConstraints `json:"constraints,omitempty"`
+}
+
+// WebhookConstraints restrict requests allowed by the authorization webhook
+type WebhookConstraints struct {
+	// MaxContentLength is the largest request body allowed, no limit if 0
+	MaxContentLength int64 `json:"maxContentLength,omitempty"`
+}
+
+// maxWebhookDecisions bounds the number of cached decisions, the least recently used are evicted first
+const maxWebhookDecisions = 4096
+
+// webhookDecision is a cached webhook response
+type webhookDecision struct {
+	key      string
+	response WebhookResponse
+	expires  time.Time
+}
+
+// WebhookAuthorizer delegates authorization decisions to an external HTTP endpoint
+// Requests are described as a WebhookRequest POSTed as JSON, and the endpoint answers with a WebhookResponse
+// Decisions are cached per WebhookRequest for the configured TTL, so every field the webhook
+// may base its decision on, including the groups and the client address, is part of the cache key
+type WebhookAuthorizer struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu    sync.Mutex
+	order *list.List               // cached decisions, most recently used first
+	cache map[string]*list.Element // request body -> element of order
+}
+
+// NewWebhookAuthorizer creates an authorizer calling url, caching decisions for ttl (no caching if 0)
+func NewWebhookAuthorizer(url string, ttl time.Duration) *WebhookAuthorizer {
+	return &WebhookAuthorizer{
+		url: url,
+		ttl: ttl,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		order: list.New(),
+		cache: map[string]*list.Element{},
+	}
+}
+
+// Middleware is HTTP middleware that rejects requests denied by the webhook
+// It must run after AuthMiddleware so that the access key of the request is known
+// Requests are denied when the webhook cannot be reached, failing closed
+func (a *WebhookAuthorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision, err := a.authorize(r.Context(), webhookRequest(r))
+		if err != nil {
+			writeError(w, "AccessDenied", "Access Denied", http.StatusForbidden)
+			return
+		}
+		if !decision.Allow {
+			message := decision.Reason
+			if message == "" {
+				message = "Access Denied"
+			}
+			writeError(w, "AccessDenied", message, http.StatusForbidden)
+			return
+		}
+
+		if limit := decision.Constraints.MaxContentLength; limit > 0 {
+			contentLength := r.ContentLength
+			// Chunk signatures of aws-chunked uploads do not count towards the object size
+			if decoded, err := strconv.ParseInt(r.Header.Get("x-amz-decoded-content-length"), 10, 64); err == nil {
+				contentLength = decoded
+			}
+			if contentLength > limit {
+				writeError(w, "AccessDenied", fmt.Sprintf("Request body exceeds the allowed %d bytes", limit), http.StatusForbidden)
+				return
+			}
+			// Bodies without a declared length are capped while reading
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webhookRequest describes r for the webhook
+func webhookRequest(r *http.Request) WebhookRequest {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+
+	query := r.URL.Query()
+	subresources := make([]string, 0, len(query))
+	for name := range query {
+		// Signature parameters of presigned URLs and SDK operation hints are not subresources
+		if strings.HasPrefix(name, "X-Amz-") || name == "x-id" {
+			continue
+		}
+		subresources = append(subresources, name)
+	}
+	sort.Strings(subresources)
+
+	return WebhookRequest{
+		AccessKeyID:  AccessKeyIDFromContext(r.Context()),
+		Groups:       GroupsFromContext(r.Context()),
+		Method:       r.Method,
+		Bucket:       bucket,
+		Key:          key,
+		Subresources: subresources,
+		SourceIP:     sourceIP(r),
+	}
+}
+
+// sourceIP returns the IP address of the client that sent r
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authorize returns the webhook decision for req, from the cache if possible
+// The call to the webhook is abandoned when ctx, the context of the client request, is done
+func (a *WebhookAuthorizer) authorize(ctx context.Context, req WebhookRequest) (WebhookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+	// The body sent to the webhook holds every field its decision may depend on
+	cacheKey := string(body)
+
+	if a.ttl > 0 {
+		if response, ok := a.cached(cacheKey); ok {
+			return response, nil
+		}
+	}
+
+	response, err := a.call(ctx, body)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	if a.ttl > 0 {
+		a.store(cacheKey, response)
+	}
+	return response, nil
+}
+
+// cached returns the unexpired decision cached for key
+func (a *WebhookAuthorizer) cached(key string) (WebhookResponse, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	elem, ok := a.cache[key]
+	if !ok {
+		return WebhookResponse{}, false
+	}
+	decision := elem.Value.(*webhookDecision)
+	if !time.Now().Before(decision.expires) {
+		a.order.Remove(elem)
+		delete(a.cache, key)
+		return WebhookResponse{}, false
+	}
+	a.order.MoveToFront(elem)
+	return decision.response, true
+}
+
+// store caches the decision for key, evicting the least recently used decisions beyond maxWebhookDecisions
+func (a *WebhookAuthorizer) store(key string, response WebhookResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	expires := time.Now().Add(a.ttl)
+	if elem, ok := a.cache[key]; ok {
+		decision := elem.Value.(*webhookDecision)
+		decision.response, decision.expires = response, expires
+		a.order.MoveToFront(elem)
+		return
+	}
+	a.cache[key] = a.order.PushFront(&webhookDecision{key: key, response: response, expires: expires})
+	for a.order.Len() > maxWebhookDecisions {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.cache, oldest.Value.(*webhookDecision).key)
+	}
+}
+
+// call asks the webhook for a decision on the JSON encoded WebhookRequest body
+func (a *WebhookAuthorizer) call(ctx context.Context, body []byte) (WebhookResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return WebhookResponse{}, fmt.Errorf("authorization webhook returned status %d", resp.StatusCode)
+	}
+
+	var response WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return WebhookResponse{}, err
+	}
+	return response, nil
+}
+
+// writeError writes an S3 error response, including the request IDs assigned by an outer middleware
+func writeError(w http.ResponseWriter, code, message string, status int) {
+	errResp := Error{
+		Code:      code,
+		Message:   message,
+		RequestId: w.Header().Get("x-amz-request-id"),
+		HostId:    w.Header().Get("x-amz-id-2"),
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return
+	}
+	xml.NewEncoder(w).Encode(errResp)
+}
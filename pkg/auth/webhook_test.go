@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookAuthorizer(t *testing.T) {
+	var calls atomic.Int32
+	var lastRequest WebhookRequest
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lastRequest = req
+
+		var resp WebhookResponse
+		switch req.Bucket {
+		case "office":
+			// Decisions based on the client address must not be replayed to other clients
+			resp.Allow = req.SourceIP == "10.0.0.1"
+		case "slow":
+			<-r.Context().Done()
+			return
+		case "public":
+			resp.Allow = true
+		case "limited":
+			resp.Allow = true
+			resp.Constraints.MaxContentLength = 4
+		case "broken":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		default:
+			resp.Reason = "bucket is private"
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer webhook.Close()
+
+	authorizer := NewWebhookAuthorizer(webhook.URL, time.Minute)
+	handler := authorizer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Allow", func(t *testing.T) {
+		if rec := serve(http.MethodGet, "/public/dir/object?tagging", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if lastRequest.Bucket != "public" || lastRequest.Key != "dir/object" || len(lastRequest.Subresources) != 1 || lastRequest.Subresources[0] != "tagging" {
+			t.Errorf("Unexpected webhook request: %+v", lastRequest)
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		before := calls.Load()
+		serve(http.MethodGet, "/public/dir/object?tagging", "")
+		if calls.Load() != before {
+			t.Error("Expected the decision to be served from the cache")
+		}
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/private/object", "")
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "bucket is private") {
+			t.Errorf("Expected the webhook reason in the error, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("Constraints", func(t *testing.T) {
+		if rec := serve(http.MethodPut, "/limited/object", "data"); rec.Code != http.StatusOK {
+			t.Errorf("Expected body within limit to be allowed, got %d", rec.Code)
+		}
+		if rec := serve(http.MethodPut, "/limited/object", "too much data"); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected body over limit to be denied, got %d", rec.Code)
+		}
+	})
+
+	t.Run("CachedPerClient", func(t *testing.T) {
+		if rec := serve(http.MethodGet, "/office/object", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the office address to be allowed, got %d", rec.Code)
+		}
+		serveFrom := func(remoteAddr string) int {
+			req := httptest.NewRequest(http.MethodGet, "/office/object", nil)
+			req.RemoteAddr = remoteAddr
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		// New connections of the same client reuse the decision
+		before := calls.Load()
+		if code := serveFrom("10.0.0.1:5678"); code != http.StatusOK || calls.Load() != before {
+			t.Errorf("Expected the decision to come from the cache for another port, got %d after %d calls", code, calls.Load()-before)
+		}
+		if lastRequest.SourceIP != "10.0.0.1" {
+			t.Errorf("Expected the source IP without port, got %q", lastRequest.SourceIP)
+		}
+
+		if code := serveFrom("192.0.2.1:1234"); code != http.StatusForbidden {
+			t.Errorf("Expected the decision for another address not to come from the cache, got %d", code)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/slow/object", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || time.Since(start) > 2*time.Second {
+			t.Errorf("Expected the webhook call to end with the client request, got %d after %v", rec.Code, time.Since(start))
+		}
+	})
+
+	t.Run("FailClosed", func(t *testing.T) {
+		if rec := serve(http.MethodGet, "/broken/object", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d when the webhook fails, got %d", http.StatusForbidden, rec.Code)
+		}
+	})
+}
+
+func TestWebhookDecisionCacheBounded(t *testing.T) {
+	authorizer := NewWebhookAuthorizer("http://127.0.0.1:0", time.Minute)
+	for i := range maxWebhookDecisions + 10 {
+		authorizer.store(fmt.Sprint(i), WebhookResponse{Allow: true})
+	}
+	if len(authorizer.cache) != maxWebhookDecisions || authorizer.order.Len() != maxWebhookDecisions {
+		t.Errorf("Expected %d cached decisions, got %d", maxWebhookDecisions, len(authorizer.cache))
+	}
+	if _, ok := authorizer.cached("0"); ok {
+		t.Error("Expected the least recently used decision to be evicted")
+	}
+	if _, ok := authorizer.cached(fmt.Sprint(maxWebhookDecisions + 9)); !ok {
+		t.Error("Expected the latest decision to be cached")
+	}
+}
@@ -0,0 +1,357 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/proxy"
+	"github.com/wzshiming/s3d/pkg/server"
+)
+
+// forwardedHeader marks requests forwarded by another node, which are served locally
+// Its value is the forwarding node, the unix time, the client IP, whether the client used TLS,
+// and an HMAC of them and of the method and URI of the request under the secret shared by the nodes,
+// so clients cannot skip the routing to write buckets onto other nodes, nor claim another address
+const forwardedHeader = "X-S3d-Cluster-Forwarded"
+
+// forwardedMaxAge is how long the signature of a forwarded request is accepted, covering clock differences of nodes
+const forwardedMaxAge = 5 * time.Minute
+
+// Cluster routes requests to the nodes owning their buckets
+// Each bucket lives on exactly one node, so a node that is down makes its buckets unavailable
+// until it is back, rather than moving them to another node that does not have their data
+// The ring follows the nodes known by the membership; a joining node becomes the owner of buckets
+// of its ring segments, whose data stays on their previous owner, so nodes join before buckets are created
+type Cluster struct {
+	self    string
+	secret  []byte
+	vnodes  int
+	members *Membership
+	client  *http.Client
+
+	mu          sync.Mutex
+	ringNodes   *Ring
+	ringVersion uint64
+	proxies     map[string]*httputil.ReverseProxy
+}
+
+// Status is the JSON document served at the status endpoint
+type Status struct {
+	Node  string   `json:"node"`
+	Nodes []string `json:"nodes"`
+	Alive []string `json:"alive"`
+}
+
+// New creates the cluster of the nodes of members, each placed at vnodes points of the ring
+func New(members *Membership, vnodes int) *Cluster {
+	return &Cluster{
+		self:    members.self,
+		secret:  members.secret,
+		vnodes:  vnodes,
+		members: members,
+		client:  &http.Client{},
+		proxies: map[string]*httputil.ReverseProxy{},
+	}
+}
+
+// ring returns the ring of the nodes currently known, rebuilt when a node joined
+func (c *Cluster) ring() *Ring {
+	nodes, version := c.members.Nodes()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ringNodes == nil || c.ringVersion != version {
+		c.ringNodes = NewRing(nodes, c.vnodes)
+		c.ringVersion = version
+	}
+	return c.ringNodes
+}
+
+// proxy returns the reverse proxy forwarding requests to node
+func (c *Cluster) proxy(node string) *httputil.ReverseProxy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if proxy, ok := c.proxies[node]; ok {
+		return proxy
+	}
+	// Nodes are validated base URLs
+	target, _ := url.Parse(node)
+	// The Host header is kept, so request signatures stay valid on the owning node
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if unreachable(r.Context(), err) {
+			c.members.markDown(node)
+		}
+		writeError(w, "ServiceUnavailable", "The node owning the bucket is unreachable", http.StatusServiceUnavailable)
+	}
+	c.proxies[node] = proxy
+	return proxy
+}
+
+// Middleware forwards requests for buckets owned by other nodes, and merges bucket listings of all nodes
+func (c *Cluster) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == statusPath {
+			if r.Method == http.MethodPost {
+				c.members.ServeGossip(w, r)
+				return
+			}
+			c.handleStatus(w, r)
+			return
+		}
+		if forwarded := r.Header.Get(forwardedHeader); forwarded != "" {
+			// Routing a request with a bad signature again could forward it back and forth between nodes
+			client, ok := c.verifyForwarded(r, forwarded, time.Now())
+			if !ok {
+				writeError(w, "AccessDenied", "Invalid cluster forwarding signature", http.StatusForbidden)
+				return
+			}
+			// Lockouts, network rules and policies see the client rather than the forwarding node
+			next.ServeHTTP(w, client)
+			return
+		}
+
+		bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if bucket == "" {
+			if r.Method == http.MethodGet {
+				c.handleListBuckets(w, r)
+				return
+			}
+			// Other requests to the root, such as STS, are served locally
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner := c.ring().Owner(bucket)
+		if owner == c.self {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.members.Alive(owner) {
+			writeError(w, "ServiceUnavailable", "The node owning the bucket is down", http.StatusServiceUnavailable)
+			return
+		}
+		c.signForwarded(r, r, time.Now())
+		c.proxy(owner).ServeHTTP(w, r)
+	})
+}
+
+// handleStatus serves the view of the cluster of this node
+func (c *Cluster) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	nodes, _ := c.members.Nodes()
+	json.NewEncoder(w).Encode(Status{
+		Node:  c.self,
+		Nodes: nodes,
+		Alive: c.members.AliveNodes(),
+	})
+}
+
+// handleListBuckets sends the signed ListBuckets request to every live node and merges the results
+// Each node applies the prefix, continuation token and limit to its own buckets,
+// so the first max-buckets names of the merged list are the page of the whole cluster
+func (c *Cluster) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	maxBuckets := 10000
+	if mb := r.URL.Query().Get("max-buckets"); mb != "" {
+		if parsed, err := strconv.Atoi(mb); err == nil && parsed > 0 {
+			maxBuckets = parsed
+		}
+	}
+
+	nodes := c.members.AliveNodes()
+	results := make([]*server.ListAllMyBucketsResult, len(nodes))
+	failures := make([]*http.Response, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], failures[i] = c.listBuckets(r, node)
+		}()
+	}
+	wg.Wait()
+
+	for i, failure := range failures {
+		if failure == nil {
+			continue
+		}
+		// Relay the first failure, such as a rejected signature, as is
+		copyResponse(w, failure)
+		for _, other := range failures[i+1:] {
+			if other != nil {
+				other.Body.Close()
+			}
+		}
+		return
+	}
+	for i, result := range results {
+		if result == nil {
+			writeError(w, "ServiceUnavailable", fmt.Sprintf("Cluster node %s is unreachable", nodes[i]), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	merged := server.ListAllMyBucketsResult{
+		Owner:  results[0].Owner,
+		Prefix: results[0].Prefix,
+	}
+	truncated := false
+	seen := map[string]bool{}
+	for _, result := range results {
+		truncated = truncated || result.ContinuationToken != ""
+		for _, b := range result.Buckets.Bucket {
+			if !seen[b.Name] {
+				seen[b.Name] = true
+				merged.Buckets.Bucket = append(merged.Buckets.Bucket, b)
+			}
+		}
+	}
+	buckets := merged.Buckets.Bucket
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	if len(buckets) > maxBuckets {
+		buckets = buckets[:maxBuckets]
+		truncated = true
+	}
+	merged.Buckets.Bucket = buckets
+	if truncated && len(buckets) > 0 {
+		merged.ContinuationToken = buckets[len(buckets)-1].Name
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(merged)
+}
+
+// listBuckets forwards the ListBuckets request r to node
+// It returns the parsed result, or the response of the node if it did not succeed,
+// or neither if the node could not be reached
+func (c *Cluster) listBuckets(r *http.Request, node string) (*server.ListAllMyBucketsResult, *http.Response) {
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.URL.Scheme, req.URL.Host, _ = strings.Cut(node, "://")
+	c.signForwarded(req, r, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if unreachable(r.Context(), err) {
+			c.members.markDown(node)
+		}
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp
+	}
+	defer resp.Body.Close()
+
+	var result server.ListAllMyBucketsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// signForwarded marks r, received from client, as forwarded by this node at now
+// client is the request as received, its address already recovered from trusted proxies
+func (c *Cluster) signForwarded(r, client *http.Request, now time.Time) {
+	host, _, err := net.SplitHostPort(client.RemoteAddr)
+	if err != nil {
+		host = client.RemoteAddr
+	}
+	scheme := "http"
+	if proxy.Secure(client) {
+		scheme = "https"
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	fields := []string{c.self, timestamp, host, scheme}
+	r.Header.Set(forwardedHeader, strings.Join(append(fields, c.forwardedSignature(r, fields)), " "))
+}
+
+// verifyForwarded checks that forwarded, the forwardedHeader of r, was signed by a node recently,
+// and returns r as sent by the client the node received it from
+// Nodes also send bucket listings to themselves
+func (c *Cluster) verifyForwarded(r *http.Request, forwarded string, now time.Time) (*http.Request, bool) {
+	parts := strings.Fields(forwarded)
+	if len(parts) != 5 || !c.members.Known(parts[0]) {
+		return nil, false
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > forwardedMaxAge || age < -forwardedMaxAge {
+		return nil, false
+	}
+	if !hmac.Equal([]byte(parts[4]), []byte(c.forwardedSignature(r, parts[:4]))) {
+		return nil, false
+	}
+	ip := net.ParseIP(parts[2])
+	if ip == nil || (parts[3] != "http" && parts[3] != "https") {
+		return nil, false
+	}
+	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		port = "0"
+	}
+	return proxy.WithClient(r, net.JoinHostPort(ip.String(), port), parts[3] == "https"), true
+}
+
+// forwardedSignature returns the HMAC of the request r forwarded with the fields of its forwardedHeader
+func (c *Cluster) forwardedSignature(r *http.Request, fields []string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(strings.Join(fields, "\n") + "\n" + r.Method + "\n" + r.URL.RequestURI()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unreachable reports whether err, returned forwarding a request with ctx, means the node could not be reached
+// Requests cancelled by their client, or failing for other reasons, leave the liveness of nodes to the heartbeat
+func unreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// copyResponse writes the response of a node to w
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// writeError writes an S3 error response
+func writeError(w http.ResponseWriter, code, message string, status int) {
+	errResp := server.Error{
+		Code:      code,
+		Message:   message,
+		RequestId: w.Header().Get("x-amz-request-id"),
+		HostId:    w.Header().Get("x-amz-id-2"),
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return
+	}
+	xml.NewEncoder(w).Encode(errResp)
+}
@@ -0,0 +1,342 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/proxy"
+	"github.com/wzshiming/s3d/pkg/server"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// testNode is a node of a test cluster
+type testNode struct {
+	url     string
+	store   *storage.Storage
+	members *Membership
+	cluster *Cluster
+	server  *httptest.Server
+}
+
+// startCluster starts n nodes serving each other, each seeded with all nodes
+func startCluster(t *testing.T, n int) []*testNode {
+	t.Helper()
+	return startNodes(t, n, func(urls []string, i int) []string { return urls })
+}
+
+// startNodes starts n nodes seeded with the nodes returned by seeds for the node at index i
+func startNodes(t *testing.T, n int, seeds func(urls []string, i int) []string) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, n)
+	urls := make([]string, n)
+	for i := range nodes {
+		ts := httptest.NewUnstartedServer(nil)
+		nodes[i] = &testNode{url: "http://" + ts.Listener.Addr().String(), server: ts}
+		urls[i] = nodes[i].url
+	}
+
+	for i, node := range nodes {
+		store, err := storage.NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		node.store = store
+		members, err := NewMembership(node.url, seeds(urls, i), []byte("cluster-secret"), 0)
+		if err != nil {
+			t.Fatalf("Failed to create membership: %v", err)
+		}
+		node.members = members
+		node.cluster = New(members, 0)
+		node.server.Config.Handler = node.cluster.Middleware(server.NewS3Handler(store))
+		node.server.Start()
+		t.Cleanup(node.server.Close)
+	}
+	return nodes
+}
+
+func doRequest(t *testing.T, method, url string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	return resp
+}
+
+func TestClusterForwarding(t *testing.T) {
+	nodes := startCluster(t, 3)
+	ring := NewRing([]string{nodes[0].url, nodes[1].url, nodes[2].url}, 0)
+
+	// Every request goes to the first node, which forwards it to the owner
+	for i := 0; i < 10; i++ {
+		bucket := fmt.Sprintf("bucket-%d", i)
+		resp := doRequest(t, http.MethodPut, nodes[0].url+"/"+bucket, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create %s: %d", bucket, resp.StatusCode)
+		}
+		resp = doRequest(t, http.MethodPut, nodes[0].url+"/"+bucket+"/key", strings.NewReader(bucket))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to put into %s: %d", bucket, resp.StatusCode)
+		}
+
+		for _, node := range nodes {
+			if exists := node.store.BucketExists(bucket); exists != (node.url == ring.Owner(bucket)) {
+				t.Errorf("Expected %s to exist on its owner only, found on %s: %v", bucket, node.url, exists)
+			}
+		}
+
+		resp = doRequest(t, http.MethodGet, nodes[1].url+"/"+bucket+"/key", nil)
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != bucket {
+			t.Errorf("Expected %q through another node, got %q", bucket, data)
+		}
+	}
+
+	// Listings merge the buckets of all nodes in order, and page across them
+	var names []string
+	token := ""
+	for {
+		resp := doRequest(t, http.MethodGet, nodes[2].url+"/?max-buckets=4&continuation-token="+token, nil)
+		var result server.ListAllMyBucketsResult
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		resp.Body.Close()
+		for _, b := range result.Buckets.Bucket {
+			names = append(names, b.Name)
+		}
+		if result.ContinuationToken == "" {
+			break
+		}
+		token = result.ContinuationToken
+	}
+	if got := strings.Join(names, ","); got != "bucket-0,bucket-1,bucket-2,bucket-3,bucket-4,bucket-5,bucket-6,bucket-7,bucket-8,bucket-9" {
+		t.Errorf("Unexpected merged listing: %s", got)
+	}
+}
+
+func TestClusterNodeDown(t *testing.T) {
+	nodes := startCluster(t, 2)
+	ring := NewRing([]string{nodes[0].url, nodes[1].url}, 0)
+
+	bucket := ""
+	for i := 0; bucket == ""; i++ {
+		if name := fmt.Sprintf("bucket-%d", i); ring.Owner(name) == nodes[1].url {
+			bucket = name
+		}
+	}
+
+	nodes[0].members.round(t.Context(), time.Now())
+	if !nodes[0].members.Alive(nodes[1].url) {
+		t.Fatal("Expected the gossip to find the second node up")
+	}
+
+	nodes[1].server.Close()
+	resp := doRequest(t, http.MethodGet, nodes[0].url+"/"+bucket, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 for a bucket of an unreachable node, got %d", resp.StatusCode)
+	}
+	if nodes[0].members.Alive(nodes[1].url) {
+		t.Error("Expected the unreachable node to be marked down")
+	}
+
+	resp = doRequest(t, http.MethodGet, nodes[0].url+statusPath, nil)
+	var status Status
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if len(status.Alive) != 1 || status.Alive[0] != nodes[0].url {
+		t.Errorf("Expected only the first node alive, got %+v", status)
+	}
+
+	// Gossip to a node that stopped answering marks it down as well
+	nodes[0].members.round(t.Context(), time.Now())
+	if nodes[0].members.Alive(nodes[1].url) {
+		t.Error("Expected the gossip to mark the node down")
+	}
+}
+
+func TestClusterHeartbeat(t *testing.T) {
+	nodes := startCluster(t, 2)
+	now := time.Now()
+	nodes[0].members.round(t.Context(), now)
+	if !nodes[0].members.Alive(nodes[1].url) {
+		t.Fatal("Expected the gossip to find the second node up")
+	}
+
+	// A node answering gossip without advancing its heartbeat, such as a hung node, is marked down
+	nodes[0].members.round(t.Context(), now.Add((gossipFailRounds+1)*DefaultHeartbeatInterval))
+	if nodes[0].members.Alive(nodes[1].url) {
+		t.Fatal("Expected a node whose heartbeat stopped to be marked down")
+	}
+
+	// Its next heartbeat marks it up again
+	nodes[1].members.round(t.Context(), now)
+	if !nodes[0].members.Alive(nodes[1].url) {
+		t.Error("Expected the advanced heartbeat to mark the node up")
+	}
+}
+
+func TestClusterJoin(t *testing.T) {
+	// The first node knows only itself, the others join through it
+	nodes := startNodes(t, 3, func(urls []string, i int) []string {
+		if i == 0 {
+			return nil
+		}
+		return urls[:1]
+	})
+
+	nodes[1].members.round(t.Context(), time.Now())
+	nodes[2].members.round(t.Context(), time.Now())
+	nodes[0].members.round(t.Context(), time.Now())
+
+	all := []string{nodes[0].url, nodes[1].url, nodes[2].url}
+	slices.Sort(all)
+	for _, node := range nodes {
+		if known, _ := node.members.Nodes(); !slices.Equal(known, all) {
+			t.Errorf("Expected %s to know all nodes, got %v", node.url, known)
+		}
+	}
+
+	// Every node routes buckets over the ring of all nodes
+	ring := NewRing(all, 0)
+	for i := 0; i < 10; i++ {
+		bucket := fmt.Sprintf("bucket-%d", i)
+		resp := doRequest(t, http.MethodPut, nodes[i%3].url+"/"+bucket, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create %s: %d", bucket, resp.StatusCode)
+		}
+		for _, node := range nodes {
+			if exists := node.store.BucketExists(bucket); exists != (node.url == ring.Owner(bucket)) {
+				t.Errorf("Expected %s to exist on its owner only, found on %s: %v", bucket, node.url, exists)
+			}
+		}
+	}
+}
+
+func TestClusterGossipSignature(t *testing.T) {
+	nodes := startCluster(t, 1)
+	outsider, err := NewMembership("http://192.0.2.1:8080", nil, []byte("other-secret"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create membership: %v", err)
+	}
+
+	for _, signature := range []string{"", "0 00", outsider.sign([]byte(`{"http://192.0.2.1:8080":1}`), time.Now())} {
+		req, _ := http.NewRequest(http.MethodPost, nodes[0].url+statusPath, strings.NewReader(`{"http://192.0.2.1:8080":1}`))
+		req.Header.Set(gossipHeader, signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected gossip signed with %q to be rejected, got %d", signature, resp.StatusCode)
+		}
+	}
+	if nodes[0].members.Known("http://192.0.2.1:8080") {
+		t.Error("Expected a node announced without the cluster secret not to join")
+	}
+}
+
+func TestClusterForwardedHeader(t *testing.T) {
+	nodes := startCluster(t, 2)
+	ring := NewRing([]string{nodes[0].url, nodes[1].url}, 0)
+
+	bucket := ""
+	for i := 0; bucket == ""; i++ {
+		if name := fmt.Sprintf("bucket-%d", i); ring.Owner(name) == nodes[1].url {
+			bucket = name
+		}
+	}
+
+	// A client claiming the request was forwarded cannot write onto a node not owning the bucket
+	for _, forwarded := range []string{nodes[1].url, nodes[1].url + " " + fmt.Sprint(time.Now().Unix()) + " 00"} {
+		req, _ := http.NewRequest(http.MethodPut, nodes[0].url+"/"+bucket, nil)
+		req.Header.Set(forwardedHeader, forwarded)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || nodes[0].store.BucketExists(bucket) {
+			t.Fatalf("Expected the forged header %q to be rejected, got %d", forwarded, resp.StatusCode)
+		}
+	}
+
+	c := nodes[1].cluster
+	req := httptest.NewRequest(http.MethodGet, "/"+bucket+"/key?tagging", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	req.TLS = &tls.ConnectionState{}
+	now := time.Now()
+	nodes[0].cluster.signForwarded(req, req, now)
+	forwarded := req.Header.Get(forwardedHeader)
+
+	// The owning node sees the client, not the forwarding node
+	req.RemoteAddr = "192.0.2.1:5678"
+	req.TLS = nil
+	client, ok := c.verifyForwarded(req, forwarded, now)
+	if !ok {
+		t.Fatal("Expected the signature of another node to be accepted")
+	}
+	if client.RemoteAddr != "203.0.113.5:5678" || !proxy.Secure(client) {
+		t.Errorf("Expected the client address and TLS state to be restored, got %s, secure %v", client.RemoteAddr, proxy.Secure(client))
+	}
+
+	if _, ok := c.verifyForwarded(req, forwarded, now.Add(forwardedMaxAge+time.Minute)); ok {
+		t.Error("Expected an old signature to be rejected")
+	}
+	other := httptest.NewRequest(http.MethodDelete, "/"+bucket+"/key?tagging", nil)
+	if _, ok := c.verifyForwarded(other, forwarded, now); ok {
+		t.Error("Expected the signature of another request to be rejected")
+	}
+	for _, forged := range []string{
+		strings.Replace(forwarded, nodes[0].url, nodes[1].url, 1),
+		strings.Replace(forwarded, "203.0.113.5", "10.0.0.1", 1),
+		strings.Replace(forwarded, "https", "http", 1),
+	} {
+		if _, ok := c.verifyForwarded(req, forged, now); ok {
+			t.Errorf("Expected the forged header %q to be rejected", forged)
+		}
+	}
+
+	// A plain HTTP client stays one over a TLS connection between nodes
+	plain := httptest.NewRequest(http.MethodGet, "/"+bucket+"/key", nil)
+	nodes[0].cluster.signForwarded(plain, plain, now)
+	plain.TLS = &tls.ConnectionState{}
+	if client, ok := c.verifyForwarded(plain, plain.Header.Get(forwardedHeader), now); !ok || proxy.Secure(client) {
+		t.Errorf("Expected the request to be accepted as plain HTTP, got %v", ok)
+	}
+}
+
+func TestClusterCancelledRequest(t *testing.T) {
+	nodes := startCluster(t, 2)
+
+	// Clients going away do not take the node down
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/bucket/key", nil).WithContext(ctx)
+	nodes[0].cluster.proxy(nodes[1].url).ErrorHandler(httptest.NewRecorder(), req, context.Canceled)
+	if !nodes[0].members.Alive(nodes[1].url) {
+		t.Error("Expected a cancelled request not to mark the node down")
+	}
+}
@@ -0,0 +1,331 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeatInterval is the default interval between gossip rounds
+const DefaultHeartbeatInterval = 5 * time.Second
+
+// statusPath serves the status of the node, and receives the gossip of other nodes
+// Bucket names cannot start with a dot, so it never shadows a bucket
+const statusPath = "/.s3d/cluster"
+
+// gossipHeader holds the unix time and the HMAC of the time and of the body of gossip messages
+// under the secret shared by the nodes, so only nodes can add nodes to the cluster
+const gossipHeader = "X-S3d-Cluster-Gossip"
+
+const (
+	// gossipFanout is how many random nodes each gossip round is sent to
+	gossipFanout = 3
+	// gossipFailRounds is how many rounds the heartbeat of a node may not advance before it is marked down
+	gossipFailRounds = 4
+	// maxGossipSize caps the size of gossip messages
+	maxGossipSize = 1 << 20
+)
+
+// Membership tracks the nodes of the cluster and which of them are alive by gossip
+// Each node increments its heartbeat every round and exchanges the heartbeats it knows with a few
+// random nodes, so nodes learn of joining nodes through any node, and a node whose heartbeat stops
+// advancing is marked down
+// Nodes are never dropped: the buckets of a node that is down have no other node holding their data
+type Membership struct {
+	self     string
+	secret   []byte
+	interval time.Duration
+	client   *http.Client
+
+	mu      sync.RWMutex
+	members map[string]*member
+	version uint64 // incremented when a node joins
+}
+
+// member is the state of a node as known by this node
+type member struct {
+	heartbeat uint64
+	updated   time.Time // when the heartbeat last advanced
+	down      bool
+}
+
+// NewMembership creates the membership of this node, self, starting from the seed nodes, given as base URLs
+// such as http://10.0.0.1:8080, which are all considered alive until their heartbeats stop advancing
+// The secret is shared by all nodes, which sign their gossip and the requests they forward to each other with it
+func NewMembership(self string, seeds []string, secret []byte, interval time.Duration) (*Membership, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the cluster secret must not be empty")
+	}
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	m := &Membership{
+		self:     self,
+		secret:   secret,
+		interval: interval,
+		client:   &http.Client{Timeout: interval},
+		members:  map[string]*member{},
+	}
+	now := time.Now()
+	for _, node := range append([]string{self}, seeds...) {
+		if !validNode(node) {
+			return nil, fmt.Errorf("invalid cluster node %q", node)
+		}
+		m.members[node] = &member{updated: now}
+	}
+	// A restarted node starts above the heartbeats it sent before, so other nodes do not ignore it
+	m.members[self].heartbeat = uint64(now.UnixNano())
+	return m, nil
+}
+
+// validNode reports whether node is the base URL of a node
+func validNode(node string) bool {
+	u, err := url.Parse(node)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/") && !strings.ContainsAny(node, " \n")
+}
+
+// Alive reports whether node is known and its heartbeat is advancing
+func (m *Membership) Alive(node string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	member, ok := m.members[node]
+	return ok && !member.down
+}
+
+// Known reports whether node is a node of the cluster
+func (m *Membership) Known(node string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.members[node]
+	return ok
+}
+
+// Nodes returns the nodes of the cluster in order, and the version of the list
+func (m *Membership) Nodes() ([]string, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes := make([]string, 0, len(m.members))
+	for node := range m.members {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	return nodes, m.version
+}
+
+// AliveNodes returns the nodes whose heartbeats are advancing in order
+func (m *Membership) AliveNodes() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var alive []string
+	for node, member := range m.members {
+		if !member.down {
+			alive = append(alive, node)
+		}
+	}
+	slices.Sort(alive)
+	return alive
+}
+
+// Run gossips every interval until ctx is done
+func (m *Membership) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.round(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// round advances the heartbeat of this node, marks down the nodes whose heartbeats stopped advancing,
+// and exchanges heartbeats with a few random nodes, down ones included so they are found when back
+func (m *Membership) round(ctx context.Context, now time.Time) {
+	m.mu.Lock()
+	m.members[m.self].heartbeat++
+	m.members[m.self].updated = now
+	var peers []string
+	for node, member := range m.members {
+		if node == m.self {
+			continue
+		}
+		if !member.down && now.Sub(member.updated) > gossipFailRounds*m.interval {
+			member.down = true
+			log.Printf("Cluster node %s is down", node)
+		}
+		peers = append(peers, node)
+	}
+	m.mu.Unlock()
+
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > gossipFanout {
+		peers = peers[:gossipFanout]
+	}
+	var wg sync.WaitGroup
+	for _, node := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !m.exchange(ctx, node) {
+				m.markDown(node)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// exchange sends the heartbeats known by this node to node and merges those it answers with
+// It reports whether node answered
+func (m *Membership) exchange(ctx context.Context, node string) bool {
+	body, err := json.Marshal(m.heartbeats())
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+statusPath, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(gossipHeader, m.sign(body, time.Now()))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	heartbeats, ok := m.read(resp.Body, resp.Header.Get(gossipHeader))
+	if !ok {
+		return false
+	}
+	m.merge(heartbeats, time.Now())
+	return true
+}
+
+// ServeGossip merges the heartbeats sent by another node and answers with the heartbeats known by this node
+func (m *Membership) ServeGossip(w http.ResponseWriter, r *http.Request) {
+	heartbeats, ok := m.read(r.Body, r.Header.Get(gossipHeader))
+	if !ok {
+		writeError(w, "AccessDenied", "Invalid cluster gossip signature", http.StatusForbidden)
+		return
+	}
+	m.merge(heartbeats, time.Now())
+
+	body, err := json.Marshal(m.heartbeats())
+	if err != nil {
+		writeError(w, "InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(gossipHeader, m.sign(body, time.Now()))
+	w.Write(body)
+}
+
+// heartbeats returns the heartbeats of the nodes known by this node
+func (m *Membership) heartbeats() map[string]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	heartbeats := make(map[string]uint64, len(m.members))
+	for node, member := range m.members {
+		heartbeats[node] = member.heartbeat
+	}
+	return heartbeats
+}
+
+// read reads a gossip message from body, checking its signature, the gossipHeader sent with it
+func (m *Membership) read(body io.Reader, signature string) (map[string]uint64, bool) {
+	data, err := io.ReadAll(io.LimitReader(body, maxGossipSize+1))
+	if err != nil || len(data) > maxGossipSize {
+		return nil, false
+	}
+	timestamp, mac, ok := strings.Cut(signature, " ")
+	if !ok {
+		return nil, false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > forwardedMaxAge || age < -forwardedMaxAge {
+		return nil, false
+	}
+	if !hmac.Equal([]byte(mac), []byte(m.mac(timestamp, data))) {
+		return nil, false
+	}
+	var heartbeats map[string]uint64
+	if err := json.Unmarshal(data, &heartbeats); err != nil {
+		return nil, false
+	}
+	return heartbeats, true
+}
+
+// sign returns the gossipHeader of the gossip message body sent at now
+func (m *Membership) sign(body []byte, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return timestamp + " " + m.mac(timestamp, body)
+}
+
+// mac returns the HMAC of a gossip message body sent at timestamp
+func (m *Membership) mac(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("gossip\n" + timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// merge adds the nodes of heartbeats this node did not know, and records the heartbeats that advanced
+func (m *Membership) merge(heartbeats map[string]uint64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for node, heartbeat := range heartbeats {
+		if node == m.self {
+			continue
+		}
+		existing, ok := m.members[node]
+		if !ok {
+			if !validNode(node) {
+				continue
+			}
+			m.members[node] = &member{heartbeat: heartbeat, updated: now}
+			m.version++
+			log.Printf("Cluster node %s joined", node)
+			continue
+		}
+		if heartbeat <= existing.heartbeat {
+			continue
+		}
+		existing.heartbeat = heartbeat
+		existing.updated = now
+		if existing.down {
+			existing.down = false
+			log.Printf("Cluster node %s is up", node)
+		}
+	}
+}
+
+// markDown marks node down when it could not be reached, until its heartbeat advances again
+func (m *Membership) markDown(node string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if member, ok := m.members[node]; ok && !member.down {
+		member.down = true
+		log.Printf("Cluster node %s is down", node)
+	}
+}
@@ -0,0 +1,59 @@
+// Package cluster spreads buckets over several s3d nodes,
+// forwarding each request to the node owning its bucket
+// Nodes learn of each other and of their liveness by gossip, starting from a few seed nodes
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the default number of points each node has on the ring
+const DefaultVirtualNodes = 128
+
+// Ring assigns buckets to nodes by consistent hashing
+// Adding or removing a node only moves the buckets of the ring segments it gains or loses
+type Ring struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+// NewRing creates a ring of nodes, each placed at vnodes points to even out the distribution
+func NewRing(nodes []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{
+		nodes: make(map[uint32]string, len(nodes)*vnodes),
+	}
+	for _, node := range nodes {
+		for i := 0; i < vnodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+			if existing, ok := r.nodes[h]; ok {
+				// Resolve collisions by name so every node builds the same ring whatever the order of nodes
+				if node < existing {
+					r.nodes[h] = node
+				}
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the node owning bucket, the first node clockwise from the bucket's hash
+func (r *Ring) Owner(bucket string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(bucket))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingOwner(t *testing.T) {
+	nodes := []string{"http://node-a:8080", "http://node-b:8080", "http://node-c:8080"}
+	ring := NewRing(nodes, 0)
+	reversed := NewRing([]string{nodes[2], nodes[1], nodes[0]}, 0)
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		bucket := fmt.Sprintf("bucket-%d", i)
+		owner := ring.Owner(bucket)
+		if owner != reversed.Owner(bucket) {
+			t.Fatalf("Expected the owner of %s not to depend on the order of nodes", bucket)
+		}
+		counts[owner]++
+	}
+	for _, node := range nodes {
+		// Virtual nodes keep each share reasonably close to a third
+		if counts[node] < 600 || counts[node] > 1400 {
+			t.Errorf("Unbalanced ring, %s owns %d of 3000 buckets", node, counts[node])
+		}
+	}
+
+	if owner := NewRing(nil, 0).Owner("bucket"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+}
+
+func TestRingAddNode(t *testing.T) {
+	nodes := []string{"http://node-a:8080", "http://node-b:8080", "http://node-c:8080"}
+	before := NewRing(nodes, 0)
+	after := NewRing(append(nodes, "http://node-d:8080"), 0)
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		bucket := fmt.Sprintf("bucket-%d", i)
+		if owner := after.Owner(bucket); owner != before.Owner(bucket) {
+			if owner != "http://node-d:8080" {
+				t.Fatalf("Expected %s to move to the new node only, got %s", bucket, owner)
+			}
+			moved++
+		}
+	}
+	// Only the share of the new node moves
+	if moved < 400 || moved > 1200 {
+		t.Errorf("Expected about a quarter of the buckets to move, got %d of 3000", moved)
+	}
+}
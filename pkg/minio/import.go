@@ -0,0 +1,213 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// ImportStats counts the results of an import
+type ImportStats struct {
+	// Buckets counts the buckets created by the import
+	Buckets int
+	// ExistingBuckets counts the buckets that already existed, whose objects are imported into them
+	ExistingBuckets int
+	Objects         int
+	Skipped         int
+}
+
+// ImportReport receives the result of each object of an import, err is nil if it was imported
+type ImportReport func(bucket, key string, err error)
+
+// Import copies the latest version of every object of a single-drive MinIO data directory into store
+// Buckets are created as needed, objects whose latest version is a delete marker are left out,
+// and objects that cannot be read from a single drive are skipped and reported
+func Import(src string, store *storage.Storage, report ImportReport) (*ImportStats, error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ImportStats{}
+	for _, entry := range entries {
+		bucket := entry.Name()
+		// Dot directories such as .minio.sys hold the MinIO configuration and internal state
+		if !entry.IsDir() || strings.HasPrefix(bucket, ".") {
+			continue
+		}
+
+		switch err := store.CreateBucket(bucket); err {
+		case nil:
+			stats.Buckets++
+		case storage.ErrBucketAlreadyExists:
+			stats.ExistingBuckets++
+		default:
+			return stats, fmt.Errorf("creating bucket %s: %w", bucket, err)
+		}
+
+		bucketPath := filepath.Join(src, bucket)
+		err := filepath.WalkDir(bucketPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || d.Name() != xlMetaFile {
+				return nil
+			}
+
+			objectPath := filepath.Dir(path)
+			rel, err := filepath.Rel(bucketPath, objectPath)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+
+			imported, err := importObject(store, bucket, key, objectPath)
+			if err != nil {
+				if errors.Is(err, storage.ErrInsufficientStorage) {
+					return err
+				}
+				stats.Skipped++
+			} else if imported {
+				stats.Objects++
+			}
+			if report != nil && (imported || err != nil) {
+				report(bucket, key, err)
+			}
+			// Part files live in data directories next to xl.meta, never nested objects
+			return fs.SkipDir
+		})
+		if err != nil {
+			return stats, fmt.Errorf("importing bucket %s: %w", bucket, err)
+		}
+	}
+	return stats, nil
+}
+
+// importObject imports the object stored in objectPath, returning false if its latest version is a delete marker
+func importObject(store *storage.Storage, bucket, key, objectPath string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(objectPath, xlMetaFile))
+	if err != nil {
+		return false, err
+	}
+	meta, err := parseXLMeta(data)
+	if err != nil {
+		return false, err
+	}
+
+	version := meta.latest()
+	if version == nil || version.Type == deleteMarkerType {
+		return false, nil
+	}
+	if err := checkSupported(version); err != nil {
+		return false, err
+	}
+
+	var content io.Reader
+	if inline := meta.inline(version); inline != nil {
+		decoded, err := decodeInline(inline, version)
+		if err != nil {
+			return false, err
+		}
+		content = bytes.NewReader(decoded)
+	} else {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(readParts(pw, objectPath, version))
+		}()
+		defer pr.Close()
+		content = pr
+	}
+
+	// Objects uploaded in parts may be larger than a single PutObject
+	if _, err := store.ImportObject(context.Background(), bucket, key, content, objectMetadata(version.MetaUsr)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkSupported rejects versions whose data is not stored as plain bitrot protected shards on this drive
+func checkSupported(v *xlVersion) error {
+	if v.ErasureM != 1 || v.ErasureN != 0 {
+		return fmt.Errorf("%w: erasure coded with %d data and %d parity shards", ErrUnsupportedObject, v.ErasureM, v.ErasureN)
+	}
+	if v.ChecksumAlgo != checksumHighwayHash {
+		return fmt.Errorf("%w: checksum algorithm %d", ErrUnsupportedObject, v.ChecksumAlgo)
+	}
+	for _, metadata := range []map[string]string{v.MetaSys, v.MetaUsr} {
+		for key := range metadata {
+			lower := strings.ToLower(key)
+			switch {
+			case strings.HasPrefix(lower, "x-minio-internal-compression"):
+				return fmt.Errorf("%w: compressed", ErrUnsupportedObject)
+			case strings.HasPrefix(lower, "x-minio-internal-server-side-encryption"):
+				return fmt.Errorf("%w: encrypted", ErrUnsupportedObject)
+			}
+		}
+	}
+	return nil
+}
+
+// readParts writes the data of the part files of v in order
+func readParts(w io.Writer, objectPath string, v *xlVersion) error {
+	if len(v.PartNumbers) != len(v.PartSizes) {
+		return fmt.Errorf("%w: mismatched part lists", ErrUnsupportedObject)
+	}
+	dataDir := filepath.Join(objectPath, v.DataDir.String())
+	for i, number := range v.PartNumbers {
+		f, err := os.Open(filepath.Join(dataDir, "part."+strconv.Itoa(number)))
+		if err != nil {
+			return err
+		}
+		err = stripBitrot(w, f, v.ErasureBlockSize, v.PartSizes[i])
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading part %d: %w", number, err)
+		}
+	}
+	return nil
+}
+
+// objectMetadata converts the user metadata of xl.meta, dropping the ETag and MinIO internal entries
+func objectMetadata(metaUsr map[string]string) storage.Metadata {
+	metadata := storage.Metadata{}
+	for key, value := range metaUsr {
+		lower := strings.ToLower(key)
+		switch {
+		case lower == "content-type":
+			metadata.ContentType = value
+		case lower == "cache-control":
+			metadata.CacheControl = value
+		case lower == "content-disposition":
+			metadata.ContentDisposition = value
+		case lower == "content-encoding":
+			metadata.ContentEncoding = value
+		case lower == "x-amz-website-redirect-location":
+			metadata.WebsiteRedirectLocation = value
+		case lower == "x-amz-tagging":
+			tags, err := url.ParseQuery(value)
+			if err != nil {
+				continue
+			}
+			metadata.Tags = make(map[string]string, len(tags))
+			for k := range tags {
+				metadata.Tags[k] = tags.Get(k)
+			}
+		case strings.HasPrefix(lower, "x-amz-meta-"):
+			if metadata.XAmzMeta == nil {
+				metadata.XAmzMeta = make(map[string]string)
+			}
+			metadata.XAmzMeta[strings.TrimPrefix(lower, "x-amz-meta-")] = value
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,228 @@
+package minio
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// writeObject writes the xl.meta and part files of an object into a MinIO data directory
+func writeObject(t *testing.T, dir, bucket, key string, xlMeta []byte, dataDir uuid.UUID, part []byte) {
+	t.Helper()
+	objectPath := filepath.Join(dir, bucket, filepath.FromSlash(key))
+	if err := os.MkdirAll(objectPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objectPath, xlMetaFile), xlMeta, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if part != nil {
+		if err := os.MkdirAll(filepath.Join(objectPath, dataDir.String()), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(objectPath, dataDir.String(), "part.1"), part, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, ".minio.sys", "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// An object stored inline in xl.meta
+	inlineID := uuid.New()
+	writeObject(t, src, "photos", "small.txt", buildXLMeta([]map[string]any{
+		objectVersion(inlineID, uuid.New(), 1, 5, map[string]any{
+			"content-type":     "text/plain",
+			"etag":             "5d41402abc4b2a76b9719d911017c592",
+			"X-Amz-Meta-Owner": "alice",
+			"X-Amz-Tagging":    "team=blue",
+		}),
+	}, map[string]any{inlineID.String(): bitrotShard([]byte("hello"))}), uuid.Nil, nil)
+
+	// A null version stored in a part file under a nested key
+	dataDir := uuid.New()
+	content := bytes.Repeat([]byte("abcdefghij"), 3)
+	writeObject(t, src, "photos", "2024/large.bin", buildXLMeta([]map[string]any{
+		objectVersion(uuid.Nil, dataDir, 1, len(content), map[string]any{"content-type": "application/octet-stream"}),
+	}, nil), dataDir, bitrotShard(content))
+
+	// An object whose latest version is a delete marker
+	writeObject(t, src, "photos", "deleted.txt", buildXLMeta([]map[string]any{
+		objectVersion(uuid.New(), uuid.New(), 1, 0, nil),
+		deleteMarker(uuid.New(), 2),
+	}, nil), uuid.Nil, nil)
+
+	// An erasure coded object cannot be read from a single drive
+	erasure := objectVersion(uuid.New(), uuid.New(), 1, 10, nil)
+	erasure["V2Obj"].(map[string]any)["EcM"] = 2
+	erasure["V2Obj"].(map[string]any)["EcN"] = 2
+	writeObject(t, src, "photos", "erasure.bin", buildXLMeta([]map[string]any{erasure}, nil), uuid.Nil, nil)
+
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	var skipped []string
+	stats, err := Import(src, store, func(bucket, key string, err error) {
+		if err != nil {
+			skipped = append(skipped, key)
+			if !errors.Is(err, ErrUnsupportedObject) {
+				t.Errorf("Expected ErrUnsupportedObject for %s, got %v", key, err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if stats.Buckets != 1 || stats.Objects != 2 || stats.Skipped != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if len(skipped) != 1 || skipped[0] != "erasure.bin" {
+		t.Errorf("Expected erasure.bin to be skipped, got %v", skipped)
+	}
+	if store.BucketExists(".minio.sys") {
+		t.Error("Expected .minio.sys not to be imported")
+	}
+
+	reader, info, err := store.GetObject("photos", "small.txt")
+	if err != nil {
+		t.Fatalf("Failed to get small.txt: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "hello" {
+		t.Errorf("Expected hello, got %q", data)
+	}
+	if info.Metadata.ContentType != "text/plain" || info.Metadata.XAmzMeta["owner"] != "alice" || info.Metadata.Tags["team"] != "blue" {
+		t.Errorf("Unexpected metadata: %+v", info.Metadata)
+	}
+
+	reader, _, err = store.GetObject("photos", "2024/large.bin")
+	if err != nil {
+		t.Fatalf("Failed to get 2024/large.bin: %v", err)
+	}
+	data, _ = io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(data, content) {
+		t.Errorf("Expected the part data, got %q", data)
+	}
+
+	if _, _, err := store.GetObject("photos", "deleted.txt"); err != storage.ErrObjectNotFound {
+		t.Errorf("Expected deleted.txt not to be imported, got %v", err)
+	}
+}
+
+// extractFixture unpacks a tar.gz fixture of testdata into a temporary directory
+func extractFixture(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return dir
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// videoMD5 is the MD5 of the content of media/video.bin in testdata/single-drive.tar.gz
+const videoMD5 = "1c1e14d42a2abb6e5e9d4b99b790d023"
+
+// TestImportSingleDrive imports testdata/single-drive.tar.gz, a data directory in the layout of a single-drive
+// MinIO deployment: xl.meta 1.3 files with the version headers, field set and 1 MiB erasure blocks MinIO writes,
+// an object stored inline in xl.meta and an object uploaded in a 5 MiB and a 4000 bytes part,
+// whose bitrot checksums are left zero as the import does not verify them
+func TestImportSingleDrive(t *testing.T) {
+	src := extractFixture(t, "single-drive.tar.gz")
+
+	// The multipart object is larger than a single PutObject may be
+	store, err := storage.NewStorage(t.TempDir(), storage.WithMaxPartSize(1<<20))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("docs"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	stats, err := Import(src, store, func(bucket, key string, err error) {
+		if err != nil {
+			t.Errorf("Failed to import %s/%s: %v", bucket, key, err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if stats.Buckets != 1 || stats.ExistingBuckets != 1 || stats.Objects != 2 || stats.Skipped != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	reader, info, err := store.GetObject("docs", "notes/hello.txt")
+	if err != nil {
+		t.Fatalf("Failed to get notes/hello.txt: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "Hello from MinIO\n" {
+		t.Errorf("Expected the inline data, got %q", data)
+	}
+	if info.Metadata.ContentType != "text/plain" || info.Metadata.XAmzMeta["author"] != "alice" {
+		t.Errorf("Unexpected metadata: %+v", info.Metadata)
+	}
+
+	reader, info, err = store.GetObject("media", "video.bin")
+	if err != nil {
+		t.Fatalf("Failed to get video.bin: %v", err)
+	}
+	hash := md5.New()
+	size, err := io.Copy(hash, reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("Failed to read video.bin: %v", err)
+	}
+	if size != 5<<20+4000 || info.Size != size {
+		t.Errorf("Expected %d bytes, got %d", 5<<20+4000, size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != videoMD5 {
+		t.Errorf("Expected the parts in order, got MD5 %s", sum)
+	}
+}
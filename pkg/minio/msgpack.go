@@ -0,0 +1,227 @@
+package minio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errShortBuffer is returned when a MessagePack value is truncated
+var errShortBuffer = errors.New("msgpack: short buffer")
+
+// msgpackReader decodes MessagePack values into generic Go values:
+// nil, bool, int64, uint64, float64, string, []byte, []any and map[string]any
+// Extension values are returned as their raw payload
+type msgpackReader struct {
+	buf []byte
+}
+
+// next consumes n bytes
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.buf) < n {
+		return nil, errShortBuffer
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of n bytes
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// length reads a length of n bytes and checks that it fits in the remaining buffer
+func (r *msgpackReader) length(n int) (int, error) {
+	l, err := r.uint(n)
+	if err != nil {
+		return 0, err
+	}
+	if l > uint64(len(r.buf)) {
+		return 0, errShortBuffer
+	}
+	return int(l), nil
+}
+
+// read decodes the next value
+func (r *msgpackReader) read() (any, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.readMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return r.readArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		s, err := r.next(int(c & 0x1f))
+		return string(s), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return r.next(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.length(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		// Skip the extension type
+		if _, err := r.next(1); err != nil {
+			return nil, err
+		}
+		return r.next(n)
+	case 0xca:
+		v, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (c - 0xcc))
+	case 0xd0:
+		v, err := r.uint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := r.uint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := r.uint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := r.uint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		if _, err := r.next(1); err != nil {
+			return nil, err
+		}
+		return r.next(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.next(n)
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := r.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readArray(n)
+	case 0xde, 0xdf:
+		n, err := r.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: unknown type 0x%02x", c)
+}
+
+// readArray decodes n array elements
+func (r *msgpackReader) readArray(n int) ([]any, error) {
+	array := make([]any, 0, min(n, len(r.buf)))
+	for i := 0; i < n; i++ {
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+// readMap decodes n map entries, converting string and binary keys to strings
+func (r *msgpackReader) readMap(n int) (map[string]any, error) {
+	m := make(map[string]any, min(n, len(r.buf)))
+	for i := 0; i < n; i++ {
+		k, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case string:
+			m[k] = v
+		case []byte:
+			m[string(k)] = v
+		default:
+			m[fmt.Sprint(k)] = v
+		}
+	}
+	return m, nil
+}
+
+// readBytes decodes a binary or string value
+func (r *msgpackReader) readBytes() ([]byte, error) {
+	v, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("msgpack: expected binary, got %T", v)
+}
+
+// readInt decodes an integer value
+func (r *msgpackReader) readInt() (int64, error) {
+	v, err := r.read()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := toInt(v)
+	if !ok {
+		return 0, fmt.Errorf("msgpack: expected integer, got %T", v)
+	}
+	return n, nil
+}
+
+// toInt converts a decoded integer to int64
+func toInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
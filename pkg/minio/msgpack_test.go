@@ -0,0 +1,127 @@
+package minio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// encodeMsgpack encodes generic values using the widest MessagePack formats
+func encodeMsgpack(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, int64(v))
+	case int64:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, v)
+	case string:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		buf.Write(v)
+	case []any:
+		buf.WriteByte(0xdd)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		for _, item := range v {
+			encodeMsgpack(buf, item)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte(0xdf)
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		for _, k := range keys {
+			encodeMsgpack(buf, k)
+			encodeMsgpack(buf, v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+func TestMsgpackRead(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want any
+	}{
+		{"positive fixint", []byte{0x05}, int64(5)},
+		{"negative fixint", []byte{0xff}, int64(-1)},
+		{"fixstr", []byte{0xa2, 'h', 'i'}, "hi"},
+		{"fixarray", []byte{0x92, 0x01, 0xc3}, []any{int64(1), true}},
+		{"fixmap", []byte{0x81, 0xa1, 'k', 0xc0}, map[string]any{"k": nil}},
+		{"uint16", []byte{0xcd, 0x01, 0x00}, uint64(256)},
+		{"int8", []byte{0xd0, 0x80}, int64(-128)},
+		{"bin8", []byte{0xc4, 0x02, 0x01, 0x02}, []byte{1, 2}},
+		{"fixext1", []byte{0xd4, 0x05, 0x07}, []byte{7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&msgpackReader{buf: tt.data}).read()
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	want := map[string]any{
+		"int":   int64(-42),
+		"str":   "value",
+		"bin":   []byte("data"),
+		"array": []any{int64(1), "two", nil},
+		"map":   map[string]any{"nested": true},
+	}
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, want)
+
+	got, err := (&msgpackReader{buf: buf.Bytes()}).read()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %#v, got %#v", want, got)
+	}
+}
+
+func TestMsgpackTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, map[string]any{"key": "value"})
+	data := buf.Bytes()
+
+	for i := 0; i < len(data); i++ {
+		if _, err := (&msgpackReader{buf: data[:i]}).read(); !errors.Is(err, errShortBuffer) {
+			t.Errorf("Expected errShortBuffer for %d bytes, got %v", i, err)
+		}
+	}
+
+	// Lengths larger than the buffer are rejected before allocating
+	if _, err := (&msgpackReader{buf: []byte{0xdd, 0xff, 0xff, 0xff, 0xff}}).read(); !errors.Is(err, errShortBuffer) {
+		t.Errorf("Expected errShortBuffer for an oversized array, got %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package minio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// xlMetaFile is the name of the metadata file of each object in a MinIO data directory
+const xlMetaFile = "xl.meta"
+
+// Version types of xl.meta
+const (
+	objectType       = 1
+	deleteMarkerType = 2
+)
+
+// checksumHighwayHash is the bitrot algorithm of xl.meta, streaming HighwayHash-256
+const checksumHighwayHash = 1
+
+// highwayHashSize is the size of the checksum preceding each shard block
+const highwayHashSize = 32
+
+var (
+	// ErrNotXLMeta is returned for files that do not start with the xl.meta signature
+	ErrNotXLMeta = errors.New("not an xl.meta file")
+	// ErrUnsupportedObject is returned for objects that cannot be imported from a single drive,
+	// such as erasure coded, compressed or encrypted objects
+	ErrUnsupportedObject = errors.New("unsupported object")
+)
+
+// xlVersion is an object version described by xl.meta
+type xlVersion struct {
+	Type      int
+	VersionID uuid.UUID
+	DataDir   uuid.UUID
+	ModTime   time.Time
+	Size      int64
+
+	ErasureM         int
+	ErasureN         int
+	ErasureBlockSize int64
+	ChecksumAlgo     int
+
+	PartNumbers []int
+	PartSizes   []int64
+
+	// MetaSys holds MinIO internal metadata
+	MetaSys map[string]string
+	// MetaUsr holds the user metadata, including the content type and ETag
+	MetaUsr map[string]string
+}
+
+// xlMeta is a parsed xl.meta file
+type xlMeta struct {
+	Versions []xlVersion
+	// inlineData maps version IDs ("null" for the null version) to the data stored in xl.meta
+	inlineData map[string][]byte
+}
+
+// latest returns the most recent version, nil if there is none
+func (m *xlMeta) latest() *xlVersion {
+	var latest *xlVersion
+	for i := range m.Versions {
+		if latest == nil || m.Versions[i].ModTime.After(latest.ModTime) {
+			latest = &m.Versions[i]
+		}
+	}
+	return latest
+}
+
+// inline returns the data of v stored in xl.meta, nil if it is stored in part files
+func (m *xlMeta) inline(v *xlVersion) []byte {
+	key := "null"
+	if v.VersionID != uuid.Nil {
+		key = v.VersionID.String()
+	}
+	return m.inlineData[key]
+}
+
+// parseXLMeta parses an xl.meta file of format version 1.0 to 1.3
+func parseXLMeta(data []byte) (*xlMeta, error) {
+	if len(data) < 8 || string(data[:4]) != "XL2 " {
+		return nil, ErrNotXLMeta
+	}
+	major := binary.LittleEndian.Uint16(data[4:6])
+	minor := binary.LittleEndian.Uint16(data[6:8])
+	if major != 1 || minor > 3 {
+		return nil, fmt.Errorf("%w: xl.meta version %d.%d", ErrUnsupportedObject, major, minor)
+	}
+
+	r := &msgpackReader{buf: data[8:]}
+	meta := &xlMeta{}
+
+	// Version 1.0 is a bare map, later versions wrap the metadata in a binary value followed by a CRC and inline data
+	if minor == 0 {
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if err := meta.addVersions(v); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+
+	metadata, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	// The metadata checksum was added in version 1.2
+	if minor >= 2 {
+		if _, err := r.readInt(); err != nil {
+			return nil, fmt.Errorf("reading xl.meta checksum: %w", err)
+		}
+	}
+	if err := meta.parseInlineData(r.buf); err != nil {
+		return nil, err
+	}
+
+	mr := &msgpackReader{buf: metadata}
+	if minor < 3 {
+		v, err := mr.read()
+		if err != nil {
+			return nil, err
+		}
+		if err := meta.addVersions(v); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+
+	// Version 1.3 lists a header and the metadata of each version as binary values
+	if _, err := mr.readInt(); err != nil {
+		return nil, err
+	}
+	if _, err := mr.readInt(); err != nil {
+		return nil, err
+	}
+	count, err := mr.readInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < count; i++ {
+		if _, err := mr.readBytes(); err != nil {
+			return nil, err
+		}
+		versionData, err := mr.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		v, err := (&msgpackReader{buf: versionData}).read()
+		if err != nil {
+			return nil, err
+		}
+		if err := meta.addVersion(v); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// addVersions adds the versions of a {"Versions": [...]} map
+func (m *xlMeta) addVersions(v any) error {
+	root, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: malformed xl.meta", ErrUnsupportedObject)
+	}
+	versions, _ := root["Versions"].([]any)
+	for _, version := range versions {
+		if err := m.addVersion(version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addVersion adds a version map, ignoring legacy and unknown version types
+func (m *xlMeta) addVersion(v any) error {
+	version, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: malformed xl.meta version", ErrUnsupportedObject)
+	}
+	versionType, _ := toInt(version["Type"])
+
+	switch versionType {
+	case objectType:
+		obj, ok := version["V2Obj"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		m.Versions = append(m.Versions, xlVersion{
+			Type:             objectType,
+			VersionID:        uuidField(obj["ID"]),
+			DataDir:          uuidField(obj["DDir"]),
+			ModTime:          time.Unix(0, intField(obj["MTime"])),
+			Size:             intField(obj["Size"]),
+			ErasureM:         int(intField(obj["EcM"])),
+			ErasureN:         int(intField(obj["EcN"])),
+			ErasureBlockSize: intField(obj["EcBSize"]),
+			ChecksumAlgo:     int(intField(obj["CSumAlgo"])),
+			PartNumbers:      intsField(obj["PartNums"]),
+			PartSizes:        int64sField(obj["PartSizes"]),
+			MetaSys:          stringMapField(obj["MetaSys"]),
+			MetaUsr:          stringMapField(obj["MetaUsr"]),
+		})
+	case deleteMarkerType:
+		marker, ok := version["DelObj"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		m.Versions = append(m.Versions, xlVersion{
+			Type:      deleteMarkerType,
+			VersionID: uuidField(marker["ID"]),
+			ModTime:   time.Unix(0, intField(marker["MTime"])),
+		})
+	}
+	return nil
+}
+
+// parseInlineData parses the data stored after the metadata, a version byte followed by a map of version IDs to data
+func (m *xlMeta) parseInlineData(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if data[0] != 1 {
+		return fmt.Errorf("%w: inline data version %d", ErrUnsupportedObject, data[0])
+	}
+	v, err := (&msgpackReader{buf: data[1:]}).read()
+	if err != nil {
+		return err
+	}
+	entries, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: malformed inline data", ErrUnsupportedObject)
+	}
+	m.inlineData = make(map[string][]byte, len(entries))
+	for key, value := range entries {
+		if b, ok := value.([]byte); ok {
+			m.inlineData[key] = b
+		}
+	}
+	return nil
+}
+
+// stripBitrot removes the checksum preceding each block of a single-drive shard,
+// returning the shard data without verifying it
+func stripBitrot(w io.Writer, r io.Reader, blockSize, size int64) error {
+	if blockSize <= 0 {
+		return fmt.Errorf("%w: invalid block size %d", ErrUnsupportedObject, blockSize)
+	}
+	hash := make([]byte, highwayHashSize)
+	for size > 0 {
+		n := min(blockSize, size)
+		if _, err := io.ReadFull(r, hash); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, n); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+// decodeInline returns the object data of inline shard data
+func decodeInline(data []byte, v *xlVersion) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := stripBitrot(&buf, bytes.NewReader(data), v.ErasureBlockSize, v.Size); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// uuidField converts a binary UUID field
+func uuidField(v any) uuid.UUID {
+	b, _ := v.([]byte)
+	id, err := uuid.FromBytes(b)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// intField converts an integer field, 0 if it is missing
+func intField(v any) int64 {
+	n, _ := toInt(v)
+	return n
+}
+
+// intsField converts an integer array field
+func intsField(v any) []int {
+	array, _ := v.([]any)
+	ints := make([]int, 0, len(array))
+	for _, item := range array {
+		ints = append(ints, int(intField(item)))
+	}
+	return ints
+}
+
+// int64sField converts an integer array field
+func int64sField(v any) []int64 {
+	array, _ := v.([]any)
+	ints := make([]int64, 0, len(array))
+	for _, item := range array {
+		ints = append(ints, intField(item))
+	}
+	return ints
+}
+
+// stringMapField converts a map of string or binary values
+func stringMapField(v any) map[string]string {
+	m, _ := v.(map[string]any)
+	strings := make(map[string]string, len(m))
+	for key, value := range m {
+		switch value := value.(type) {
+		case string:
+			strings[key] = value
+		case []byte:
+			strings[key] = string(value)
+		}
+	}
+	return strings
+}
@@ -0,0 +1,128 @@
+package minio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testBlockSize is the erasure block size of the synthesized objects
+const testBlockSize = 4
+
+// buildXLMeta synthesizes an xl.meta file of format version 1.3
+func buildXLMeta(versions []map[string]any, inline map[string]any) []byte {
+	var metadata bytes.Buffer
+	encodeMsgpack(&metadata, 3)
+	encodeMsgpack(&metadata, 2)
+	encodeMsgpack(&metadata, len(versions))
+	for _, version := range versions {
+		var versionData bytes.Buffer
+		encodeMsgpack(&versionData, version)
+		encodeMsgpack(&metadata, []byte{})
+		encodeMsgpack(&metadata, versionData.Bytes())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("XL2 ")
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	binary.Write(&buf, binary.LittleEndian, uint16(3))
+	encodeMsgpack(&buf, metadata.Bytes())
+	encodeMsgpack(&buf, uint32(0))
+	if inline != nil {
+		buf.WriteByte(1)
+		encodeMsgpack(&buf, inline)
+	}
+	return buf.Bytes()
+}
+
+// objectVersion synthesizes a single-drive object version
+func objectVersion(id, dataDir uuid.UUID, modTime int64, size int, metaUsr map[string]any) map[string]any {
+	return map[string]any{
+		"Type": objectType,
+		"V2Obj": map[string]any{
+			"ID":        id[:],
+			"DDir":      dataDir[:],
+			"MTime":     modTime,
+			"Size":      size,
+			"EcM":       1,
+			"EcN":       0,
+			"EcBSize":   testBlockSize,
+			"CSumAlgo":  checksumHighwayHash,
+			"PartNums":  []any{1},
+			"PartSizes": []any{size},
+			"MetaSys":   map[string]any{},
+			"MetaUsr":   metaUsr,
+		},
+	}
+}
+
+// deleteMarker synthesizes a delete marker version
+func deleteMarker(id uuid.UUID, modTime int64) map[string]any {
+	return map[string]any{
+		"Type":   deleteMarkerType,
+		"DelObj": map[string]any{"ID": id[:], "MTime": modTime},
+	}
+}
+
+// bitrotShard prefixes each block of data with a placeholder checksum
+func bitrotShard(data []byte) []byte {
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		n := min(testBlockSize, len(data))
+		buf.Write(make([]byte, highwayHashSize))
+		buf.Write(data[:n])
+		data = data[n:]
+	}
+	return buf.Bytes()
+}
+
+func TestParseXLMeta(t *testing.T) {
+	id := uuid.New()
+	data := buildXLMeta([]map[string]any{
+		deleteMarker(uuid.New(), 1),
+		objectVersion(id, uuid.New(), 2, 10, map[string]any{"content-type": "text/plain"}),
+	}, map[string]any{id.String(): bitrotShard([]byte("0123456789"))})
+
+	meta, err := parseXLMeta(data)
+	if err != nil {
+		t.Fatalf("Failed to parse xl.meta: %v", err)
+	}
+	if len(meta.Versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(meta.Versions))
+	}
+
+	latest := meta.latest()
+	if latest.Type != objectType || latest.VersionID != id || latest.Size != 10 {
+		t.Fatalf("Unexpected latest version: %+v", latest)
+	}
+	if latest.MetaUsr["content-type"] != "text/plain" {
+		t.Errorf("Expected the user metadata, got %v", latest.MetaUsr)
+	}
+
+	content, err := decodeInline(meta.inline(latest), latest)
+	if err != nil {
+		t.Fatalf("Failed to decode inline data: %v", err)
+	}
+	if string(content) != "0123456789" {
+		t.Errorf("Expected the inline data, got %q", content)
+	}
+}
+
+func TestParseXLMetaInvalid(t *testing.T) {
+	if _, err := parseXLMeta([]byte("not xl.meta")); !errors.Is(err, ErrNotXLMeta) {
+		t.Errorf("Expected ErrNotXLMeta, got %v", err)
+	}
+	if _, err := parseXLMeta([]byte("XL2 \x02\x00\x00\x00")); !errors.Is(err, ErrUnsupportedObject) {
+		t.Errorf("Expected ErrUnsupportedObject for an unknown version, got %v", err)
+	}
+
+	data := buildXLMeta([]map[string]any{objectVersion(uuid.New(), uuid.New(), 1, 1, nil)}, nil)
+	for i := 8; i < len(data); i++ {
+		if _, err := parseXLMeta(data[:i]); err == nil {
+			t.Fatalf("Expected an error for xl.meta truncated to %d bytes", i)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds how long a connection may take to send its PROXY protocol header
+const headerTimeout = 10 * time.Second
+
+var (
+	// signatureV1 starts a human readable PROXY protocol header
+	signatureV1 = []byte("PROXY ")
+	// signatureV2 starts a binary PROXY protocol header
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrInvalidHeader is returned when a connection sends a malformed PROXY protocol header
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener accepts connections that start with a PROXY protocol v1 or v2 header
+// and reports the client address carried by the header as the RemoteAddr
+type Listener struct {
+	net.Listener
+	// Trusted restricts which peers may send a header; any peer may if it is empty
+	Trusted Trusted
+}
+
+// NewListener wraps l so that PROXY protocol headers from trusted peers are honored
+func NewListener(l net.Listener, trusted Trusted) *Listener {
+	return &Listener{Listener: l, Trusted: trusted}
+}
+
+// Accept waits for the next connection
+// The header is read lazily on first use, so a slow client cannot block the accept loop
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Trusted) != 0 && !l.Trusted.containsAddr(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Conn is a connection whose addresses come from a PROXY protocol header
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// Read reads data following the PROXY protocol header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the peer address without one
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the header, or the local address without one
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes the PROXY protocol header if the connection starts with one
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	if prefix, err := c.reader.Peek(len(signatureV1)); err == nil && bytes.Equal(prefix, signatureV1) {
+		c.remoteAddr, c.localAddr, c.err = readHeaderV1(c.reader)
+		return
+	}
+	if prefix, err := c.reader.Peek(len(signatureV2)); err == nil && bytes.Equal(prefix, signatureV2) {
+		c.remoteAddr, c.localAddr, c.err = readHeaderV2(c.reader)
+		return
+	}
+}
+
+// readHeaderV1 parses a header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readHeaderV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	// A v1 header is at most 107 bytes including the CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+// readHeaderV2 parses a binary header
+func readHeaderV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	header := make([]byte, len(signatureV2)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+
+	versionCommand := header[len(signatureV2)]
+	family := header[len(signatureV2)+1]
+	length := binary.BigEndian.Uint16(header[len(signatureV2)+2:])
+
+	if versionCommand>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, versionCommand>>4)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections are health checks from the proxy itself and keep the peer address
+	if versionCommand&0x0f == 0 {
+		return nil, nil, nil
+	}
+	if versionCommand&0x0f != 1 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		// Unix sockets and unspecified families carry no usable IP address
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// acceptWith sends header and body through a PROXY protocol listener and returns the accepted side
+func acceptWith(t *testing.T, trusted Trusted, data []byte) net.Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	pl := NewListener(l, trusted)
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		client.Write(data)
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestListenerV1(t *testing.T) {
+	conn := acceptWith(t, nil, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello"))
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("Expected remote address 192.0.2.1:56324, got %s", got)
+	}
+	if got := conn.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Errorf("Expected local address 198.51.100.1:443, got %s", got)
+	}
+
+	body := make([]byte, 5)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("Expected body hello, got %q", body)
+	}
+}
+
+func TestListenerV2(t *testing.T) {
+	header := append([]byte{}, signatureV2...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 0, 2, 1, 198, 51, 100, 1)
+	header = binary.BigEndian.AppendUint16(header, 56324)
+	header = binary.BigEndian.AppendUint16(header, 443)
+
+	conn := acceptWith(t, nil, append(header, "hello"...))
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("Expected remote address 192.0.2.1:56324, got %s", got)
+	}
+
+	body := make([]byte, 5)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("Expected body hello, got %q", body)
+	}
+}
+
+func TestListenerWithoutHeader(t *testing.T) {
+	conn := acceptWith(t, nil, []byte("GET / HTTP/1.1\r\n"))
+
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("Expected peer address, got %s", got)
+	}
+
+	body := make([]byte, 3)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if string(body) != "GET" {
+		t.Errorf("Expected data to be left untouched, got %q", body)
+	}
+}
+
+func TestListenerInvalidHeader(t *testing.T) {
+	conn := acceptWith(t, nil, []byte("PROXY TCP4 garbage\r\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected error for malformed header")
+	}
+}
+
+func TestListenerUntrustedPeer(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	conn := acceptWith(t, trusted, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("Expected header from untrusted peer to be ignored, got %s", got)
+	}
+}
@@ -0,0 +1,151 @@
+// Package proxy recovers the client address of requests that reach the server
+// through load balancers or reverse proxies
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Trusted is a set of networks whose proxies are allowed to report the client address
+type Trusted []*net.IPNet
+
+// ParseTrusted parses a comma-separated list of IP addresses and CIDR networks
+func ParseTrusted(list string) (Trusted, error) {
+	var trusted Trusted
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %q", item)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network: %q", item)
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted, nil
+}
+
+// Contains reports whether ip belongs to one of the trusted networks
+func (t Trusted) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr reports whether the host part of a host:port address is trusted
+func (t Trusted) containsAddr(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return t.Contains(net.ParseIP(host))
+}
+
+// ClientIP returns the address of the client that sent r
+// X-Forwarded-For and X-Real-IP are only honored when the connection comes from a trusted proxy,
+// and X-Forwarded-For is walked from the right so that entries added by the client are ignored
+func (t Trusted) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !t.Contains(net.ParseIP(host)) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) != 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			host = ip.String()
+			if !t.Contains(ip) {
+				return host
+			}
+		}
+		return host
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// forwardedTLSKey is the context key marking requests a trusted proxy received over TLS
+type forwardedTLSKey struct{}
+
+// Secure reports whether the client sent r over TLS, either to the server or to a trusted proxy
+// reporting it with X-Forwarded-Proto, as recorded by RealIPMiddleware
+func Secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	forwarded, _ := r.Context().Value(forwardedTLSKey{}).(bool)
+	return forwarded
+}
+
+// WithClient returns a copy of r as sent by the client at addr, over TLS if secure, for requests
+// relayed by a peer that authenticated itself and reported the client it received them from
+// The TLS state of the connection of the peer is dropped, as it is not the one of the client
+func WithClient(r *http.Request, addr string, secure bool) *http.Request {
+	r = r.Clone(context.WithValue(r.Context(), forwardedTLSKey{}, secure))
+	r.RemoteAddr = addr
+	r.TLS = nil
+	return r
+}
+
+// RealIPMiddleware rewrites the RemoteAddr of requests from trusted proxies to the client address
+// reported in X-Forwarded-For or X-Real-IP, so access logs and later handlers see the real client,
+// and records whether they reported receiving the request over TLS in X-Forwarded-Proto
+func RealIPMiddleware(trusted Trusted, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host, port = r.RemoteAddr, "0"
+		}
+		// The first proxy is the one the client connected to
+		if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); strings.EqualFold(strings.TrimSpace(proto), "https") && trusted.Contains(net.ParseIP(host)) {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedTLSKey{}, true))
+		}
+		ip := trusted.ClientIP(r)
+		if addr := net.JoinHostPort(ip, port); addr != r.RemoteAddr {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = addr
+		}
+		next.ServeHTTP(w, r)
+	})
+}
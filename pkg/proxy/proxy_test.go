@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8, 192.168.1.1,::1")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	if len(trusted) != 3 {
+		t.Fatalf("Expected 3 networks, got %d", len(trusted))
+	}
+
+	for _, invalid := range []string{"not-an-ip", "10.0.0.0/33"} {
+		if _, err := ParseTrusted(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "203.0.113.5:1234",
+			expected:   "203.0.113.5",
+		},
+		{
+			name:       "UntrustedForwardedFor",
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			expected:   "203.0.113.5",
+		},
+		{
+			name:       "TrustedForwardedFor",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			expected:   "198.51.100.7",
+		},
+		{
+			name:       "SpoofedForwardedFor",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.2"},
+			expected:   "198.51.100.7",
+		},
+		{
+			name:       "TrustedRealIP",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.7"},
+			expected:   "198.51.100.7",
+		},
+		{
+			name:       "InvalidRealIP",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "garbage"},
+			expected:   "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := trusted.ClientIP(req); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	var remoteAddr string
+	handler := RealIPMiddleware(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if remoteAddr != "198.51.100.7:1234" {
+		t.Errorf("Expected RemoteAddr 198.51.100.7:1234, got %s", remoteAddr)
+	}
+}
+
+func TestSecure(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+
+	var secure bool
+	handler := RealIPMiddleware(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secure = Secure(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		want       bool
+	}{
+		{"PlainHTTP", "198.51.100.7:1234", "", false, false},
+		{"TLS", "198.51.100.7:1234", "", true, true},
+		{"TrustedProxy", "10.0.0.1:1234", "https", false, true},
+		{"TrustedProxyChain", "10.0.0.1:1234", "HTTPS, http", false, true},
+		{"TrustedProxyPlainHTTP", "10.0.0.1:1234", "http", false, false},
+		{"UntrustedProxy", "198.51.100.7:1234", "https", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if secure != tt.want {
+				t.Errorf("Expected Secure to be %v, got %v", tt.want, secure)
+			}
+		})
+	}
+}
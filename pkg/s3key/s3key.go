@@ -0,0 +1,71 @@
+// Package s3key encodes, decodes and validates S3 object keys
+// as they appear in URLs, headers and XML responses
+package s3key
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the maximum length of an object key in bytes
+const MaxLength = 1024
+
+var (
+	ErrInvalidKey        = errors.New("invalid object key")
+	ErrInvalidCopySource = errors.New("invalid copy source")
+)
+
+// Validate checks that key can be stored: it must be valid UTF-8,
+// at most MaxLength bytes long and must not contain NUL characters
+func Validate(key string) error {
+	if key == "" || len(key) > MaxLength {
+		return ErrInvalidKey
+	}
+	if !utf8.ValidString(key) {
+		return ErrInvalidKey
+	}
+	if strings.IndexByte(key, 0) >= 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Encode encodes a key for responses requested with encoding-type=url
+// Like S3, spaces are encoded as "+" and "/" is kept as is
+func Encode(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
+
+// Decode decodes a key encoded by Encode
+func Decode(encoded string) (string, error) {
+	return url.QueryUnescape(encoded)
+}
+
+// ParseCopySource parses the x-amz-copy-source header, "bucket/key" or "/bucket/key"
+// optionally followed by "?versionId=id"
+// The key is URL decoded as a path, so "+" stays a plus sign
+func ParseCopySource(source string) (bucket, key, versionID string, err error) {
+	source = strings.TrimPrefix(source, "/")
+
+	if i := strings.Index(source, "?"); i >= 0 {
+		query, err := url.ParseQuery(source[i+1:])
+		if err != nil {
+			return "", "", "", ErrInvalidCopySource
+		}
+		versionID = query.Get("versionId")
+		source = source[:i]
+	}
+
+	decoded, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", "", ErrInvalidCopySource
+	}
+
+	bucket, key, ok := strings.Cut(decoded, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", "", ErrInvalidCopySource
+	}
+	return bucket, key, versionID, nil
+}
@@ -0,0 +1,134 @@
+package s3key
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"simple.txt", "simple.txt"},
+		{"dir/file.txt", "dir/file.txt"},
+		{"with space.txt", "with+space.txt"},
+		{"plus+sign", "plus%2Bsign"},
+		{"percent%20", "percent%2520"},
+		{"emoji-😀", "emoji-%F0%9F%98%80"},
+		{"control\x01char", "control%01char"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := Encode(tt.key); got != tt.expected {
+				t.Errorf("Encode(%q) = %q, expected %q", tt.key, got, tt.expected)
+			}
+			decoded, err := Decode(tt.expected)
+			if err != nil {
+				t.Fatalf("Decode(%q) failed: %v", tt.expected, err)
+			}
+			if decoded != tt.key {
+				t.Errorf("Decode(%q) = %q, expected %q", tt.expected, decoded, tt.key)
+			}
+		})
+	}
+}
+
+func TestParseCopySource(t *testing.T) {
+	tests := []struct {
+		source    string
+		bucket    string
+		key       string
+		versionID string
+		expectErr bool
+	}{
+		{source: "bucket/key.txt", bucket: "bucket", key: "key.txt"},
+		{source: "/bucket/dir/key.txt", bucket: "bucket", key: "dir/key.txt"},
+		{source: "bucket/with%20space.txt", bucket: "bucket", key: "with space.txt"},
+		{source: "bucket/with space.txt", bucket: "bucket", key: "with space.txt"},
+		{source: "bucket/plus+sign", bucket: "bucket", key: "plus+sign"},
+		{source: "bucket/percent%2520", bucket: "bucket", key: "percent%20"},
+		{source: "bucket/emoji-%F0%9F%98%80", bucket: "bucket", key: "emoji-😀"},
+		{source: "bucket/question%3Fmark", bucket: "bucket", key: "question?mark"},
+		{source: "bucket/key.txt?versionId=abc", bucket: "bucket", key: "key.txt", versionID: "abc"},
+		{source: "bucket", expectErr: true},
+		{source: "bucket/", expectErr: true},
+		{source: "bucket/bad%zzescape", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			bucket, key, versionID, err := ParseCopySource(tt.source)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error, got bucket %q key %q", bucket, key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCopySource failed: %v", err)
+			}
+			if bucket != tt.bucket || key != tt.key || versionID != tt.versionID {
+				t.Errorf("Got (%q, %q, %q), expected (%q, %q, %q)", bucket, key, versionID, tt.bucket, tt.key, tt.versionID)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"key.txt", true},
+		{"emoji-😀", true},
+		{"control\x01char", true},
+		{"", false},
+		{"nul\x00char", false},
+		{"invalid-\xff-utf8", false},
+		{strings.Repeat("a", MaxLength), true},
+		{strings.Repeat("a", MaxLength+1), false},
+	}
+
+	for _, tt := range tests {
+		if err := Validate(tt.key); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) = %v, expected valid %v", tt.key, err, tt.valid)
+		}
+	}
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	for _, seed := range []string{"key.txt", "with space", "plus+sign", "percent%20", "emoji-😀", "dir/sub/", "control\x01"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, key string) {
+		encoded := Encode(key)
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode(Encode(%q)) failed: %v", key, err)
+		}
+		if decoded != key {
+			t.Fatalf("Decode(Encode(%q)) = %q", key, decoded)
+		}
+		if !utf8.ValidString(encoded) {
+			t.Fatalf("Encode(%q) = %q is not valid UTF-8", key, encoded)
+		}
+	})
+}
+
+func FuzzParseCopySource(f *testing.F) {
+	for _, seed := range []string{"bucket/key", "/bucket/with%20space", "bucket/plus+sign", "bucket/key?versionId=1", "bucket"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, source string) {
+		bucket, key, _, err := ParseCopySource(source)
+		if err != nil {
+			return
+		}
+		if bucket == "" || key == "" || strings.Contains(bucket, "/") {
+			t.Fatalf("ParseCopySource(%q) = (%q, %q)", source, bucket, key)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+)
+
+// NewAccountHandler serves the requests of the access keys and identity provider groups of each account
+// by the handler of its bucket namespace, and the other ones, including anonymous requests, by root
+// Temporary credentials and those of a watched directory appear at runtime, possibly from any holder
+// of an identity token, so they are denied rather than served by root when they belong to no account
+// It must run after the authentication so that the access key of the request is known
+func NewAccountHandler(accounts *auth.Accounts, namespaces map[string]http.Handler, root http.Handler) http.Handler {
+	for _, name := range accounts.Names() {
+		// Requests of the account would otherwise reach the root namespace
+		if namespaces[name] == nil {
+			panic(fmt.Sprintf("server: no handler for account %q", name))
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		account, err := accounts.Resolve(auth.AccessKeyIDFromContext(ctx), auth.GroupsFromContext(ctx))
+		switch {
+		case errors.Is(err, auth.ErrAmbiguousAccount):
+			denyAccount(w, "The groups of the credentials belong to several accounts")
+		case account != "":
+			namespaces[account].ServeHTTP(w, r)
+		case auth.RuntimeCredentialsFromContext(ctx):
+			denyAccount(w, "The credentials do not belong to an account")
+		default:
+			root.ServeHTTP(w, r)
+		}
+	})
+}
+
+// denyAccount writes the AccessDenied error of a request whose credentials select no namespace
+func denyAccount(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(Error{
+		Code:      "AccessDenied",
+		Message:   message,
+		RequestId: w.Header().Get("x-amz-request-id"),
+		HostId:    w.Header().Get("x-amz-id-2"),
+	})
+}
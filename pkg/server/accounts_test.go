@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestAccountHandler(t *testing.T) {
+	accounts, err := auth.NewAccounts(map[string]auth.Account{
+		"team-a": {AccessKeys: []string{"a-key"}},
+		"team-b": {AccessKeys: []string{"b-key"}},
+	})
+	if err != nil {
+		t.Fatalf("NewAccounts failed: %v", err)
+	}
+	authenticator := auth.NewAWS4Authenticator()
+	for _, accessKeyID := range []string{"a-key", "b-key", "root-key"} {
+		authenticator.AddCredentials(accessKeyID, "secret")
+	}
+
+	newStore := func() *storage.Storage {
+		store, err := storage.NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	root, teamA, teamB := newStore(), newStore(), newStore()
+	handler := authenticator.BasicAuthMiddleware("s3d", NewAccountHandler(accounts, map[string]http.Handler{
+		"team-a": NewS3Handler(teamA),
+		"team-b": NewS3Handler(teamB),
+	}, NewS3Handler(root)))
+
+	serve := func(method, target, accessKeyID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.SetBasicAuth(accessKeyID, "secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Both accounts may use the same bucket name
+	for _, accessKeyID := range []string{"a-key", "b-key"} {
+		if rec := serve(http.MethodPut, "/shared", accessKeyID); rec.Code != http.StatusOK {
+			t.Fatalf("Expected %s to create the bucket, got %d: %s", accessKeyID, rec.Code, rec.Body.String())
+		}
+	}
+	if !teamA.BucketExists("shared") || !teamB.BucketExists("shared") || root.BucketExists("shared") {
+		t.Error("Expected the bucket in the namespaces of both accounts only")
+	}
+
+	if rec := serve(http.MethodGet, "/", "root-key"); strings.Contains(rec.Body.String(), "shared") {
+		t.Errorf("Expected the buckets of the accounts to be hidden from the root namespace, got %s", rec.Body.String())
+	}
+	if rec := serve(http.MethodHead, "/shared", "root-key"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the bucket to be missing in the root namespace, got %d", rec.Code)
+	}
+
+	t.Run("Groups", func(t *testing.T) {
+		accounts, err := auth.NewAccounts(map[string]auth.Account{
+			"team-a": {Groups: []string{"developers"}},
+			"team-b": {Groups: []string{"analysts"}},
+		})
+		if err != nil {
+			t.Fatalf("NewAccounts failed: %v", err)
+		}
+		authenticator := auth.NewAWS4Authenticator()
+		authenticator.AddCredentials("root-key", "secret")
+		expires := time.Now().Add(time.Hour)
+		authenticator.AddSession("ASIA-DEV", "secret", "token", []string{"developers"}, expires)
+		authenticator.AddSession("ASIA-OTHER", "secret", "token", []string{"other"}, expires)
+		authenticator.AddSession("ASIA-BOTH", "secret", "token", []string{"developers", "analysts"}, expires)
+		authenticator.SetWatchedCredentials([]auth.Credentials{{AccessKeyID: "watched-key", SecretAccessKey: "secret"}})
+
+		root, teamA, teamB := newStore(), newStore(), newStore()
+		handler := authenticator.AuthMiddleware(NewAccountHandler(accounts, map[string]http.Handler{
+			"team-a": NewS3Handler(teamA),
+			"team-b": NewS3Handler(teamB),
+		}, NewS3Handler(root)))
+
+		serve := func(bucket, accessKeyID, sessionToken string) int {
+			req := httptest.NewRequest(http.MethodPut, "/"+bucket, nil)
+			req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+			credentials := aws.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: "secret", SessionToken: sessionToken}
+			if err := v4.NewSigner().SignHTTP(t.Context(), credentials, req, "UNSIGNED-PAYLOAD", "s3", "us-east-1", time.Now()); err != nil {
+				t.Fatalf("Failed to sign request: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		if code := serve("dev-bucket", "ASIA-DEV", "token"); code != http.StatusOK || !teamA.BucketExists("dev-bucket") {
+			t.Errorf("Expected the group to select its account, got %d", code)
+		}
+		for _, accessKeyID := range []string{"ASIA-OTHER", "ASIA-BOTH", "watched-key"} {
+			token := "token"
+			if accessKeyID == "watched-key" {
+				token = ""
+			}
+			if code := serve("leaked-bucket", accessKeyID, token); code != http.StatusForbidden {
+				t.Errorf("Expected %s to be denied, got %d", accessKeyID, code)
+			}
+		}
+		if root.BucketExists("leaked-bucket") {
+			t.Error("Expected credentials of no account to stay out of the root namespace")
+		}
+		if code := serve("root-bucket", "root-key", ""); code != http.StatusOK || !root.BucketExists("root-bucket") {
+			t.Errorf("Expected static access keys of no account to use the root namespace, got %d", code)
+		}
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a missing namespace to panic")
+		}
+	}()
+	NewAccountHandler(accounts, map[string]http.Handler{"team-a": NewS3Handler(teamA)}, NewS3Handler(root))
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestAuditLog(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store, WithAuditLog(true))
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	requests := []struct {
+		method  string
+		target  string
+		headers map[string]string
+	}{
+		{http.MethodPut, "/test-bucket/a", nil},
+		{http.MethodPut, "/test-bucket/b", map[string]string{"x-amz-copy-source": "/test-bucket/a"}},
+		{http.MethodDelete, "/test-bucket/a", nil},
+		// Deleting a missing object changes nothing and is not audited
+		{http.MethodDelete, "/test-bucket/missing", nil},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("data"))
+		req.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s failed with status %d: %s", tt.method, tt.target, rec.Code, rec.Body.String())
+		}
+	}
+
+	getAuditLog := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket?audit"+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := getAuditLog("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var result AuditLog
+	if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse audit log: %v", err)
+	}
+
+	expected := []struct{ operation, key, source string }{
+		{"PutObject", "a", ""},
+		{"CopyObject", "b", "test-bucket/a"},
+		{"DeleteObject", "a", ""},
+	}
+	if len(result.Events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(result.Events))
+	}
+	for i, e := range expected {
+		event := result.Events[i]
+		if event.Operation != e.operation || event.Key != e.key || event.Source != e.source {
+			t.Errorf("Event %d: expected %+v, got %+v", i, e, event)
+		}
+		if event.RemoteAddr != "192.0.2.1:1234" || event.RequestId == "" {
+			t.Errorf("Event %d: expected remote address and request ID, got %+v", i, event)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec = getAuditLog("&start=" + future)
+	result = AuditLog{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse audit log: %v", err)
+	}
+	if len(result.Events) != 0 {
+		t.Errorf("Expected no events after %s, got %d", future, len(result.Events))
+	}
+
+	if rec := getAuditLog("&end=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid time, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// BenchmarkServerParallelGet measures GetObject over HTTP, where the response body copy dominates
+func BenchmarkServerParallelGet(b *testing.B) {
+	tmpDir, err := os.MkdirTemp("", "s3d-bench-*")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := storage.NewStorage(tmpDir)
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("bench-bucket"); err != nil {
+		b.Fatalf("CreateBucket failed: %v", err)
+	}
+	const size = 8 << 20
+	if _, err := store.PutObject(context.Background(), "bench-bucket", "object", strings.NewReader(strings.Repeat("x", size)), storage.Metadata{}, ""); err != nil {
+		b.Fatalf("PutObject failed: %v", err)
+	}
+
+	srv := httptest.NewServer(NewS3Handler(store))
+	defer srv.Close()
+	client := srv.Client()
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := client.Get(srv.URL + "/bench-bucket/object")
+			if err != nil {
+				b.Errorf("GET failed: %v", err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				b.Errorf("Expected status 200, got %d", resp.StatusCode)
+				return
+			}
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"encoding/xml"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/wzshiming/s3d/pkg/storage"
 )
@@ -41,10 +44,7 @@ func (s *S3Handler) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 	}
 
 	result := ListAllMyBucketsResult{
-		Owner: Owner{
-			ID:          "local-user",
-			DisplayName: "local-user",
-		},
+		Owner:  s.owner(),
 		Prefix: prefix,
 	}
 
@@ -55,16 +55,42 @@ func (s *S3Handler) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 	for _, b := range buckets {
 		result.Buckets.Bucket = append(result.Buckets.Bucket, Bucket{
 			Name:         b.Name,
-			CreationDate: b.ModTime,
+			CreationDate: Timestamp{b.CreationDate},
 		})
 	}
 
 	s.xmlResponse(w, r, result, http.StatusOK)
 }
 
+// handleListDirectoryBuckets handles ListDirectoryBuckets operation
+// There are no directory buckets, so S3 Express clients get an empty list instead of an error
+func (s *S3Handler) handleListDirectoryBuckets(w http.ResponseWriter, r *http.Request) {
+	s.xmlResponse(w, r, ListAllMyDirectoryBucketsResult{}, http.StatusOK)
+}
+
+// wormRetentionHeader sets the WORM retention in days when creating a bucket, and reports it on HeadBucket
+const wormRetentionHeader = "x-s3d-worm-retention-days"
+
 // handleCreateBucket handles CreateBucket operation
 func (s *S3Handler) handleCreateBucket(w http.ResponseWriter, r *http.Request, bucket string) {
-	err := s.storage.CreateBucket(bucket)
+	objectOwnership := r.Header.Get("x-amz-object-ownership")
+	if objectOwnership != "" && !isValidObjectOwnership(objectOwnership) {
+		s.errorResponse(w, r, "InvalidArgument", "Invalid x-amz-object-ownership header", http.StatusBadRequest)
+		return
+	}
+
+	// Buckets created with a WORM retention reject overwrites and deletes of recent objects
+	var err error
+	if days := r.Header.Get(wormRetentionHeader); days != "" {
+		n, parseErr := strconv.Atoi(days)
+		if parseErr != nil || n <= 0 {
+			s.errorResponse(w, r, "InvalidArgument", "Invalid "+wormRetentionHeader+" header, expected a positive number of days", http.StatusBadRequest)
+			return
+		}
+		err = s.storage.CreateWORMBucket(bucket, time.Duration(n)*24*time.Hour)
+	} else {
+		err = s.storage.CreateBucket(bucket)
+	}
 	if err != nil {
 		if err == storage.ErrBucketAlreadyExists {
 			s.errorResponse(w, r, "BucketAlreadyExists", "Bucket already exists", http.StatusConflict)
@@ -74,6 +100,13 @@ func (s *S3Handler) handleCreateBucket(w http.ResponseWriter, r *http.Request, b
 		return
 	}
 
+	if objectOwnership != "" {
+		if err := s.storage.PutBucketOwnershipControls(bucket, objectOwnership); err != nil {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	s.setHeaders(w, r)
 	w.WriteHeader(http.StatusOK)
 }
@@ -82,9 +115,12 @@ func (s *S3Handler) handleCreateBucket(w http.ResponseWriter, r *http.Request, b
 func (s *S3Handler) handleDeleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	err := s.storage.DeleteBucket(bucket)
 	if err != nil {
-		if err == storage.ErrBucketNotFound {
+		switch err {
+		case storage.ErrBucketNotFound:
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
-		} else {
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -94,6 +130,9 @@ func (s *S3Handler) handleDeleteBucket(w http.ResponseWriter, r *http.Request, b
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// creationDateHeader is the s3d extension header reporting the creation date of a bucket on HeadBucket
+const creationDateHeader = "x-s3d-creation-date"
+
 // handleHeadBucket handles HeadBucket operation
 func (s *S3Handler) handleHeadBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	if !s.storage.BucketExists(bucket) {
@@ -103,9 +142,428 @@ func (s *S3Handler) handleHeadBucket(w http.ResponseWriter, r *http.Request, buc
 	}
 
 	s.setHeaders(w, r)
+	if created, err := s.storage.GetBucketCreationDate(bucket); err == nil {
+		w.Header().Set(creationDateHeader, created.UTC().Format(http.TimeFormat))
+	}
+	if retention, err := s.storage.GetBucketWORMRetention(bucket); err == nil && retention > 0 {
+		w.Header().Set(wormRetentionHeader, strconv.Itoa(int(retention/(24*time.Hour))))
+	}
+	if frozen, err := s.storage.IsBucketFrozen(bucket); err == nil && frozen {
+		w.Header().Set(frozenHeader, "true")
+	}
+	if ttl, err := s.storage.GetBucketDefaultTTL(bucket); err == nil && ttl > 0 {
+		w.Header().Set(defaultTTLHeader, strconv.Itoa(durationDays(ttl)))
+	}
+	if retention, err := s.storage.GetBucketTrashRetention(bucket); err == nil && retention > 0 {
+		w.Header().Set(trashRetentionHeader, strconv.Itoa(durationDays(retention)))
+	}
 	// Return directory-like headers for s3fs-fuse compatibility
 	// This helps s3fs understand the bucket root as a directory
 	w.Header().Set("Content-Type", "application/x-directory")
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusOK)
 }
+
+// isValidObjectOwnership reports whether v is a valid object ownership setting
+func isValidObjectOwnership(v string) bool {
+	switch v {
+	case "BucketOwnerEnforced", "BucketOwnerPreferred", "ObjectWriter":
+		return true
+	}
+	return false
+}
+
+// handlePutBucketOwnershipControls handles PutBucketOwnershipControls operation
+func (s *S3Handler) handlePutBucketOwnershipControls(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req OwnershipControls
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Rules) != 1 || !isValidObjectOwnership(req.Rules[0].ObjectOwnership) {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.PutBucketOwnershipControls(bucket, req.Rules[0].ObjectOwnership)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetBucketOwnershipControls handles GetBucketOwnershipControls operation
+func (s *S3Handler) handleGetBucketOwnershipControls(w http.ResponseWriter, r *http.Request, bucket string) {
+	objectOwnership, err := s.storage.GetBucketOwnershipControls(bucket)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrOwnershipControlsNotFound:
+			s.errorResponse(w, r, "OwnershipControlsNotFoundError", "The bucket ownership controls were not found", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := OwnershipControls{
+		Rules: []OwnershipControlsRule{
+			{ObjectOwnership: objectOwnership},
+		},
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleDeleteBucketOwnershipControls handles DeleteBucketOwnershipControls operation
+func (s *S3Handler) handleDeleteBucketOwnershipControls(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := s.storage.DeleteBucketOwnershipControls(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePutPublicAccessBlock handles PutPublicAccessBlock operation
+func (s *S3Handler) handlePutPublicAccessBlock(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req PublicAccessBlockConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.PutPublicAccessBlock(bucket, storage.PublicAccessBlock{
+		BlockPublicAcls:       req.BlockPublicAcls,
+		IgnorePublicAcls:      req.IgnorePublicAcls,
+		BlockPublicPolicy:     req.BlockPublicPolicy,
+		RestrictPublicBuckets: req.RestrictPublicBuckets,
+	})
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetPublicAccessBlock handles GetPublicAccessBlock operation
+func (s *S3Handler) handleGetPublicAccessBlock(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := s.storage.GetPublicAccessBlock(bucket)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrPublicAccessBlockNotFound:
+			s.errorResponse(w, r, "NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := PublicAccessBlockConfiguration{
+		BlockPublicAcls:       config.BlockPublicAcls,
+		IgnorePublicAcls:      config.IgnorePublicAcls,
+		BlockPublicPolicy:     config.BlockPublicPolicy,
+		RestrictPublicBuckets: config.RestrictPublicBuckets,
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleDeletePublicAccessBlock handles DeletePublicAccessBlock operation
+func (s *S3Handler) handleDeletePublicAccessBlock(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := s.storage.DeletePublicAccessBlock(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePutBucketWebsite handles PutBucketWebsite operation
+func (s *S3Handler) handlePutBucketWebsite(w http.ResponseWriter, r *http.Request, bucket string) {
+	// The website endpoint finds buckets by host name alone, which accounts reusing bucket names make
+	// ambiguous, so it serves the root namespace only
+	if s.account != "" {
+		s.errorResponse(w, r, "InvalidRequest", "Website hosting is only available to the buckets of the root namespace", http.StatusBadRequest)
+		return
+	}
+
+	var req WebsiteConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	var config storage.WebsiteConfiguration
+	if req.RedirectAllRequestsTo != nil {
+		if req.RedirectAllRequestsTo.HostName == "" {
+			s.errorResponse(w, r, "InvalidArgument", "RedirectAllRequestsTo must contain a HostName", http.StatusBadRequest)
+			return
+		}
+		if req.IndexDocument != nil || req.ErrorDocument != nil || len(req.RoutingRules) != 0 {
+			s.errorResponse(w, r, "InvalidArgument", "RedirectAllRequestsTo cannot be combined with other website configuration", http.StatusBadRequest)
+			return
+		}
+		config.RedirectAllRequestsTo = &storage.WebsiteRedirect{
+			HostName: req.RedirectAllRequestsTo.HostName,
+			Protocol: req.RedirectAllRequestsTo.Protocol,
+		}
+	} else {
+		if req.IndexDocument == nil || req.IndexDocument.Suffix == "" || strings.Contains(req.IndexDocument.Suffix, "/") {
+			s.errorResponse(w, r, "InvalidArgument", "The IndexDocument Suffix is not well formed", http.StatusBadRequest)
+			return
+		}
+		config.IndexDocumentSuffix = req.IndexDocument.Suffix
+		if req.ErrorDocument != nil {
+			config.ErrorDocumentKey = req.ErrorDocument.Key
+		}
+		for _, rule := range req.RoutingRules {
+			if rule.Redirect.ReplaceKeyPrefixWith != "" && rule.Redirect.ReplaceKeyWith != "" {
+				s.errorResponse(w, r, "InvalidArgument", "You can only define ReplaceKeyPrefix or ReplaceKey but not both", http.StatusBadRequest)
+				return
+			}
+			if code := rule.Redirect.HttpRedirectCode; code != "" && !isRedirectCode(code) {
+				s.errorResponse(w, r, "InvalidArgument", "The provided HTTP redirect code is not valid", http.StatusBadRequest)
+				return
+			}
+			routingRule := storage.WebsiteRoutingRule{
+				Redirect: storage.WebsiteRedirect{
+					HostName:             rule.Redirect.HostName,
+					Protocol:             rule.Redirect.Protocol,
+					HttpRedirectCode:     rule.Redirect.HttpRedirectCode,
+					ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+					ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+				},
+			}
+			if rule.Condition != nil {
+				routingRule.Condition = &storage.WebsiteRoutingCondition{
+					KeyPrefixEquals:             rule.Condition.KeyPrefixEquals,
+					HttpErrorCodeReturnedEquals: rule.Condition.HttpErrorCodeReturnedEquals,
+				}
+			}
+			config.RoutingRules = append(config.RoutingRules, routingRule)
+		}
+	}
+
+	err := s.storage.PutBucketWebsite(bucket, config)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetBucketWebsite handles GetBucketWebsite operation
+func (s *S3Handler) handleGetBucketWebsite(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := s.storage.GetBucketWebsite(bucket)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrWebsiteNotFound:
+			s.errorResponse(w, r, "NoSuchWebsiteConfiguration", "The specified bucket does not have a website configuration", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var result WebsiteConfiguration
+	if config.RedirectAllRequestsTo != nil {
+		result.RedirectAllRequestsTo = &RedirectAllRequestsTo{
+			HostName: config.RedirectAllRequestsTo.HostName,
+			Protocol: config.RedirectAllRequestsTo.Protocol,
+		}
+	}
+	if config.IndexDocumentSuffix != "" {
+		result.IndexDocument = &IndexDocument{Suffix: config.IndexDocumentSuffix}
+	}
+	if config.ErrorDocumentKey != "" {
+		result.ErrorDocument = &ErrorDocument{Key: config.ErrorDocumentKey}
+	}
+	for _, rule := range config.RoutingRules {
+		routingRule := RoutingRule{
+			Redirect: RoutingRuleRedirect{
+				HostName:             rule.Redirect.HostName,
+				HttpRedirectCode:     rule.Redirect.HttpRedirectCode,
+				Protocol:             rule.Redirect.Protocol,
+				ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+				ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+			},
+		}
+		if rule.Condition != nil {
+			routingRule.Condition = &RoutingRuleCondition{
+				HttpErrorCodeReturnedEquals: rule.Condition.HttpErrorCodeReturnedEquals,
+				KeyPrefixEquals:             rule.Condition.KeyPrefixEquals,
+			}
+		}
+		result.RoutingRules = append(result.RoutingRules, routingRule)
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleDeleteBucketWebsite handles DeleteBucketWebsite operation
+func (s *S3Handler) handleDeleteBucketWebsite(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := s.storage.DeleteBucketWebsite(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePutBucketAccelerateConfiguration handles PutBucketAccelerateConfiguration operation
+func (s *S3Handler) handlePutBucketAccelerateConfiguration(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req AccelerateConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status != "Enabled" && req.Status != "Suspended" {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	// Transfer acceleration is not supported for bucket names containing dots
+	if strings.Contains(bucket, ".") {
+		s.errorResponse(w, r, "InvalidRequest", "S3 Transfer Acceleration is not supported for buckets with periods (.) in their names", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.PutBucketAccelerateConfiguration(bucket, req.Status)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetBucketAccelerateConfiguration handles GetBucketAccelerateConfiguration operation
+func (s *S3Handler) handleGetBucketAccelerateConfiguration(w http.ResponseWriter, r *http.Request, bucket string) {
+	status, err := s.storage.GetBucketAccelerateConfiguration(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := AccelerateConfiguration{
+		Status: status,
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleGetBucketLocation handles GetBucketLocation operation
+// Clients such as restic and kopia ask for it to sign their requests for the right region
+func (s *S3Handler) handleGetBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !s.storage.BucketExists(bucket) {
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	result := LocationConstraint{}
+	if s.region != "us-east-1" {
+		result.Region = s.region
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handlePutBucketRequestPayment handles PutBucketRequestPayment operation
+func (s *S3Handler) handlePutBucketRequestPayment(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req RequestPaymentConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	if req.Payer != "BucketOwner" && req.Payer != "Requester" {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	err := s.storage.PutBucketRequestPayment(bucket, req.Payer)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetBucketRequestPayment handles GetBucketRequestPayment operation
+func (s *S3Handler) handleGetBucketRequestPayment(w http.ResponseWriter, r *http.Request, bucket string) {
+	payer, err := s.storage.GetBucketRequestPayment(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := RequestPaymentConfiguration{
+		Payer: payer,
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
@@ -2,11 +2,18 @@ package server
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/wzshiming/s3d/pkg/storage"
 )
 
 func TestBucketOperations(t *testing.T) {
@@ -298,3 +305,680 @@ func TestListBucketsPrefix(t *testing.T) {
 		}
 	})
 }
+
+func TestBucketOwnershipControls(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-ownership-controls"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("GetNotConfigured", func(t *testing.T) {
+		_, err := ts.client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error when ownership controls are not configured")
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		_, err := ts.client.PutBucketOwnershipControls(ctx, &s3.PutBucketOwnershipControlsInput{
+			Bucket: aws.String(bucketName),
+			OwnershipControls: &types.OwnershipControls{
+				Rules: []types.OwnershipControlsRule{
+					{ObjectOwnership: types.ObjectOwnershipBucketOwnerPreferred},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutBucketOwnershipControls failed: %v", err)
+		}
+
+		output, err := ts.client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketOwnershipControls failed: %v", err)
+		}
+		if len(output.OwnershipControls.Rules) != 1 {
+			t.Fatalf("Expected 1 rule, got %d", len(output.OwnershipControls.Rules))
+		}
+		if output.OwnershipControls.Rules[0].ObjectOwnership != types.ObjectOwnershipBucketOwnerPreferred {
+			t.Fatalf("Expected BucketOwnerPreferred, got %s", output.OwnershipControls.Rules[0].ObjectOwnership)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := ts.client.DeleteBucketOwnershipControls(ctx, &s3.DeleteBucketOwnershipControlsInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("DeleteBucketOwnershipControls failed: %v", err)
+		}
+
+		_, err = ts.client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error after deleting ownership controls")
+		}
+	})
+
+	t.Run("CreateBucketWithObjectOwnership", func(t *testing.T) {
+		name := "test-ownership-on-create"
+		_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+			Bucket:          aws.String(name),
+			ObjectOwnership: types.ObjectOwnershipBucketOwnerEnforced,
+		})
+		if err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(name)})
+
+		output, err := ts.client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket: aws.String(name),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketOwnershipControls failed: %v", err)
+		}
+		if output.OwnershipControls.Rules[0].ObjectOwnership != types.ObjectOwnershipBucketOwnerEnforced {
+			t.Fatalf("Expected BucketOwnerEnforced, got %s", output.OwnershipControls.Rules[0].ObjectOwnership)
+		}
+	})
+
+	t.Run("NonexistentBucket", func(t *testing.T) {
+		_, err := ts.client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket: aws.String("nonexistent-ownership-bucket"),
+		})
+		if err == nil {
+			t.Fatal("Expected error for nonexistent bucket")
+		}
+	})
+}
+
+func TestPublicAccessBlock(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-public-access-block"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("GetNotConfigured", func(t *testing.T) {
+		_, err := ts.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error when public access block is not configured")
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		_, err := ts.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+			PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(false),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutPublicAccessBlock failed: %v", err)
+		}
+
+		output, err := ts.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetPublicAccessBlock failed: %v", err)
+		}
+		config := output.PublicAccessBlockConfiguration
+		if !aws.ToBool(config.BlockPublicAcls) || !aws.ToBool(config.IgnorePublicAcls) ||
+			aws.ToBool(config.BlockPublicPolicy) || !aws.ToBool(config.RestrictPublicBuckets) {
+			t.Fatalf("Unexpected configuration: %+v", config)
+		}
+	})
+
+	t.Run("BlockPublicAcls", func(t *testing.T) {
+		// Anonymous requests are denied by buckets ignoring public ACLs or restricting public buckets
+		_, err := ts.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+			PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+				BlockPublicAcls: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutPublicAccessBlock failed: %v", err)
+		}
+
+		_, err = ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("public-object"),
+			Body:   strings.NewReader("content"),
+			ACL:    types.ObjectCannedACLPublicRead,
+		})
+		if err == nil {
+			t.Fatal("Expected PutObject with public ACL to be rejected")
+		}
+
+		_, err = ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("private-object"),
+			Body:   strings.NewReader("content"),
+			ACL:    types.ObjectCannedACLPrivate,
+		})
+		if err != nil {
+			t.Fatalf("PutObject with private ACL failed: %v", err)
+		}
+		defer ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("private-object"),
+		})
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := ts.client.DeletePublicAccessBlock(ctx, &s3.DeletePublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("DeletePublicAccessBlock failed: %v", err)
+		}
+
+		_, err = ts.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error after deleting public access block")
+		}
+	})
+}
+
+func TestBucketWebsite(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-website"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("GetNotConfigured", func(t *testing.T) {
+		_, err := ts.client.GetBucketWebsite(ctx, &s3.GetBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error when website is not configured")
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		_, err := ts.client.PutBucketWebsite(ctx, &s3.PutBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+			WebsiteConfiguration: &types.WebsiteConfiguration{
+				IndexDocument: &types.IndexDocument{Suffix: aws.String("index.html")},
+				ErrorDocument: &types.ErrorDocument{Key: aws.String("404.html")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutBucketWebsite failed: %v", err)
+		}
+
+		output, err := ts.client.GetBucketWebsite(ctx, &s3.GetBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketWebsite failed: %v", err)
+		}
+		if output.IndexDocument == nil || aws.ToString(output.IndexDocument.Suffix) != "index.html" {
+			t.Fatalf("Unexpected IndexDocument: %+v", output.IndexDocument)
+		}
+		if output.ErrorDocument == nil || aws.ToString(output.ErrorDocument.Key) != "404.html" {
+			t.Fatalf("Unexpected ErrorDocument: %+v", output.ErrorDocument)
+		}
+	})
+
+	t.Run("RoutingRules", func(t *testing.T) {
+		_, err := ts.client.PutBucketWebsite(ctx, &s3.PutBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+			WebsiteConfiguration: &types.WebsiteConfiguration{
+				IndexDocument: &types.IndexDocument{Suffix: aws.String("index.html")},
+				RoutingRules: []types.RoutingRule{
+					{
+						Condition: &types.Condition{KeyPrefixEquals: aws.String("docs/")},
+						Redirect:  &types.Redirect{ReplaceKeyPrefixWith: aws.String("documents/")},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutBucketWebsite failed: %v", err)
+		}
+
+		output, err := ts.client.GetBucketWebsite(ctx, &s3.GetBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketWebsite failed: %v", err)
+		}
+		if len(output.RoutingRules) != 1 {
+			t.Fatalf("Expected 1 routing rule, got %d", len(output.RoutingRules))
+		}
+		rule := output.RoutingRules[0]
+		if rule.Condition == nil || aws.ToString(rule.Condition.KeyPrefixEquals) != "docs/" {
+			t.Errorf("Unexpected condition: %+v", rule.Condition)
+		}
+		if rule.Redirect == nil || aws.ToString(rule.Redirect.ReplaceKeyPrefixWith) != "documents/" {
+			t.Errorf("Unexpected redirect: %+v", rule.Redirect)
+		}
+	})
+
+	t.Run("RoutingRuleInvalidRedirect", func(t *testing.T) {
+		_, err := ts.client.PutBucketWebsite(ctx, &s3.PutBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+			WebsiteConfiguration: &types.WebsiteConfiguration{
+				IndexDocument: &types.IndexDocument{Suffix: aws.String("index.html")},
+				RoutingRules: []types.RoutingRule{
+					{
+						Redirect: &types.Redirect{
+							ReplaceKeyPrefixWith: aws.String("a/"),
+							ReplaceKeyWith:       aws.String("b.html"),
+						},
+					},
+				},
+			},
+		})
+		if err == nil {
+			t.Fatal("Expected error when both ReplaceKeyPrefixWith and ReplaceKeyWith are set")
+		}
+	})
+
+	t.Run("RoutingRuleInvalidRedirectCode", func(t *testing.T) {
+		_, err := ts.client.PutBucketWebsite(ctx, &s3.PutBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+			WebsiteConfiguration: &types.WebsiteConfiguration{
+				IndexDocument: &types.IndexDocument{Suffix: aws.String("index.html")},
+				RoutingRules: []types.RoutingRule{
+					{
+						Redirect: &types.Redirect{
+							ReplaceKeyPrefixWith: aws.String("a/"),
+							HttpRedirectCode:     aws.String("200"),
+						},
+					},
+				},
+			},
+		})
+		if err == nil {
+			t.Fatal("Expected error for a redirect code outside of 3xx")
+		}
+	})
+
+	t.Run("PutInvalidSuffix", func(t *testing.T) {
+		_, err := ts.client.PutBucketWebsite(ctx, &s3.PutBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+			WebsiteConfiguration: &types.WebsiteConfiguration{
+				IndexDocument: &types.IndexDocument{Suffix: aws.String("dir/index.html")},
+			},
+		})
+		if err == nil {
+			t.Fatal("Expected error for index document suffix containing a slash")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := ts.client.DeleteBucketWebsite(ctx, &s3.DeleteBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("DeleteBucketWebsite failed: %v", err)
+		}
+
+		_, err = ts.client.GetBucketWebsite(ctx, &s3.GetBucketWebsiteInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err == nil {
+			t.Fatal("Expected error after deleting website configuration")
+		}
+	})
+}
+
+func TestBucketAccelerateConfiguration(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-accelerate"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("GetNotConfigured", func(t *testing.T) {
+		output, err := ts.client.GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketAccelerateConfiguration failed: %v", err)
+		}
+		if output.Status != "" {
+			t.Fatalf("Expected empty status, got %q", output.Status)
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		for _, status := range []types.BucketAccelerateStatus{types.BucketAccelerateStatusEnabled, types.BucketAccelerateStatusSuspended} {
+			_, err := ts.client.PutBucketAccelerateConfiguration(ctx, &s3.PutBucketAccelerateConfigurationInput{
+				Bucket: aws.String(bucketName),
+				AccelerateConfiguration: &types.AccelerateConfiguration{
+					Status: status,
+				},
+			})
+			if err != nil {
+				t.Fatalf("PutBucketAccelerateConfiguration failed: %v", err)
+			}
+
+			output, err := ts.client.GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{
+				Bucket: aws.String(bucketName),
+			})
+			if err != nil {
+				t.Fatalf("GetBucketAccelerateConfiguration failed: %v", err)
+			}
+			if output.Status != status {
+				t.Fatalf("Expected status %q, got %q", status, output.Status)
+			}
+		}
+	})
+
+	t.Run("NonexistentBucket", func(t *testing.T) {
+		_, err := ts.client.GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{
+			Bucket: aws.String("nonexistent-accelerate-bucket"),
+		})
+		if err == nil {
+			t.Fatal("Expected error for nonexistent bucket")
+		}
+	})
+}
+
+func TestGetBucketLocation(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-location"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("DefaultRegion", func(t *testing.T) {
+		output, err := ts.client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketLocation failed: %v", err)
+		}
+		if output.LocationConstraint != "" {
+			t.Fatalf("Expected an empty location constraint for us-east-1, got %q", output.LocationConstraint)
+		}
+	})
+
+	t.Run("OtherRegion", func(t *testing.T) {
+		store, err := storage.NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		defer store.Close()
+		if err := store.CreateBucket(bucketName); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/"+bucketName+"?location", nil)
+		rec := httptest.NewRecorder()
+		NewS3Handler(store, WithRegion("eu-west-1")).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var result LocationConstraint
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse location: %v", err)
+		}
+		if result.Region != "eu-west-1" {
+			t.Fatalf("Expected location constraint eu-west-1, got %q", result.Region)
+		}
+	})
+
+	t.Run("NonexistentBucket", func(t *testing.T) {
+		_, err := ts.client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+			Bucket: aws.String("nonexistent-location-bucket"),
+		})
+		if err == nil {
+			t.Fatal("Expected error for nonexistent bucket")
+		}
+	})
+}
+
+func TestBucketRequestPayment(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-request-payment"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	t.Run("GetDefault", func(t *testing.T) {
+		output, err := ts.client.GetBucketRequestPayment(ctx, &s3.GetBucketRequestPaymentInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketRequestPayment failed: %v", err)
+		}
+		if output.Payer != types.PayerBucketOwner {
+			t.Fatalf("Expected payer BucketOwner, got %q", output.Payer)
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		_, err := ts.client.PutBucketRequestPayment(ctx, &s3.PutBucketRequestPaymentInput{
+			Bucket: aws.String(bucketName),
+			RequestPaymentConfiguration: &types.RequestPaymentConfiguration{
+				Payer: types.PayerRequester,
+			},
+		})
+		if err != nil {
+			t.Fatalf("PutBucketRequestPayment failed: %v", err)
+		}
+
+		output, err := ts.client.GetBucketRequestPayment(ctx, &s3.GetBucketRequestPaymentInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			t.Fatalf("GetBucketRequestPayment failed: %v", err)
+		}
+		if output.Payer != types.PayerRequester {
+			t.Fatalf("Expected payer Requester, got %q", output.Payer)
+		}
+	})
+
+	t.Run("NonexistentBucket", func(t *testing.T) {
+		_, err := ts.client.GetBucketRequestPayment(ctx, &s3.GetBucketRequestPaymentInput{
+			Bucket: aws.String("nonexistent-request-payment-bucket"),
+		})
+		if err == nil {
+			t.Fatal("Expected error for nonexistent bucket")
+		}
+	})
+}
+
+func TestWORMBucket(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	do := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("data"))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, days := range []string{"0", "-1", "abc"} {
+		if rec := do(http.MethodPut, "/invalid-worm-bucket", map[string]string{wormRetentionHeader: days}); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for retention %q, got %d", http.StatusBadRequest, days, rec.Code)
+		}
+	}
+
+	if rec := do(http.MethodPut, "/worm-bucket", map[string]string{wormRetentionHeader: "30"}); rec.Code != http.StatusOK {
+		t.Fatalf("CreateBucket failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodHead, "/worm-bucket", nil); rec.Header().Get(wormRetentionHeader) != "30" {
+		t.Errorf("Expected HeadBucket to report 30 days of retention, got %q", rec.Header().Get(wormRetentionHeader))
+	}
+
+	if rec := do(http.MethodPut, "/worm-bucket/object", nil); rec.Code != http.StatusOK {
+		t.Fatalf("PutObject failed with status %d", rec.Code)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		method string
+	}{
+		{"Overwrite", http.MethodPut},
+		{"Delete", http.MethodDelete},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, "/worm-bucket/object", nil)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != "AccessDenied" {
+				t.Errorf("Expected AccessDenied, got %s", errResp.Code)
+			}
+		})
+	}
+}
+
+func TestWORMBucketDeleteObjects(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateWORMBucket("worm-bucket", time.Hour); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "worm-bucket", "locked", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	body := `<Delete><Object><Key>locked</Key></Object><Object><Key>missing</Key></Object></Delete>`
+	req := httptest.NewRequest(http.MethodPost, "/worm-bucket?delete", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var result DeleteObjectsResult
+	if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Key != "locked" || result.Errors[0].Code != "AccessDenied" {
+		t.Errorf("Expected AccessDenied for the locked key, got %+v", result.Errors)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0].Key != "missing" {
+		t.Errorf("Expected the missing key to be reported deleted, got %+v", result.Deleted)
+	}
+}
+
+func TestListDirectoryBuckets(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-directory"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	// General purpose buckets are not directory buckets
+	output, err := ts.client.ListDirectoryBuckets(ctx, &s3.ListDirectoryBucketsInput{})
+	if err != nil {
+		t.Fatalf("ListDirectoryBuckets failed: %v", err)
+	}
+	if len(output.Buckets) != 0 {
+		t.Fatalf("Expected no directory buckets, got %d", len(output.Buckets))
+	}
+}
+
+func TestBucketCreationDate(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	created, err := store.GetBucketCreationDate("test-bucket")
+	if err != nil {
+		t.Fatalf("Failed to get creation date: %v", err)
+	}
+
+	handler := NewS3Handler(store)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/test-bucket", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HeadBucket failed with status %d", rec.Code)
+	}
+	header, err := http.ParseTime(rec.Header().Get(creationDateHeader))
+	if err != nil {
+		t.Fatalf("Failed to parse %s %q: %v", creationDateHeader, rec.Header().Get(creationDateHeader), err)
+	}
+	if !header.Equal(created.Truncate(time.Second)) {
+		t.Errorf("Expected HeadBucket creation date %v, got %v", created, header)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var result ListAllMyBucketsResult
+	if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse ListBuckets response: %v", err)
+	}
+	if len(result.Buckets.Bucket) != 1 || !result.Buckets.Bucket[0].CreationDate.Equal(created.Truncate(time.Millisecond)) {
+		t.Errorf("Expected ListBuckets creation date %v, got %+v", created, result.Buckets.Bucket)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// lockTokenHeader carries the token of an advisory lock, returned when it is acquired
+// and required to renew or release it
+const lockTokenHeader = "x-s3d-lock-token"
+
+// lockTTLHeader is for how many seconds an advisory lock is acquired or renewed, defaultLockTTL if unset
+const lockTTLHeader = "x-s3d-lock-ttl-seconds"
+
+const (
+	// defaultLockTTL is how long locks are held when the client does not say
+	defaultLockTTL = time.Minute
+	// maxLockTTL bounds how long a lock of a client that went away blocks the others
+	maxLockTTL = 24 * time.Hour
+	// maxLockInfoSize is the largest description of its holder a lock keeps
+	maxLockInfoSize = 4096
+)
+
+// BucketLockResult is the response of the lock extension endpoint
+type BucketLockResult struct {
+	XMLName xml.Name `xml:"BucketLock"`
+	Name    string   `xml:"Name"`
+	// Token is only returned to the holder
+	Token      string    `xml:"Token,omitempty"`
+	Info       string    `xml:"Info,omitempty"`
+	AcquiredAt Timestamp `xml:"AcquiredAt"`
+	ExpiresAt  Timestamp `xml:"ExpiresAt"`
+}
+
+// handlePutBucketLock acquires the advisory lock named by the lock parameter, described by the request body,
+// or renews it when the request carries its token
+func (s *S3Handler) handlePutBucketLock(w http.ResponseWriter, r *http.Request, bucket string) {
+	name := r.URL.Query().Get("lock")
+	ttl := defaultLockTTL
+	if value := r.Header.Get(lockTTLHeader); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxLockTTL {
+			s.errorResponse(w, r, "InvalidArgument", fmt.Sprintf("Invalid %s header, expected a number of seconds up to %d", lockTTLHeader, int(maxLockTTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	var lock *storage.BucketLock
+	var err error
+	if token := r.Header.Get(lockTokenHeader); token != "" {
+		lock, err = s.storage.RenewLock(bucket, name, token, ttl)
+	} else {
+		info, readErr := io.ReadAll(io.LimitReader(r.Body, maxLockInfoSize+1))
+		if readErr != nil {
+			s.errorResponse(w, r, "IncompleteBody", "The request body could not be read", http.StatusBadRequest)
+			return
+		}
+		if len(info) > maxLockInfoSize {
+			s.errorResponse(w, r, "InvalidArgument", fmt.Sprintf("The lock info must not exceed %d bytes", maxLockInfoSize), http.StatusBadRequest)
+			return
+		}
+		lock, err = s.storage.AcquireLock(bucket, name, string(info), ttl)
+	}
+	if err != nil {
+		s.lockErrorResponse(w, r, lock, err)
+		return
+	}
+
+	w.Header().Set(lockTokenHeader, lock.Token)
+	s.xmlResponse(w, r, bucketLockResult(lock), http.StatusOK)
+}
+
+// handleGetBucketLock returns who holds the advisory lock named by the lock parameter and until when
+func (s *S3Handler) handleGetBucketLock(w http.ResponseWriter, r *http.Request, bucket string) {
+	lock, err := s.storage.GetLock(bucket, r.URL.Query().Get("lock"))
+	if err != nil {
+		s.lockErrorResponse(w, r, lock, err)
+		return
+	}
+	s.xmlResponse(w, r, bucketLockResult(lock), http.StatusOK)
+}
+
+// handleDeleteBucketLock releases the advisory lock named by the lock parameter held with the token of the request
+func (s *S3Handler) handleDeleteBucketLock(w http.ResponseWriter, r *http.Request, bucket string) {
+	token := r.Header.Get(lockTokenHeader)
+	if token == "" {
+		s.errorResponse(w, r, "InvalidRequest", "Missing "+lockTokenHeader+" header", http.StatusBadRequest)
+		return
+	}
+	if err := s.storage.ReleaseLock(bucket, r.URL.Query().Get("lock"), token); err != nil {
+		s.lockErrorResponse(w, r, nil, err)
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockErrorResponse writes the error of a lock operation, naming the holder of a lock held by another client
+func (s *S3Handler) lockErrorResponse(w http.ResponseWriter, r *http.Request, lock *storage.BucketLock, err error) {
+	switch err {
+	case storage.ErrBucketNotFound:
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+	case storage.ErrLockNotFound:
+		s.errorResponse(w, r, "NoSuchLock", "The lock is not held", http.StatusNotFound)
+	case storage.ErrLockHeld:
+		message := "The lock is held by another client"
+		if lock != nil {
+			message = fmt.Sprintf("The lock is held by another client until %s: %s", lock.ExpiresAt.UTC().Format(time.RFC3339), lock.Info)
+		}
+		s.errorResponse(w, r, "LockHeld", message, http.StatusConflict)
+	default:
+		s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// bucketLockResult converts a lock to its response
+func bucketLockResult(lock *storage.BucketLock) BucketLockResult {
+	return BucketLockResult{
+		Name:       lock.Name,
+		Token:      lock.Token,
+		Info:       lock.Info,
+		AcquiredAt: Timestamp{lock.AcquiredAt},
+		ExpiresAt:  Timestamp{lock.ExpiresAt},
+	}
+}
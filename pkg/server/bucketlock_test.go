@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestBucketLockHandlers(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	handler := NewS3Handler(store)
+	do := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) BucketLockResult {
+		t.Helper()
+		var result BucketLockResult
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse lock response: %v", err)
+		}
+		return result
+	}
+
+	rec := do(http.MethodPut, "/test-bucket?lock=terraform/state", "alice@host", map[string]string{lockTTLHeader: "30"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the lock to be acquired, got %d: %s", rec.Code, rec.Body.String())
+	}
+	acquired := decode(rec)
+	token := rec.Header().Get(lockTokenHeader)
+	if token == "" || acquired.Token != token || acquired.Name != "terraform/state" || acquired.Info != "alice@host" {
+		t.Fatalf("Expected the lock with its token, got %+v and header %q", acquired, token)
+	}
+	if ttl := acquired.ExpiresAt.Sub(acquired.AcquiredAt.Time); ttl.Seconds() != 30 {
+		t.Errorf("Expected a TTL of 30 seconds, got %v", ttl)
+	}
+
+	if rec := do(http.MethodPut, "/test-bucket?lock=terraform/state", "bob@host", nil); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "alice@host") {
+		t.Errorf("Expected the held lock to conflict naming its holder, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodGet, "/test-bucket?lock=terraform/state", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the lock to be returned, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := decode(rec); got.Token != "" || got.Info != "alice@host" {
+		t.Errorf("Expected the lock without its token, got %+v", got)
+	}
+
+	rec = do(http.MethodPut, "/test-bucket?lock=terraform/state", "", map[string]string{lockTokenHeader: token, lockTTLHeader: "3600"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the lock to be renewed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if renewed := decode(rec); !renewed.ExpiresAt.After(acquired.ExpiresAt.Time) || renewed.Info != "alice@host" {
+		t.Errorf("Expected the lock to be extended, got %+v", renewed)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		status  int
+	}{
+		{"RenewOtherToken", http.MethodPut, "/test-bucket?lock=terraform/state", map[string]string{lockTokenHeader: "wrong"}, http.StatusConflict},
+		{"ReleaseOtherToken", http.MethodDelete, "/test-bucket?lock=terraform/state", map[string]string{lockTokenHeader: "wrong"}, http.StatusConflict},
+		{"ReleaseWithoutToken", http.MethodDelete, "/test-bucket?lock=terraform/state", nil, http.StatusBadRequest},
+		{"InvalidTTL", http.MethodPut, "/test-bucket?lock=other", map[string]string{lockTTLHeader: "0"}, http.StatusBadRequest},
+		{"TTLTooLong", http.MethodPut, "/test-bucket?lock=other", map[string]string{lockTTLHeader: "86401"}, http.StatusBadRequest},
+		{"MissingBucket", http.MethodPut, "/missing-bucket?lock=state", nil, http.StatusNotFound},
+		{"NotHeld", http.MethodGet, "/test-bucket?lock=other", nil, http.StatusNotFound},
+		{"Release", http.MethodDelete, "/test-bucket?lock=terraform/state", map[string]string{lockTokenHeader: token}, http.StatusNoContent},
+		{"Released", http.MethodGet, "/test-bucket?lock=terraform/state", nil, http.StatusNotFound},
+		{"MethodNotAllowed", http.MethodPost, "/test-bucket?lock=terraform/state", nil, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.target, "", tt.headers); rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if rec := do(http.MethodPut, "/test-bucket?lock=big", strings.Repeat("x", maxLockInfoSize+1), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected oversized lock info to be rejected, got %d", rec.Code)
+	}
+
+	// Locks are not objects
+	if objects, _, err := store.ListObjects("test-bucket", "", "", "", 100); err != nil || len(objects) != 0 {
+		t.Errorf("Expected no objects in the bucket, got %v (%v)", objects, err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// minCompressSize is the smallest object worth compressing on the fly
+const minCompressSize = 1024
+
+// acceptedEncoding returns the preferred encoding among gzip and deflate
+// accepted by the Accept-Encoding header, or an empty string
+func acceptedEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					continue
+				}
+			}
+			accepted[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter compresses the body of successful responses
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	head     bool
+	writer   io.WriteCloser
+}
+
+// WriteHeader replaces Content-Length with Content-Encoding for full responses
+// Other responses, such as 304 Not Modified, are written unchanged
+func (c *compressWriter) WriteHeader(status int) {
+	if status == http.StatusOK {
+		c.Header().Del("Content-Length")
+		c.Header().Set("Content-Encoding", c.encoding)
+		// HEAD responses carry the headers of the compressed GET response but no body
+		if !c.head {
+			c.writer = c.newWriter()
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// newWriter creates the compressor for the negotiated encoding
+func (c *compressWriter) newWriter() io.WriteCloser {
+	if c.encoding == "gzip" {
+		return gzip.NewWriter(c.ResponseWriter)
+	}
+	return zlib.NewWriter(c.ResponseWriter)
+}
+
+// Write writes compressed data if compression started
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.writer == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.writer.Write(b)
+}
+
+// Close flushes the compressed stream
+func (c *compressWriter) Close() error {
+	if c.writer == nil {
+		return nil
+	}
+	return c.writer.Close()
+}
+
+// serveContent serves an object like http.ServeContent, compressing it with gzip or deflate
+// when compress is set, the client accepts it, the content type is compressible and the object
+// was not stored with a Content-Encoding of its own
+// Range requests are always served uncompressed so byte offsets refer to the stored object
+func serveContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, size int64, content io.ReadSeeker, compress bool) {
+	encoding := ""
+	if compress && size >= minCompressSize && r.Header.Get("Range") == "" && w.Header().Get("Content-Encoding") == "" && storage.IsCompressible(w.Header().Get("Content-Type")) {
+		encoding = acceptedEncoding(r)
+	}
+
+	if compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if encoding == "" {
+		http.ServeContent(w, r, name, modTime, content)
+		return
+	}
+
+	// The compressed representation differs byte-wise from the stored object
+	if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		w.Header().Set("ETag", "W/"+etag)
+	}
+	// Checksums describe the stored bytes, not the compressed body
+	w.Header().Del("x-amz-checksum-sha256")
+
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding, head: r.Method == http.MethodHead}
+	defer cw.Close()
+	http.ServeContent(cw, r, name, modTime, content)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestCompression(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	text := strings.Repeat("hello compressible world\n", 100)
+	if _, err := store.PutObject(context.Background(), "test-bucket", "page.html", strings.NewReader(text), storage.Metadata{ContentType: "text/html; charset=utf-8"}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "image.png", strings.NewReader(text), storage.Metadata{ContentType: "image/png"}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "small.txt", strings.NewReader("small"), storage.Metadata{ContentType: "text/plain"}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	handler := NewS3Handler(store, WithCompression(true))
+
+	get := func(handler http.Handler, key string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/"+key, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		rec := get(handler, "page.html", map[string]string{"Accept-Encoding": "gzip, deflate"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Expected gzip Content-Encoding, got %q", got)
+		}
+		if !strings.HasPrefix(rec.Header().Get("ETag"), "W/") {
+			t.Errorf("Expected weak ETag, got %q", rec.Header().Get("ETag"))
+		}
+
+		reader, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress body: %v", err)
+		}
+		if string(body) != text {
+			t.Error("Decompressed body does not match object")
+		}
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		rec := get(handler, "page.html", map[string]string{"Accept-Encoding": "gzip;q=0, deflate"})
+		if got := rec.Header().Get("Content-Encoding"); got != "deflate" {
+			t.Fatalf("Expected deflate Content-Encoding, got %q", got)
+		}
+
+		reader, err := zlib.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Failed to create zlib reader: %v", err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress body: %v", err)
+		}
+		if string(body) != text {
+			t.Error("Decompressed body does not match object")
+		}
+	})
+
+	t.Run("StoredEncoding", func(t *testing.T) {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write([]byte(text))
+		zw.Close()
+		if _, err := store.PutObject(context.Background(), "test-bucket", "page.html.gz", bytes.NewReader(compressed.Bytes()), storage.Metadata{ContentType: "text/html", ContentEncoding: "gzip"}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		// Objects stored with an encoding are served as stored, never compressed twice
+		rec := get(handler, "page.html.gz", map[string]string{"Accept-Encoding": "gzip"})
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Expected the stored gzip Content-Encoding, got %q", got)
+		}
+		if strings.HasPrefix(rec.Header().Get("ETag"), "W/") {
+			t.Errorf("Expected strong ETag, got %q", rec.Header().Get("ETag"))
+		}
+		if !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()) {
+			t.Error("Expected the stored gzip body")
+		}
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		key     string
+		headers map[string]string
+	}{
+		{name: "NotAccepted", handler: handler, key: "page.html"},
+		{name: "AlreadyCompressed", handler: handler, key: "image.png", headers: map[string]string{"Accept-Encoding": "gzip"}},
+		{name: "TooSmall", handler: handler, key: "small.txt", headers: map[string]string{"Accept-Encoding": "gzip"}},
+		{name: "Range", handler: handler, key: "page.html", headers: map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}},
+		{name: "Disabled", handler: NewS3Handler(store), key: "page.html", headers: map[string]string{"Accept-Encoding": "gzip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.handler, tt.key, tt.headers)
+			if rec.Code != http.StatusOK && rec.Code != http.StatusPartialContent {
+				t.Fatalf("Unexpected status %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Expected uncompressed response, got Content-Encoding %q", got)
+			}
+			if rec.Header().Get("Content-Length") == "" {
+				t.Error("Expected Content-Length on uncompressed response")
+			}
+		})
+	}
+}
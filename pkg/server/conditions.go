@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// errContentMD5Mismatch is returned by the body of a request once it is read to the end
+// and its MD5 does not match the Content-MD5 header
+var errContentMD5Mismatch = errors.New("content md5 mismatch")
+
+// contentMD5Body returns the body of r, checked against its Content-MD5 header if there is one
+// It reports false if the header is not the base64 encoding of an MD5 sum
+func contentMD5Body(r *http.Request) (io.Reader, bool) {
+	header := r.Header.Get("Content-MD5")
+	if header == "" {
+		return r.Body, true
+	}
+	expected, err := base64.StdEncoding.DecodeString(header)
+	if err != nil || len(expected) != md5.Size {
+		return nil, false
+	}
+	return &md5Reader{r: r.Body, hash: md5.New(), expected: expected}, true
+}
+
+// md5Reader hashes what it reads and fails with errContentMD5Mismatch at the end
+// if the sum differs from expected, so that storage discards the data
+type md5Reader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+func (r *md5Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.expected) {
+		return n, errContentMD5Mismatch
+	}
+	return n, err
+}
+
+// writeCondition returns the precondition of the If-Match and If-None-Match headers of a write
+// It reports false if If-None-Match is set to anything but "*", the only value S3 supports on writes
+func (s *S3Handler) writeCondition(r *http.Request) (storage.Condition, bool) {
+	cond := storage.Condition{
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		cond.IfMatch = s.storedETag(ifMatch)
+	}
+	if cond.IfNoneMatch != "" && cond.IfNoneMatch != "*" {
+		return cond, false
+	}
+	return cond, true
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// consolePageSize is the number of objects listed per page of the console
+const consolePageSize = 1000
+
+//go:embed console.html
+var consoleFS embed.FS
+
+var consoleTemplates = template.Must(template.New("console").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	},
+	"formatSize": formatSize,
+}).ParseFS(consoleFS, "console.html"))
+
+// ConsoleHandler serves a web console for browsing buckets and objects, uploading and downloading
+// objects, sharing them through presigned URLs and viewing bucket settings
+// It trusts the requests it gets to be authenticated, the access key and secret of HTTP Basic credentials
+// being those presigned URLs are signed with
+type ConsoleHandler struct {
+	storage  *storage.Storage
+	mux      *http.ServeMux
+	csrf     *http.CrossOriginProtection
+	endpoint string
+	region   string
+	readOnly bool
+}
+
+// ConsoleOption is a functional option for configuring ConsoleHandler
+type ConsoleOption func(*ConsoleHandler)
+
+// WithConsoleEndpoint sets the base URL of the S3 endpoint presigned URLs are generated for and its region
+// An endpoint without a host, such as http://:8080, stands for the host the console is reached on,
+// and presigned URLs cannot be generated without an endpoint
+func WithConsoleEndpoint(endpoint, region string) ConsoleOption {
+	return func(h *ConsoleHandler) {
+		h.endpoint = endpoint
+		h.region = region
+	}
+}
+
+// WithConsoleReadOnly hides uploads and rejects them
+func WithConsoleReadOnly(enabled bool) ConsoleOption {
+	return func(h *ConsoleHandler) {
+		h.readOnly = enabled
+	}
+}
+
+// NewConsoleHandler creates a new web console handler
+func NewConsoleHandler(storage *storage.Storage, opts ...ConsoleOption) *ConsoleHandler {
+	h := &ConsoleHandler{
+		storage: storage,
+		mux:     http.NewServeMux(),
+		csrf:    http.NewCrossOriginProtection(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("GET /{$}", h.listBuckets)
+	h.mux.HandleFunc("GET /buckets/{bucket}", h.listObjects)
+	h.mux.HandleFunc("POST /buckets/{bucket}", h.upload)
+	h.mux.HandleFunc("GET /buckets/{bucket}/settings", h.settings)
+	h.mux.HandleFunc("GET /download/{bucket}", h.download)
+	h.mux.HandleFunc("GET /presign/{bucket}", h.presign)
+	return h
+}
+
+// ServeHTTP handles console requests
+// Browsers send Basic credentials along with requests from other sites, so cross-origin writes are rejected
+func (h *ConsoleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+	if err := h.csrf.Check(r); err != nil {
+		h.renderError(w, r, err.Error(), http.StatusForbidden)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// consolePage holds what every page shows
+type consolePage struct {
+	Title       string
+	AccessKeyID string
+}
+
+// page returns the common data of a page
+func (h *ConsoleHandler) page(r *http.Request, title string) consolePage {
+	return consolePage{
+		Title:       title,
+		AccessKeyID: auth.AccessKeyIDFromContext(r.Context()),
+	}
+}
+
+// render writes a page
+func (h *ConsoleHandler) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	if err := consoleTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Failed to render console page %s: %v", name, err)
+	}
+}
+
+// renderError writes an error page
+func (h *ConsoleHandler) renderError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	w.WriteHeader(status)
+	h.render(w, "error", struct {
+		consolePage
+		Message string
+	}{h.page(r, "Error"), message})
+}
+
+// renderStorageError writes the error page of a storage error
+func (h *ConsoleHandler) renderStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrBucketNotFound), errors.Is(err, storage.ErrObjectNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidBucketName), errors.Is(err, storage.ErrInvalidObjectKey):
+		status = http.StatusBadRequest
+	case errors.Is(err, storage.ErrObjectLocked), errors.Is(err, storage.ErrBucketFrozen), errors.Is(err, storage.ErrReadReplica):
+		status = http.StatusForbidden
+	case errors.Is(err, storage.ErrEntityTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrInsufficientStorage):
+		status = http.StatusInsufficientStorage
+	}
+	h.renderError(w, r, err.Error(), status)
+}
+
+// listBuckets shows the buckets
+func (h *ConsoleHandler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := h.storage.ListBuckets("", "", 0)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	h.render(w, "buckets", struct {
+		consolePage
+		Buckets []storage.BucketInfo
+	}{h.page(r, "Buckets"), buckets})
+}
+
+// consoleEntry is a directory or object listed by the console
+type consoleEntry struct {
+	Name    string
+	Prefix  string
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// listObjects shows the directories and objects of a prefix, a page at a time
+func (h *ConsoleHandler) listObjects(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	prefix := r.URL.Query().Get("prefix")
+	objects, prefixes, err := h.storage.ListObjects(bucket, prefix, "/", r.URL.Query().Get("marker"), consolePageSize)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+
+	data := struct {
+		consolePage
+		Bucket     string
+		Prefix     string
+		Crumbs     []consoleEntry
+		Prefixes   []consoleEntry
+		Objects    []consoleEntry
+		NextMarker string
+		ReadOnly   bool
+	}{
+		consolePage: h.page(r, bucket),
+		Bucket:      bucket,
+		Prefix:      prefix,
+		ReadOnly:    h.readOnly || h.storage.IsReadReplica(),
+	}
+	for i := 0; i < len(prefix); {
+		end := strings.Index(prefix[i:], "/")
+		if end == -1 {
+			break
+		}
+		data.Crumbs = append(data.Crumbs, consoleEntry{Name: prefix[i : i+end], Prefix: prefix[:i+end+1]})
+		i += end + 1
+	}
+	for _, p := range prefixes {
+		data.Prefixes = append(data.Prefixes, consoleEntry{Name: strings.TrimPrefix(p, prefix), Prefix: p})
+	}
+	for _, obj := range objects {
+		// The directory object of the prefix itself
+		if obj.Key == prefix {
+			continue
+		}
+		data.Objects = append(data.Objects, consoleEntry{Name: strings.TrimPrefix(obj.Key, prefix), Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime})
+	}
+	if len(objects)+len(prefixes) == consolePageSize {
+		data.NextMarker = lastListed(objects, prefixes)
+	}
+	h.render(w, "objects", data)
+}
+
+// upload stores the files of a multipart form under the prefix, streaming them as they are received
+func (h *ConsoleHandler) upload(w http.ResponseWriter, r *http.Request) {
+	if h.readOnly || h.storage.IsReadReplica() {
+		h.renderError(w, r, "The server is read-only", http.StatusForbidden)
+		return
+	}
+	bucket := r.PathValue("bucket")
+	prefix := r.URL.Query().Get("prefix")
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.renderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			h.renderError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := part.FileName()
+		if part.FormName() != "file" || name == "" {
+			continue
+		}
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = mime.TypeByExtension(path.Ext(name))
+		}
+		_, err = h.storage.PutObject(r.Context(), bucket, prefix+name, part, storage.Metadata{ContentType: contentType}, "")
+		if err != nil {
+			h.renderStorageError(w, r, err)
+			return
+		}
+	}
+	http.Redirect(w, r, "/buckets/"+bucket+"?"+url.Values{"prefix": {prefix}}.Encode(), http.StatusSeeOther)
+}
+
+// download sends an object as an attachment, with range requests
+func (h *ConsoleHandler) download(w http.ResponseWriter, r *http.Request) {
+	bucket, key := r.PathValue("bucket"), r.URL.Query().Get("key")
+	reader, info, err := h.storage.GetObject(bucket, key)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	contentType := info.Metadata.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+	w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+	http.ServeContent(w, r, "", info.ModTime, reader)
+}
+
+// consoleChoice is an option of a select field
+type consoleChoice struct {
+	Name  string
+	Value string
+}
+
+// presignChoices are the validities presigned URLs can be generated for
+var presignChoices = []consoleChoice{
+	{"15 minutes", "15m0s"},
+	{"1 hour", "1h0m0s"},
+	{"1 day", "24h0m0s"},
+	{"7 days", "168h0m0s"},
+}
+
+// presign shows a presigned URL downloading an object, signed with the Basic credentials of the request
+func (h *ConsoleHandler) presign(w http.ResponseWriter, r *http.Request) {
+	bucket, key := r.PathValue("bucket"), r.URL.Query().Get("key")
+	data := struct {
+		consolePage
+		Bucket    string
+		Key       string
+		Expires   string
+		Choices   []consoleChoice
+		URL       string
+		ExpiresAt time.Time
+	}{
+		consolePage: h.page(r, key),
+		Bucket:      bucket,
+		Key:         key,
+		Expires:     r.URL.Query().Get("expires"),
+		Choices:     presignChoices,
+	}
+	if data.Expires == "" {
+		data.Expires = presignChoices[1].Value
+		h.render(w, "presign", data)
+		return
+	}
+
+	expires, err := time.ParseDuration(data.Expires)
+	if err != nil {
+		h.renderError(w, r, "Invalid validity: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	objects, _, err := h.storage.ListObjects(bucket, key, "", "", 1)
+	if err == nil && (len(objects) == 0 || objects[0].Key != key) {
+		err = storage.ErrObjectNotFound
+	}
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	endpoint, err := h.endpointURL(r)
+	if err != nil {
+		h.renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u := endpoint.JoinPath(bucket, key)
+
+	accessKeyID, secretAccessKey, ok := r.BasicAuth()
+	if !ok {
+		// Without credentials the server is anonymous, so the URL needs no signature
+		data.URL = u.String()
+		h.render(w, "presign", data)
+		return
+	}
+	now := time.Now()
+	data.URL, err = auth.PresignURL(http.MethodGet, u, auth.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}, h.region, now, expires)
+	if err != nil {
+		h.renderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.ExpiresAt = now.Add(expires)
+	h.render(w, "presign", data)
+}
+
+// endpointURL returns the base URL of the S3 endpoint, on the host of the request if the endpoint has none
+func (h *ConsoleHandler) endpointURL(r *http.Request) (*url.URL, error) {
+	if h.endpoint == "" {
+		return nil, errors.New("presigned URLs need the endpoint of the S3 server to be configured")
+	}
+	u, err := url.Parse(h.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		u.Host = net.JoinHostPort(host, u.Port())
+	}
+	return u, nil
+}
+
+// consoleSetting is a bucket setting shown by the console
+type consoleSetting struct {
+	Name  string
+	Value string
+}
+
+// settings shows the settings of a bucket
+func (h *ConsoleHandler) settings(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	created, err := h.storage.GetBucketCreationDate(bucket)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+
+	var settings []consoleSetting
+	add := func(name, value string) {
+		settings = append(settings, consoleSetting{Name: name, Value: value})
+	}
+	add("Created", created.UTC().Format(time.RFC1123))
+
+	website, err := h.storage.GetBucketWebsite(bucket)
+	switch {
+	case err == nil && website.RedirectAllRequestsTo != nil:
+		add("Website", "Redirects every request to "+website.RedirectAllRequestsTo.HostName)
+	case err == nil:
+		add("Website", fmt.Sprintf("Index document %q, error document %q, %d routing rules", website.IndexDocumentSuffix, website.ErrorDocumentKey, len(website.RoutingRules)))
+	case errors.Is(err, storage.ErrWebsiteNotFound):
+		add("Website", "")
+	default:
+		h.renderStorageError(w, r, err)
+		return
+	}
+
+	durations := []struct {
+		name string
+		get  func(string) (time.Duration, error)
+	}{
+		{"Default object TTL", h.storage.GetBucketDefaultTTL},
+		{"Trash retention", h.storage.GetBucketTrashRetention},
+		{"WORM retention", h.storage.GetBucketWORMRetention},
+	}
+	for _, d := range durations {
+		value, err := d.get(bucket)
+		if err != nil {
+			h.renderStorageError(w, r, err)
+			return
+		}
+		if value == 0 {
+			add(d.name, "")
+			continue
+		}
+		add(d.name, formatDays(value))
+	}
+
+	frozen, err := h.storage.IsBucketFrozen(bucket)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	add("Frozen", strconv.FormatBool(frozen))
+
+	ownership, err := h.storage.GetBucketOwnershipControls(bucket)
+	if err != nil && !errors.Is(err, storage.ErrOwnershipControlsNotFound) {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	add("Object ownership", ownership)
+
+	block, err := h.storage.GetPublicAccessBlock(bucket)
+	switch {
+	case err == nil:
+		add("Public access block", fmt.Sprintf("BlockPublicAcls %t, IgnorePublicAcls %t, BlockPublicPolicy %t, RestrictPublicBuckets %t",
+			block.BlockPublicAcls, block.IgnorePublicAcls, block.BlockPublicPolicy, block.RestrictPublicBuckets))
+	case errors.Is(err, storage.ErrPublicAccessBlockNotFound):
+		add("Public access block", "")
+	default:
+		h.renderStorageError(w, r, err)
+		return
+	}
+
+	accelerate, err := h.storage.GetBucketAccelerateConfiguration(bucket)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	add("Transfer acceleration", accelerate)
+	payer, err := h.storage.GetBucketRequestPayment(bucket)
+	if err != nil {
+		h.renderStorageError(w, r, err)
+		return
+	}
+	add("Request payer", payer)
+
+	h.render(w, "settings", struct {
+		consolePage
+		Bucket   string
+		Settings []consoleSetting
+	}{h.page(r, bucket+" settings"), bucket, settings})
+}
+
+// formatDays formats a duration in days, the unit bucket retentions are configured in
+func formatDays(d time.Duration) string {
+	days := d.Hours() / 24
+	if days == 1 {
+		return "1 day"
+	}
+	return strconv.FormatFloat(days, 'f', -1, 64) + " days"
+}
+
+// formatSize formats a size in bytes with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestConsoleHandler(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("photos"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for key, content := range map[string]string{
+		"readme.txt":         "hello",
+		"2024/beach.jpg":     "jpeg",
+		"2024/summer/a.jpg":  "a",
+		"odd name?#.txt":     "odd",
+		"2024/mountains.jpg": "jpeg",
+	} {
+		if _, err := store.PutObject(context.Background(), "photos", key, strings.NewReader(content), storage.Metadata{ContentType: "image/jpeg"}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+
+	handler := NewConsoleHandler(store, WithConsoleEndpoint("http://:8080", "us-east-1"))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(target string) string {
+		t.Helper()
+		rec := serve(httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected GET %s to succeed, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	if page := get("/"); !strings.Contains(page, `<a href="/buckets/photos">photos</a>`) {
+		t.Errorf("Expected the bucket to be listed, got %s", page)
+	}
+
+	page := get("/buckets/photos")
+	for _, want := range []string{
+		`<a href="/buckets/photos?prefix=2024%2f">2024/</a>`,
+		`<a href="/download/photos?key=readme.txt">readme.txt</a>`,
+		`<a href="/download/photos?key=odd%20name%3f%23.txt">odd name?#.txt</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the listing to contain %s, got %s", want, page)
+		}
+	}
+	page = get("/buckets/photos?prefix=2024/")
+	if !strings.Contains(page, "beach.jpg") || !strings.Contains(page, "summer/") || strings.Contains(page, "readme.txt") {
+		t.Errorf("Expected the listing of the prefix, got %s", page)
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/download/photos?key="+"odd%20name%3F%23.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "odd" {
+		t.Errorf("Expected the object to be downloaded, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") {
+		t.Errorf("Expected an attachment, got %q", disposition)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/download/photos?key=missing", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing object to return 404, got %d", rec.Code)
+	}
+
+	if err := store.FreezeBucket("photos", true); err != nil {
+		t.Fatalf("FreezeBucket failed: %v", err)
+	}
+	page = get("/buckets/photos/settings")
+	if !strings.Contains(page, "<th>Frozen</th><td>true</td>") {
+		t.Errorf("Expected the bucket to be shown frozen, got %s", page)
+	}
+	if err := store.FreezeBucket("photos", false); err != nil {
+		t.Fatalf("FreezeBucket failed: %v", err)
+	}
+
+	t.Run("Upload", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, content := range map[string]string{"notes.txt": "uploaded", "more.txt": "more"} {
+			part, err := form.CreateFormFile("file", name)
+			if err != nil {
+				t.Fatalf("CreateFormFile failed: %v", err)
+			}
+			part.Write([]byte(content))
+		}
+		form.Close()
+
+		upload := func(origin string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/buckets/photos?prefix=2024/", bytes.NewReader(body.Bytes()))
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			if origin != "" {
+				req.Header.Set("Origin", origin)
+			}
+			return serve(req)
+		}
+
+		// Browsers send the credentials of the console along with forms of other sites
+		if rec := upload("https://evil.example.com"); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected a cross-origin upload to be rejected, got %d", rec.Code)
+		}
+		if _, _, err := store.GetObject("photos", "2024/notes.txt"); err != storage.ErrObjectNotFound {
+			t.Errorf("Expected nothing to be uploaded, got %v", err)
+		}
+
+		rec := upload("")
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/buckets/photos?prefix=2024%2F" {
+			t.Fatalf("Expected a redirect to the prefix, got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		_, info, err := store.GetObject("photos", "2024/notes.txt")
+		if err != nil || info.Size != int64(len("uploaded")) || info.Metadata.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("Expected the uploaded object, got %+v (%v)", info, err)
+		}
+
+		readOnly := NewConsoleHandler(store, WithConsoleReadOnly(true))
+		req := httptest.NewRequest(http.MethodPost, "/buckets/photos", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec = httptest.NewRecorder()
+		readOnly.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected a read-only console to reject uploads, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Presign", func(t *testing.T) {
+		authenticator := auth.NewAWS4Authenticator()
+		authenticator.AddCredentials("test-key", "test-secret")
+
+		req := httptest.NewRequest(http.MethodGet, "/presign/photos?key=2024/beach.jpg&expires=1h0m0s", nil)
+		req.Host = "console.example.com:9090"
+		req.SetBasicAuth("test-key", "test-secret")
+		rec := serve(req)
+		match := regexp.MustCompile(`value="(http[^"]+)"`).FindStringSubmatch(rec.Body.String())
+		if rec.Code != http.StatusOK || match == nil {
+			t.Fatalf("Expected a presigned URL, got %d: %s", rec.Code, rec.Body.String())
+		}
+		presigned := html.UnescapeString(match[1])
+		if !strings.HasPrefix(presigned, "http://console.example.com:8080/photos/2024/beach.jpg?") {
+			t.Errorf("Expected the URL on the host of the console with the port of the endpoint, got %s", presigned)
+		}
+
+		var accessKeyID string
+		verify := authenticator.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accessKeyID = auth.AccessKeyIDFromContext(r.Context())
+		}))
+		verify.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, presigned, nil))
+		if accessKeyID != "test-key" {
+			t.Errorf("Expected the presigned URL to authenticate, got %q", accessKeyID)
+		}
+
+		for _, target := range []string{"/presign/photos?key=missing&expires=1h0m0s", "/presign/photos?key=readme.txt&expires=" + (8 * 24 * time.Hour).String()} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.SetBasicAuth("test-key", "test-secret")
+			if rec := serve(req); rec.Code == http.StatusOK {
+				t.Errorf("Expected %s to fail", target)
+			}
+		}
+	})
+}
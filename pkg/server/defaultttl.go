@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// defaultTTLHeader reports on HeadBucket after how many days the objects of a bucket expire
+const defaultTTLHeader = "x-s3d-default-ttl-days"
+
+// BucketDefaultTTL is the request and response of the default-ttl extension endpoint
+type BucketDefaultTTL struct {
+	XMLName xml.Name `xml:"BucketDefaultTTL"`
+	// Days after being written objects expire, 0 if they never do
+	Days int `xml:"Days"`
+}
+
+// handlePutBucketDefaultTTL sets after how many days the objects of a bucket expire
+func (s *S3Handler) handlePutBucketDefaultTTL(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req BucketDefaultTTL
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if req.Days <= 0 {
+		s.errorResponse(w, r, "InvalidArgument", "Days must be a positive number of days", http.StatusBadRequest)
+		return
+	}
+	s.setBucketDefaultTTL(w, r, bucket, time.Duration(req.Days)*24*time.Hour)
+}
+
+// handleDeleteBucketDefaultTTL stops the objects of a bucket from expiring
+func (s *S3Handler) handleDeleteBucketDefaultTTL(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.setBucketDefaultTTL(w, r, bucket, 0)
+}
+
+// setBucketDefaultTTL sets the default TTL of a bucket, 0 to disable it
+func (s *S3Handler) setBucketDefaultTTL(w http.ResponseWriter, r *http.Request, bucket string, ttl time.Duration) {
+	if err := s.storage.SetBucketDefaultTTL(bucket, ttl); err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetBucketDefaultTTL returns after how many days the objects of a bucket expire
+func (s *S3Handler) handleGetBucketDefaultTTL(w http.ResponseWriter, r *http.Request, bucket string) {
+	ttl, err := s.storage.GetBucketDefaultTTL(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.xmlResponse(w, r, BucketDefaultTTL{Days: durationDays(ttl)}, http.StatusOK)
+}
+
+// durationDays returns a duration in whole days
+func durationDays(d time.Duration) int {
+	return int(d / (24 * time.Hour))
+}
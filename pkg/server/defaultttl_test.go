@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestBucketDefaultTTL(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	days := func() int {
+		rec := serve(http.MethodGet, "/test-bucket?default-ttl", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var ttl BucketDefaultTTL
+		if err := xml.Unmarshal(rec.Body.Bytes(), &ttl); err != nil {
+			t.Fatalf("Failed to parse default TTL: %v", err)
+		}
+		return ttl.Days
+	}
+
+	if days() != 0 {
+		t.Fatal("Expected a new bucket to have no default TTL")
+	}
+	if rec := serve(http.MethodHead, "/test-bucket", ""); rec.Header().Get(defaultTTLHeader) != "" {
+		t.Errorf("Expected no %s header without a default TTL", defaultTTLHeader)
+	}
+
+	if rec := serve(http.MethodPut, "/test-bucket?default-ttl", "<BucketDefaultTTL><Days>3</Days></BucketDefaultTTL>"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	if got := days(); got != 3 {
+		t.Errorf("Expected a default TTL of 3 days, got %d", got)
+	}
+	if rec := serve(http.MethodHead, "/test-bucket", ""); rec.Header().Get(defaultTTLHeader) != "3" {
+		t.Errorf("Expected the %s header on HeadBucket, got %q", defaultTTLHeader, rec.Header().Get(defaultTTLHeader))
+	}
+
+	// Objects written through the handler are deleted by the expiration worker once they are older
+	if rec := serve(http.MethodPut, "/test-bucket/object", "data"); rec.Code != http.StatusOK {
+		t.Fatalf("Failed to put object: %d", rec.Code)
+	}
+	if n, err := store.ExpireObjects(context.Background(), time.Now().Add(4*24*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("Expected 1 expired object, got %d (%v)", n, err)
+	}
+	if rec := serve(http.MethodGet, "/test-bucket/object", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the expired object to be gone, got status %d", rec.Code)
+	}
+
+	if rec := serve(http.MethodDelete, "/test-bucket?default-ttl", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if days() != 0 {
+		t.Error("Expected the default TTL to be removed")
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			body string
+			code int
+		}{
+			{body: "<BucketDefaultTTL><Days>0</Days></BucketDefaultTTL>", code: http.StatusBadRequest},
+			{body: "<BucketDefaultTTL><Days>-1</Days></BucketDefaultTTL>", code: http.StatusBadRequest},
+			{body: "<BucketDefaultTTL><Days>soon</Days></BucketDefaultTTL>", code: http.StatusBadRequest},
+			{body: "not xml", code: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			if rec := serve(http.MethodPut, "/test-bucket?default-ttl", tt.body); rec.Code != tt.code {
+				t.Errorf("Expected status %d for %q, got %d", tt.code, tt.body, rec.Code)
+			}
+		}
+		if rec := serve(http.MethodPut, "/missing-bucket?default-ttl", "<BucketDefaultTTL><Days>1</Days></BucketDefaultTTL>"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for a missing bucket, got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+}
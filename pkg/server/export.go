@@ -0,0 +1,169 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// exportBatch is the number of objects listed at once while exporting
+const exportBatch = 1000
+
+// archiveWriter writes the objects of an export into an archive
+type archiveWriter interface {
+	// add writes an object, a directory object if name ends with a slash
+	add(name string, size int64, modTime time.Time, data io.Reader) error
+	Close() error
+}
+
+// tarArchive writes objects into a tar archive
+type tarArchive struct {
+	tw *tar.Writer
+}
+
+func (a *tarArchive) add(name string, size int64, modTime time.Time, data io.Reader) error {
+	header := &tar.Header{
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if strings.HasSuffix(name, "/") {
+		header.Size = 0
+		header.Mode = 0755
+		header.Typeflag = tar.TypeDir
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	_, err := io.Copy(a.tw, data)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	return a.tw.Close()
+}
+
+// zipArchive writes objects into a zip archive, stored without compression
+// since objects are mostly compressed already and the archive is generated on the fly
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(name string, size int64, modTime time.Time, data io.Reader) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(name, "/") {
+		return nil
+	}
+	_, err = io.Copy(w, data)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// handleExportBucket streams the objects of a bucket, optionally under a prefix, as a tar or zip archive
+// generated on the fly, named by their keys
+func (s *S3Handler) handleExportBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "tar"
+	}
+	var contentType string
+	switch format {
+	case "tar":
+		contentType = "application/x-tar"
+	case "zip":
+		contentType = "application/zip"
+	default:
+		s.errorResponse(w, r, "InvalidArgument", "The format parameter must be tar or zip", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+
+	if !s.storage.BucketExists(bucket) {
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bucket + "." + format}))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var archive archiveWriter
+	if format == "zip" {
+		archive = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		archive = &tarArchive{tw: tar.NewWriter(w)}
+	}
+	err := s.exportObjects(r.Context(), archive, bucket, prefix)
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// The status is already sent, so the response is aborted rather than ended,
+		// keeping clients from taking a truncated archive for a complete one
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportObjects adds the objects of a bucket under prefix to archive in key order
+// Objects deleted while the export runs are left out
+func (s *S3Handler) exportObjects(ctx context.Context, archive archiveWriter, bucket, prefix string) error {
+	marker := ""
+	for {
+		objects, _, err := s.storage.ListObjects(bucket, prefix, "", marker, exportBatch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := s.exportObject(archive, bucket, obj.Key); err != nil {
+				return err
+			}
+		}
+		if len(objects) < exportBatch {
+			return nil
+		}
+		marker = objects[len(objects)-1].Key
+	}
+}
+
+// exportObject adds an object to archive
+func (s *S3Handler) exportObject(archive archiveWriter, bucket, key string) error {
+	reader, info, err := s.storage.GetObject(bucket, key)
+	if err != nil {
+		if err == storage.ErrObjectNotFound {
+			return nil
+		}
+		return err
+	}
+	defer reader.Close()
+	return archive.add(key, info.Size, info.ModTime, reader)
+}
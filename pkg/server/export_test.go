@@ -0,0 +1,158 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestExportBucket(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	objects := map[string]string{
+		"data/a.txt":        "first",
+		"data/nested/b.bin": strings.Repeat("large ", 2000),
+		"data/empty/":       "",
+		"other.txt":         "other",
+	}
+	for key, data := range objects {
+		if rec := serve(http.MethodPut, "/test-bucket/"+key, data); rec.Code != http.StatusOK {
+			t.Fatalf("PutObject %s failed with status %d", key, rec.Code)
+		}
+	}
+	want := []string{"data/a.txt", "data/empty/", "data/nested/b.bin"}
+
+	t.Run("Tar", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/test-bucket?export&prefix=data/", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Export failed with status %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/x-tar" {
+			t.Errorf("Expected Content-Type application/x-tar, got %q", got)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=test-bucket.tar` {
+			t.Errorf("Unexpected Content-Disposition %q", got)
+		}
+
+		tr := tar.NewReader(rec.Body)
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read tar archive: %v", err)
+			}
+			names = append(names, header.Name)
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", header.Name, err)
+			}
+			if string(data) != objects[header.Name] {
+				t.Errorf("Unexpected content of %s", header.Name)
+			}
+			if strings.HasSuffix(header.Name, "/") != (header.Typeflag == tar.TypeDir) {
+				t.Errorf("Expected only directory objects to be directories, got %s of type %c", header.Name, header.Typeflag)
+			}
+			if header.ModTime.IsZero() {
+				t.Errorf("Expected the modification time of %s", header.Name)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Expected entries %v, got %v", want, names)
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/test-bucket?export&format=zip&prefix=data/", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Export failed with status %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/zip" {
+			t.Errorf("Expected Content-Type application/zip, got %q", got)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("Failed to open zip archive: %v", err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", f.Name, err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", f.Name, err)
+			}
+			if string(data) != objects[f.Name] {
+				t.Errorf("Unexpected content of %s", f.Name)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Expected entries %v, got %v", want, names)
+		}
+	})
+
+	t.Run("WholeBucket", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/test-bucket?export", "")
+		tr := tar.NewReader(rec.Body)
+		n := 0
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Failed to read tar archive: %v", err)
+			}
+			n++
+		}
+		if n != len(objects) {
+			t.Errorf("Expected %d entries, got %d", len(objects), n)
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		rec := serve(http.MethodHead, "/test-bucket?export&format=zip", "")
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "application/zip" {
+			t.Errorf("Expected the headers of the export only, got status %d with %d bytes", rec.Code, rec.Body.Len())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if rec := serve(http.MethodGet, "/test-bucket?export&format=rar", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+		}
+		if rec := serve(http.MethodGet, "/missing-bucket?export", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for a missing bucket, got %d", http.StatusNotFound, rec.Code)
+		}
+		if rec := serve(http.MethodPut, "/test-bucket?export", ""); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+const (
+	// maxExtractEntries is the number of objects an uploaded archive may unpack into
+	maxExtractEntries = 10000
+	// maxExtractSize is the total size of the objects an uploaded archive may unpack into,
+	// and of the archive itself since it is spooled to disk before it is extracted
+	maxExtractSize int64 = 1 << 30
+)
+
+var (
+	// errUnsafeEntryName is returned for archive entries whose name climbs out of the prefix
+	errUnsafeEntryName = errors.New("unsafe archive entry name")
+	// errTooManyEntries is returned for archives with more than maxExtractEntries objects
+	errTooManyEntries = errors.New("too many archive entries")
+	// errMalformedArchive is returned for archives that cannot be read
+	errMalformedArchive = errors.New("malformed archive")
+)
+
+// ExtractArchiveResult is the response of the extract extension endpoint
+type ExtractArchiveResult struct {
+	XMLName xml.Name `xml:"ExtractArchiveResult"`
+	Prefix  string   `xml:"Prefix"`
+	// Objects is the number of objects written, directory objects included
+	Objects int `xml:"Objects"`
+	// Size is the total size of the objects written
+	Size int64 `xml:"Size"`
+}
+
+// archiveEntry is called for every object of an archive with its name, relative to the prefix,
+// ending with a slash for directory objects
+type archiveEntry func(name string, size int64, data io.Reader) error
+
+// handleExtractArchive unpacks an uploaded tar or zip archive into an object per file,
+// named by the prefix followed by the path of the file in the archive
+// The archive is received in full before anything is extracted; objects extracted before
+// a failure in the archive itself are kept, the error reports how many there are
+func (s *S3Handler) handleExtractArchive(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "tar"
+	}
+	if format != "tar" && format != "zip" {
+		s.errorResponse(w, r, "InvalidArgument", "The format parameter must be tar or zip", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+
+	if !s.storage.BucketExists(bucket) {
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	result := ExtractArchiveResult{Prefix: prefix}
+	extract := func(name string, size int64, data io.Reader) error {
+		if result.Objects >= maxExtractEntries {
+			return errTooManyEntries
+		}
+		// Both formats fail reads of entries going past the size they declare
+		if size > maxExtractSize-result.Size {
+			return storage.ErrEntityTooLarge
+		}
+
+		key := prefix + name
+		var metadata storage.Metadata
+		if s.sniff {
+			data, metadata.ContentType = sniffContentType(data)
+		}
+		info, err := s.storage.PutObject(r.Context(), bucket, key, data, metadata, "")
+		if err != nil {
+			return err
+		}
+		s.recordAudit(r, bucket, "PutObject", key, "")
+		result.Objects++
+		result.Size += info.Size
+		return nil
+	}
+
+	// Nothing is extracted before the whole archive is received, since the data of aws-chunked
+	// uploads and of payloads checked in strict mode is only known to be signed once read to the end
+	archive, size, err := s.spoolArchive(r.Body)
+	if err != nil {
+		switch {
+		case err == storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", fmt.Sprintf("The archive exceeds the maximum size of %d bytes", maxExtractSize), http.StatusBadRequest)
+		case err == storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
+		case errors.Is(err, auth.ErrChunkSignatureMismatch):
+			s.errorResponse(w, r, "SignatureDoesNotMatch", "The chunk signature does not match", http.StatusForbidden)
+		case errors.Is(err, auth.ErrContentSHA256Mismatch):
+			s.errorResponse(w, r, "XAmzContentSHA256Mismatch", "The payload does not match x-amz-content-sha256", http.StatusBadRequest)
+		case errors.Is(err, auth.ErrInvalidChunkFormat), errors.Is(err, io.ErrUnexpectedEOF):
+			s.errorResponse(w, r, "IncompleteBody", "The archive could not be read to its end", http.StatusBadRequest)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	if format == "zip" {
+		err = extractZip(archive, size, extract)
+	} else {
+		err = extractTar(archive, extract)
+	}
+	if err != nil {
+		extracted := fmt.Sprintf(" (%d objects were extracted)", result.Objects)
+		switch err {
+		case errUnsafeEntryName:
+			s.errorResponse(w, r, "InvalidArgument", "The archive has an entry outside of its root"+extracted, http.StatusBadRequest)
+		case errTooManyEntries:
+			s.errorResponse(w, r, "InvalidArgument", fmt.Sprintf("The archive has more than %d entries%s", maxExtractEntries, extracted), http.StatusBadRequest)
+		case errMalformedArchive:
+			s.errorResponse(w, r, "MalformedArchive", "The archive could not be read"+extracted, http.StatusBadRequest)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", fmt.Sprintf("The archive exceeds the maximum extracted size of %d bytes%s", maxExtractSize, extracted), http.StatusBadRequest)
+		case storage.ErrInvalidObjectKey:
+			s.errorResponse(w, r, "InvalidArgument", "The archive has an entry with an invalid object key"+extracted, http.StatusBadRequest)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket"+extracted, http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen"+extracted, http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object."+extracted, http.StatusInsufficientStorage)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error()+extracted, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// extractTar calls extract for every regular file and directory of a tar archive,
+// other entries such as links are skipped
+func extractTar(body io.Reader, extract archiveEntry) error {
+	tr := tar.NewReader(body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errMalformedArchive
+		}
+
+		var dir bool
+		switch header.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			dir = true
+		default:
+			continue
+		}
+		name, err := archiveEntryName(header.Name, dir)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		if dir {
+			err = extract(name, 0, strings.NewReader(""))
+		} else {
+			err = extract(name, header.Size, tr)
+		}
+		if err != nil {
+			if errors.Is(err, tar.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return errMalformedArchive
+			}
+			return err
+		}
+	}
+}
+
+// spoolArchive copies an uploaded archive to a temporary file and rewinds it,
+// failing with ErrEntityTooLarge for archives larger than maxExtractSize
+// The file is only returned once the body has been read to its end without error
+func (s *S3Handler) spoolArchive(body io.Reader) (*os.File, int64, error) {
+	tmp, err := s.storage.TempFile("extract-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(body, maxExtractSize+1))
+	if err == nil && n > maxExtractSize {
+		err = storage.ErrEntityTooLarge
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, n, nil
+}
+
+// extractZip calls extract for every regular file and directory of a zip archive of size bytes,
+// which has to be spooled since its index is at its end
+func extractZip(archive io.ReaderAt, size int64, extract archiveEntry) error {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return errMalformedArchive
+	}
+	if len(zr.File) > maxExtractEntries {
+		return errTooManyEntries
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		dir := mode.IsDir()
+		if !dir && !mode.IsRegular() {
+			continue
+		}
+		name, err := archiveEntryName(f.Name, dir)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		if dir {
+			err = extract(name, 0, strings.NewReader(""))
+		} else {
+			err = extractZipFile(f, name, extract)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZipFile calls extract for a file of a zip archive
+func extractZipFile(f *zip.File, name string, extract archiveEntry) error {
+	rc, err := f.Open()
+	if err != nil {
+		return errMalformedArchive
+	}
+	defer rc.Close()
+	err = extract(name, int64(f.UncompressedSize64), rc)
+	if errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrAlgorithm) {
+		return errMalformedArchive
+	}
+	return err
+}
+
+// archiveEntryName returns the name of an archive entry relative to the prefix it is extracted under,
+// with a trailing slash for directories, or an empty string for the root of the archive
+// Names climbing out of the archive with .. are rejected rather than silently moved
+func archiveEntryName(name string, dir bool) (string, error) {
+	if slices.Contains(strings.Split(name, "/"), "..") {
+		return "", errUnsafeEntryName
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || !dir {
+		return name, nil
+	}
+	return name + "/", nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// archiveFile is an entry of an archive built by the tests, a directory if its name ends with a slash
+type archiveFile struct {
+	name string
+	data string
+}
+
+func buildTar(t *testing.T, files []archiveFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			header.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatalf("Failed to write tar entry: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, files []archiveFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	serve := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	read := func(key string) string {
+		reader, _, err := store.GetObject("test-bucket", key)
+		if err != nil {
+			t.Fatalf("GetObject %s failed: %v", key, err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		return string(data)
+	}
+
+	files := []archiveFile{
+		{"./", ""},
+		{"docs/", ""},
+		{"docs/a.txt", "first"},
+		{"/docs/nested/b.txt", "second"},
+		{"c.txt", ""},
+	}
+	want := map[string]string{
+		"docs/":             "",
+		"docs/a.txt":        "first",
+		"docs/nested/b.txt": "second",
+		"c.txt":             "",
+	}
+
+	for _, format := range []string{"tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			body := buildTar(t, files)
+			if format == "zip" {
+				body = buildZip(t, files)
+			}
+			prefix := "import-" + format + "/"
+			rec := serve(http.MethodPut, "/test-bucket?extract&format="+format+"&prefix="+prefix, body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Extract failed with status %d: %s", rec.Code, rec.Body.String())
+			}
+			var result ExtractArchiveResult
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if result.Prefix != prefix || result.Objects != len(want) || result.Size != int64(len("first")+len("second")) {
+				t.Errorf("Unexpected result %+v", result)
+			}
+
+			objects, _, err := store.ListObjects("test-bucket", prefix, "", "", 0)
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+			if len(objects) != len(want) {
+				t.Errorf("Expected %d objects, got %+v", len(want), objects)
+			}
+			for name, data := range want {
+				if got := read(prefix + name); got != data {
+					t.Errorf("Expected %q in %s, got %q", data, name, got)
+				}
+			}
+		})
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/test-bucket?export&prefix=import-tar/", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Export failed with status %d", rec.Code)
+		}
+		if rec := serve(http.MethodPut, "/test-bucket?extract&prefix=copy/", rec.Body.Bytes()); rec.Code != http.StatusOK {
+			t.Fatalf("Extract failed with status %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := read("copy/import-tar/docs/nested/b.txt"); got != "second" {
+			t.Errorf("Expected the exported object, got %q", got)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			target string
+			body   []byte
+			want   int
+			code   string
+		}{
+			{"UnknownFormat", "/test-bucket?extract&format=rar", nil, http.StatusBadRequest, "InvalidArgument"},
+			{"MissingBucket", "/missing-bucket?extract", buildTar(t, files), http.StatusNotFound, "NoSuchBucket"},
+			{"UnsafeName", "/test-bucket?extract&prefix=unsafe/", buildTar(t, []archiveFile{{"../escape", "data"}}), http.StatusBadRequest, "InvalidArgument"},
+			{"MalformedTar", "/test-bucket?extract", []byte(strings.Repeat("not a tar archive", 64)), http.StatusBadRequest, "MalformedArchive"},
+			{"MalformedZip", "/test-bucket?extract&format=zip", []byte("not a zip archive"), http.StatusBadRequest, "MalformedArchive"},
+		}
+		for _, tt := range tests {
+			rec := serve(http.MethodPut, tt.target, tt.body)
+			if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.code) {
+				t.Errorf("%s: expected status %d with %s, got %d: %s", tt.name, tt.want, tt.code, rec.Code, rec.Body.String())
+			}
+		}
+		if objects, _, err := store.ListObjects("test-bucket", "unsafe/", "", "", 0); err != nil || len(objects) != 0 {
+			t.Errorf("Expected nothing extracted from an unsafe archive, got %+v (%v)", objects, err)
+		}
+	})
+
+	t.Run("TooManyEntries", func(t *testing.T) {
+		files := make([]archiveFile, maxExtractEntries+1)
+		for i := range files {
+			files[i] = archiveFile{name: fmt.Sprintf("dir%d/", i)}
+		}
+		rec := serve(http.MethodPut, "/test-bucket?extract&format=zip&prefix=many/", buildZip(t, files))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "InvalidArgument") {
+			t.Errorf("Expected the archive to be rejected, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if objects, _, err := store.ListObjects("test-bucket", "many/", "", "", 0); err != nil || len(objects) != 0 {
+			t.Errorf("Expected nothing extracted from a zip archive over the limit, got %d objects (%v)", len(objects), err)
+		}
+	})
+
+	t.Run("ForgedChunk", func(t *testing.T) {
+		// The archive is complete, but a later chunk of the body fails its signature
+		body := io.MultiReader(bytes.NewReader(buildTar(t, files)), iotest.ErrReader(auth.ErrChunkSignatureMismatch))
+		req := httptest.NewRequest(http.MethodPut, "/test-bucket?extract&prefix=forged/", body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "SignatureDoesNotMatch") {
+			t.Errorf("Expected the archive to be rejected, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if objects, _, err := store.ListObjects("test-bucket", "forged/", "", "", 0); err != nil || len(objects) != 0 {
+			t.Errorf("Expected nothing extracted from a forged body, got %+v (%v)", objects, err)
+		}
+	})
+
+	t.Run("Frozen", func(t *testing.T) {
+		if err := store.FreezeBucket("test-bucket", true); err != nil {
+			t.Fatalf("FreezeBucket failed: %v", err)
+		}
+		defer store.FreezeBucket("test-bucket", false)
+		rec := serve(http.MethodPut, "/test-bucket?extract&prefix=frozen/", buildTar(t, files))
+		if rec.Code != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+		want string
+		err  error
+	}{
+		{"a.txt", false, "a.txt", nil},
+		{"./a/b.txt", false, "a/b.txt", nil},
+		{"/abs/c.txt", false, "abs/c.txt", nil},
+		{"a//b/./c", false, "a/b/c", nil},
+		{"dir", true, "dir/", nil},
+		{"dir/", true, "dir/", nil},
+		{"./", true, "", nil},
+		{"../x", false, "", errUnsafeEntryName},
+		{"a/../../x", false, "", errUnsafeEntryName},
+		{"a/..", true, "", errUnsafeEntryName},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryName(tt.name, tt.dir)
+		if got != tt.want || err != tt.err {
+			t.Errorf("archiveEntryName(%q, %v) = %q, %v, want %q, %v", tt.name, tt.dir, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// frozenHeader reports on HeadBucket that a bucket is frozen
+const frozenHeader = "x-s3d-frozen"
+
+// BucketFreezeStatus is the response of the freeze extension endpoint
+type BucketFreezeStatus struct {
+	XMLName xml.Name `xml:"BucketFreezeStatus"`
+	Frozen  bool     `xml:"Frozen"`
+}
+
+// handlePutBucketFreeze freezes a bucket, so its objects can be read but not written or deleted
+func (s *S3Handler) handlePutBucketFreeze(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.setBucketFrozen(w, r, bucket, true)
+}
+
+// handleDeleteBucketFreeze unfreezes a bucket
+func (s *S3Handler) handleDeleteBucketFreeze(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.setBucketFrozen(w, r, bucket, false)
+}
+
+// setBucketFrozen freezes or unfreezes a bucket
+func (s *S3Handler) setBucketFrozen(w http.ResponseWriter, r *http.Request, bucket string, frozen bool) {
+	if err := s.storage.FreezeBucket(bucket, frozen); err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetBucketFreeze returns whether a bucket is frozen
+func (s *S3Handler) handleGetBucketFreeze(w http.ResponseWriter, r *http.Request, bucket string) {
+	frozen, err := s.storage.IsBucketFrozen(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.xmlResponse(w, r, BucketFreezeStatus{Frozen: frozen}, http.StatusOK)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestBucketFreeze(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("data"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	frozen := func() bool {
+		rec := serve(http.MethodGet, "/test-bucket?freeze")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var status BucketFreezeStatus
+		if err := xml.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to parse freeze status: %v", err)
+		}
+		return status.Frozen
+	}
+
+	if rec := serve(http.MethodPut, "/test-bucket/object"); rec.Code != http.StatusOK {
+		t.Fatalf("Failed to put object: %d", rec.Code)
+	}
+	if frozen() {
+		t.Fatal("Expected a new bucket not to be frozen")
+	}
+
+	if rec := serve(http.MethodPut, "/test-bucket?freeze"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if !frozen() {
+		t.Fatal("Expected the bucket to be frozen")
+	}
+	if rec := serve(http.MethodHead, "/test-bucket"); rec.Header().Get(frozenHeader) != "true" {
+		t.Errorf("Expected the %s header on HeadBucket", frozenHeader)
+	}
+
+	if rec := serve(http.MethodGet, "/test-bucket/object"); rec.Code != http.StatusOK {
+		t.Errorf("Expected reads to work, got %d", rec.Code)
+	}
+	for _, tt := range []struct{ method, target string }{
+		{http.MethodPut, "/test-bucket/object"},
+		{http.MethodDelete, "/test-bucket/object"},
+		{http.MethodPost, "/test-bucket/object?uploads"},
+		{http.MethodDelete, "/test-bucket"},
+	} {
+		rec := serve(tt.method, tt.target)
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "InvalidBucketState") {
+			t.Errorf("%s %s: expected InvalidBucketState, got %d: %s", tt.method, tt.target, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := serve(http.MethodDelete, "/test-bucket?freeze"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/test-bucket/object"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected deletes to work after unfreezing, got %d", rec.Code)
+	}
+}
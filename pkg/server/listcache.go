@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// listCacheMaxEntries bounds the number of cached list responses
+	listCacheMaxEntries = 1024
+	// listCacheMaxBody is the largest list response that is cached, larger ones are streamed as usual
+	listCacheMaxBody = 1 << 20
+)
+
+// WithListCache caches the responses of ListBuckets and ListObjects for ttl, disabled if 0,
+// for dashboards and filers polling listings
+// Writes through this handler invalidate the cached listings of their bucket at once, as do
+// changes of objects made through its storage by other handlers, changes made behind its back,
+// such as by another process, show up once the entries expire
+func WithListCache(ttl time.Duration) Option {
+	return func(h *S3Handler) {
+		if ttl > 0 {
+			h.lists = newListCache(ttl)
+		}
+	}
+}
+
+// listCacheEntry is a cached list response
+type listCacheEntry struct {
+	bucket      string
+	generation  uint64
+	sequence    uint64 // of the bucket when the listing started
+	expires     time.Time
+	contentType string
+	encoding    string
+	body        []byte
+}
+
+// listCache holds list responses keyed by bucket, query and content encoding
+// Every bucket has a generation counter incremented by each write to it, entries of older
+// generations are never served, so a listing racing a write is not served once the write completed
+type listCache struct {
+	ttl time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]*listCacheEntry
+	generations map[string]uint64 // by bucket, "" for the list of buckets
+}
+
+// newListCache creates a list cache keeping responses for ttl
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{
+		ttl:         ttl,
+		entries:     map[string]*listCacheEntry{},
+		generations: map[string]uint64{},
+	}
+}
+
+// listCacheKey returns the cache key of a listing of bucket, "" for the list of buckets
+// Signature parameters of presigned URLs differ between requests and are left out
+func listCacheKey(r *http.Request, bucket, encoding string) string {
+	query := r.URL.Query()
+	for name := range query {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			query.Del(name)
+		}
+	}
+	return bucket + "\n" + encoding + "\n" + query.Encode()
+}
+
+// get returns the live entry of key along with the current generation of bucket
+func (c *listCache) get(key, bucket string) (*listCacheEntry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	generation := c.generations[bucket]
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, generation
+	}
+	if entry.generation != generation || time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, generation
+	}
+	return entry, generation
+}
+
+// put stores entry unless its bucket was written to since the listing started
+func (c *listCache) put(key string, entry *listCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.generation != c.generations[entry.bucket] {
+		return
+	}
+	if len(c.entries) >= listCacheMaxEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if e.generation != c.generations[e.bucket] || now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= listCacheMaxEntries {
+			return
+		}
+	}
+	entry.expires = time.Now().Add(c.ttl)
+	c.entries[key] = entry
+}
+
+// invalidate discards the cached listings of bucket, and the list of buckets too if buckets is set
+func (c *listCache) invalidate(bucket string, buckets bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[bucket]++
+	if buckets {
+		c.generations[""]++
+	}
+}
+
+// listRecorder buffers a list response to cache it, falling back to streaming it
+// to the client once it grows beyond listCacheMaxBody or is not a success
+type listRecorder struct {
+	w           http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (l *listRecorder) Header() http.Header {
+	return l.w.Header()
+}
+
+func (l *listRecorder) WriteHeader(status int) {
+	if l.status != 0 {
+		return
+	}
+	l.status = status
+	if status != http.StatusOK {
+		l.passthrough = true
+		l.w.WriteHeader(status)
+	}
+}
+
+func (l *listRecorder) Write(b []byte) (int, error) {
+	if l.status == 0 {
+		l.WriteHeader(http.StatusOK)
+	}
+	if l.passthrough {
+		return l.w.Write(b)
+	}
+	if l.buf.Len()+len(b) > listCacheMaxBody {
+		l.passthrough = true
+		l.w.WriteHeader(l.status)
+		if _, err := l.w.Write(l.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		l.buf.Reset()
+		return l.w.Write(b)
+	}
+	return l.buf.Write(b)
+}
+
+// Flush flushes streamed responses, buffered ones go out once complete
+func (l *listRecorder) Flush() {
+	if l.passthrough {
+		http.NewResponseController(l.w).Flush()
+	}
+}
+
+// cachedList serves a listing of bucket, "" for the list of buckets, from the list cache,
+// running list to fill it on a miss
+// With compression enabled, cached responses of accepted encodings are stored compressed
+func (s *S3Handler) cachedList(w http.ResponseWriter, r *http.Request, bucket string, list func(w http.ResponseWriter)) {
+	if s.lists == nil {
+		list(w)
+		return
+	}
+
+	encoding := ""
+	if s.compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding = acceptedEncoding(r)
+	}
+	key := listCacheKey(r, bucket, encoding)
+	sequence := s.storage.BucketSequence(bucket)
+	entry, generation := s.lists.get(key, bucket)
+	if entry != nil && entry.sequence != sequence {
+		// The objects were changed through the storage without going through this handler
+		entry = nil
+	}
+	if entry == nil {
+		rec := &listRecorder{w: w}
+		list(rec)
+		if rec.passthrough {
+			return
+		}
+
+		entry = &listCacheEntry{
+			bucket:      bucket,
+			generation:  generation,
+			sequence:    sequence,
+			contentType: w.Header().Get("Content-Type"),
+			body:        rec.buf.Bytes(),
+		}
+		if encoding != "" && len(entry.body) >= minCompressSize {
+			if compressed, err := compressBody(entry.body, encoding); err == nil {
+				entry.encoding = encoding
+				entry.body = compressed
+			}
+		}
+		s.lists.put(key, entry)
+	} else {
+		s.setHeaders(w, r)
+		w.Header().Set("Content-Type", entry.contentType)
+	}
+
+	if entry.encoding != "" {
+		w.Header().Set("Content-Encoding", entry.encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+}
+
+// compressBody compresses body with gzip or deflate
+func compressBody(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	if encoding == "gzip" {
+		zw = gzip.NewWriter(&buf)
+	} else {
+		zw = zlib.NewWriter(&buf)
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// invalidateLists discards the cached listings a write to bucket may change once it completed
+// Only creating and deleting the bucket itself changes the list of buckets
+func (s *S3Handler) invalidateLists(r *http.Request, bucket, key, subresource string) {
+	if s.lists == nil || isReadRequest(r) {
+		return
+	}
+	buckets := key == "" && subresource == "" && (r.Method == http.MethodPut || r.Method == http.MethodDelete)
+	s.lists.invalidate(bucket, buckets)
+}
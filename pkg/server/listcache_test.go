@@ -0,0 +1,196 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestListCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	put := func(key string) {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+	put("first")
+
+	handler := NewS3Handler(store, WithListCache(time.Hour))
+	do := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("data"))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	listKeys := func(target string) []string {
+		rec := do(http.MethodGet, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ListObjects failed with status %d: %s", rec.Code, rec.Body.String())
+		}
+		var result ListBucketResultV2
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse ListObjects response: %v", err)
+		}
+		var keys []string
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		return keys
+	}
+
+	if keys := listKeys("/test-bucket?list-type=2"); len(keys) != 1 {
+		t.Fatalf("Expected 1 object, got %v", keys)
+	}
+
+	// Objects written behind the handler's back, such as by another process, are not seen until the entry expires
+	if err := os.CopyFS(filepath.Join(dir, "test-bucket", "second"), os.DirFS(filepath.Join(dir, "test-bucket", "first"))); err != nil {
+		t.Fatalf("Failed to copy object: %v", err)
+	}
+	if keys := listKeys("/test-bucket?list-type=2"); len(keys) != 1 {
+		t.Errorf("Expected the cached listing of 1 object, got %v", keys)
+	}
+	// Other queries are cached separately, signature parameters of presigned URLs are ignored
+	if keys := listKeys("/test-bucket?list-type=2&prefix=s"); len(keys) != 1 || keys[0] != "second" {
+		t.Errorf("Expected a fresh listing for another prefix, got %v", keys)
+	}
+	if keys := listKeys("/test-bucket?list-type=2&X-Amz-Signature=abc"); len(keys) != 1 {
+		t.Errorf("Expected the cached listing regardless of signature parameters, got %v", keys)
+	}
+
+	// Writes through the handler invalidate the listings of their bucket
+	if rec := do(http.MethodPut, "/test-bucket/third", nil); rec.Code != http.StatusOK {
+		t.Fatalf("PutObject failed with status %d", rec.Code)
+	}
+	if keys := listKeys("/test-bucket?list-type=2"); len(keys) != 3 {
+		t.Errorf("Expected the write to invalidate the listing, got %v", keys)
+	}
+	// So do writes through the storage by other handlers
+	put("fourth")
+	if keys := listKeys("/test-bucket?list-type=2"); len(keys) != 4 {
+		t.Errorf("Expected the write through the storage to invalidate the listing, got %v", keys)
+	}
+
+	t.Run("ListBuckets", func(t *testing.T) {
+		listBuckets := func() int {
+			var result ListAllMyBucketsResult
+			if err := xml.Unmarshal(do(http.MethodGet, "/", nil).Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to parse ListBuckets response: %v", err)
+			}
+			return len(result.Buckets.Bucket)
+		}
+		if n := listBuckets(); n != 1 {
+			t.Fatalf("Expected 1 bucket, got %d", n)
+		}
+		if rec := do(http.MethodPut, "/other-bucket", nil); rec.Code != http.StatusOK {
+			t.Fatalf("CreateBucket failed with status %d", rec.Code)
+		}
+		if n := listBuckets(); n != 2 {
+			t.Errorf("Expected CreateBucket to invalidate the bucket list, got %d buckets", n)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if rec := do(http.MethodGet, "/missing-bucket", nil); rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+		}
+		if err := store.CreateBucket("missing-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+		if rec := do(http.MethodGet, "/missing-bucket", nil); rec.Code != http.StatusOK {
+			t.Errorf("Expected errors not to be cached, got status %d", rec.Code)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		handler := NewS3Handler(store, WithListCache(time.Millisecond))
+		list := func() string {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-bucket", nil))
+			return rec.Body.String()
+		}
+		before := list()
+		if err := os.CopyFS(filepath.Join(dir, "test-bucket", "fifth"), os.DirFS(filepath.Join(dir, "test-bucket", "first"))); err != nil {
+			t.Fatalf("Failed to copy object: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if list() == before {
+			t.Error("Expected the expired listing to be refreshed")
+		}
+	})
+}
+
+func TestListCacheCompression(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	for i := range 50 {
+		if _, err := store.PutObject(context.Background(), "test-bucket", fmt.Sprintf("object-%03d", i), strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	handler := NewS3Handler(store, WithListCache(time.Hour), WithCompression(true))
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket?list-type=2", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := get("")
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Expected an uncompressed listing, got Content-Encoding %q", plain.Header().Get("Content-Encoding"))
+	}
+
+	// Miss and hit serve the same compressed listing
+	for range 2 {
+		rec := get("gzip")
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Expected gzip Content-Encoding, got %q", got)
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+			t.Errorf("Expected Vary: Accept-Encoding, got %q", rec.Header().Get("Vary"))
+		}
+		reader, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress listing: %v", err)
+		}
+		if string(body) != plain.Body.String() {
+			t.Error("Expected the decompressed listing to match the uncompressed one")
+		}
+	}
+}
@@ -2,14 +2,19 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/wzshiming/s3d/pkg/storage"
 )
 
 func TestObjectMetadata(t *testing.T) {
@@ -230,6 +235,43 @@ func TestObjectMetadata(t *testing.T) {
 			t.Errorf("Expected no metadata, but got: %v", output.Metadata)
 		}
 	})
+
+	t.Run("PutObjectWithWebsiteRedirectLocation", func(t *testing.T) {
+		redirectKey := "redirect-object.html"
+		_, err := ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:                  aws.String(bucketName),
+			Key:                     aws.String(redirectKey),
+			Body:                    strings.NewReader(""),
+			WebsiteRedirectLocation: aws.String("/new-location.html"),
+		})
+		if err != nil {
+			t.Fatalf("PutObject with website redirect location failed: %v", err)
+		}
+		defer ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(redirectKey)})
+
+		output, err := ts.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(redirectKey),
+		})
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if aws.ToString(output.WebsiteRedirectLocation) != "/new-location.html" {
+			t.Errorf("WebsiteRedirectLocation = %q, want %q", aws.ToString(output.WebsiteRedirectLocation), "/new-location.html")
+		}
+	})
+
+	t.Run("PutObjectWithInvalidWebsiteRedirectLocation", func(t *testing.T) {
+		_, err := ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:                  aws.String(bucketName),
+			Key:                     aws.String("invalid-redirect.html"),
+			Body:                    strings.NewReader(""),
+			WebsiteRedirectLocation: aws.String("javascript:alert(1)"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "InvalidRedirectLocation") {
+			t.Fatalf("Expected InvalidRedirectLocation, got %v", err)
+		}
+	})
 }
 
 func TestMultipartUploadMetadata(t *testing.T) {
@@ -337,3 +379,115 @@ func TestMultipartUploadMetadata(t *testing.T) {
 		}
 	})
 }
+
+func TestContentEncodingAWSChunked(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	handler := NewS3Handler(store)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello gzip"))
+	zw.Close()
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "aws-chunked,gzip", want: "gzip"},
+		{header: "gzip, aws-chunked", want: "gzip"},
+		{header: "aws-chunked", want: ""},
+		{header: "gzip", want: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/test-bucket/object", bytes.NewReader(compressed.Bytes()))
+			req.Header.Set("Content-Encoding", tt.header)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("PutObject failed with status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			_, info, err := store.GetObject("test-bucket", "object")
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			if info.Metadata.ContentEncoding != tt.want {
+				t.Errorf("Expected stored Content-Encoding %q, got %q", tt.want, info.Metadata.ContentEncoding)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-bucket/object", nil))
+			if got := rec.Header().Get("Content-Encoding"); got != tt.want {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.want, got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()) {
+				t.Error("Expected the body to be stored as sent")
+			}
+		})
+	}
+}
+
+func TestContentTypeSniffing(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name            string
+		sniff           bool
+		body            []byte
+		contentType     string
+		contentEncoding string
+		want            string
+	}{
+		{name: "Disabled", body: []byte("<html><body>hi</body></html>"), want: "application/octet-stream"},
+		{name: "HTML", sniff: true, body: []byte("<html><body>hi</body></html>"), want: "text/html; charset=utf-8"},
+		{name: "PNG", sniff: true, body: png, want: "image/png"},
+		{name: "Large", sniff: true, body: bytes.Repeat([]byte("plain text "), 1000), want: "text/plain; charset=utf-8"},
+		{name: "Empty", sniff: true, want: "application/octet-stream"},
+		{name: "ClientContentType", sniff: true, body: png, contentType: "application/x-custom", want: "application/x-custom"},
+		{name: "ContentEncoding", sniff: true, body: []byte("<html></html>"), contentEncoding: "br", want: "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewS3Handler(store, WithContentTypeSniffing(tt.sniff))
+			req := httptest.NewRequest(http.MethodPut, "/test-bucket/object", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("PutObject failed with status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-bucket/object", nil))
+			if got := rec.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Expected Content-Type %q, got %q", tt.want, got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.body) {
+				t.Errorf("Expected the whole body to be stored, got %d of %d bytes", rec.Body.Len(), len(tt.body))
+			}
+		})
+	}
+}
@@ -4,27 +4,35 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/s3key"
 	"github.com/wzshiming/s3d/pkg/storage"
 )
 
 // handleInitiateMultipartUpload handles InitiateMultipartUpload operation
 func (s *S3Handler) handleInitiateMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	checksumAlgorithm := strings.ToUpper(r.Header.Get("x-amz-checksum-algorithm"))
+	if checksumAlgorithm != "" && !isValidChecksumAlgorithm(checksumAlgorithm) {
+		s.errorResponse(w, r, "InvalidRequest", "Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, CRC64NVME, SHA1, SHA256]", http.StatusBadRequest)
+		return
 	}
 
+	if !s.checkRedirectLocation(w, r) {
+		return
+	}
 	metadata := extractMetadata(r)
 
-	uploadID, err := s.storage.InitiateMultipartUpload(bucket, key, metadata)
+	uploadID, err := s.storage.InitiateMultipartUploadBy(bucket, key, metadata, checksumAlgorithm, auth.AccessKeyIDFromContext(r.Context()))
 	if err != nil {
-		if err == storage.ErrBucketNotFound {
+		switch err {
+		case storage.ErrBucketNotFound:
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
-		} else {
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -36,9 +44,42 @@ func (s *S3Handler) handleInitiateMultipartUpload(w http.ResponseWriter, r *http
 		UploadId: uploadID,
 	}
 
+	if checksumAlgorithm != "" {
+		w.Header().Set("x-amz-checksum-algorithm", checksumAlgorithm)
+	}
 	s.xmlResponse(w, r, result, http.StatusOK)
 }
 
+// checksumAlgorithms lists the checksum algorithms supported by S3
+var checksumAlgorithms = []string{"CRC32", "CRC32C", "CRC64NVME", "SHA1", "SHA256"}
+
+// isValidChecksumAlgorithm reports whether algorithm is a supported checksum algorithm
+func isValidChecksumAlgorithm(algorithm string) bool {
+	for _, a := range checksumAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// requestChecksumAlgorithm returns the checksum algorithm the request body is sent with,
+// either as a checksum header, the SDK algorithm header or a trailing checksum
+func requestChecksumAlgorithm(r *http.Request) string {
+	for _, algorithm := range checksumAlgorithms {
+		if r.Header.Get("x-amz-checksum-"+strings.ToLower(algorithm)) != "" {
+			return algorithm
+		}
+	}
+	if algorithm := r.Header.Get("x-amz-sdk-checksum-algorithm"); algorithm != "" {
+		return strings.ToUpper(algorithm)
+	}
+	if trailer := r.Header.Get("x-amz-trailer"); strings.HasPrefix(strings.ToLower(trailer), "x-amz-checksum-") {
+		return strings.ToUpper(strings.TrimPrefix(strings.ToLower(trailer), "x-amz-checksum-"))
+	}
+	return ""
+}
+
 // handleUploadPart handles UploadPart operation
 func (s *S3Handler) handleUploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID, partNumberStr string) {
 	partNumber, err := strconv.Atoi(partNumberStr)
@@ -53,10 +94,40 @@ func (s *S3Handler) handleUploadPart(w http.ResponseWriter, r *http.Request, buc
 		return
 	}
 
+	// Parts must be sent with the checksum algorithm the upload was initiated with
+	upload, err := s.storage.GetMultipartUpload(bucket, key, uploadID)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrInvalidUploadID:
+			s.errorResponse(w, r, "NoSuchUpload", "Upload does not exist", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if upload.ChecksumAlgorithm != "" {
+		if algorithm := requestChecksumAlgorithm(r); algorithm != upload.ChecksumAlgorithm {
+			actual := strings.ToLower(algorithm)
+			if actual == "" {
+				actual = "null"
+			}
+			s.errorResponse(w, r, "InvalidRequest", fmt.Sprintf("Checksum Type mismatch occurred, expected checksum Type: %s, actual checksum Type: %s", strings.ToLower(upload.ChecksumAlgorithm), actual), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get the expected checksum from the request header (if provided)
 	expectedChecksumSHA256 := r.Header.Get("x-amz-checksum-sha256")
 
-	objInfo, err := s.storage.UploadPart(bucket, key, uploadID, partNumber, r.Body, expectedChecksumSHA256)
+	body, ok := contentMD5Body(r)
+	if !ok {
+		s.errorResponse(w, r, "InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest)
+		return
+	}
+
+	objInfo, err := s.storage.UploadPart(r.Context(), bucket, key, uploadID, partNumber, body, expectedChecksumSHA256)
 	if err != nil {
 		switch err {
 		case storage.ErrBucketNotFound:
@@ -67,6 +138,14 @@ func (s *S3Handler) handleUploadPart(w http.ResponseWriter, r *http.Request, buc
 			s.errorResponse(w, r, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
 		case storage.ErrChecksumMismatch:
 			s.errorResponse(w, r, "BadDigest", "The Content-SHA256 you specified did not match what we received.", http.StatusBadRequest)
+		case errContentMD5Mismatch:
+			s.errorResponse(w, r, "BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
@@ -74,7 +153,7 @@ func (s *S3Handler) handleUploadPart(w http.ResponseWriter, r *http.Request, buc
 	}
 
 	s.setHeaders(w, r)
-	w.Header().Set("ETag", fmt.Sprintf("%q", objInfo.ETag))
+	w.Header().Set("ETag", s.etag(objInfo.ETag))
 	w.Header().Set("x-amz-checksum-sha256", objInfo.ChecksumSHA256)
 	w.WriteHeader(http.StatusOK)
 }
@@ -88,23 +167,10 @@ func (s *S3Handler) handleUploadPartCopy(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Remove leading slash if present
-	copySource = strings.TrimPrefix(copySource, "/")
-
 	// Parse source bucket and key
-	parts := strings.SplitN(copySource, "/", 2)
-	if len(parts) != 2 {
-		s.errorResponse(w, r, "InvalidArgument", "Invalid copy source format", http.StatusBadRequest)
-		return
-	}
-
-	srcBucket := parts[0]
-	srcKey := parts[1]
-
-	// URL decode the source key (S3 object keys in copy source can be URL-encoded)
-	decodedSrcKey, err := url.QueryUnescape(srcKey)
+	srcBucket, srcKey, _, err := s3key.ParseCopySource(copySource)
 	if err != nil {
-		s.errorResponse(w, r, "InvalidArgument", "Invalid URL encoding in copy source", http.StatusBadRequest)
+		s.errorResponse(w, r, "InvalidArgument", "Invalid copy source format", http.StatusBadRequest)
 		return
 	}
 
@@ -120,7 +186,7 @@ func (s *S3Handler) handleUploadPartCopy(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Perform copy to part
-	objInfo, err := s.storage.UploadPartCopy(bucket, key, uploadID, partNumber, srcBucket, decodedSrcKey, startByte, endByte)
+	objInfo, err := s.storage.UploadPartCopy(r.Context(), bucket, key, uploadID, partNumber, srcBucket, srcKey, startByte, endByte)
 	if err != nil {
 		switch err {
 		case storage.ErrBucketNotFound:
@@ -133,6 +199,12 @@ func (s *S3Handler) handleUploadPartCopy(w http.ResponseWriter, r *http.Request,
 			s.errorResponse(w, r, "InvalidArgument", "Invalid part number", http.StatusBadRequest)
 		case storage.ErrInvalidRange:
 			s.errorResponse(w, r, "InvalidRange", "The requested range is not valid", http.StatusRequestedRangeNotSatisfiable)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
@@ -140,8 +212,8 @@ func (s *S3Handler) handleUploadPartCopy(w http.ResponseWriter, r *http.Request,
 	}
 
 	result := CopyPartResult{
-		LastModified: objInfo.ModTime.UTC(),
-		ETag:         fmt.Sprintf("%q", objInfo.ETag),
+		LastModified: Timestamp{objInfo.ModTime},
+		ETag:         s.etag(objInfo.ETag),
 	}
 
 	s.xmlResponse(w, r, result, http.StatusOK)
@@ -191,7 +263,7 @@ func (s *S3Handler) handleCompleteMultipartUpload(w http.ResponseWriter, r *http
 
 		parts = append(parts, storage.Multipart{
 			PartNumber:     p.PartNumber,
-			ETag:           p.ETag,
+			ETag:           s.storedETag(p.ETag),
 			ChecksumSHA256: p.ChecksumSHA256,
 		})
 	}
@@ -199,26 +271,48 @@ func (s *S3Handler) handleCompleteMultipartUpload(w http.ResponseWriter, r *http
 	// Get the expected checksum from the request header (if provided)
 	expectedChecksumSHA256 := r.Header.Get("x-amz-checksum-sha256")
 
-	objInfo, err := s.storage.CompleteMultipartUpload(bucket, key, uploadID, parts, expectedChecksumSHA256)
+	cond, ok := s.writeCondition(r)
+	if !ok {
+		s.errorResponse(w, r, "NotImplemented", "A header you provided implies functionality that is not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	objInfo, err := s.storage.CompleteMultipartUploadIf(r.Context(), bucket, key, uploadID, parts, expectedChecksumSHA256, cond)
 	if err != nil {
 		switch err {
 		case storage.ErrBucketNotFound:
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
 		case storage.ErrInvalidUploadID:
 			s.errorResponse(w, r, "NoSuchUpload", "Upload does not exist", http.StatusNotFound)
+		case storage.ErrObjectNotFound:
+			s.errorResponse(w, r, "NoSuchKey", "Object does not exist", http.StatusNotFound)
+		case storage.ErrPreconditionFailed:
+			s.errorResponse(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		case storage.ErrInvalidPart:
+			s.errorResponse(w, r, "InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest)
 		case storage.ErrChecksumMismatch:
 			s.errorResponse(w, r, "BadDigest", "The Content-SHA256 you specified did not match what we received.", http.StatusBadRequest)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
+	s.recordAudit(r, bucket, "CompleteMultipartUpload", key, "")
+
 	result := CompleteMultipartUploadResult{
 		Location:       fmt.Sprintf("/%s/%s", bucket, key),
 		Bucket:         bucket,
 		Key:            key,
-		ETag:           fmt.Sprintf("%q", objInfo.ETag),
+		ETag:           s.etag(objInfo.ETag),
 		ChecksumSHA256: objInfo.ChecksumSHA256,
 	}
 
@@ -250,11 +344,15 @@ func (s *S3Handler) handleListMultipartUploads(w http.ResponseWriter, r *http.Re
 	prefix := query.Get("prefix")
 	keyMarker := query.Get("key-marker")
 	uploadIDMarker := query.Get("upload-id-marker")
-	maxUploads := 1000
-	if mu := query.Get("max-uploads"); mu != "" {
-		if parsed, err := strconv.Atoi(mu); err == nil {
-			maxUploads = parsed
-		}
+	encodeKey, ok := keyEncoder(query)
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Invalid Encoding Method specified in Request", http.StatusBadRequest)
+		return
+	}
+	maxUploads, ok := s.parsePageSize(query, "max-uploads")
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Argument max-uploads must be an integer between 0 and 2147483647", http.StatusBadRequest)
+		return
 	}
 
 	// Fetch one extra upload to determine if there are more results
@@ -282,10 +380,11 @@ func (s *S3Handler) handleListMultipartUploads(w http.ResponseWriter, r *http.Re
 	}
 
 	result := ListMultipartUploadsResult{
-		Bucket:      bucket,
-		MaxUploads:  maxUploads,
-		IsTruncated: isTruncated,
-		KeyMarker:   keyMarker,
+		Bucket:       bucket,
+		MaxUploads:   maxUploads,
+		IsTruncated:  isTruncated,
+		KeyMarker:    encodeKey(keyMarker),
+		EncodingType: query.Get("encoding-type"),
 	}
 
 	if uploadIDMarker != "" {
@@ -293,16 +392,19 @@ func (s *S3Handler) handleListMultipartUploads(w http.ResponseWriter, r *http.Re
 	}
 
 	if isTruncated {
-		result.NextKeyMarker = nextKeyMarker
+		result.NextKeyMarker = encodeKey(nextKeyMarker)
 		result.NextUploadIdMarker = nextUploadIDMarker
 	}
 
 	for _, upload := range uploads {
 		result.Uploads = append(result.Uploads, Upload{
-			Key:          upload.Key,
-			UploadId:     upload.UploadID,
-			Initiated:    upload.ModTime,
-			StorageClass: "STANDARD",
+			Key:               encodeKey(upload.Key),
+			UploadId:          upload.UploadID,
+			Initiated:         Timestamp{upload.ModTime},
+			Initiator:         s.uploadInitiator(upload.Initiator),
+			Owner:             s.owner(),
+			StorageClass:      "STANDARD",
+			ChecksumAlgorithm: upload.ChecksumAlgorithm,
 		})
 	}
 
@@ -318,11 +420,10 @@ func (s *S3Handler) handleListParts(w http.ResponseWriter, r *http.Request, buck
 			partNumberMarker = parsed
 		}
 	}
-	maxParts := 1000
-	if mp := query.Get("max-parts"); mp != "" {
-		if parsed, err := strconv.Atoi(mp); err == nil {
-			maxParts = parsed
-		}
+	maxParts, ok := s.parsePageSize(query, "max-parts")
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Argument max-parts must be an integer between 0 and 2147483647", http.StatusBadRequest)
+		return
 	}
 
 	// Fetch one extra part to determine if there are more results
@@ -351,13 +452,29 @@ func (s *S3Handler) handleListParts(w http.ResponseWriter, r *http.Request, buck
 		}
 	}
 
+	upload, err := s.storage.GetMultipartUpload(bucket, key, uploadID)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrInvalidUploadID:
+			s.errorResponse(w, r, "NoSuchUpload", "Upload does not exist", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	result := ListPartsResult{
-		Bucket:       bucket,
-		Key:          key,
-		UploadId:     uploadID,
-		StorageClass: "STANDARD",
-		MaxParts:     maxParts,
-		IsTruncated:  isTruncated,
+		Bucket:            bucket,
+		Key:               key,
+		UploadId:          uploadID,
+		Initiator:         s.uploadInitiator(upload.Initiator),
+		Owner:             s.owner(),
+		StorageClass:      "STANDARD",
+		ChecksumAlgorithm: upload.ChecksumAlgorithm,
+		MaxParts:          maxParts,
+		IsTruncated:       isTruncated,
 	}
 
 	if partNumberMarker > 0 {
@@ -369,12 +486,20 @@ func (s *S3Handler) handleListParts(w http.ResponseWriter, r *http.Request, buck
 	}
 
 	for _, part := range parts {
-		result.Parts = append(result.Parts, CompletedPart{
+		completedPart := CompletedPart{
 			PartNumber:   part.PartNumber,
-			LastModified: part.ModTime,
-			ETag:         fmt.Sprintf("%q", part.ETag),
+			LastModified: Timestamp{part.ModTime},
+			ETag:         s.etag(part.ETag),
 			Size:         part.Size,
-		})
+		}
+		// Only the checksum of the algorithm the upload was initiated with is returned
+		switch upload.ChecksumAlgorithm {
+		case "CRC32":
+			completedPart.ChecksumCRC32 = part.ChecksumCRC32
+		case "SHA256":
+			completedPart.ChecksumSHA256 = part.ChecksumSHA256
+		}
+		result.Parts = append(result.Parts, completedPart)
 	}
 
 	s.xmlResponse(w, r, result, http.StatusOK)
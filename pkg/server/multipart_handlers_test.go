@@ -3,14 +3,21 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/wzshiming/s3d/pkg/storage"
 )
 
 func TestMultipartUpload(t *testing.T) {
@@ -107,6 +114,98 @@ func TestMultipartUpload(t *testing.T) {
 			t.Fatalf("Expected content %q, got %q", expectedContent, string(data))
 		}
 	})
+
+	t.Run("MultipartUploadWithMetadata", func(t *testing.T) {
+		metadataKey := "test-multipart-metadata.txt"
+
+		initOutput, err := ts.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(metadataKey),
+			CacheControl:      aws.String("max-age=3600"),
+			ContentType:       aws.String("text/plain"),
+			Metadata:          map[string]string{"author": "test"},
+			Tagging:           aws.String("env=dev&team=storage"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		if initOutput.ChecksumAlgorithm != types.ChecksumAlgorithmSha256 {
+			t.Errorf("Expected checksum algorithm SHA256, got %q", initOutput.ChecksumAlgorithm)
+		}
+
+		// Parts must use the checksum algorithm of the upload
+		_, err = ts.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(metadataKey),
+			UploadId:          initOutput.UploadId,
+			PartNumber:        aws.Int32(1),
+			Body:              strings.NewReader("part content"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err == nil {
+			t.Fatal("Expected UploadPart with a different checksum algorithm to fail")
+		}
+
+		partOutput, err := ts.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(metadataKey),
+			UploadId:          initOutput.UploadId,
+			PartNumber:        aws.Int32(1),
+			Body:              strings.NewReader("part content"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+
+		_, err = ts.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String(metadataKey),
+			UploadId: initOutput.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: []types.CompletedPart{
+					{
+						PartNumber:     aws.Int32(1),
+						ETag:           partOutput.ETag,
+						ChecksumSHA256: partOutput.ChecksumSHA256,
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+
+		headOutput, err := ts.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(metadataKey),
+		})
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if aws.ToString(headOutput.CacheControl) != "max-age=3600" {
+			t.Errorf("Expected Cache-Control max-age=3600, got %q", aws.ToString(headOutput.CacheControl))
+		}
+		if aws.ToString(headOutput.ContentType) != "text/plain" {
+			t.Errorf("Expected Content-Type text/plain, got %q", aws.ToString(headOutput.ContentType))
+		}
+		if headOutput.Metadata["author"] != "test" {
+			t.Errorf("Expected metadata author=test, got %v", headOutput.Metadata)
+		}
+
+		getOutput, err := ts.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(metadataKey),
+		})
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		getOutput.Body.Close()
+		if aws.ToInt32(getOutput.TagCount) != 2 {
+			t.Errorf("Expected tag count 2, got %d", aws.ToInt32(getOutput.TagCount))
+		}
+	})
 }
 
 func TestListMultipartUploads(t *testing.T) {
@@ -205,6 +304,113 @@ func TestListParts(t *testing.T) {
 			t.Fatalf("Expected part number %d, got %d", i+1, *part.PartNumber)
 		}
 	}
+
+	if output.Initiator == nil || aws.ToString(output.Initiator.ID) == "" {
+		t.Errorf("Expected initiator, got %+v", output.Initiator)
+	}
+	if output.Owner == nil || aws.ToString(output.Owner.ID) == "" {
+		t.Errorf("Expected owner, got %+v", output.Owner)
+	}
+	if output.StorageClass != types.StorageClassStandard {
+		t.Errorf("Expected storage class STANDARD, got %q", output.StorageClass)
+	}
+}
+
+func TestListPartsChecksums(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-list-parts-checksums"
+	partData := "part data with checksum"
+
+	// Create bucket
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	crc := crc32.ChecksumIEEE([]byte(partData))
+	expectedCRC32 := base64.StdEncoding.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)})
+	sum := sha256.Sum256([]byte(partData))
+	expectedSHA256 := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		algorithm types.ChecksumAlgorithm
+		check     func(part types.Part) error
+	}{
+		{
+			name:      "CRC32",
+			algorithm: types.ChecksumAlgorithmCrc32,
+			check: func(part types.Part) error {
+				if aws.ToString(part.ChecksumCRC32) != expectedCRC32 {
+					return fmt.Errorf("expected CRC32 checksum %q, got %q", expectedCRC32, aws.ToString(part.ChecksumCRC32))
+				}
+				return nil
+			},
+		},
+		{
+			name:      "SHA256",
+			algorithm: types.ChecksumAlgorithmSha256,
+			check: func(part types.Part) error {
+				if aws.ToString(part.ChecksumSHA256) != expectedSHA256 {
+					return fmt.Errorf("expected SHA256 checksum %q, got %q", expectedSHA256, aws.ToString(part.ChecksumSHA256))
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objectKey := "checksum-" + tt.name + ".txt"
+
+			initOutput, err := ts.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+				Bucket:            aws.String(bucketName),
+				Key:               aws.String(objectKey),
+				ChecksumAlgorithm: tt.algorithm,
+			})
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+			defer ts.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucketName),
+				Key:      aws.String(objectKey),
+				UploadId: initOutput.UploadId,
+			})
+
+			_, err = ts.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:            aws.String(bucketName),
+				Key:               aws.String(objectKey),
+				UploadId:          initOutput.UploadId,
+				PartNumber:        aws.Int32(1),
+				Body:              strings.NewReader(partData),
+				ChecksumAlgorithm: tt.algorithm,
+			})
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+
+			output, err := ts.client.ListParts(ctx, &s3.ListPartsInput{
+				Bucket:   aws.String(bucketName),
+				Key:      aws.String(objectKey),
+				UploadId: initOutput.UploadId,
+			})
+			if err != nil {
+				t.Fatalf("ListParts failed: %v", err)
+			}
+
+			if output.ChecksumAlgorithm != tt.algorithm {
+				t.Errorf("Expected checksum algorithm %q, got %q", tt.algorithm, output.ChecksumAlgorithm)
+			}
+			if len(output.Parts) != 1 {
+				t.Fatalf("Expected 1 part, got %d", len(output.Parts))
+			}
+			if err := tt.check(output.Parts[0]); err != nil {
+				t.Error(err)
+			}
+		})
+	}
 }
 
 func TestAbortMultipartUpload(t *testing.T) {
@@ -1028,3 +1234,42 @@ func TestUploadPartCopyWithRange(t *testing.T) {
 		}
 	})
 }
+
+func FuzzCompleteMultipartUpload(f *testing.F) {
+	for _, seed := range []string{
+		`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"etag"</ETag></Part></CompleteMultipartUpload>`,
+		`<CompleteMultipartUpload><Part><PartNumber>2</PartNumber></Part><Part><PartNumber>1</PartNumber></Part></CompleteMultipartUpload>`,
+		`<CompleteMultipartUpload><Part><PartNumber>-1</PartNumber><ChecksumSHA256>!!</ChecksumSHA256></Part></CompleteMultipartUpload>`,
+		`<CompleteMultipartUpload><Part><PartNumber>99999999999</PartNumber></Part>`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	store, err := storage.NewStorage(f.TempDir())
+	if err != nil {
+		f.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("fuzz-bucket"); err != nil {
+		f.Fatalf("Failed to create bucket: %v", err)
+	}
+	handler := NewS3Handler(store)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		uploadID, err := store.InitiateMultipartUpload("fuzz-bucket", "key", storage.Metadata{}, "")
+		if err != nil {
+			t.Fatalf("Failed to initiate upload: %v", err)
+		}
+		defer store.AbortMultipartUpload("fuzz-bucket", "key", uploadID)
+		if _, err := store.UploadPart(context.Background(), "fuzz-bucket", "key", uploadID, 1, strings.NewReader("data"), ""); err != nil {
+			t.Fatalf("Failed to upload part: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/fuzz-bucket/key?uploadId="+uploadID, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusInternalServerError {
+			t.Fatalf("CompleteMultipartUpload with %q failed with status %d: %s", body, rec.Code, rec.Body.String())
+		}
+	})
+}
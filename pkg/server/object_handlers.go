@@ -3,21 +3,22 @@ package server
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/wzshiming/s3d/pkg/s3key"
 	"github.com/wzshiming/s3d/pkg/storage"
 )
 
-const (
-	// Default owner ID and display name for S3 objects
-	defaultOwnerID          = "s3d-owner"
-	defaultOwnerDisplayName = "s3d-owner"
-)
-
 // handlePutObject handles PutObject operation
 func (s *S3Handler) handlePutObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.checkRedirectLocation(w, r) {
+		return
+	}
+
 	if r.Header.Get("x-amz-rename-source") != "" {
 		s.handleRenameObject(w, r, bucket, key)
 		return
@@ -30,23 +31,53 @@ func (s *S3Handler) handlePutObject(w http.ResponseWriter, r *http.Request, buck
 	// Get the expected checksum from the request header (if provided)
 	expectedChecksumSHA256 := r.Header.Get("x-amz-checksum-sha256")
 
+	body, ok := contentMD5Body(r)
+	if !ok {
+		s.errorResponse(w, r, "InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest)
+		return
+	}
+	cond, ok := s.writeCondition(r)
+	if !ok {
+		s.errorResponse(w, r, "NotImplemented", "A header you provided implies functionality that is not implemented", http.StatusNotImplemented)
+		return
+	}
+
 	metadata := extractMetadata(r)
+	if s.sniff && metadata.ContentType == "" && metadata.ContentEncoding == "" {
+		body, metadata.ContentType = sniffContentType(body)
+	}
 
-	objInfo, err := s.storage.PutObject(bucket, key, r.Body, metadata, expectedChecksumSHA256)
+	objInfo, err := s.storage.PutObjectIf(r.Context(), bucket, key, body, metadata, expectedChecksumSHA256, cond)
 	if err != nil {
 		switch err {
 		case storage.ErrBucketNotFound:
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrObjectNotFound:
+			s.errorResponse(w, r, "NoSuchKey", "Object does not exist", http.StatusNotFound)
+		case storage.ErrPreconditionFailed:
+			s.errorResponse(w, r, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
 		case storage.ErrChecksumMismatch:
 			s.errorResponse(w, r, "BadDigest", "The Content-SHA256 you specified did not match what we received.", http.StatusBadRequest)
+		case errContentMD5Mismatch:
+			s.errorResponse(w, r, "BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
+	s.recordAudit(r, bucket, "PutObject", key, "")
+
 	s.setHeaders(w, r)
-	w.Header().Set("ETag", fmt.Sprintf("%q", objInfo.ETag))
+	w.Header().Set("ETag", s.etag(objInfo.ETag))
 	w.Header().Set("x-amz-checksum-sha256", objInfo.ChecksumSHA256)
 	w.WriteHeader(http.StatusOK)
 }
@@ -68,24 +99,34 @@ func (s *S3Handler) handleGetObject(w http.ResponseWriter, r *http.Request, buck
 	defer reader.Close()
 
 	s.setHeaders(w, r)
-	w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+	w.Header().Set("ETag", s.etag(info.ETag))
 	w.Header().Set("x-amz-checksum-sha256", info.ChecksumSHA256)
 	setMetadataHeaders(w, info.Metadata)
 
-	http.ServeContent(w, r, key, info.ModTime, reader)
+	// Range, If-Range and the conditional read headers are evaluated against the ETag and the
+	// modification time, a stale If-Range falls back to the full object with a 200
+	serveContent(w, r, key, info.ModTime, info.Size, reader, s.compress)
 }
 
 // handleDeleteObject handles DeleteObject operation
 func (s *S3Handler) handleDeleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
 	err := s.storage.DeleteObject(bucket, key)
 	if err != nil && err != storage.ErrObjectNotFound {
-		if err == storage.ErrBucketNotFound {
+		switch err {
+		case storage.ErrBucketNotFound:
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
-		} else {
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
+	if err == nil {
+		s.recordAudit(r, bucket, "DeleteObject", key, "")
+	}
 
 	s.setHeaders(w, r)
 	w.WriteHeader(http.StatusNoContent)
@@ -99,39 +140,152 @@ func (s *S3Handler) handleDeleteObjects(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Parse the request body
+	// Parse the request body, read to the end so that its Content-MD5 is checked
+	body, ok := contentMD5Body(r)
+	if !ok {
+		s.errorResponse(w, r, "InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		if err == errContentMD5Mismatch {
+			s.errorResponse(w, r, "BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest)
+		} else {
+			s.errorResponse(w, r, "IncompleteBody", "The request body could not be read", http.StatusBadRequest)
+		}
+		return
+	}
 	var deleteReq Delete
-	if err := xml.NewDecoder(r.Body).Decode(&deleteReq); err != nil {
+	if err := xml.Unmarshal(data, &deleteReq); err != nil {
 		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
 		return
 	}
 
-	// Process deletions
-	result := DeleteObjectsResult{}
-
+	keys := make([]string, 0, len(deleteReq.Objects))
 	for _, obj := range deleteReq.Objects {
-		err := s.storage.DeleteObject(bucket, obj.Key)
+		keys = append(keys, obj.Key)
+	}
 
-		if err != nil && err != storage.ErrObjectNotFound {
-			// Add to errors list
-			result.Errors = append(result.Errors, DeleteError{
-				Key:     obj.Key,
-				Code:    "InternalError",
-				Message: err.Error(),
-			})
-		} else {
+	// Process deletions, reporting failures per key
+	errs, err := s.storage.DeleteObjects(bucket, keys)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := DeleteObjectsResult{}
+	for i, key := range keys {
+		switch errs[i] {
+		case nil, storage.ErrObjectNotFound:
+			if errs[i] == nil {
+				s.recordAudit(r, bucket, "DeleteObject", key, "")
+			}
 			// Successfully deleted (or object didn't exist, which is also considered success in S3)
 			if !deleteReq.Quiet {
 				result.Deleted = append(result.Deleted, DeletedObject{
-					Key: obj.Key,
+					Key: key,
 				})
 			}
+		case storage.ErrObjectLocked:
+			result.Errors = append(result.Errors, DeleteError{
+				Key:     key,
+				Code:    "AccessDenied",
+				Message: "Object is protected by the WORM retention of the bucket",
+			})
+		case storage.ErrBucketFrozen:
+			result.Errors = append(result.Errors, DeleteError{
+				Key:     key,
+				Code:    "InvalidBucketState",
+				Message: "The bucket is frozen",
+			})
+		case storage.ErrInvalidObjectKey:
+			result.Errors = append(result.Errors, DeleteError{
+				Key:     key,
+				Code:    "InvalidArgument",
+				Message: "Invalid object key",
+			})
+		default:
+			result.Errors = append(result.Errors, DeleteError{
+				Key:     key,
+				Code:    "InternalError",
+				Message: errs[i].Error(),
+			})
 		}
 	}
 
 	s.xmlResponse(w, r, result, http.StatusOK)
 }
 
+// deletePrefixProgressInterval is the number of keys between progress reports of DeletePrefix
+const deletePrefixProgressInterval = 1000
+
+// maxDeletePrefixErrors is the number of failed keys a DeletePrefix response lists,
+// the others are only counted
+const maxDeletePrefixErrors = 1000
+
+// handleDeletePrefix handles the DeletePrefix extension operation, which deletes every object
+// under a prefix server-side; the response streams the progress and the keys that failed
+func (s *S3Handler) handleDeletePrefix(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	// An explicit, possibly empty, prefix guards against emptying a bucket by accident
+	if !query.Has("prefix") {
+		s.errorResponse(w, r, "InvalidArgument", "The prefix parameter is required", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+
+	if !s.storage.BucketExists(bucket) {
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	x := s.xmlStreamResponse(w, r, "DeletePrefixResult", http.StatusOK)
+	x.element("Prefix", prefix)
+	x.flush()
+
+	var progress DeletePrefixProgress
+	err := s.storage.DeletePrefix(r.Context(), bucket, prefix, func(key string, err error) {
+		switch err {
+		case nil, storage.ErrObjectNotFound:
+			// Objects deleted by someone else meanwhile count as deleted
+			if err == nil {
+				s.recordAudit(r, bucket, "DeleteObject", key, "")
+			}
+			progress.Deleted++
+		default:
+			progress.Failed++
+			if progress.Failed <= maxDeletePrefixErrors {
+				deleteErr := DeleteError{Key: key}
+				switch err {
+				case storage.ErrObjectLocked:
+					deleteErr.Code, deleteErr.Message = "AccessDenied", "Object is protected by the WORM retention of the bucket"
+				case storage.ErrBucketFrozen:
+					deleteErr.Code, deleteErr.Message = "InvalidBucketState", "The bucket is frozen"
+				default:
+					deleteErr.Code, deleteErr.Message = "InternalError", err.Error()
+				}
+				x.element("Error", deleteErr)
+			}
+		}
+		if (progress.Deleted+progress.Failed)%deletePrefixProgressInterval == 0 {
+			x.element("Progress", progress)
+			x.flush()
+		}
+	})
+	if err != nil && r.Context().Err() == nil {
+		// The status is already sent, so the failure is reported in the body
+		x.element("Error", DeleteError{Code: "InternalError", Message: err.Error()})
+	}
+	x.element("Deleted", progress.Deleted)
+	x.element("Failed", progress.Failed)
+	x.close()
+}
+
 // handleCopyObject handles CopyObject operation
 func (s *S3Handler) handleCopyObject(w http.ResponseWriter, r *http.Request, dstBucket, dstKey string) {
 	// Parse x-amz-copy-source header
@@ -141,19 +295,13 @@ func (s *S3Handler) handleCopyObject(w http.ResponseWriter, r *http.Request, dst
 		return
 	}
 
-	// Remove leading slash if present
-	copySource = strings.TrimPrefix(copySource, "/")
-
 	// Parse source bucket and key
-	parts := strings.SplitN(copySource, "/", 2)
-	if len(parts) != 2 {
+	srcBucket, srcKey, _, err := s3key.ParseCopySource(copySource)
+	if err != nil {
 		s.errorResponse(w, r, "InvalidArgument", "Invalid copy source format", http.StatusBadRequest)
 		return
 	}
 
-	srcBucket := parts[0]
-	srcKey := parts[1]
-
 	// Handle x-amz-metadata-directive header
 	// COPY (default): copy metadata from source object
 	// REPLACE: use metadata from request headers
@@ -172,15 +320,158 @@ func (s *S3Handler) handleCopyObject(w http.ResponseWriter, r *http.Request, dst
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
 		case storage.ErrObjectNotFound:
 			s.errorResponse(w, r, "NoSuchKey", "Source object does not exist", http.StatusNotFound)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
+	s.recordAudit(r, dstBucket, "CopyObject", dstKey, srcBucket+"/"+srcKey)
+
 	result := CopyObjectResult{
-		LastModified: objInfo.ModTime.UTC(),
-		ETag:         fmt.Sprintf("%q", objInfo.ETag),
+		LastModified: Timestamp{objInfo.ModTime},
+		ETag:         s.etag(objInfo.ETag),
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleComposeObject handles the ComposeObject extension operation,
+// which concatenates objects of the bucket into a new object server-side
+func (s *S3Handler) handleComposeObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var req ComposeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "Invalid XML", http.StatusBadRequest)
+		return
+	}
+
+	sources := make([]string, 0, len(req.Sources))
+	for _, source := range req.Sources {
+		sources = append(sources, source.Key)
+	}
+
+	// Like CopyObject, metadata comes from the first source unless replaced
+	var metadata *storage.Metadata
+	if r.Header.Get("x-amz-metadata-directive") == "REPLACE" {
+		if !s.checkRedirectLocation(w, r) {
+			return
+		}
+		m := extractMetadata(r)
+		metadata = &m
+	}
+
+	objInfo, err := s.storage.ComposeObject(r.Context(), bucket, key, sources, metadata)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrObjectNotFound:
+			s.errorResponse(w, r, "NoSuchKey", "Source object does not exist", http.StatusNotFound)
+		case storage.ErrInvalidObjectKey:
+			s.errorResponse(w, r, "InvalidArgument", "Invalid object key", http.StatusBadRequest)
+		case storage.ErrInvalidComposeSources:
+			s.errorResponse(w, r, "InvalidArgument", fmt.Sprintf("A compose request must have between 1 and %d sources", storage.MaxComposeSources), http.StatusBadRequest)
+		case storage.ErrEntityTooLarge:
+			s.errorResponse(w, r, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		case storage.ErrInsufficientStorage:
+			s.errorResponse(w, r, "InsufficientStorage", "Not enough free disk space to store the object.", http.StatusInsufficientStorage)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, bucket, "ComposeObject", key, "")
+
+	result := ComposeResult{
+		Bucket:         bucket,
+		Key:            key,
+		ETag:           s.etag(objInfo.ETag),
+		Size:           objInfo.Size,
+		LastModified:   Timestamp{objInfo.ModTime},
+		ChecksumSHA256: objInfo.ChecksumSHA256,
+	}
+
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleCopyObjects handles the CopyObjects extension operation, which copies
+// many objects into the bucket server-side in one request, reporting failures per copy
+func (s *S3Handler) handleCopyObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req CopyObjectsRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+
+	copies := make([]storage.CopyRequest, 0, len(req.Objects))
+	for _, obj := range req.Objects {
+		srcBucket := obj.SourceBucket
+		if srcBucket == "" {
+			srcBucket = bucket
+		}
+		copies = append(copies, storage.CopyRequest{SrcBucket: srcBucket, SrcKey: obj.SourceKey, DstKey: obj.Key})
+	}
+
+	infos, errs, err := s.storage.CopyObjects(r.Context(), bucket, copies)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrInvalidCopyObjects:
+			s.errorResponse(w, r, "InvalidArgument", fmt.Sprintf("A copy request must have between 1 and %d objects", storage.MaxCopyObjects), http.StatusBadRequest)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CopyObjectsResult{}
+	for i, c := range copies {
+		var code, message string
+		switch errs[i] {
+		case nil:
+			s.recordAudit(r, bucket, "CopyObject", c.DstKey, c.SrcBucket+"/"+c.SrcKey)
+			if !req.Quiet {
+				result.Copied = append(result.Copied, CopiedObject{
+					Key:          c.DstKey,
+					ETag:         s.etag(infos[i].ETag),
+					LastModified: Timestamp{infos[i].ModTime},
+				})
+			}
+			continue
+		case storage.ErrBucketNotFound:
+			code, message = "NoSuchBucket", "Source bucket does not exist"
+		case storage.ErrObjectNotFound:
+			code, message = "NoSuchKey", "Source object does not exist"
+		case storage.ErrInvalidObjectKey:
+			code, message = "InvalidArgument", "Invalid object key"
+		case storage.ErrObjectLocked:
+			code, message = "AccessDenied", "Object is protected by the WORM retention of the bucket"
+		case storage.ErrBucketFrozen:
+			code, message = "InvalidBucketState", "The bucket is frozen"
+		case storage.ErrInsufficientStorage:
+			code, message = "InsufficientStorage", "Not enough free disk space to store the object."
+		default:
+			code, message = "InternalError", errs[i].Error()
+		}
+		result.Errors = append(result.Errors, CopyError{
+			Key:          c.DstKey,
+			SourceBucket: c.SrcBucket,
+			SourceKey:    c.SrcKey,
+			Code:         code,
+			Message:      message,
+		})
 	}
 
 	s.xmlResponse(w, r, result, http.StatusOK)
@@ -222,18 +513,83 @@ func (s *S3Handler) handleRenameObject(w http.ResponseWriter, r *http.Request, b
 			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
 		case storage.ErrObjectNotFound:
 			s.errorResponse(w, r, "NoSuchKey", "Source object does not exist", http.StatusNotFound)
+		case storage.ErrObjectLocked:
+			s.errorResponse(w, r, "AccessDenied", "Object is protected by the WORM retention of the bucket", http.StatusForbidden)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
 		default:
 			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
+	s.recordAudit(r, bucket, "RenameObject", dstKey, bucket+"/"+srcKey)
+
 	// RenameObject returns 204 No Content on success
 	s.setHeaders(w, r)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// defaultMaxKeys is the most keys, multipart uploads and parts a listing returns, as in AWS
+const defaultMaxKeys = 1000
+
+// parsePageSize returns the page size a listing asks for in the named parameter, capped at the
+// maximum of the handler and defaulting to it, or false if the parameter is not a non-negative integer
+func (s *S3Handler) parsePageSize(query url.Values, name string) (int, bool) {
+	v := query.Get(name)
+	if v == "" {
+		return s.maxKeys, true
+	}
+	parsed, err := strconv.Atoi(v)
+	if err != nil || parsed < 0 {
+		return 0, false
+	}
+	return min(parsed, s.maxKeys), true
+}
+
+// listObjects lists up to maxKeys objects after marker, reporting whether more follow
+func (s *S3Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket, prefix, delimiter, marker string, maxKeys int) ([]storage.ObjectInfo, []string, bool, bool) {
+	// Handle maxKeys=0 special case
+	if maxKeys == 0 {
+		return nil, nil, false, true
+	}
+	objects, commonPrefixes, err := s.storage.ListObjects(bucket, prefix, delimiter, marker, maxKeys+1)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return nil, nil, false, false
+	}
+
+	// Determine if results are truncated, common prefixes counting against maxKeys as in S3
+	isTruncated := len(objects)+len(commonPrefixes) > maxKeys
+	if isTruncated {
+		// Remove the extra object or common prefix, whichever sorts later
+		if len(commonPrefixes) == 0 || (len(objects) != 0 && objects[len(objects)-1].Key > commonPrefixes[len(commonPrefixes)-1]) {
+			objects = objects[:len(objects)-1]
+		} else {
+			commonPrefixes = commonPrefixes[:len(commonPrefixes)-1]
+		}
+	}
+	return objects, commonPrefixes, isTruncated, true
+}
+
+// lastListed returns the key or common prefix of a page that sorts last, where the next page starts
+func lastListed(objects []storage.ObjectInfo, commonPrefixes []string) string {
+	last := ""
+	if len(objects) != 0 {
+		last = objects[len(objects)-1].Key
+	}
+	if len(commonPrefixes) != 0 {
+		last = max(last, commonPrefixes[len(commonPrefixes)-1])
+	}
+	return last
+}
+
 // handleListObjects handles ListObjects operation (v1 and v2)
+// The result is streamed in the element order of ListBucketResult
 func (s *S3Handler) handleListObjects(w http.ResponseWriter, r *http.Request, bucket string) {
 	query := r.URL.Query()
 
@@ -243,81 +599,56 @@ func (s *S3Handler) handleListObjects(w http.ResponseWriter, r *http.Request, bu
 		return
 	}
 
+	encodeKey, ok := keyEncoder(query)
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Invalid Encoding Method specified in Request", http.StatusBadRequest)
+		return
+	}
+
 	// ListObjects v1
 	prefix := query.Get("prefix")
 	delimiter := query.Get("delimiter")
 	marker := query.Get("marker")
-	maxKeys := 1000
-	if mk := query.Get("max-keys"); mk != "" {
-		parsed, err := strconv.Atoi(mk)
-		if err != nil || parsed < 0 {
-			s.errorResponse(w, r, "InvalidArgument", "Argument max-keys must be an integer between 0 and 2147483647", http.StatusBadRequest)
-			return
-		}
-		maxKeys = parsed
-	}
-
-	// Handle maxKeys=0 special case
-	var objects []storage.ObjectInfo
-	var commonPrefixes []string
-	var err error
-	if maxKeys != 0 {
-		objects, commonPrefixes, err = s.storage.ListObjects(bucket, prefix, delimiter, marker, maxKeys+1)
-		if err != nil {
-			if err == storage.ErrBucketNotFound {
-				s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
-			} else {
-				s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
-			}
-			return
-		}
-	}
-
-	// Determine if results are truncated
-	isTruncated := len(objects) > maxKeys
-	var nextMarker string
-	if isTruncated {
-		// Remove the extra object
-		objects = objects[:maxKeys]
-		// Set next marker to the last object key
-		if len(objects) > 0 {
-			nextMarker = objects[len(objects)-1].Key
-		}
+	maxKeys, ok := s.parsePageSize(query, "max-keys")
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Argument max-keys must be an integer between 0 and 2147483647", http.StatusBadRequest)
+		return
 	}
 
-	result := ListBucketResult{
-		Name:        bucket,
-		Prefix:      prefix,
-		Marker:      marker,
-		Delimiter:   delimiter,
-		MaxKeys:     maxKeys,
-		IsTruncated: isTruncated,
+	objects, commonPrefixes, isTruncated, ok := s.listObjects(w, r, bucket, prefix, delimiter, marker, maxKeys)
+	if !ok {
+		return
 	}
 
-	if isTruncated {
-		result.NextMarker = nextMarker
+	x := s.xmlStreamResponse(w, r, "ListBucketResult", http.StatusOK)
+	x.element("Name", bucket)
+	x.element("Prefix", encodeKey(prefix))
+	x.element("Marker", encodeKey(marker))
+	if isTruncated && delimiter != "" {
+		// S3 only returns the next marker along with a delimiter, clients use the last key otherwise
+		x.optional("NextMarker", encodeKey(lastListed(objects, commonPrefixes)))
 	}
-
+	x.optional("Delimiter", encodeKey(delimiter))
+	x.element("MaxKeys", maxKeys)
+	x.element("IsTruncated", isTruncated)
+	x.optional("EncodingType", query.Get("encoding-type"))
 	for _, obj := range objects {
-		result.Contents = append(result.Contents, Contents{
-			Key:          obj.Key,
-			LastModified: obj.ModTime,
-			ETag:         fmt.Sprintf("%q", obj.ETag),
+		x.element("Contents", Contents{
+			Key:          encodeKey(obj.Key),
+			LastModified: Timestamp{obj.ModTime},
+			ETag:         s.etag(obj.ETag),
 			Size:         obj.Size,
 			StorageClass: "STANDARD",
 		})
 	}
-
 	for _, cp := range commonPrefixes {
-		result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{
-			Prefix: cp,
-		})
+		x.element("CommonPrefixes", CommonPrefix{Prefix: encodeKey(cp)})
 	}
-
-	s.xmlResponse(w, r, result, http.StatusOK)
+	x.close()
 }
 
 // handleListObjectsV2 handles ListObjectsV2 operation
+// The result is streamed in the element order of ListBucketResultV2
 func (s *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) {
 	query := r.URL.Query()
 	prefix := query.Get("prefix")
@@ -325,14 +656,15 @@ func (s *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request,
 	startAfter := query.Get("start-after")
 	continuationToken := query.Get("continuation-token")
 	fetchOwner := query.Get("fetch-owner") == "true"
-	maxKeys := 1000
-	if mk := query.Get("max-keys"); mk != "" {
-		parsed, err := strconv.Atoi(mk)
-		if err != nil || parsed < 0 {
-			s.errorResponse(w, r, "InvalidArgument", "Argument max-keys must be an integer between 0 and 2147483647", http.StatusBadRequest)
-			return
-		}
-		maxKeys = parsed
+	encodeKey, ok := keyEncoder(query)
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Invalid Encoding Method specified in Request", http.StatusBadRequest)
+		return
+	}
+	maxKeys, ok := s.parsePageSize(query, "max-keys")
+	if !ok {
+		s.errorResponse(w, r, "InvalidArgument", "Argument max-keys must be an integer between 0 and 2147483647", http.StatusBadRequest)
+		return
 	}
 
 	// Determine the marker to use
@@ -343,71 +675,41 @@ func (s *S3Handler) handleListObjectsV2(w http.ResponseWriter, r *http.Request,
 		marker = startAfter
 	}
 
-	// Handle maxKeys=0 special case
-	var objects []storage.ObjectInfo
-	var commonPrefixes []string
-	var err error
-	if maxKeys != 0 {
-		objects, commonPrefixes, err = s.storage.ListObjects(bucket, prefix, delimiter, marker, maxKeys+1)
-		if err != nil {
-			if err == storage.ErrBucketNotFound {
-				s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
-			} else {
-				s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
-			}
-			return
-		}
-	}
-
-	// Determine if results are truncated
-	isTruncated := len(objects) > maxKeys
-	var nextContinuationToken string
-	if isTruncated {
-		// Remove the extra object
-		objects = objects[:maxKeys]
-		// Set next continuation token to the last object key
-		if len(objects) > 0 {
-			nextContinuationToken = objects[len(objects)-1].Key
-		}
-	}
-
-	result := ListBucketResultV2{
-		Name:              bucket,
-		Prefix:            prefix,
-		Delimiter:         delimiter,
-		MaxKeys:           maxKeys,
-		KeyCount:          len(objects),
-		IsTruncated:       isTruncated,
-		StartAfter:        startAfter,
-		ContinuationToken: continuationToken,
+	objects, commonPrefixes, isTruncated, ok := s.listObjects(w, r, bucket, prefix, delimiter, marker, maxKeys)
+	if !ok {
+		return
 	}
 
+	x := s.xmlStreamResponse(w, r, "ListBucketResult", http.StatusOK)
+	x.element("Name", bucket)
+	x.element("Prefix", encodeKey(prefix))
+	x.optional("Delimiter", encodeKey(delimiter))
+	x.element("MaxKeys", maxKeys)
+	x.element("KeyCount", len(objects)+len(commonPrefixes))
+	x.element("IsTruncated", isTruncated)
+	x.element("ContinuationToken", continuationToken)
 	if isTruncated {
-		result.NextContinuationToken = nextContinuationToken
+		x.optional("NextContinuationToken", lastListed(objects, commonPrefixes))
 	}
-
+	x.optional("StartAfter", encodeKey(startAfter))
+	x.optional("EncodingType", query.Get("encoding-type"))
 	for _, obj := range objects {
 		content := Contents{
-			Key:          obj.Key,
-			LastModified: obj.ModTime,
-			ETag:         fmt.Sprintf("%q", obj.ETag),
+			Key:          encodeKey(obj.Key),
+			LastModified: Timestamp{obj.ModTime},
+			ETag:         s.etag(obj.ETag),
 			Size:         obj.Size,
 			StorageClass: "STANDARD",
 		}
 		if fetchOwner {
-			content.Owner = &Owner{
-				ID:          defaultOwnerID,
-				DisplayName: defaultOwnerDisplayName,
-			}
+			// Objects belong to the bucket owner, as with the BucketOwnerEnforced object ownership
+			owner := s.owner()
+			content.Owner = &owner
 		}
-		result.Contents = append(result.Contents, content)
+		x.element("Contents", content)
 	}
-
 	for _, cp := range commonPrefixes {
-		result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{
-			Prefix: cp,
-		})
+		x.element("CommonPrefixes", CommonPrefix{Prefix: encodeKey(cp)})
 	}
-
-	s.xmlResponse(w, r, result, http.StatusOK)
+	x.close()
 }
@@ -3,14 +3,24 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/wzshiming/s3d/pkg/s3key"
+	"github.com/wzshiming/s3d/pkg/storage"
 )
 
 func TestObjectOperations(t *testing.T) {
@@ -868,8 +878,8 @@ func TestListObjectsV1Pagination(t *testing.T) {
 			t.Errorf("Expected IsTruncated=true when more objects exist than MaxKeys, got false")
 		}
 
-		// Continue fetching pages if truncated
-		marker := output.NextMarker
+		// Continue fetching pages if truncated, after the last key without a delimiter
+		marker := output.Contents[len(output.Contents)-1].Key
 		for output.IsTruncated != nil && *output.IsTruncated {
 			output, err = ts.client.ListObjects(ctx, &s3.ListObjectsInput{
 				Bucket:  aws.String(bucketName),
@@ -884,7 +894,7 @@ func TestListObjectsV1Pagination(t *testing.T) {
 				allObjects = append(allObjects, *obj.Key)
 			}
 
-			marker = output.NextMarker
+			marker = output.Contents[len(output.Contents)-1].Key
 		}
 
 		// Verify we got all objects
@@ -920,12 +930,81 @@ func TestListObjectsV1Pagination(t *testing.T) {
 			t.Errorf("Expected IsTruncated=true with MaxKeys=1 and %d total objects", numObjects)
 		}
 
-		if output.NextMarker == nil || *output.NextMarker == "" {
-			t.Errorf("Expected NextMarker to be set when IsTruncated=true")
+		if output.NextMarker != nil {
+			t.Errorf("Expected no NextMarker without a delimiter, got %q", *output.NextMarker)
 		}
 	})
 }
 
+func TestListObjectsNextMarker(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-list-next-marker"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	// Common prefixes interleaved with keys, several sorting after the last key
+	keys := []string{"a.txt", "b/1", "b/2", "c.txt", "d/1", "e/1", "e/2", "f/1"}
+	for _, key := range keys {
+		_, err := ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(key),
+		})
+		if err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+		defer ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+	}
+
+	var listed, nextMarkers []string
+	var marker *string
+	for {
+		output, err := ts.client.ListObjects(ctx, &s3.ListObjectsInput{
+			Bucket:    aws.String(bucketName),
+			Delimiter: aws.String("/"),
+			MaxKeys:   aws.Int32(2),
+			Marker:    marker,
+		})
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		if len(output.Contents)+len(output.CommonPrefixes) > 2 {
+			t.Errorf("Expected keys and common prefixes to count against MaxKeys, got %d and %d", len(output.Contents), len(output.CommonPrefixes))
+		}
+		for _, obj := range output.Contents {
+			listed = append(listed, *obj.Key)
+		}
+		for _, cp := range output.CommonPrefixes {
+			listed = append(listed, *cp.Prefix)
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			if output.NextMarker != nil {
+				t.Errorf("Expected no NextMarker on the last page, got %q", *output.NextMarker)
+			}
+			break
+		}
+		if output.NextMarker == nil {
+			t.Fatal("Expected a NextMarker when truncated with a delimiter")
+		}
+		nextMarkers = append(nextMarkers, *output.NextMarker)
+		marker = output.NextMarker
+	}
+
+	sort.Strings(listed)
+	if want := "a.txt,b/,c.txt,d/,e/,f/"; strings.Join(listed, ",") != want {
+		t.Errorf("Expected every key and common prefix once, got %v", listed)
+	}
+	if want := "b/,d/"; strings.Join(nextMarkers, ",") != want {
+		t.Errorf("Expected the next markers to be the entries sorting last, got %v", nextMarkers)
+	}
+}
+
 func TestDeleteObjects(t *testing.T) {
 	ctx := context.Background()
 	bucketName := "test-delete-objects"
@@ -1671,6 +1750,19 @@ func TestListObjectsInvalidMaxKeys(t *testing.T) {
 		}
 	})
 
+	t.Run("ListObjectsV2MaxKeysCapped", func(t *testing.T) {
+		output, err := ts.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(bucketName),
+			MaxKeys: aws.Int32(5000),
+		})
+		if err != nil {
+			t.Fatalf("ListObjectsV2 with MaxKeys=5000 failed: %v", err)
+		}
+		if aws.ToInt32(output.MaxKeys) != 1000 {
+			t.Errorf("Expected MaxKeys to be capped at 1000, got %d", aws.ToInt32(output.MaxKeys))
+		}
+	})
+
 	t.Run("ListObjectsV1MaxKeysNegative", func(t *testing.T) {
 		_, err := ts.client.ListObjects(ctx, &s3.ListObjectsInput{
 			Bucket:  aws.String(bucketName),
@@ -1693,3 +1785,784 @@ func TestListObjectsInvalidMaxKeys(t *testing.T) {
 		}
 	})
 }
+
+func TestWithMaxKeys(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	ctx := context.Background()
+	var uploadID string
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.PutObject(ctx, "bucket", key, strings.NewReader(key), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if uploadID, err = store.InitiateMultipartUpload("bucket", key, storage.Metadata{}, ""); err != nil {
+			t.Fatalf("InitiateMultipartUpload failed: %v", err)
+		}
+	}
+	for partNumber := 1; partNumber <= 3; partNumber++ {
+		if _, err := store.UploadPart(ctx, "bucket", "c", uploadID, partNumber, strings.NewReader("part"), ""); err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+	}
+
+	get := func(handler http.Handler, target string, v any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code == http.StatusOK {
+			if err := xml.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("Failed to parse %s: %v", target, err)
+			}
+		}
+		return rec.Code
+	}
+
+	small := NewS3Handler(store, WithMaxKeys(2))
+	var objects ListBucketResultV2
+	if code := get(small, "/bucket?list-type=2&max-keys=5", &objects); code != http.StatusOK || objects.MaxKeys != 2 || len(objects.Contents) != 2 || !objects.IsTruncated {
+		t.Errorf("Expected max-keys to be capped at 2, got %d: %+v", code, objects)
+	}
+	var uploads ListMultipartUploadsResult
+	if code := get(small, "/bucket?uploads&max-uploads=5", &uploads); code != http.StatusOK || uploads.MaxUploads != 2 || len(uploads.Uploads) != 2 || !uploads.IsTruncated {
+		t.Errorf("Expected max-uploads to be capped at 2, got %d: %+v", code, uploads)
+	}
+	var parts ListPartsResult
+	if code := get(small, "/bucket/c?uploadId="+uploadID, &parts); code != http.StatusOK || parts.MaxParts != 2 || len(parts.Parts) != 2 || !parts.IsTruncated {
+		t.Errorf("Expected max-parts to default to the cap of 2, got %d: %+v", code, parts)
+	}
+
+	large := NewS3Handler(store, WithMaxKeys(5000))
+	objects = ListBucketResultV2{}
+	if code := get(large, "/bucket?list-type=2&max-keys=2000", &objects); code != http.StatusOK || objects.MaxKeys != 2000 {
+		t.Errorf("Expected a raised cap to honor max-keys=2000, got %d: %+v", code, objects)
+	}
+
+	standard := NewS3Handler(store, WithMaxKeys(0))
+	uploads = ListMultipartUploadsResult{}
+	if code := get(standard, "/bucket?uploads&max-uploads=5000", &uploads); code != http.StatusOK || uploads.MaxUploads != 1000 {
+		t.Errorf("Expected max-uploads to be capped at 1000, got %d: %+v", code, uploads)
+	}
+	parts = ListPartsResult{}
+	if code := get(standard, "/bucket/c?uploadId="+uploadID+"&max-parts=5000", &parts); code != http.StatusOK || parts.MaxParts != 1000 || len(parts.Parts) != 3 {
+		t.Errorf("Expected max-parts to be capped at 1000, got %d: %+v", code, parts)
+	}
+	for _, target := range []string{"/bucket?uploads&max-uploads=-1", "/bucket?uploads&max-uploads=many", "/bucket/c?uploadId=" + uploadID + "&max-parts=-1"} {
+		if code := get(standard, target, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", target, code)
+		}
+	}
+}
+
+func TestSpecialCharacterKeys(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-special-character-keys"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	defer ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+
+	keys := []string{
+		"plus+sign.txt",
+		"percent%20literal.txt",
+		"with space.txt",
+		"emoji-😀.txt",
+		"control\x01char.txt",
+	}
+
+	for _, key := range keys {
+		_, err := ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(key),
+		})
+		if err != nil {
+			t.Fatalf("PutObject %q failed: %v", key, err)
+		}
+		defer ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+	}
+
+	t.Run("GetObject", func(t *testing.T) {
+		for _, key := range keys {
+			output, err := ts.client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(bucketName),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				t.Fatalf("GetObject %q failed: %v", key, err)
+			}
+			data, _ := io.ReadAll(output.Body)
+			output.Body.Close()
+			if string(data) != key {
+				t.Errorf("Expected content %q, got %q", key, string(data))
+			}
+		}
+	})
+
+	t.Run("ListObjectsEncodingTypeURL", func(t *testing.T) {
+		output, err := ts.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:       aws.String(bucketName),
+			EncodingType: types.EncodingTypeUrl,
+		})
+		if err != nil {
+			t.Fatalf("ListObjectsV2 failed: %v", err)
+		}
+		if output.EncodingType != types.EncodingTypeUrl {
+			t.Errorf("Expected encoding type url, got %q", output.EncodingType)
+		}
+
+		listed := map[string]bool{}
+		for _, obj := range output.Contents {
+			key, err := s3key.Decode(aws.ToString(obj.Key))
+			if err != nil {
+				t.Fatalf("Failed to decode key %q: %v", aws.ToString(obj.Key), err)
+			}
+			listed[key] = true
+		}
+		for _, key := range keys {
+			if !listed[key] {
+				t.Errorf("Expected key %q in listing, got %v", key, listed)
+			}
+		}
+	})
+
+	t.Run("CopyObject", func(t *testing.T) {
+		for _, key := range keys {
+			dstKey := "copy-" + key
+			_, err := ts.client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(bucketName),
+				Key:        aws.String(dstKey),
+				CopySource: aws.String(bucketName + "/" + url.PathEscape(key)),
+			})
+			if err != nil {
+				t.Fatalf("CopyObject %q failed: %v", key, err)
+			}
+			defer ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(dstKey)})
+		}
+	})
+}
+
+func TestComposeObject(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	for _, key := range []string{"part-1", "part-2"} {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, strings.NewReader(key+"\n"), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	compose := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/test-bucket/composed?compose", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		rec := compose(`<ComposeRequest><Source><Key>part-1</Key></Source><Source><Key>part-2</Key></Source></ComposeRequest>`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		var result ComposeResult
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse compose result: %v", err)
+		}
+		if result.Size != int64(len("part-1\npart-2\n")) {
+			t.Errorf("Unexpected composed size %d", result.Size)
+		}
+
+		reader, _, err := store.GetObject("test-bucket", "composed")
+		if err != nil {
+			t.Fatalf("Failed to get composed object: %v", err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		if string(data) != "part-1\npart-2\n" {
+			t.Errorf("Unexpected composed content %q", data)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"MissingSource", `<ComposeRequest><Source><Key>missing</Key></Source></ComposeRequest>`, http.StatusNotFound, "NoSuchKey"},
+		{"NoSources", `<ComposeRequest></ComposeRequest>`, http.StatusBadRequest, "InvalidArgument"},
+		{"MalformedXML", `<ComposeRequest>`, http.StatusBadRequest, "MalformedXML"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := compose(tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected error code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestCopyObjects(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	for _, bucket := range []string{"test-bucket", "other-bucket"} {
+		if err := store.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := store.PutObject(context.Background(), "other-bucket", key, strings.NewReader("content "+key), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	copyObjects := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/test-bucket?copy", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		rec := copyObjects(`<CopyObjectsRequest>
+			<Object><SourceBucket>other-bucket</SourceBucket><SourceKey>a</SourceKey><Key>copy/a</Key></Object>
+			<Object><SourceBucket>other-bucket</SourceBucket><SourceKey>b</SourceKey><Key>copy/b</Key></Object>
+			<Object><SourceBucket>other-bucket</SourceBucket><SourceKey>missing</SourceKey><Key>copy/missing</Key></Object>
+			<Object><SourceKey>copy/a</SourceKey><Key>copy/again</Key></Object>
+		</CopyObjectsRequest>`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		var result CopyObjectsResult
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse copy result: %v", err)
+		}
+		// The copy from the bucket of the request may run before the copy it reads from
+		if len(result.Copied)+len(result.Errors) != 4 {
+			t.Fatalf("Expected a result for each of the 4 copies, got %+v", result)
+		}
+		copied := map[string]bool{}
+		for _, obj := range result.Copied {
+			copied[obj.Key] = true
+		}
+		if !copied["copy/a"] || !copied["copy/b"] {
+			t.Errorf("Expected copy/a and copy/b to be copied, got %+v", result.Copied)
+		}
+		var missing *CopyError
+		for i := range result.Errors {
+			if result.Errors[i].Key == "copy/missing" {
+				missing = &result.Errors[i]
+			}
+		}
+		if missing == nil || missing.Code != "NoSuchKey" || missing.SourceBucket != "other-bucket" {
+			t.Errorf("Expected a NoSuchKey error for the missing source, got %+v", result.Errors)
+		}
+
+		reader, _, err := store.GetObject("test-bucket", "copy/b")
+		if err != nil {
+			t.Fatalf("Failed to get copied object: %v", err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		if string(data) != "content b" {
+			t.Errorf("Unexpected copied content %q", data)
+		}
+	})
+
+	t.Run("Quiet", func(t *testing.T) {
+		rec := copyObjects(`<CopyObjectsRequest><Quiet>true</Quiet><Object><SourceBucket>other-bucket</SourceBucket><SourceKey>a</SourceKey><Key>quiet</Key></Object></CopyObjectsRequest>`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var result CopyObjectsResult
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse copy result: %v", err)
+		}
+		if len(result.Copied) != 0 || len(result.Errors) != 0 {
+			t.Errorf("Expected an empty quiet result, got %+v", result)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"NoObjects", "/test-bucket?copy", `<CopyObjectsRequest></CopyObjectsRequest>`, http.StatusBadRequest, "InvalidArgument"},
+		{"MalformedXML", "/test-bucket?copy", `<CopyObjectsRequest>`, http.StatusBadRequest, "MalformedXML"},
+		{"NoSuchBucket", "/missing-bucket?copy", `<CopyObjectsRequest><Object><SourceKey>a</SourceKey><Key>b</Key></Object></CopyObjectsRequest>`, http.StatusNotFound, "NoSuchBucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected error code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := store.CreateWORMBucket("worm-bucket", time.Hour); err != nil {
+		t.Fatalf("Failed to create WORM bucket: %v", err)
+	}
+	for _, key := range []string{"logs/a", "logs/b/c", "logs/b/d", "other"} {
+		for _, bucket := range []string{"test-bucket", "worm-bucket"} {
+			if _, err := store.PutObject(context.Background(), bucket, key, strings.NewReader(key), storage.Metadata{}, ""); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+		}
+	}
+
+	type deletePrefixResult struct {
+		Prefix   string                 `xml:"Prefix"`
+		Progress []DeletePrefixProgress `xml:"Progress"`
+		Errors   []DeleteError          `xml:"Error"`
+		Deleted  int64                  `xml:"Deleted"`
+		Failed   int64                  `xml:"Failed"`
+	}
+	deletePrefix := func(target string) (*httptest.ResponseRecorder, deletePrefixResult) {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var result deletePrefixResult
+		if rec.Code == http.StatusOK {
+			if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to parse delete prefix result: %v", err)
+			}
+		}
+		return rec, result
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		rec, result := deletePrefix("/test-bucket?deletePrefix&prefix=logs/")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if result.Prefix != "logs/" || result.Deleted != 3 || result.Failed != 0 {
+			t.Errorf("Unexpected result %+v", result)
+		}
+
+		objects, _, err := store.ListObjects("test-bucket", "", "", "", 0)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		if len(objects) != 1 || objects[0].Key != "other" {
+			t.Errorf("Expected only the key outside the prefix to remain, got %v", objects)
+		}
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		rec, result := deletePrefix("/worm-bucket?deletePrefix&prefix=logs/b/")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if result.Deleted != 0 || result.Failed != 2 {
+			t.Errorf("Expected both objects to fail, got %+v", result)
+		}
+		if len(result.Errors) != 2 || result.Errors[0].Key != "logs/b/c" || result.Errors[0].Code != "AccessDenied" {
+			t.Errorf("Expected AccessDenied errors for the locked objects, got %+v", result.Errors)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+	}{
+		{"MissingPrefix", "/test-bucket?deletePrefix", http.StatusBadRequest, "InvalidArgument"},
+		{"NoSuchBucket", "/missing-bucket?deletePrefix&prefix=logs/", http.StatusNotFound, "NoSuchBucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := deletePrefix(tt.target)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected error code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestGetObjectIfRange(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	content := strings.Repeat("0123456789", 1000)
+	info, err := store.PutObject(context.Background(), "test-bucket", "object.txt", strings.NewReader(content), storage.Metadata{ContentType: "text/plain"}, "")
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	etag := fmt.Sprintf("%q", info.ETag)
+	lastModified := info.ModTime.UTC().Format(http.TimeFormat)
+	earlier := info.ModTime.Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"CurrentETag", etag, http.StatusPartialContent, content[10:20]},
+		{"ChangedETag", `"changed"`, http.StatusOK, content},
+		{"WeakETag", "W/" + etag, http.StatusOK, content},
+		{"CurrentDate", lastModified, http.StatusPartialContent, content[10:20]},
+		{"EarlierDate", earlier, http.StatusOK, content},
+	}
+
+	// Range requests are never compressed, so the fallback to the full body is the stored object
+	for _, handler := range []http.Handler{NewS3Handler(store), NewS3Handler(store, WithCompression(true))} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/test-bucket/object.txt", nil)
+				req.Header.Set("Range", "bytes=10-19")
+				req.Header.Set("If-Range", tt.ifRange)
+				req.Header.Set("Accept-Encoding", "gzip")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.status {
+					t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+				}
+				if rec.Body.String() != tt.body {
+					t.Errorf("Expected a body of %d bytes, got %d bytes", len(tt.body), rec.Body.Len())
+				}
+				if rec.Header().Get("ETag") != etag {
+					t.Errorf("Expected ETag %s, got %s", etag, rec.Header().Get("ETag"))
+				}
+			})
+		}
+	}
+}
+
+func TestListObjectsV2PaginationWithDelimiter(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-pagination-delimiter"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	keys := []string{"a/1", "b", "c/1", "d"}
+	for _, key := range keys {
+		_, err := ts.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   strings.NewReader("data"),
+		})
+		if err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+	defer func() {
+		for _, key := range keys {
+			ts.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(key)})
+		}
+		ts.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+	}()
+
+	// Every key and common prefix is listed once, in order, across pages
+	var listed []string
+	var token *string
+	for page := 0; ; page++ {
+		if page > len(keys) {
+			t.Fatal("Too many pages")
+		}
+		output, err := ts.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucketName),
+			Delimiter:         aws.String("/"),
+			MaxKeys:           aws.Int32(1),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			t.Fatalf("ListObjectsV2 failed: %v", err)
+		}
+		for _, cp := range output.CommonPrefixes {
+			listed = append(listed, aws.ToString(cp.Prefix))
+		}
+		for _, obj := range output.Contents {
+			listed = append(listed, aws.ToString(obj.Key))
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			break
+		}
+		token = output.NextContinuationToken
+	}
+
+	sort.Strings(listed)
+	if want := []string{"a/", "b", "c/", "d"}; strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, listed)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	bucketName := "test-bucket-conditional"
+
+	_, err := ts.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	put := func(key, content string, mutate func(*s3.PutObjectInput)) (*s3.PutObjectOutput, error) {
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(content),
+		}
+		mutate(input)
+		return ts.client.PutObject(ctx, input)
+	}
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		ifNoneMatch := func(input *s3.PutObjectInput) { input.IfNoneMatch = aws.String("*") }
+		if _, err := put("lock", "first", ifNoneMatch); err != nil {
+			t.Fatalf("Expected the first conditional write to succeed, got %v", err)
+		}
+		_, err := put("lock", "second", ifNoneMatch)
+		if err == nil || !strings.Contains(err.Error(), "PreconditionFailed") {
+			t.Fatalf("Expected PreconditionFailed, got %v", err)
+		}
+
+		output, err := ts.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("lock"),
+		})
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		data, _ := io.ReadAll(output.Body)
+		output.Body.Close()
+		if string(data) != "first" {
+			t.Errorf("Expected the object to keep the first content, got %q", data)
+		}
+	})
+
+	t.Run("IfMatch", func(t *testing.T) {
+		output, err := put("versioned", "v1", func(*s3.PutObjectInput) {})
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if _, err := put("versioned", "v2", func(input *s3.PutObjectInput) { input.IfMatch = aws.String(`"other"`) }); err == nil || !strings.Contains(err.Error(), "PreconditionFailed") {
+			t.Fatalf("Expected PreconditionFailed for another ETag, got %v", err)
+		}
+		if _, err := put("versioned", "v2", func(input *s3.PutObjectInput) { input.IfMatch = output.ETag }); err != nil {
+			t.Fatalf("Expected IfMatch with the current ETag to succeed, got %v", err)
+		}
+		if _, err := put("missing", "v1", func(input *s3.PutObjectInput) { input.IfMatch = output.ETag }); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+			t.Fatalf("Expected NoSuchKey for IfMatch on a missing object, got %v", err)
+		}
+	})
+
+	t.Run("CompleteMultipartUpload", func(t *testing.T) {
+		upload, err := ts.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("lock"),
+		})
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		part, err := ts.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(bucketName),
+			Key:        aws.String("lock"),
+			UploadId:   upload.UploadId,
+			PartNumber: aws.Int32(1),
+			Body:       strings.NewReader("multipart"),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		_, err = ts.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String("lock"),
+			UploadId:    upload.UploadId,
+			IfNoneMatch: aws.String("*"),
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: []types.CompletedPart{{PartNumber: aws.Int32(1), ETag: part.ETag}},
+			},
+		})
+		if err == nil || !strings.Contains(err.Error(), "PreconditionFailed") {
+			t.Fatalf("Expected PreconditionFailed, got %v", err)
+		}
+		ts.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucketName),
+			Key:      aws.String("lock"),
+			UploadId: upload.UploadId,
+		})
+	})
+
+	t.Run("UnsupportedIfNoneMatch", func(t *testing.T) {
+		_, err := put("lock", "second", func(input *s3.PutObjectInput) { input.IfNoneMatch = aws.String(`"etag"`) })
+		if err == nil || !strings.Contains(err.Error(), "NotImplemented") {
+			t.Fatalf("Expected NotImplemented, got %v", err)
+		}
+	})
+}
+
+func TestContentMD5(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	md5Of := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	serve := func(method, target, body, contentMD5 string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-MD5", contentMD5)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		contentMD5 string
+		status     int
+		code       string
+	}{
+		{"PutObjectValid", http.MethodPut, "/test-bucket/valid", "content", md5Of("content"), http.StatusOK, ""},
+		{"PutObjectMismatch", http.MethodPut, "/test-bucket/mismatch", "content", md5Of("other"), http.StatusBadRequest, "BadDigest"},
+		{"PutObjectInvalid", http.MethodPut, "/test-bucket/invalid", "content", "not-an-md5", http.StatusBadRequest, "InvalidDigest"},
+		{"DeleteObjectsValid", http.MethodPost, "/test-bucket?delete", "<Delete><Object><Key>valid</Key></Object></Delete>", md5Of("<Delete><Object><Key>valid</Key></Object></Delete>"), http.StatusOK, ""},
+		{"DeleteObjectsMismatch", http.MethodPost, "/test-bucket?delete", "<Delete><Object><Key>valid</Key></Object></Delete>", md5Of("<Delete></Delete>"), http.StatusBadRequest, "BadDigest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.target, tt.body, tt.contentMD5)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected error code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+
+	if _, _, err := store.GetObject("test-bucket", "mismatch"); err != storage.ErrObjectNotFound {
+		t.Errorf("Expected an object with a mismatched Content-MD5 not to be stored, got %v", err)
+	}
+}
+
+func FuzzDeleteObjects(f *testing.F) {
+	for _, seed := range []string{
+		`<Delete><Object><Key>key</Key></Object><Quiet>true</Quiet></Delete>`,
+		`<Delete><Object><Key>../escape</Key></Object><Object><Key></Key></Object></Delete>`,
+		`<Delete><Object><Key>a</Key><VersionId>1</VersionId></Object>`,
+		`<!DOCTYPE x [<!ENTITY a "b">]><Delete>&a;</Delete>`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	store, err := storage.NewStorage(f.TempDir())
+	if err != nil {
+		f.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("fuzz-bucket"); err != nil {
+		f.Fatalf("Failed to create bucket: %v", err)
+	}
+	handler := NewS3Handler(store)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		store.PutObject(context.Background(), "fuzz-bucket", "key", strings.NewReader("data"), storage.Metadata{}, "")
+		req := httptest.NewRequest(http.MethodPost, "/fuzz-bucket?delete", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusInternalServerError {
+			t.Fatalf("DeleteObjects with %q failed with status %d: %s", body, rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// defaultOwnerDisplayName is the display name of the owner of the root namespace
+const defaultOwnerDisplayName = "s3d-owner"
+
+// WithAccount sets the account owning the buckets served, the owner of the root namespace if empty
+func WithAccount(account string) Option {
+	return func(h *S3Handler) {
+		h.account = account
+	}
+}
+
+// canonicalID derives the stable canonical user ID of a principal, 64 hex characters like those of S3
+// The kind keeps accounts and access keys of the same name apart
+func canonicalID(kind, name string) string {
+	sum := sha256.Sum256([]byte(kind + ":" + name))
+	return hex.EncodeToString(sum[:])
+}
+
+// owner returns the owner of the buckets and objects served, which is the account of the namespace
+func (s *S3Handler) owner() Owner {
+	if s.account == "" {
+		return Owner{
+			ID:          canonicalID("account", ""),
+			DisplayName: defaultOwnerDisplayName,
+		}
+	}
+	return Owner{
+		ID:          canonicalID("account", s.account),
+		DisplayName: s.account,
+	}
+}
+
+// uploadInitiator returns the initiator of an upload initiated with the access key initiator,
+// the owner for anonymous uploads
+func (s *S3Handler) uploadInitiator(initiator string) Owner {
+	if initiator == "" {
+		return s.owner()
+	}
+	return Owner{
+		ID:          canonicalID("key", initiator),
+		DisplayName: initiator,
+	}
+}
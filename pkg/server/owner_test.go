@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestCanonicalID(t *testing.T) {
+	id := canonicalID("account", "team-a")
+	if !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(id) {
+		t.Errorf("Expected 64 hex characters, got %q", id)
+	}
+	if id != canonicalID("account", "team-a") {
+		t.Error("Expected the canonical ID to be stable")
+	}
+	if id == canonicalID("key", "team-a") || id == canonicalID("account", "team-b") {
+		t.Error("Expected distinct principals to have distinct canonical IDs")
+	}
+}
+
+func TestOwner(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "bucket", "object", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUploadBy("bucket", "upload", storage.Metadata{}, "", "member-key")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	authenticator := auth.NewAWS4Authenticator()
+	authenticator.AddCredentials("member-key", "secret")
+	handler := authenticator.BasicAuthMiddleware("s3d", NewS3Handler(store, WithAccount("team-a")))
+	get := func(target string, v any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetBasicAuth("member-key", "secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected GET %s to succeed, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		if err := xml.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to parse the response of %s: %v", target, err)
+		}
+	}
+	account := Owner{ID: canonicalID("account", "team-a"), DisplayName: "team-a"}
+
+	var buckets ListAllMyBucketsResult
+	get("/", &buckets)
+	if buckets.Owner != account {
+		t.Errorf("Expected the account to own the buckets, got %+v", buckets.Owner)
+	}
+
+	var objects struct {
+		Contents []Contents `xml:"Contents"`
+	}
+	get("/bucket?list-type=2&fetch-owner=true", &objects)
+	if len(objects.Contents) != 1 || objects.Contents[0].Owner == nil || *objects.Contents[0].Owner != account {
+		t.Errorf("Expected the account to own the objects, got %+v", objects.Contents)
+	}
+
+	var uploads ListMultipartUploadsResult
+	get("/bucket?uploads", &uploads)
+	member := Owner{ID: canonicalID("key", "member-key"), DisplayName: "member-key"}
+	if len(uploads.Uploads) != 1 || uploads.Uploads[0].Initiator != member || uploads.Uploads[0].Owner != account {
+		t.Errorf("Expected the access key to initiate the upload owned by the account, got %+v", uploads.Uploads)
+	}
+
+	var parts ListPartsResult
+	get("/bucket/upload?uploadId="+uploadID, &parts)
+	if parts.Initiator != member || parts.Owner != account {
+		t.Errorf("Expected the access key to initiate the upload owned by the account, got %+v and %+v", parts.Initiator, parts.Owner)
+	}
+
+	root := NewS3Handler(store)
+	rec := httptest.NewRecorder()
+	root.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := "<ID>" + canonicalID("account", "") + "</ID><DisplayName>" + defaultOwnerDisplayName + "</DisplayName>"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Expected the owner of the root namespace, got %s", rec.Body.String())
+	}
+}
+
+func TestAccountWebsite(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// The website endpoint serves the root namespace, so accounts cannot configure websites
+	body := `<WebsiteConfiguration><IndexDocument><Suffix>index.html</Suffix></IndexDocument></WebsiteConfiguration>`
+	rec := httptest.NewRecorder()
+	NewS3Handler(store, WithAccount("team-a")).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/bucket?website", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "InvalidRequest") {
+		t.Fatalf("Expected the website configuration to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := store.GetBucketWebsite("bucket"); err != storage.ErrWebsiteNotFound {
+		t.Errorf("Expected no website configuration, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Profile toggles behaviors in which S3 providers differ, so that applications can be tested
+// against the quirks of the provider they run on with a single server
+// The zero Profile is the behavior of s3d itself
+type Profile struct {
+	// HexETags formats ETags as the lowercase hex digits AWS and MinIO ETags are made of,
+	// instead of the URL-safe base64 of the SHA-256 of the object that s3d stores
+	HexETags bool
+	// StrictStorageClass rejects writes with a storage class S3 does not know with InvalidStorageClass
+	StrictStorageClass bool
+	// RejectEncryption rejects writes asking for server-side encryption, which s3d does not do,
+	// with NotImplemented like MinIO without a KMS, instead of storing them unencrypted
+	RejectEncryption bool
+	// UnsupportedConfigurations answers GETs of analytics, intelligent-tiering, inventory and metrics
+	// configurations with NotImplemented like MinIO, instead of NoSuchConfiguration
+	UnsupportedConfigurations bool
+	// EmptyConfigurations answers GETs of unconfigured bucket configurations with empty ones,
+	// instead of the errors S3 returns to mean they are not set, for clients failing on them
+	EmptyConfigurations bool
+}
+
+// Profiles are the named profiles, "s3d" being the behavior of s3d itself
+var Profiles = map[string]Profile{
+	"s3d":          {},
+	"aws":          {HexETags: true, StrictStorageClass: true},
+	"minio-strict": {HexETags: true, StrictStorageClass: true, RejectEncryption: true, UnsupportedConfigurations: true},
+	"lenient":      {EmptyConfigurations: true},
+}
+
+// ParseProfile returns the named profile, that of s3d itself if name is empty
+func ParseProfile(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(Profiles))
+		for name := range Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown compatibility profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// WithProfile sets the provider whose quirks the handler emulates
+func WithProfile(profile Profile) Option {
+	return func(h *S3Handler) {
+		h.profile = profile
+	}
+}
+
+// storageClasses are the storage classes S3 knows
+var storageClasses = []string{
+	"DEEP_ARCHIVE",
+	"EXPRESS_ONEZONE",
+	"GLACIER",
+	"GLACIER_IR",
+	"INTELLIGENT_TIERING",
+	"ONEZONE_IA",
+	"OUTPOSTS",
+	"REDUCED_REDUNDANCY",
+	"SNOW",
+	"STANDARD",
+	"STANDARD_IA",
+}
+
+// encryptionHeaders are the headers asking for server-side encryption of the data written
+var encryptionHeaders = []string{
+	"x-amz-server-side-encryption",
+	"x-amz-server-side-encryption-customer-algorithm",
+}
+
+// checkProfileHeaders rejects the headers of an object write the profile is strict about
+// It reports false if the request was answered with an error
+func (s *S3Handler) checkProfileHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		return true
+	}
+	if s.profile.StrictStorageClass {
+		if class := r.Header.Get("x-amz-storage-class"); class != "" && !slices.Contains(storageClasses, class) {
+			s.errorResponse(w, r, "InvalidStorageClass", "The storage class you specified is not valid", http.StatusBadRequest)
+			return false
+		}
+	}
+	if s.profile.RejectEncryption {
+		for _, header := range encryptionHeaders {
+			if r.Header.Get(header) != "" {
+				s.errorResponse(w, r, "NotImplemented", "Server side encryption specified but KMS is not configured", http.StatusNotImplemented)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// etag returns the quoted ETag sent to clients for the ETag of an object as stored
+func (s *S3Handler) etag(etag string) string {
+	if s.profile.HexETags {
+		if sum, err := base64.URLEncoding.DecodeString(etag); err == nil {
+			etag = hex.EncodeToString(sum)
+		}
+	}
+	return fmt.Sprintf("%q", etag)
+}
+
+// storedETag returns the ETag of an object as stored for an ETag sent by a client, quoted or not
+func (s *S3Handler) storedETag(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if s.profile.HexETags {
+		if sum, err := hex.DecodeString(etag); err == nil && len(sum) == sha256.Size {
+			return base64.URLEncoding.EncodeToString(sum)
+		}
+	}
+	return etag
+}
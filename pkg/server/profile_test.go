@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestParseProfile(t *testing.T) {
+	if profile, err := ParseProfile(""); err != nil || profile != (Profile{}) {
+		t.Errorf("Expected the profile of s3d by default, got %+v (%v)", profile, err)
+	}
+	if profile, err := ParseProfile("aws"); err != nil || !profile.HexETags {
+		t.Errorf("Expected the aws profile, got %+v (%v)", profile, err)
+	}
+	if _, err := ParseProfile("gcs"); err == nil || !strings.Contains(err.Error(), "minio-strict") {
+		t.Errorf("Expected an unknown profile to fail listing the known ones, got %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	handlers := map[string]http.Handler{}
+	for name, profile := range Profiles {
+		handlers[name] = NewS3Handler(store, WithProfile(profile))
+	}
+	serve := func(profile, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		handlers[profile].ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("HexETags", func(t *testing.T) {
+		hexETag := regexp.MustCompile(`^"[0-9a-f]{64}"$`)
+		rec := serve("aws", http.MethodPut, "/bucket/object", "data", nil)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || !hexETag.MatchString(etag) {
+			t.Fatalf("Expected a hex ETag, got %d with %q", rec.Code, etag)
+		}
+		if native := serve("s3d", http.MethodHead, "/bucket/object", "", nil).Header().Get("ETag"); native == etag || hexETag.MatchString(native) {
+			t.Errorf("Expected the profile of s3d to keep its own ETags, got %q", native)
+		}
+		if body := serve("aws", http.MethodGet, "/bucket?list-type=2", "", nil).Body.String(); !strings.Contains(body, "<ETag>&#34;"+strings.Trim(etag, `"`)+"&#34;</ETag>") {
+			t.Errorf("Expected the listing to carry the hex ETag, got %s", body)
+		}
+
+		// ETags sent back by clients are those they were given
+		if rec := serve("aws", http.MethodGet, "/bucket/object", "", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+			t.Errorf("Expected a conditional read on the hex ETag to succeed, got %d", rec.Code)
+		}
+		if rec := serve("aws", http.MethodPut, "/bucket/object", "new data", map[string]string{"If-Match": etag}); rec.Code != http.StatusOK {
+			t.Errorf("Expected a conditional write on the hex ETag to succeed, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve("aws", http.MethodPut, "/bucket/object", "newer data", map[string]string{"If-Match": etag}); rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected a conditional write on an outdated ETag to fail, got %d", rec.Code)
+		}
+
+		var initiated InitiateMultipartUploadResult
+		if err := xml.Unmarshal(serve("aws", http.MethodPost, "/bucket/upload?uploads", "", nil).Body.Bytes(), &initiated); err != nil {
+			t.Fatalf("Failed to parse the initiated upload: %v", err)
+		}
+		partETag := serve("aws", http.MethodPut, "/bucket/upload?partNumber=1&uploadId="+initiated.UploadId, "part", nil).Header().Get("ETag")
+		if !hexETag.MatchString(partETag) {
+			t.Errorf("Expected a hex part ETag, got %q", partETag)
+		}
+		complete := fmt.Sprintf("<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>", partETag)
+		if rec := serve("aws", http.MethodPost, "/bucket/upload?uploadId="+initiated.UploadId, complete, nil); rec.Code != http.StatusOK {
+			t.Errorf("Expected the upload to complete with the hex part ETags, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("StrictHeaders", func(t *testing.T) {
+		tests := []struct {
+			profile string
+			header  map[string]string
+			status  int
+		}{
+			{"s3d", map[string]string{"x-amz-storage-class": "COLD"}, http.StatusOK},
+			{"aws", map[string]string{"x-amz-storage-class": "COLD"}, http.StatusBadRequest},
+			{"aws", map[string]string{"x-amz-storage-class": "GLACIER_IR"}, http.StatusOK},
+			{"aws", map[string]string{"x-amz-server-side-encryption": "AES256"}, http.StatusOK},
+			{"minio-strict", map[string]string{"x-amz-server-side-encryption": "aws:kms"}, http.StatusNotImplemented},
+			{"minio-strict", map[string]string{"x-amz-server-side-encryption-customer-algorithm": "AES256"}, http.StatusNotImplemented},
+			{"lenient", map[string]string{"x-amz-storage-class": "COLD"}, http.StatusOK},
+		}
+		for _, tt := range tests {
+			if rec := serve(tt.profile, http.MethodPut, "/bucket/headers", "data", tt.header); rec.Code != tt.status {
+				t.Errorf("Expected a write with %v to return %d with the %s profile, got %d: %s", tt.header, tt.status, tt.profile, rec.Code, rec.Body.String())
+			}
+		}
+	})
+
+	t.Run("Configurations", func(t *testing.T) {
+		tests := []struct {
+			profile, target string
+			status          int
+			want            string
+		}{
+			{"aws", "/bucket?cors", http.StatusNotFound, "<Code>NoSuchCORSConfiguration</Code>"},
+			{"lenient", "/bucket?cors", http.StatusOK, "<CORSConfiguration></CORSConfiguration>"},
+			{"lenient", "/bucket?tagging", http.StatusOK, "<Tagging></Tagging>"},
+			{"lenient", "/bucket?policy", http.StatusNotFound, "<Code>NoSuchBucketPolicy</Code>"},
+			{"lenient", "/bucket?metrics&id=daily", http.StatusNotFound, "<Code>NoSuchConfiguration</Code>"},
+			{"aws", "/bucket?metrics&id=daily", http.StatusNotFound, "<Code>NoSuchConfiguration</Code>"},
+			{"minio-strict", "/bucket?metrics&id=daily", http.StatusNotImplemented, "<Code>NotImplemented</Code>"},
+			{"minio-strict", "/bucket?lifecycle", http.StatusNotFound, "<Code>NoSuchLifecycleConfiguration</Code>"},
+			{"lenient", "/missing?cors", http.StatusNotFound, "<Code>NoSuchBucket</Code>"},
+		}
+		for _, tt := range tests {
+			rec := serve(tt.profile, http.MethodGet, tt.target, "", nil)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("Expected GET %s to return %d with %s with the %s profile, got %d: %s", tt.target, tt.status, tt.want, tt.profile, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
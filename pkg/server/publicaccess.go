@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// maxPublicAccessBody is the largest ACL or bucket policy body inspected for public grants,
+// larger ones are rejected by buckets blocking them
+const maxPublicAccessBody = 64 << 10
+
+// publicGroups are the grantee groups making an ACL public
+var publicGroups = []string{
+	"acs.amazonaws.com/groups/global/AllUsers",
+	"acs.amazonaws.com/groups/global/AuthenticatedUsers",
+}
+
+// checkPublicAccessBlock enforces the public access block of the bucket, if any, on every request to it:
+// BlockPublicAcls rejects requests granting a public ACL, BlockPublicPolicy requests setting a public
+// bucket policy, and IgnorePublicAcls and RestrictPublicBuckets reject anonymous requests,
+// which only reach the server without credentials, where everyone has access,
+// apart from the public access block configuration itself so that it can be removed
+// Returns the request to handle, with the body it read restored, and false if an error response has been written
+func (s *S3Handler) checkPublicAccessBlock(w http.ResponseWriter, r *http.Request, bucket string) (*http.Request, bool) {
+	query := r.URL.Query()
+	grantsACL := r.Method == http.MethodPut && query.Has("acl")
+	setsPolicy := r.Method == http.MethodPut && query.Has("policy")
+	anonymous := auth.AccessKeyIDFromContext(r.Context()) == "" && !query.Has("publicAccessBlock")
+	// The configuration is only read for requests it may deny
+	if !grantsACL && !setsPolicy && !anonymous && !hasPublicACL(r) {
+		return r, true
+	}
+
+	config, err := s.storage.GetPublicAccessBlock(bucket)
+	switch err {
+	case nil:
+	case storage.ErrPublicAccessBlockNotFound, storage.ErrBucketNotFound:
+		return r, true
+	default:
+		s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return r, false
+	}
+
+	var body []byte
+	if (config.BlockPublicAcls && grantsACL) || (config.BlockPublicPolicy && setsPolicy) {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxPublicAccessBody+1))
+		if err != nil {
+			s.errorResponse(w, r, "IncompleteBody", "The request body could not be read", http.StatusBadRequest)
+			return r, false
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	switch {
+	case config.BlockPublicAcls && (hasPublicACL(r) || grantsACL && hasPublicGrant(body)):
+	case config.BlockPublicPolicy && setsPolicy && isPublicPolicy(body):
+	case (config.IgnorePublicAcls || config.RestrictPublicBuckets) && anonymous:
+	default:
+		return r, true
+	}
+	s.errorResponse(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+	return r, false
+}
+
+// hasPublicACL reports whether the request grants access to everyone or to all authenticated users,
+// either through a canned ACL or through explicit grant headers
+func hasPublicACL(r *http.Request) bool {
+	switch r.Header.Get("x-amz-acl") {
+	case "public-read", "public-read-write", "authenticated-read":
+		return true
+	}
+	for _, header := range []string{
+		"x-amz-grant-read",
+		"x-amz-grant-write",
+		"x-amz-grant-read-acp",
+		"x-amz-grant-write-acp",
+		"x-amz-grant-full-control",
+	} {
+		if hasPublicGrant([]byte(r.Header.Get(header))) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPublicGrant reports whether grants, grant headers or an AccessControlPolicy document,
+// name a public grantee group, a body too large to inspect counting as public
+func hasPublicGrant(grants []byte) bool {
+	if len(grants) > maxPublicAccessBody {
+		return true
+	}
+	for _, group := range publicGroups {
+		if bytes.Contains(grants, []byte(group)) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyStatement is the part of a bucket policy statement deciding whether it is public
+type policyStatement struct {
+	Effect    string
+	Principal any
+	Condition map[string]any
+}
+
+// isPublicPolicy reports whether a bucket policy allows everyone without conditions,
+// a body too large to inspect counting as public
+func isPublicPolicy(policy []byte) bool {
+	if len(policy) > maxPublicAccessBody {
+		return true
+	}
+	var document struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal(policy, &document); err != nil {
+		return false
+	}
+	// Statement is either a single statement or a list of them
+	var statements []policyStatement
+	if err := json.Unmarshal(document.Statement, &statements); err != nil {
+		var statement policyStatement
+		if err := json.Unmarshal(document.Statement, &statement); err != nil {
+			return false
+		}
+		statements = []policyStatement{statement}
+	}
+	for _, statement := range statements {
+		if strings.EqualFold(statement.Effect, "Allow") && len(statement.Condition) == 0 && isPublicPrincipal(statement.Principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicPrincipal reports whether a policy principal, "*" or {"AWS": "*"}, matches everyone
+func isPublicPrincipal(principal any) bool {
+	switch principal := principal.(type) {
+	case string:
+		return principal == "*"
+	case map[string]any:
+		switch aws := principal["AWS"].(type) {
+		case string:
+			return aws == "*"
+		case []any:
+			for _, item := range aws {
+				if item == "*" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
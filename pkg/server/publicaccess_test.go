@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/auth"
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestCheckPublicAccessBlock(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	for _, bucket := range []string{"data", "broken"} {
+		if err := store.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+	}
+	if _, err := store.PutObject(context.Background(), "data", "object", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	// Bucket metadata that cannot be read
+	if err := os.WriteFile(filepath.Join(dir, ".buckets", "broken", "meta"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without credentials every request is anonymous
+	anonymous := NewS3Handler(store)
+	authenticator := auth.NewAWS4Authenticator()
+	authenticator.AddCredentials("owner-key", "secret")
+	signed := authenticator.BasicAuthMiddleware("s3d", anonymous)
+
+	const publicPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::data/*"}]}`
+	const conditionalPolicy = `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":"s3:GetObject","Resource":"arn:aws:s3:::data/*","Condition":{"IpAddress":{"aws:SourceIp":"10.0.0.0/8"}}}}`
+	const publicACL = `<AccessControlPolicy><AccessControlList><Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>http://acs.amazonaws.com/groups/global/AllUsers</URI></Grantee><Permission>READ</Permission></Grant></AccessControlList></AccessControlPolicy>`
+
+	tests := []struct {
+		name    string
+		config  storage.PublicAccessBlock
+		signed  bool
+		method  string
+		target  string
+		header  [2]string
+		body    string
+		want    int
+		wantErr string
+	}{
+		{name: "NotConfigured", method: http.MethodGet, target: "/data/object", want: http.StatusOK},
+		{name: "UnreadableConfiguration", method: http.MethodGet, target: "/broken", want: http.StatusInternalServerError, wantErr: "InternalError"},
+
+		{name: "PublicCannedACL", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data/new", header: [2]string{"x-amz-acl", "public-read"}, want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "PrivateCannedACL", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data/new", header: [2]string{"x-amz-acl", "private"}, want: http.StatusOK},
+		{name: "PutObjectAclCanned", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data/object?acl", header: [2]string{"x-amz-acl", "authenticated-read"}, want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "PutBucketAclGrant", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data?acl", header: [2]string{"x-amz-grant-read", `uri="http://acs.amazonaws.com/groups/global/AllUsers"`}, want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "PutBucketAclBody", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data?acl", body: publicACL, want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "PutBucketAclPrivate", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data?acl", header: [2]string{"x-amz-acl", "private"}, want: http.StatusNotImplemented},
+		{name: "PublicACLAllowed", config: storage.PublicAccessBlock{BlockPublicPolicy: true}, method: http.MethodPut, target: "/data/object?acl", body: publicACL, want: http.StatusNotImplemented},
+
+		{name: "PublicPolicy", config: storage.PublicAccessBlock{BlockPublicPolicy: true}, method: http.MethodPut, target: "/data?policy", body: publicPolicy, want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "ConditionalPolicy", config: storage.PublicAccessBlock{BlockPublicPolicy: true}, method: http.MethodPut, target: "/data?policy", body: conditionalPolicy, want: http.StatusNotImplemented},
+		{name: "PublicPolicyAllowed", config: storage.PublicAccessBlock{BlockPublicAcls: true}, method: http.MethodPut, target: "/data?policy", body: publicPolicy, want: http.StatusNotImplemented},
+
+		{name: "IgnorePublicAclsAnonymous", config: storage.PublicAccessBlock{IgnorePublicAcls: true}, method: http.MethodGet, target: "/data/object", want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "IgnorePublicAclsSigned", config: storage.PublicAccessBlock{IgnorePublicAcls: true}, signed: true, method: http.MethodGet, target: "/data/object", want: http.StatusOK},
+		{name: "IgnorePublicAclsConfiguration", config: storage.PublicAccessBlock{IgnorePublicAcls: true}, method: http.MethodGet, target: "/data?publicAccessBlock", want: http.StatusOK},
+
+		{name: "RestrictPublicBucketsAnonymous", config: storage.PublicAccessBlock{RestrictPublicBuckets: true}, method: http.MethodGet, target: "/data?list-type=2", want: http.StatusForbidden, wantErr: "AccessDenied"},
+		{name: "RestrictPublicBucketsSigned", config: storage.PublicAccessBlock{RestrictPublicBuckets: true}, signed: true, method: http.MethodGet, target: "/data?list-type=2", want: http.StatusOK},
+		{name: "RestrictPublicBucketsConfiguration", config: storage.PublicAccessBlock{RestrictPublicBuckets: true}, method: http.MethodDelete, target: "/data?publicAccessBlock", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config == (storage.PublicAccessBlock{}) {
+				store.DeletePublicAccessBlock("data")
+			} else if err := store.PutPublicAccessBlock("data", tt.config); err != nil {
+				t.Fatalf("PutPublicAccessBlock failed: %v", err)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.header[0] != "" {
+				req.Header.Set(tt.header[0], tt.header[1])
+			}
+			var handler http.Handler = anonymous
+			if tt.signed {
+				req.SetBasicAuth("owner-key", "secret")
+				handler = signed
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.wantErr != "" && !strings.Contains(rec.Body.String(), "<Code>"+tt.wantErr+"</Code>") {
+				t.Errorf("Expected %s, got %s", tt.wantErr, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestIsPublicPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   bool
+	}{
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject"}]}`, true},
+		{`{"Statement":{"Effect":"Allow","Principal":{"AWS":"*"},"Action":"s3:*"}}`, true},
+		{`{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*"},{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::111122223333:root","*"]},"Action":"s3:GetObject"}]}`, true},
+		{`{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*"}]}`, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:root"},"Action":"s3:GetObject"}]}`, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Condition":{"StringEquals":{"aws:SourceVpce":"vpce-1a2b3c4d"}}}]}`, false},
+		{`not a policy`, false},
+		{strings.Repeat(" ", maxPublicAccessBody+1), true},
+	}
+	for _, tt := range tests {
+		if got := isPublicPolicy([]byte(tt.policy)); got != tt.want {
+			t.Errorf("isPublicPolicy(%.60q) = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// WithHeavyOperationQueue bounds the disk-bound operations served at once to workers,
+// such as completing multipart uploads, copies and bucket listings
+// Up to queue more requests wait for a worker, further ones are rejected with SlowDown
+// so that clients back off instead of piling up goroutines thrashing the disk
+func WithHeavyOperationQueue(workers, queue int) Option {
+	return func(h *S3Handler) {
+		if workers > 0 {
+			h.heavy = newHeavyQueue(workers, queue)
+		}
+	}
+}
+
+// heavyQueue is a bounded pool of workers with a bounded queue in front of it
+type heavyQueue struct {
+	admitted chan struct{} // requests running or waiting
+	workers  chan struct{} // requests running
+}
+
+// newHeavyQueue creates a pool of workers with room for queue waiting requests
+func newHeavyQueue(workers, queue int) *heavyQueue {
+	return &heavyQueue{
+		admitted: make(chan struct{}, workers+max(queue, 0)),
+		workers:  make(chan struct{}, workers),
+	}
+}
+
+// acquire waits for a worker, reporting false if the queue is full or ctx is done first
+func (q *heavyQueue) acquire(ctx context.Context) bool {
+	select {
+	case q.admitted <- struct{}{}:
+	default:
+		return false
+	}
+	select {
+	case q.workers <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		<-q.admitted
+		return false
+	}
+}
+
+// release frees the worker of an acquired request
+func (q *heavyQueue) release() {
+	<-q.workers
+	<-q.admitted
+}
+
+// bucketConfigSubresources are the bucket subresources whose requests only touch the bucket metadata
+var bucketConfigSubresources = []string{"location", "ownershipControls", "publicAccessBlock", "website", "accelerate", "requestPayment", "freeze", "default-ttl", "trash", "lock"}
+
+// isHeavyRequest reports whether r is a disk-bound operation going through the heavy operation queue
+func isHeavyRequest(r *http.Request, key string) bool {
+	query := r.URL.Query()
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			// Listings of objects, uploads and audit logs walk the bucket, and so do prefix summaries
+			for _, name := range bucketConfigSubresources {
+				if query.Has(name) {
+					return false
+				}
+			}
+			return true
+		case http.MethodPost:
+			return query.Has("delete") || query.Has("copy") || query.Has("deletePrefix")
+		case http.MethodPut:
+			// Extracting an archive writes an object per file
+			return query.Has("extract")
+		}
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		return query.Has("uploadId") || query.Has("compose")
+	case http.MethodPut:
+		return r.Header.Get("x-amz-copy-source") != ""
+	}
+	return false
+}
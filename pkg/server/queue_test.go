@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestHeavyQueue(t *testing.T) {
+	q := newHeavyQueue(1, 1)
+	if !q.acquire(context.Background()) {
+		t.Fatal("Expected the first request to get a worker")
+	}
+
+	// The second request waits in the queue until the worker is released
+	acquired := make(chan bool)
+	go func() {
+		acquired <- q.acquire(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if q.acquire(context.Background()) {
+		t.Error("Expected a request beyond the queue to be rejected")
+	}
+
+	q.release()
+	if !<-acquired {
+		t.Error("Expected the queued request to get the worker")
+	}
+
+	// A request whose client goes away leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if q.acquire(ctx) {
+		t.Error("Expected a cancelled request not to get a worker")
+	}
+	q.release()
+	if !q.acquire(context.Background()) {
+		t.Error("Expected the worker to be free again")
+	}
+}
+
+func TestHeavyOperationQueue(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "s3d-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := storage.NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "key", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	handler := NewS3Handler(store, WithHeavyOperationQueue(1, 0))
+
+	// Saturate the only worker
+	if !handler.heavy.acquire(context.Background()) {
+		t.Fatal("Expected to get the worker")
+	}
+
+	tests := []struct {
+		method     string
+		target     string
+		copySource string
+		want       int
+	}{
+		{http.MethodGet, "/test-bucket", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/test-bucket?uploads", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test-bucket?delete", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test-bucket?copy", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test-bucket?deletePrefix&prefix=logs/", "", http.StatusServiceUnavailable},
+		{http.MethodPut, "/test-bucket?extract", "", http.StatusServiceUnavailable},
+		{http.MethodPut, "/test-bucket/copy", "/test-bucket/key", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test-bucket/key?uploadId=upload", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/test-bucket?freeze", "", http.StatusOK},
+		{http.MethodGet, "/test-bucket/key", "", http.StatusOK},
+		{http.MethodPut, "/test-bucket/other", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("data"))
+		if tt.copySource != "" {
+			req.Header.Set("x-amz-copy-source", tt.copySource)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.want, rec.Code)
+		}
+		if tt.want == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "SlowDown") {
+			t.Errorf("%s %s: expected a SlowDown error, got %s", tt.method, tt.target, rec.Body.String())
+		}
+	}
+
+	handler.heavy.release()
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the listing to be served once the worker is free, got %d", rec.Code)
+	}
+}
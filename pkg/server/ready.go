@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// NewReadyHandler reports whether the stores serve requests, listing the buckets of each one,
+// or with the deep query parameter writing, reading back and removing a canary object in each one,
+// shared by concurrent checks and reused for a second
+// Checks not done within timeout fail, so that a hung filesystem does not hang the probe
+func NewReadyHandler(stores []*storage.Storage, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		_, deep := r.URL.Query()["deep"]
+		for i, store := range stores {
+			var err error
+			if deep {
+				err = store.Probe(ctx)
+			} else {
+				_, err = store.ListBuckets("", "", 1)
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("store %d: %v", i, err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestReadyHandler(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	full, err := storage.NewStorage(t.TempDir(), storage.WithMinFreeSpace(1<<62))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer full.Close()
+
+	tests := []struct {
+		name   string
+		stores []*storage.Storage
+		target string
+		status int
+	}{
+		{"Shallow", []*storage.Storage{store}, "/readyz", http.StatusOK},
+		{"Deep", []*storage.Storage{store}, "/readyz?deep", http.StatusOK},
+		// The disk is not too full to list buckets, only to write
+		{"ShallowFull", []*storage.Storage{store, full}, "/readyz", http.StatusOK},
+		{"DeepFull", []*storage.Storage{store, full}, "/readyz?deep=1", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewReadyHandler(tt.stores, time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != "ok\n" {
+				t.Errorf("Expected ok, got %q", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Unreadable", func(t *testing.T) {
+		dir := t.TempDir()
+		gone, err := storage.NewStorage(dir)
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		defer gone.Close()
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("RemoveAll failed: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		NewReadyHandler([]*storage.Storage{gone}, time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "store 0") {
+			t.Errorf("Expected the missing data directory to fail, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// newRequestID generates a request ID in the format used by S3, 16 uppercase hex characters
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// newHostID generates an extended request ID for the x-amz-id-2 header
+func newHostID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// RequestIDFromContext returns the request ID stored in the context, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID assigns a request ID to the request unless it already has one,
+// and sets the x-amz-request-id and x-amz-id-2 response headers
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if RequestIDFromContext(r.Context()) != "" {
+		return r
+	}
+
+	id := newRequestID()
+	w.Header().Set("x-amz-request-id", id)
+	w.Header().Set("x-amz-id-2", newHostID())
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// RequestIDMiddleware assigns a request ID to every request before it reaches next,
+// so that responses written by outer middlewares such as authentication carry it too
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
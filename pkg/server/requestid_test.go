@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestRequestID(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "/test-bucket", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if id := rec.Header().Get("x-amz-request-id"); len(id) != 16 {
+			t.Errorf("Expected a 16 character request ID, got %q", id)
+		}
+		if rec.Header().Get("x-amz-id-2") == "" {
+			t.Error("Expected x-amz-id-2 header")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/missing", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var errResp Error
+		if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+			t.Fatalf("Failed to parse error response: %v", err)
+		}
+		if errResp.RequestId == "" || errResp.RequestId != rec.Header().Get("x-amz-request-id") {
+			t.Errorf("Expected error RequestId to match header %q, got %q", rec.Header().Get("x-amz-request-id"), errResp.RequestId)
+		}
+		if errResp.HostId != rec.Header().Get("x-amz-id-2") {
+			t.Errorf("Expected error HostId to match header %q, got %q", rec.Header().Get("x-amz-id-2"), errResp.HostId)
+		}
+	})
+
+	t.Run("Middleware", func(t *testing.T) {
+		var contextID string
+		wrapped := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contextID = RequestIDFromContext(r.Context())
+			handler.ServeHTTP(w, r)
+		}))
+
+		req := httptest.NewRequest(http.MethodHead, "/test-bucket", nil)
+		rec := httptest.NewRecorder()
+		wrapped.ServeHTTP(rec, req)
+
+		if contextID == "" {
+			t.Fatal("Expected request ID in context")
+		}
+		if id := rec.Header().Get("x-amz-request-id"); id != contextID {
+			t.Errorf("Expected request ID %q, got %q", contextID, id)
+		}
+	})
+}
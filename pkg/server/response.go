@@ -1,10 +1,15 @@
 package server
 
 import (
+	"bufio"
 	"encoding/xml"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/wzshiming/s3d/pkg/s3key"
 	"github.com/wzshiming/s3d/pkg/storage"
 )
 
@@ -34,13 +39,70 @@ func extractMetadata(r *http.Request) storage.Metadata {
 	if contentDisposition := r.Header.Get("Content-Disposition"); contentDisposition != "" {
 		metadata.ContentDisposition = contentDisposition
 	}
+	if contentEncoding := storedContentEncoding(r.Header.Get("Content-Encoding")); contentEncoding != "" {
+		metadata.ContentEncoding = contentEncoding
+	}
 	if contentType := r.Header.Get("Content-Type"); contentType != "" {
 		metadata.ContentType = contentType
 	}
+	if redirectLocation := r.Header.Get("x-amz-website-redirect-location"); redirectLocation != "" {
+		metadata.WebsiteRedirectLocation = redirectLocation
+	}
+	if tagging := r.Header.Get("x-amz-tagging"); tagging != "" {
+		// The tag set is URL query encoded, e.g. "key1=value1&key2=value2"
+		tags, _ := url.ParseQuery(tagging)
+		for key, values := range tags {
+			if metadata.Tags == nil {
+				metadata.Tags = make(map[string]string)
+			}
+			metadata.Tags[key] = values[0]
+		}
+	}
 
 	return metadata
 }
 
+// validRedirectLocation reports whether location may be the website redirect location of an object,
+// another object of the website or an absolute http or https URL, as S3 accepts
+func validRedirectLocation(location string) bool {
+	return strings.HasPrefix(location, "/") || strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// checkRedirectLocation rejects requests with an x-amz-website-redirect-location S3 does not accept
+// Returns false if an error response has been written
+func (s *S3Handler) checkRedirectLocation(w http.ResponseWriter, r *http.Request) bool {
+	if location := r.Header.Get("x-amz-website-redirect-location"); location != "" && !validRedirectLocation(location) {
+		s.errorResponse(w, r, "InvalidRedirectLocation", "The website redirect location must have a prefix of 'http://' or 'https://' or '/'.", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// storedContentEncoding returns the Content-Encoding of a write without aws-chunked,
+// which only describes the framing of the request body, so "aws-chunked,gzip" stores "gzip"
+func storedContentEncoding(header string) string {
+	var encodings []string
+	for _, encoding := range strings.Split(header, ",") {
+		encoding = strings.TrimSpace(encoding)
+		if encoding != "" && !strings.EqualFold(encoding, "aws-chunked") {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return strings.Join(encodings, ",")
+}
+
+// sniffContentType detects the Content-Type of body from its first 512 bytes,
+// returning a reader of the whole body and "" for empty ones
+// Read errors are left to the returned reader, so that storage discards the data as usual
+func sniffContentType(body io.Reader) (io.Reader, string) {
+	reader := bufio.NewReaderSize(body, 512)
+	head, _ := reader.Peek(512)
+	if len(head) == 0 {
+		return reader, ""
+	}
+	return reader, http.DetectContentType(head)
+}
+
 // setMetadataHeaders sets user-defined metadata headers on the response
 func setMetadataHeaders(w http.ResponseWriter, metadata storage.Metadata) {
 	if metadata.CacheControl != "" {
@@ -49,11 +111,20 @@ func setMetadataHeaders(w http.ResponseWriter, metadata storage.Metadata) {
 	if metadata.ContentDisposition != "" {
 		w.Header().Set("Content-Disposition", metadata.ContentDisposition)
 	}
+	if metadata.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", metadata.ContentEncoding)
+	}
 	if metadata.ContentType != "" {
 		w.Header().Set("Content-Type", metadata.ContentType)
 	} else {
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
+	if metadata.WebsiteRedirectLocation != "" {
+		w.Header().Set("x-amz-website-redirect-location", metadata.WebsiteRedirectLocation)
+	}
+	if len(metadata.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(metadata.Tags)))
+	}
 
 	for key, value := range metadata.XAmzMeta {
 		headerName := "x-amz-meta-" + key
@@ -61,6 +132,19 @@ func setMetadataHeaders(w http.ResponseWriter, metadata storage.Metadata) {
 	}
 }
 
+// keyEncoder returns the function encoding keys in list responses for the encoding-type
+// query parameter, which is either empty or "url"
+func keyEncoder(query url.Values) (func(string) string, bool) {
+	switch query.Get("encoding-type") {
+	case "":
+		return func(key string) string { return key }, true
+	case "url":
+		return s3key.Encode, true
+	default:
+		return nil, false
+	}
+}
+
 // setHeaders sets common headers on the response
 func (s *S3Handler) setHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("x-amz-bucket-region", s.region)
@@ -80,11 +164,78 @@ func (s *S3Handler) xmlResponse(w http.ResponseWriter, r *http.Request, data any
 	}
 }
 
+// xmlStream writes an XML response element by element, so a long result is never held in memory as a whole
+// The encoder buffers a few kilobytes and writes them to the client as they fill up
+type xmlStream struct {
+	w    http.ResponseWriter
+	enc  *xml.Encoder
+	root xml.StartElement
+	err  error
+}
+
+// xmlStreamResponse starts an XML response whose root element is name
+func (s *S3Handler) xmlStreamResponse(w http.ResponseWriter, r *http.Request, name string, status int) *xmlStream {
+	s.setHeaders(w, r)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	x := &xmlStream{
+		w:    w,
+		enc:  xml.NewEncoder(w),
+		root: xml.StartElement{Name: xml.Name{Local: name}},
+	}
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		x.err = err
+		return x
+	}
+	x.err = x.enc.EncodeToken(x.root)
+	return x
+}
+
+// element writes v as a child element of the root, doing nothing once the client is gone
+func (x *xmlStream) element(name string, v any) {
+	if x.err != nil {
+		return
+	}
+	x.err = x.enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+// optional writes a child element of the root unless v is empty, like the omitempty option of struct tags
+func (x *xmlStream) optional(name, v string) {
+	if v != "" {
+		x.element(name, v)
+	}
+}
+
+// flush sends what was written so far to the client, so that it sees the progress of a long operation
+func (x *xmlStream) flush() {
+	if x.err != nil {
+		return
+	}
+	if x.err = x.enc.Flush(); x.err == nil {
+		// Not every writer can flush, the data then goes out when its buffer fills up
+		http.NewResponseController(x.w).Flush()
+	}
+}
+
+// close ends the root element and flushes the response
+func (x *xmlStream) close() error {
+	if x.err != nil {
+		return x.err
+	}
+	if err := x.enc.EncodeToken(x.root.End()); err != nil {
+		return err
+	}
+	return x.enc.Flush()
+}
+
 // errorResponse writes an error response
 func (s *S3Handler) errorResponse(w http.ResponseWriter, r *http.Request, code, message string, status int) {
 	err := Error{
-		Code:    code,
-		Message: message,
+		Code:      code,
+		Message:   message,
+		RequestId: w.Header().Get("x-amz-request-id"),
+		HostId:    w.Header().Get("x-amz-id-2"),
 	}
 
 	s.setHeaders(w, r)
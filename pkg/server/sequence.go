@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// bucketSequenceHeader carries the sequence number of the bucket of a request in its response:
+// reads and listings observe every change numbered up to it, and writes are numbered up to it
+// Test harnesses pass it back in minBucketSequenceHeader to assert read-after-write and
+// list-after-write consistency across requests
+const bucketSequenceHeader = "x-s3d-bucket-sequence"
+
+// minBucketSequenceHeader makes a request fail unless the server has numbered the changes
+// of its bucket up to the sequence number it carries, such as one served by another process
+// or before a restart, which restart the numbering
+const minBucketSequenceHeader = "x-s3d-min-bucket-sequence"
+
+// sequenceWriter adds bucketSequenceHeader to a response
+// Reads report the sequence number from before they started, since changes made while they run
+// may not be observed, and writes the one from after they completed
+type sequenceWriter struct {
+	http.ResponseWriter
+	storage     *storage.Storage
+	bucket      string
+	read        bool
+	sequence    uint64
+	wroteHeader bool
+}
+
+func (q *sequenceWriter) WriteHeader(status int) {
+	if !q.wroteHeader {
+		q.wroteHeader = true
+		sequence := q.sequence
+		if !q.read {
+			sequence = q.storage.BucketSequence(q.bucket)
+		}
+		q.Header().Set(bucketSequenceHeader, strconv.FormatUint(sequence, 10))
+	}
+	q.ResponseWriter.WriteHeader(status)
+}
+
+func (q *sequenceWriter) Write(b []byte) (int, error) {
+	if !q.wroteHeader {
+		q.WriteHeader(http.StatusOK)
+	}
+	return q.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying response writer
+func (q *sequenceWriter) Unwrap() http.ResponseWriter {
+	return q.ResponseWriter
+}
+
+// withBucketSequence wraps w to report the sequence number of bucket, and checks that of
+// minBucketSequenceHeader, in which case it writes the error and returns false
+func (s *S3Handler) withBucketSequence(w http.ResponseWriter, r *http.Request, bucket string) (http.ResponseWriter, bool) {
+	sequence := s.storage.BucketSequence(bucket)
+	if value := r.Header.Get(minBucketSequenceHeader); value != "" {
+		minSequence, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			s.errorResponse(w, r, "InvalidArgument", "Invalid "+minBucketSequenceHeader+" header, expected a sequence number", http.StatusBadRequest)
+			return nil, false
+		}
+		if sequence < minSequence {
+			w.Header().Set(bucketSequenceHeader, strconv.FormatUint(sequence, 10))
+			s.errorResponse(w, r, "ServiceUnavailable", "The server has not reached the requested sequence number of the bucket", http.StatusServiceUnavailable)
+			return nil, false
+		}
+	}
+	return &sequenceWriter{
+		ResponseWriter: w,
+		storage:        s.storage,
+		bucket:         bucket,
+		read:           isReadRequest(r),
+		sequence:       sequence,
+	}, true
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestBucketSequenceHeader(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	handler := NewS3Handler(store)
+	do := func(method, target, minSequence string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("data"))
+		if minSequence != "" {
+			req.Header.Set(minBucketSequenceHeader, minSequence)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Writes report the sequence number of their change
+	for _, want := range []string{"1", "2"} {
+		rec := do(http.MethodPut, "/test-bucket/object", "")
+		if rec.Code != http.StatusOK || rec.Header().Get(bucketSequenceHeader) != want {
+			t.Fatalf("Expected PutObject to report sequence %s, got %d with %q", want, rec.Code, rec.Header().Get(bucketSequenceHeader))
+		}
+	}
+	// So do writes through the storage
+	if _, err := store.PutObject(context.Background(), "test-bucket", "other", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		minSequence string
+		status      int
+		sequence    string
+	}{
+		{"Get", http.MethodGet, "/test-bucket/object", "", http.StatusOK, "3"},
+		{"List", http.MethodGet, "/test-bucket?list-type=2", "3", http.StatusOK, "3"},
+		{"Missing", http.MethodHead, "/test-bucket/missing", "", http.StatusNotFound, "3"},
+		{"OtherBucket", http.MethodGet, "/other-bucket", "", http.StatusNotFound, "0"},
+		{"NotReached", http.MethodGet, "/test-bucket/object", "4", http.StatusServiceUnavailable, "3"},
+		{"Invalid", http.MethodGet, "/test-bucket/object", "latest", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.target, tt.minSequence)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get(bucketSequenceHeader); got != tt.sequence {
+				t.Errorf("Expected sequence %q, got %q", tt.sequence, got)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/wzshiming/s3d/pkg/storage"
@@ -9,8 +12,17 @@ import (
 
 // S3Handler represents the S3-compatible server
 type S3Handler struct {
-	storage *storage.Storage
-	region  string
+	storage  *storage.Storage
+	region   string
+	compress bool
+	sniff    bool
+	audit    bool
+	readOnly bool
+	heavy    *heavyQueue // bounds disk-bound operations, unbounded if nil
+	lists    *listCache  // caches listings, disabled if nil
+	account  string      // owns the buckets served, the root namespace if empty
+	maxKeys  int         // caps the keys, multipart uploads and parts a listing returns
+	profile  Profile     // quirks of the provider emulated, none if zero
 }
 
 // Option is a functional option for configuring S3Handler
@@ -23,11 +35,45 @@ func WithRegion(region string) Option {
 	}
 }
 
+// WithCompression enables on-the-fly gzip/deflate compression of compressible GetObject responses
+func WithCompression(enabled bool) Option {
+	return func(h *S3Handler) {
+		h.compress = enabled
+	}
+}
+
+// WithContentTypeSniffing stores the Content-Type detected from the first 512 bytes of objects
+// uploaded without one by PutObject, instead of serving them as application/octet-stream
+func WithContentTypeSniffing(enabled bool) Option {
+	return func(h *S3Handler) {
+		h.sniff = enabled
+	}
+}
+
+// WithReadOnly puts the server in maintenance mode, rejecting every request that could change data
+func WithReadOnly(enabled bool) Option {
+	return func(h *S3Handler) {
+		h.readOnly = enabled
+	}
+}
+
+// WithMaxKeys raises or lowers the most keys, multipart uploads and parts a listing returns,
+// capping larger max-keys, max-uploads and max-parts like the 1000 of S3 does, kept if not positive
+// Larger pages suit trusted networks, where clients are not expected to hold the server busy with them
+func WithMaxKeys(n int) Option {
+	return func(h *S3Handler) {
+		if n > 0 {
+			h.maxKeys = n
+		}
+	}
+}
+
 // NewS3Handler creates a new S3 server
 func NewS3Handler(storage *storage.Storage, opts ...Option) *S3Handler {
 	h := &S3Handler{
 		storage: storage,
 		region:  "us-east-1", // default region
+		maxKeys: defaultMaxKeys,
 	}
 	for _, opt := range opts {
 		opt(h)
@@ -35,17 +81,173 @@ func NewS3Handler(storage *storage.Storage, opts ...Option) *S3Handler {
 	return h
 }
 
+// unimplementedBucketSubresources maps bucket subresources that are recognized
+// but not implemented to the resource part of their operation names
+var unimplementedBucketSubresources = map[string]string{
+	"acl":                 "BucketAcl",
+	"analytics":           "BucketAnalyticsConfiguration",
+	"cors":                "BucketCors",
+	"encryption":          "BucketEncryption",
+	"intelligent-tiering": "BucketIntelligentTieringConfiguration",
+	"inventory":           "BucketInventoryConfiguration",
+	"lifecycle":           "BucketLifecycleConfiguration",
+	"logging":             "BucketLogging",
+	"metrics":             "BucketMetricsConfiguration",
+	"notification":        "BucketNotificationConfiguration",
+	"object-lock":         "ObjectLockConfiguration",
+	"policy":              "BucketPolicy",
+	"policyStatus":        "BucketPolicyStatus",
+	"replication":         "BucketReplication",
+	"session":             "CreateSession",
+	"tagging":             "BucketTagging",
+	"versioning":          "BucketVersioning",
+	"versions":            "ObjectVersions",
+}
+
+// unimplementedObjectSubresources maps object subresources that are recognized
+// but not implemented to the resource part of their operation names
+var unimplementedObjectSubresources = map[string]string{
+	"acl":        "ObjectAcl",
+	"attributes": "ObjectAttributes",
+	"legal-hold": "ObjectLegalHold",
+	"restore":    "RestoreObject",
+	"retention":  "ObjectRetention",
+	"select":     "SelectObjectContent",
+	"tagging":    "ObjectTagging",
+	"torrent":    "ObjectTorrent",
+}
+
+// unimplementedOperation returns the name of the operation if the request targets
+// a known but unimplemented subresource, or an empty string otherwise
+func unimplementedOperation(r *http.Request, isBucket bool) string {
+	subresources := unimplementedObjectSubresources
+	if isBucket {
+		subresources = unimplementedBucketSubresources
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource, ok := subresources[name]
+		if !ok {
+			continue
+		}
+		switch resource {
+		case "ObjectVersions":
+			return "ListObjectVersions"
+		case "CreateSession", "RestoreObject", "SelectObjectContent":
+			return resource
+		}
+		switch r.Method {
+		case http.MethodPut:
+			return "Put" + resource
+		case http.MethodDelete:
+			return "Delete" + resource
+		default:
+			return "Get" + resource
+		}
+	}
+	return ""
+}
+
+// bucketSubresourceMethods maps the implemented bucket subresources, and the bucket itself
+// under the empty name, to the methods they support
+var bucketSubresourceMethods = map[string][]string{
+	"":                  {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"accelerate":        {http.MethodGet, http.MethodHead, http.MethodPut},
+	"audit":             {http.MethodGet, http.MethodHead},
+	"copy":              {http.MethodPost},
+	"default-ttl":       {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"delete":            {http.MethodPost},
+	"deletePrefix":      {http.MethodPost},
+	"export":            {http.MethodGet, http.MethodHead},
+	"extract":           {http.MethodPut},
+	"freeze":            {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"listTrash":         {http.MethodGet, http.MethodHead},
+	"location":          {http.MethodGet, http.MethodHead},
+	"lock":              {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"ownershipControls": {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"publicAccessBlock": {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"requestPayment":    {http.MethodGet, http.MethodHead, http.MethodPut},
+	"restoreTrash":      {http.MethodPost},
+	"summary":           {http.MethodGet, http.MethodHead},
+	"trash":             {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"uploads":           {http.MethodGet, http.MethodHead},
+	"website":           {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+}
+
+// objectSubresourceMethods maps the implemented object subresources, and the object itself
+// under the empty name, to the methods they support
+var objectSubresourceMethods = map[string][]string{
+	"":         {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	"compose":  {http.MethodPost},
+	"uploadId": {http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete},
+	"uploads":  {http.MethodPost},
+}
+
+// requestSubresource returns the implemented subresource the request targets, or an empty string
+// for the bucket or object itself, and the methods it supports
+func requestSubresource(r *http.Request, isBucket bool) (string, []string) {
+	subresources := objectSubresourceMethods
+	if isBucket {
+		subresources = bucketSubresourceMethods
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if methods, ok := subresources[name]; ok && name != "" {
+			return name, methods
+		}
+	}
+	return "", subresources[""]
+}
+
+// methodNotAllowed rejects a request whose method the resource does not support, listing the ones it does
+func (s *S3Handler) methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	s.errorResponse(w, r, "MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed)
+}
+
+// isReadRequest reports whether r cannot change any data
+// Every S3 operation using another method, including POST, writes
+func isReadRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
 // handleRequest handles all S3 requests
 func (s *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+
+	if s.readOnly && !isReadRequest(r) {
+		s.errorResponse(w, r, "ServiceUnavailable", "The server is in read-only mode", http.StatusServiceUnavailable)
+		return
+	}
+
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	parts := strings.SplitN(path, "/", 2)
 
 	// Root path - list buckets
 	if path == "" || path == "/" {
-		if r.Method == http.MethodGet {
-			s.handleListBuckets(w, r)
-		} else {
-			s.errorResponse(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+		switch {
+		case r.Method != http.MethodGet:
+			s.methodNotAllowed(w, r, []string{http.MethodGet})
+		case r.URL.Query().Get("x-id") == "ListDirectoryBuckets":
+			s.handleListDirectoryBuckets(w, r)
+		default:
+			s.cachedList(w, r, "", func(w http.ResponseWriter) {
+				s.handleListBuckets(w, r)
+			})
 		}
 		return
 	}
@@ -60,40 +262,165 @@ func (s *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// This handles cases where s3fs-fuse requests /bucket// to access the root directory
 	key = strings.TrimPrefix(key, "/")
 
+	w, ok := s.withBucketSequence(w, r, bucket)
+	if !ok {
+		return
+	}
+	r, ok = s.checkPublicAccessBlock(w, r, bucket)
+	if !ok {
+		return
+	}
+
+	if key == "" {
+		if subresource, ok := unconfiguredSubresource(r); ok {
+			s.handleGetUnconfigured(w, r, bucket, subresource)
+			return
+		}
+	}
+	if operation := unimplementedOperation(r, key == ""); operation != "" {
+		s.errorResponse(w, r, "NotImplemented", fmt.Sprintf("%s is not implemented", operation), http.StatusNotImplemented)
+		return
+	}
+	if key != "" && !s.checkProfileHeaders(w, r) {
+		return
+	}
+
+	// Unsupported methods are rejected here rather than reaching a handler of another operation,
+	// such as PUT /bucket/key?uploads storing an object
+	subresource, allowed := requestSubresource(r, key == "")
+	if !slices.Contains(allowed, r.Method) {
+		s.methodNotAllowed(w, r, allowed)
+		return
+	}
+	defer s.invalidateLists(r, bucket, key, subresource)
+	method := r.Method
+	if method == http.MethodHead && subresource != "" {
+		// HEAD on a subresource is its GET without the body
+		method = http.MethodGet
+	}
+
+	if s.heavy != nil && isHeavyRequest(r, key) {
+		if !s.heavy.acquire(r.Context()) {
+			s.errorResponse(w, r, "SlowDown", "Please reduce your request rate", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.heavy.release()
+	}
+
 	query := r.URL.Query()
 	if key == "" {
-		switch r.Method {
+		switch method {
 		case http.MethodPut:
-			s.handleCreateBucket(w, r, bucket)
+			switch {
+			case query.Has("ownershipControls"):
+				s.handlePutBucketOwnershipControls(w, r, bucket)
+			case query.Has("publicAccessBlock"):
+				s.handlePutPublicAccessBlock(w, r, bucket)
+			case query.Has("website"):
+				s.handlePutBucketWebsite(w, r, bucket)
+			case query.Has("accelerate"):
+				s.handlePutBucketAccelerateConfiguration(w, r, bucket)
+			case query.Has("requestPayment"):
+				s.handlePutBucketRequestPayment(w, r, bucket)
+			case query.Has("freeze"):
+				s.handlePutBucketFreeze(w, r, bucket)
+			case query.Has("default-ttl"):
+				s.handlePutBucketDefaultTTL(w, r, bucket)
+			case query.Has("trash"):
+				s.handlePutBucketTrash(w, r, bucket)
+			case query.Has("extract"):
+				s.handleExtractArchive(w, r, bucket)
+			case query.Has("lock"):
+				s.handlePutBucketLock(w, r, bucket)
+			default:
+				s.handleCreateBucket(w, r, bucket)
+			}
 		case http.MethodGet:
-			if query.Has("uploads") {
+			switch {
+			case query.Has("uploads"):
 				s.handleListMultipartUploads(w, r, bucket)
-			} else {
-				s.handleListObjects(w, r, bucket)
+			case query.Has("location"):
+				s.handleGetBucketLocation(w, r, bucket)
+			case query.Has("ownershipControls"):
+				s.handleGetBucketOwnershipControls(w, r, bucket)
+			case query.Has("publicAccessBlock"):
+				s.handleGetPublicAccessBlock(w, r, bucket)
+			case query.Has("website"):
+				s.handleGetBucketWebsite(w, r, bucket)
+			case query.Has("accelerate"):
+				s.handleGetBucketAccelerateConfiguration(w, r, bucket)
+			case query.Has("requestPayment"):
+				s.handleGetBucketRequestPayment(w, r, bucket)
+			case query.Has("audit"):
+				s.handleGetBucketAuditLog(w, r, bucket)
+			case query.Has("freeze"):
+				s.handleGetBucketFreeze(w, r, bucket)
+			case query.Has("default-ttl"):
+				s.handleGetBucketDefaultTTL(w, r, bucket)
+			case query.Has("trash"):
+				s.handleGetBucketTrash(w, r, bucket)
+			case query.Has("listTrash"):
+				s.handleListTrash(w, r, bucket)
+			case query.Has("summary"):
+				s.handleGetPrefixSummary(w, r, bucket)
+			case query.Has("export"):
+				s.handleExportBucket(w, r, bucket)
+			case query.Has("lock"):
+				s.handleGetBucketLock(w, r, bucket)
+			default:
+				s.cachedList(w, r, bucket, func(w http.ResponseWriter) {
+					s.handleListObjects(w, r, bucket)
+				})
 			}
 		case http.MethodPost:
-			if query.Has("delete") {
+			switch {
+			case query.Has("delete"):
 				s.handleDeleteObjects(w, r, bucket)
-			} else {
-				s.errorResponse(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			case query.Has("copy"):
+				s.handleCopyObjects(w, r, bucket)
+			case query.Has("deletePrefix"):
+				s.handleDeletePrefix(w, r, bucket)
+			case query.Has("restoreTrash"):
+				s.handleRestoreTrash(w, r, bucket)
+			default:
+				s.methodNotAllowed(w, r, allowed)
 			}
 		case http.MethodDelete:
-			s.handleDeleteBucket(w, r, bucket)
+			switch {
+			case query.Has("ownershipControls"):
+				s.handleDeleteBucketOwnershipControls(w, r, bucket)
+			case query.Has("publicAccessBlock"):
+				s.handleDeletePublicAccessBlock(w, r, bucket)
+			case query.Has("website"):
+				s.handleDeleteBucketWebsite(w, r, bucket)
+			case query.Has("freeze"):
+				s.handleDeleteBucketFreeze(w, r, bucket)
+			case query.Has("default-ttl"):
+				s.handleDeleteBucketDefaultTTL(w, r, bucket)
+			case query.Has("trash"):
+				s.handleDeleteBucketTrash(w, r, bucket)
+			case query.Has("lock"):
+				s.handleDeleteBucketLock(w, r, bucket)
+			default:
+				s.handleDeleteBucket(w, r, bucket)
+			}
 		case http.MethodHead:
 			s.handleHeadBucket(w, r, bucket)
 		default:
-			s.errorResponse(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			s.methodNotAllowed(w, r, allowed)
 		}
 	} else {
-		switch r.Method {
+		switch method {
 		case http.MethodPost:
 			if query.Has("uploads") {
 				s.handleInitiateMultipartUpload(w, r, bucket, key)
 			} else if query.Has("uploadId") {
 				uploadID := query.Get("uploadId")
 				s.handleCompleteMultipartUpload(w, r, bucket, key, uploadID)
+			} else if query.Has("compose") {
+				s.handleComposeObject(w, r, bucket, key)
 			} else {
-				s.errorResponse(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+				s.methodNotAllowed(w, r, allowed)
 			}
 		case http.MethodPut:
 			if query.Has("uploadId") {
@@ -123,7 +450,7 @@ func (s *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				s.handleDeleteObject(w, r, bucket, key)
 			}
 		default:
-			s.errorResponse(w, r, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			s.methodNotAllowed(w, r, allowed)
 		}
 	}
 }
@@ -1,9 +1,16 @@
 package server
 
 import (
+	"context"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"time"
@@ -49,3 +56,288 @@ func TestServerRun(t *testing.T) {
 
 	t.Logf("Server would run on %s", addr)
 }
+
+func TestUnimplementedOperations(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	tests := []struct {
+		method            string
+		path              string
+		expectedOperation string
+	}{
+		{http.MethodGet, "/test-bucket?analytics", "GetBucketAnalyticsConfiguration"},
+		{http.MethodPut, "/test-bucket?intelligent-tiering", "PutBucketIntelligentTieringConfiguration"},
+		{http.MethodDelete, "/test-bucket?metrics&id=1", "DeleteBucketMetricsConfiguration"},
+		{http.MethodPut, "/test-bucket?replication", "PutBucketReplication"},
+		{http.MethodGet, "/test-bucket?session", "CreateSession"},
+		{http.MethodGet, "/test-bucket?versions", "ListObjectVersions"},
+		{http.MethodPut, "/test-bucket/key?tagging", "PutObjectTagging"},
+		{http.MethodGet, "/test-bucket/key?legal-hold", "GetObjectLegalHold"},
+		{http.MethodPost, "/test-bucket/key?restore", "RestoreObject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedOperation, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotImplemented {
+				t.Fatalf("Expected status %d, got %d", http.StatusNotImplemented, rec.Code)
+			}
+
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != "NotImplemented" {
+				t.Errorf("Expected code NotImplemented, got %q", errResp.Code)
+			}
+			if errResp.Message != tt.expectedOperation+" is not implemented" {
+				t.Errorf("Unexpected message %q", errResp.Message)
+			}
+		})
+	}
+
+	// Subresources must not be misrouted to object handlers
+	if _, _, err := store.GetObject("test-bucket", "key"); err != storage.ErrObjectNotFound {
+		t.Fatalf("Expected no object to be created, got %v", err)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "key", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodDelete, "/", "GET"},
+		{http.MethodPost, "/test-bucket", "GET, HEAD, PUT, DELETE"},
+		{http.MethodGet, "/test-bucket?delete", "POST"},
+		{http.MethodPut, "/test-bucket?location", "GET, HEAD"},
+		{http.MethodDelete, "/test-bucket?accelerate", "GET, HEAD, PUT"},
+		{http.MethodPost, "/test-bucket/key", "GET, HEAD, PUT, DELETE"},
+		{http.MethodPut, "/test-bucket/key?uploads", "POST"},
+		{http.MethodDelete, "/test-bucket/key?uploads", "POST"},
+		{http.MethodGet, "/test-bucket/key?compose", "POST"},
+		{http.MethodPatch, "/test-bucket/key?uploadId=upload", "GET, HEAD, PUT, POST, DELETE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("other"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Expected Allow %q, got %q", tt.allow, allow)
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != "MethodNotAllowed" {
+				t.Errorf("Expected code MethodNotAllowed, got %q", errResp.Code)
+			}
+		})
+	}
+
+	// Rejected requests must not reach the object handlers
+	reader, _, err := store.GetObject("test-bucket", "key")
+	if err != nil {
+		t.Fatalf("Expected the object to remain, got %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "data" {
+		t.Errorf("Expected the object to be unchanged, got %q", data)
+	}
+}
+
+func TestHeadSubresources(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// HEAD on a subresource answers like its GET, not like HeadBucket or HeadObject
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/test-bucket?location", http.StatusOK, ""},
+		{"/test-bucket?freeze", http.StatusOK, ""},
+		{"/test-bucket?website", http.StatusNotFound, "NoSuchWebsiteConfiguration"},
+		{"/test-bucket/key?uploadId=missing", http.StatusNotFound, "NoSuchUpload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodHead, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if tt.code == "" {
+				return
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected code %s, got %q", tt.code, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestEntityTooLarge(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir(), storage.WithMaxPartSize(10))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/test-bucket/key", strings.NewReader("more than ten bytes"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	var errResp Error
+	if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("Failed to parse error response: %v", err)
+	}
+	if errResp.Code != "EntityTooLarge" {
+		t.Errorf("Expected code EntityTooLarge, got %q", errResp.Code)
+	}
+
+	if _, _, err := store.GetObject("test-bucket", "key"); err != storage.ErrObjectNotFound {
+		t.Fatalf("Expected no object to be created, got %v", err)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "key", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	handler := NewS3Handler(store, WithReadOnly(true))
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodGet, "/test-bucket/key", http.StatusOK},
+		{http.MethodHead, "/test-bucket/key", http.StatusOK},
+		{http.MethodGet, "/test-bucket", http.StatusOK},
+		{http.MethodPut, "/test-bucket/other", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/test-bucket/key", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test-bucket?delete", http.StatusServiceUnavailable},
+		{http.MethodPut, "/new-bucket", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("data"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.want, rec.Code)
+		}
+	}
+
+	if _, _, err := store.GetObject("test-bucket", "key"); err != nil {
+		t.Errorf("Expected the object to survive, got %v", err)
+	}
+}
+
+func FuzzServeHTTP(f *testing.F) {
+	for _, seed := range []struct {
+		method, target string
+	}{
+		{http.MethodGet, "/"},
+		{http.MethodGet, "/fuzz-bucket?list-type=2&max-keys=-1&encoding-type=url"},
+		{http.MethodPut, "/fuzz-bucket/key?partNumber=0&uploadId=x"},
+		{http.MethodGet, "/fuzz-bucket//..%2F..%2Fetc/passwd"},
+		{http.MethodDelete, "/fuzz-bucket?website&freeze"},
+		{http.MethodPost, "/fuzz-bucket/key?compose"},
+	} {
+		f.Add(seed.method, seed.target, "")
+	}
+	store, err := storage.NewStorage(f.TempDir())
+	if err != nil {
+		f.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("fuzz-bucket"); err != nil {
+		f.Fatalf("Failed to create bucket: %v", err)
+	}
+	handler := NewS3Handler(store)
+
+	f.Fuzz(func(t *testing.T, method, target, body string) {
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete:
+		default:
+			return
+		}
+		u, err := url.ParseRequestURI(target)
+		if err != nil || !strings.HasPrefix(u.Path, "/") {
+			return
+		}
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.URL = u
+		req.RequestURI = target
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+	})
+}
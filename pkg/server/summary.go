@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// PrefixSummary is the response of the summary extension endpoint
+type PrefixSummary struct {
+	XMLName      xml.Name   `xml:"PrefixSummary"`
+	Bucket       string     `xml:"Bucket"`
+	Prefix       string     `xml:"Prefix"`
+	ObjectCount  int64      `xml:"ObjectCount"`
+	TotalSize    int64      `xml:"TotalSize"`
+	LastModified *Timestamp `xml:"LastModified,omitempty"`
+}
+
+// handleGetPrefixSummary returns the number and total size of the objects under the prefix parameter,
+// so that dashboards do not have to paginate through a listing of the whole prefix
+func (s *S3Handler) handleGetPrefixSummary(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	summary, err := s.storage.SummarizePrefix(r.Context(), bucket, prefix)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := PrefixSummary{
+		Bucket:      bucket,
+		Prefix:      prefix,
+		ObjectCount: summary.Objects,
+		TotalSize:   summary.Size,
+	}
+	if !summary.LastModified.IsZero() {
+		result.LastModified = &Timestamp{summary.LastModified}
+	}
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestGetPrefixSummary(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	for key, content := range map[string]string{"logs/a": "12345", "logs/b/c": "123", "other": "1"} {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, strings.NewReader(content), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	summarize := func(target string) (*httptest.ResponseRecorder, PrefixSummary) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var summary PrefixSummary
+		if rec.Code == http.StatusOK {
+			if err := xml.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+				t.Fatalf("Failed to parse summary: %v", err)
+			}
+		}
+		return rec, summary
+	}
+
+	tests := []struct {
+		target  string
+		prefix  string
+		objects int64
+		size    int64
+	}{
+		{"/test-bucket?summary", "", 3, 9},
+		{"/test-bucket?summary&prefix=logs/", "logs/", 2, 8},
+		{"/test-bucket?summary&prefix=missing/", "missing/", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, summary := summarize(tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if summary.Bucket != "test-bucket" || summary.Prefix != tt.prefix {
+				t.Errorf("Unexpected bucket %q and prefix %q", summary.Bucket, summary.Prefix)
+			}
+			if summary.ObjectCount != tt.objects || summary.TotalSize != tt.size {
+				t.Errorf("Expected %d objects of %d bytes, got %d objects of %d bytes", tt.objects, tt.size, summary.ObjectCount, summary.TotalSize)
+			}
+			if (summary.ObjectCount == 0) != (summary.LastModified == nil) {
+				t.Errorf("Unexpected last modification time %v for %d objects", summary.LastModified, summary.ObjectCount)
+			}
+		})
+	}
+
+	rec, _ := summarize("/missing-bucket?summary")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	var errResp Error
+	if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("Failed to parse error response: %v", err)
+	}
+	if errResp.Code != "NoSuchBucket" {
+		t.Errorf("Expected error code NoSuchBucket, got %s", errResp.Code)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// trashRetentionHeader reports on HeadBucket for how many days deleted objects of a bucket are kept in its trash
+const trashRetentionHeader = "x-s3d-trash-retention-days"
+
+// BucketTrashConfiguration is the request and response of the trash extension endpoint
+type BucketTrashConfiguration struct {
+	XMLName xml.Name `xml:"BucketTrashConfiguration"`
+	// RetentionDays deleted objects are kept in the trash, 0 if the bucket has no trash
+	RetentionDays int `xml:"RetentionDays"`
+}
+
+// ListTrashResult is the response of the listTrash extension endpoint
+type ListTrashResult struct {
+	XMLName xml.Name        `xml:"ListTrashResult"`
+	Name    string          `xml:"Name"`
+	Prefix  string          `xml:"Prefix"`
+	Objects []TrashedObject `xml:"Object"`
+}
+
+// TrashedObject is an object in the trash of a bucket
+type TrashedObject struct {
+	TrashID      string    `xml:"TrashId"`
+	Key          string    `xml:"Key"`
+	LastModified Timestamp `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	DeletedAt    Timestamp `xml:"DeletedAt"`
+	PurgeAt      Timestamp `xml:"PurgeAt"`
+}
+
+// RestoreTrashResult is the response of the restoreTrash extension endpoint
+type RestoreTrashResult struct {
+	XMLName xml.Name `xml:"RestoreTrashResult"`
+	Key     string   `xml:"Key"`
+}
+
+// handlePutBucketTrash enables the trash of a bucket, keeping deleted objects for a number of days
+func (s *S3Handler) handlePutBucketTrash(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req BucketTrashConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, r, "MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest)
+		return
+	}
+	if req.RetentionDays <= 0 {
+		s.errorResponse(w, r, "InvalidArgument", "RetentionDays must be a positive number of days", http.StatusBadRequest)
+		return
+	}
+	s.setBucketTrashRetention(w, r, bucket, time.Duration(req.RetentionDays)*24*time.Hour)
+}
+
+// handleDeleteBucketTrash disables the trash of a bucket, objects already in it are kept until purged
+func (s *S3Handler) handleDeleteBucketTrash(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.setBucketTrashRetention(w, r, bucket, 0)
+}
+
+// setBucketTrashRetention sets the trash retention of a bucket, 0 to disable its trash
+func (s *S3Handler) setBucketTrashRetention(w http.ResponseWriter, r *http.Request, bucket string, retention time.Duration) {
+	if err := s.storage.SetBucketTrashRetention(bucket, retention); err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.setHeaders(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetBucketTrash returns for how many days deleted objects of a bucket are kept in its trash
+func (s *S3Handler) handleGetBucketTrash(w http.ResponseWriter, r *http.Request, bucket string) {
+	retention, err := s.storage.GetBucketTrashRetention(bucket)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.xmlResponse(w, r, BucketTrashConfiguration{RetentionDays: durationDays(retention)}, http.StatusOK)
+}
+
+// handleListTrash lists the objects in the trash of a bucket, optionally under a prefix
+func (s *S3Handler) handleListTrash(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	trashed, err := s.storage.ListTrash(bucket, prefix)
+	if err != nil {
+		if err == storage.ErrBucketNotFound {
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		} else {
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := ListTrashResult{
+		Name:   bucket,
+		Prefix: prefix,
+	}
+	for _, obj := range trashed {
+		result.Objects = append(result.Objects, TrashedObject{
+			TrashID:      obj.ID,
+			Key:          obj.Key,
+			LastModified: Timestamp{obj.ModTime},
+			ETag:         s.etag(obj.ETag),
+			Size:         obj.Size,
+			DeletedAt:    Timestamp{obj.DeletedAt},
+			PurgeAt:      Timestamp{obj.PurgeAt},
+		})
+	}
+	s.xmlResponse(w, r, result, http.StatusOK)
+}
+
+// handleRestoreTrash moves an object out of the trash of a bucket back to its key
+func (s *S3Handler) handleRestoreTrash(w http.ResponseWriter, r *http.Request, bucket string) {
+	key, err := s.storage.RestoreObject(bucket, r.URL.Query().Get("id"))
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		case storage.ErrTrashEntryNotFound:
+			s.errorResponse(w, r, "NoSuchKey", "The trash of the bucket has no such object", http.StatusNotFound)
+		case storage.ErrObjectAlreadyExists:
+			s.errorResponse(w, r, "ObjectAlreadyExists", "An object was written to the key since it was deleted", http.StatusConflict)
+		case storage.ErrBucketFrozen:
+			s.errorResponse(w, r, "InvalidBucketState", "The bucket is frozen", http.StatusConflict)
+		default:
+			s.errorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.recordAudit(r, bucket, "RestoreObject", key, "")
+	s.xmlResponse(w, r, RestoreTrashResult{Key: key}, http.StatusOK)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestBucketTrash(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	listTrash := func(target string) ListTrashResult {
+		rec := serve(http.MethodGet, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ListTrash failed with status %d: %s", rec.Code, rec.Body.String())
+		}
+		var result ListTrashResult
+		if err := xml.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse ListTrash response: %v", err)
+		}
+		return result
+	}
+
+	if rec := serve(http.MethodPut, "/test-bucket?trash", "<BucketTrashConfiguration><RetentionDays>7</RetentionDays></BucketTrashConfiguration>"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	rec := serve(http.MethodGet, "/test-bucket?trash", "")
+	var config BucketTrashConfiguration
+	if err := xml.Unmarshal(rec.Body.Bytes(), &config); err != nil || config.RetentionDays != 7 {
+		t.Errorf("Expected a retention of 7 days, got %+v (%v)", config, err)
+	}
+	if rec := serve(http.MethodHead, "/test-bucket", ""); rec.Header().Get(trashRetentionHeader) != "7" {
+		t.Errorf("Expected the %s header on HeadBucket, got %q", trashRetentionHeader, rec.Header().Get(trashRetentionHeader))
+	}
+
+	for _, key := range []string{"docs/a", "docs/b", "other"} {
+		if rec := serve(http.MethodPut, "/test-bucket/"+key, "content of "+key); rec.Code != http.StatusOK {
+			t.Fatalf("PutObject failed with status %d", rec.Code)
+		}
+		if rec := serve(http.MethodDelete, "/test-bucket/"+key, ""); rec.Code != http.StatusNoContent {
+			t.Fatalf("DeleteObject failed with status %d", rec.Code)
+		}
+	}
+
+	result := listTrash("/test-bucket?listTrash")
+	if len(result.Objects) != 3 {
+		t.Fatalf("Expected 3 trashed objects, got %+v", result.Objects)
+	}
+	first := result.Objects[0]
+	if first.Key != "docs/a" || first.TrashID == "" || first.Size != int64(len("content of docs/a")) || first.DeletedAt.IsZero() || !first.PurgeAt.After(first.DeletedAt.Time) {
+		t.Errorf("Unexpected trashed object %+v", first)
+	}
+	if result := listTrash("/test-bucket?listTrash&prefix=docs/"); len(result.Objects) != 2 || result.Prefix != "docs/" {
+		t.Errorf("Expected 2 trashed objects under docs/, got %+v", result)
+	}
+
+	rec = serve(http.MethodPost, "/test-bucket?restoreTrash&id="+first.TrashID, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("RestoreTrash failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+	var restored RestoreTrashResult
+	if err := xml.Unmarshal(rec.Body.Bytes(), &restored); err != nil || restored.Key != "docs/a" {
+		t.Errorf("Expected docs/a to be restored, got %+v (%v)", restored, err)
+	}
+	if rec := serve(http.MethodGet, "/test-bucket/docs/a", ""); rec.Code != http.StatusOK || rec.Body.String() != "content of docs/a" {
+		t.Errorf("Expected the restored object, got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if result := listTrash("/test-bucket?listTrash"); len(result.Objects) != 2 {
+		t.Errorf("Expected 2 trashed objects left, got %+v", result.Objects)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		if rec := serve(http.MethodPost, "/test-bucket?restoreTrash&id="+first.TrashID, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d restoring twice, got %d", http.StatusNotFound, rec.Code)
+		}
+		second := listTrash("/test-bucket?listTrash&prefix=docs/b").Objects[0]
+		if rec := serve(http.MethodPut, "/test-bucket/docs/b", "new"); rec.Code != http.StatusOK {
+			t.Fatalf("PutObject failed with status %d", rec.Code)
+		}
+		if rec := serve(http.MethodPost, "/test-bucket?restoreTrash&id="+second.TrashID, ""); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status %d restoring over a new object, got %d", http.StatusConflict, rec.Code)
+		}
+		if rec := serve(http.MethodPut, "/test-bucket?trash", "<BucketTrashConfiguration><RetentionDays>0</RetentionDays></BucketTrashConfiguration>"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for a retention of 0 days, got %d", http.StatusBadRequest, rec.Code)
+		}
+		if rec := serve(http.MethodGet, "/missing-bucket?listTrash", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for a missing bucket, got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+
+	// Disabling the trash keeps what is already in it
+	if rec := serve(http.MethodDelete, "/test-bucket?trash", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/test-bucket/docs/a", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DeleteObject failed with status %d", rec.Code)
+	}
+	if result := listTrash("/test-bucket?listTrash"); len(result.Objects) != 2 {
+		t.Errorf("Expected deletes without a trash to skip it, got %+v", result.Objects)
+	}
+}
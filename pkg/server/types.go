@@ -5,10 +5,24 @@ import (
 	"time"
 )
 
+// timestampFormat is the ISO 8601 format of times in S3 XML responses, in UTC with milliseconds
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Timestamp is a time in an XML response, formatted like S3 does rather than with the nanoseconds
+// and zone offsets of time.Time, which strict SDK parsers warn about or reject
+type Timestamp struct {
+	time.Time
+}
+
+// MarshalText formats the timestamp in UTC with milliseconds
+func (t Timestamp) MarshalText() ([]byte, error) {
+	return []byte(t.UTC().Format(timestampFormat)), nil
+}
+
 // Bucket represents a bucket in ListBuckets response
 type Bucket struct {
 	Name         string    `xml:"Name"`
-	CreationDate time.Time `xml:"CreationDate"`
+	CreationDate Timestamp `xml:"CreationDate"`
 }
 
 // Owner represents the owner of buckets
@@ -28,10 +42,19 @@ type ListAllMyBucketsResult struct {
 	Prefix            string `xml:"Prefix,omitempty"`
 }
 
+// ListAllMyDirectoryBucketsResult is the response for ListDirectoryBuckets operation
+type ListAllMyDirectoryBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyDirectoryBucketsResult"`
+	Buckets struct {
+		Bucket []Bucket `xml:"Bucket"`
+	} `xml:"Buckets"`
+	ContinuationToken string `xml:"ContinuationToken,omitempty"`
+}
+
 // Contents represents an object in ListObjectsV2 response
 type Contents struct {
 	Key          string    `xml:"Key"`
-	LastModified time.Time `xml:"LastModified"`
+	LastModified Timestamp `xml:"LastModified"`
 	ETag         string    `xml:"ETag"`
 	Size         int64     `xml:"Size"`
 	StorageClass string    `xml:"StorageClass"`
@@ -53,6 +76,7 @@ type ListBucketResult struct {
 	Delimiter      string         `xml:"Delimiter,omitempty"`
 	MaxKeys        int            `xml:"MaxKeys"`
 	IsTruncated    bool           `xml:"IsTruncated"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
 	Contents       []Contents     `xml:"Contents"`
 	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 }
@@ -69,6 +93,7 @@ type ListBucketResultV2 struct {
 	ContinuationToken     string         `xml:"ContinuationToken"`
 	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
 	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
 	Contents              []Contents     `xml:"Contents"`
 	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 }
@@ -90,10 +115,12 @@ type Multipart struct {
 
 // CompletedPart represents a part in ListParts response
 type CompletedPart struct {
-	PartNumber   int       `xml:"PartNumber"`
-	LastModified time.Time `xml:"LastModified"`
-	ETag         string    `xml:"ETag"`
-	Size         int64     `xml:"Size"`
+	PartNumber     int       `xml:"PartNumber"`
+	LastModified   Timestamp `xml:"LastModified"`
+	ETag           string    `xml:"ETag"`
+	Size           int64     `xml:"Size"`
+	ChecksumCRC32  string    `xml:"ChecksumCRC32,omitempty"`
+	ChecksumSHA256 string    `xml:"ChecksumSHA256,omitempty"`
 }
 
 // CompleteMultipartUpload is the request for CompleteMultipartUpload operation
@@ -111,12 +138,75 @@ type CompleteMultipartUploadResult struct {
 	ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
 }
 
+// ComposeSource is a source object in a ComposeObject request
+type ComposeSource struct {
+	Key string `xml:"Key"`
+}
+
+// ComposeRequest is the request for the ComposeObject extension operation
+type ComposeRequest struct {
+	XMLName xml.Name        `xml:"ComposeRequest"`
+	Sources []ComposeSource `xml:"Source"`
+}
+
+// ComposeResult is the response for the ComposeObject extension operation
+type ComposeResult struct {
+	XMLName        xml.Name  `xml:"ComposeResult"`
+	Bucket         string    `xml:"Bucket"`
+	Key            string    `xml:"Key"`
+	ETag           string    `xml:"ETag"`
+	Size           int64     `xml:"Size"`
+	LastModified   Timestamp `xml:"LastModified"`
+	ChecksumSHA256 string    `xml:"ChecksumSHA256,omitempty"`
+}
+
+// CopyObjectsEntry is a copy in a CopyObjects request
+// SourceBucket defaults to the bucket of the request
+type CopyObjectsEntry struct {
+	SourceBucket string `xml:"SourceBucket,omitempty"`
+	SourceKey    string `xml:"SourceKey"`
+	Key          string `xml:"Key"`
+}
+
+// CopyObjectsRequest is the request for the CopyObjects extension operation
+type CopyObjectsRequest struct {
+	XMLName xml.Name           `xml:"CopyObjectsRequest"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []CopyObjectsEntry `xml:"Object"`
+}
+
+// CopiedObject represents a successful copy in CopyObjects response
+type CopiedObject struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	LastModified Timestamp `xml:"LastModified"`
+}
+
+// CopyError represents an error copying an object in CopyObjects response
+type CopyError struct {
+	Key          string `xml:"Key"`
+	SourceBucket string `xml:"SourceBucket"`
+	SourceKey    string `xml:"SourceKey"`
+	Code         string `xml:"Code"`
+	Message      string `xml:"Message"`
+}
+
+// CopyObjectsResult is the response for the CopyObjects extension operation
+type CopyObjectsResult struct {
+	XMLName xml.Name       `xml:"CopyObjectsResult"`
+	Copied  []CopiedObject `xml:"Copied,omitempty"`
+	Errors  []CopyError    `xml:"Error,omitempty"`
+}
+
 // Upload represents an upload in ListMultipartUploads response
 type Upload struct {
-	Key          string    `xml:"Key"`
-	UploadId     string    `xml:"UploadId"`
-	Initiated    time.Time `xml:"Initiated"`
-	StorageClass string    `xml:"StorageClass"`
+	Key               string    `xml:"Key"`
+	UploadId          string    `xml:"UploadId"`
+	Initiated         Timestamp `xml:"Initiated"`
+	Initiator         Owner     `xml:"Initiator"`
+	Owner             Owner     `xml:"Owner"`
+	StorageClass      string    `xml:"StorageClass"`
+	ChecksumAlgorithm string    `xml:"ChecksumAlgorithm,omitempty"`
 }
 
 // ListMultipartUploadsResult is the response for ListMultipartUploads operation
@@ -129,6 +219,7 @@ type ListMultipartUploadsResult struct {
 	NextUploadIdMarker string   `xml:"NextUploadIdMarker,omitempty"`
 	MaxUploads         int      `xml:"MaxUploads"`
 	IsTruncated        bool     `xml:"IsTruncated"`
+	EncodingType       string   `xml:"EncodingType,omitempty"`
 	Uploads            []Upload `xml:"Upload"`
 }
 
@@ -138,7 +229,10 @@ type ListPartsResult struct {
 	Bucket               string          `xml:"Bucket"`
 	Key                  string          `xml:"Key"`
 	UploadId             string          `xml:"UploadId"`
+	Initiator            Owner           `xml:"Initiator"`
+	Owner                Owner           `xml:"Owner"`
 	StorageClass         string          `xml:"StorageClass"`
+	ChecksumAlgorithm    string          `xml:"ChecksumAlgorithm,omitempty"`
 	PartNumberMarker     int             `xml:"PartNumberMarker,omitempty"`
 	NextPartNumberMarker int             `xml:"NextPartNumberMarker,omitempty"`
 	MaxParts             int             `xml:"MaxParts"`
@@ -149,22 +243,24 @@ type ListPartsResult struct {
 // CopyObjectResult is the response for CopyObject operation
 type CopyObjectResult struct {
 	XMLName      xml.Name  `xml:"CopyObjectResult"`
-	LastModified time.Time `xml:"LastModified"`
+	LastModified Timestamp `xml:"LastModified"`
 	ETag         string    `xml:"ETag"`
 }
 
 // CopyPartResult is the response for UploadPartCopy operation
 type CopyPartResult struct {
 	XMLName      xml.Name  `xml:"CopyPartResult"`
-	LastModified time.Time `xml:"LastModified"`
+	LastModified Timestamp `xml:"LastModified"`
 	ETag         string    `xml:"ETag"`
 }
 
 // Error represents an S3 error response
 type Error struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    string   `xml:"Code"`
-	Message string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestId string   `xml:"RequestId,omitempty"`
+	HostId    string   `xml:"HostId,omitempty"`
 }
 
 // ObjectIdentifier represents an object to delete in DeleteObjects request
@@ -201,3 +297,113 @@ type DeleteObjectsResult struct {
 	Deleted []DeletedObject `xml:"Deleted,omitempty"`
 	Errors  []DeleteError   `xml:"Error,omitempty"`
 }
+
+// DeletePrefixProgress is the progress of a DeletePrefix operation,
+// streamed while it runs and repeated at the end of its response
+type DeletePrefixProgress struct {
+	Deleted int64 `xml:"Deleted"`
+	Failed  int64 `xml:"Failed"`
+}
+
+// OwnershipControls is the request and response for the bucket ownership controls operations
+type OwnershipControls struct {
+	XMLName xml.Name                `xml:"OwnershipControls"`
+	Rules   []OwnershipControlsRule `xml:"Rule"`
+}
+
+// OwnershipControlsRule represents a rule in OwnershipControls
+type OwnershipControlsRule struct {
+	ObjectOwnership string `xml:"ObjectOwnership"`
+}
+
+// PublicAccessBlockConfiguration is the request and response for the public access block operations
+type PublicAccessBlockConfiguration struct {
+	XMLName               xml.Name `xml:"PublicAccessBlockConfiguration"`
+	BlockPublicAcls       bool     `xml:"BlockPublicAcls"`
+	IgnorePublicAcls      bool     `xml:"IgnorePublicAcls"`
+	BlockPublicPolicy     bool     `xml:"BlockPublicPolicy"`
+	RestrictPublicBuckets bool     `xml:"RestrictPublicBuckets"`
+}
+
+// WebsiteConfiguration is the request and response for the bucket website operations
+type WebsiteConfiguration struct {
+	XMLName               xml.Name               `xml:"WebsiteConfiguration"`
+	IndexDocument         *IndexDocument         `xml:"IndexDocument,omitempty"`
+	ErrorDocument         *ErrorDocument         `xml:"ErrorDocument,omitempty"`
+	RedirectAllRequestsTo *RedirectAllRequestsTo `xml:"RedirectAllRequestsTo,omitempty"`
+	RoutingRules          []RoutingRule          `xml:"RoutingRules>RoutingRule,omitempty"`
+}
+
+// IndexDocument represents the index document of a website configuration
+type IndexDocument struct {
+	Suffix string `xml:"Suffix"`
+}
+
+// ErrorDocument represents the error document of a website configuration
+type ErrorDocument struct {
+	Key string `xml:"Key"`
+}
+
+// RedirectAllRequestsTo represents the redirect target of a website configuration
+type RedirectAllRequestsTo struct {
+	HostName string `xml:"HostName"`
+	Protocol string `xml:"Protocol,omitempty"`
+}
+
+// RoutingRule represents a routing rule of a website configuration
+type RoutingRule struct {
+	Condition *RoutingRuleCondition `xml:"Condition,omitempty"`
+	Redirect  RoutingRuleRedirect   `xml:"Redirect"`
+}
+
+// RoutingRuleCondition represents the condition of a routing rule
+type RoutingRuleCondition struct {
+	HttpErrorCodeReturnedEquals string `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+}
+
+// RoutingRuleRedirect represents the redirect of a routing rule
+type RoutingRuleRedirect struct {
+	HostName             string `xml:"HostName,omitempty"`
+	HttpRedirectCode     string `xml:"HttpRedirectCode,omitempty"`
+	Protocol             string `xml:"Protocol,omitempty"`
+	ReplaceKeyPrefixWith string `xml:"ReplaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string `xml:"ReplaceKeyWith,omitempty"`
+}
+
+// AccelerateConfiguration is the request and response for the bucket accelerate configuration operations
+type AccelerateConfiguration struct {
+	XMLName xml.Name `xml:"AccelerateConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// VersioningConfiguration is the response of the GetBucketVersioning operation
+// Status is empty for buckets versioning was never enabled on, which are all buckets of s3d
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// BucketLoggingStatus is the response of the GetBucketLogging operation, empty while logging is disabled
+type BucketLoggingStatus struct {
+	XMLName xml.Name `xml:"BucketLoggingStatus"`
+}
+
+// NotificationConfiguration is the response of the GetBucketNotificationConfiguration operation,
+// empty while no notification is configured
+type NotificationConfiguration struct {
+	XMLName xml.Name `xml:"NotificationConfiguration"`
+}
+
+// LocationConstraint is the response of the GetBucketLocation operation
+// Region is empty for us-east-1, like S3 reports buckets of that region
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Region  string   `xml:",chardata"`
+}
+
+// RequestPaymentConfiguration is the request and response for the bucket request payment operations
+type RequestPaymentConfiguration struct {
+	XMLName xml.Name `xml:"RequestPaymentConfiguration"`
+	Payer   string   `xml:"Payer"`
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestTimestamp(t *testing.T) {
+	local := time.Date(2024, 3, 9, 23, 30, 15, 123456789, time.FixedZone("UTC+2", 2*60*60))
+	data, err := xml.Marshal(CopyObjectResult{LastModified: Timestamp{local}, ETag: `"etag"`})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), "<LastModified>2024-03-09T21:30:15.123Z</LastModified>") {
+		t.Errorf("Expected ISO 8601 in UTC with milliseconds, got %s", data)
+	}
+
+	var result CopyObjectResult
+	if err := xml.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !result.LastModified.Equal(local.Truncate(time.Millisecond)) {
+		t.Errorf("Expected %v after a round trip, got %v", local.Truncate(time.Millisecond), result.LastModified)
+	}
+}
+
+func TestResponseDates(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := store.PutObject(ctx, "bucket", "object", strings.NewReader("data"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUpload("bucket", "upload", storage.Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	if _, err := store.UploadPart(ctx, "bucket", "upload", uploadID, 1, strings.NewReader("part"), ""); err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	handler := NewS3Handler(store)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s failed with %d: %s", req.Method, req.URL, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	copyReq := httptest.NewRequest(http.MethodPut, "/bucket/copy", nil)
+	copyReq.Header.Set("x-amz-copy-source", "/bucket/object")
+	partCopyReq := httptest.NewRequest(http.MethodPut, "/bucket/upload?partNumber=2&uploadId="+uploadID, nil)
+	partCopyReq.Header.Set("x-amz-copy-source", "/bucket/object")
+
+	iso8601 := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$`)
+	dates := regexp.MustCompile(`<(LastModified|Initiated|CreationDate)>([^<]*)<`)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil),
+		httptest.NewRequest(http.MethodGet, "/bucket", nil),
+		httptest.NewRequest(http.MethodGet, "/bucket?list-type=2", nil),
+		httptest.NewRequest(http.MethodGet, "/bucket?uploads", nil),
+		httptest.NewRequest(http.MethodGet, "/bucket/upload?uploadId="+uploadID, nil),
+		copyReq,
+		partCopyReq,
+	} {
+		body := serve(req).Body.String()
+		matches := dates.FindAllStringSubmatch(body, -1)
+		if len(matches) == 0 {
+			t.Errorf("Expected dates in the response to %s %s, got %s", req.Method, req.URL, body)
+		}
+		for _, match := range matches {
+			if !iso8601.MatchString(match[2]) {
+				t.Errorf("Expected %s of %s %s in ISO 8601 with milliseconds, got %q", match[1], req.Method, req.URL, match[2])
+			}
+		}
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		lastModified := serve(httptest.NewRequest(method, "/bucket/object", nil)).Header().Get("Last-Modified")
+		if _, err := time.Parse(http.TimeFormat, lastModified); err != nil {
+			t.Errorf("Expected the Last-Modified header of %s in RFC 1123 GMT, got %q", method, lastModified)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// unconfiguredError is the error S3 returns on the GET of a bucket configuration that is not set
+type unconfiguredError struct {
+	code    string
+	message string
+}
+
+// unconfiguredBucketErrors maps the unimplemented bucket subresources whose GET fails when they are not set
+// to the error S3 returns, which SDKs and tools such as Terraform special-case to mean "not configured"
+// s3d never stores these configurations, so the GET of an existing bucket always returns it
+// The subresources of configurations kept by ID only fail like this when asked for an ID,
+// listing them stays unimplemented
+var unconfiguredBucketErrors = map[string]unconfiguredError{
+	"analytics":           {"NoSuchConfiguration", "The specified configuration does not exist."},
+	"cors":                {"NoSuchCORSConfiguration", "The CORS configuration does not exist"},
+	"encryption":          {"ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found"},
+	"intelligent-tiering": {"NoSuchConfiguration", "The specified configuration does not exist."},
+	"inventory":           {"NoSuchConfiguration", "The specified configuration does not exist."},
+	"lifecycle":           {"NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist"},
+	"metrics":             {"NoSuchConfiguration", "The specified configuration does not exist."},
+	"object-lock":         {"ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket"},
+	"policy":              {"NoSuchBucketPolicy", "The bucket policy does not exist"},
+	"policyStatus":        {"NoSuchBucketPolicy", "The bucket policy does not exist"},
+	"replication":         {"ReplicationConfigurationNotFoundError", "The replication configuration was not found"},
+	"tagging":             {"NoSuchTagSet", "The TagSet does not exist"},
+}
+
+// unconfiguredBucketResponses maps the unimplemented bucket subresources whose GET succeeds when they
+// are not set to the empty configuration S3 returns
+var unconfiguredBucketResponses = map[string]any{
+	"logging":      BucketLoggingStatus{},
+	"notification": NotificationConfiguration{},
+	"versioning":   VersioningConfiguration{},
+}
+
+// emptyBucketConfigurations maps the subresources of unconfigured bucket configurations that fail
+// on GET to the root element of the empty configuration returned instead with EmptyConfigurations
+// Policies are JSON documents that S3 rejects without statements, so they keep failing
+var emptyBucketConfigurations = map[string]string{
+	"cors":         "CORSConfiguration",
+	"encryption":   "ServerSideEncryptionConfiguration",
+	"lifecycle":    "LifecycleConfiguration",
+	"object-lock":  "ObjectLockConfiguration",
+	"policyStatus": "PolicyStatus",
+	"replication":  "ReplicationConfiguration",
+	"tagging":      "Tagging",
+}
+
+// emptyConfiguration is a bucket configuration without any element
+type emptyConfiguration struct {
+	XMLName xml.Name
+}
+
+// idBucketSubresources are the subresources of bucket configurations kept by ID
+var idBucketSubresources = map[string]bool{
+	"analytics":           true,
+	"intelligent-tiering": true,
+	"inventory":           true,
+	"metrics":             true,
+}
+
+// unconfiguredSubresource returns the unimplemented bucket subresource whose GET the request is,
+// if S3 answers it for a bucket without that configuration
+func unconfiguredSubresource(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := unimplementedBucketSubresources[name]; !ok {
+			continue
+		}
+		if idBucketSubresources[name] && !query.Has("id") {
+			return "", false
+		}
+		_, isError := unconfiguredBucketErrors[name]
+		_, isResponse := unconfiguredBucketResponses[name]
+		return name, isError || isResponse
+	}
+	return "", false
+}
+
+// handleGetUnconfigured answers the GET of a bucket configuration s3d does not store as S3 does
+// for a bucket without it
+func (s *S3Handler) handleGetUnconfigured(w http.ResponseWriter, r *http.Request, bucket, subresource string) {
+	if !s.storage.BucketExists(bucket) {
+		s.errorResponse(w, r, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if s.profile.UnsupportedConfigurations && idBucketSubresources[subresource] {
+		s.errorResponse(w, r, "NotImplemented", fmt.Sprintf("Get%s is not implemented", unimplementedBucketSubresources[subresource]), http.StatusNotImplemented)
+		return
+	}
+	if response, ok := unconfiguredBucketResponses[subresource]; ok {
+		s.xmlResponse(w, r, response, http.StatusOK)
+		return
+	}
+	if root, ok := emptyBucketConfigurations[subresource]; ok && s.profile.EmptyConfigurations {
+		s.xmlResponse(w, r, emptyConfiguration{XMLName: xml.Name{Local: root}}, http.StatusOK)
+		return
+	}
+	e := unconfiguredBucketErrors[subresource]
+	s.errorResponse(w, r, e.code, e.message, http.StatusNotFound)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestUnconfiguredBucketSubresources(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	handler := NewS3Handler(store)
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/test-bucket?lifecycle", http.StatusNotFound, "NoSuchLifecycleConfiguration"},
+		{http.MethodGet, "/test-bucket?cors", http.StatusNotFound, "NoSuchCORSConfiguration"},
+		{http.MethodGet, "/test-bucket?policy", http.StatusNotFound, "NoSuchBucketPolicy"},
+		{http.MethodGet, "/test-bucket?policyStatus", http.StatusNotFound, "NoSuchBucketPolicy"},
+		{http.MethodGet, "/test-bucket?replication", http.StatusNotFound, "ReplicationConfigurationNotFoundError"},
+		{http.MethodGet, "/test-bucket?encryption", http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError"},
+		{http.MethodGet, "/test-bucket?tagging", http.StatusNotFound, "NoSuchTagSet"},
+		{http.MethodGet, "/test-bucket?object-lock", http.StatusNotFound, "ObjectLockConfigurationNotFoundError"},
+		{http.MethodGet, "/test-bucket?metrics&id=all", http.StatusNotFound, "NoSuchConfiguration"},
+		{http.MethodHead, "/test-bucket?lifecycle", http.StatusNotFound, ""},
+		{http.MethodGet, "/test-bucket?versioning", http.StatusOK, ""},
+		{http.MethodGet, "/test-bucket?logging", http.StatusOK, ""},
+		{http.MethodGet, "/test-bucket?notification", http.StatusOK, ""},
+		// The bucket is checked first
+		{http.MethodGet, "/missing-bucket?lifecycle", http.StatusNotFound, "NoSuchBucket"},
+		{http.MethodGet, "/missing-bucket?versioning", http.StatusNotFound, "NoSuchBucket"},
+		// Writes and listings stay unimplemented
+		{http.MethodPut, "/test-bucket?lifecycle", http.StatusNotImplemented, "NotImplemented"},
+		{http.MethodGet, "/test-bucket?metrics", http.StatusNotImplemented, "NotImplemented"},
+		{http.MethodGet, "/test-bucket?acl", http.StatusNotImplemented, "NotImplemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+			var errResp Error
+			if err := xml.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if errResp.Code != tt.code {
+				t.Errorf("Expected code %s, got %s", tt.code, errResp.Code)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test-bucket?versioning", nil))
+	var versioning VersioningConfiguration
+	if err := xml.Unmarshal(rec.Body.Bytes(), &versioning); err != nil || versioning.Status != "" {
+		t.Errorf("Expected a versioning configuration without status, got %+v (%v): %s", versioning, err, rec.Body.String())
+	}
+}
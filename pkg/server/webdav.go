@@ -0,0 +1,515 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVHandler serves buckets and objects over WebDAV, for clients without S3 support
+// such as Windows Explorer and macOS Finder
+// Buckets are the top-level collections and the slashes of object keys separate nested ones,
+// which exist as long as keys start with their prefix or as directory objects
+type WebDAVHandler struct {
+	storage  *storage.Storage
+	dav      *webdav.Handler
+	readOnly bool
+}
+
+// WebDAVOption is a functional option for configuring WebDAVHandler
+type WebDAVOption func(*WebDAVHandler)
+
+// WithWebDAVReadOnly rejects every request that could change data
+func WithWebDAVReadOnly(enabled bool) WebDAVOption {
+	return func(h *WebDAVHandler) {
+		h.readOnly = enabled
+	}
+}
+
+// NewWebDAVHandler creates a new WebDAV handler
+// Locks are held in memory, so they do not survive restarts, which WebDAV clients cope with
+func NewWebDAVHandler(storage *storage.Storage, opts ...WebDAVOption) *WebDAVHandler {
+	h := &WebDAVHandler{
+		storage: storage,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.dav = &webdav.Handler{
+		FileSystem: &davFS{storage: storage},
+		LockSystem: webdav.NewMemLS(),
+	}
+	return h
+}
+
+// ServeHTTP handles WebDAV requests
+func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+
+	if (h.readOnly || h.storage.IsReadReplica()) && !isDAVRead(r.Method) {
+		http.Error(w, "The server is read-only", http.StatusForbidden)
+		return
+	}
+
+	// The WebDAV handler stores whatever was written once the upload is closed,
+	// so a body cut short must be seen by the file to abort the upload
+	if r.Method == http.MethodPut {
+		body := &uploadBody{ReadCloser: r.Body}
+		r.Body = body
+		r = r.WithContext(context.WithValue(r.Context(), uploadBodyKey{}, body))
+	}
+	h.dav.ServeHTTP(w, r)
+}
+
+// isDAVRead reports whether a WebDAV method only reads
+func isDAVRead(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+	return false
+}
+
+// uploadBodyKey is the context key of the body of a PUT request
+type uploadBodyKey struct{}
+
+// uploadBody records the error reading the body of a PUT request
+type uploadBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// davFS maps WebDAV paths onto buckets and objects
+type davFS struct {
+	storage *storage.Storage
+}
+
+// splitDAVPath returns the bucket and object key of a WebDAV path,
+// the key empty for a bucket and both empty for the root
+func splitDAVPath(name string) (bucket, key string) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	bucket, key, _ = strings.Cut(name, "/")
+	return bucket, key
+}
+
+// davInfo describes a bucket, directory or object
+type davInfo struct {
+	name        string
+	size        int64
+	dir         bool
+	modTime     time.Time
+	etag        string
+	contentType string
+}
+
+var (
+	_ webdav.ETager       = (*davInfo)(nil)
+	_ webdav.ContentTyper = (*davInfo)(nil)
+)
+
+func (i *davInfo) Name() string       { return i.name }
+func (i *davInfo) Size() int64        { return i.size }
+func (i *davInfo) ModTime() time.Time { return i.modTime }
+func (i *davInfo) IsDir() bool        { return i.dir }
+func (i *davInfo) Sys() any           { return nil }
+
+func (i *davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag returns the ETag of the object, so that clients see the same one as over S3
+func (i *davInfo) ETag(ctx context.Context) (string, error) {
+	if i.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fmt.Sprintf("%q", i.etag), nil
+}
+
+// ContentType returns the stored content type of the object
+func (i *davInfo) ContentType(ctx context.Context) (string, error) {
+	if i.contentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.contentType, nil
+}
+
+// objectInfo describes an object
+func objectInfo(name string, obj *storage.ObjectInfo) *davInfo {
+	return &davInfo{
+		name:        name,
+		size:        obj.Size,
+		modTime:     obj.ModTime,
+		etag:        obj.ETag,
+		contentType: obj.Metadata.ContentType,
+	}
+}
+
+// Stat describes the root, a bucket, a directory if keys start with the key followed by a slash,
+// or else an object
+func (f *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	bucket, key := splitDAVPath(name)
+	if bucket == "" {
+		return &davInfo{name: "/", dir: true}, nil
+	}
+	created, err := f.storage.GetBucketCreationDate(bucket)
+	if err != nil {
+		return nil, davError(err)
+	}
+	if key == "" {
+		return &davInfo{name: bucket, dir: true, modTime: created}, nil
+	}
+
+	objects, prefixes, err := f.storage.ListObjects(bucket, key+"/", "/", "", 1)
+	if err != nil {
+		return nil, davError(err)
+	}
+	if len(objects) != 0 || len(prefixes) != 0 {
+		info := &davInfo{name: path.Base(key), dir: true, modTime: created}
+		if len(objects) != 0 && objects[0].Key == key+"/" {
+			info.modTime = objects[0].ModTime
+		}
+		return info, nil
+	}
+
+	objects, _, err = f.storage.ListObjects(bucket, key, "", "", 1)
+	if err != nil {
+		return nil, davError(err)
+	}
+	if len(objects) == 0 || objects[0].Key != key {
+		return nil, os.ErrNotExist
+	}
+	return objectInfo(path.Base(key), &objects[0]), nil
+}
+
+// OpenFile opens a bucket or directory for listing, an object for reading,
+// or an object for writing, which stores it when it is closed
+// Objects can only be written as a whole, so writes must truncate them
+func (f *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	bucket, key := splitDAVPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if key == "" || flag&os.O_TRUNC == 0 {
+			return nil, os.ErrPermission
+		}
+		if !f.storage.BucketExists(bucket) {
+			return nil, os.ErrNotExist
+		}
+		return newDAVWriter(ctx, f.storage, bucket, key), nil
+	}
+
+	info, err := f.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &davDir{fs: f, bucket: bucket, key: key, info: info}, nil
+	}
+	reader, _, err := f.storage.GetObject(bucket, key)
+	if err != nil {
+		return nil, davError(err)
+	}
+	return &davReader{ReadSeekCloser: reader, info: info}, nil
+}
+
+// Mkdir creates a bucket at the top level, or else the directory object of a directory
+func (f *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	bucket, key := splitDAVPath(name)
+	if bucket == "" {
+		return os.ErrExist
+	}
+	if key == "" {
+		return davError(f.storage.CreateBucket(bucket))
+	}
+
+	if _, err := f.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// Like MKCOL, the parent directory must exist
+	parent, err := f.Stat(ctx, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return os.ErrNotExist
+	}
+	_, err = f.storage.PutObject(ctx, bucket, key+"/", strings.NewReader(""), storage.Metadata{}, "")
+	return davError(err)
+}
+
+// RemoveAll deletes an object, or every object of a directory or bucket
+// A bucket is only deleted once all of its objects are, so WORM protected objects keep theirs
+func (f *davFS) RemoveAll(ctx context.Context, name string) error {
+	bucket, key := splitDAVPath(name)
+	if bucket == "" {
+		return os.ErrPermission
+	}
+	info, err := f.Stat(ctx, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return davError(f.storage.DeleteObject(bucket, key))
+	}
+
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+	var deleteErr error
+	err = f.storage.DeletePrefix(ctx, bucket, prefix, func(key string, err error) {
+		if err != nil && deleteErr == nil {
+			deleteErr = err
+		}
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	if err != nil || key != "" {
+		return davError(err)
+	}
+	return davError(f.storage.DeleteBucket(bucket))
+}
+
+// Rename moves an object, or every object of a directory one by one, so a failure may leave a directory
+// partly moved
+// Buckets cannot be renamed, nor objects moved to the top level
+func (f *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	srcBucket, srcKey := splitDAVPath(oldName)
+	dstBucket, dstKey := splitDAVPath(newName)
+	if srcKey == "" || dstKey == "" {
+		return os.ErrPermission
+	}
+	if !f.storage.BucketExists(dstBucket) {
+		return os.ErrNotExist
+	}
+	info, err := f.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return f.move(srcBucket, srcKey, dstBucket, dstKey)
+	}
+
+	objects, _, err := f.storage.ListObjects(srcBucket, srcKey+"/", "", "", 0)
+	if err != nil {
+		return davError(err)
+	}
+	// Renaming an object takes the keys nested in its directory along, so the objects of
+	// a directory are copied, which shares their content, and deleted one by one instead
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f.copyDelete(srcBucket, obj.Key, dstBucket, dstKey+strings.TrimPrefix(obj.Key, srcKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move moves an object, renaming it within a bucket
+func (f *davFS) move(srcBucket, srcKey, dstBucket, dstKey string) error {
+	if srcBucket == dstBucket {
+		return davError(f.storage.RenameObject(srcBucket, srcKey, dstKey))
+	}
+	return f.copyDelete(srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// copyDelete moves an object by copying and deleting it
+func (f *davFS) copyDelete(srcBucket, srcKey, dstBucket, dstKey string) error {
+	if _, err := f.storage.CopyObject(srcBucket, srcKey, dstBucket, dstKey, nil); err != nil {
+		return davError(err)
+	}
+	return davError(f.storage.DeleteObject(srcBucket, srcKey))
+}
+
+// davDir is an open bucket or directory, or the root listing the buckets
+type davDir struct {
+	fs     *davFS
+	bucket string
+	key    string
+	info   os.FileInfo
+	// entries are loaded on the first Readdir and returned from pos on
+	entries []os.FileInfo
+	pos     int
+}
+
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+func (d *davDir) Close() error                                 { return nil }
+
+// Readdir returns the next count entries, or all remaining ones if count <= 0
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.entries == nil {
+		entries, err := d.list()
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+
+	rest := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	d.pos += len(rest)
+	return rest, nil
+}
+
+// list returns the entries of the directory
+// A key that is both an object and the prefix of other keys is listed as a directory
+func (d *davDir) list() ([]os.FileInfo, error) {
+	if d.bucket == "" {
+		buckets, err := d.fs.storage.ListBuckets("", "", 0)
+		if err != nil {
+			return nil, davError(err)
+		}
+		entries := make([]os.FileInfo, 0, len(buckets))
+		for _, b := range buckets {
+			entries = append(entries, &davInfo{name: b.Name, dir: true, modTime: b.CreationDate})
+		}
+		return entries, nil
+	}
+
+	prefix := ""
+	if d.key != "" {
+		prefix = d.key + "/"
+	}
+	objects, prefixes, err := d.fs.storage.ListObjects(d.bucket, prefix, "/", "", 0)
+	if err != nil {
+		return nil, davError(err)
+	}
+
+	dirs := make(map[string]bool, len(prefixes))
+	entries := make([]os.FileInfo, 0, len(objects)+len(prefixes))
+	for _, p := range prefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/")
+		if name == "" {
+			continue
+		}
+		dirs[name] = true
+		entries = append(entries, &davInfo{name: name, dir: true, modTime: d.info.ModTime()})
+	}
+	for i, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		// The directory object of the directory itself
+		if name == "" || dirs[name] {
+			continue
+		}
+		entries = append(entries, objectInfo(name, &objects[i]))
+	}
+	return entries, nil
+}
+
+// davReader is an object open for reading
+type davReader struct {
+	io.ReadSeekCloser
+	info os.FileInfo
+}
+
+func (r *davReader) Write(p []byte) (int, error)              { return 0, os.ErrPermission }
+func (r *davReader) Readdir(count int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (r *davReader) Stat() (os.FileInfo, error)               { return r.info, nil }
+
+// davWriter is an object open for writing
+// Written data streams into the object, which is stored once the writer is closed
+type davWriter struct {
+	ctx  context.Context
+	name string
+	pw   *io.PipeWriter
+	size int64
+	// done receives the result of storing the object
+	done chan error
+}
+
+// newDAVWriter starts storing the object written through the returned writer
+func newDAVWriter(ctx context.Context, s *storage.Storage, bucket, key string) *davWriter {
+	pr, pw := io.Pipe()
+	w := &davWriter{
+		ctx:  ctx,
+		name: path.Base(key),
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		metadata := storage.Metadata{ContentType: mime.TypeByExtension(path.Ext(key))}
+		_, err := s.PutObject(ctx, bucket, key, pr, metadata, "")
+		pr.CloseWithError(err)
+		w.done <- davError(err)
+	}()
+	return w
+}
+
+func (w *davWriter) Write(p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *davWriter) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (w *davWriter) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (w *davWriter) Readdir(count int) ([]os.FileInfo, error)     { return nil, os.ErrInvalid }
+
+// Stat describes the data written so far
+func (w *davWriter) Stat() (os.FileInfo, error) {
+	return &davInfo{name: w.name, size: w.size, modTime: time.Now()}, nil
+}
+
+// Close stores the object, unless the body of the request writing it was cut short
+func (w *davWriter) Close() error {
+	if body, ok := w.ctx.Value(uploadBodyKey{}).(*uploadBody); ok && body.err != nil {
+		w.pw.CloseWithError(body.err)
+		<-w.done
+		return body.err
+	}
+	w.pw.Close()
+	return <-w.done
+}
+
+// davError maps storage errors to the os errors the WebDAV handler turns into status codes
+func davError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrObjectNotFound), errors.Is(err, storage.ErrBucketNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, storage.ErrBucketAlreadyExists), errors.Is(err, storage.ErrObjectAlreadyExists):
+		return os.ErrExist
+	case errors.Is(err, storage.ErrObjectLocked), errors.Is(err, storage.ErrBucketFrozen), errors.Is(err, storage.ErrReadReplica):
+		return os.ErrPermission
+	case errors.Is(err, storage.ErrInvalidBucketName), errors.Is(err, storage.ErrInvalidObjectKey):
+		return os.ErrInvalid
+	default:
+		return err
+	}
+}
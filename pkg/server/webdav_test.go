@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestWebDAVHandler(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	srv := httptest.NewServer(NewWebDAVHandler(store))
+	defer srv.Close()
+
+	do := func(method, path, body string, header map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	expect := func(method, path, body string, header map[string]string, status int) string {
+		t.Helper()
+		code, data := do(method, path, body, header)
+		if code != status {
+			t.Fatalf("Expected %s %s to return %d, got %d: %s", method, path, status, code, data)
+		}
+		return data
+	}
+	read := func(bucket, key string) string {
+		t.Helper()
+		reader, _, err := store.GetObject(bucket, key)
+		if err != nil {
+			t.Fatalf("GetObject %s/%s failed: %v", bucket, key, err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		return string(data)
+	}
+
+	// Collections at the top level are buckets, nested ones directories
+	expect("MKCOL", "/docs", "", nil, http.StatusCreated)
+	if !store.BucketExists("docs") {
+		t.Fatal("Expected MKCOL at the top level to create a bucket")
+	}
+	expect("MKCOL", "/docs/notes", "", nil, http.StatusCreated)
+	expect("MKCOL", "/docs/notes", "", nil, http.StatusMethodNotAllowed)
+	expect("MKCOL", "/docs/missing/notes", "", nil, http.StatusConflict)
+	expect("MKCOL", "/missing/notes", "", nil, http.StatusConflict)
+
+	expect(http.MethodPut, "/docs/notes/todo.txt", "write tests", nil, http.StatusCreated)
+	expect(http.MethodPut, "/missing/todo.txt", "write tests", nil, http.StatusConflict)
+	if got := read("docs", "notes/todo.txt"); got != "write tests" {
+		t.Errorf("Expected the uploaded object, got %q", got)
+	}
+	_, info, err := store.GetObject("docs", "notes/todo.txt")
+	if err != nil || info.Metadata.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("Expected the content type of the extension, got %+v (%v)", info, err)
+	}
+	if got := expect(http.MethodGet, "/docs/notes/todo.txt", "", nil, http.StatusOK); got != "write tests" {
+		t.Errorf("Expected GET to return the object, got %q", got)
+	}
+
+	listing := expect("PROPFIND", "/docs/", "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	if !strings.Contains(listing, "<D:href>/docs/notes/</D:href>") {
+		t.Errorf("Expected the directory to be listed, got %s", listing)
+	}
+	listing = expect("PROPFIND", "/docs/notes/todo.txt", "", map[string]string{"Depth": "0"}, http.StatusMultiStatus)
+	if !strings.Contains(listing, "<D:getcontentlength>11</D:getcontentlength>") || !strings.Contains(listing, `<D:getetag>"`+info.ETag+`"</D:getetag>`) {
+		t.Errorf("Expected the size and ETag of the object, got %s", listing)
+	}
+	listing = expect("PROPFIND", "/", "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	if !strings.Contains(listing, "<D:href>/docs/</D:href>") {
+		t.Errorf("Expected the bucket to be listed, got %s", listing)
+	}
+
+	// Directories are moved object by object, across buckets too
+	expect("MOVE", "/docs/notes/todo.txt", "", map[string]string{"Destination": srv.URL + "/docs/notes/done.txt"}, http.StatusCreated)
+	expect("COPY", "/docs/notes", "", map[string]string{"Destination": srv.URL + "/docs/copy"}, http.StatusCreated)
+	expect("MOVE", "/docs/notes", "", map[string]string{"Destination": srv.URL + "/docs/archive"}, http.StatusCreated)
+	if got := read("docs", "archive/done.txt"); got != "write tests" {
+		t.Errorf("Expected the moved object, got %q", got)
+	}
+	if got := read("docs", "copy/done.txt"); got != "write tests" {
+		t.Errorf("Expected the copied object, got %q", got)
+	}
+	expect(http.MethodGet, "/docs/notes/done.txt", "", nil, http.StatusNotFound)
+	expect("MKCOL", "/other", "", nil, http.StatusCreated)
+	expect("MOVE", "/docs/archive", "", map[string]string{"Destination": srv.URL + "/other/archive"}, http.StatusCreated)
+	if got := read("other", "archive/done.txt"); got != "write tests" {
+		t.Errorf("Expected the object moved to the other bucket, got %q", got)
+	}
+	expect("MOVE", "/other", "", map[string]string{"Destination": srv.URL + "/renamed"}, http.StatusForbidden)
+
+	expect(http.MethodDelete, "/docs/copy", "", nil, http.StatusNoContent)
+	if objects, _, _ := store.ListObjects("docs", "copy/", "", "", 0); len(objects) != 0 {
+		t.Errorf("Expected the directory to be deleted, got %d objects", len(objects))
+	}
+	expect(http.MethodDelete, "/other", "", nil, http.StatusNoContent)
+	if store.BucketExists("other") {
+		t.Error("Expected the bucket to be deleted")
+	}
+
+	t.Run("CutShortUpload", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/docs/partial.txt", io.MultiReader(strings.NewReader("partial"), errReader{}))
+		rec := httptest.NewRecorder()
+		NewWebDAVHandler(store).ServeHTTP(rec, req)
+		if rec.Code == http.StatusCreated {
+			t.Errorf("Expected the upload to fail, got %d", rec.Code)
+		}
+		if _, _, err := store.GetObject("docs", "partial.txt"); err != storage.ErrObjectNotFound {
+			t.Errorf("Expected nothing to be stored, got %v", err)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		if _, err := store.PutObject(context.Background(), "docs", "readme.txt", strings.NewReader("read me"), storage.Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		handler := NewWebDAVHandler(store, WithWebDAVReadOnly(true))
+		for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "LOCK"} {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "/docs/readme.txt", strings.NewReader("changed")))
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected %s to be rejected, got %d", method, rec.Code)
+			}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/readme.txt", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "read me" {
+			t.Errorf("Expected GET to be served, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// errReader fails every read
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
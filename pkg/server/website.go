@@ -0,0 +1,284 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+// WebsiteHandler serves buckets with a website configuration as static websites
+type WebsiteHandler struct {
+	storage  *storage.Storage
+	compress bool
+}
+
+// WebsiteOption is a functional option for configuring WebsiteHandler
+type WebsiteOption func(*WebsiteHandler)
+
+// WithWebsiteCompression enables on-the-fly gzip/deflate compression of compressible pages
+func WithWebsiteCompression(enabled bool) WebsiteOption {
+	return func(h *WebsiteHandler) {
+		h.compress = enabled
+	}
+}
+
+// NewWebsiteHandler creates a new static website handler
+func NewWebsiteHandler(storage *storage.Storage, opts ...WebsiteOption) *WebsiteHandler {
+	h := &WebsiteHandler{
+		storage: storage,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP handles website requests
+// The bucket is taken from the Host header when a bucket with that name exists,
+// otherwise from the first path segment (path-style)
+func (h *WebsiteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		websiteErrorResponse(w, r, "MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket, key := h.resolveBucket(r)
+	if bucket == "" {
+		websiteErrorResponse(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	config, err := h.storage.GetBucketWebsite(bucket)
+	if err != nil {
+		switch err {
+		case storage.ErrBucketNotFound:
+			websiteErrorResponse(w, r, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		case storage.ErrWebsiteNotFound:
+			websiteErrorResponse(w, r, "NoSuchWebsiteConfiguration", "The specified bucket does not have a website configuration", http.StatusNotFound)
+		default:
+			websiteErrorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Website requests are anonymous, so they are denied once the bucket restricts public access
+	if !h.allowsPublicAccess(bucket) {
+		websiteErrorResponse(w, r, "AccessDenied", "Access Denied", http.StatusForbidden)
+		return
+	}
+
+	if config.RedirectAllRequestsTo != nil {
+		protocol := config.RedirectAllRequestsTo.Protocol
+		if protocol == "" {
+			protocol = "http"
+		}
+		http.Redirect(w, r, protocol+"://"+config.RedirectAllRequestsTo.HostName+"/"+key, http.StatusMovedPermanently)
+		return
+	}
+
+	// Routing rules without an error code condition apply before the object is looked up
+	if rule := matchRoutingRule(config.RoutingRules, key, 0); rule != nil {
+		websiteRedirect(w, r, bucket, key, rule)
+		return
+	}
+
+	// Requests for the root or a "directory" are served with the index document
+	objectKey := key
+	if objectKey == "" || strings.HasSuffix(objectKey, "/") {
+		objectKey += config.IndexDocumentSuffix
+	}
+
+	reader, info, err := h.storage.GetObject(bucket, objectKey)
+	if err == nil {
+		defer reader.Close()
+		// Locations are validated when they are set, objects imported with an invalid one are served instead
+		if location := info.Metadata.WebsiteRedirectLocation; location != "" && validRedirectLocation(location) {
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+		setMetadataHeaders(w, info.Metadata)
+		serveContent(w, r, objectKey, info.ModTime, info.Size, reader, h.compress)
+		return
+	}
+	if err != storage.ErrObjectNotFound && err != storage.ErrInvalidObjectKey {
+		websiteErrorResponse(w, r, "InternalError", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A key without trailing slash that names a directory with an index document
+	// is redirected to the directory, like S3 does
+	if key != "" && !strings.HasSuffix(key, "/") {
+		if reader, _, err := h.storage.GetObject(bucket, key+"/"+config.IndexDocumentSuffix); err == nil {
+			reader.Close()
+			http.Redirect(w, r, websiteLocation(r, bucket, key+"/"), http.StatusFound)
+			return
+		}
+	}
+
+	if rule := matchRoutingRule(config.RoutingRules, key, http.StatusNotFound); rule != nil {
+		websiteRedirect(w, r, bucket, key, rule)
+		return
+	}
+
+	h.serveErrorDocument(w, r, bucket, config, "NoSuchKey", "The specified key does not exist.", http.StatusNotFound)
+}
+
+// allowsPublicAccess reports whether the public access block of the bucket, if any,
+// neither blocks public policies nor restricts access to public buckets
+func (h *WebsiteHandler) allowsPublicAccess(bucket string) bool {
+	config, err := h.storage.GetPublicAccessBlock(bucket)
+	if err == storage.ErrPublicAccessBlockNotFound {
+		return true
+	}
+	return err == nil && !config.BlockPublicPolicy && !config.RestrictPublicBuckets
+}
+
+// resolveBucket determines the bucket and key of a website request
+func (h *WebsiteHandler) resolveBucket(r *http.Request) (string, string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if host != "" && h.storage.BucketExists(host) {
+		return host, path
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// isRedirectCode reports whether code is an HTTP redirect status code
+func isRedirectCode(code string) bool {
+	status, err := strconv.Atoi(code)
+	return err == nil && status >= 300 && status < 400
+}
+
+// matchRoutingRule returns the first routing rule matching the key
+// With errorCode 0 only rules without an error code condition are considered,
+// otherwise only rules whose error code condition equals errorCode
+func matchRoutingRule(rules []storage.WebsiteRoutingRule, key string, errorCode int) *storage.WebsiteRoutingRule {
+	for i, rule := range rules {
+		var prefix, code string
+		if rule.Condition != nil {
+			prefix = rule.Condition.KeyPrefixEquals
+			code = rule.Condition.HttpErrorCodeReturnedEquals
+		}
+
+		if errorCode == 0 {
+			if code != "" {
+				continue
+			}
+		} else if code != strconv.Itoa(errorCode) {
+			continue
+		}
+
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		return &rules[i]
+	}
+	return nil
+}
+
+// websiteRedirect redirects the request as described by the routing rule
+func websiteRedirect(w http.ResponseWriter, r *http.Request, bucket, key string, rule *storage.WebsiteRoutingRule) {
+	redirect := rule.Redirect
+
+	newKey := key
+	if redirect.ReplaceKeyWith != "" {
+		newKey = redirect.ReplaceKeyWith
+	} else if redirect.ReplaceKeyPrefixWith != "" {
+		var prefix string
+		if rule.Condition != nil {
+			prefix = rule.Condition.KeyPrefixEquals
+		}
+		newKey = redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, prefix)
+	}
+
+	// Codes are validated when the configuration is set, anything but a redirect falls back to 301
+	status := http.StatusMovedPermanently
+	if isRedirectCode(redirect.HttpRedirectCode) {
+		status, _ = strconv.Atoi(redirect.HttpRedirectCode)
+	}
+
+	var location string
+	if redirect.HostName != "" || redirect.Protocol != "" {
+		protocol := redirect.Protocol
+		if protocol == "" {
+			protocol = "http"
+			if r.TLS != nil {
+				protocol = "https"
+			}
+		}
+		host := redirect.HostName
+		if host == "" {
+			host = r.Host
+		}
+		location = protocol + "://" + host + "/" + newKey
+	} else {
+		location = websiteLocation(r, bucket, newKey)
+	}
+
+	w.Header().Set("Location", location)
+	w.WriteHeader(status)
+}
+
+// websiteLocation builds the redirect location for a key, keeping the path-style
+// bucket prefix when the request was not addressed by host
+func websiteLocation(r *http.Request, bucket, key string) string {
+	if strings.HasPrefix(r.URL.Path, "/"+bucket+"/") || r.URL.Path == "/"+bucket {
+		return "/" + bucket + "/" + key
+	}
+	return "/" + key
+}
+
+// serveErrorDocument serves the configured error document with the given status,
+// falling back to the default error page when no error document is available
+func (h *WebsiteHandler) serveErrorDocument(w http.ResponseWriter, r *http.Request, bucket string, config *storage.WebsiteConfiguration, code, message string, status int) {
+	if config.ErrorDocumentKey == "" {
+		websiteErrorResponse(w, r, code, message, status)
+		return
+	}
+
+	reader, info, err := h.storage.GetObject(bucket, config.ErrorDocumentKey)
+	if err != nil {
+		websiteErrorResponse(w, r, code, message, status)
+		return
+	}
+	defer reader.Close()
+
+	setMetadataHeaders(w, info.Metadata)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, reader)
+}
+
+// websiteErrorResponse writes an HTML error page, as website endpoints do not return XML errors
+func websiteErrorResponse(w http.ResponseWriter, r *http.Request, code, message string, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	fmt.Fprintf(w, "<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n<li>Code: %s</li>\n<li>Message: %s</li>\n</ul>\n</body>\n</html>\n",
+		html.EscapeString(title), html.EscapeString(title), html.EscapeString(code), html.EscapeString(message))
+}
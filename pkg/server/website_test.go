@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wzshiming/s3d/pkg/storage"
+)
+
+func TestWebsiteHandler(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucket := "site"
+	if err := store.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	objects := map[string]string{
+		"index.html":      "root index",
+		"docs/index.html": "docs index",
+		"page.html":       "page",
+		"404.html":        "not found page",
+	}
+	for key, content := range objects {
+		_, err := store.PutObject(context.Background(), bucket, key, strings.NewReader(content), storage.Metadata{ContentType: "text/html"}, "")
+		if err != nil {
+			t.Fatalf("Failed to put object %s: %v", key, err)
+		}
+	}
+
+	handler := NewWebsiteHandler(store)
+
+	// Without a website configuration the bucket is not served
+	req := httptest.NewRequest(http.MethodGet, "/site/page.html", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "NoSuchWebsiteConfiguration") {
+		t.Fatalf("Expected NoSuchWebsiteConfiguration, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	err = store.PutBucketWebsite(bucket, storage.WebsiteConfiguration{
+		IndexDocumentSuffix: "index.html",
+		ErrorDocumentKey:    "404.html",
+	})
+	if err != nil {
+		t.Fatalf("Failed to put website configuration: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		method           string
+		host             string
+		path             string
+		expectedStatus   int
+		expectedBody     string
+		expectedLocation string
+	}{
+		{
+			name:           "RootIndex",
+			method:         http.MethodGet,
+			path:           "/site/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "root index",
+		},
+		{
+			name:           "BucketWithoutSlash",
+			method:         http.MethodGet,
+			path:           "/site",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "root index",
+		},
+		{
+			name:           "DirectoryIndex",
+			method:         http.MethodGet,
+			path:           "/site/docs/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "docs index",
+		},
+		{
+			name:             "DirectoryRedirect",
+			method:           http.MethodGet,
+			path:             "/site/docs",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "/site/docs/",
+		},
+		{
+			name:           "Object",
+			method:         http.MethodGet,
+			path:           "/site/page.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "page",
+		},
+		{
+			name:           "ErrorDocument",
+			method:         http.MethodGet,
+			path:           "/site/missing.html",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found page",
+		},
+		{
+			name:           "VirtualHost",
+			method:         http.MethodGet,
+			host:           "site:8080",
+			path:           "/page.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "page",
+		},
+		{
+			name:             "VirtualHostDirectoryRedirect",
+			method:           http.MethodGet,
+			host:             "site",
+			path:             "/docs",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "/docs/",
+		},
+		{
+			name:           "MethodNotAllowed",
+			method:         http.MethodPut,
+			path:           "/site/page.html",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "NoSuchBucket",
+			method:         http.MethodGet,
+			path:           "/nonexistent/page.html",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "NoSuchBucket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			body, _ := io.ReadAll(rec.Body)
+			if tt.expectedBody != "" && !strings.Contains(string(body), tt.expectedBody) {
+				t.Errorf("Expected body to contain %q, got %q", tt.expectedBody, string(body))
+			}
+			if tt.expectedLocation != "" && rec.Header().Get("Location") != tt.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tt.expectedLocation, rec.Header().Get("Location"))
+			}
+		})
+	}
+
+	t.Run("PublicAccessBlock", func(t *testing.T) {
+		for _, config := range []storage.PublicAccessBlock{
+			{BlockPublicPolicy: true},
+			{RestrictPublicBuckets: true},
+		} {
+			if err := store.PutPublicAccessBlock(bucket, config); err != nil {
+				t.Fatalf("Failed to put public access block: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/site/page.html", nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "page") {
+				t.Errorf("Expected %+v to deny website access, got %d: %s", config, rec.Code, rec.Body.String())
+			}
+		}
+
+		// Blocking public ACLs alone leaves public policies serving the website in place
+		if err := store.PutPublicAccessBlock(bucket, storage.PublicAccessBlock{BlockPublicAcls: true}); err != nil {
+			t.Fatalf("Failed to put public access block: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/site/page.html", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if err := store.DeletePublicAccessBlock(bucket); err != nil {
+			t.Fatalf("Failed to delete public access block: %v", err)
+		}
+	})
+
+	t.Run("RedirectAllRequests", func(t *testing.T) {
+		err := store.PutBucketWebsite(bucket, storage.WebsiteConfiguration{
+			RedirectAllRequestsTo: &storage.WebsiteRedirect{HostName: "example.com", Protocol: "https"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to put website configuration: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/site/page.html", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently {
+			t.Fatalf("Expected status 301, got %d", rec.Code)
+		}
+		if location := rec.Header().Get("Location"); location != "https://example.com/page.html" {
+			t.Fatalf("Unexpected Location %q", location)
+		}
+	})
+}
+
+func TestWebsiteRoutingRules(t *testing.T) {
+	store, err := storage.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucket := "site"
+	if err := store.CreateBucket(bucket); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucket, "index.html", strings.NewReader("index"), storage.Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	_, err = store.PutObject(context.Background(), bucket, "moved.html", strings.NewReader(""), storage.Metadata{
+		WebsiteRedirectLocation: "/index.html",
+	}, "")
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	// Stored without going through the validation of PutObject, as imports do
+	_, err = store.PutObject(context.Background(), bucket, "invalid.html", strings.NewReader("invalid"), storage.Metadata{
+		WebsiteRedirectLocation: "javascript:alert(1)",
+	}, "")
+	if err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	err = store.PutBucketWebsite(bucket, storage.WebsiteConfiguration{
+		IndexDocumentSuffix: "index.html",
+		RoutingRules: []storage.WebsiteRoutingRule{
+			{
+				Condition: &storage.WebsiteRoutingCondition{KeyPrefixEquals: "docs/"},
+				Redirect:  storage.WebsiteRedirect{ReplaceKeyPrefixWith: "documents/"},
+			},
+			{
+				Condition: &storage.WebsiteRoutingCondition{KeyPrefixEquals: "old/"},
+				Redirect:  storage.WebsiteRedirect{ReplaceKeyPrefixWith: "new/", HttpRedirectCode: "200"},
+			},
+			{
+				Condition: &storage.WebsiteRoutingCondition{HttpErrorCodeReturnedEquals: "404"},
+				Redirect:  storage.WebsiteRedirect{HostName: "example.com", Protocol: "https", HttpRedirectCode: "302", ReplaceKeyWith: "not-found.html"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put website configuration: %v", err)
+	}
+
+	handler := NewWebsiteHandler(store)
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "KeyPrefixRedirect",
+			path:             "/site/docs/guide.html",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/site/documents/guide.html",
+		},
+		{
+			name:             "ErrorCodeRedirect",
+			path:             "/site/missing.html",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/not-found.html",
+		},
+		{
+			name:             "ObjectRedirect",
+			path:             "/site/moved.html",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/index.html",
+		},
+		{
+			name:           "InvalidObjectRedirect",
+			path:           "/site/invalid.html",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "InvalidRedirectCode",
+			path:             "/site/old/page.html",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/site/new/page.html",
+		},
+		{
+			name:           "NoRedirect",
+			path:           "/site/index.html",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if location := rec.Header().Get("Location"); location != tt.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tt.expectedLocation, location)
+			}
+		})
+	}
+}
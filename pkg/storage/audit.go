@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// auditDir holds one append-only audit log per bucket
+// It is kept apart from the bucket so the trail survives the deletion of the bucket
+const auditDir = ".audit"
+
+// AuditEvent records a change made to an object
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Key       string    `json:"key"`
+	// Source is the source of copies and renames, as bucket/key
+	Source string `json:"source,omitempty"`
+	// AccessKeyID is the access key the request was signed with, empty for anonymous requests
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	RemoteAddr  string `json:"remoteAddr,omitempty"`
+	RequestID   string `json:"requestId,omitempty"`
+}
+
+// auditLogPath returns the path of the audit log of a bucket
+func (s *Storage) auditLogPath(bucket string) (string, error) {
+	if err := sanitizeBucketName(bucket); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.basePath, auditDir, bucket+".log"), nil
+}
+
+// AppendAuditEvent appends an event to the audit log of a bucket
+func (s *Storage) AppendAuditEvent(bucket string, event AuditEvent) error {
+	path, err := s.auditLogPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.auditMut.Lock()
+	defer s.auditMut.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ListAuditEvents returns the audit events of a bucket within [start, end), in the order they were recorded
+// A zero start or end leaves that side of the range open
+func (s *Storage) ListAuditEvents(bucket string, start, end time.Time) ([]AuditEvent, error) {
+	path, err := s.auditLogPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// Skip a partially written last line
+			continue
+		}
+		if !start.IsZero() && event.Time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && !event.Time.Before(end) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditEvents(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-audit"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, op := range []string{"PutObject", "CopyObject", "DeleteObject"} {
+		event := AuditEvent{
+			Time:        base.Add(time.Duration(i) * time.Hour),
+			Operation:   op,
+			Key:         "object",
+			AccessKeyID: "test-key",
+		}
+		if err := store.AppendAuditEvent(bucketName, event); err != nil {
+			t.Fatalf("AppendAuditEvent failed: %v", err)
+		}
+	}
+
+	events, err := store.ListAuditEvents(bucketName, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents failed: %v", err)
+	}
+	if len(events) != 3 || events[0].Operation != "PutObject" || events[2].Operation != "DeleteObject" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	events, err = store.ListAuditEvents(bucketName, base.Add(time.Hour), base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("ListAuditEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Operation != "CopyObject" {
+		t.Fatalf("Expected only the CopyObject event in range, got %+v", events)
+	}
+
+	// The audit trail outlives the bucket
+	if err := store.DeleteBucket(bucketName); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	events, err = store.ListAuditEvents(bucketName, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Errorf("Expected audit events to survive bucket deletion, got %d", len(events))
+	}
+
+	if _, err := store.ListAuditEvents("../escape", time.Time{}, time.Time{}); err == nil {
+		t.Error("Expected error for invalid bucket name")
+	}
+}
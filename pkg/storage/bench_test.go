@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// newBenchStorage creates a storage in a temporary directory with one bucket
+func newBenchStorage(b *testing.B, bucket string) *Storage {
+	b.Helper()
+	tmpDir, err := os.MkdirTemp("", "storage-bench-*")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	b.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	b.Cleanup(func() { store.Close() })
+
+	if err := store.CreateBucket(bucket); err != nil {
+		b.Fatalf("CreateBucket failed: %v", err)
+	}
+	return store
+}
+
+// uniqueReader returns size bytes starting with seed, so every object has its own content
+// and content addressing does not turn repeated writes into reference count updates
+func uniqueReader(seed uint64, size int64) io.Reader {
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], seed)
+	if size < int64(len(header)) {
+		return io.LimitReader(zeroReader{}, size)
+	}
+	return io.MultiReader(bytes.NewReader(header[:]), io.LimitReader(zeroReader{}, size-int64(len(header))))
+}
+
+func BenchmarkPutObject(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int64
+	}{
+		{"1KB", 1 << 10},
+		{"64KB", 64 << 10},
+		{"1MB", 1 << 20},
+		{"16MB", 16 << 20},
+		{"256MB", 256 << 20},
+		{"1GB", 1 << 30},
+	}
+	for _, size := range sizes {
+		b.Run(size.name, func(b *testing.B) {
+			store := newBenchStorage(b, "bench-bucket")
+			b.SetBytes(size.size)
+			b.ReportAllocs()
+			var seed uint64
+			for b.Loop() {
+				seed++
+				key := fmt.Sprintf("object-%d", seed)
+				if _, err := store.PutObject(context.Background(), "bench-bucket", key, uniqueReader(seed, size.size), Metadata{}, ""); err != nil {
+					b.Fatalf("PutObject failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int64
+	}{
+		{"1KB", 1 << 10},
+		{"1MB", 1 << 20},
+	}
+	for _, size := range sizes {
+		b.Run(size.name, func(b *testing.B) {
+			store := newBenchStorage(b, "bench-bucket")
+			if _, err := store.PutObject(context.Background(), "bench-bucket", "object", uniqueReader(0, size.size), Metadata{}, ""); err != nil {
+				b.Fatalf("PutObject failed: %v", err)
+			}
+
+			b.SetBytes(size.size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					reader, _, err := store.GetObject("bench-bucket", "object")
+					if err != nil {
+						b.Errorf("GetObject failed: %v", err)
+						return
+					}
+					if _, err := io.Copy(io.Discard, reader); err != nil {
+						b.Errorf("Read failed: %v", err)
+					}
+					reader.Close()
+				}
+			})
+		})
+	}
+}
+
+// listBenchKeys is the number of objects of the bucket listed by BenchmarkListObjects
+const listBenchKeys = 100000
+
+func BenchmarkListObjects(b *testing.B) {
+	store := newBenchStorage(b, "bench-bucket")
+	for i := range listBenchKeys {
+		// Spread the keys over 100 directories like a typical date or tenant layout
+		key := fmt.Sprintf("dir-%02d/object-%06d", i%100, i)
+		if _, err := store.PutObject(context.Background(), "bench-bucket", key, uniqueReader(uint64(i), 16), Metadata{}, ""); err != nil {
+			b.Fatalf("PutObject failed: %v", err)
+		}
+	}
+
+	b.Run("FirstPage", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := store.ListObjects("bench-bucket", "", "", "", 1001); err != nil {
+				b.Fatalf("ListObjects failed: %v", err)
+			}
+		}
+	})
+	b.Run("LastPage", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := store.ListObjects("bench-bucket", "", "", "dir-99/object-0", 1001); err != nil {
+				b.Fatalf("ListObjects failed: %v", err)
+			}
+		}
+	})
+	b.Run("Delimiter", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := store.ListObjects("bench-bucket", "", "/", "", 1001); err != nil {
+				b.Fatalf("ListObjects failed: %v", err)
+			}
+		}
+	})
+	b.Run("All", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := store.ListObjects("bench-bucket", "", "", "", 0); err != nil {
+				b.Fatalf("ListObjects failed: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompleteMultipartUpload(b *testing.B) {
+	const parts = 10
+	const partSize = 5 << 20
+	store := newBenchStorage(b, "bench-bucket")
+
+	b.SetBytes(parts * partSize)
+	b.ReportAllocs()
+	var seed uint64
+	for b.Loop() {
+		b.StopTimer()
+		key := fmt.Sprintf("object-%d", seed)
+		uploadID, err := store.InitiateMultipartUpload("bench-bucket", key, Metadata{}, "")
+		if err != nil {
+			b.Fatalf("InitiateMultipartUpload failed: %v", err)
+		}
+		var completed []Multipart
+		for partNumber := 1; partNumber <= parts; partNumber++ {
+			seed++
+			part, err := store.UploadPart(context.Background(), "bench-bucket", key, uploadID, partNumber, uniqueReader(seed, partSize), "")
+			if err != nil {
+				b.Fatalf("UploadPart failed: %v", err)
+			}
+			completed = append(completed, Multipart{PartNumber: partNumber, ETag: part.ETag})
+		}
+		b.StartTimer()
+
+		if _, err := store.CompleteMultipartUpload(context.Background(), "bench-bucket", key, uploadID, completed, ""); err != nil {
+			b.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+	}
+}
+
+// TestListObjectsFirstPageAllocs guards against listings going back to reading the whole bucket for a page
+func TestListObjectsFirstPageAllocs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for i := range 2000 {
+		key := fmt.Sprintf("dir-%02d/object-%04d", i%20, i)
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, uniqueReader(uint64(i), 16), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+
+	page := testing.AllocsPerRun(5, func() {
+		store.ListObjects("test-bucket", "", "", "", 11)
+	})
+	all := testing.AllocsPerRun(5, func() {
+		store.ListObjects("test-bucket", "", "", "", 0)
+	})
+	if page*5 > all {
+		t.Errorf("Expected a page of 10 keys to cost a fraction of listing 2000 keys, got %.0f allocations against %.0f", page, all)
+	}
+}
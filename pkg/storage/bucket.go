@@ -2,11 +2,19 @@ package storage
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // CreateBucket creates a new bucket
 func (s *Storage) CreateBucket(bucket string) error {
+	return s.createBucket(bucket, &bucketMetadata{})
+}
+
+// createBucket creates a new bucket with the initial metadata
+// The metadata is saved before the bucket directory is created, so the bucket never exists without it
+func (s *Storage) createBucket(bucket string, metadata *bucketMetadata) error {
 	bucketPath, err := s.safePath(bucket, "")
 	if err != nil {
 		return err
@@ -16,7 +24,20 @@ func (s *Storage) CreateBucket(bucket string) error {
 		return ErrBucketAlreadyExists
 	}
 
-	return os.MkdirAll(bucketPath, 0755)
+	metadata.CreationDate = time.Now().UTC()
+	metaPath := s.bucketMetaPath(bucket)
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return err
+	}
+	if err := saveBucketMetadata(metaPath, metadata); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(bucketPath, 0755); err != nil {
+		os.RemoveAll(filepath.Dir(metaPath))
+		return err
+	}
+	return nil
 }
 
 // DeleteBucket deletes a bucket
@@ -30,6 +51,22 @@ func (s *Storage) DeleteBucket(bucket string) error {
 		return ErrBucketNotFound
 	}
 
+	if err := s.checkFrozen(bucket); err != nil {
+		return err
+	}
+
+	// The trash goes with the bucket, so that a new bucket of the same name starts without one
+	if err := s.emptyTrash(bucket); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.basePath, bucketsDir, bucket)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(s.basePath, locksDir, bucket)); err != nil {
+		return err
+	}
+
 	return os.RemoveAll(bucketPath)
 }
 
@@ -65,8 +102,8 @@ func (s *Storage) ListBuckets(prefix, continuationToken string, maxBuckets int)
 			continue
 		}
 		buckets = append(buckets, BucketInfo{
-			Name:    name,
-			ModTime: info.ModTime(),
+			Name:         name,
+			CreationDate: s.bucketCreationDate(name, info),
 		})
 
 		// Stop if we've reached maxBuckets (fetch one extra to determine if truncated)
@@ -77,6 +114,53 @@ func (s *Storage) ListBuckets(prefix, continuationToken string, maxBuckets int)
 	return buckets, nil
 }
 
+// GetBucketCreationDate returns when a bucket was created
+func (s *Storage) GetBucketCreationDate(bucket string) (time.Time, error) {
+	bucketPath, err := s.safePath(bucket, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(bucketPath)
+	if err != nil || !info.IsDir() {
+		return time.Time{}, ErrBucketNotFound
+	}
+	return s.bucketCreationDate(bucket, info), nil
+}
+
+// bucketCreationDate returns the creation date recorded in the bucket metadata,
+// falling back to the modification time of the bucket directory if none is recorded
+// The directory changes whenever objects are added or removed at the top of the bucket,
+// so the fallback only serves buckets the layout migration has not reached
+func (s *Storage) bucketCreationDate(bucket string, dirInfo os.FileInfo) time.Time {
+	metadata, err := loadBucketMetadata(s.bucketMetaPath(bucket))
+	if err == nil && metadata != nil && !metadata.CreationDate.IsZero() {
+		return metadata.CreationDate
+	}
+	return dirInfo.ModTime()
+}
+
+// migrateBucketCreationDates records the modification time of the directory of every bucket
+// without a creation date as its creation date, the best estimate left for buckets created
+// before creation dates were recorded
+func migrateBucketCreationDates(s *Storage, progress func(done, total int)) error {
+	buckets, err := s.ListBuckets("", "", 0)
+	if err != nil {
+		return err
+	}
+	for i, bucket := range buckets {
+		err := s.updateBucketMetadata(bucket.Name, func(metadata *bucketMetadata) {
+			if metadata.CreationDate.IsZero() {
+				metadata.CreationDate = bucket.CreationDate.UTC()
+			}
+		})
+		if err != nil {
+			return err
+		}
+		progress(i+1, len(buckets))
+	}
+	return nil
+}
+
 // BucketExists checks if a bucket exists
 func (s *Storage) BucketExists(bucket string) bool {
 	bucketPath, err := s.safePath(bucket, "")
@@ -87,3 +171,160 @@ func (s *Storage) BucketExists(bucket string) bool {
 	info, err := os.Stat(bucketPath)
 	return err == nil && info.IsDir()
 }
+
+// bucketMetaPath returns the path to the bucket metadata file
+// Bucket metadata lives outside the bucket directory so it can't collide with object keys
+func (s *Storage) bucketMetaPath(bucket string) string {
+	return filepath.Join(s.basePath, bucketsDir, bucket, metaFile)
+}
+
+// getBucketMetadata loads the bucket metadata
+// Returns empty metadata if the bucket has no configuration yet
+func (s *Storage) getBucketMetadata(bucket string) (*bucketMetadata, error) {
+	if !s.BucketExists(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	metadata, err := loadBucketMetadata(s.bucketMetaPath(bucket))
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = &bucketMetadata{}
+	}
+	return metadata, nil
+}
+
+// updateBucketMetadata loads the bucket metadata, applies fn and saves the result
+// Updates of the same bucket are serialized, so concurrent changes of different settings are all kept
+func (s *Storage) updateBucketMetadata(bucket string, fn func(metadata *bucketMetadata)) error {
+	metaPath := s.bucketMetaPath(bucket)
+	unlock := s.bucketMetas.lock(metaPath)
+	defer unlock()
+
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return err
+	}
+
+	fn(metadata)
+
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return err
+	}
+	return saveBucketMetadata(metaPath, metadata)
+}
+
+// PutBucketOwnershipControls sets the object ownership setting of a bucket
+func (s *Storage) PutBucketOwnershipControls(bucket, objectOwnership string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.ObjectOwnership = objectOwnership
+	})
+}
+
+// GetBucketOwnershipControls returns the object ownership setting of a bucket
+func (s *Storage) GetBucketOwnershipControls(bucket string) (string, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return "", err
+	}
+	if metadata.ObjectOwnership == "" {
+		return "", ErrOwnershipControlsNotFound
+	}
+	return metadata.ObjectOwnership, nil
+}
+
+// DeleteBucketOwnershipControls removes the ownership controls of a bucket
+func (s *Storage) DeleteBucketOwnershipControls(bucket string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.ObjectOwnership = ""
+	})
+}
+
+// PutPublicAccessBlock sets the public access block configuration of a bucket
+func (s *Storage) PutPublicAccessBlock(bucket string, config PublicAccessBlock) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.PublicAccessBlock = &config
+	})
+}
+
+// GetPublicAccessBlock returns the public access block configuration of a bucket
+func (s *Storage) GetPublicAccessBlock(bucket string) (*PublicAccessBlock, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.PublicAccessBlock == nil {
+		return nil, ErrPublicAccessBlockNotFound
+	}
+	return metadata.PublicAccessBlock, nil
+}
+
+// DeletePublicAccessBlock removes the public access block configuration of a bucket
+func (s *Storage) DeletePublicAccessBlock(bucket string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.PublicAccessBlock = nil
+	})
+}
+
+// PutBucketWebsite sets the static website hosting configuration of a bucket
+func (s *Storage) PutBucketWebsite(bucket string, config WebsiteConfiguration) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.Website = &config
+	})
+}
+
+// GetBucketWebsite returns the static website hosting configuration of a bucket
+func (s *Storage) GetBucketWebsite(bucket string) (*WebsiteConfiguration, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Website == nil {
+		return nil, ErrWebsiteNotFound
+	}
+	return metadata.Website, nil
+}
+
+// DeleteBucketWebsite removes the static website hosting configuration of a bucket
+func (s *Storage) DeleteBucketWebsite(bucket string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.Website = nil
+	})
+}
+
+// PutBucketAccelerateConfiguration sets the transfer acceleration status of a bucket
+func (s *Storage) PutBucketAccelerateConfiguration(bucket, status string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.AccelerateStatus = status
+	})
+}
+
+// GetBucketAccelerateConfiguration returns the transfer acceleration status of a bucket
+// Returns an empty status if acceleration has never been configured
+func (s *Storage) GetBucketAccelerateConfiguration(bucket string) (string, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return "", err
+	}
+	return metadata.AccelerateStatus, nil
+}
+
+// PutBucketRequestPayment sets who pays for requests to a bucket
+func (s *Storage) PutBucketRequestPayment(bucket, payer string) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.RequestPayer = payer
+	})
+}
+
+// GetBucketRequestPayment returns who pays for requests to a bucket
+func (s *Storage) GetBucketRequestPayment(bucket string) (string, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return "", err
+	}
+	if metadata.RequestPayer == "" {
+		return "BucketOwner", nil
+	}
+	return metadata.RequestPayer, nil
+}
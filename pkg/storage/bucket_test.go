@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestBucketOperations(t *testing.T) {
@@ -130,3 +135,396 @@ func TestDeleteNonexistentBucket(t *testing.T) {
 		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
 	}
 }
+
+func TestBucketOwnershipControls(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	// No ownership controls configured yet
+	if _, err := store.GetBucketOwnershipControls(bucketName); err != ErrOwnershipControlsNotFound {
+		t.Fatalf("Expected ErrOwnershipControlsNotFound, got %v", err)
+	}
+
+	if err := store.PutBucketOwnershipControls(bucketName, "BucketOwnerEnforced"); err != nil {
+		t.Fatalf("PutBucketOwnershipControls failed: %v", err)
+	}
+
+	ownership, err := store.GetBucketOwnershipControls(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketOwnershipControls failed: %v", err)
+	}
+	if ownership != "BucketOwnerEnforced" {
+		t.Fatalf("Expected BucketOwnerEnforced, got %q", ownership)
+	}
+
+	// Bucket metadata must not show up as an object
+	objects, _, err := store.ListObjects(bucketName, "", "", "", 0)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(objects) != 0 {
+		t.Fatalf("Expected no objects, got %d", len(objects))
+	}
+
+	if err := store.DeleteBucketOwnershipControls(bucketName); err != nil {
+		t.Fatalf("DeleteBucketOwnershipControls failed: %v", err)
+	}
+	if _, err := store.GetBucketOwnershipControls(bucketName); err != ErrOwnershipControlsNotFound {
+		t.Fatalf("Expected ErrOwnershipControlsNotFound after delete, got %v", err)
+	}
+
+	// Configuration must not survive bucket deletion
+	if err := store.PutBucketOwnershipControls(bucketName, "ObjectWriter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetBucketOwnershipControls(bucketName); err != ErrOwnershipControlsNotFound {
+		t.Fatalf("Expected ErrOwnershipControlsNotFound for recreated bucket, got %v", err)
+	}
+
+	if err := store.PutBucketOwnershipControls("nonexistent", "ObjectWriter"); err != ErrBucketNotFound {
+		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestPublicAccessBlock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetPublicAccessBlock(bucketName); err != ErrPublicAccessBlockNotFound {
+		t.Fatalf("Expected ErrPublicAccessBlockNotFound, got %v", err)
+	}
+
+	config := PublicAccessBlock{
+		BlockPublicAcls:   true,
+		BlockPublicPolicy: true,
+	}
+	if err := store.PutPublicAccessBlock(bucketName, config); err != nil {
+		t.Fatalf("PutPublicAccessBlock failed: %v", err)
+	}
+
+	got, err := store.GetPublicAccessBlock(bucketName)
+	if err != nil {
+		t.Fatalf("GetPublicAccessBlock failed: %v", err)
+	}
+	if *got != config {
+		t.Fatalf("Expected %+v, got %+v", config, *got)
+	}
+
+	// Other bucket configuration must be preserved
+	if err := store.PutBucketOwnershipControls(bucketName, "ObjectWriter"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetPublicAccessBlock(bucketName); err != nil || *got != config {
+		t.Fatalf("Public access block lost after updating ownership controls: %+v, %v", got, err)
+	}
+
+	if err := store.DeletePublicAccessBlock(bucketName); err != nil {
+		t.Fatalf("DeletePublicAccessBlock failed: %v", err)
+	}
+	if _, err := store.GetPublicAccessBlock(bucketName); err != ErrPublicAccessBlockNotFound {
+		t.Fatalf("Expected ErrPublicAccessBlockNotFound after delete, got %v", err)
+	}
+}
+
+func TestConcurrentBucketMetadataUpdates(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates of different settings racing each other must all be kept
+	config := PublicAccessBlock{RestrictPublicBuckets: true}
+	for i := 0; i < 20; i++ {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := store.PutPublicAccessBlock(bucketName, config); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := store.PutBucketOwnershipControls(bucketName, "ObjectWriter"); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if got, err := store.GetPublicAccessBlock(bucketName); err != nil || *got != config {
+			t.Fatalf("Public access block lost: %+v, %v", got, err)
+		}
+		if got, err := store.GetBucketOwnershipControls(bucketName); err != nil || got != "ObjectWriter" {
+			t.Fatalf("Ownership controls lost: %q, %v", got, err)
+		}
+		if err := store.DeletePublicAccessBlock(bucketName); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteBucketOwnershipControls(bucketName); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBucketWebsite(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetBucketWebsite(bucketName); err != ErrWebsiteNotFound {
+		t.Fatalf("Expected ErrWebsiteNotFound, got %v", err)
+	}
+
+	config := WebsiteConfiguration{
+		IndexDocumentSuffix: "index.html",
+		ErrorDocumentKey:    "error.html",
+	}
+	if err := store.PutBucketWebsite(bucketName, config); err != nil {
+		t.Fatalf("PutBucketWebsite failed: %v", err)
+	}
+
+	got, err := store.GetBucketWebsite(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketWebsite failed: %v", err)
+	}
+	if got.IndexDocumentSuffix != "index.html" || got.ErrorDocumentKey != "error.html" || got.RedirectAllRequestsTo != nil {
+		t.Fatalf("Unexpected website configuration: %+v", got)
+	}
+
+	if err := store.DeleteBucketWebsite(bucketName); err != nil {
+		t.Fatalf("DeleteBucketWebsite failed: %v", err)
+	}
+	if _, err := store.GetBucketWebsite(bucketName); err != ErrWebsiteNotFound {
+		t.Fatalf("Expected ErrWebsiteNotFound after delete, got %v", err)
+	}
+}
+
+func TestBucketAccelerateConfiguration(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := store.GetBucketAccelerateConfiguration(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketAccelerateConfiguration failed: %v", err)
+	}
+	if status != "" {
+		t.Fatalf("Expected empty status, got %q", status)
+	}
+
+	if err := store.PutBucketAccelerateConfiguration(bucketName, "Enabled"); err != nil {
+		t.Fatalf("PutBucketAccelerateConfiguration failed: %v", err)
+	}
+	status, err = store.GetBucketAccelerateConfiguration(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketAccelerateConfiguration failed: %v", err)
+	}
+	if status != "Enabled" {
+		t.Fatalf("Expected Enabled, got %q", status)
+	}
+
+	if _, err := store.GetBucketAccelerateConfiguration("nonexistent"); err != ErrBucketNotFound {
+		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestBucketRequestPayment(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatal(err)
+	}
+
+	payer, err := store.GetBucketRequestPayment(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketRequestPayment failed: %v", err)
+	}
+	if payer != "BucketOwner" {
+		t.Fatalf("Expected default payer BucketOwner, got %q", payer)
+	}
+
+	if err := store.PutBucketRequestPayment(bucketName, "Requester"); err != nil {
+		t.Fatalf("PutBucketRequestPayment failed: %v", err)
+	}
+	payer, err = store.GetBucketRequestPayment(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketRequestPayment failed: %v", err)
+	}
+	if payer != "Requester" {
+		t.Fatalf("Expected Requester, got %q", payer)
+	}
+}
+
+func TestBucketCreationDate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	before := time.Now()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	created, err := store.GetBucketCreationDate("test-bucket")
+	if err != nil {
+		t.Fatalf("GetBucketCreationDate failed: %v", err)
+	}
+	if created.Before(before.Add(-time.Second)) || created.After(time.Now()) {
+		t.Errorf("Expected the creation date to be the time of CreateBucket, got %v", created)
+	}
+
+	// Writes change the modification time of the bucket directory but not the creation date
+	if _, err := store.PutObject(context.Background(), "test-bucket", "object", strings.NewReader("data"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpDir, "test-bucket"), later, later); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if got, _ := store.GetBucketCreationDate("test-bucket"); !got.Equal(created) {
+		t.Errorf("Expected creation date %v, got %v", created, got)
+	}
+	buckets, err := store.ListBuckets("", "", 0)
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %v", err)
+	}
+	if len(buckets) != 1 || !buckets[0].CreationDate.Equal(created) {
+		t.Errorf("Expected ListBuckets to return creation date %v, got %+v", created, buckets)
+	}
+
+	if _, err := store.GetBucketCreationDate("missing-bucket"); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestMigrateBucketCreationDates(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.CreateBucket("legacy-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// A bucket created before creation dates were recorded, in a directory of the first layout
+	if err := os.RemoveAll(filepath.Join(tmpDir, bucketsDir, "legacy-bucket")); err != nil {
+		t.Fatalf("Failed to remove bucket metadata: %v", err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(tmpDir, "legacy-bucket"), modTime, modTime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if err := store.saveLayout(&layoutState{Version: 1}); err != nil {
+		t.Fatalf("Failed to save layout: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	// The migration keeps the modification time the directory had at upgrade
+	later := time.Now()
+	if err := os.Chtimes(filepath.Join(tmpDir, "legacy-bucket"), later, later); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	created, err := store.GetBucketCreationDate("legacy-bucket")
+	if err != nil {
+		t.Fatalf("GetBucketCreationDate failed: %v", err)
+	}
+	if !created.Equal(modTime) {
+		t.Errorf("Expected creation date %v, got %v", modTime, created)
+	}
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// locksDir holds the advisory locks of buckets, one directory per bucket
+// Every lock is a file named by the hash of its name, so that any name maps to a single file
+const locksDir = ".locks"
+
+// BucketLock is an advisory lock on a name in a bucket, held until it is released or expires
+// This is an s3d extension for clients coordinating on a bucket, such as Terraform state locking
+type BucketLock struct {
+	Name string
+	// Token identifies the holder, renewing or releasing the lock requires it
+	Token string
+	// Info is set by the holder to tell others who holds the lock
+	Info       string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+// expired reports whether the lock is no longer held at now
+func (l *BucketLock) expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// AcquireLock takes the lock name of a bucket for ttl, failing with ErrLockHeld and the current lock
+// while another holder has it
+// Only the lock returned to its holder carries the token
+func (s *Storage) AcquireLock(bucket, name, info string, ttl time.Duration) (*BucketLock, error) {
+	lockPath, err := s.lockPath(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.commits.lock(lockPath)
+	defer unlock()
+
+	now := time.Now().UTC()
+	existing, err := loadBucketLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && !existing.expired(now) {
+		existing.Token = ""
+		return existing, ErrLockHeld
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	lock := &BucketLock{
+		Name:       name,
+		Token:      token,
+		Info:       info,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := saveBucketLock(lockPath, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// RenewLock extends the lock name of a bucket held with token to ttl from now
+// A lock that expired may have been taken by another holder, so it is not renewed
+func (s *Storage) RenewLock(bucket, name, token string, ttl time.Duration) (*BucketLock, error) {
+	lockPath, err := s.lockPath(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.commits.lock(lockPath)
+	defer unlock()
+
+	now := time.Now().UTC()
+	lock, err := s.heldLock(lockPath, token, now)
+	if err != nil {
+		return nil, err
+	}
+	lock.ExpiresAt = now.Add(ttl)
+	if err := saveBucketLock(lockPath, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ReleaseLock releases the lock name of a bucket held with token
+func (s *Storage) ReleaseLock(bucket, name, token string) error {
+	lockPath, err := s.lockPath(bucket, name)
+	if err != nil {
+		return err
+	}
+	unlock := s.commits.lock(lockPath)
+	defer unlock()
+
+	if _, err := s.heldLock(lockPath, token, time.Now()); err != nil {
+		return err
+	}
+	return os.Remove(lockPath)
+}
+
+// GetLock returns the lock name of a bucket without its token, ErrLockNotFound if nobody holds it
+func (s *Storage) GetLock(bucket, name string) (*BucketLock, error) {
+	lockPath, err := s.lockPath(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := loadBucketLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil || lock.expired(time.Now()) {
+		return nil, ErrLockNotFound
+	}
+	lock.Token = ""
+	return lock, nil
+}
+
+// heldLock returns the lock at lockPath if it is held with token at now
+func (s *Storage) heldLock(lockPath, token string, now time.Time) (*BucketLock, error) {
+	lock, err := loadBucketLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil || lock.expired(now) {
+		return nil, ErrLockNotFound
+	}
+	if lock.Token != token {
+		lock.Token = ""
+		return lock, ErrLockHeld
+	}
+	return lock, nil
+}
+
+// lockPath returns the file of the lock name of a bucket, which must exist
+func (s *Storage) lockPath(bucket, name string) (string, error) {
+	if !s.BucketExists(bucket) {
+		return "", ErrBucketNotFound
+	}
+	if name == "" {
+		return "", ErrLockNotFound
+	}
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(s.basePath, locksDir, bucket, hex.EncodeToString(sum[:])), nil
+}
+
+// newLockToken returns a random lock token
+func newLockToken() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token[:]), nil
+}
+
+// saveBucketLock atomically replaces the lock at path
+func saveBucketLock(path string, lock *BucketLock) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(lock); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadBucketLock loads the lock at path, nil if it does not exist
+func loadBucketLock(path string) (*BucketLock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lock BucketLock
+	if err := gob.NewDecoder(file).Decode(&lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
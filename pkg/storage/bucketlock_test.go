@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBucketLock(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	if _, err := store.AcquireLock("missing-bucket", "state", "", time.Minute); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := store.GetLock("test-bucket", "state"); err != ErrLockNotFound {
+		t.Errorf("Expected ErrLockNotFound, got %v", err)
+	}
+
+	lock, err := store.AcquireLock("test-bucket", "state", "alice", time.Minute)
+	if err != nil || lock.Token == "" || lock.Info != "alice" {
+		t.Fatalf("Expected the lock to be acquired, got %+v (%v)", lock, err)
+	}
+
+	// Others see the holder but not its token
+	held, err := store.AcquireLock("test-bucket", "state", "bob", time.Minute)
+	if err != ErrLockHeld || held.Info != "alice" || held.Token != "" {
+		t.Errorf("Expected ErrLockHeld with the lock of alice, got %+v (%v)", held, err)
+	}
+	got, err := store.GetLock("test-bucket", "state")
+	if err != nil || got.Info != "alice" || got.Token != "" || !got.ExpiresAt.Equal(lock.ExpiresAt) {
+		t.Errorf("Expected the lock of alice without its token, got %+v (%v)", got, err)
+	}
+	if _, err := store.AcquireLock("test-bucket", "other", "bob", time.Minute); err != nil {
+		t.Errorf("Expected another name to be locked independently, got %v", err)
+	}
+
+	if _, err := store.RenewLock("test-bucket", "state", "wrong", time.Hour); err != ErrLockHeld {
+		t.Errorf("Expected renewing with another token to fail with ErrLockHeld, got %v", err)
+	}
+	renewed, err := store.RenewLock("test-bucket", "state", lock.Token, time.Hour)
+	if err != nil || !renewed.ExpiresAt.After(lock.ExpiresAt) || !renewed.AcquiredAt.Equal(lock.AcquiredAt) {
+		t.Errorf("Expected the lock to be extended, got %+v (%v)", renewed, err)
+	}
+
+	if err := store.ReleaseLock("test-bucket", "state", "wrong"); err != ErrLockHeld {
+		t.Errorf("Expected releasing with another token to fail with ErrLockHeld, got %v", err)
+	}
+	if err := store.ReleaseLock("test-bucket", "state", lock.Token); err != nil {
+		t.Fatalf("ReleaseLock failed: %v", err)
+	}
+	if err := store.ReleaseLock("test-bucket", "state", lock.Token); err != ErrLockNotFound {
+		t.Errorf("Expected releasing twice to fail with ErrLockNotFound, got %v", err)
+	}
+
+	t.Run("Expiry", func(t *testing.T) {
+		lock, err := store.AcquireLock("test-bucket", "expiring", "alice", 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("AcquireLock failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if _, err := store.GetLock("test-bucket", "expiring"); err != ErrLockNotFound {
+			t.Errorf("Expected the expired lock to be gone, got %v", err)
+		}
+		// The expired holder cannot take the lock back from under the next one
+		if _, err := store.RenewLock("test-bucket", "expiring", lock.Token, time.Minute); err != ErrLockNotFound {
+			t.Errorf("Expected renewing an expired lock to fail with ErrLockNotFound, got %v", err)
+		}
+		if _, err := store.AcquireLock("test-bucket", "expiring", "bob", time.Minute); err != nil {
+			t.Errorf("Expected the expired lock to be acquired, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var acquired atomic.Int32
+		var wg sync.WaitGroup
+		for range 16 {
+			wg.Go(func() {
+				if _, err := store.AcquireLock("test-bucket", "contended", "", time.Minute); err == nil {
+					acquired.Add(1)
+				} else if err != ErrLockHeld {
+					t.Errorf("Expected ErrLockHeld, got %v", err)
+				}
+			})
+		}
+		wg.Wait()
+		if n := acquired.Load(); n != 1 {
+			t.Errorf("Expected exactly one holder, got %d", n)
+		}
+	})
+
+	t.Run("DeleteBucket", func(t *testing.T) {
+		if err := store.CreateBucket("short-lived"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := store.AcquireLock("short-lived", "state", "", time.Hour); err != nil {
+			t.Fatalf("AcquireLock failed: %v", err)
+		}
+		if err := store.DeleteBucket("short-lived"); err != nil {
+			t.Fatalf("DeleteBucket failed: %v", err)
+		}
+		if err := store.CreateBucket("short-lived"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := store.GetLock("short-lived", "state"); err != ErrLockNotFound {
+			t.Errorf("Expected the locks to go with the bucket, got %v", err)
+		}
+	})
+}
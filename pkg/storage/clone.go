@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"os"
+)
+
+// cloneFile makes dst share the data of src without copying it, as a hard link or,
+// where the filesystem refuses another link, as a reflink
+// Content-addressed objects and parts are never written in place, so sharing their data is safe
+// It fails when neither is possible, such as across filesystems, and dst is then left absent
+func cloneFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return reflink(src, dst)
+}
+
+// cloneContent clones the content-addressed data of an object into a new temporary file, returning its path,
+// or an empty path if the data is compressed, packed or cannot be shared with another file
+func (s *Storage) cloneContent(metadata *objectMetadata) string {
+	if metadata.Digest == "" || metadata.Compression != "" {
+		return ""
+	}
+	if loc, err := s.packedLocation(metadata.Digest); err != nil || loc != nil {
+		return ""
+	}
+	objPath, err := s.objectPath(metadata.Digest)
+	if err != nil {
+		return ""
+	}
+
+	// Links and reflinks need a path that does not exist yet
+	tmpFile, err := s.contentTempFile()
+	if err != nil {
+		return ""
+	}
+	tmpFile.Close()
+	os.Remove(tmpFile.Name())
+	if err := cloneFile(objPath, tmpFile.Name()); err != nil {
+		return ""
+	}
+	metrics.Add("clones", 1)
+	return tmpFile.Name()
+}
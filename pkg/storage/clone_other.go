@@ -0,0 +1,12 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+)
+
+// reflink is not supported on this platform, only hard links share data
+func reflink(src, dst string) error {
+	return errors.ErrUnsupported
+}
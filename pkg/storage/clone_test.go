@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloneFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	dst := filepath.Join(tmpDir, "dst")
+	if err := cloneFile(src, dst); err != nil {
+		t.Fatalf("cloneFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "content" {
+		t.Errorf("Expected the clone to hold the content, got %q (%v)", data, err)
+	}
+
+	// The destination must not exist yet
+	if err := cloneFile(src, dst); err == nil {
+		t.Error("Expected cloning onto an existing file to fail")
+	}
+	if err := cloneFile(filepath.Join(tmpDir, "missing"), filepath.Join(tmpDir, "other")); err == nil {
+		t.Error("Expected cloning a missing file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "other")); !os.IsNotExist(err) {
+		t.Errorf("Expected a failed clone to leave no file behind, got %v", err)
+	}
+}
+
+func TestUploadPartCopyClone(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	data := strings.Repeat("0123456789", 2*inlineThreshold)
+	srcInfo, err := store.PutObject(context.Background(), bucketName, "src", strings.NewReader(data), Metadata{}, "")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	key := "dst"
+	uploadID, err := store.InitiateMultipartUpload(bucketName, key, Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	whole, err := store.UploadPartCopy(context.Background(), bucketName, key, uploadID, 1, bucketName, "src", -1, -1)
+	if err != nil {
+		t.Fatalf("UploadPartCopy failed: %v", err)
+	}
+	if whole.ETag != srcInfo.ETag || whole.Size != int64(len(data)) {
+		t.Errorf("Expected the part to have the ETag and size of the object, got %s and %d", whole.ETag, whole.Size)
+	}
+	part, err := store.UploadPartCopy(context.Background(), bucketName, key, uploadID, 2, bucketName, "src", 5, 14)
+	if err != nil {
+		t.Fatalf("UploadPartCopy with a range failed: %v", err)
+	}
+
+	// The whole object part shares the data file of the object, the range is copied
+	objectDir, err := store.safePath(bucketName, "src")
+	if err != nil {
+		t.Fatalf("safePath failed: %v", err)
+	}
+	srcMetadata, err := store.loadObjectMetadata(filepath.Join(objectDir, metaFile))
+	if err != nil || srcMetadata == nil {
+		t.Fatalf("Failed to load the metadata of the object: %v", err)
+	}
+	objPath, err := store.objectPath(srcMetadata.Digest)
+	if err != nil {
+		t.Fatalf("objectPath failed: %v", err)
+	}
+	uploadDir := filepath.Join(tmpDir, uploadsDir, bucketName, key, uploadID)
+	objInfo, err := os.Stat(objPath)
+	if err != nil {
+		t.Fatalf("Failed to stat the data file: %v", err)
+	}
+	partInfo, err := os.Stat(filepath.Join(uploadDir, fmt.Sprintf("1-%s", whole.ETag)))
+	if err != nil {
+		t.Fatalf("Failed to stat the part: %v", err)
+	}
+	if !os.SameFile(objInfo, partInfo) {
+		t.Error("Expected the whole object part to be a hard link of the data file")
+	}
+
+	// Deleting the object keeps the data of the part
+	if err := store.DeleteObject(bucketName, "src"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := store.CompleteMultipartUpload(context.Background(), bucketName, key, uploadID, []Multipart{
+		{PartNumber: 1, ETag: whole.ETag},
+		{PartNumber: 2, ETag: part.ETag},
+	}, ""); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	reader, _, err := store.GetObject(bucketName, key)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil || string(got) != data+data[5:15] {
+		t.Errorf("Unexpected content of the completed upload, got %d bytes (%v)", len(got), err)
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// MaxComposeSources is the maximum number of source objects in a single compose request
+const MaxComposeSources = 32
+
+// ComposeObject creates an object by concatenating existing objects of the same bucket in order,
+// without the data leaving the server
+// The sources are copied into a multipart upload that is then completed, so the usual
+// part and object size limits apply. If metadata is nil, the metadata of the first source is used
+// Once ctx is done the copy stops and the upload is aborted
+func (s *Storage) ComposeObject(ctx context.Context, bucket, key string, sources []string, metadata *Metadata) (*ObjectInfo, error) {
+	if len(sources) == 0 || len(sources) > MaxComposeSources {
+		return nil, ErrInvalidComposeSources
+	}
+	if !s.BucketExists(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	var metadataToUse Metadata
+	if metadata != nil {
+		metadataToUse = *metadata
+	} else {
+		srcObjectDir, err := s.safePath(bucket, sources[0])
+		if err != nil {
+			return nil, err
+		}
+		srcMetadata, err := s.loadObjectMetadata(filepath.Join(srcObjectDir, metaFile))
+		if err != nil {
+			return nil, err
+		}
+		if srcMetadata == nil {
+			return nil, ErrObjectNotFound
+		}
+		metadataToUse = srcMetadata.Metadata
+	}
+
+	uploadID, err := s.InitiateMultipartUpload(bucket, key, metadataToUse, "")
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Multipart, 0, len(sources))
+	for i, source := range sources {
+		if err := ctx.Err(); err != nil {
+			s.AbortMultipartUpload(bucket, key, uploadID)
+			return nil, err
+		}
+		partInfo, err := s.UploadPartCopy(ctx, bucket, key, uploadID, i+1, bucket, source, -1, -1)
+		if err != nil {
+			s.AbortMultipartUpload(bucket, key, uploadID)
+			return nil, err
+		}
+		parts = append(parts, Multipart{
+			PartNumber: i + 1,
+			ETag:       partInfo.ETag,
+		})
+	}
+
+	info, err := s.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts, "")
+	if err != nil {
+		s.AbortMultipartUpload(bucket, key, uploadID)
+		return nil, err
+	}
+	return info, nil
+}
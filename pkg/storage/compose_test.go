@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestComposeObject(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-compose"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// Mix inline and content-addressed sources
+	contents := []string{"first log line\n", strings.Repeat("x", 10000), "", "last log line\n"}
+	var sources []string
+	for i, content := range contents {
+		key := "logs/" + string(rune('a'+i))
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(content), Metadata{ContentType: "text/plain"}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		sources = append(sources, key)
+	}
+
+	t.Run("Concatenate", func(t *testing.T) {
+		info, err := store.ComposeObject(context.Background(), bucketName, "logs/all", sources, nil)
+		if err != nil {
+			t.Fatalf("ComposeObject failed: %v", err)
+		}
+
+		expected := strings.Join(contents, "")
+		if info.Size != int64(len(expected)) {
+			t.Errorf("Expected size %d, got %d", len(expected), info.Size)
+		}
+
+		reader, objInfo, err := store.GetObject(bucketName, "logs/all")
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read object: %v", err)
+		}
+		if !bytes.Equal(data, []byte(expected)) {
+			t.Error("Composed content does not match sources")
+		}
+		if objInfo.Metadata.ContentType != "text/plain" {
+			t.Errorf("Expected metadata of first source, got content type %q", objInfo.Metadata.ContentType)
+		}
+
+		uploads, err := store.ListMultipartUploads(bucketName, "", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(uploads) != 0 {
+			t.Errorf("Expected no leftover uploads, got %d", len(uploads))
+		}
+	})
+
+	t.Run("ReplaceMetadata", func(t *testing.T) {
+		info, err := store.ComposeObject(context.Background(), bucketName, "logs/replaced", sources[:1], &Metadata{ContentType: "application/x-ndjson"})
+		if err != nil {
+			t.Fatalf("ComposeObject failed: %v", err)
+		}
+		if info.Metadata.ContentType != "application/x-ndjson" {
+			t.Errorf("Expected replaced content type, got %q", info.Metadata.ContentType)
+		}
+	})
+
+	t.Run("MissingSource", func(t *testing.T) {
+		_, err := store.ComposeObject(context.Background(), bucketName, "logs/missing", []string{sources[0], "logs/nonexistent"}, nil)
+		if err != ErrObjectNotFound {
+			t.Fatalf("Expected ErrObjectNotFound, got %v", err)
+		}
+
+		uploads, err := store.ListMultipartUploads(bucketName, "", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(uploads) != 0 {
+			t.Errorf("Expected failed compose to abort its upload, got %d uploads", len(uploads))
+		}
+	})
+
+	t.Run("SourceCount", func(t *testing.T) {
+		if _, err := store.ComposeObject(context.Background(), bucketName, "logs/none", nil, nil); err != ErrInvalidComposeSources {
+			t.Errorf("Expected ErrInvalidComposeSources for no sources, got %v", err)
+		}
+		tooMany := make([]string, MaxComposeSources+1)
+		for i := range tooMany {
+			tooMany[i] = sources[0]
+		}
+		if _, err := store.ComposeObject(context.Background(), bucketName, "logs/many", tooMany, nil); err != ErrInvalidComposeSources {
+			t.Errorf("Expected ErrInvalidComposeSources for too many sources, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// compressionZstd is the codec new object data is compressed with, version 1 of the zstd seekable format:
+	// independent zstd frames followed by a skippable frame holding the seek table, which zstd tools skip
+	compressionZstd = "zstd/1"
+	// compressFrameSize is the size of the object data in every compressed frame,
+	// the most a range read decompresses beyond what it returns
+	compressFrameSize = 1 << 20
+	// compressMaxRatio is the compressed share of the size past which object data is stored as is
+	compressMaxRatio = 0.9
+
+	// seekTableMagic starts the skippable frame holding the seek table
+	seekTableMagic = 0x184D2A5E
+	// seekableMagic ends the seek table
+	seekableMagic = 0x8F92EAB1
+	// seekTableFooterSize is the size of the end of the seek table: the number of frames,
+	// the descriptor and seekableMagic
+	seekTableFooterSize = 9
+	// seekTableEntrySize is the size of the entry of a frame in the seek table,
+	// its compressed and decompressed sizes
+	seekTableEntrySize = 8
+	// seekTableChecksums flags seek tables whose entries also hold a checksum in the descriptor
+	seekTableChecksums = 0x80
+)
+
+var (
+	// errCorruptCompressed is returned when reading compressed data whose seek table or frames are damaged
+	errCorruptCompressed = errors.New("corrupt compressed object data")
+	// errUnsupportedCompression is returned when reading object data compressed with an unknown codec
+	errUnsupportedCompression = errors.New("unsupported compression codec")
+)
+
+// frameDecoders maps the codecs of the Compression field of object metadata, the frame codec and
+// the version of the frame layout, to the decoder of their frames, appending a frame to dst
+// Object data keeps the codec it was written with, so a later codec is added here
+// without rewriting the data compressed before
+var frameDecoders = map[string]func(frame, dst []byte) ([]byte, error){
+	compressionZstd: func(frame, dst []byte) ([]byte, error) {
+		return zstdDecoder().DecodeAll(frame, dst)
+	},
+}
+
+// zstdEncoder returns the encoder of zstd frames, safe for concurrent use
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	enc, _ := zstd.NewWriter(nil)
+	return enc
+})
+
+// zstdDecoder returns the decoder of zstd frames, safe for concurrent use
+// Frames never decompress to more than compressFrameSize
+var zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(compressFrameSize))
+	return dec
+})
+
+// compressibleTypes lists the non-text media types that benefit from compression
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/rss+xml":       true,
+	"application/atom+xml":      true,
+	"application/wasm":          true,
+	"application/xhtml+xml":     true,
+	"application/xml":           true,
+	"application/x-javascript":  true,
+	"application/x-ndjson":      true,
+	"image/svg+xml":             true,
+}
+
+// IsCompressible reports whether content of the given type is worth compressing
+// Images, archives and other binary formats are usually compressed already
+func IsCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// WithCompression compresses the data of objects with a compressible content type and no content encoding
+// when it is stored, decompressing it transparently on reads, range reads included
+// The data is compressed in the zstd seekable format, independent frames followed by a seek table,
+// so a range read only decompresses the frames it covers, and is stored as is unless compression
+// saves at least a tenth of it
+func WithCompression() Option {
+	return func(s *Storage) {
+		s.compress = true
+	}
+}
+
+// compressContent compresses the object data at srcPath of the given size into a temporary file
+// if compression is enabled and worth it for the metadata, returning its path and the digest
+// of the compressed data, or an empty path to store the data as is
+func (s *Storage) compressContent(srcPath string, size int64, metadata Metadata) (string, string, error) {
+	if !s.compress || size <= inlineThreshold || metadata.ContentEncoding != "" || !IsCompressible(metadata.ContentType) {
+		return "", "", nil
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	tmpFile, err := s.contentTempFile()
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.New()
+	compressedSize, err := writeCompressed(io.MultiWriter(tmpFile, hash), src, int64(compressMaxRatio*float64(size)))
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || compressedSize < 0 {
+		os.Remove(tmpFile.Name())
+		return "", "", err
+	}
+	return tmpFile.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeCompressed writes the data of src to w as zstd frames of compressFrameSize followed
+// by the seek table, returning the compressed size, or -1 once it would exceed limit
+func writeCompressed(w io.Writer, src io.Reader, limit int64) (int64, error) {
+	enc := zstdEncoder()
+	var written int64
+	var entries []byte
+	buf := make([]byte, compressFrameSize)
+	var frame []byte
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			frame = enc.EncodeAll(buf[:n], frame[:0])
+			written += int64(len(frame))
+			if written > limit {
+				return -1, nil
+			}
+			if _, err := w.Write(frame); err != nil {
+				return 0, err
+			}
+			entries = binary.LittleEndian.AppendUint32(entries, uint32(len(frame)))
+			entries = binary.LittleEndian.AppendUint32(entries, uint32(n))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	table := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(entries)+seekTableFooterSize))
+	table = append(table, entries...)
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(entries)/seekTableEntrySize))
+	table = append(table, 0)
+	table = binary.LittleEndian.AppendUint32(table, seekableMagic)
+	if _, err := w.Write(table); err != nil {
+		return 0, err
+	}
+	return written + int64(len(table)), nil
+}
+
+// objectContent is the data of an object held in content-addressed storage
+type objectContent interface {
+	io.ReadSeekCloser
+	// Size returns the size of the object data
+	Size() int64
+}
+
+// openObjectContent opens the content-addressed data of an object for reading, decompressing it if it is compressed
+func (s *Storage) openObjectContent(metadata *objectMetadata) (objectContent, error) {
+	file, err := s.getContentAddressedObject(metadata.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Compression == "" {
+		return file, nil
+	}
+	reader, err := newCompressedReader(file, metadata.Compression, metadata.Size)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// digestSize returns the size of the data of an object held in content-addressed storage
+func (s *Storage) digestSize(metadata *objectMetadata) (int64, error) {
+	if metadata.Compression != "" {
+		return metadata.Size, nil
+	}
+	return s.contentSize(metadata.Digest)
+}
+
+// compressedReader decompresses the frames of compressed object data as they are read
+type compressedReader struct {
+	src    *sharedFileReader
+	decode func(frame, dst []byte) ([]byte, error)
+	size   int64
+	// offsets holds the offset of every frame in src, followed by the offset of the seek table
+	offsets []int64
+	// starts holds the offset of the data of every frame in the object, followed by its size
+	starts []int64
+	pos    int64
+	// frame is the index of the frame decompressed into buf, -1 if none is
+	frame      int
+	buf        []byte
+	compressed []byte
+}
+
+// newCompressedReader reads the seek table of the data of an object of the given size compressed with codec
+func newCompressedReader(src *sharedFileReader, codec string, size int64) (*compressedReader, error) {
+	decode, ok := frameDecoders[codec]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnsupportedCompression, codec)
+	}
+	footer := make([]byte, seekTableFooterSize)
+	tableEnd := src.Size() - seekTableFooterSize
+	if tableEnd < 8 {
+		return nil, errCorruptCompressed
+	}
+	if _, err := src.ReadAt(footer, tableEnd); err != nil {
+		return nil, err
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer[0:]))
+	descriptor := footer[4]
+	entrySize := int64(seekTableEntrySize)
+	if descriptor&seekTableChecksums != 0 {
+		entrySize += 4
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic || descriptor&^seekTableChecksums != 0 || entrySize*frames > tableEnd-8 {
+		return nil, errCorruptCompressed
+	}
+
+	// The skippable frame header is followed by the entries
+	table := make([]byte, 8+entrySize*frames)
+	tableStart := tableEnd - int64(len(table))
+	if _, err := src.ReadAt(table, tableStart); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table[0:]) != seekTableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != entrySize*frames+seekTableFooterSize {
+		return nil, errCorruptCompressed
+	}
+	offsets := make([]int64, frames+1)
+	starts := make([]int64, frames+1)
+	for i := range frames {
+		entry := table[8+entrySize*i:]
+		compressed, decompressed := int64(binary.LittleEndian.Uint32(entry)), int64(binary.LittleEndian.Uint32(entry[4:]))
+		if decompressed == 0 || decompressed > compressFrameSize {
+			return nil, errCorruptCompressed
+		}
+		offsets[i+1] = offsets[i] + compressed
+		starts[i+1] = starts[i] + decompressed
+	}
+	if offsets[frames] != tableStart || starts[frames] != size {
+		return nil, errCorruptCompressed
+	}
+
+	return &compressedReader{
+		src:     src,
+		decode:  decode,
+		size:    size,
+		offsets: offsets,
+		starts:  starts,
+		frame:   -1,
+	}, nil
+}
+
+// Size returns the size of the decompressed data
+func (r *compressedReader) Size() int64 {
+	return r.size
+}
+
+func (r *compressedReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	frame := r.frame
+	if frame < 0 || r.pos < r.starts[frame] || r.pos >= r.starts[frame+1] {
+		frame = sort.Search(len(r.starts)-1, func(i int) bool { return r.starts[i+1] > r.pos })
+		if err := r.load(frame); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.pos-r.starts[frame]:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// load decompresses a frame into buf
+func (r *compressedReader) load(frame int) error {
+	r.frame = -1
+	length := r.offsets[frame+1] - r.offsets[frame]
+	if int64(cap(r.compressed)) < length {
+		r.compressed = make([]byte, length)
+	}
+	r.compressed = r.compressed[:length]
+	if _, err := r.src.ReadAt(r.compressed, r.offsets[frame]); err != nil {
+		return err
+	}
+
+	buf, err := r.decode(r.compressed, r.buf[:0])
+	if err != nil || int64(len(buf)) != r.starts[frame+1]-r.starts[frame] {
+		return errCorruptCompressed
+	}
+	r.buf = buf
+	r.frame = frame
+	return nil
+}
+
+func (r *compressedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close releases the compressed data
+func (r *compressedReader) Close() error {
+	return r.src.Close()
+}
+
+// migrateCompression leaves the data directory as it is
+// Earlier versions would serve compressed data as is, so the layout version keeps them from opening the data directory
+func migrateCompression(s *Storage, progress func(done, total int)) error {
+	return nil
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompression(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir, WithCompression())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	var text strings.Builder
+	for i := 0; text.Len() < 3*compressFrameSize+12345; i++ {
+		fmt.Fprintf(&text, "line %d of a compressible log\n", i)
+	}
+	random := make([]byte, 2*inlineThreshold)
+	rand.Read(random)
+
+	objects := []struct {
+		key         string
+		data        string
+		metadata    Metadata
+		compression string
+	}{
+		{"log.txt", text.String(), Metadata{ContentType: "text/plain; charset=utf-8"}, compressionZstd},
+		{"same.txt", text.String(), Metadata{ContentType: "text/plain"}, compressionZstd},
+		{"small.json", strings.Repeat("{}", inlineThreshold), Metadata{ContentType: "application/json"}, compressionZstd},
+		{"image.png", text.String(), Metadata{ContentType: "image/png"}, ""},
+		{"encoded.txt", text.String(), Metadata{ContentType: "text/plain", ContentEncoding: "gzip"}, ""},
+		{"random.txt", string(random), Metadata{ContentType: "text/plain"}, ""},
+		{"inline.txt", "inline", Metadata{ContentType: "text/plain"}, ""},
+	}
+	for _, obj := range objects {
+		info, err := store.PutObject(context.Background(), bucketName, obj.key, strings.NewReader(obj.data), obj.metadata, "")
+		if err != nil {
+			t.Fatalf("PutObject %s failed: %v", obj.key, err)
+		}
+		if info.Size != int64(len(obj.data)) {
+			t.Errorf("Expected %s to be put with size %d, got %d", obj.key, len(obj.data), info.Size)
+		}
+	}
+
+	metadataOf := func(key string) *objectMetadata {
+		t.Helper()
+		objectDir, err := store.safePath(bucketName, key)
+		if err != nil {
+			t.Fatalf("safePath failed: %v", err)
+		}
+		metadata, err := store.loadObjectMetadata(filepath.Join(objectDir, metaFile))
+		if err != nil || metadata == nil {
+			t.Fatalf("Failed to load the metadata of %s: %v", key, err)
+		}
+		return metadata
+	}
+	read := func(key string) string {
+		t.Helper()
+		reader, info, err := store.GetObject(bucketName, key)
+		if err != nil {
+			t.Fatalf("GetObject %s failed: %v", key, err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		if info.Size != int64(len(data)) {
+			t.Errorf("Expected the size of %s to be %d, got %d", key, len(data), info.Size)
+		}
+		return string(data)
+	}
+
+	for _, obj := range objects {
+		metadata := metadataOf(obj.key)
+		if metadata.Compression != obj.compression {
+			t.Errorf("Expected %s to be stored with compression %q, got %q", obj.key, obj.compression, metadata.Compression)
+		}
+		if got := read(obj.key); got != obj.data {
+			t.Errorf("Unexpected content of %s, got %d bytes", obj.key, len(got))
+		}
+	}
+
+	// The compressed data is much smaller, and shared by objects with the same content
+	logMetadata := metadataOf("log.txt")
+	if metadataOf("same.txt").Digest != logMetadata.Digest {
+		t.Error("Expected objects with the same content to share their compressed data")
+	}
+	if size, err := store.contentSize(logMetadata.Digest); err != nil || size >= int64(text.Len())/2 {
+		t.Errorf("Expected the compressed data to be less than half of %d bytes, got %d (%v)", text.Len(), size, err)
+	}
+
+	t.Run("Range", func(t *testing.T) {
+		reader, _, err := store.GetObject(bucketName, "log.txt")
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
+
+		data := text.String()
+		for _, r := range [][2]int64{
+			{0, 10},
+			{compressFrameSize - 5, 10},
+			{2*compressFrameSize + 7, compressFrameSize},
+			{int64(len(data)) - 100, 100},
+		} {
+			if _, err := reader.Seek(r[0], io.SeekStart); err != nil {
+				t.Fatalf("Seek failed: %v", err)
+			}
+			got := make([]byte, r[1])
+			if _, err := io.ReadFull(reader, got); err != nil {
+				t.Fatalf("Failed to read %d bytes at %d: %v", r[1], r[0], err)
+			}
+			if string(got) != data[r[0]:r[0]+r[1]] {
+				t.Errorf("Unexpected content of %d bytes at %d", r[1], r[0])
+			}
+		}
+		if end, err := reader.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+			t.Errorf("Expected the end at %d, got %d (%v)", len(data), end, err)
+		}
+		if n, err := reader.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Expected EOF at the end, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		info, err := store.CopyObject(bucketName, "log.txt", bucketName, "copy.txt", nil)
+		if err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if info.Size != int64(text.Len()) {
+			t.Errorf("Expected the copy to have size %d, got %d", text.Len(), info.Size)
+		}
+		if got := read("copy.txt"); got != text.String() {
+			t.Errorf("Unexpected content of the copy, got %d bytes", len(got))
+		}
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		key := "multipart.txt"
+		uploadID, err := store.InitiateMultipartUpload(bucketName, key, Metadata{ContentType: "text/csv"}, "")
+		if err != nil {
+			t.Fatalf("InitiateMultipartUpload failed: %v", err)
+		}
+		part1, err := store.UploadPart(context.Background(), bucketName, key, uploadID, 1, strings.NewReader(text.String()), "")
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		part2, err := store.UploadPartCopy(context.Background(), bucketName, key, uploadID, 2, bucketName, "log.txt", compressFrameSize-10, compressFrameSize+9)
+		if err != nil {
+			t.Fatalf("UploadPartCopy failed: %v", err)
+		}
+		if _, err := store.CompleteMultipartUpload(context.Background(), bucketName, key, uploadID, []Multipart{
+			{PartNumber: 1, ETag: part1.ETag},
+			{PartNumber: 2, ETag: part2.ETag},
+		}, ""); err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+
+		if metadataOf(key).Compression != compressionZstd {
+			t.Error("Expected the completed upload to be compressed")
+		}
+		expected := text.String() + text.String()[compressFrameSize-10:compressFrameSize+10]
+		if got := read(key); got != expected {
+			t.Errorf("Unexpected content of the completed upload, got %d bytes", len(got))
+		}
+	})
+
+	listed, _, err := store.ListObjects(bucketName, "", "", "", 0)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	for _, obj := range listed {
+		for _, o := range objects {
+			if o.key == obj.Key && obj.Size != int64(len(o.data)) {
+				t.Errorf("Expected %s to be listed with size %d, got %d", obj.Key, len(o.data), obj.Size)
+			}
+		}
+	}
+
+	// Objects stay readable once compression is disabled
+	store.Close()
+	store, err = NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	if got := read("log.txt"); got != text.String() {
+		t.Errorf("Unexpected content after reopening, got %d bytes", len(got))
+	}
+}
+
+func TestCompressedReaderCorrupt(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "data")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := writeCompressed(file, strings.NewReader(strings.Repeat("a", 10000)), 1<<20); err != nil {
+		t.Fatalf("writeCompressed failed: %v", err)
+	}
+	file.Close()
+
+	files := newFileCache(0)
+	for _, size := range []int64{10000 + compressFrameSize, 0} {
+		src, err := files.open(path)
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		if _, err := newCompressedReader(src, compressionZstd, size); err != errCorruptCompressed {
+			t.Errorf("Expected a size of %d to be rejected, got %v", size, err)
+		}
+		src.Close()
+	}
+
+	// Data compressed with a codec of a later version is not misread
+	src, err := files.open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	if _, err := newCompressedReader(src, "zstd/2", 10000); !errors.Is(err, errUnsupportedCompression) {
+		t.Errorf("Expected an unknown codec to be rejected, got %v", err)
+	}
+	src.Close()
+
+	// The data is a zstd stream whose seek table zstd decoders skip
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+	dec, err := zstd.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to create decoder: %v", err)
+	}
+	defer dec.Close()
+	if got, err := io.ReadAll(dec); err != nil || string(got) != strings.Repeat("a", 10000) {
+		t.Errorf("Expected the data to decode as a zstd stream, got %d bytes (%v)", len(got), err)
+	}
+
+	if n, err := writeCompressed(io.Discard, strings.NewReader(strings.Repeat("a", 10000)), 10); n != -1 || err != nil {
+		t.Errorf("Expected compression past the limit to give up, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// Condition is a precondition on the object a write replaces, checked atomically with the write
+// The zero Condition always holds
+type Condition struct {
+	// IfMatch requires the object to exist with this ETag
+	IfMatch string
+	// IfNoneMatch set to "*" requires the object not to exist
+	IfNoneMatch string
+}
+
+// check reports whether the condition holds for the existing object, nil if there is none
+func (c Condition) check(existing *objectMetadata) error {
+	if c.IfNoneMatch != "" && existing != nil {
+		return ErrPreconditionFailed
+	}
+	if c.IfMatch != "" {
+		if existing == nil {
+			return ErrObjectNotFound
+		}
+		if strings.Trim(c.IfMatch, `"`) != existing.ETag {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
+
+// commitLockStripes is the number of locks object commits are spread over
+const commitLockStripes = 64
+
+// commitLocks serialize the commits of writes and deletes of the same object, from loading the object
+// they replace to saving their metadata, so that preconditions and reference counts
+// are never decided on an object another write is replacing at the same time
+type commitLocks [commitLockStripes]sync.Mutex
+
+// stripe returns the index of the stripe of the meta file at metaPath
+func (l *commitLocks) stripe(metaPath string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(metaPath))
+	return h.Sum32() % commitLockStripes
+}
+
+// lock locks the stripe of the meta file at metaPath and returns its unlock function
+func (l *commitLocks) lock(metaPath string) func() {
+	mu := &l[l.stripe(metaPath)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockPair locks the stripes of two meta files and returns the function unlocking both
+// Stripes are always locked in index order, so operations locking several never deadlock
+func (l *commitLocks) lockPair(a, b string) func() {
+	i, j := l.stripe(a), l.stripe(b)
+	if i == j {
+		l[i].Lock()
+		return l[i].Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	l[i].Lock()
+	l[j].Lock()
+	return func() {
+		l[j].Unlock()
+		l[i].Unlock()
+	}
+}
+
+// lockAll locks every stripe, waiting for all commits in progress, and returns the function unlocking them
+func (l *commitLocks) lockAll() func() {
+	for i := range l {
+		l[i].Lock()
+	}
+	return func() {
+		for i := len(l) - 1; i >= 0; i-- {
+			l[i].Unlock()
+		}
+	}
+}
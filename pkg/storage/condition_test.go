@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPutObjectIf(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	put := func(content string, cond Condition) (*ObjectInfo, error) {
+		return store.PutObjectIf(context.Background(), bucketName, "lock", strings.NewReader(content), Metadata{}, "", cond)
+	}
+
+	if _, err := put("first", Condition{IfMatch: "missing"}); err != ErrObjectNotFound {
+		t.Errorf("Expected ErrObjectNotFound for IfMatch on a missing object, got %v", err)
+	}
+	first, err := put("first", Condition{IfNoneMatch: "*"})
+	if err != nil {
+		t.Fatalf("Expected IfNoneMatch to create a missing object, got %v", err)
+	}
+	if _, err := put("second", Condition{IfNoneMatch: "*"}); err != ErrPreconditionFailed {
+		t.Errorf("Expected ErrPreconditionFailed for IfNoneMatch on an existing object, got %v", err)
+	}
+	if _, err := put("second", Condition{IfMatch: "other"}); err != ErrPreconditionFailed {
+		t.Errorf("Expected ErrPreconditionFailed for IfMatch with another ETag, got %v", err)
+	}
+
+	reader, _, err := store.GetObject(bucketName, "lock")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "first" {
+		t.Errorf("Expected failed writes to keep the object, got %q", data)
+	}
+
+	if _, err := put("second", Condition{IfMatch: `"` + first.ETag + `"`}); err != nil {
+		t.Errorf("Expected IfMatch with the current ETag to replace the object, got %v", err)
+	}
+}
+
+func TestPutObjectIfNoneMatchConcurrent(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// Writers of large objects race to create the same lock, only one may win
+	const writers = 16
+	var wg sync.WaitGroup
+	results := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := bytes.Repeat([]byte(fmt.Sprintf("writer-%02d", i)), 1<<12)
+			_, err := store.PutObjectIf(context.Background(), bucketName, "lock", bytes.NewReader(content), Metadata{}, "", Condition{IfNoneMatch: "*"})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var created int
+	for err := range results {
+		switch err {
+		case nil:
+			created++
+		case ErrPreconditionFailed:
+		default:
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one writer to create the object, got %d", created)
+	}
+}
+
+func TestDeleteObjectConcurrent(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// Another object shares the content the racing writes and deletes reference
+	content := bytes.Repeat([]byte("shared content"), 1<<12)
+	if _, err := store.PutObject(context.Background(), bucketName, "keep", bytes.NewReader(content), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	const workers = 16
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				if i%2 == 0 {
+					if _, err := store.PutObject(context.Background(), bucketName, "racy", bytes.NewReader(content), Metadata{}, ""); err != nil {
+						t.Errorf("PutObject failed: %v", err)
+					}
+				} else if err := store.DeleteObject(bucketName, "racy"); err != nil && err != ErrObjectNotFound {
+					t.Errorf("DeleteObject failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := store.DeleteObject(bucketName, "racy"); err != nil && err != ErrObjectNotFound {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	// Releasing a reference twice would have removed the content of the remaining object
+	objectDir, _ := store.safePath(bucketName, "keep")
+	metadata, err := store.loadObjectMetadata(filepath.Join(objectDir, metaFile))
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	var refs uint64
+	store.refcountDB.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(refcountBucket).Get([]byte(metadata.Digest)); data != nil {
+			refs = binary.BigEndian.Uint64(data)
+		}
+		return nil
+	})
+	if refs != 1 {
+		t.Errorf("Expected the content to be referenced once by the remaining object, got %d", refs)
+	}
+	reader, _, err := store.GetObject(bucketName, "keep")
+	if err != nil {
+		t.Fatalf("Expected the object sharing the content to remain readable, got %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the object sharing the content to keep it, got %d bytes (%v)", len(data), err)
+	}
+}
+
+func TestCompleteMultipartUploadIf(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("existing"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "object", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	part, err := store.UploadPart(context.Background(), bucketName, "object", uploadID, 1, bytes.NewReader(make([]byte, 10000)), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	parts := []Multipart{{PartNumber: 1, ETag: part.ETag}}
+
+	if _, err := store.CompleteMultipartUploadIf(context.Background(), bucketName, "object", uploadID, parts, "", Condition{IfNoneMatch: "*"}); err != ErrPreconditionFailed {
+		t.Fatalf("Expected ErrPreconditionFailed, got %v", err)
+	}
+
+	// The upload is left in place and can be completed unconditionally
+	info, err := store.CompleteMultipartUpload(context.Background(), bucketName, "object", uploadID, parts, "")
+	if err != nil {
+		t.Fatalf("Expected the upload to complete after a failed precondition, got %v", err)
+	}
+	if info.Size != 10000 {
+		t.Errorf("Expected the completed object to replace the existing one, got size %d", info.Size)
+	}
+}
+
+func TestSaveObjectMetadataAtomic(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("v0"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Listings racing overwrites must always see the object
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			store.PutObject(context.Background(), bucketName, "object", strings.NewReader(fmt.Sprintf("v%d", i)), Metadata{}, "")
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		objects, _, err := store.ListObjects(bucketName, "", "", "", 0)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		if len(objects) != 1 {
+			t.Fatalf("Expected the object to be listed while it is overwritten, got %d objects", len(objects))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// MaxCopyObjects is the maximum number of copies in a single CopyObjects request
+const MaxCopyObjects = 1000
+
+// copyObjectsConcurrency is the number of copies of a CopyObjects request running at the same time
+const copyObjectsConcurrency = 8
+
+// CopyRequest is a copy of a CopyObjects request
+type CopyRequest struct {
+	SrcBucket string
+	SrcKey    string
+	DstKey    string
+}
+
+// CopyObjects copies several objects into a bucket concurrently, keeping the metadata of the sources
+// The returned slices hold the result and the error of each copy, so that one missing or protected
+// object does not fail the others. Once ctx is done the copies not started yet fail with its error
+func (s *Storage) CopyObjects(ctx context.Context, dstBucket string, copies []CopyRequest) ([]*ObjectInfo, []error, error) {
+	if len(copies) == 0 || len(copies) > MaxCopyObjects {
+		return nil, nil, ErrInvalidCopyObjects
+	}
+	if !s.BucketExists(dstBucket) {
+		return nil, nil, ErrBucketNotFound
+	}
+
+	infos := make([]*ObjectInfo, len(copies))
+	errs := make([]error, len(copies))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(copyObjectsConcurrency, len(copies)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				c := copies[i]
+				infos[i], errs[i] = s.CopyObject(c.SrcBucket, c.SrcKey, dstBucket, c.DstKey, nil)
+			}
+		}()
+	}
+	for i := range copies {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return infos, errs, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCopyObjects(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	for _, bucket := range []string{"src-bucket", "dst-bucket"} {
+		if err := store.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+	}
+
+	// Mix inline and content-addressed objects
+	var copies []CopyRequest
+	for i := range 20 {
+		key := fmt.Sprintf("dataset/%02d", i)
+		content := strings.Repeat(key, i*500)
+		if _, err := store.PutObject(context.Background(), "src-bucket", key, strings.NewReader(content), Metadata{ContentType: "text/plain"}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		copies = append(copies, CopyRequest{SrcBucket: "src-bucket", SrcKey: key, DstKey: "copy/" + key})
+	}
+
+	t.Run("Copy", func(t *testing.T) {
+		withMissing := append(copies[:len(copies):len(copies)], CopyRequest{SrcBucket: "src-bucket", SrcKey: "missing", DstKey: "copy/missing"})
+		infos, errs, err := store.CopyObjects(context.Background(), "dst-bucket", withMissing)
+		if err != nil {
+			t.Fatalf("CopyObjects failed: %v", err)
+		}
+
+		for i, c := range copies {
+			if errs[i] != nil {
+				t.Fatalf("Copy of %s failed: %v", c.SrcKey, errs[i])
+			}
+			reader, info, err := store.GetObject("dst-bucket", c.DstKey)
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != strings.Repeat(c.SrcKey, i*500) {
+				t.Errorf("Unexpected content of %s", c.DstKey)
+			}
+			if info.ETag != infos[i].ETag {
+				t.Errorf("Expected ETag %q for %s, got %q", infos[i].ETag, c.DstKey, info.ETag)
+			}
+			if info.Metadata.ContentType != "text/plain" {
+				t.Errorf("Expected the metadata of the source to be kept, got content type %q", info.Metadata.ContentType)
+			}
+		}
+
+		if errs[len(copies)] != ErrObjectNotFound {
+			t.Errorf("Expected ErrObjectNotFound for a missing source, got %v", errs[len(copies)])
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, _, err := store.CopyObjects(context.Background(), "dst-bucket", nil); err != ErrInvalidCopyObjects {
+			t.Errorf("Expected ErrInvalidCopyObjects for no copies, got %v", err)
+		}
+		if _, _, err := store.CopyObjects(context.Background(), "dst-bucket", make([]CopyRequest, MaxCopyObjects+1)); err != ErrInvalidCopyObjects {
+			t.Errorf("Expected ErrInvalidCopyObjects for too many copies, got %v", err)
+		}
+		if _, _, err := store.CopyObjects(context.Background(), "missing-bucket", copies); err != ErrBucketNotFound {
+			t.Errorf("Expected ErrBucketNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, errs, err := store.CopyObjects(ctx, "dst-bucket", copies)
+		if err != nil {
+			t.Fatalf("CopyObjects failed: %v", err)
+		}
+		for i, err := range errs {
+			if err != context.Canceled {
+				t.Errorf("Expected copy %d to be canceled, got %v", i, err)
+			}
+		}
+	})
+}
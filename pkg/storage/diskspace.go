@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"expvar"
+	"syscall"
+)
+
+// metrics exposes disk space related counters through expvar
+var metrics = expvar.NewMap("s3d_storage")
+
+// WithMinFreeSpace sets the free disk space in bytes that must remain available
+// Writes are rejected with ErrInsufficientStorage once free space drops below it
+func WithMinFreeSpace(size int64) Option {
+	return func(s *Storage) {
+		s.minFreeSpace = size
+	}
+}
+
+// checkFreeSpace returns ErrInsufficientStorage if the free space of the data directory
+// is below the configured reserve
+func (s *Storage) checkFreeSpace() error {
+	if s.minFreeSpace <= 0 {
+		return nil
+	}
+
+	free, ok := freeSpace(s.basePath)
+	if !ok {
+		return nil
+	}
+
+	freeBytes := new(expvar.Int)
+	freeBytes.Set(free)
+	metrics.Set("free_bytes", freeBytes)
+
+	if free < s.minFreeSpace {
+		metrics.Add("rejected_writes", 1)
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// diskError translates an out of space error from the filesystem into ErrInsufficientStorage
+func (s *Storage) diskError(err error) error {
+	if err != nil && errors.Is(err, syscall.ENOSPC) {
+		metrics.Add("enospc_errors", 1)
+		return ErrInsufficientStorage
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package storage
+
+// freeSpace is not supported on this platform, free space checks are skipped
+func freeSpace(path string) (int64, bool) {
+	return 0, false
+}
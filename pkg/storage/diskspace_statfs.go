@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package storage
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the number of bytes available to unprivileged users on the filesystem of path
+func freeSpace(path string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), true
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMinFreeSpace(t *testing.T) {
+	// Create temp directory
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, ok := freeSpace(tmpDir); !ok {
+		t.Skip("Free space is not available on this platform")
+	}
+
+	store, err := NewStorage(tmpDir, WithMinFreeSpace(math.MaxInt64))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	err = store.CreateBucket("test-bucket")
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	_, err = store.PutObject(context.Background(), "test-bucket", "key.txt", bytes.NewReader([]byte("data")), Metadata{}, "")
+	if err != ErrInsufficientStorage {
+		t.Fatalf("Expected ErrInsufficientStorage, got %v", err)
+	}
+
+	if _, _, err := store.GetObject("test-bucket", "key.txt"); err != ErrObjectNotFound {
+		t.Fatalf("Expected no object to be created, got %v", err)
+	}
+}
+
+func TestDiskError(t *testing.T) {
+	store := &Storage{}
+
+	err := store.diskError(&os.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC})
+	if err != ErrInsufficientStorage {
+		t.Errorf("Expected ErrInsufficientStorage, got %v", err)
+	}
+
+	if err := store.diskError(ErrObjectNotFound); err != ErrObjectNotFound {
+		t.Errorf("Expected ErrObjectNotFound to be kept, got %v", err)
+	}
+	if err := store.diskError(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
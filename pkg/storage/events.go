@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change an Event reports
+type EventType string
+
+const (
+	// EventObjectCreated reports an object written by PutObject, CompleteMultipartUpload,
+	// ComposeObject or RestoreObject
+	EventObjectCreated EventType = "ObjectCreated"
+	// EventObjectCopied reports an object written by CopyObject or CopyObjects
+	EventObjectCopied EventType = "ObjectCopied"
+	// EventObjectRenamed reports an object moved by RenameObject
+	EventObjectRenamed EventType = "ObjectRenamed"
+	// EventObjectDeleted reports an object removed by DeleteObject, DeleteObjects, DeletePrefix or expiration
+	EventObjectDeleted EventType = "ObjectDeleted"
+)
+
+// Event reports a change made to an object
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	Bucket string
+	Key    string
+	// SourceBucket and SourceKey are the object copied or renamed from
+	SourceBucket string
+	SourceKey    string
+	// Size and ETag are those of the object written, empty for deletes
+	Size int64
+	ETag string
+	// Sequence is the sequence number of the change in Bucket, see BucketSequence
+	Sequence uint64
+}
+
+// subscribers holds the functions subscribed to the events of a Storage
+type subscribers struct {
+	mu   sync.RWMutex
+	next int
+	fns  map[int]func(Event)
+}
+
+// Subscribe calls fn with every change made to objects through s from then on, until the returned
+// function is called
+// fn is called in the goroutine making the change once it is done, so it must return quickly and hand
+// slow work such as indexing off to another goroutine. Changes made by other processes sharing
+// the data directory are not reported
+func (s *Storage) Subscribe(fn func(Event)) (unsubscribe func()) {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+
+	if s.events.fns == nil {
+		s.events.fns = map[int]func(Event){}
+	}
+	id := s.events.next
+	s.events.next++
+	s.events.fns[id] = fn
+
+	return func() {
+		s.events.mu.Lock()
+		defer s.events.mu.Unlock()
+		delete(s.events.fns, id)
+	}
+}
+
+// publish numbers the change reported by event and calls the subscribed functions with it
+func (s *Storage) publish(event Event) {
+	event.Sequence = s.advanceSequence(event.Bucket)
+
+	s.events.mu.RLock()
+	if len(s.events.fns) == 0 {
+		s.events.mu.RUnlock()
+		return
+	}
+	// The functions are called without the lock, so they may unsubscribe
+	fns := make([]func(Event), 0, len(s.events.fns))
+	for _, fn := range s.events.fns {
+		fns = append(fns, fn)
+	}
+	s.events.mu.RUnlock()
+
+	event.Time = time.Now()
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+// publishWrite publishes the write of the object described by info
+func (s *Storage) publishWrite(eventType EventType, bucket string, info *ObjectInfo, srcBucket, srcKey string) {
+	s.publish(Event{
+		Type:         eventType,
+		Bucket:       bucket,
+		Key:          info.Key,
+		SourceBucket: srcBucket,
+		SourceKey:    srcKey,
+		Size:         info.Size,
+		ETag:         info.ETag,
+	})
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if err := store.SetBucketTrashRetention(bucketName, 24*time.Hour); err != nil {
+		t.Fatalf("SetBucketTrashRetention failed: %v", err)
+	}
+
+	var events []Event
+	unsubscribe := store.Subscribe(func(event Event) {
+		events = append(events, event)
+	})
+
+	put, err := store.PutObject(context.Background(), bucketName, "a.txt", strings.NewReader("hello"), Metadata{}, "")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := store.CopyObject(bucketName, "a.txt", bucketName, "b.txt", nil); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if err := store.RenameObject(bucketName, "b.txt", "c.txt"); err != nil {
+		t.Fatalf("RenameObject failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "d.txt", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	part, err := store.UploadPart(context.Background(), bucketName, "d.txt", uploadID, 1, strings.NewReader("part"), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	completed, err := store.CompleteMultipartUpload(context.Background(), bucketName, "d.txt", uploadID, []Multipart{{PartNumber: 1, ETag: part.ETag}}, "")
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	if err := store.DeleteObject(bucketName, "a.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	trashed, err := store.ListTrash(bucketName, "")
+	if err != nil || len(trashed) != 1 {
+		t.Fatalf("Expected one trashed object, got %d (%v)", len(trashed), err)
+	}
+	if _, err := store.RestoreObject(bucketName, trashed[0].ID); err != nil {
+		t.Fatalf("RestoreObject failed: %v", err)
+	}
+
+	// Failed changes are not reported
+	if err := store.DeleteObject(bucketName, "missing.txt"); err != ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound, got %v", err)
+	}
+
+	expected := []Event{
+		{Type: EventObjectCreated, Bucket: bucketName, Key: "a.txt", Size: 5, ETag: put.ETag, Sequence: 1},
+		{Type: EventObjectCopied, Bucket: bucketName, Key: "b.txt", SourceBucket: bucketName, SourceKey: "a.txt", Size: 5, ETag: put.ETag, Sequence: 2},
+		{Type: EventObjectRenamed, Bucket: bucketName, Key: "c.txt", SourceBucket: bucketName, SourceKey: "b.txt", Sequence: 3},
+		{Type: EventObjectCreated, Bucket: bucketName, Key: "d.txt", Size: 4, ETag: completed.ETag, Sequence: 4},
+		{Type: EventObjectDeleted, Bucket: bucketName, Key: "a.txt", Sequence: 5},
+		{Type: EventObjectCreated, Bucket: bucketName, Key: "a.txt", Size: 5, ETag: put.ETag, Sequence: 6},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, event := range events {
+		if event.Time.IsZero() {
+			t.Errorf("Expected event %d to have a time", i)
+		}
+		event.Time = time.Time{}
+		if event != expected[i] {
+			t.Errorf("Expected event %d to be %+v, got %+v", i, expected[i], event)
+		}
+	}
+
+	unsubscribe()
+	if _, err := store.PutObject(context.Background(), bucketName, "e.txt", strings.NewReader("e"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if len(events) != len(expected) {
+		t.Errorf("Expected no event after unsubscribing, got %+v", events[len(expected):])
+	}
+}
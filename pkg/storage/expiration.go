@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// expirationBatch is the number of objects listed at once when looking for expired objects
+const expirationBatch = 1000
+
+// SetBucketDefaultTTL sets how long after being written the objects of a bucket expire, 0 disables expiration
+// This is an s3d extension for scratch and cache buckets, needing no lifecycle rules
+func (s *Storage) SetBucketDefaultTTL(bucket string, ttl time.Duration) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.DefaultTTL = ttl
+	})
+}
+
+// GetBucketDefaultTTL returns how long after being written the objects of a bucket expire, 0 if they never do
+func (s *Storage) GetBucketDefaultTTL(bucket string) (time.Duration, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return metadata.DefaultTTL, nil
+}
+
+// ExpireObjects deletes the objects written longer than the default TTL of their bucket before now,
+// returning the number of objects deleted
+// Frozen buckets are skipped, and so are objects still protected by the WORM retention of their bucket
+// Once ctx is done it stops with its error
+func (s *Storage) ExpireObjects(ctx context.Context, now time.Time) (int, error) {
+	buckets, err := s.ListBuckets("", "", 0)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, bucket := range buckets {
+		metadata, err := s.getBucketMetadata(bucket.Name)
+		if err != nil {
+			if err == ErrBucketNotFound {
+				continue
+			}
+			return expired, err
+		}
+		if metadata.DefaultTTL <= 0 || metadata.Frozen {
+			continue
+		}
+
+		n, err := s.expireBucket(ctx, bucket.Name, now.Add(-metadata.DefaultTTL))
+		expired += n
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// expireBucket deletes the objects of bucket written before cutoff
+func (s *Storage) expireBucket(ctx context.Context, bucket string, cutoff time.Time) (int, error) {
+	expired := 0
+	marker := ""
+	for {
+		objects, _, err := s.ListObjects(bucket, "", "", marker, expirationBatch)
+		if err != nil {
+			if err == ErrBucketNotFound {
+				return expired, nil
+			}
+			return expired, err
+		}
+		for _, obj := range objects {
+			if err := ctx.Err(); err != nil {
+				return expired, err
+			}
+			if !obj.ModTime.Before(cutoff) {
+				continue
+			}
+			ok, err := s.expireObject(bucket, obj.Key, cutoff)
+			if err != nil {
+				return expired, err
+			}
+			if ok {
+				expired++
+			}
+		}
+		if len(objects) < expirationBatch {
+			return expired, nil
+		}
+		marker = objects[len(objects)-1].Key
+	}
+}
+
+// expireObject deletes an object if it was written before cutoff, reporting whether it did
+// The age is checked again under the commit lock, so an object overwritten since it was listed is kept
+func (s *Storage) expireObject(bucket, key string, cutoff time.Time) (bool, error) {
+	objectDir, err := s.safePath(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	metaPath := filepath.Join(objectDir, metaFile)
+	unlock := s.commits.lock(metaPath)
+	defer unlock()
+
+	info, err := os.Stat(metaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !info.ModTime().Before(cutoff) {
+		return false, nil
+	}
+
+	// The commit lock is already held, which DeleteObject would take again
+	switch err := s.deleteLockedObject(bucket, key, objectDir, metaPath); err {
+	case nil:
+		s.publish(Event{Type: EventObjectDeleted, Bucket: bucket, Key: key})
+		return true, nil
+	case ErrObjectNotFound, ErrObjectLocked, ErrBucketNotFound, ErrBucketFrozen:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireObjects(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	const day = 24 * time.Hour
+	put := func(bucket, key string) {
+		if _, err := store.PutObject(context.Background(), bucket, key, strings.NewReader("data"), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s/%s failed: %v", bucket, key, err)
+		}
+	}
+
+	get := func(bucket, key string) error {
+		reader, _, err := store.GetObject(bucket, key)
+		if err == nil {
+			reader.Close()
+		}
+		return err
+	}
+
+	if err := store.CreateBucket("scratch"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if ttl, err := store.GetBucketDefaultTTL("scratch"); err != nil || ttl != 0 {
+		t.Fatalf("Expected no default TTL on a new bucket, got %v (%v)", ttl, err)
+	}
+	if err := store.SetBucketDefaultTTL("scratch", day); err != nil {
+		t.Fatalf("SetBucketDefaultTTL failed: %v", err)
+	}
+	if ttl, err := store.GetBucketDefaultTTL("scratch"); err != nil || ttl != day {
+		t.Fatalf("Expected a default TTL of %v, got %v (%v)", day, ttl, err)
+	}
+	if err := store.SetBucketDefaultTTL("missing", day); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+
+	// More objects than a batch, nested at several levels
+	for i := range expirationBatch + 10 {
+		put("scratch", "dir/"+strings.Repeat("x", i%5)+"/"+time.Duration(i).String())
+	}
+	put("scratch", "top")
+
+	if err := store.CreateBucket("kept"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	put("kept", "object")
+
+	// Objects within their TTL are kept
+	if n, err := store.ExpireObjects(context.Background(), time.Now().Add(day/2)); err != nil || n != 0 {
+		t.Fatalf("Expected no objects to expire yet, got %d (%v)", n, err)
+	}
+
+	n, err := store.ExpireObjects(context.Background(), time.Now().Add(2*day))
+	if err != nil {
+		t.Fatalf("ExpireObjects failed: %v", err)
+	}
+	if n != expirationBatch+11 {
+		t.Errorf("Expected %d expired objects, got %d", expirationBatch+11, n)
+	}
+	if objects, _, err := store.ListObjects("scratch", "", "", "", 0); err != nil || len(objects) != 0 {
+		t.Errorf("Expected the scratch bucket to be empty, got %d objects (%v)", len(objects), err)
+	}
+	if err := get("kept", "object"); err != nil {
+		t.Errorf("Expected objects of buckets without a default TTL to be kept, got %v", err)
+	}
+
+	t.Run("WORM", func(t *testing.T) {
+		if err := store.CreateWORMBucket("worm", 7*day); err != nil {
+			t.Fatalf("CreateWORMBucket failed: %v", err)
+		}
+		if err := store.SetBucketDefaultTTL("worm", day); err != nil {
+			t.Fatalf("SetBucketDefaultTTL failed: %v", err)
+		}
+		put("worm", "object")
+
+		// The retention is measured from the real clock, so the object is still protected
+		if n, err := store.ExpireObjects(context.Background(), time.Now().Add(2*day)); err != nil || n != 0 {
+			t.Errorf("Expected protected objects to be kept, got %d expired (%v)", n, err)
+		}
+		if err := get("worm", "object"); err != nil {
+			t.Errorf("Expected the protected object to remain, got %v", err)
+		}
+	})
+
+	t.Run("Frozen", func(t *testing.T) {
+		if err := store.CreateBucket("frozen"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if err := store.SetBucketDefaultTTL("frozen", day); err != nil {
+			t.Fatalf("SetBucketDefaultTTL failed: %v", err)
+		}
+		put("frozen", "object")
+		if err := store.FreezeBucket("frozen", true); err != nil {
+			t.Fatalf("FreezeBucket failed: %v", err)
+		}
+
+		if n, err := store.ExpireObjects(context.Background(), time.Now().Add(2*day)); err != nil || n != 0 {
+			t.Errorf("Expected frozen buckets to be skipped, got %d expired (%v)", n, err)
+		}
+		if err := get("frozen", "object"); err != nil {
+			t.Errorf("Expected the object of the frozen bucket to remain, got %v", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		put("scratch", "object")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := store.ExpireObjects(ctx, time.Now().Add(2*day)); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
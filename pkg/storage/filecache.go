@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultOpenFileCacheSize is the default number of idle file handles kept open
+const DefaultOpenFileCacheSize = 64
+
+// WithOpenFileCacheSize sets how many content-addressed files stay open after their
+// last reader is done, 0 closes them right away
+func WithOpenFileCacheSize(size int) Option {
+	return func(s *Storage) {
+		s.files = newFileCache(size)
+	}
+}
+
+// fileCache shares open handles of content-addressed objects between concurrent readers
+// Content-addressed objects never change once stored, so readers can use the same
+// descriptor with positioned reads instead of each opening and stating the file
+// Handles without readers are kept in an LRU list so repeated reads skip the open too
+type fileCache struct {
+	mu      sync.Mutex
+	files   map[string]*sharedFile
+	idle    *list.List
+	maxIdle int
+	// revalidate checks that a cached handle still refers to the file at its path before reusing it,
+	// for data directories where another process may delete and recreate content-addressed files
+	revalidate bool
+}
+
+// sharedFile is an open file handle with the number of readers using it
+type sharedFile struct {
+	path string
+	file *os.File
+	size int64
+	refs int
+	// idle is the element in the idle list while no reader uses the handle
+	idle *list.Element
+}
+
+// newFileCache creates an empty file cache keeping up to maxIdle unused handles open
+func newFileCache(maxIdle int) *fileCache {
+	return &fileCache{
+		files:   map[string]*sharedFile{},
+		idle:    list.New(),
+		maxIdle: maxIdle,
+	}
+}
+
+// open returns a reader of the file at path, sharing the handle with other open readers
+func (c *fileCache) open(path string) (*sharedFileReader, error) {
+	return c.openSection(path, 0, -1)
+}
+
+// openSection returns a reader of n bytes of the file at path from off, or of the whole file if n is negative,
+// sharing the handle with other open readers
+func (c *fileCache) openSection(path string, off, n int64) (*sharedFileReader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.files[path]
+	if ok && c.revalidate && !f.current() {
+		c.drop(f)
+		ok = false
+	}
+	if !ok {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		f = &sharedFile{path: path, file: file, size: info.Size()}
+		c.files[path] = f
+	}
+	if f.idle != nil {
+		c.idle.Remove(f.idle)
+		f.idle = nil
+	}
+	f.refs++
+
+	if n < 0 {
+		off, n = 0, f.size
+	}
+	return &sharedFileReader{
+		SectionReader: io.NewSectionReader(f.file, off, n),
+		cache:         c,
+		path:          path,
+		shared:        f,
+	}, nil
+}
+
+// release drops a reference to the handle of path
+// Once no reader is left the handle becomes idle, closing the least recently used idle handles
+func (c *fileCache) release(path string, f *sharedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f.refs--
+	if f.refs > 0 {
+		return
+	}
+	if c.files[path] != f {
+		// The handle was evicted while in use
+		f.file.Close()
+		return
+	}
+
+	f.idle = c.idle.PushFront(f)
+	for c.idle.Len() > c.maxIdle {
+		oldest := c.idle.Remove(c.idle.Back()).(*sharedFile)
+		oldest.idle = nil
+		delete(c.files, oldest.path)
+		oldest.file.Close()
+	}
+}
+
+// evict removes the handle of path so the file is no longer held open once its readers are done
+func (c *fileCache) evict(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.files[path]; ok {
+		c.drop(f)
+	}
+}
+
+// drop removes f from the cache, closing it right away if no reader uses it
+// The caller must hold c.mu
+func (c *fileCache) drop(f *sharedFile) {
+	delete(c.files, f.path)
+	if f.idle != nil {
+		c.idle.Remove(f.idle)
+		f.idle = nil
+		f.file.Close()
+	}
+}
+
+// current reports whether the path of f still refers to the open file
+// A handle of a file deleted by another process can go stale on network filesystems
+func (f *sharedFile) current() bool {
+	pathInfo, err := os.Stat(f.path)
+	if err != nil {
+		return false
+	}
+	fileInfo, err := f.file.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(pathInfo, fileInfo)
+}
+
+// close closes all idle handles
+func (c *fileCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.idle.Len() > 0 {
+		f := c.idle.Remove(c.idle.Front()).(*sharedFile)
+		f.idle = nil
+		delete(c.files, f.path)
+		f.file.Close()
+	}
+}
+
+// openCount returns the number of open handles
+func (c *fileCache) openCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.files)
+}
+
+// sharedFileReader reads a shared file with its own offset
+type sharedFileReader struct {
+	*io.SectionReader
+	cache  *fileCache
+	path   string
+	shared *sharedFile
+	once   sync.Once
+}
+
+// Close releases the reader's reference to the shared handle
+func (r *sharedFileReader) Close() error {
+	r.once.Do(func() {
+		r.cache.release(r.path, r.shared)
+	})
+	return nil
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileCacheSharing(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-filecache"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	if _, err := store.PutObject(context.Background(), bucketName, "large", bytes.NewReader(content), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	reader1, _, err := store.GetObject(bucketName, "large")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	reader2, _, err := store.GetObject(bucketName, "large")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+
+	if n := store.files.openCount(); n != 1 {
+		t.Fatalf("Expected concurrent readers to share 1 handle, got %d", n)
+	}
+
+	// Readers keep independent offsets on the shared handle
+	if _, err := reader1.Seek(5000, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	buf1 := make([]byte, 10)
+	buf2 := make([]byte, 10)
+	if _, err := io.ReadFull(reader1, buf1); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := io.ReadFull(reader2, buf2); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(buf1, content[5000:5010]) || !bytes.Equal(buf2, content[:10]) {
+		t.Error("Readers returned unexpected data")
+	}
+
+	reader1.Close()
+	reader1.Close() // closing twice must not release the handle of reader2
+	if n := store.files.openCount(); n != 1 {
+		t.Fatalf("Expected handle to stay open for the remaining reader, got %d", n)
+	}
+	reader2.Close()
+	if n := store.files.openCount(); n != 1 {
+		t.Fatalf("Expected handle to stay open while idle, got %d", n)
+	}
+
+	// Deleting the object must not keep its file open
+	if err := store.DeleteObject(bucketName, "large"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if n := store.files.openCount(); n != 0 {
+		t.Fatalf("Expected handle to be closed after delete, got %d", n)
+	}
+}
+
+func TestFileCacheIdleLimit(t *testing.T) {
+	dir := t.TempDir()
+	cache := newFileCache(2)
+	defer cache.close()
+
+	var readers []*sharedFileReader
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		reader, err := cache.open(path)
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		readers = append(readers, reader)
+	}
+
+	if n := cache.openCount(); n != 3 {
+		t.Fatalf("Expected handles in use to stay open, got %d", n)
+	}
+	for _, reader := range readers {
+		reader.Close()
+	}
+	if n := cache.openCount(); n != 2 {
+		t.Fatalf("Expected idle handles to be limited to 2, got %d", n)
+	}
+	if _, ok := cache.files[filepath.Join(dir, "a")]; ok {
+		t.Error("Expected the least recently used handle to be closed")
+	}
+}
+
+func BenchmarkParallelRangeGet(b *testing.B) {
+	tmpDir, err := os.MkdirTemp("", "storage-bench-*")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "bench-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		b.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	const size = 64 << 20
+	const chunk = 1 << 20
+	if _, err := store.PutObject(context.Background(), bucketName, "large", io.LimitReader(zeroReader{}, size), Metadata{}, ""); err != nil {
+		b.Fatalf("PutObject failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var next int64
+	b.SetBytes(chunk)
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, chunk)
+		for pb.Next() {
+			mu.Lock()
+			offset := next
+			next = (next + chunk) % size
+			mu.Unlock()
+
+			reader, _, err := store.GetObject(bucketName, "large")
+			if err != nil {
+				b.Errorf("GetObject failed: %v", err)
+				return
+			}
+			if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+				b.Errorf("Seek failed: %v", err)
+				return
+			}
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				b.Errorf("Read failed: %v", err)
+				return
+			}
+			reader.Close()
+		}
+	})
+}
+
+// zeroReader is an endless reader of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestFileCacheRevalidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "object")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newFileCache(4)
+	cache.revalidate = true
+	defer cache.close()
+
+	read := func() string {
+		reader, err := cache.open(path)
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "old" {
+		t.Fatalf("Expected old, got %q", got)
+	}
+
+	// Another process deletes and recreates the file while the idle handle is cached
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "new content" {
+		t.Errorf("Expected the recreated file to be read, got %q", got)
+	}
+	if n := cache.openCount(); n != 1 {
+		t.Errorf("Expected the stale handle to be closed, got %d open handles", n)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package storage
+
+import "os"
+
+// sameFilesystem is not supported on this platform, paths are assumed to share a filesystem
+// and renames across filesystems fall back to copying
+func sameFilesystem(a, b string) bool {
+	return true
+}
+
+// fileInode is not supported on this platform, files are told apart by their modification time and size only
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
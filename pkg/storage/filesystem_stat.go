@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameFilesystem reports whether the paths a and b are on the same filesystem, so files can be renamed between them
+func sameFilesystem(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return true
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return true
+	}
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+	return !okA || !okB || statA.Dev == statB.Dev
+}
+
+// fileInode returns the inode number of the file described by info, or 0 if it is unknown
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package storage
+
+// FreezeBucket freezes or unfreezes a bucket
+// Objects of a frozen bucket can still be listed and read, but writes and deletes fail with ErrBucketFrozen,
+// and the bucket itself cannot be deleted
+// Freezing waits for the writes being committed, so none commits once the bucket is frozen
+func (s *Storage) FreezeBucket(bucket string, frozen bool) error {
+	if frozen {
+		unlock := s.commits.lockAll()
+		defer unlock()
+	}
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.Frozen = frozen
+	})
+}
+
+// IsBucketFrozen reports whether a bucket is frozen
+func (s *Storage) IsBucketFrozen(bucket string) (bool, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return false, err
+	}
+	return metadata.Frozen, nil
+}
+
+// checkFrozen returns ErrBucketFrozen if the bucket is frozen
+func (s *Storage) checkFrozen(bucket string) error {
+	frozen, err := s.IsBucketFrozen(bucket)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return ErrBucketFrozen
+	}
+	return nil
+}
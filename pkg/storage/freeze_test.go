@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFreezeBucket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-frozen"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("data"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "upload", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	if err := store.FreezeBucket(bucketName, true); err != nil {
+		t.Fatalf("FreezeBucket failed: %v", err)
+	}
+	if frozen, err := store.IsBucketFrozen(bucketName); err != nil || !frozen {
+		t.Fatalf("Expected bucket to be frozen, got %v (%v)", frozen, err)
+	}
+
+	// Reads and listings keep working
+	reader, _, err := store.GetObject(bucketName, "object")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "data" {
+		t.Errorf("Expected data, got %q", data)
+	}
+	if objects, _, err := store.ListObjects(bucketName, "", "", "", 100); err != nil || len(objects) != 1 {
+		t.Errorf("Expected 1 object listed, got %d (%v)", len(objects), err)
+	}
+
+	writes := map[string]func() error{
+		"PutObject": func() error {
+			_, err := store.PutObject(context.Background(), bucketName, "new", strings.NewReader("data"), Metadata{}, "")
+			return err
+		},
+		"DeleteObject": func() error {
+			return store.DeleteObject(bucketName, "object")
+		},
+		"CopyObject": func() error {
+			_, err := store.CopyObject(bucketName, "object", bucketName, "copy", nil)
+			return err
+		},
+		"RenameObject": func() error {
+			return store.RenameObject(bucketName, "object", "renamed")
+		},
+		"InitiateMultipartUpload": func() error {
+			_, err := store.InitiateMultipartUpload(bucketName, "other", Metadata{}, "")
+			return err
+		},
+		"UploadPart": func() error {
+			_, err := store.UploadPart(context.Background(), bucketName, "upload", uploadID, 1, strings.NewReader("data"), "")
+			return err
+		},
+		"DeleteBucket": func() error {
+			return store.DeleteBucket(bucketName)
+		},
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrBucketFrozen {
+			t.Errorf("%s: expected ErrBucketFrozen, got %v", name, err)
+		}
+	}
+
+	if err := store.FreezeBucket(bucketName, false); err != nil {
+		t.Fatalf("Unfreezing failed: %v", err)
+	}
+	if err := store.DeleteObject(bucketName, "object"); err != nil {
+		t.Errorf("Expected writes to work after unfreezing, got %v", err)
+	}
+
+	if err := store.FreezeBucket("missing-bucket", true); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestFreezeBucketDuringWrite(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-frozen"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// A write still receiving its content when the bucket is frozen must not commit
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := store.PutObject(context.Background(), bucketName, "object", pr, Metadata{}, "")
+		done <- err
+	}()
+	if _, err := pw.Write([]byte("data")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.FreezeBucket(bucketName, true); err != nil {
+		t.Fatalf("FreezeBucket failed: %v", err)
+	}
+	pw.Close()
+
+	if err := <-done; err != ErrBucketFrozen {
+		t.Errorf("Expected ErrBucketFrozen, got %v", err)
+	}
+	if _, _, err := store.GetObject(bucketName, "object"); err != ErrObjectNotFound {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+}
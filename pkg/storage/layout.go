@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// layoutFile records the version of the data directory layout
+const layoutFile = ".layout"
+
+// LayoutVersion is the data directory layout used by this version of the storage
+// Version 1 is the layout of data directories created before layout versioning was introduced
+// Version 2 records the creation date of every bucket in its metadata
+// Version 3 may pack the contents of objects into segments indexed in the reference count database
+// Version 4 may shard the directories of object keys, with the fan-out recorded in the layout file
+// Version 5 may compress the data of objects, with the codec recorded in their metadata
+const LayoutVersion = 5
+
+// ErrUnsupportedLayout is returned when the data directory was written by a newer version,
+// or an earlier migration towards such a version did not complete
+var ErrUnsupportedLayout = errors.New("unsupported data directory layout")
+
+// layoutState is the content of the layout file
+type layoutState struct {
+	Version int `json:"version"`
+	// Migrating is the version a migration was started towards, 0 if none is in progress
+	Migrating int `json:"migrating,omitempty"`
+	// ShardFanout is the number of shard directories the directories of object keys are spread over, 0 if not sharded
+	ShardFanout int `json:"shardFanout,omitempty"`
+}
+
+// Migration upgrades the data directory from layout Version-1 to Version
+type Migration struct {
+	Version     int
+	Description string
+	// Migrate upgrades the data directory in place, reporting progress in arbitrary units
+	// It must be idempotent, since an interrupted migration runs again from the start at the next startup
+	Migrate func(s *Storage, progress func(done, total int)) error
+	// Rollback restores the previous layout after Migrate failed
+	// Without it the data directory stays marked as migrating, and the migration is retried at the next startup
+	Rollback func(s *Storage) error
+}
+
+// migrations lists the layout migrations in version order
+var migrations = []Migration{
+	{Version: 2, Description: "Record bucket creation dates", Migrate: migrateBucketCreationDates},
+	{Version: 3, Description: "Allow packed object contents", Migrate: migratePacks},
+	{Version: 4, Description: "Allow sharded object directories", Migrate: migrateSharding},
+	{Version: 5, Description: "Allow compressed object data", Migrate: migrateCompression},
+}
+
+// MigrationProgress reports the progress of a layout migration
+type MigrationProgress func(version int, description string, done, total int)
+
+// WithMigrationProgress sets the function receiving the progress of layout migrations at startup
+func WithMigrationProgress(progress MigrationProgress) Option {
+	return func(s *Storage) {
+		s.migrationProgress = progress
+	}
+}
+
+// loadLayout reads the layout file, returning nil if it does not exist
+func (s *Storage) loadLayout() (*layoutState, error) {
+	data, err := os.ReadFile(filepath.Join(s.basePath, layoutFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state layoutState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid layout file: %w", err)
+	}
+	return &state, nil
+}
+
+// saveLayout atomically replaces the layout file
+func (s *Storage) saveLayout(state *layoutState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := s.tempFile()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return renameFile(tmp.Name(), filepath.Join(s.basePath, layoutFile))
+}
+
+// migrate brings the data directory to the target layout version by running the pending migrations in order
+// The version is only advanced once a migration completed, so a failed migration never leaves
+// the data directory claiming a layout it does not have
+func (s *Storage) migrate(list []Migration, target int) error {
+	state, err := s.loadLayout()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		// Directories without a layout file are either new or predate layout versioning
+		state = &layoutState{Version: 1}
+		if err := s.saveLayout(state); err != nil {
+			return err
+		}
+	}
+
+	if state.Version > target || state.Migrating > target {
+		return fmt.Errorf("%w: version %d, this build supports up to %d", ErrUnsupportedLayout, max(state.Version, state.Migrating), target)
+	}
+
+	for _, m := range list {
+		if m.Version <= state.Version || m.Version > target {
+			continue
+		}
+		if m.Version != state.Version+1 {
+			return fmt.Errorf("%w: no migration from version %d", ErrUnsupportedLayout, state.Version)
+		}
+
+		state.Migrating = m.Version
+		if err := s.saveLayout(state); err != nil {
+			return err
+		}
+
+		progress := func(done, total int) {
+			if s.migrationProgress != nil {
+				s.migrationProgress(m.Version, m.Description, done, total)
+			}
+		}
+		if err := m.Migrate(s, progress); err != nil {
+			if m.Rollback == nil {
+				return fmt.Errorf("migration to layout version %d failed: %w", m.Version, err)
+			}
+			if rollbackErr := m.Rollback(s); rollbackErr != nil {
+				return fmt.Errorf("migration to layout version %d failed: %w, and rolling back failed: %v", m.Version, err, rollbackErr)
+			}
+			state.Migrating = 0
+			if saveErr := s.saveLayout(state); saveErr != nil {
+				return saveErr
+			}
+			return fmt.Errorf("migration to layout version %d failed and was rolled back: %w", m.Version, err)
+		}
+
+		state.Version = m.Version
+		state.Migrating = 0
+		if err := s.saveLayout(state); err != nil {
+			return err
+		}
+	}
+
+	if state.Version != target {
+		return fmt.Errorf("%w: no migration from version %d", ErrUnsupportedLayout, state.Version)
+	}
+	return nil
+}
+
+// checkReplicaLayout verifies that a data directory opened as a read replica has the layout of this build
+// Read replicas never migrate, the writing process does
+func (s *Storage) checkReplicaLayout() error {
+	info, err := os.Stat(s.basePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.basePath)
+	}
+
+	state, err := s.loadLayout()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		// Directories without a layout file predate layout versioning
+		state = &layoutState{Version: 1}
+	}
+	if state.Version != LayoutVersion || state.Migrating != 0 {
+		return fmt.Errorf("%w: version %d, this build reads version %d", ErrUnsupportedLayout, state.Version, LayoutVersion)
+	}
+	return nil
+}
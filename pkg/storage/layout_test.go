@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLayoutVersion(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	state, err := store.loadLayout()
+	if err != nil || state == nil || state.Version != LayoutVersion {
+		t.Fatalf("Expected layout version %d, got %+v (%v)", LayoutVersion, state, err)
+	}
+
+	// A data directory written by a newer version is refused
+	if err := store.saveLayout(&layoutState{Version: LayoutVersion + 1}); err != nil {
+		t.Fatalf("Failed to save layout: %v", err)
+	}
+	store.Close()
+	if _, err := NewStorage(tmpDir); !errors.Is(err, ErrUnsupportedLayout) {
+		t.Errorf("Expected ErrUnsupportedLayout, got %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var reported []int
+	store, err := NewStorage(tmpDir, WithMigrationProgress(func(version int, description string, done, total int) {
+		reported = append(reported, version)
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	// The migrations below start from the first layout
+	if err := store.saveLayout(&layoutState{Version: 1}); err != nil {
+		t.Fatalf("Failed to save layout: %v", err)
+	}
+
+	var ran []int
+	list := []Migration{
+		{Version: 2, Description: "second", Migrate: func(s *Storage, progress func(done, total int)) error {
+			ran = append(ran, 2)
+			progress(1, 1)
+			return nil
+		}},
+		{Version: 3, Description: "third", Migrate: func(s *Storage, progress func(done, total int)) error {
+			ran = append(ran, 3)
+			progress(1, 1)
+			return nil
+		}},
+	}
+
+	if err := store.migrate(list, 3); err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if len(ran) != 2 || ran[0] != 2 || ran[1] != 3 {
+		t.Errorf("Expected migrations 2 and 3 to run in order, got %v", ran)
+	}
+	if len(reported) != 2 {
+		t.Errorf("Expected progress for both migrations, got %v", reported)
+	}
+
+	// Completed migrations do not run again
+	ran = nil
+	if err := store.migrate(list, 3); err != nil || len(ran) != 0 {
+		t.Errorf("Expected no migration to run again, got %v (%v)", ran, err)
+	}
+}
+
+func TestMigrateFailure(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	// The migrations below start from the first layout
+	if err := store.saveLayout(&layoutState{Version: 1}); err != nil {
+		t.Fatalf("Failed to save layout: %v", err)
+	}
+
+	failing := errors.New("disk on fire")
+	attempts := 0
+	list := []Migration{{
+		Version: 2,
+		Migrate: func(s *Storage, progress func(done, total int)) error {
+			attempts++
+			if attempts == 1 {
+				return failing
+			}
+			return nil
+		},
+	}}
+
+	// Without a rollback the directory stays marked as migrating and the migration is retried
+	if err := store.migrate(list, 2); !errors.Is(err, failing) {
+		t.Fatalf("Expected the migration error, got %v", err)
+	}
+	state, _ := store.loadLayout()
+	if state.Version != 1 || state.Migrating != 2 {
+		t.Errorf("Expected version 1 migrating to 2, got %+v", state)
+	}
+	// Builds that do not know the pending version refuse to open the directory
+	if err := store.migrate(nil, 1); !errors.Is(err, ErrUnsupportedLayout) {
+		t.Errorf("Expected ErrUnsupportedLayout, got %v", err)
+	}
+	if err := store.migrate(list, 2); err != nil {
+		t.Fatalf("Expected the retried migration to succeed, got %v", err)
+	}
+	state, _ = store.loadLayout()
+	if state.Version != 2 || state.Migrating != 0 {
+		t.Errorf("Expected version 2, got %+v", state)
+	}
+
+	// A migration with a rollback restores the previous version
+	rolledBack := false
+	list = append(list, Migration{
+		Version: 3,
+		Migrate: func(s *Storage, progress func(done, total int)) error {
+			return failing
+		},
+		Rollback: func(s *Storage) error {
+			rolledBack = true
+			return nil
+		},
+	})
+	if err := store.migrate(list, 3); !errors.Is(err, failing) {
+		t.Fatalf("Expected the migration error, got %v", err)
+	}
+	state, _ = store.loadLayout()
+	if !rolledBack || state.Version != 2 || state.Migrating != 0 {
+		t.Errorf("Expected a rollback to version 2, got %+v (rolled back: %v)", state, rolledBack)
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFile is locked by the process writing to the data directory
+const lockFile = ".lock"
+
+var (
+	// ErrDataDirLocked is returned when another process already writes to the data directory
+	ErrDataDirLocked = errors.New("data directory is locked by another process")
+	// ErrReadReplica is returned for writes to a storage opened as a read replica
+	ErrReadReplica = errors.New("storage is a read replica")
+)
+
+// WithReadReplica opens the data directory for reading only, alongside the process writing to it
+// Read replicas neither take the data directory lock nor run layout migrations,
+// and writes must be rejected before they reach the storage, e.g. with server.WithReadOnly
+func WithReadReplica() Option {
+	return func(s *Storage) {
+		s.readReplica = true
+	}
+}
+
+// IsReadReplica reports whether the storage was opened as a read replica
+func (s *Storage) IsReadReplica() bool {
+	return s.readReplica
+}
+
+// lockDataDir takes the exclusive lock of the data directory, failing immediately if another process holds it
+// The lock is released by the operating system when the process exits, so a crash never leaves it behind
+func lockDataDir(basePath string) (*os.File, error) {
+	path := filepath.Join(basePath, lockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLock(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	if !locked {
+		holder, _ := os.ReadFile(path)
+		f.Close()
+		if pid := strings.TrimSpace(string(holder)); pid != "" {
+			return nil, fmt.Errorf("%w: %s is held by process %s", ErrDataDirLocked, basePath, pid)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrDataDirLocked, basePath)
+	}
+
+	// Record the holder to make the error of the next process actionable
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
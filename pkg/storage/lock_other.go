@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package storage
+
+import (
+	"os"
+)
+
+// tryLock is not supported on this platform, the data directory is not protected against concurrent processes
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
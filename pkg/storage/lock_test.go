@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDataDirLock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(tmpDir, lockFile))
+	if err != nil {
+		t.Fatalf("Failed to open lock file: %v", err)
+	}
+	locked, _ := tryLock(f)
+	f.Close()
+	if locked {
+		store.Close()
+		t.Skip("File locking is not supported on this platform")
+	}
+
+	_, err = NewStorage(tmpDir)
+	if !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("Expected ErrDataDirLocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "process") {
+		t.Errorf("Expected the error to name the holding process, got %v", err)
+	}
+
+	// The lock is released on close
+	store.Close()
+	store, err = NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	store.Close()
+}
+
+func TestReadReplica(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), "test-bucket", "key", strings.NewReader("content"), Metadata{}, ""); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	// Replicas open the data directory while the writer holds the lock
+	replica, err := NewStorage(tmpDir, WithReadReplica())
+	if err != nil {
+		t.Fatalf("Failed to open read replica: %v", err)
+	}
+	defer replica.Close()
+	if !replica.IsReadReplica() {
+		t.Error("Expected a read replica")
+	}
+
+	reader, _, err := replica.GetObject("test-bucket", "key")
+	if err != nil {
+		t.Fatalf("Failed to get object from replica: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "content" {
+		t.Errorf("Expected content, got %q", data)
+	}
+
+	// Replicas refuse data directories they cannot read
+	if err := store.saveLayout(&layoutState{Version: LayoutVersion + 1}); err != nil {
+		t.Fatalf("Failed to save layout: %v", err)
+	}
+	if _, err := NewStorage(tmpDir, WithReadReplica()); !errors.Is(err, ErrUnsupportedLayout) {
+		t.Errorf("Expected ErrUnsupportedLayout, got %v", err)
+	}
+	if _, err := NewStorage(filepath.Join(tmpDir, "missing"), WithReadReplica()); err == nil {
+		t.Error("Expected an error for a missing data directory")
+	}
+}
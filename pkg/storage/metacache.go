@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"maps"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataCacheSize is the default number of parsed object metadata files kept in memory
+const DefaultMetadataCacheSize = 4096
+
+// WithMetadataCacheSize sets how many parsed object metadata files are cached, 0 disables the cache
+func WithMetadataCacheSize(size int) Option {
+	return func(s *Storage) {
+		s.metadata = newMetadataCache(size)
+	}
+}
+
+// WithMetadataCacheMaxAge reloads cached metadata older than maxAge even if the file looks unchanged
+// This is meant for shared filesystems such as NFS, whose coarse or client-cached timestamps
+// can hide a change made by another host, 0 keeps entries until the file changes
+func WithMetadataCacheMaxAge(maxAge time.Duration) Option {
+	return func(s *Storage) {
+		s.metadataMaxAge = maxAge
+	}
+}
+
+// metadataCache is an LRU cache of parsed object metadata keyed by path
+// Entries are validated against the inode, modification time and size of the file,
+// so changes made behind the cache's back, including files renamed over it, are still picked up
+type metadataCache struct {
+	mu   sync.Mutex
+	size int
+	// maxAge is how long an entry is trusted before it is reloaded, 0 for no limit
+	maxAge  time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// metadataEntry is a cached metadata file
+type metadataEntry struct {
+	path     string
+	inode    uint64
+	modTime  time.Time
+	fileSize int64
+	metadata *objectMetadata
+	// loaded is when the file was read
+	loaded time.Time
+}
+
+// newMetadataCache creates a metadata cache holding up to size entries
+func newMetadataCache(size int) *metadataCache {
+	return &metadataCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// load returns the metadata stored at path and the file's info, or nil if it does not exist
+// The returned metadata is a copy that callers may modify
+func (c *metadataCache) load(path string) (*objectMetadata, os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		c.invalidate(path)
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if metadata := c.get(path, info); metadata != nil {
+		return metadata, info, nil
+	}
+
+	metadata, err := loadObjectMetadata(path)
+	if err != nil || metadata == nil {
+		return metadata, info, err
+	}
+	c.put(path, info, metadata)
+	return metadata.clone(), info, nil
+}
+
+// get returns a copy of the cached metadata of path if it matches info
+func (c *metadataCache) get(path string, info os.FileInfo) *objectMetadata {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*metadataEntry)
+	expired := c.maxAge > 0 && time.Since(entry.loaded) > c.maxAge
+	if expired || entry.inode != fileInode(info) || !entry.modTime.Equal(info.ModTime()) || entry.fileSize != info.Size() {
+		c.order.Remove(elem)
+		delete(c.entries, path)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.metadata.clone()
+}
+
+// clone returns a copy of m sharing no maps or slices with it, so callers may modify it
+func (m *objectMetadata) clone() *objectMetadata {
+	cp := *m
+	cp.Metadata.XAmzMeta = maps.Clone(m.Metadata.XAmzMeta)
+	cp.Metadata.Tags = maps.Clone(m.Metadata.Tags)
+	cp.Data = bytes.Clone(m.Data)
+	return &cp
+}
+
+// put stores metadata read from path, evicting the least recently used entries
+func (c *metadataCache) put(path string, info os.FileInfo, metadata *objectMetadata) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &metadataEntry{
+		path:     path,
+		inode:    fileInode(info),
+		modTime:  info.ModTime(),
+		fileSize: info.Size(),
+		metadata: metadata,
+		loaded:   time.Now(),
+	}
+	if elem, ok := c.entries[path]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[path] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*metadataEntry).path)
+	}
+}
+
+// invalidate drops the cached metadata of path
+func (c *metadataCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[path]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, path)
+	}
+}
+
+// loadObjectMetadata loads object metadata through the metadata cache
+func (s *Storage) loadObjectMetadata(path string) (*objectMetadata, error) {
+	metadata, _, err := s.metadata.load(path)
+	return metadata, err
+}
+
+// saveObjectMetadata saves object metadata and invalidates its cache entry
+func (s *Storage) saveObjectMetadata(path string, metadata *objectMetadata) error {
+	defer s.metadata.invalidate(path)
+	return saveObjectMetadata(path, metadata)
+}
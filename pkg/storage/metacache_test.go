@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-metacache"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("first"), Metadata{ContentType: "text/plain"}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, info, err := store.GetObject(bucketName, "object"); err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	} else if info.Metadata.ContentType != "text/plain" {
+		t.Fatalf("Unexpected content type %q", info.Metadata.ContentType)
+	}
+
+	metaPath := filepath.Join(tmpDir, bucketName, "object", metaFile)
+	if _, ok := store.metadata.entries[metaPath]; !ok {
+		t.Fatal("Expected metadata to be cached after GetObject")
+	}
+
+	// Writes invalidate the cached entry
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("second"), Metadata{ContentType: "application/json", Tags: map[string]string{"team": "a"}, XAmzMeta: map[string]string{"owner": "alice"}}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	_, info, err := store.GetObject(bucketName, "object")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if info.Metadata.ContentType != "application/json" || info.Size != int64(len("second")) {
+		t.Errorf("Expected updated object, got content type %q and size %d", info.Metadata.ContentType, info.Size)
+	}
+
+	// Modifying a returned copy must not affect the cache
+	metadata, err := store.loadObjectMetadata(metaPath)
+	if err != nil {
+		t.Fatalf("loadObjectMetadata failed: %v", err)
+	}
+	metadata.ETag = "modified"
+	metadata.Metadata.Tags["team"] = "modified"
+	metadata.Metadata.XAmzMeta["owner"] = "modified"
+	metadata, err = store.loadObjectMetadata(metaPath)
+	if err != nil {
+		t.Fatalf("loadObjectMetadata failed: %v", err)
+	}
+	if metadata.ETag == "modified" || metadata.Metadata.Tags["team"] != "a" || metadata.Metadata.XAmzMeta["owner"] != "alice" {
+		t.Errorf("Expected cached metadata to be unaffected by callers, got %+v", metadata)
+	}
+
+	// Deleted objects are not served from the cache
+	if err := store.DeleteObject(bucketName, "object"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, _, err := store.GetObject(bucketName, "object"); err != ErrObjectNotFound {
+		t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+	}
+}
+
+func TestMetadataCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache := newMetadataCache(2)
+
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		if err := saveObjectMetadata(path, &objectMetadata{ETag: name}); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+		metadata, _, err := cache.load(path)
+		if err != nil || metadata == nil || metadata.ETag != name {
+			t.Fatalf("Unexpected metadata for %s: %v, %v", name, metadata, err)
+		}
+	}
+
+	if cache.order.Len() != 2 {
+		t.Fatalf("Expected 2 cached entries, got %d", cache.order.Len())
+	}
+	if _, ok := cache.entries[filepath.Join(dir, "a")]; ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+}
+
+func TestMetadataCacheMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "meta")
+
+	// Rewrite the file in place behind the cache's back without changing its size or modification time,
+	// as a coarse or client-cached timestamp on a network filesystem would report it
+	rewrite := func(etag string) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := saveObjectMetadata(path+".new", &objectMetadata{ETag: etag}); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+		data, err := os.ReadFile(path + ".new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		maxAge time.Duration
+		want   string
+	}{
+		{0, "a"},
+		{time.Nanosecond, "b"},
+	} {
+		if err := saveObjectMetadata(path, &objectMetadata{ETag: "a"}); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+		cache := newMetadataCache(2)
+		cache.maxAge = tt.maxAge
+		if _, _, err := cache.load(path); err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		rewrite("b")
+		time.Sleep(time.Millisecond)
+		metadata, _, err := cache.load(path)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+		if metadata.ETag != tt.want {
+			t.Errorf("Expected ETag %q with max age %v, got %q", tt.want, tt.maxAge, metadata.ETag)
+		}
+	}
+}
+
+func TestMetadataCacheInode(t *testing.T) {
+	if fileInode(mustStat(t, t.TempDir())) == 0 {
+		t.Skip("Inodes are not supported on this platform")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "meta")
+	if err := saveObjectMetadata(path, &objectMetadata{ETag: "a"}); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	cache := newMetadataCache(2)
+	if _, _, err := cache.load(path); err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+
+	// Another file of the same size and modification time renamed over it is still noticed
+	info := mustStat(t, path)
+	if err := saveObjectMetadata(path, &objectMetadata{ETag: "b"}); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	metadata, _, err := cache.load(path)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if metadata.ETag != "b" {
+		t.Errorf("Expected a file renamed over the cached one to be reloaded, got ETag %q", metadata.ETag)
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
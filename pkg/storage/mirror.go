@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// WithMirrors keeps a copy of every content-addressed object in each of dirs, typically on other disks
+// Reads of an object whose copy in the data directory is missing or unreadable are served from a
+// mirror whose copy still matches the object's digest, and the data directory copy is restored from it
+func WithMirrors(dirs ...string) Option {
+	return func(s *Storage) {
+		s.mirrors = append(s.mirrors, dirs...)
+	}
+}
+
+// initMirrors prepares and locks the mirror directories
+func (s *Storage) initMirrors() error {
+	for i, dir := range s.mirrors {
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if absPath == s.basePath {
+			return fmt.Errorf("mirror %s is the data directory", dir)
+		}
+		s.mirrors[i] = absPath
+
+		if s.readReplica {
+			continue
+		}
+		for _, sub := range []string{objectsDir, tempDir} {
+			if err := os.MkdirAll(filepath.Join(absPath, sub), 0755); err != nil {
+				return err
+			}
+		}
+		lock, err := lockDataDir(absPath)
+		if err != nil {
+			return err
+		}
+		s.mirrorLocks = append(s.mirrorLocks, lock)
+	}
+	return nil
+}
+
+// mirrorPath returns the path of the copy of a content-addressed object in mirror
+func mirrorPath(mirror, digest string) string {
+	return filepath.Join(mirror, objectsDir, digest[:2], digest)
+}
+
+// writeMirrors copies the content-addressed object at srcPath to every mirror lacking it
+func (s *Storage) writeMirrors(srcPath, digest string) error {
+	for _, mirror := range s.mirrors {
+		dst := mirrorPath(mirror, digest)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := copyFile(srcPath, dst, filepath.Join(mirror, tempDir)); err != nil {
+			metrics.Add("mirror_errors", 1)
+			return fmt.Errorf("writing mirror %s: %w", mirror, err)
+		}
+	}
+	return nil
+}
+
+// removeMirrors deletes the copies of a content-addressed object from every mirror
+func (s *Storage) removeMirrors(digest string) error {
+	for _, mirror := range s.mirrors {
+		if err := os.Remove(mirrorPath(mirror, digest)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// heal restores the data directory copy of a content-addressed object from a mirror
+// Mirror copies are verified against the digest first, so a damaged mirror is never propagated
+func (s *Storage) heal(digest string) error {
+	if len(s.mirrors) == 0 {
+		return os.ErrNotExist
+	}
+	if s.readReplica {
+		return ErrReadReplica
+	}
+	objPath, err := s.objectPath(digest)
+	if err != nil {
+		return err
+	}
+
+	for _, mirror := range s.mirrors {
+		src := mirrorPath(mirror, digest)
+		if ok, err := verifyContent(src, digest); err != nil || !ok {
+			continue
+		}
+		if err := copyFile(src, objPath, s.objectsTempDir); err != nil {
+			return err
+		}
+		s.files.evict(objPath)
+		metrics.Add("mirror_heals", 1)
+		log.Printf("Restored object content %s from mirror %s", digest, mirror)
+		return nil
+	}
+	return os.ErrNotExist
+}
+
+// verifyContent reports whether the file at path hashes to digest
+func verifyContent(path, digest string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == digest, nil
+}
+
+// copyFile atomically copies src to dst through a temporary file in tmpDir
+func copyFile(src, dst, tmpDir string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return copyReader(in, dst, tmpDir)
+}
+
+// copyReader atomically writes the content of in to dst through a temporary file in tmpDir
+func copyReader(in io.Reader, dst, tmpDir string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
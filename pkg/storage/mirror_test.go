@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := filepath.Join(tmpDir, "data")
+	mirror1 := filepath.Join(tmpDir, "mirror1")
+	mirror2 := filepath.Join(tmpDir, "mirror2")
+	store, err := NewStorage(dataDir, WithMirrors(mirror1, mirror2))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-mirror"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	content := bytes.Repeat([]byte("mirrored "), 1000)
+	if _, err := store.PutObject(context.Background(), bucketName, "object", bytes.NewReader(content), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	objPath, _ := store.objectPath(digest)
+	for _, mirror := range []string{mirror1, mirror2} {
+		if data, err := os.ReadFile(mirrorPath(mirror, digest)); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("Expected a copy in %s: %v", mirror, err)
+		}
+	}
+
+	// The data directory copy is lost and the first mirror damaged, the second mirror heals the read
+	if err := os.Remove(objPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mirrorPath(mirror1, digest), []byte("damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := store.GetObject(bucketName, "object")
+	if err != nil {
+		t.Fatalf("Expected the read to heal, got %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(data, content) {
+		t.Error("Expected the healed content")
+	}
+	if data, err := os.ReadFile(objPath); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the data directory copy to be restored: %v", err)
+	}
+
+	// Deleting the object removes every copy
+	if err := store.DeleteObject(bucketName, "object"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	for _, mirror := range []string{mirror1, mirror2} {
+		if _, err := os.Stat(mirrorPath(mirror, digest)); !os.IsNotExist(err) {
+			t.Errorf("Expected the copy in %s to be deleted, got %v", mirror, err)
+		}
+	}
+}
+
+func TestMirrorsUnrecoverable(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mirror := filepath.Join(tmpDir, "mirror")
+	store, err := NewStorage(filepath.Join(tmpDir, "data"), WithMirrors(mirror))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-mirror"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	content := bytes.Repeat([]byte("x"), 10000)
+	if _, err := store.PutObject(context.Background(), bucketName, "object", bytes.NewReader(content), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	objPath, _ := store.objectPath(digest)
+
+	// A damaged mirror is never used to restore the data directory
+	os.Remove(objPath)
+	os.WriteFile(mirrorPath(mirror, digest), []byte("damaged"), 0644)
+	if _, _, err := store.GetObject(bucketName, "object"); err != ErrObjectNotFound {
+		t.Errorf("Expected ErrObjectNotFound without an intact copy, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// renameFile renames src to dst, copying it when they are on different filesystems,
+// such as buckets or data directories with their own mounts, which rename cannot cross
+func renameFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	metrics.Add("cross_device_renames", 1)
+	if err := copyFileSync(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// renameDir renames the directory src to dst, copying the tree when they are on different filesystems
+// Every file of the copy is synced and renamed into place before src is removed, so a crash leaves
+// either both trees or a complete dst, and meta files never appear partially written
+func renameDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	metrics.Add("cross_device_renames", 1)
+	err = filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, 0755)
+		case entry.Type().IsRegular():
+			return copyFileSync(path, target)
+		default:
+			return &fs.PathError{Op: "rename", Path: path, Err: errors.New("not a regular file or directory")}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyFileSync copies src to dst through a synced temporary file renamed over dst,
+// keeping the permissions of src
+func copyFileSync(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// syncDir makes the entries of dir durable, where the platform supports syncing directories
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
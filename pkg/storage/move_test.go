@@ -0,0 +1,213 @@
+//go:build linux
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// mountTmpfs mounts a tmpfs on dir, creating it, so renames into it cross filesystems
+func mountTmpfs(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create mount point: %v", err)
+	}
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", 0, ""); err != nil {
+		t.Skipf("Mounting a tmpfs needs privileges: %v", err)
+	}
+	t.Cleanup(func() { syscall.Unmount(dir, syscall.MNT_DETACH) })
+}
+
+func TestRenameAcrossDevices(t *testing.T) {
+	tmpDir := t.TempDir()
+	mnt := filepath.Join(tmpDir, "mnt")
+	mountTmpfs(t, mnt)
+
+	src := filepath.Join(tmpDir, "file")
+	os.WriteFile(src, []byte("content"), 0600)
+	if err := os.Rename(src, filepath.Join(mnt, "probe")); err == nil {
+		t.Fatal("Expected the rename to cross filesystems")
+	}
+	dst := filepath.Join(mnt, "file")
+	if err := renameFile(src, dst); err != nil {
+		t.Fatalf("renameFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "content" {
+		t.Errorf("Expected the file to be moved, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the permissions to be kept, got %v (%v)", info.Mode(), err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Expected the source to be removed, got %v", err)
+	}
+
+	srcDir := filepath.Join(tmpDir, "dir")
+	os.MkdirAll(filepath.Join(srcDir, "nested", "deeper"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "meta"), []byte("top"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "nested", "deeper", "meta"), []byte("deep"), 0644)
+	dstDir := filepath.Join(mnt, "parent", "dir")
+	os.MkdirAll(filepath.Dir(dstDir), 0755)
+	if err := renameDir(srcDir, dstDir); err != nil {
+		t.Fatalf("renameDir failed: %v", err)
+	}
+	for path, want := range map[string]string{"meta": "top", "nested/deeper/meta": "deep"} {
+		if data, err := os.ReadFile(filepath.Join(dstDir, path)); err != nil || string(data) != want {
+			t.Errorf("Expected %s to be moved, got %q (%v)", path, data, err)
+		}
+	}
+	if _, err := os.Stat(srcDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the source tree to be removed, got %v", err)
+	}
+	entries, _ := os.ReadDir(dstDir)
+	if len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestRenameObjectAcrossDevices(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	// Keys under archive/ live on another filesystem
+	mountTmpfs(t, filepath.Join(tmpDir, "bucket", "archive"))
+
+	for key, content := range map[string]string{"report": "report", "report/2024": "nested"} {
+		if _, err := store.PutObject(context.Background(), "bucket", key, bytes.NewReader([]byte(content)), Metadata{ContentType: "text/plain"}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	if err := store.RenameObject("bucket", "report", "archive/report"); err != nil {
+		t.Fatalf("RenameObject failed: %v", err)
+	}
+	for key, want := range map[string]string{"archive/report": "report", "archive/report/2024": "nested"} {
+		reader, info, err := store.GetObject("bucket", key)
+		if err != nil {
+			t.Fatalf("GetObject %s failed: %v", key, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != want || info.Metadata.ContentType != "text/plain" {
+			t.Errorf("Expected %s to hold %q, got %q", key, want, data)
+		}
+	}
+	if _, _, err := store.GetObject("bucket", "report"); err != ErrObjectNotFound {
+		t.Errorf("Expected the source to be gone, got %v", err)
+	}
+}
+
+func TestCompleteMultipartUploadAcrossDevices(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Parts are written on another filesystem than the uploads they are renamed into
+	temp := filepath.Join(tmpDir, "temp")
+	mountTmpfs(t, temp)
+	store, err := NewStorage(filepath.Join(tmpDir, "data"), WithTempDir(temp))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	before := crossDeviceRenames()
+	uploadID, err := store.InitiateMultipartUpload("bucket", "large", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	var parts []Multipart
+	var content []byte
+	for i := 1; i <= 2; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 2*inlineThreshold)
+		info, err := store.UploadPart(context.Background(), "bucket", "large", uploadID, i, bytes.NewReader(data), "")
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		parts = append(parts, Multipart{PartNumber: i, ETag: info.ETag})
+		content = append(content, data...)
+	}
+	if _, err := store.CompleteMultipartUpload(context.Background(), "bucket", "large", uploadID, parts, ""); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	reader, _, err := store.GetObject("bucket", "large")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, content) {
+		t.Errorf("Expected the completed object, got %d bytes", len(data))
+	}
+	if crossDeviceRenames() == before {
+		t.Error("Expected the parts to be copied across filesystems")
+	}
+	if entries, _ := os.ReadDir(store.tempDir); len(entries) != 0 {
+		t.Errorf("Expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestTempDirAcrossDevices(t *testing.T) {
+	tmpDir := t.TempDir()
+	mountTmpfs(t, filepath.Join(tmpDir, objectsDir))
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if want := filepath.Join(tmpDir, objectsDir, tempDir); store.objectsTempDir != want {
+		t.Fatalf("Expected content to be written in %s, got %s", want, store.objectsTempDir)
+	}
+
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	before := crossDeviceRenames()
+	if _, err := store.PutObject(context.Background(), "bucket", "object", bytes.NewReader(bytes.Repeat([]byte("data"), inlineThreshold)), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if crossDeviceRenames() != before {
+		t.Error("Expected the content to be renamed into place without copying")
+	}
+	if digests, err := store.listContent(); err != nil || len(digests) != 1 {
+		t.Errorf("Expected the temporary directory to be left out of the content, got %v (%v)", digests, err)
+	}
+}
+
+func TestProbeReadOnlyFilesystem(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	probe := filepath.Join(tmpDir, probeDir)
+	mountTmpfs(t, probe)
+	if err := syscall.Mount("", probe, "", syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		t.Fatalf("Failed to remount read-only: %v", err)
+	}
+	if err := store.Probe(context.Background()); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected the probe to fail on a read-only filesystem, got %v", err)
+	}
+}
+
+// crossDeviceRenames returns the number of renames that fell back to copying
+func crossDeviceRenames() int64 {
+	if v, ok := metrics.Get("cross_device_renames").(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
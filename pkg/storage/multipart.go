@@ -1,15 +1,18 @@
 package storage
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -20,9 +23,16 @@ func genUploadID() string {
 }
 
 // InitiateMultipartUpload initiates a multipart upload
-func (s *Storage) InitiateMultipartUpload(bucket, key string, userMetadata Metadata) (string, error) {
-	if !s.BucketExists(bucket) {
-		return "", ErrBucketNotFound
+// The metadata and checksum algorithm are kept with the upload and applied when it is completed.
+func (s *Storage) InitiateMultipartUpload(bucket, key string, userMetadata Metadata, checksumAlgorithm string) (string, error) {
+	return s.InitiateMultipartUploadBy(bucket, key, userMetadata, checksumAlgorithm, "")
+}
+
+// InitiateMultipartUploadBy is InitiateMultipartUpload recording the access key of the initiator,
+// empty for anonymous uploads
+func (s *Storage) InitiateMultipartUploadBy(bucket, key string, userMetadata Metadata, checksumAlgorithm, initiator string) (string, error) {
+	if err := s.checkFrozen(bucket); err != nil {
+		return "", err
 	}
 
 	// Validate paths
@@ -44,7 +54,9 @@ func (s *Storage) InitiateMultipartUpload(bucket, key string, userMetadata Metad
 
 	uploadMetaPath := filepath.Join(uploadDir, metaFile)
 	metadata := &uploadMetadata{
-		Metadata: userMetadata,
+		Metadata:          userMetadata,
+		ChecksumAlgorithm: checksumAlgorithm,
+		Initiator:         initiator,
 	}
 	if err := saveUploadMetadata(uploadMetaPath, metadata); err != nil {
 		return "", err
@@ -53,14 +65,57 @@ func (s *Storage) InitiateMultipartUpload(bucket, key string, userMetadata Metad
 	return uploadID, nil
 }
 
-// UploadPart uploads a part of a multipart upload
-// If expectedChecksumSHA256 is provided (non-empty), it validates the checksum after computing.
-func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+// GetMultipartUpload returns an in-progress multipart upload
+func (s *Storage) GetMultipartUpload(bucket, key, uploadID string) (*MultipartUpload, error) {
 	if !s.BucketExists(bucket) {
 		return nil, ErrBucketNotFound
 	}
 
-	if partNumber < 1 || partNumber > 10000 {
+	// Check filesystem for upload directory
+	uploadDir := filepath.Join(s.basePath, uploadsDir, bucket, key, uploadID)
+	info, err := os.Stat(uploadDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrInvalidUploadID
+		}
+		return nil, err
+	}
+
+	metadata, err := loadUploadMetadata(filepath.Join(uploadDir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, ErrInvalidUploadID
+	}
+
+	return &MultipartUpload{
+		UploadID:          uploadID,
+		Bucket:            bucket,
+		Key:               key,
+		ModTime:           info.ModTime(),
+		ChecksumAlgorithm: metadata.ChecksumAlgorithm,
+		Initiator:         metadata.Initiator,
+	}, nil
+}
+
+// UploadPart uploads a part of a multipart upload
+// If expectedChecksumSHA256 is provided (non-empty), it validates the checksum after computing.
+// Reading data stops once ctx is done.
+func (s *Storage) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data io.Reader, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	info, err := s.uploadPart(bucket, key, uploadID, partNumber, contextReader(ctx, data), expectedChecksumSHA256)
+	return info, s.diskError(err)
+}
+
+func (s *Storage) uploadPart(bucket, key, uploadID string, partNumber int, data io.Reader, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+	if err := s.checkFrozen(bucket); err != nil {
+		return nil, err
+	}
+
+	if partNumber < 1 || partNumber > maxPartNumber {
 		return nil, ErrInvalidPartNumber
 	}
 
@@ -70,6 +125,22 @@ func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data
 		return nil, ErrInvalidUploadID
 	}
 
+	existingETag, err := existingPartETag(uploadDir, partNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// The part may not push the upload over the maximum object size
+	uploadedSize, err := uploadSize(uploadDir, partNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// A retried part whose checksum matches the stored part does not need to be written again
+	if existingETag != "" && expectedChecksumSHA256 != "" && urlSafeToStdBase64(existingETag) == expectedChecksumSHA256 {
+		return s.partInfo(uploadDir, key, partNumber, existingETag)
+	}
+
 	// Create temp file
 	tmpFile, err := s.tempFile()
 	if err != nil {
@@ -81,7 +152,7 @@ func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data
 	hash := sha256.New()
 	writer := io.MultiWriter(tmpFile, hash)
 
-	_, err = io.Copy(writer, data)
+	_, err = copyLimited(writer, data, min(s.maxPartSize, s.maxObjectSize-uploadedSize))
 	if err != nil {
 		tmpFile.Close()
 		return nil, err
@@ -96,12 +167,79 @@ func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data
 		return nil, ErrChecksumMismatch
 	}
 
+	// Like a duplicate PutObject, a retried part with the same digest as the stored part
+	// keeps the stored part, the received data is dropped with the temp file
+	if existingETag == etag {
+		return s.partInfo(uploadDir, key, partNumber, etag)
+	}
+
+	if err := storePart(uploadDir, partNumber, tmpFile.Name(), etag, existingETag); err != nil {
+		return nil, err
+	}
+
+	return s.partInfo(uploadDir, key, partNumber, etag)
+}
+
+// uploadSize returns the total size of the stored parts of an upload,
+// not counting the part with the given number which is about to be replaced
+func uploadSize(uploadDir string, excludePartNumber int) (int64, error) {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := fmt.Sprintf("%d-", excludePartNumber)
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == metaFile || strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// existingPartETag returns the ETag of the stored part with the given number,
+// or an empty string if the part has not been uploaded yet
+func existingPartETag(uploadDir string, partNumber int) (string, error) {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := fmt.Sprintf("%d-", partNumber)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			return strings.TrimPrefix(entry.Name(), prefix), nil
+		}
+	}
+	return "", nil
+}
+
+// storePart moves the temp file into place as the part with the given number, replacing the previous part
+func storePart(uploadDir string, partNumber int, tmpPath, etag, existingETag string) error {
 	partPath := filepath.Join(uploadDir, fmt.Sprintf("%d-%s", partNumber, etag))
 
 	// Move temp file to part file
-	if err := os.Rename(tmpFile.Name(), partPath); err != nil {
-		return nil, err
+	if err := renameFile(tmpPath, partPath); err != nil {
+		return err
+	}
+
+	if existingETag != "" {
+		if err := os.Remove(filepath.Join(uploadDir, fmt.Sprintf("%d-%s", partNumber, existingETag))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
+	return nil
+}
+
+// partInfo returns the ObjectInfo of a stored part
+func (s *Storage) partInfo(uploadDir, key string, partNumber int, etag string) (*ObjectInfo, error) {
+	partPath := filepath.Join(uploadDir, fmt.Sprintf("%d-%s", partNumber, etag))
 
 	// Get file info for size and mod time
 	partFileInfo, err := os.Stat(partPath)
@@ -111,7 +249,13 @@ func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data
 
 	// Load upload metadata for content type
 	uploadMetaPath := filepath.Join(uploadDir, metaFile)
-	metadata, _ := loadUploadMetadata(uploadMetaPath)
+	metadata, err := loadUploadMetadata(uploadMetaPath)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, ErrInvalidUploadID
+	}
 
 	return &ObjectInfo{
 		Key:            key,
@@ -126,12 +270,21 @@ func (s *Storage) UploadPart(bucket, key, uploadID string, partNumber int, data
 // UploadPartCopy uploads a part of a multipart upload by copying from an existing object
 // If startByte and endByte are both >= 0, only the specified byte range is copied.
 // If startByte is < 0, the entire source object is copied.
-func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, srcBucket, srcKey string, startByte, endByte int64) (*ObjectInfo, error) {
-	if !s.BucketExists(bucket) {
-		return nil, ErrBucketNotFound
+// The copy stops once ctx is done.
+func (s *Storage) UploadPartCopy(ctx context.Context, bucket, key, uploadID string, partNumber int, srcBucket, srcKey string, startByte, endByte int64) (*ObjectInfo, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	info, err := s.uploadPartCopy(ctx, bucket, key, uploadID, partNumber, srcBucket, srcKey, startByte, endByte)
+	return info, s.diskError(err)
+}
+
+func (s *Storage) uploadPartCopy(ctx context.Context, bucket, key, uploadID string, partNumber int, srcBucket, srcKey string, startByte, endByte int64) (*ObjectInfo, error) {
+	if err := s.checkFrozen(bucket); err != nil {
+		return nil, err
 	}
 
-	if partNumber < 1 || partNumber > 10000 {
+	if partNumber < 1 || partNumber > maxPartNumber {
 		return nil, ErrInvalidPartNumber
 	}
 
@@ -155,7 +308,7 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 	srcMetaPath := filepath.Join(srcObjectDir, metaFile)
 
 	// Load source metadata
-	srcMetadata, err := loadObjectMetadata(srcMetaPath)
+	srcMetadata, err := s.loadObjectMetadata(srcMetaPath)
 	if err != nil {
 		return nil, err
 	}
@@ -168,18 +321,13 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 	if len(srcMetadata.Data) > 0 {
 		srcSize = int64(len(srcMetadata.Data))
 	} else if srcMetadata.Digest != "" {
-		objPath, err := s.objectPath(srcMetadata.Digest)
-		if err != nil {
-			return nil, err
-		}
-		srcFileInfo, err := os.Stat(objPath)
+		srcSize, err = s.digestSize(srcMetadata)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return nil, ErrObjectNotFound
 			}
 			return nil, err
 		}
-		srcSize = srcFileInfo.Size()
 	}
 
 	// Validate byte range if specified
@@ -190,6 +338,38 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 		}
 	}
 
+	copySize := srcSize
+	if hasRange {
+		copySize = endByte - startByte + 1
+	}
+	uploadedSize, err := uploadSize(uploadDir, partNumber)
+	if err != nil {
+		return nil, err
+	}
+	if copySize > s.maxPartSize || uploadedSize+copySize > s.maxObjectSize {
+		return nil, ErrEntityTooLarge
+	}
+
+	existingETag, err := existingPartETag(uploadDir, partNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// A part holding a whole object shares its data file rather than copying and hashing it again,
+	// its content and so its ETag being those of the object
+	if copySize == srcSize {
+		if clonePath := s.cloneContent(srcMetadata); clonePath != "" {
+			defer os.Remove(clonePath)
+			// Parts are dated by their upload, a hard link shares the date of the data file otherwise
+			now := time.Now()
+			os.Chtimes(clonePath, now, now)
+			if err := storePart(uploadDir, partNumber, clonePath, srcMetadata.ETag, existingETag); err != nil {
+				return nil, err
+			}
+			return s.partInfo(uploadDir, key, partNumber, srcMetadata.ETag)
+		}
+	}
+
 	// Create temp file
 	tmpFile, err := s.tempFile()
 	if err != nil {
@@ -197,9 +377,12 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 	}
 	defer os.Remove(tmpFile.Name())
 
-	// Calculate SHA256 while copying
+	// Calculate SHA256 while copying a range, a whole object keeps its ETag
 	hash := sha256.New()
-	writer := io.MultiWriter(tmpFile, hash)
+	var writer io.Writer = tmpFile
+	if copySize != srcSize {
+		writer = io.MultiWriter(tmpFile, hash)
+	}
 
 	// Copy data from source (either inline or digest)
 	if len(srcMetadata.Data) > 0 {
@@ -211,12 +394,12 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 		}
 	} else if srcMetadata.Digest != "" {
 		// Data is in content-addressable storage
-		srcFile, err := s.getContentAddressedObject(srcMetadata.Digest)
-		if err != nil {
-			if os.IsNotExist(err) {
+		srcFile, openErr := s.openObjectContent(srcMetadata)
+		if openErr != nil {
+			if os.IsNotExist(openErr) {
 				return nil, ErrObjectNotFound
 			}
-			return nil, err
+			return nil, openErr
 		}
 		defer srcFile.Close()
 
@@ -225,9 +408,9 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 			if _, err := srcFile.Seek(startByte, io.SeekStart); err != nil {
 				return nil, err
 			}
-			_, err = io.CopyN(writer, srcFile, endByte-startByte+1)
+			_, err = io.CopyN(writer, contextReader(ctx, srcFile), endByte-startByte+1)
 		} else {
-			_, err = io.Copy(writer, srcFile)
+			_, err = io.Copy(writer, contextReader(ctx, srcFile))
 		}
 	}
 
@@ -237,37 +420,39 @@ func (s *Storage) UploadPartCopy(bucket, key, uploadID string, partNumber int, s
 	}
 	tmpFile.Close()
 
-	etag := base64.URLEncoding.EncodeToString(hash.Sum(nil))
-
-	partPath := filepath.Join(uploadDir, fmt.Sprintf("%d-%s", partNumber, etag))
-
-	// Move temp file to part file
-	if err := os.Rename(tmpFile.Name(), partPath); err != nil {
-		return nil, err
+	etag := srcMetadata.ETag
+	if copySize != srcSize {
+		etag = base64.URLEncoding.EncodeToString(hash.Sum(nil))
 	}
 
-	// Get file info for size and mod time
-	partFileInfo, err := os.Stat(partPath)
-	if err != nil {
+	if err := storePart(uploadDir, partNumber, tmpFile.Name(), etag, existingETag); err != nil {
 		return nil, err
 	}
 
-	// Load upload metadata for content type
-	uploadMetaPath := filepath.Join(uploadDir, metaFile)
-	metadata, _ := loadUploadMetadata(uploadMetaPath)
-
-	return &ObjectInfo{
-		Key:            key,
-		Size:           partFileInfo.Size(),
-		ETag:           etag,
-		ChecksumSHA256: urlSafeToStdBase64(etag),
-		ModTime:        partFileInfo.ModTime(),
-		Metadata:       metadata.Metadata,
-	}, nil
+	return s.partInfo(uploadDir, key, partNumber, etag)
 }
 
 // CompleteMultipartUpload completes a multipart upload
-func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []Multipart, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+// Concatenating the parts stops once ctx is done, leaving the upload in place
+func (s *Storage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Multipart, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+	return s.CompleteMultipartUploadIf(ctx, bucket, key, uploadID, parts, expectedChecksumSHA256, Condition{})
+}
+
+// CompleteMultipartUploadIf completes a multipart upload like CompleteMultipartUpload if cond holds
+// for the object it replaces, keeping the upload for another attempt otherwise
+func (s *Storage) CompleteMultipartUploadIf(ctx context.Context, bucket, key, uploadID string, parts []Multipart, expectedChecksumSHA256 string, cond Condition) (*ObjectInfo, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	info, err := s.completeMultipartUpload(ctx, bucket, key, uploadID, parts, expectedChecksumSHA256, cond)
+	if err != nil {
+		return info, s.diskError(err)
+	}
+	s.publishWrite(EventObjectCreated, bucket, info, "", "")
+	return info, nil
+}
+
+func (s *Storage) completeMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Multipart, expectedChecksumSHA256 string, cond Condition) (*ObjectInfo, error) {
 	if !s.BucketExists(bucket) {
 		return nil, ErrBucketNotFound
 	}
@@ -285,13 +470,8 @@ func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []
 
 	metaPath := filepath.Join(objectDir, metaFile)
 
-	// Create object directory
-	if err := os.MkdirAll(objectDir, 0755); err != nil {
-		return nil, err
-	}
-
 	// Create temp file for final object
-	tmpFile, err := s.tempFile()
+	tmpFile, err := s.contentTempFile()
 	if err != nil {
 		return nil, err
 	}
@@ -300,6 +480,7 @@ func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []
 	hash := sha256.New()
 
 	// Concatenate parts in order
+	var totalSize int64
 	for _, part := range parts {
 		// Strip quotes from ETag if present (client may send quoted ETags)
 		etag := strings.Trim(part.ETag, `"`)
@@ -313,14 +494,35 @@ func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []
 			}
 		}
 
+		// The ETag names the part file, so it must not be able to point outside of the upload
+		if etag == "" || strings.ContainsAny(etag, `/\`) {
+			tmpFile.Close()
+			return nil, ErrInvalidPart
+		}
 		partPath := filepath.Join(uploadDir, fmt.Sprintf("%d-%s", part.PartNumber, etag))
 		partFile, err := os.Open(partPath)
 		if err != nil {
+			tmpFile.Close()
+			if os.IsNotExist(err) {
+				return nil, ErrInvalidPart
+			}
+			return nil, err
+		}
+
+		partFileInfo, err := partFile.Stat()
+		if err != nil {
+			partFile.Close()
 			tmpFile.Close()
 			return nil, err
 		}
+		totalSize += partFileInfo.Size()
+		if totalSize > s.maxObjectSize {
+			partFile.Close()
+			tmpFile.Close()
+			return nil, ErrEntityTooLarge
+		}
 
-		if _, err := io.Copy(io.MultiWriter(tmpFile, hash), partFile); err != nil {
+		if _, err := io.Copy(io.MultiWriter(tmpFile, hash), contextReader(ctx, partFile)); err != nil {
 			partFile.Close()
 			tmpFile.Close()
 			return nil, err
@@ -351,9 +553,24 @@ func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []
 		return nil, err
 	}
 
+	unlock := s.commits.lock(metaPath)
+	defer unlock()
+
+	if err := s.checkWritable(bucket, key); err != nil {
+		return nil, err
+	}
+
+	// Create object directory, under the commit lock like putObject
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return nil, err
+	}
+
 	var existingMetadata *objectMetadata
 	if _, err := os.Stat(metaPath); err == nil {
-		existingMetadata, _ = loadObjectMetadata(metaPath)
+		existingMetadata, _ = s.loadObjectMetadata(metaPath)
+	}
+	if err := cond.check(existingMetadata); err != nil {
+		return nil, err
 	}
 
 	// Use content-addressable storage for all multipart uploads (they're typically large)
@@ -362,16 +579,28 @@ func (s *Storage) CompleteMultipartUpload(bucket, key, uploadID string, parts []
 	// Create object metadata from upload metadata
 	meta := &objectMetadata{
 		ETag:     etag,
-		Digest:   digest,
 		Metadata: uploadMetadata.Metadata,
 	}
 
+	dataPath := tmpFile.Name()
+	compressedPath, compressedDigest, err := s.compressContent(dataPath, fileInfo.Size(), uploadMetadata.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if compressedPath != "" {
+		defer os.Remove(compressedPath)
+		dataPath, digest = compressedPath, compressedDigest
+		meta.Compression = compressionZstd
+		meta.Size = fileInfo.Size()
+	}
+	meta.Digest = digest
+
 	// Store in content-addressable storage
-	if err := s.storeContentAddressedObject(tmpFile.Name(), digest); err != nil {
+	if err := s.storeContentAddressedObject(dataPath, digest); err != nil {
 		return nil, err
 	}
 
-	if err := saveObjectMetadata(metaPath, meta); err != nil {
+	if err := s.saveObjectMetadata(metaPath, meta); err != nil {
 		return nil, err
 	}
 	// Decrement refcount for old object if it had a digest and it's different
@@ -505,6 +734,10 @@ func (s *Storage) ListMultipartUploads(bucket, prefix, keyMarker, uploadIDMarker
 			Key:      key,
 			ModTime:  info.ModTime(),
 		}
+		if metadata, err := loadUploadMetadata(metaPath); err == nil && metadata != nil {
+			upload.ChecksumAlgorithm = metadata.ChecksumAlgorithm
+			upload.Initiator = metadata.Initiator
+		}
 
 		uploads = append(uploads, upload)
 		return nil
@@ -530,6 +763,21 @@ func (s *Storage) ListMultipartUploads(bucket, prefix, keyMarker, uploadIDMarker
 	return uploads, nil
 }
 
+// fileChecksumCRC32 returns the base64 encoded CRC32 checksum of a file
+func fileChecksumCRC32(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
 // ListParts lists all uploaded parts for a multipart upload with pagination support
 func (s *Storage) ListParts(bucket, key, uploadID string, partNumberMarker, maxParts int) ([]Part, error) {
 	if !s.BucketExists(bucket) {
@@ -547,6 +795,11 @@ func (s *Storage) ListParts(bucket, key, uploadID string, partNumberMarker, maxP
 		return nil, err
 	}
 
+	var checksumAlgorithm string
+	if metadata, err := loadUploadMetadata(filepath.Join(uploadDir, metaFile)); err == nil && metadata != nil {
+		checksumAlgorithm = metadata.ChecksumAlgorithm
+	}
+
 	var parts []Part
 	for _, entry := range entries {
 		if entry.IsDir() {
@@ -572,10 +825,19 @@ func (s *Storage) ListParts(bucket, key, uploadID string, partNumberMarker, maxP
 		}
 
 		part := Part{
-			PartNumber: partNumber,
-			ETag:       etag,
-			Size:       info.Size(),
-			ModTime:    info.ModTime(),
+			PartNumber:     partNumber,
+			ETag:           etag,
+			ChecksumSHA256: urlSafeToStdBase64(etag),
+			Size:           info.Size(),
+			ModTime:        info.ModTime(),
+		}
+
+		if checksumAlgorithm == "CRC32" {
+			checksum, err := fileChecksumCRC32(filepath.Join(uploadDir, name))
+			if err != nil {
+				return nil, err
+			}
+			part.ChecksumCRC32 = checksum
 		}
 
 		parts = append(parts, part)
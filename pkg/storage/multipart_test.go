@@ -2,8 +2,12 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -30,7 +34,7 @@ func TestMultipartUpload(t *testing.T) {
 	}
 
 	// Initiate multipart upload
-	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{})
+	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
@@ -43,12 +47,12 @@ func TestMultipartUpload(t *testing.T) {
 	part1Content := "Part 1 content"
 	part2Content := "Part 2 content"
 
-	objInfo1, err := store.UploadPart(bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte(part1Content)), "")
+	objInfo1, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte(part1Content)), "")
 	if err != nil {
 		t.Fatalf("UploadPart 1 failed: %v", err)
 	}
 
-	objInfo2, err := store.UploadPart(bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte(part2Content)), "")
+	objInfo2, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte(part2Content)), "")
 	if err != nil {
 		t.Fatalf("UploadPart 2 failed: %v", err)
 	}
@@ -59,7 +63,7 @@ func TestMultipartUpload(t *testing.T) {
 		{PartNumber: 2, ETag: objInfo2.ETag},
 	}
 
-	finalObjInfo, err := store.CompleteMultipartUpload(bucketName, objectKey, uploadID, parts, "")
+	finalObjInfo, err := store.CompleteMultipartUpload(context.Background(), bucketName, objectKey, uploadID, parts, "")
 	if err != nil {
 		t.Fatalf("CompleteMultipartUpload failed: %v", err)
 	}
@@ -113,13 +117,13 @@ func TestAbortMultipartUpload(t *testing.T) {
 	}
 
 	// Initiate multipart upload
-	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{})
+	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
 
 	// Upload a part
-	_, err = store.UploadPart(bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("test")), "")
+	_, err = store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("test")), "")
 	if err != nil {
 		t.Fatalf("UploadPart failed: %v", err)
 	}
@@ -131,12 +135,253 @@ func TestAbortMultipartUpload(t *testing.T) {
 	}
 
 	// Verify upload is aborted
-	_, err = store.UploadPart(bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte("test")), "")
+	_, err = store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte("test")), "")
 	if err != ErrInvalidUploadID {
 		t.Fatal("Expected ErrInvalidUploadID after abort")
 	}
 }
 
+func TestMultipartUploadMetadata(t *testing.T) {
+	// Create temp directory
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket-upload-metadata"
+	objectKey := "metadata-multipart.txt"
+
+	// Create bucket
+	err = store.CreateBucket(bucketName)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	metadata := Metadata{
+		CacheControl: "max-age=3600",
+		ContentType:  "text/plain",
+		XAmzMeta:     map[string]string{"author": "test"},
+		Tags:         map[string]string{"env": "dev"},
+	}
+
+	// Initiate multipart upload
+	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, metadata, "SHA256")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	upload, err := store.GetMultipartUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		t.Fatalf("GetMultipartUpload failed: %v", err)
+	}
+	if upload.ChecksumAlgorithm != "SHA256" {
+		t.Errorf("Expected checksum algorithm SHA256, got %q", upload.ChecksumAlgorithm)
+	}
+
+	uploads, err := store.ListMultipartUploads(bucketName, "", "", "", 0)
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	if len(uploads) != 1 || uploads[0].ChecksumAlgorithm != "SHA256" {
+		t.Errorf("Expected one upload with checksum algorithm SHA256, got %+v", uploads)
+	}
+
+	part, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("test")), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	_, err = store.CompleteMultipartUpload(context.Background(), bucketName, objectKey, uploadID, []Multipart{
+		{PartNumber: 1, ETag: part.ETag},
+	}, "")
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	reader, info, err := store.GetObject(bucketName, objectKey)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	reader.Close()
+
+	if !metadataEqual(info.Metadata, metadata) {
+		t.Errorf("Expected metadata %+v, got %+v", metadata, info.Metadata)
+	}
+
+	_, err = store.GetMultipartUpload(bucketName, objectKey, uploadID)
+	if err != ErrInvalidUploadID {
+		t.Errorf("Expected ErrInvalidUploadID after complete, got %v", err)
+	}
+}
+
+// failingReader fails every read, to verify data is not consumed
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("unexpected read")
+}
+
+func TestUploadPartDuplicate(t *testing.T) {
+	// Create temp directory
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket-duplicate-part"
+	objectKey := "duplicate-part.txt"
+
+	// Create bucket
+	err = store.CreateBucket(bucketName)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	first, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("part data")), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	uploadDir := filepath.Join(tmpDir, uploadsDir, bucketName, objectKey, uploadID)
+	partPath := filepath.Join(uploadDir, "1-"+first.ETag)
+	stored, err := os.Stat(partPath)
+	if err != nil {
+		t.Fatalf("Failed to stat part: %v", err)
+	}
+
+	// Retrying with the same content and no checksum header is deduplicated by the digest of the body
+	second, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("part data")), "")
+	if err != nil {
+		t.Fatalf("UploadPart retry failed: %v", err)
+	}
+	if second.ETag != first.ETag || !second.ModTime.Equal(first.ModTime) {
+		t.Errorf("Expected retried part to be unchanged, got %+v and %+v", first, second)
+	}
+	retried, err := os.Stat(partPath)
+	if err != nil {
+		t.Fatalf("Failed to stat retried part: %v", err)
+	}
+	if !os.SameFile(stored, retried) {
+		t.Error("Expected the stored part file to be kept, it was replaced")
+	}
+
+	// Retrying with a matching checksum does not read the data at all
+	third, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, failingReader{}, first.ChecksumSHA256)
+	if err != nil {
+		t.Fatalf("UploadPart retry with checksum failed: %v", err)
+	}
+	if third.ETag != first.ETag {
+		t.Errorf("Expected ETag %s, got %s", first.ETag, third.ETag)
+	}
+
+	// Uploading different content replaces the part
+	replaced, err := store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("new part data")), "")
+	if err != nil {
+		t.Fatalf("UploadPart replace failed: %v", err)
+	}
+
+	parts, err := store.ListParts(bucketName, objectKey, uploadID, 0, 0)
+	if err != nil {
+		t.Fatalf("ListParts failed: %v", err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("Expected 1 part, got %d", len(parts))
+	}
+	if parts[0].ETag != replaced.ETag {
+		t.Errorf("Expected ETag %s, got %s", replaced.ETag, parts[0].ETag)
+	}
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	// Create temp directory
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir, WithMaxPartSize(10), WithMaxObjectSize(15))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket-size-limits"
+
+	// Create bucket
+	err = store.CreateBucket(bucketName)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	_, err = store.PutObject(context.Background(), bucketName, "too-large.txt", bytes.NewReader(make([]byte, 11)), Metadata{}, "")
+	if err != ErrEntityTooLarge {
+		t.Fatalf("Expected ErrEntityTooLarge for PutObject, got %v", err)
+	}
+	_, err = store.PutObject(context.Background(), bucketName, "source.txt", bytes.NewReader(make([]byte, 10)), Metadata{}, "")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Imports are only limited by the maximum object size
+	if _, err := store.ImportObject(context.Background(), bucketName, "imported.txt", bytes.NewReader(make([]byte, 15)), Metadata{}); err != nil {
+		t.Fatalf("ImportObject failed: %v", err)
+	}
+	if _, err := store.ImportObject(context.Background(), bucketName, "imported.txt", bytes.NewReader(make([]byte, 16)), Metadata{}); err != ErrEntityTooLarge {
+		t.Fatalf("Expected ErrEntityTooLarge for ImportObject, got %v", err)
+	}
+
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "multipart.txt", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	_, err = store.UploadPart(context.Background(), bucketName, "multipart.txt", uploadID, 1, bytes.NewReader(make([]byte, 11)), "")
+	if err != ErrEntityTooLarge {
+		t.Fatalf("Expected ErrEntityTooLarge for a part over the part size limit, got %v", err)
+	}
+	_, err = store.UploadPart(context.Background(), bucketName, "multipart.txt", uploadID, 1, bytes.NewReader(make([]byte, 10)), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	// The total size of the upload is limited by the object size
+	_, err = store.UploadPart(context.Background(), bucketName, "multipart.txt", uploadID, 2, bytes.NewReader(make([]byte, 6)), "")
+	if err != ErrEntityTooLarge {
+		t.Fatalf("Expected ErrEntityTooLarge for a part over the object size limit, got %v", err)
+	}
+	_, err = store.UploadPartCopy(context.Background(), bucketName, "multipart.txt", uploadID, 2, bucketName, "source.txt", -1, -1)
+	if err != ErrEntityTooLarge {
+		t.Fatalf("Expected ErrEntityTooLarge for a copied part over the object size limit, got %v", err)
+	}
+	_, err = store.UploadPart(context.Background(), bucketName, "multipart.txt", uploadID, 2, bytes.NewReader(make([]byte, 5)), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	// Replacing a part does not count its previous size
+	_, err = store.UploadPart(context.Background(), bucketName, "multipart.txt", uploadID, 1, bytes.NewReader(bytes.Repeat([]byte("a"), 10)), "")
+	if err != nil {
+		t.Fatalf("UploadPart replace failed: %v", err)
+	}
+}
+
 func TestListMultipartUploads(t *testing.T) {
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "storage-test-*")
@@ -159,17 +404,17 @@ func TestListMultipartUploads(t *testing.T) {
 	}
 
 	// Initiate multiple uploads
-	uploadID1, err := store.InitiateMultipartUpload(bucketName, "file1.txt", Metadata{})
+	uploadID1, err := store.InitiateMultipartUpload(bucketName, "file1.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
 
-	uploadID2, err := store.InitiateMultipartUpload(bucketName, "file2.txt", Metadata{})
+	uploadID2, err := store.InitiateMultipartUpload(bucketName, "file2.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
 
-	uploadID3, err := store.InitiateMultipartUpload(bucketName, "prefix/file3.txt", Metadata{})
+	uploadID3, err := store.InitiateMultipartUpload(bucketName, "prefix/file3.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
@@ -200,6 +445,54 @@ func TestListMultipartUploads(t *testing.T) {
 	store.AbortMultipartUpload(bucketName, "prefix/file3.txt", uploadID3)
 }
 
+func TestMultipartUploadInitiator(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	initiated := map[string]string{}
+	for _, initiator := range []string{"user1-key", "user2-key", ""} {
+		uploadID, err := store.InitiateMultipartUploadBy(bucketName, "object", Metadata{}, "", initiator)
+		if err != nil {
+			t.Fatalf("InitiateMultipartUploadBy failed: %v", err)
+		}
+		initiated[uploadID] = initiator
+
+		upload, err := store.GetMultipartUpload(bucketName, "object", uploadID)
+		if err != nil {
+			t.Fatalf("GetMultipartUpload failed: %v", err)
+		}
+		if upload.Initiator != initiator {
+			t.Errorf("Expected initiator %q, got %q", initiator, upload.Initiator)
+		}
+	}
+
+	uploads, err := store.ListMultipartUploads(bucketName, "", "", "", 0)
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	if len(uploads) != len(initiated) {
+		t.Fatalf("Expected %d uploads, got %d", len(initiated), len(uploads))
+	}
+	for _, upload := range uploads {
+		if want := initiated[upload.UploadID]; upload.Initiator != want {
+			t.Errorf("Expected initiator %q for upload %s, got %q", want, upload.UploadID, upload.Initiator)
+		}
+	}
+}
+
 func TestListParts(t *testing.T) {
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "storage-test-*")
@@ -223,23 +516,23 @@ func TestListParts(t *testing.T) {
 	}
 
 	// Initiate upload
-	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{})
+	uploadID, err := store.InitiateMultipartUpload(bucketName, objectKey, Metadata{}, "")
 	if err != nil {
 		t.Fatalf("InitiateMultipartUpload failed: %v", err)
 	}
 
 	// Upload parts
-	_, err = store.UploadPart(bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("part1")), "")
+	_, err = store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 1, bytes.NewReader([]byte("part1")), "")
 	if err != nil {
 		t.Fatalf("UploadPart 1 failed: %v", err)
 	}
 
-	_, err = store.UploadPart(bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte("part2")), "")
+	_, err = store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 2, bytes.NewReader([]byte("part2")), "")
 	if err != nil {
 		t.Fatalf("UploadPart 2 failed: %v", err)
 	}
 
-	_, err = store.UploadPart(bucketName, objectKey, uploadID, 3, bytes.NewReader([]byte("part3")), "")
+	_, err = store.UploadPart(context.Background(), bucketName, objectKey, uploadID, 3, bytes.NewReader([]byte("part3")), "")
 	if err != nil {
 		t.Fatalf("UploadPart 3 failed: %v", err)
 	}
@@ -282,7 +575,7 @@ func TestInvalidUploadID(t *testing.T) {
 	}
 
 	// Try to upload part with invalid upload ID
-	_, err = store.UploadPart("test-bucket", "key.txt", "invalid-upload-id", 1, bytes.NewReader([]byte("data")), "")
+	_, err = store.UploadPart(context.Background(), "test-bucket", "key.txt", "invalid-upload-id", 1, bytes.NewReader([]byte("data")), "")
 	if err != ErrInvalidUploadID {
 		t.Fatalf("Expected ErrInvalidUploadID, got %v", err)
 	}
@@ -304,7 +597,7 @@ func TestInvalidPartNumber(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	uploadID, err := store.InitiateMultipartUpload("test-bucket", "key.txt", Metadata{})
+	uploadID, err := store.InitiateMultipartUpload("test-bucket", "key.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -312,7 +605,7 @@ func TestInvalidPartNumber(t *testing.T) {
 	// Test invalid part numbers
 	invalidParts := []int{0, -1, 10001}
 	for _, partNum := range invalidParts {
-		_, err = store.UploadPart("test-bucket", "key.txt", uploadID, partNum, bytes.NewReader([]byte("data")), "")
+		_, err = store.UploadPart(context.Background(), "test-bucket", "key.txt", uploadID, partNum, bytes.NewReader([]byte("data")), "")
 		if err != ErrInvalidPartNumber {
 			t.Errorf("Part %d should return ErrInvalidPartNumber, got %v", partNum, err)
 		}
@@ -331,7 +624,7 @@ func TestMultipartUploadNonexistentBucket(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer store.Close()
-	_, err = store.InitiateMultipartUpload("nonexistent", "key.txt", Metadata{})
+	_, err = store.InitiateMultipartUpload("nonexistent", "key.txt", Metadata{}, "")
 	if err != ErrBucketNotFound {
 		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
 	}
@@ -356,19 +649,19 @@ func TestCompleteWithWrongBucketKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	uploadID, err := store.InitiateMultipartUpload("bucket1", "key1.txt", Metadata{})
+	uploadID, err := store.InitiateMultipartUpload("bucket1", "key1.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Try to complete with wrong bucket
-	_, err = store.CompleteMultipartUpload("bucket2", "key1.txt", uploadID, []Multipart{}, "")
+	_, err = store.CompleteMultipartUpload(context.Background(), "bucket2", "key1.txt", uploadID, []Multipart{}, "")
 	if err != ErrInvalidUploadID {
 		t.Fatalf("Expected ErrInvalidUploadID for wrong bucket, got %v", err)
 	}
 
 	// Try to complete with wrong key
-	_, err = store.CompleteMultipartUpload("bucket1", "key2.txt", uploadID, []Multipart{}, "")
+	_, err = store.CompleteMultipartUpload(context.Background(), "bucket1", "key2.txt", uploadID, []Multipart{}, "")
 	if err != ErrInvalidUploadID {
 		t.Fatalf("Expected ErrInvalidUploadID for wrong key, got %v", err)
 	}
@@ -391,7 +684,7 @@ func TestMultipartUploadPersistence(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	uploadID, err := store.InitiateMultipartUpload("test-bucket", "key.txt", Metadata{})
+	uploadID, err := store.InitiateMultipartUpload("test-bucket", "key.txt", Metadata{}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -406,14 +699,165 @@ func TestMultipartUploadPersistence(t *testing.T) {
 	}
 	defer store2.Close()
 	// Upload part with the new store - should work if persistence works
-	objInfo, err := store2.UploadPart("test-bucket", "key.txt", uploadID, 1, bytes.NewReader([]byte("test data")), "")
+	objInfo, err := store2.UploadPart(context.Background(), "test-bucket", "key.txt", uploadID, 1, bytes.NewReader([]byte("test data")), "")
 	if err != nil {
 		t.Fatalf("Upload should work after restart: %v", err)
 	}
 
 	// Complete upload should also work
-	_, err = store2.CompleteMultipartUpload("test-bucket", "key.txt", uploadID, []Multipart{{PartNumber: 1, ETag: objInfo.ETag}}, "")
+	_, err = store2.CompleteMultipartUpload(context.Background(), "test-bucket", "key.txt", uploadID, []Multipart{{PartNumber: 1, ETag: objInfo.ETag}}, "")
 	if err != nil {
 		t.Fatalf("Complete should work after restart: %v", err)
 	}
 }
+
+func TestCompleteMultipartUploadCancelled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-cancel"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "object", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+	part, err := store.UploadPart(context.Background(), bucketName, "object", uploadID, 1, bytes.NewReader(make([]byte, 10000)), "")
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.UploadPartCopy(ctx, bucketName, "object", uploadID, 2, bucketName, "missing", -1, -1); err == nil {
+		t.Error("Expected UploadPartCopy to fail")
+	}
+	parts := []Multipart{{PartNumber: 1, ETag: part.ETag}}
+	if _, err := store.CompleteMultipartUpload(ctx, bucketName, "object", uploadID, parts, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	// The upload is left in place and can still be completed
+	if _, err := store.CompleteMultipartUpload(context.Background(), bucketName, "object", uploadID, parts, ""); err != nil {
+		t.Errorf("Expected the upload to complete after a cancelled attempt, got %v", err)
+	}
+}
+
+func TestCompleteMultipartUploadInvalidPart(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-invalid-part"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "object", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("InitiateMultipartUpload failed: %v", err)
+	}
+
+	secret := filepath.Join(tmpDir, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// An ETag escaping the upload directory must not turn another file into the object
+	escape := "/../../../../../secret"
+	for _, etag := range []string{"missing", escape, ""} {
+		parts := []Multipart{{PartNumber: 1, ETag: etag}}
+		if _, err := store.CompleteMultipartUpload(context.Background(), bucketName, "object", uploadID, parts, ""); err != ErrInvalidPart {
+			t.Errorf("Expected ErrInvalidPart for ETag %q, got %v", etag, err)
+		}
+	}
+}
+
+func TestUploadPartCopyKeepsETag(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Compressed and packed data cannot be cloned, so whole objects are copied
+	store, err := NewStorage(tmpDir, WithCompression(), WithPacking(64<<10))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	sources := map[string]struct {
+		data     string
+		metadata Metadata
+	}{
+		"inline":     {"inline data", Metadata{}},
+		"compressed": {strings.Repeat("compressible ", inlineThreshold), Metadata{ContentType: "text/plain"}},
+		"packed":     {strings.Repeat("p", 2*inlineThreshold), Metadata{}},
+	}
+	for key, src := range sources {
+		info, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(src.data), src.metadata, "")
+		if err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+
+		uploadID, err := store.InitiateMultipartUpload(bucketName, "dst", Metadata{}, "")
+		if err != nil {
+			t.Fatalf("InitiateMultipartUpload failed: %v", err)
+		}
+		part, err := store.UploadPartCopy(context.Background(), bucketName, "dst", uploadID, 1, bucketName, key, -1, -1)
+		if err != nil {
+			t.Fatalf("UploadPartCopy of %s failed: %v", key, err)
+		}
+		if part.ETag != info.ETag || part.Size != int64(len(src.data)) {
+			t.Errorf("Expected the part copied from %s to have ETag %s and size %d, got %s and %d", key, info.ETag, len(src.data), part.ETag, part.Size)
+		}
+		ranged, err := store.UploadPartCopy(context.Background(), bucketName, "dst", uploadID, 2, bucketName, key, 1, 4)
+		if err != nil {
+			t.Fatalf("UploadPartCopy of a range of %s failed: %v", key, err)
+		}
+		rangeInfo, err := store.PutObject(context.Background(), bucketName, "range", strings.NewReader(src.data[1:5]), Metadata{}, "")
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if ranged.ETag != rangeInfo.ETag {
+			t.Errorf("Expected the range of %s to be hashed to %s, got %s", key, rangeInfo.ETag, ranged.ETag)
+		}
+
+		if _, err := store.CompleteMultipartUpload(context.Background(), bucketName, "dst", uploadID, []Multipart{
+			{PartNumber: 1, ETag: part.ETag},
+			{PartNumber: 2, ETag: ranged.ETag},
+		}, ""); err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+		reader, _, err := store.GetObject(bucketName, "dst")
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(got) != src.data+src.data[1:5] {
+			t.Errorf("Unexpected content of the upload copied from %s, got %d bytes (%v)", key, len(got), err)
+		}
+	}
+}
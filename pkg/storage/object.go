@@ -2,9 +2,11 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -39,7 +41,37 @@ func urlSafeToStdBase64(urlSafe string) string {
 
 // PutObject stores an object
 // If expectedChecksumSHA256 is provided (non-empty), it validates the checksum after computing.
-func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Metadata, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+// Reading data stops once ctx is done, leaving no partial object behind.
+func (s *Storage) PutObject(ctx context.Context, bucket, key string, data io.Reader, userMetadata Metadata, expectedChecksumSHA256 string) (*ObjectInfo, error) {
+	return s.PutObjectIf(ctx, bucket, key, data, userMetadata, expectedChecksumSHA256, Condition{})
+}
+
+// PutObjectIf stores an object like PutObject if cond holds for the object it replaces,
+// failing with ErrPreconditionFailed, or ErrObjectNotFound for IfMatch on a missing object, otherwise
+func (s *Storage) PutObjectIf(ctx context.Context, bucket, key string, data io.Reader, userMetadata Metadata, expectedChecksumSHA256 string, cond Condition) (*ObjectInfo, error) {
+	return s.putObjectLimited(ctx, bucket, key, data, userMetadata, expectedChecksumSHA256, cond, min(s.maxPartSize, s.maxObjectSize))
+}
+
+// ImportObject stores an object like PutObject, for objects migrated from other servers, such as
+// objects uploaded there in parts, whose size is only limited by the maximum object size
+func (s *Storage) ImportObject(ctx context.Context, bucket, key string, data io.Reader, userMetadata Metadata) (*ObjectInfo, error) {
+	return s.putObjectLimited(ctx, bucket, key, data, userMetadata, "", Condition{}, s.maxObjectSize)
+}
+
+// putObjectLimited stores an object of at most limit bytes
+func (s *Storage) putObjectLimited(ctx context.Context, bucket, key string, data io.Reader, userMetadata Metadata, expectedChecksumSHA256 string, cond Condition, limit int64) (*ObjectInfo, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	info, err := s.putObject(bucket, key, contextReader(ctx, data), userMetadata, expectedChecksumSHA256, cond, limit)
+	if err != nil {
+		return info, s.diskError(err)
+	}
+	s.publishWrite(EventObjectCreated, bucket, info, "", "")
+	return info, nil
+}
+
+func (s *Storage) putObject(bucket, key string, data io.Reader, userMetadata Metadata, expectedChecksumSHA256 string, cond Condition, limit int64) (*ObjectInfo, error) {
 	if !s.BucketExists(bucket) {
 		return nil, ErrBucketNotFound
 	}
@@ -49,25 +81,10 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 		return nil, err
 	}
 
-	// Create object directory
-	if err := os.MkdirAll(objectDir, 0755); err != nil {
-		return nil, err
-	}
-
 	metaPath := filepath.Join(objectDir, metaFile)
 
-	// Check if object already exists and load existing metadata
-	var existingMetadata *objectMetadata
-	if _, err := os.Stat(metaPath); err == nil {
-		existingMetadata, err = loadObjectMetadata(metaPath)
-		if err != nil {
-			// If metadata is corrupted, treat as if object doesn't exist and overwrite
-			existingMetadata = nil
-		}
-	}
-
-	// Create temp file in the object directory
-	tmpFile, err := s.tempFile()
+	// Create temp file for the content
+	tmpFile, err := s.contentTempFile()
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +94,7 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 	hash := sha256.New()
 	writer := io.MultiWriter(tmpFile, hash)
 
-	if _, err := io.Copy(writer, data); err != nil {
+	if _, err := copyLimited(writer, data, limit); err != nil {
 		tmpFile.Close()
 		return nil, err
 	}
@@ -97,6 +114,33 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 		return nil, ErrChecksumMismatch
 	}
 
+	unlock := s.commits.lock(metaPath)
+	defer unlock()
+
+	// Checked under the commit lock, so that a freeze or an earlier write under WORM retention
+	// committed while the content was received is not missed
+	if err := s.checkWritable(bucket, key); err != nil {
+		return nil, err
+	}
+
+	// The directory is created under the commit lock, a delete of the object may be removing it
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Check if object already exists and load existing metadata
+	var existingMetadata *objectMetadata
+	if _, err := os.Stat(metaPath); err == nil {
+		existingMetadata, err = s.loadObjectMetadata(metaPath)
+		if err != nil {
+			// If metadata is corrupted, treat as if object doesn't exist and overwrite
+			existingMetadata = nil
+		}
+	}
+	if err := cond.check(existingMetadata); err != nil {
+		return nil, err
+	}
+
 	// Check compatibility: if object exists with same ETag, it's a duplicate write
 	// This is compatible and we can proceed without issue (S3 behavior)
 	if existingMetadata != nil && existingMetadata.ETag == etag {
@@ -113,7 +157,7 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 		// If metadata is different, update it
 		if existingMetadata != nil && !metadataEqual(existingMetadata.Metadata, userMetadata) {
 			existingMetadata.Metadata = userMetadata
-			if err := saveObjectMetadata(metaPath, existingMetadata); err != nil {
+			if err := s.saveObjectMetadata(metaPath, existingMetadata); err != nil {
 				return nil, err
 			}
 		}
@@ -144,7 +188,7 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 		metadata.Data = fileData
 
 		// Save metadata with inline data
-		if err := saveObjectMetadata(metaPath, metadata); err != nil {
+		if err := s.saveObjectMetadata(metaPath, metadata); err != nil {
 			return nil, err
 		}
 
@@ -155,15 +199,26 @@ func (s *Storage) PutObject(bucket, key string, data io.Reader, userMetadata Met
 	} else {
 		// Use content-addressable storage for larger files
 		digest := hex.EncodeToString(hash.Sum(nil))
+		dataPath := tmpFile.Name()
+		compressedPath, compressedDigest, err := s.compressContent(dataPath, fileInfo.Size(), userMetadata)
+		if err != nil {
+			return nil, err
+		}
+		if compressedPath != "" {
+			defer os.Remove(compressedPath)
+			dataPath, digest = compressedPath, compressedDigest
+			metadata.Compression = compressionZstd
+			metadata.Size = fileInfo.Size()
+		}
 		metadata.Digest = digest
 
 		// Store the file in .objects directory
-		if err := s.storeContentAddressedObject(tmpFile.Name(), digest); err != nil {
+		if err := s.storeContentAddressedObject(dataPath, digest); err != nil {
 			return nil, err
 		}
 
 		// Store metadata with digest reference
-		if err := saveObjectMetadata(metaPath, metadata); err != nil {
+		if err := s.saveObjectMetadata(metaPath, metadata); err != nil {
 			return nil, err
 		}
 
@@ -202,8 +257,8 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 
 	metaPath := filepath.Join(objectDir, metaFile)
 
-	// Load metadata first
-	metadata, err := loadObjectMetadata(metaPath)
+	// Load metadata first, the meta file's ModTime is the object's ModTime
+	metadata, metaFileInfo, err := s.metadata.load(metaPath)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -216,12 +271,6 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 		// Data is embedded in metadata
 		reader := &inlineDataReader{bytes.NewReader(metadata.Data)}
 
-		// For inline data, we need to get the last modified time from the meta file
-		metaFileInfo, err := os.Stat(metaPath)
-		if err != nil {
-			return nil, nil, err
-		}
-
 		info := &ObjectInfo{
 			Key:            key,
 			Size:           int64(len(metadata.Data)),
@@ -236,8 +285,8 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 
 	// Check if data is in content-addressable storage
 	if metadata.Digest != "" {
-		// Data is in .objects directory
-		file, err := s.getContentAddressedObject(metadata.Digest)
+		// Data is in .objects directory or a pack segment, the handle is shared with concurrent readers
+		file, err := s.openObjectContent(metadata)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return nil, nil, ErrObjectNotFound
@@ -245,23 +294,9 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 			return nil, nil, err
 		}
 
-		// Get file info for size
-		fileInfo, err := file.Stat()
-		if err != nil {
-			file.Close()
-			return nil, nil, err
-		}
-
-		// Always use meta file's ModTime
-		metaFileInfo, err := os.Stat(metaPath)
-		if err != nil {
-			file.Close()
-			return nil, nil, err
-		}
-
 		info := &ObjectInfo{
 			Key:            key,
-			Size:           fileInfo.Size(),
+			Size:           file.Size(),
 			ETag:           metadata.ETag,
 			ChecksumSHA256: urlSafeToStdBase64(metadata.ETag),
 			ModTime:        metaFileInfo.ModTime(),
@@ -272,11 +307,6 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 	}
 
 	// Zero-byte object (including folder objects)
-	metaFileInfo, err := os.Stat(metaPath)
-	if err != nil {
-		return nil, nil, err
-	}
-
 	info := &ObjectInfo{
 		Key:            key,
 		Size:           0,
@@ -290,6 +320,14 @@ func (s *Storage) GetObject(bucket, key string) (io.ReadSeekCloser, *ObjectInfo,
 
 // DeleteObject deletes an object
 func (s *Storage) DeleteObject(bucket, key string) error {
+	if err := s.deleteObject(bucket, key); err != nil {
+		return err
+	}
+	s.publish(Event{Type: EventObjectDeleted, Bucket: bucket, Key: key})
+	return nil
+}
+
+func (s *Storage) deleteObject(bucket, key string) error {
 	if !s.BucketExists(bucket) {
 		return ErrBucketNotFound
 	}
@@ -299,20 +337,23 @@ func (s *Storage) DeleteObject(bucket, key string) error {
 		return err
 	}
 
-	// Check if object exists by checking for meta file (which always exists)
+	// Deletes commit under the lock of writes, so that the reference of an object being replaced
+	// is not released twice nor its meta file moved to the trash while it is replaced
 	metaPath := filepath.Join(objectDir, metaFile)
+	unlock := s.commits.lock(metaPath)
+	defer unlock()
+	return s.deleteLockedObject(bucket, key, objectDir, metaPath)
+}
+
+// deleteLockedObject deletes the object whose meta file is metaPath, the caller holding its commit lock
+func (s *Storage) deleteLockedObject(bucket, key, objectDir, metaPath string) error {
+	// Check if object exists by checking for meta file (which always exists)
 	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
 		return ErrObjectNotFound
 	}
 
-	// Load metadata to check if we need to decrement refcount
-	metadata, err := loadObjectMetadata(metaPath)
-	if err == nil && metadata != nil && metadata.Digest != "" {
-		// Decrement reference count for content-addressed object
-		if err := s.decrementRefCount(metadata.Digest); err != nil {
-			// Log error but don't fail the delete operation
-			// The object metadata will be deleted anyway
-		}
+	if err := s.checkWritable(bucket, key); err != nil {
+		return err
 	}
 
 	// Get bucket path before deleting the object
@@ -321,19 +362,85 @@ func (s *Storage) DeleteObject(bucket, key string) error {
 		return err
 	}
 
-	// Remove entire object directory
-	if err := os.RemoveAll(objectDir); err != nil {
+	retention, err := s.GetBucketTrashRetention(bucket)
+	if err != nil {
 		return err
 	}
+	if retention > 0 {
+		// The meta file moves to the trash along with the reference to the data
+		if err := s.moveToTrash(bucket, key, metaPath, retention); err != nil {
+			return err
+		}
+	} else {
+		// Load metadata to check if we need to decrement refcount
+		metadata, err := s.loadObjectMetadata(metaPath)
+		if err == nil && metadata != nil && metadata.Digest != "" {
+			// Decrement reference count for content-addressed object
+			if err := s.decrementRefCount(metadata.Digest); err != nil {
+				// Log error but don't fail the delete operation
+				// The object metadata will be deleted anyway
+			}
+		}
 
-	// Clean up empty parent directories
-	parentDir := filepath.Dir(objectDir)
-	s.cleanupEmptyDirs(parentDir, bucketPath)
+		// Remove the meta file only, the keys below the object live in subdirectories of its directory
+		if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Clean up the object directory and its parents once they are empty
+	s.cleanupEmptyDirs(objectDir, bucketPath)
 
 	return nil
 }
 
+// DeleteObjects deletes several objects of a bucket
+// The returned slice holds the error of each key, nil for deleted keys and ErrObjectNotFound for
+// keys that did not exist, so that one protected or invalid key does not fail the others
+func (s *Storage) DeleteObjects(bucket string, keys []string) ([]error, error) {
+	if !s.BucketExists(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = s.DeleteObject(bucket, key)
+	}
+	return errs, nil
+}
+
+// deletePrefixBatch is the number of keys DeletePrefix lists at a time
+const deletePrefixBatch = 1000
+
+// DeletePrefix deletes every object of a bucket whose key starts with prefix, listing and deleting
+// them in batches so that memory does not grow with the number of keys
+// fn is called after each delete with the key and its error, nil if it was deleted; objects that
+// cannot be deleted, such as WORM protected ones, are skipped. Once ctx is done it stops with its error
+func (s *Storage) DeletePrefix(ctx context.Context, bucket, prefix string, fn func(key string, err error)) error {
+	marker := ""
+	for {
+		objects, _, err := s.ListObjects(bucket, prefix, "", marker, deletePrefixBatch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(obj.Key, s.DeleteObject(bucket, obj.Key))
+		}
+		if len(objects) < deletePrefixBatch {
+			return nil
+		}
+		marker = objects[len(objects)-1].Key
+	}
+}
+
 // ListObjects lists objects in a bucket with optional prefix, delimiter, and marker for pagination
+// Objects are walked in key order and the walk stops after maxKeys objects and common prefixes,
+// counted together as in S3, so listing the first pages of a huge bucket neither reads nor holds all
+// of its objects; maxKeys <= 0 lists them all
+// A marker that is a common prefix continues after every key rolled up into it, as S3 does
 func (s *Storage) ListObjects(bucket, prefix, delimiter, marker string, maxKeys int) ([]ObjectInfo, []string, error) {
 	if !s.BucketExists(bucket) {
 		return nil, nil, ErrBucketNotFound
@@ -345,121 +452,175 @@ func (s *Storage) ListObjects(bucket, prefix, delimiter, marker string, maxKeys
 	}
 
 	var objects []ObjectInfo
-	commonPrefixes := make(map[string]bool)
+	var prefixes []string
+	lastPrefix := ""
+	if delimiter != "" && strings.HasPrefix(marker, prefix) {
+		// The marker is the next marker of a page that ended with this common prefix
+		relativeMarker := marker[len(prefix):]
+		if strings.HasSuffix(relativeMarker, delimiter) && strings.Index(relativeMarker, delimiter) == len(relativeMarker)-len(delimiter) {
+			lastPrefix = marker
+		}
+	}
 
-	err = filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip errors
+	want := func(key string, subtree bool) bool {
+		// Keys of a common prefix already listed are rolled up into it
+		if lastPrefix != "" && strings.HasPrefix(key, lastPrefix) {
+			return false
+		}
+		if !subtree {
+			return strings.HasPrefix(key, prefix) && key > marker
+		}
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			return false
 		}
+		// Every key starting with the subtree prefix sorts before a greater marker it is not a prefix of
+		return key >= marker || strings.HasPrefix(marker, key)
+	}
 
-		// Skip the bucket directory itself
-		if path == bucketPath {
+	err = s.walkObjects(bucketPath, "", want, func(objectKey string, metadata *objectMetadata, info os.FileInfo) error {
+		// Apply prefix and marker filters, the walk only narrows them down to subtrees
+		if !strings.HasPrefix(objectKey, prefix) || objectKey <= marker {
 			return nil
 		}
 
-		// Check if this is a meta file (all objects have meta files)
-		if filepath.Base(path) == metaFile && !info.IsDir() {
-			objectDir := filepath.Dir(path)
-			objectKey, err := filepath.Rel(bucketPath, objectDir)
-			if err != nil {
-				return nil
-			}
-			objectKey = filepath.ToSlash(objectKey)
-
-			// Load metadata first to determine if this is a directory object
-			metadata, _ := loadObjectMetadata(path)
-			if metadata == nil {
-				return nil
-			}
-
-			// Reconstruct the original key with trailing slash for directory objects
-			if metadata.IsDir {
-				objectKey = objectKey + "/"
-			}
-
-			// Apply prefix filter
-			if prefix != "" && !strings.HasPrefix(objectKey, prefix) {
+		// Handle delimiter
+		if delimiter != "" {
+			relativeKey := strings.TrimPrefix(objectKey, prefix)
+			if idx := strings.Index(relativeKey, delimiter); idx != -1 {
+				// This is a common prefix
+				commonPrefix := prefix + relativeKey[:idx+len(delimiter)]
+				if commonPrefix != lastPrefix {
+					lastPrefix = commonPrefix
+					prefixes = append(prefixes, commonPrefix)
+					if maxKeys > 0 && len(objects)+len(prefixes) >= maxKeys {
+						return errStopWalk
+					}
+				}
 				return nil
 			}
+		}
 
-			// Apply marker filter - only include objects after the marker
-			if marker != "" && objectKey <= marker {
-				return nil
-			}
+		size, err := s.objectSize(objectKey, metadata)
+		if err != nil {
+			return err
+		}
 
-			// Handle delimiter
-			if delimiter != "" {
-				relativeKey := strings.TrimPrefix(objectKey, prefix)
-				if idx := strings.Index(relativeKey, delimiter); idx != -1 {
-					// This is a common prefix
-					commonPrefix := prefix + relativeKey[:idx+len(delimiter)]
-					commonPrefixes[commonPrefix] = true
-					return nil
-				}
-			}
+		// Always use meta file's ModTime
+		objects = append(objects, ObjectInfo{
+			Key:            objectKey,
+			Size:           size,
+			ETag:           metadata.ETag,
+			ChecksumSHA256: urlSafeToStdBase64(metadata.ETag),
+			ModTime:        info.ModTime(),
+			Metadata:       metadata.Metadata,
+		})
+		if maxKeys > 0 && len(objects)+len(prefixes) >= maxKeys {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		return nil, nil, err
+	}
 
-			var size int64
-
-			// Check if data is inline or in content-addressable storage
-			if len(metadata.Data) > 0 {
-				// Data is inline
-				size = int64(len(metadata.Data))
-			} else if metadata.Digest != "" {
-				// Data is in content-addressable storage
-				objPath, err := s.objectPath(metadata.Digest)
-				if err != nil {
-					return fmt.Errorf("invalid digest for object %s: %v", objectKey, err)
-				}
-				dataInfo, err := os.Stat(objPath)
-				if err != nil {
-					return fmt.Errorf("failed to stat content-addressed object for %s: %v", objectKey, err)
-				}
-				size = dataInfo.Size()
-			}
-			// else: size is 0 (empty/zero-byte object, including folder objects)
+	return objects, prefixes, nil
+}
 
-			// Always use meta file's ModTime
-			objects = append(objects, ObjectInfo{
-				Key:            objectKey,
-				Size:           size,
-				ETag:           metadata.ETag,
-				ChecksumSHA256: urlSafeToStdBase64(metadata.ETag),
-				ModTime:        info.ModTime(),
-				Metadata:       metadata.Metadata,
-			})
+// objectSize returns the size of the data of the object with the given key and metadata
+func (s *Storage) objectSize(key string, metadata *objectMetadata) (int64, error) {
+	// Check if data is inline or in content-addressable storage
+	if len(metadata.Data) > 0 {
+		// Data is inline
+		return int64(len(metadata.Data)), nil
+	}
+	if metadata.Digest != "" {
+		// Data is in content-addressable storage
+		size, err := s.digestSize(metadata)
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat content-addressed object for %s: %v", key, err)
 		}
+		return size, nil
+	}
+	// Size is 0 for empty objects, including folder objects
+	return 0, nil
+}
 
-		return nil
-	})
+// errStopWalk ends walkObjects early without an error
+var errStopWalk = errors.New("stop walk")
 
+// walkObjects calls fn for every object under dir in key order, rel being the key prefix of dir
+// want is asked before loading an object, and before walking the keys starting with a subtree prefix
+func (s *Storage) walkObjects(dir, rel string, want func(key string, subtree bool) bool, fn func(key string, metadata *objectMetadata, info os.FileInfo) error) error {
+	entries, err := readObjectDir(dir)
 	if err != nil {
-		return nil, nil, err
+		return nil // Skip errors
 	}
 
-	// Sort objects by key
-	sort.Slice(objects, func(i, j int) bool {
-		return objects[i].Key < objects[j].Key
+	// An object directory holds the object itself and the subtree of keys below it,
+	// which sort apart: "a" < "a-b" < "a/" < "a/b"
+	type item struct {
+		sortKey string
+		path    string
+		subtree bool
+	}
+	items := make([]item, 0, 2*len(entries))
+	for _, path := range entries {
+		// Entries of shard directories are named by the last element of their path
+		name := filepath.Base(path)
+		items = append(items, item{name, path, false}, item{name + "/", path, true})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].sortKey < items[j].sortKey
 	})
 
-	// Apply maxKeys limit
-	if maxKeys > 0 && len(objects) > maxKeys {
-		objects = objects[:maxKeys]
+	for _, it := range items {
+		key := rel + it.sortKey
+		if !want(key, it.subtree) {
+			continue
+		}
+		path := filepath.Join(dir, it.path)
+		// Directory objects have the key of their subtree prefix, listed before the subtree
+		if err := s.visitObject(key, filepath.Join(path, metaFile), it.subtree, fn); err != nil {
+			return err
+		}
+		if it.subtree {
+			if err := s.walkObjects(path, key, want, fn); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	// Convert common prefixes to sorted slice
-	var prefixes []string
-	for prefix := range commonPrefixes {
-		prefixes = append(prefixes, prefix)
+// visitObject calls fn for the object whose meta file is at metaPath, if it exists and is a directory object or not as given
+func (s *Storage) visitObject(key, metaPath string, isDir bool, fn func(key string, metadata *objectMetadata, info os.FileInfo) error) error {
+	info, err := os.Stat(metaPath)
+	if err != nil || info.IsDir() {
+		return nil
 	}
-	sort.Strings(prefixes)
-
-	return objects, prefixes, nil
+	metadata, _ := s.loadObjectMetadata(metaPath)
+	if metadata == nil || metadata.IsDir != isDir {
+		return nil
+	}
+	return fn(key, metadata, info)
 }
 
 // CopyObject copies an object from one location to another
 // If replaceMetadata is provided (non-nil), it replaces the source object's metadata.
 // If replaceMetadata is nil, the source object's metadata is copied.
 func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replaceMetadata *Metadata) (*ObjectInfo, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	info, err := s.copyObject(srcBucket, srcKey, dstBucket, dstKey, replaceMetadata)
+	if err != nil {
+		return info, s.diskError(err)
+	}
+	s.publishWrite(EventObjectCopied, dstBucket, info, srcBucket, srcKey)
+	return info, nil
+}
+
+func (s *Storage) copyObject(srcBucket, srcKey, dstBucket, dstKey string, replaceMetadata *Metadata) (*ObjectInfo, error) {
 	// Verify source bucket exists
 	if !s.BucketExists(srcBucket) {
 		return nil, ErrBucketNotFound
@@ -479,7 +640,7 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 	srcMetaPath := filepath.Join(srcObjectDir, metaFile)
 
 	// Load source metadata
-	srcMetadata, err := loadObjectMetadata(srcMetaPath)
+	srcMetadata, err := s.loadObjectMetadata(srcMetaPath)
 	if err != nil {
 		return nil, err
 	}
@@ -501,17 +662,24 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 		return nil, err
 	}
 
-	// Create destination object directory
-	if err := os.MkdirAll(dstObjectDir, 0755); err != nil {
+	dstMetaPath := filepath.Join(dstObjectDir, metaFile)
+
+	unlock := s.commits.lock(dstMetaPath)
+	defer unlock()
+
+	if err := s.checkWritable(dstBucket, dstKey); err != nil {
 		return nil, err
 	}
 
-	dstMetaPath := filepath.Join(dstObjectDir, metaFile)
+	// Create destination object directory, under the commit lock like putObject
+	if err := os.MkdirAll(dstObjectDir, 0755); err != nil {
+		return nil, err
+	}
 
 	// Check if destination object already exists
 	var existingDstMetadata *objectMetadata
 	if _, err := os.Stat(dstMetaPath); err == nil {
-		existingDstMetadata, err = loadObjectMetadata(dstMetaPath)
+		existingDstMetadata, err = s.loadObjectMetadata(dstMetaPath)
 		if err != nil {
 			// If metadata is corrupted, treat as if object doesn't exist and overwrite
 			existingDstMetadata = nil
@@ -528,16 +696,10 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 			size = int64(len(existingDstMetadata.Data))
 		} else if existingDstMetadata.Digest != "" {
 			// Get size from content-addressed object
-			objFile, err := s.getContentAddressedObject(existingDstMetadata.Digest)
-			if err != nil {
-				return nil, err
-			}
-			defer objFile.Close()
-			fileInfo, err := objFile.Stat()
+			size, err = s.digestSize(existingDstMetadata)
 			if err != nil {
 				return nil, err
 			}
-			size = fileInfo.Size()
 		}
 		// else: size is 0 (zero-byte object, including folder objects)
 
@@ -557,6 +719,12 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 		}, nil
 	}
 
+	// Copying an object onto itself with new metadata is how clients update metadata,
+	// the data stays where it is
+	if replaceMetadata != nil && existingDstMetadata != nil && srcObjectDir == dstObjectDir {
+		return s.replaceObjectMetadata(dstKey, dstMetaPath, existingDstMetadata, metadataToUse)
+	}
+
 	// Check if source data is inline
 	if len(srcMetadata.Data) > 0 {
 		// Data is inline - copy directly
@@ -568,7 +736,7 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 		}
 		copy(dstMetadata.Data, srcMetadata.Data)
 
-		if err := saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
+		if err := s.saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
 			return nil, err
 		}
 
@@ -601,13 +769,15 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 		}
 
 		dstMetadata := &objectMetadata{
-			ETag:     srcMetadata.ETag,
-			Digest:   srcMetadata.Digest,
-			Metadata: metadataToUse,
-			IsDir:    strings.HasSuffix(dstKey, "/"),
+			ETag:        srcMetadata.ETag,
+			Digest:      srcMetadata.Digest,
+			Metadata:    metadataToUse,
+			IsDir:       strings.HasSuffix(dstKey, "/"),
+			Compression: srcMetadata.Compression,
+			Size:        srcMetadata.Size,
 		}
 
-		if err := saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
+		if err := s.saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
 			// Rollback refcount increment
 			s.decrementRefCount(srcMetadata.Digest)
 			return nil, err
@@ -618,14 +788,8 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 			s.decrementRefCount(existingDstMetadata.Digest)
 		}
 
-		// Fallback to reading from content-addressed object
-		objFile, err := s.getContentAddressedObject(srcMetadata.Digest)
-		if err != nil {
-			return nil, err
-		}
-		defer objFile.Close()
-
-		fileInfo, err := objFile.Stat()
+		// Get size from content-addressed object
+		size, err := s.digestSize(srcMetadata)
 		if err != nil {
 			return nil, err
 		}
@@ -638,7 +802,7 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 
 		return &ObjectInfo{
 			Key:            dstKey,
-			Size:           fileInfo.Size(),
+			Size:           size,
 			ETag:           srcMetadata.ETag,
 			ChecksumSHA256: urlSafeToStdBase64(srcMetadata.ETag),
 			ModTime:        metaFileInfo.ModTime(),
@@ -653,7 +817,7 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 		IsDir:    strings.HasSuffix(dstKey, "/"),
 	}
 
-	if err := saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
+	if err := s.saveObjectMetadata(dstMetaPath, dstMetadata); err != nil {
 		return nil, err
 	}
 
@@ -678,8 +842,53 @@ func (s *Storage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, replac
 	}, nil
 }
 
+// replaceObjectMetadata rewrites the metadata of the object stored at metaPath, keeping its data,
+// so no content is copied and no reference count changes
+// The caller holds the commit lock of metaPath
+func (s *Storage) replaceObjectMetadata(key, metaPath string, existing *objectMetadata, metadata Metadata) (*ObjectInfo, error) {
+	updated := *existing
+	updated.Metadata = metadata
+	if err := s.saveObjectMetadata(metaPath, &updated); err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if len(updated.Data) > 0 {
+		size = int64(len(updated.Data))
+	} else if updated.Digest != "" {
+		var err error
+		size, err = s.digestSize(&updated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Always use meta file's ModTime
+	metaFileInfo, err := os.Stat(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:            key,
+		Size:           size,
+		ETag:           updated.ETag,
+		ChecksumSHA256: urlSafeToStdBase64(updated.ETag),
+		ModTime:        metaFileInfo.ModTime(),
+		Metadata:       metadata,
+	}, nil
+}
+
 // RenameObject renames an object within the same bucket
 func (s *Storage) RenameObject(bucket, srcKey, dstKey string) error {
+	if err := s.renameObject(bucket, srcKey, dstKey); err != nil {
+		return err
+	}
+	s.publish(Event{Type: EventObjectRenamed, Bucket: bucket, Key: dstKey, SourceBucket: bucket, SourceKey: srcKey})
+	return nil
+}
+
+func (s *Storage) renameObject(bucket, srcKey, dstKey string) error {
 	// Verify bucket exists
 	if !s.BucketExists(bucket) {
 		return ErrBucketNotFound
@@ -691,8 +900,20 @@ func (s *Storage) RenameObject(bucket, srcKey, dstKey string) error {
 		return err
 	}
 
-	// Check if source exists by checking for meta file
+	// Get destination object directory
+	dstObjectDir, err := s.safePath(bucket, dstKey)
+	if err != nil {
+		return err
+	}
+
+	// Renaming removes the source and may replace the destination, so it commits
+	// under the locks of both like a delete of one and a write of the other
 	srcMetaPath := filepath.Join(srcObjectDir, metaFile)
+	dstMetaPath := filepath.Join(dstObjectDir, metaFile)
+	unlock := s.commits.lockPair(srcMetaPath, dstMetaPath)
+	defer unlock()
+
+	// Check if source exists by checking for meta file
 	if _, err := os.Stat(srcMetaPath); err != nil {
 		if os.IsNotExist(err) {
 			return ErrObjectNotFound
@@ -700,19 +921,19 @@ func (s *Storage) RenameObject(bucket, srcKey, dstKey string) error {
 		return err
 	}
 
-	// Get destination object directory
-	dstObjectDir, err := s.safePath(bucket, dstKey)
-	if err != nil {
+	if err := s.checkWritable(bucket, srcKey); err != nil {
+		return err
+	}
+	if err := s.checkWritable(bucket, dstKey); err != nil {
 		return err
 	}
 
 	// Check if destination already exists (compatibility check)
-	dstMetaPath := filepath.Join(dstObjectDir, metaFile)
 	if _, err := os.Stat(dstMetaPath); err == nil {
 		// Destination exists - check if it's the same as source
 		// Load both metadata to compare
-		srcMetadata, srcErr := loadObjectMetadata(srcMetaPath)
-		dstMetadata, dstErr := loadObjectMetadata(dstMetaPath)
+		srcMetadata, srcErr := s.loadObjectMetadata(srcMetaPath)
+		dstMetadata, dstErr := s.loadObjectMetadata(dstMetaPath)
 
 		// If both metadata are readable and ETags match, content is the same
 		if srcErr == nil && dstErr == nil && srcMetadata != nil && dstMetadata != nil && srcMetadata.ETag == dstMetadata.ETag {
@@ -755,7 +976,7 @@ func (s *Storage) RenameObject(bucket, srcKey, dstKey string) error {
 	}
 
 	// Rename/move the object directory
-	if err := os.Rename(srcObjectDir, dstObjectDir); err != nil {
+	if err := renameDir(srcObjectDir, dstObjectDir); err != nil {
 		return err
 	}
 
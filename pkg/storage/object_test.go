@@ -2,9 +2,13 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -32,7 +36,7 @@ func TestObjectOperations(t *testing.T) {
 	}
 
 	// Put object
-	objInfo, err := store.PutObject(bucketName, objectKey, bytes.NewReader([]byte(objectContent)), Metadata{ContentType: "text/plain"}, "")
+	objInfo, err := store.PutObject(context.Background(), bucketName, objectKey, bytes.NewReader([]byte(objectContent)), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -122,7 +126,7 @@ func TestPathTraversalProtection(t *testing.T) {
 	}
 
 	for _, key := range testCases {
-		_, err := store.PutObject(bucketName, key, bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), bucketName, key, bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
 		if err == nil {
 			t.Fatalf("Expected error for path traversal attempt: %s", key)
 		}
@@ -163,7 +167,7 @@ func TestCopyObject(t *testing.T) {
 	}
 
 	// Create source object
-	_, err = store.PutObject(srcBucket, srcKey, bytes.NewReader([]byte(content)), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), srcBucket, srcKey, bytes.NewReader([]byte(content)), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -239,7 +243,7 @@ func TestObjectInvalidKeys(t *testing.T) {
 
 	invalidKeys := []string{".", "..", "../file.txt"}
 	for _, key := range invalidKeys {
-		_, err := store.PutObject("test-bucket", key, bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), "test-bucket", key, bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
 		if err != ErrInvalidObjectKey {
 			t.Errorf("PutObject(%q) should return ErrInvalidObjectKey, got %v", key, err)
 		}
@@ -301,7 +305,7 @@ func TestPutObjectNonexistentBucket(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer store.Close()
-	_, err = store.PutObject("nonexistent", "key.txt", bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), "nonexistent", "key.txt", bytes.NewReader([]byte("test")), Metadata{ContentType: "text/plain"}, "")
 	if err != ErrBucketNotFound {
 		t.Fatalf("Expected ErrBucketNotFound, got %v", err)
 	}
@@ -332,7 +336,7 @@ func TestRenameObject(t *testing.T) {
 	}
 
 	// Create source object
-	_, err = store.PutObject(bucketName, srcKey, bytes.NewReader([]byte(content)), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, srcKey, bytes.NewReader([]byte(content)), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -435,7 +439,7 @@ func TestInlineDataForSmallFiles(t *testing.T) {
 	smallKey := "small.txt"
 	smallContent := bytes.Repeat([]byte("x"), 100) // 100 bytes - well under threshold
 
-	objInfo1, err := store.PutObject(bucketName, smallKey, bytes.NewReader(smallContent), Metadata{ContentType: "text/plain"}, "")
+	objInfo1, err := store.PutObject(context.Background(), bucketName, smallKey, bytes.NewReader(smallContent), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for small file failed: %v", err)
 	}
@@ -481,7 +485,7 @@ func TestInlineDataForSmallFiles(t *testing.T) {
 	largeKey := "large.txt"
 	largeContent := bytes.Repeat([]byte("y"), 5000) // 5000 bytes - over threshold
 
-	objInfo2, err := store.PutObject(bucketName, largeKey, bytes.NewReader(largeContent), Metadata{ContentType: "text/plain"}, "")
+	objInfo2, err := store.PutObject(context.Background(), bucketName, largeKey, bytes.NewReader(largeContent), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for large file failed: %v", err)
 	}
@@ -618,7 +622,7 @@ func TestInlineDataThreshold(t *testing.T) {
 	atThresholdKey := "at-threshold.txt"
 	atThresholdContent := bytes.Repeat([]byte("z"), 4096)
 
-	_, err = store.PutObject(bucketName, atThresholdKey, bytes.NewReader(atThresholdContent), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, atThresholdKey, bytes.NewReader(atThresholdContent), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -634,7 +638,7 @@ func TestInlineDataThreshold(t *testing.T) {
 	aboveThresholdKey := "above-threshold.txt"
 	aboveThresholdContent := bytes.Repeat([]byte("w"), 4097)
 
-	_, err = store.PutObject(bucketName, aboveThresholdKey, bytes.NewReader(aboveThresholdContent), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, aboveThresholdKey, bytes.NewReader(aboveThresholdContent), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -672,13 +676,13 @@ func TestPutObjectDuplicateCompatibility(t *testing.T) {
 		content := bytes.Repeat([]byte("test"), 100)
 
 		// First put
-		objInfo1, err := store.PutObject(bucketName, objectKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		objInfo1, err := store.PutObject(context.Background(), bucketName, objectKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("First PutObject failed: %v", err)
 		}
 
 		// Second put with same content - should be compatible
-		objInfo2, err := store.PutObject(bucketName, objectKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		objInfo2, err := store.PutObject(context.Background(), bucketName, objectKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("Second PutObject with same content failed: %v", err)
 		}
@@ -711,13 +715,13 @@ func TestPutObjectDuplicateCompatibility(t *testing.T) {
 		content2 := []byte("second content different")
 
 		// First put
-		objInfo1, err := store.PutObject(bucketName, objectKey, bytes.NewReader(content1), Metadata{ContentType: "text/plain"}, "")
+		objInfo1, err := store.PutObject(context.Background(), bucketName, objectKey, bytes.NewReader(content1), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("First PutObject failed: %v", err)
 		}
 
 		// Second put with different content - should overwrite
-		objInfo2, err := store.PutObject(bucketName, objectKey, bytes.NewReader(content2), Metadata{ContentType: "text/plain"}, "")
+		objInfo2, err := store.PutObject(context.Background(), bucketName, objectKey, bytes.NewReader(content2), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("Second PutObject with different content failed: %v", err)
 		}
@@ -745,6 +749,86 @@ func TestPutObjectDuplicateCompatibility(t *testing.T) {
 }
 
 // TestCopyObjectDuplicateCompatibility tests copying to an existing destination
+func TestCopyObjectOntoItself(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	for name, content := range map[string][]byte{
+		"Inline":           []byte("small"),
+		"ContentAddressed": bytes.Repeat([]byte("large "), inlineThreshold),
+	} {
+		t.Run(name, func(t *testing.T) {
+			key := name + ".txt"
+			put, err := store.PutObject(context.Background(), "bucket", key, bytes.NewReader(content), Metadata{ContentType: "text/plain", XAmzMeta: map[string]string{"old": "1"}}, "")
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			objectDir, _ := store.safePath("bucket", key)
+			before, err := store.loadObjectMetadata(filepath.Join(objectDir, metaFile))
+			if err != nil {
+				t.Fatalf("Failed to load metadata: %v", err)
+			}
+			var dataInfo os.FileInfo
+			if before.Digest != "" {
+				dataPath, _ := store.objectPath(before.Digest)
+				if dataInfo, err = os.Stat(dataPath); err != nil {
+					t.Fatalf("Failed to stat the data: %v", err)
+				}
+			}
+
+			replaced := Metadata{ContentType: "application/json", XAmzMeta: map[string]string{"new": "2"}, Tags: map[string]string{"team": "a"}}
+			info, err := store.CopyObject("bucket", key, "bucket", key, &replaced)
+			if err != nil {
+				t.Fatalf("CopyObject failed: %v", err)
+			}
+			if info.ETag != put.ETag || info.Size != int64(len(content)) {
+				t.Errorf("Expected the ETag and size of the object, got %+v", info)
+			}
+
+			after, err := store.loadObjectMetadata(filepath.Join(objectDir, metaFile))
+			if err != nil {
+				t.Fatalf("Failed to load metadata: %v", err)
+			}
+			if after.Digest != before.Digest || !metadataEqual(after.Metadata, replaced) {
+				t.Errorf("Expected only the metadata to change, got %+v", after)
+			}
+			if before.Digest != "" {
+				dataPath, _ := store.objectPath(before.Digest)
+				if stat, err := os.Stat(dataPath); err != nil || !os.SameFile(stat, dataInfo) || !stat.ModTime().Equal(dataInfo.ModTime()) {
+					t.Errorf("Expected the data to be left untouched: %v", err)
+				}
+			}
+
+			reader, got, err := store.GetObject("bucket", key)
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			defer reader.Close()
+			data, _ := io.ReadAll(reader)
+			if !bytes.Equal(data, content) || got.Metadata.ContentType != "application/json" || got.Metadata.XAmzMeta["old"] != "" {
+				t.Errorf("Expected the content with the new metadata, got %+v", got.Metadata)
+			}
+
+			// The reference count is unchanged, so deleting the object releases the data
+			if err := store.DeleteObject("bucket", key); err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+			if before.Digest != "" {
+				dataPath, _ := store.objectPath(before.Digest)
+				if _, err := os.Stat(dataPath); !os.IsNotExist(err) {
+					t.Errorf("Expected the data to be released with the object, got %v", err)
+				}
+			}
+		})
+	}
+}
+
 func TestCopyObjectDuplicateCompatibility(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "storage-test-*")
 	if err != nil {
@@ -770,13 +854,13 @@ func TestCopyObjectDuplicateCompatibility(t *testing.T) {
 		content := []byte("shared content")
 
 		// Create source
-		_, err := store.PutObject(bucketName, srcKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), bucketName, srcKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject source failed: %v", err)
 		}
 
 		// Create destination with same content
-		_, err = store.PutObject(bucketName, dstKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		_, err = store.PutObject(context.Background(), bucketName, dstKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject destination failed: %v", err)
 		}
@@ -812,13 +896,13 @@ func TestCopyObjectDuplicateCompatibility(t *testing.T) {
 		dstContent := []byte("destination content different")
 
 		// Create source
-		_, err := store.PutObject(bucketName, srcKey, bytes.NewReader(srcContent), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), bucketName, srcKey, bytes.NewReader(srcContent), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject source failed: %v", err)
 		}
 
 		// Create destination with different content
-		_, err = store.PutObject(bucketName, dstKey, bytes.NewReader(dstContent), Metadata{ContentType: "text/plain"}, "")
+		_, err = store.PutObject(context.Background(), bucketName, dstKey, bytes.NewReader(dstContent), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject destination failed: %v", err)
 		}
@@ -873,13 +957,13 @@ func TestRenameObjectDuplicateCompatibility(t *testing.T) {
 		content := []byte("same content")
 
 		// Create source
-		_, err := store.PutObject(bucketName, srcKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), bucketName, srcKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject source failed: %v", err)
 		}
 
 		// Create destination with same content
-		_, err = store.PutObject(bucketName, dstKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+		_, err = store.PutObject(context.Background(), bucketName, dstKey, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject destination failed: %v", err)
 		}
@@ -917,13 +1001,13 @@ func TestRenameObjectDuplicateCompatibility(t *testing.T) {
 		dstContent := []byte("destination content different")
 
 		// Create source
-		_, err := store.PutObject(bucketName, srcKey, bytes.NewReader(srcContent), Metadata{ContentType: "text/plain"}, "")
+		_, err := store.PutObject(context.Background(), bucketName, srcKey, bytes.NewReader(srcContent), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject source failed: %v", err)
 		}
 
 		// Create destination with different content
-		_, err = store.PutObject(bucketName, dstKey, bytes.NewReader(dstContent), Metadata{ContentType: "text/plain"}, "")
+		_, err = store.PutObject(context.Background(), bucketName, dstKey, bytes.NewReader(dstContent), Metadata{ContentType: "text/plain"}, "")
 		if err != nil {
 			t.Fatalf("PutObject destination failed: %v", err)
 		}
@@ -977,14 +1061,14 @@ func TestContentAddressableStorageDeduplication(t *testing.T) {
 	key1 := "file1.txt"
 	content := bytes.Repeat([]byte("duplicate content"), 300) // ~5100 bytes - over threshold
 
-	objInfo1, err := store.PutObject(bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	objInfo1, err := store.PutObject(context.Background(), bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for file1 failed: %v", err)
 	}
 
 	// Create second object with SAME content
 	key2 := "file2.txt"
-	objInfo2, err := store.PutObject(bucketName, key2, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	objInfo2, err := store.PutObject(context.Background(), bucketName, key2, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for file2 failed: %v", err)
 	}
@@ -1122,7 +1206,7 @@ func TestContentAddressableStorageWithDifferentContent(t *testing.T) {
 	key1 := "file1.txt"
 	content1 := bytes.Repeat([]byte("content A"), 500) // ~4500 bytes - over threshold
 
-	objInfo1, err := store.PutObject(bucketName, key1, bytes.NewReader(content1), Metadata{ContentType: "text/plain"}, "")
+	objInfo1, err := store.PutObject(context.Background(), bucketName, key1, bytes.NewReader(content1), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for file1 failed: %v", err)
 	}
@@ -1131,7 +1215,7 @@ func TestContentAddressableStorageWithDifferentContent(t *testing.T) {
 	key2 := "file2.txt"
 	content2 := bytes.Repeat([]byte("content B"), 500) // ~4500 bytes - over threshold
 
-	objInfo2, err := store.PutObject(bucketName, key2, bytes.NewReader(content2), Metadata{ContentType: "text/plain"}, "")
+	objInfo2, err := store.PutObject(context.Background(), bucketName, key2, bytes.NewReader(content2), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject for file2 failed: %v", err)
 	}
@@ -1217,7 +1301,7 @@ func TestInlineDataNotInObjectsDirectory(t *testing.T) {
 	key := "small.txt"
 	content := []byte("small content")
 
-	_, err = store.PutObject(bucketName, key, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, key, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -1286,17 +1370,17 @@ func TestReferenceCountingCleanup(t *testing.T) {
 	key2 := "ref2.txt"
 	key3 := "ref3.txt"
 
-	_, err = store.PutObject(bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject key1 failed: %v", err)
 	}
 
-	_, err = store.PutObject(bucketName, key2, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, key2, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject key2 failed: %v", err)
 	}
 
-	_, err = store.PutObject(bucketName, key3, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, key3, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject key3 failed: %v", err)
 	}
@@ -1380,7 +1464,7 @@ func TestReferenceCountingWithCopy(t *testing.T) {
 
 	// Upload original
 	key1 := "original.txt"
-	_, err = store.PutObject(bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
+	_, err = store.PutObject(context.Background(), bucketName, key1, bytes.NewReader(content), Metadata{ContentType: "text/plain"}, "")
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -1467,7 +1551,7 @@ func TestFolderObjects(t *testing.T) {
 		folderKey := "myfolder/"
 
 		// Create folder object (zero-byte content)
-		objInfo, err := store.PutObject(bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
+		objInfo, err := store.PutObject(context.Background(), bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject folder failed: %v", err)
 		}
@@ -1504,7 +1588,7 @@ func TestFolderObjects(t *testing.T) {
 		folderKey := "getfolder/"
 
 		// Create folder object
-		_, err := store.PutObject(bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
+		_, err := store.PutObject(context.Background(), bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject folder failed: %v", err)
 		}
@@ -1537,7 +1621,7 @@ func TestFolderObjects(t *testing.T) {
 		folderKey := "deletefolder/"
 
 		// Create folder object
-		_, err := store.PutObject(bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
+		_, err := store.PutObject(context.Background(), bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject folder failed: %v", err)
 		}
@@ -1560,7 +1644,7 @@ func TestFolderObjects(t *testing.T) {
 		dstFolder := "dstfolder/"
 
 		// Create source folder object
-		_, err := store.PutObject(bucketName, srcFolder, bytes.NewReader([]byte{}), Metadata{}, "")
+		_, err := store.PutObject(context.Background(), bucketName, srcFolder, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject source folder failed: %v", err)
 		}
@@ -1594,13 +1678,13 @@ func TestFolderObjects(t *testing.T) {
 		nestedContent := "Hello from nested file"
 
 		// Create folder object
-		_, err := store.PutObject(bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
+		_, err := store.PutObject(context.Background(), bucketName, folderKey, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject folder failed: %v", err)
 		}
 
 		// Create nested object inside folder
-		_, err = store.PutObject(bucketName, nestedKey, bytes.NewReader([]byte(nestedContent)), Metadata{}, "")
+		_, err = store.PutObject(context.Background(), bucketName, nestedKey, bytes.NewReader([]byte(nestedContent)), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject nested file failed: %v", err)
 		}
@@ -1655,21 +1739,21 @@ func TestFolderObjects(t *testing.T) {
 	t.Run("ListWithDelimiterAndFolderObjects", func(t *testing.T) {
 		// Create a root-level folder object
 		rootFolder := "rootfolder/"
-		_, err := store.PutObject(bucketName, rootFolder, bytes.NewReader([]byte{}), Metadata{}, "")
+		_, err := store.PutObject(context.Background(), bucketName, rootFolder, bytes.NewReader([]byte{}), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject root folder failed: %v", err)
 		}
 
 		// Create a root-level file
 		rootFile := "rootfile.txt"
-		_, err = store.PutObject(bucketName, rootFile, bytes.NewReader([]byte("root content")), Metadata{}, "")
+		_, err = store.PutObject(context.Background(), bucketName, rootFile, bytes.NewReader([]byte("root content")), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject root file failed: %v", err)
 		}
 
 		// Create a file inside rootfolder
 		nestedFile := "rootfolder/nested.txt"
-		_, err = store.PutObject(bucketName, nestedFile, bytes.NewReader([]byte("nested content")), Metadata{}, "")
+		_, err = store.PutObject(context.Background(), bucketName, nestedFile, bytes.NewReader([]byte("nested content")), Metadata{}, "")
 		if err != nil {
 			t.Fatalf("PutObject nested file failed: %v", err)
 		}
@@ -1703,3 +1787,272 @@ func TestFolderObjects(t *testing.T) {
 		}
 	})
 }
+
+func TestPutObjectCancelled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-cancel"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// The client goes away after the first chunk of an endless body
+	ctx, cancel := context.WithCancel(context.Background())
+	body := io.MultiReader(bytes.NewReader(make([]byte, 1<<16)), readerFunc(func(p []byte) (int, error) {
+		cancel()
+		return len(p), nil
+	}))
+	if _, err := store.PutObject(ctx, bucketName, "object", body, Metadata{}, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, _, err := store.GetObject(bucketName, "object"); err != ErrObjectNotFound {
+		t.Errorf("Expected no object after a cancelled upload, got %v", err)
+	}
+}
+
+func TestPutObjectReadError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-read-error"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("original"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// A body failing at its end, like a payload not matching its signed hash, must not replace the object
+	errMismatch := errors.New("payload mismatch")
+	body := io.MultiReader(bytes.NewReader(make([]byte, 1<<16)), readerFunc(func(p []byte) (int, error) {
+		return 0, errMismatch
+	}))
+	if _, err := store.PutObject(context.Background(), bucketName, "object", body, Metadata{}, ""); !errors.Is(err, errMismatch) {
+		t.Fatalf("Expected the read error, got %v", err)
+	}
+	reader, _, err := store.GetObject(bucketName, "object")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "original" {
+		t.Errorf("Expected the original object to be kept, got %d bytes", len(data))
+	}
+	if entries, err := os.ReadDir(store.objectsTempDir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the temporary file to be removed, got %v (%v)", entries, err)
+	}
+}
+
+// readerFunc adapts a function to io.Reader
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestListObjectsOrder(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-order"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// Keys whose directories sort differently than the keys themselves
+	keys := []string{"a/b", "a", "a-b", "c/", "a.b/c", "b", "c/d"}
+	for _, key := range keys {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader("data"), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+
+	listKeys := func(prefix, delimiter, marker string, maxKeys int) ([]string, []string) {
+		objects, prefixes, err := store.ListObjects(bucketName, prefix, delimiter, marker, maxKeys)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		var listed []string
+		for _, obj := range objects {
+			listed = append(listed, obj.Key)
+		}
+		return listed, prefixes
+	}
+
+	tests := []struct {
+		prefix, delimiter, marker string
+		maxKeys                   int
+		wantKeys, wantPrefixes    []string
+	}{
+		{"", "", "", 0, []string{"a", "a-b", "a.b/c", "a/b", "b", "c/", "c/d"}, nil},
+		{"", "", "", 3, []string{"a", "a-b", "a.b/c"}, nil},
+		{"", "", "a-b", 2, []string{"a.b/c", "a/b"}, nil},
+		{"", "", "a/", 0, []string{"a/b", "b", "c/", "c/d"}, nil},
+		{"", "", "c/", 0, []string{"c/d"}, nil},
+		{"c/", "", "", 0, []string{"c/", "c/d"}, nil},
+		{"", "/", "", 0, []string{"a", "a-b", "b"}, []string{"a.b/", "a/", "c/"}},
+		{"", "/", "a/b", 0, []string{"b"}, []string{"c/"}},
+		// Common prefixes count against maxKeys, and a common prefix marker skips its keys
+		{"", "/", "", 3, []string{"a", "a-b"}, []string{"a.b/"}},
+		{"", "/", "a.b/", 2, []string{"b"}, []string{"a/"}},
+		{"", "/", "a/", 0, []string{"b"}, []string{"c/"}},
+		{"c/", "/", "c/", 0, []string{"c/d"}, nil},
+	}
+	for _, tt := range tests {
+		gotKeys, gotPrefixes := listKeys(tt.prefix, tt.delimiter, tt.marker, tt.maxKeys)
+		if strings.Join(gotKeys, ",") != strings.Join(tt.wantKeys, ",") || strings.Join(gotPrefixes, ",") != strings.Join(tt.wantPrefixes, ",") {
+			t.Errorf("ListObjects(%q, %q, %q, %d) = %v %v, want %v %v", tt.prefix, tt.delimiter, tt.marker, tt.maxKeys, gotKeys, gotPrefixes, tt.wantKeys, tt.wantPrefixes)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// More keys than a batch, nested at several levels
+	var keys []string
+	for i := range deletePrefixBatch + 50 {
+		keys = append(keys, "dir/"+strconv.Itoa(i%7)+"/"+strconv.Itoa(i))
+	}
+	keys = append(keys, "dir/", "dir-other", "other/key")
+	for _, key := range keys {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(key), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+
+	deleted := map[string]bool{}
+	err = store.DeletePrefix(context.Background(), bucketName, "dir/", func(key string, err error) {
+		if err != nil {
+			t.Errorf("Delete of %s failed: %v", key, err)
+		}
+		if deleted[key] {
+			t.Errorf("Key %s deleted twice", key)
+		}
+		deleted[key] = true
+	})
+	if err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+	if len(deleted) != deletePrefixBatch+51 {
+		t.Errorf("Expected %d keys to be deleted, got %d", deletePrefixBatch+51, len(deleted))
+	}
+
+	objects, _, err := store.ListObjects(bucketName, "", "", "", 0)
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	var remaining []string
+	for _, obj := range objects {
+		remaining = append(remaining, obj.Key)
+	}
+	if strings.Join(remaining, ",") != "dir-other,other/key" {
+		t.Errorf("Expected the keys outside the prefix to remain, got %v", remaining)
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := store.DeletePrefix(ctx, bucketName, "", func(key string, err error) {
+			t.Errorf("Unexpected delete of %s", key)
+		})
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("NonexistentBucket", func(t *testing.T) {
+		if err := store.DeletePrefix(context.Background(), "missing-bucket", "", func(string, error) {}); err != ErrBucketNotFound {
+			t.Errorf("Expected ErrBucketNotFound, got %v", err)
+		}
+	})
+}
+
+func TestDeleteObjectKeepsKeysBelow(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// "folder/" and "folder/file" share a directory on disk
+	for _, key := range []string{"folder/", "folder/file"} {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(key), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+	if err := store.DeleteObject(bucketName, "folder/"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, _, err := store.GetObject(bucketName, "folder/"); err != ErrObjectNotFound {
+		t.Errorf("Expected the folder object to be deleted, got %v", err)
+	}
+	reader, _, err := store.GetObject(bucketName, "folder/file")
+	if err != nil {
+		t.Fatalf("Expected the key below the deleted object to remain, got %v", err)
+	}
+	reader.Close()
+
+	// Deleting the last key removes the empty directories
+	if err := store.DeleteObject(bucketName, "folder/file"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, bucketName, "folder")); !os.IsNotExist(err) {
+		t.Errorf("Expected the folder directory to be removed, got %v", err)
+	}
+}
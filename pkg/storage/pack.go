@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// packsDir holds the segment files the contents of small objects are packed into
+	packsDir = ".packs"
+	// packSegmentSize is the size past which appends go to a new segment
+	packSegmentSize = 64 << 20
+	// packCompactRatio is the share of a segment left unreferenced by deletes past which CompactPacks rewrites it
+	packCompactRatio = 0.5
+)
+
+var (
+	// packIndexBucket is the BoltDB bucket name for the locations of packed contents
+	packIndexBucket = []byte("packs")
+
+	// errPackedReplica is returned when opening a read replica of a data directory holding packed contents,
+	// whose index lives in the database the writing process holds
+	errPackedReplica = errors.New("read replicas cannot serve packed object contents")
+)
+
+// WithPacking appends the contents of objects of up to threshold bytes to shared segment files
+// instead of storing each in a file of its own, saving an inode per object for millions of small objects
+// Objects up to the inline threshold stay in their meta file, and the space of deleted contents
+// is reclaimed by CompactPacks. Packing cannot be combined with mirrors
+func WithPacking(threshold int64) Option {
+	return func(s *Storage) {
+		s.packThreshold = threshold
+	}
+}
+
+// packLocation is where a packed content is stored
+type packLocation struct {
+	Segment uint64
+	Offset  int64
+	Size    int64
+}
+
+// encode returns the index value of the location
+func (l packLocation) encode() []byte {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint64(buf[0:], l.Segment)
+	binary.BigEndian.PutUint64(buf[8:], uint64(l.Offset))
+	binary.BigEndian.PutUint64(buf[16:], uint64(l.Size))
+	return buf
+}
+
+// decodePackLocation parses an index value, reporting false if it is not one
+func decodePackLocation(data []byte) (packLocation, bool) {
+	if len(data) != 24 {
+		return packLocation{}, false
+	}
+	return packLocation{
+		Segment: binary.BigEndian.Uint64(data[0:]),
+		Offset:  int64(binary.BigEndian.Uint64(data[8:])),
+		Size:    int64(binary.BigEndian.Uint64(data[16:])),
+	}, true
+}
+
+// packWriter appends contents to the active segment
+// Every process starts a segment of its own, so the possibly torn end of an earlier one is never appended to
+type packWriter struct {
+	mu sync.Mutex
+	// file is the active segment, nil until the first append
+	file    *os.File
+	segment uint64
+	size    int64
+}
+
+// close closes the active segment
+func (w *packWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// segmentPath returns the path of a segment file
+func (s *Storage) segmentPath(segment uint64) string {
+	return filepath.Join(s.basePath, packsDir, fmt.Sprintf("%016x", segment))
+}
+
+// listSegments returns the segments of the data directory in ascending order
+func (s *Storage) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.basePath, packsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		segment, err := strconv.ParseUint(entry.Name(), 16, 64)
+		if err != nil || !entry.Type().IsRegular() {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+	return segments, nil
+}
+
+// packedLocation returns where the content of digest is packed, nil if it is not packed
+func (s *Storage) packedLocation(digest string) (*packLocation, error) {
+	if s.refcountDB == nil {
+		return nil, nil
+	}
+	var loc *packLocation
+	err := s.refcountDB.View(func(tx *bolt.Tx) error {
+		if l, ok := decodePackLocation(tx.Bucket(packIndexBucket).Get([]byte(digest))); ok {
+			loc = &l
+		}
+		return nil
+	})
+	return loc, err
+}
+
+// referencePacked increments the reference count of the content of digest if it is packed,
+// reporting whether it is
+func (s *Storage) referencePacked(digest string) (bool, error) {
+	if s.refcountDB == nil {
+		return false, ErrReadReplica
+	}
+	packed := false
+	err := s.refcountDB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(packIndexBucket).Get([]byte(digest)) == nil {
+			return nil
+		}
+		packed = true
+		return incrementRefCountTx(tx, digest)
+	})
+	return packed, err
+}
+
+// storePacked appends the content at srcPath to the active segment and indexes it under digest
+func (s *Storage) storePacked(srcPath, digest string, size int64) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	s.packs.mu.Lock()
+	loc, err := s.appendSegment(src, size)
+	s.packs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.refcountDB.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(packIndexBucket)
+		key := []byte(digest)
+		// A concurrent write of the same content got there first, the appended copy is left for compaction
+		if index.Get(key) == nil {
+			if err := index.Put(key, loc.encode()); err != nil {
+				return err
+			}
+		}
+		return incrementRefCountTx(tx, digest)
+	})
+}
+
+// appendSegment appends size bytes of r to the active segment, starting a new one when it is full
+// The caller must hold s.packs.mu
+func (s *Storage) appendSegment(r io.Reader, size int64) (packLocation, error) {
+	w := &s.packs
+	if w.file == nil || w.size >= packSegmentSize {
+		if err := s.startSegment(); err != nil {
+			return packLocation{}, err
+		}
+	}
+
+	loc := packLocation{Segment: w.segment, Offset: w.size, Size: size}
+	n, err := io.Copy(io.NewOffsetWriter(w.file, loc.Offset), io.LimitReader(r, size))
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// Drop the partial append so the next one starts at a known offset
+		w.file.Truncate(loc.Offset)
+		return packLocation{}, err
+	}
+	w.size += size
+	return loc, nil
+}
+
+// startSegment closes the active segment and creates the next one
+// The caller must hold s.packs.mu
+func (s *Storage) startSegment() error {
+	w := &s.packs
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	next := w.segment + 1
+	if len(segments) != 0 && segments[len(segments)-1] >= next {
+		next = segments[len(segments)-1] + 1
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.basePath, packsDir), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.segmentPath(next), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file, w.segment, w.size = file, next, 0
+	return nil
+}
+
+// openPacked opens a packed content for reading, sharing the segment handle with concurrent readers
+func (s *Storage) openPacked(digest string, loc *packLocation) (*sharedFileReader, error) {
+	file, err := s.files.openSection(s.segmentPath(loc.Segment), loc.Offset, loc.Size)
+	if err == nil || !os.IsNotExist(err) {
+		return file, err
+	}
+	// The segment was compacted since the location was looked up
+	loc, err = s.packedLocation(digest)
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		return nil, os.ErrNotExist
+	}
+	return s.files.openSection(s.segmentPath(loc.Segment), loc.Offset, loc.Size)
+}
+
+// CompactPacks rewrites the segments whose share of contents no longer referenced since deletes
+// reaches packCompactRatio, moving their remaining contents to the active segment, and removes
+// segments left without contents, returning the number of bytes reclaimed
+// Once ctx is done it stops with its error
+func (s *Storage) CompactPacks(ctx context.Context) (int64, error) {
+	if s.refcountDB == nil {
+		return 0, ErrReadReplica
+	}
+	segments, err := s.listSegments()
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+
+	// Contents are only ever removed from segments other than the active one, so live sizes only shrink
+	live := map[uint64]int64{}
+	err = s.refcountDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(packIndexBucket).ForEach(func(_, value []byte) error {
+			if loc, ok := decodePackLocation(value); ok {
+				live[loc.Segment] += loc.Size
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.packs.mu.Lock()
+	active, hasActive := s.packs.segment, s.packs.file != nil
+	s.packs.mu.Unlock()
+
+	var reclaimed int64
+	for _, segment := range segments {
+		if err := ctx.Err(); err != nil {
+			return reclaimed, err
+		}
+		if hasActive && segment >= active {
+			continue
+		}
+		info, err := os.Stat(s.segmentPath(segment))
+		if err != nil {
+			continue
+		}
+		dead := info.Size() - live[segment]
+		if live[segment] > 0 && float64(dead) < packCompactRatio*float64(info.Size()) {
+			continue
+		}
+		moved, err := s.compactSegment(ctx, segment)
+		if err != nil {
+			return reclaimed, err
+		}
+		reclaimed += info.Size() - moved
+	}
+	return reclaimed, nil
+}
+
+// compactSegment moves the contents still referenced in segment to the active segment and removes it,
+// returning the number of bytes moved
+func (s *Storage) compactSegment(ctx context.Context, segment uint64) (int64, error) {
+	path := s.segmentPath(segment)
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	type packedContent struct {
+		digest string
+		loc    packLocation
+	}
+	var contents []packedContent
+	err = s.refcountDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(packIndexBucket).ForEach(func(key, value []byte) error {
+			if loc, ok := decodePackLocation(value); ok && loc.Segment == segment {
+				contents = append(contents, packedContent{string(key), loc})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	var moved int64
+	for _, c := range contents {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+		loc, err := s.appendSegment(io.NewSectionReader(src, c.loc.Offset, c.loc.Size), c.loc.Size)
+		if err != nil {
+			return moved, err
+		}
+		err = s.refcountDB.Update(func(tx *bolt.Tx) error {
+			index := tx.Bucket(packIndexBucket)
+			key := []byte(c.digest)
+			// Contents deleted meanwhile leave their new copy for the next compaction
+			if current, ok := decodePackLocation(index.Get(key)); !ok || current != c.loc {
+				return nil
+			}
+			return index.Put(key, loc.encode())
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved += c.loc.Size
+	}
+
+	// Readers which already opened the segment keep reading it, later ones look the contents up again
+	s.files.evict(path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return moved, err
+	}
+	return moved, nil
+}
+
+// migratePacks creates the directory of pack segments
+// Earlier versions would not find packed contents, so the layout version keeps them from opening the data directory
+func migratePacks(s *Storage, progress func(done, total int)) error {
+	return os.MkdirAll(filepath.Join(s.basePath, packsDir), 0755)
+}
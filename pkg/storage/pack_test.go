@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPacking(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	const threshold = 64 << 10
+	store, err := NewStorage(tmpDir, WithPacking(threshold))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer func() {
+		store.Close()
+	}()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	objects := map[string]string{
+		"small":  strings.Repeat("a", inlineThreshold+1),
+		"medium": strings.Repeat("b", 3*inlineThreshold),
+		"same":   strings.Repeat("b", 3*inlineThreshold),
+		"large":  strings.Repeat("c", threshold+1),
+	}
+	for key, data := range objects {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(data), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+	read := func(key string) string {
+		t.Helper()
+		reader, info, err := store.GetObject(bucketName, key)
+		if err != nil {
+			t.Fatalf("GetObject %s failed: %v", key, err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		if info.Size != int64(len(data)) {
+			t.Errorf("Expected the size of %s to be %d, got %d", key, len(data), info.Size)
+		}
+		return string(data)
+	}
+	check := func() {
+		t.Helper()
+		for key, data := range objects {
+			if got := read(key); got != data {
+				t.Errorf("Unexpected content of %s, got %d bytes", key, len(got))
+			}
+		}
+		listed, _, err := store.ListObjects(bucketName, "", "", "", 0)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		for _, obj := range listed {
+			if obj.Size != int64(len(objects[obj.Key])) {
+				t.Errorf("Expected %s to be listed with size %d, got %d", obj.Key, len(objects[obj.Key]), obj.Size)
+			}
+		}
+	}
+	countFiles := func(dir string) int {
+		t.Helper()
+		n := 0
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	check()
+	// Only the object above the threshold has a file of its own, identical contents are packed once
+	if n := countFiles(filepath.Join(tmpDir, objectsDir)); n != 1 {
+		t.Errorf("Expected 1 content file, got %d", n)
+	}
+	segments, err := store.listSegments()
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("Expected 1 segment, got %v (%v)", segments, err)
+	}
+	if info, err := os.Stat(store.segmentPath(segments[0])); err != nil || info.Size() != int64(len(objects["small"])+len(objects["medium"])) {
+		t.Errorf("Expected the segment to hold the packed contents once, got %v (%v)", info, err)
+	}
+
+	// Copies share the packed content, ranged copies read it
+	if _, err := store.CopyObject(bucketName, "small", bucketName, "copy", nil); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	objects["copy"] = objects["small"]
+	uploadID, err := store.InitiateMultipartUpload(bucketName, "part-copy", Metadata{}, "")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	if part, err := store.UploadPartCopy(context.Background(), bucketName, "part-copy", uploadID, 1, bucketName, "medium", 10, 19); err != nil || part.Size != 10 {
+		t.Fatalf("UploadPartCopy failed: %+v (%v)", part, err)
+	}
+	store.AbortMultipartUpload(bucketName, "part-copy", uploadID)
+	check()
+
+	// A new process appends to a segment of its own, leaving the first one to compaction
+	store.Close()
+	store, err = NewStorage(tmpDir, WithPacking(threshold))
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	objects["other"] = strings.Repeat("d", 2*inlineThreshold)
+	if _, err := store.PutObject(context.Background(), bucketName, "other", strings.NewReader(objects["other"]), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Nothing is reclaimed while most of the segment is referenced
+	if reclaimed, err := store.CompactPacks(context.Background()); err != nil || reclaimed != 0 {
+		t.Errorf("Expected nothing to be reclaimed, got %d (%v)", reclaimed, err)
+	}
+	for _, key := range []string{"medium", "same"} {
+		if err := store.DeleteObject(bucketName, key); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		delete(objects, key)
+	}
+	reclaimed, err := store.CompactPacks(context.Background())
+	if err != nil {
+		t.Fatalf("CompactPacks failed: %v", err)
+	}
+	if reclaimed != int64(3*inlineThreshold) {
+		t.Errorf("Expected %d bytes reclaimed, got %d", 3*inlineThreshold, reclaimed)
+	}
+	if _, err := os.Stat(store.segmentPath(segments[0])); !os.IsNotExist(err) {
+		t.Errorf("Expected the compacted segment to be removed, got %v", err)
+	}
+	check()
+
+	// The last reference to a packed content drops it from the index
+	for _, key := range []string{"small", "copy"} {
+		if err := store.DeleteObject(bucketName, key); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		delete(objects, key)
+	}
+	digest := sha256.Sum256([]byte(strings.Repeat("a", inlineThreshold+1)))
+	if loc, err := store.packedLocation(hex.EncodeToString(digest[:])); err != nil || loc != nil {
+		t.Errorf("Expected the content to be unpacked, got %+v (%v)", loc, err)
+	}
+	check()
+
+	t.Run("ReadReplica", func(t *testing.T) {
+		if _, err := NewStorage(tmpDir, WithReadReplica()); err != errPackedReplica {
+			t.Errorf("Expected errPackedReplica, got %v", err)
+		}
+	})
+
+	t.Run("Mirrors", func(t *testing.T) {
+		if _, err := NewStorage(t.TempDir(), WithPacking(threshold), WithMirrors(t.TempDir())); err == nil {
+			t.Error("Expected packing with mirrors to be rejected")
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// probeDir is the hidden bucket of the canary objects written by Probe
+// Its leading dot keeps it out of the buckets listed
+const probeDir = ".probe"
+
+// probeSize is the size of canary objects, larger than inline objects so the content takes the path of stored data
+const probeSize = inlineThreshold + 1
+
+// probeCacheTime is how long the result of a probe is returned to later callers instead of probing again,
+// so that frequent or concurrent readiness checks do not turn into as many synced writes
+const probeCacheTime = time.Second
+
+// probeState holds the probe in flight and the result of the last one
+type probeState struct {
+	mu sync.Mutex
+	// running is closed when the probe in flight finishes, nil if none is
+	running chan struct{}
+	err     error
+	// finished is when the last probe finished, zero if none did
+	finished time.Time
+}
+
+// Probe checks that the data directory accepts writes by writing, reading back and removing a canary object,
+// catching read-only filesystems, full disks and hung mounts that checks of the process miss
+// Read replicas never write, so they only read the data directory
+// Concurrent callers share the probe in flight, which a caller giving up does not cancel, and no new probe
+// starts before it finishes, so a hung filesystem holds a single probe; callers within probeCacheTime
+// of the last probe get its result
+// It gives up once ctx is done, even if the filesystem does not return
+func (s *Storage) Probe(ctx context.Context) error {
+	p := &s.probes
+	p.mu.Lock()
+	if p.running == nil {
+		if !p.finished.IsZero() && time.Since(p.finished) < probeCacheTime {
+			err := p.err
+			p.mu.Unlock()
+			return err
+		}
+		running := make(chan struct{})
+		p.running = running
+		go func() {
+			err := s.probe()
+			p.mu.Lock()
+			p.err = err
+			p.finished = time.Now()
+			p.running = nil
+			p.mu.Unlock()
+			close(running)
+		}()
+	}
+	running := p.running
+	p.mu.Unlock()
+
+	select {
+	case <-running:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Storage) probe() error {
+	if s.readReplica {
+		_, err := os.ReadDir(s.basePath)
+		return err
+	}
+	if free, ok := freeSpace(s.basePath); ok && s.minFreeSpace > 0 && free < s.minFreeSpace {
+		return ErrInsufficientStorage
+	}
+
+	canary := make([]byte, probeSize)
+	rand.Read(canary)
+
+	// The canary is written like stored content, through a synced temporary file renamed into place
+	tmp, err := s.contentTempFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(canary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.basePath, probeDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(tmp.Name()))
+	if err := renameFile(tmp.Name(), path); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, canary) {
+		return errors.New("canary object read back differs from the one written")
+	}
+	return os.Remove(path)
+}
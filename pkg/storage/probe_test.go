@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Probe(context.Background()); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(store.basePath, probeDir)); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the canary to be removed, got %v (%v)", entries, err)
+	}
+	if buckets, err := store.ListBuckets("", "", 0); err != nil || len(buckets) != 0 {
+		t.Errorf("Expected the probe bucket to be hidden, got %v (%v)", buckets, err)
+	}
+
+	t.Run("InsufficientStorage", func(t *testing.T) {
+		store.minFreeSpace = 1 << 62
+		defer func() { store.minFreeSpace = 0 }()
+		if _, ok := freeSpace(store.basePath); !ok {
+			t.Skip("Free space is not supported on this platform")
+		}
+		// The result of the last probe is reused until it is older than probeCacheTime
+		if err := store.Probe(context.Background()); err != nil {
+			t.Errorf("Expected the last result to be reused, got %v", err)
+		}
+		store.probes.finished = time.Now().Add(-probeCacheTime)
+		if err := store.Probe(context.Background()); err != ErrInsufficientStorage {
+			t.Errorf("Expected ErrInsufficientStorage, got %v", err)
+		}
+	})
+
+	t.Run("InFlight", func(t *testing.T) {
+		// A probe in flight is shared rather than joined by another one
+		running := make(chan struct{})
+		store.probes.mu.Lock()
+		store.probes.running = running
+		store.probes.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		for range 10 {
+			if err := store.Probe(ctx); err != context.DeadlineExceeded {
+				t.Fatalf("Expected the probe to time out, got %v", err)
+			}
+		}
+		store.probes.mu.Lock()
+		if store.probes.running != running {
+			t.Errorf("Expected no probe to start while one is in flight")
+		}
+		store.probes.err = nil
+		store.probes.finished = time.Now()
+		store.probes.running = nil
+		store.probes.mu.Unlock()
+		close(running)
+	})
+
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repairMetrics exposes the progress of the last mirror repair through expvar
+var repairMetrics = expvar.NewMap("s3d_repair")
+
+// RepairOptions configures RepairMirrors
+type RepairOptions struct {
+	// MissingOnly only copies content missing from the mirrors, without hashing every copy to find damaged ones
+	MissingOnly bool
+	// RateLimit is the maximum number of bytes copied per second, 0 for no limit
+	RateLimit int64
+	// Progress receives the progress after each object
+	Progress func(RepairProgress)
+}
+
+// RepairProgress reports the progress of a mirror repair
+type RepairProgress struct {
+	// Objects is the number of content-addressed objects to check
+	Objects int
+	// Checked is the number of objects checked so far
+	Checked int
+	// Repaired is the number of copies written
+	Repaired int
+	// Failed is the number of objects without an intact copy to repair from
+	Failed int
+	// Bytes is the number of bytes copied
+	Bytes int64
+}
+
+// publish exposes the progress through expvar
+func (p RepairProgress) publish() {
+	for key, value := range map[string]int64{
+		"objects":  int64(p.Objects),
+		"checked":  int64(p.Checked),
+		"repaired": int64(p.Repaired),
+		"failed":   int64(p.Failed),
+		"bytes":    p.Bytes,
+	} {
+		v := new(expvar.Int)
+		v.Set(value)
+		repairMetrics.Set(key, v)
+	}
+}
+
+// RepairMirrors copies every content-addressed object to the mirrors lacking it, such as a replacement disk,
+// and rewrites the copies in the data directory or the mirrors not matching their digest from an intact one
+// It runs alongside regular traffic until ctx is done, throttled by the rate limit
+func (s *Storage) RepairMirrors(ctx context.Context, opts RepairOptions) (RepairProgress, error) {
+	var progress RepairProgress
+	if len(s.mirrors) == 0 {
+		return progress, nil
+	}
+	if s.readReplica {
+		return progress, ErrReadReplica
+	}
+
+	digests, err := s.listContent()
+	if err != nil {
+		return progress, err
+	}
+	progress.Objects = len(digests)
+	progress.publish()
+
+	limiter := newRateLimiter(opts.RateLimit)
+	for _, digest := range digests {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		repaired, bytes, err := s.repairContent(ctx, digest, !opts.MissingOnly, limiter)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return progress, ctxErr
+			}
+			progress.Failed++
+			log.Printf("Failed to repair object content %s: %v", digest, err)
+		}
+		progress.Checked++
+		progress.Repaired += repaired
+		progress.Bytes += bytes
+		progress.publish()
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+	return progress, nil
+}
+
+// listContent returns the digests of all content-addressed objects in the data directory
+func (s *Storage) listContent() ([]string, error) {
+	shards, err := os.ReadDir(s.objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for _, shard := range shards {
+		// Skip the temporary files of content written in .objects
+		if !shard.IsDir() || strings.HasPrefix(shard.Name(), ".") {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.objectsDir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				digests = append(digests, entry.Name())
+			}
+		}
+	}
+	return digests, nil
+}
+
+// repairContent brings every copy of a content-addressed object in line with an intact copy,
+// returning the number of copies written and the bytes copied
+func (s *Storage) repairContent(ctx context.Context, digest string, verify bool, limiter *rateLimiter) (int, int64, error) {
+	objPath, err := s.objectPath(digest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	repaired := 0
+	if verify {
+		ok, err := verifyContent(objPath, digest)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		if !ok {
+			if err := s.heal(digest); err != nil {
+				return 0, 0, err
+			}
+			repaired++
+		}
+	}
+
+	info, err := os.Stat(objPath)
+	if err != nil {
+		// The object was deleted since it was listed
+		return repaired, 0, nil
+	}
+
+	var copied int64
+	for _, mirror := range s.mirrors {
+		dst := mirrorPath(mirror, digest)
+		if verify {
+			if ok, _ := verifyContent(dst, digest); ok {
+				continue
+			}
+		} else if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+
+		src, err := os.Open(objPath)
+		if err != nil {
+			return repaired, copied, err
+		}
+		err = copyReader(limiter.reader(ctx, src), dst, filepath.Join(mirror, tempDir))
+		src.Close()
+		if err != nil {
+			metrics.Add("mirror_errors", 1)
+			return repaired, copied, err
+		}
+		repaired++
+		copied += info.Size()
+
+		// Drop the copy if the object was deleted while it was copied
+		if _, err := os.Stat(objPath); os.IsNotExist(err) {
+			os.Remove(dst)
+			return repaired, copied, nil
+		}
+	}
+	return repaired, copied, nil
+}
+
+// rateLimiter throttles copies to a number of bytes per second
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	total int64
+}
+
+// newRateLimiter creates a limiter of rate bytes per second, 0 for no limit
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// reader wraps r so reads are throttled by the limiter
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l.rate <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: l}
+}
+
+// wait blocks until n more bytes fit in the rate
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.total += int64(n)
+	due := l.start.Add(time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader is a reader throttled by a rate limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read in small chunks so the throttling stays smooth
+	if int64(len(p)) > t.limiter.rate {
+		p = p[:max(t.limiter.rate, 1)]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepairMirrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := filepath.Join(tmpDir, "data")
+	mirror1 := filepath.Join(tmpDir, "mirror1")
+	store, err := NewStorage(dataDir, WithMirrors(mirror1))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucketName := "test-bucket-repair"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	var digests []string
+	for _, key := range []string{"a", "b"} {
+		content := bytes.Repeat([]byte(key), 10000)
+		if _, err := store.PutObject(context.Background(), bucketName, key, bytes.NewReader(content), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		sum := sha256.Sum256(content)
+		digests = append(digests, hex.EncodeToString(sum[:]))
+	}
+	store.Close()
+
+	// A replacement disk is added as an empty mirror
+	mirror2 := filepath.Join(tmpDir, "mirror2")
+	store, err = NewStorage(dataDir, WithMirrors(mirror1, mirror2))
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	var updates int
+	progress, err := store.RepairMirrors(context.Background(), RepairOptions{
+		Progress: func(RepairProgress) { updates++ },
+	})
+	if err != nil {
+		t.Fatalf("RepairMirrors failed: %v", err)
+	}
+	if progress.Objects != 2 || progress.Checked != 2 || progress.Repaired != 2 || progress.Failed != 0 || progress.Bytes != 20000 {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+	if updates != 2 {
+		t.Errorf("Expected a progress update per object, got %d", updates)
+	}
+	for _, digest := range digests {
+		if ok, err := verifyContent(mirrorPath(mirror2, digest), digest); !ok {
+			t.Errorf("Expected %s on the replacement mirror: %v", digest, err)
+		}
+	}
+
+	// Damaged copies are rewritten unless only missing ones are looked for
+	if err := os.WriteFile(mirrorPath(mirror1, digests[0]), []byte("damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if progress, _ := store.RepairMirrors(context.Background(), RepairOptions{MissingOnly: true}); progress.Repaired != 0 {
+		t.Errorf("Expected no repair when only looking for missing copies, got %+v", progress)
+	}
+	if progress, _ := store.RepairMirrors(context.Background(), RepairOptions{}); progress.Repaired != 1 {
+		t.Errorf("Expected the damaged copy to be repaired, got %+v", progress)
+	}
+	if ok, _ := verifyContent(mirrorPath(mirror1, digests[0]), digests[0]); !ok {
+		t.Error("Expected the damaged copy to be rewritten")
+	}
+
+	// A damaged copy in the data directory is restored from a mirror instead of being copied to the others
+	objPath, _ := store.objectPath(digests[1])
+	if err := os.WriteFile(objPath, []byte("damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if progress, _ := store.RepairMirrors(context.Background(), RepairOptions{}); progress.Repaired != 1 || progress.Failed != 0 {
+		t.Errorf("Expected the data directory copy to be repaired, got %+v", progress)
+	}
+	for _, path := range []string{objPath, mirrorPath(mirror1, digests[1]), mirrorPath(mirror2, digests[1])} {
+		if ok, _ := verifyContent(path, digests[1]); !ok {
+			t.Errorf("Expected %s to be intact", path)
+		}
+	}
+
+	// The rate limit throttles copies, and cancellation stops the repair
+	os.RemoveAll(filepath.Join(mirror2, objectsDir))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := store.RepairMirrors(ctx, RepairOptions{RateLimit: 1000}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the throttled repair to be cancelled, got %v", err)
+	}
+}
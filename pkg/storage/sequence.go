@@ -0,0 +1,33 @@
+package storage
+
+import "sync"
+
+// bucketSequences holds the sequence numbers of the buckets of a Storage
+type bucketSequences struct {
+	mu   sync.Mutex
+	seqs map[string]uint64
+}
+
+// BucketSequence returns the sequence number of bucket, counting the changes made to its objects
+// through s, which start at 0 when s is opened
+// A change is numbered once it is visible, so reads and listings of the bucket starting after
+// BucketSequence returned n observe every change numbered up to n: this is the read-after-write
+// and list-after-write guarantee of the storage, concurrent writers included
+// Changes made by other processes sharing the data directory are not counted
+func (s *Storage) BucketSequence(bucket string) uint64 {
+	s.sequences.mu.Lock()
+	defer s.sequences.mu.Unlock()
+	return s.sequences.seqs[bucket]
+}
+
+// advanceSequence numbers a change made to the objects of bucket, which must be visible already
+func (s *Storage) advanceSequence(bucket string) uint64 {
+	s.sequences.mu.Lock()
+	defer s.sequences.mu.Unlock()
+
+	if s.sequences.seqs == nil {
+		s.sequences.seqs = map[string]uint64{}
+	}
+	s.sequences.seqs[bucket]++
+	return s.sequences.seqs[bucket]
+}
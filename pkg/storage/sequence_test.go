@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBucketSequence(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	for _, bucket := range []string{"test-bucket", "other-bucket"} {
+		if err := store.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	seen := map[uint64]bool{}
+	defer store.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Bucket == "test-bucket" {
+			seen[e.Sequence] = true
+		}
+	})()
+
+	// Every writer observes its own change, and all those numbered before it, in reads and listings
+	const writers, writes = 8, 10
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Go(func() {
+			for i := range writes {
+				key := fmt.Sprintf("writer-%d/%03d", w, i)
+				if _, err := store.PutObject(context.Background(), "test-bucket", key, strings.NewReader(key), Metadata{}, ""); err != nil {
+					t.Errorf("PutObject failed: %v", err)
+					return
+				}
+				sequence := store.BucketSequence("test-bucket")
+				objects, _, err := store.ListObjects("test-bucket", "", "", "", writers*writes)
+				if err != nil {
+					t.Errorf("ListObjects failed: %v", err)
+					return
+				}
+				if uint64(len(objects)) < sequence {
+					t.Errorf("Expected at least %d objects listed after sequence %d, got %d", sequence, sequence, len(objects))
+				}
+				if _, _, err := store.GetObject("test-bucket", key); err != nil {
+					t.Errorf("Expected %s to be readable after its write, got %v", key, err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if sequence := store.BucketSequence("test-bucket"); sequence != writers*writes {
+		t.Errorf("Expected sequence %d, got %d", writers*writes, sequence)
+	}
+	if len(seen) != writers*writes || seen[0] {
+		t.Errorf("Expected the events to be numbered 1 to %d, got %d numbers", writers*writes, len(seen))
+	}
+
+	// Deletes count too, each bucket is numbered on its own
+	if err := store.DeleteObject("test-bucket", "writer-0/000"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if sequence := store.BucketSequence("test-bucket"); sequence != writers*writes+1 {
+		t.Errorf("Expected the delete to advance the sequence to %d, got %d", writers*writes+1, sequence)
+	}
+	if sequence := store.BucketSequence("other-bucket"); sequence != 0 {
+		t.Errorf("Expected the other bucket to be unchanged, got sequence %d", sequence)
+	}
+}
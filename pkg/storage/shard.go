@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// shardDirPrefix starts the names of shard directories
+	// Keys never contain "..", so no key component can be mistaken for a shard directory
+	shardDirPrefix = ".."
+	// maxShardFanout is the highest number of shard directories per directory
+	maxShardFanout = 256
+)
+
+// WithDirectorySharding spreads the entries of every directory of object keys over fanout
+// sub-directories chosen by a hash of their name, bounding the number of entries per directory
+// for prefixes holding millions of keys, which degrade filesystems such as ext4 and NFS
+// The fan-out is recorded in the data directory, which must not hold buckets yet, and applies
+// from then on whether or not the option is given again
+func WithDirectorySharding(fanout int) Option {
+	return func(s *Storage) {
+		s.shardFanout = fanout
+	}
+}
+
+// initSharding applies the directory sharding recorded in the layout file,
+// recording the requested one on a data directory without buckets
+func (s *Storage) initSharding() error {
+	state, err := s.loadLayout()
+	if err != nil {
+		return err
+	}
+	recorded := 0
+	if state != nil {
+		recorded = state.ShardFanout
+	}
+	if s.shardFanout == 0 || s.shardFanout == recorded {
+		s.shardFanout = recorded
+		return nil
+	}
+
+	if recorded != 0 {
+		return fmt.Errorf("the data directory is sharded with a fan-out of %d, not %d", recorded, s.shardFanout)
+	}
+	if s.shardFanout < 2 || s.shardFanout > maxShardFanout {
+		return fmt.Errorf("directory sharding fan-out must be between 2 and %d, got %d", maxShardFanout, s.shardFanout)
+	}
+	if s.readReplica {
+		return fmt.Errorf("directory sharding must be enabled by the process writing the data directory")
+	}
+	buckets, err := s.ListBuckets("", "", 1)
+	if err != nil {
+		return err
+	}
+	if len(buckets) != 0 {
+		return fmt.Errorf("directory sharding can only be enabled on a data directory without buckets")
+	}
+
+	state.ShardFanout = s.shardFanout
+	return s.saveLayout(state)
+}
+
+// shardedKeyPath returns the path of the directory of key relative to its bucket,
+// with every component of the key in the shard directory picked by its name
+func (s *Storage) shardedKeyPath(key string) string {
+	parts := strings.Split(filepath.Clean(key), string(filepath.Separator))
+	path := make([]string, 0, 2*len(parts))
+	for _, part := range parts {
+		h := fnv.New32a()
+		h.Write([]byte(part))
+		path = append(path, fmt.Sprintf("%s%02x", shardDirPrefix, h.Sum32()%uint32(s.shardFanout)), part)
+	}
+	return filepath.Join(path...)
+}
+
+// isShardDir reports whether the directory entry name is a shard directory
+func isShardDir(name string) bool {
+	return strings.HasPrefix(name, shardDirPrefix)
+}
+
+// readObjectDir returns the entries of the object directory dir, with the entries of its shard directories
+// named by their path relative to dir
+func readObjectDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !isShardDir(name) {
+			names = append(names, name)
+			continue
+		}
+		shardEntries, err := os.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, shardEntry := range shardEntries {
+			if shardEntry.IsDir() {
+				names = append(names, filepath.Join(name, shardEntry.Name()))
+			}
+		}
+	}
+	return names, nil
+}
+
+// migrateSharding leaves the data directory as it is
+// Sharding is only enabled on data directories without buckets, so no object moves, but earlier
+// versions would not find the objects of a sharded data directory
+func migrateSharding(s *Storage, progress func(done, total int)) error {
+	return nil
+}
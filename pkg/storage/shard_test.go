@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDirectorySharding(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	const fanout = 16
+	store, err := NewStorage(tmpDir, WithDirectorySharding(fanout))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	keys := []string{"a", "a-b", "a/b", "dir/nested/deep.txt"}
+	for i := range 200 {
+		keys = append(keys, fmt.Sprintf("images/%07d.jpg", i))
+	}
+	for _, key := range keys {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(key), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+	sort.Strings(keys)
+
+	listKeys := func(store *Storage, prefix, delimiter string) ([]string, []string) {
+		t.Helper()
+		objects, prefixes, err := store.ListObjects(bucketName, prefix, delimiter, "", 0)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		var listed []string
+		for _, obj := range objects {
+			listed = append(listed, obj.Key)
+		}
+		return listed, prefixes
+	}
+
+	// Listings map the sharded directories back to keys in key order
+	if listed, _ := listKeys(store, "", ""); strings.Join(listed, ",") != strings.Join(keys, ",") {
+		t.Errorf("Expected keys %v, got %v", keys, listed)
+	}
+	if listed, prefixes := listKeys(store, "", "/"); strings.Join(listed, ",") != "a,a-b" || strings.Join(prefixes, ",") != "a/,dir/,images/" {
+		t.Errorf("Unexpected delimited listing %v %v", listed, prefixes)
+	}
+	if listed, _ := listKeys(store, "images/000019", ""); len(listed) != 10 {
+		t.Errorf("Expected 10 keys under the prefix, got %v", listed)
+	}
+	reader, _, err := store.GetObject(bucketName, "images/0000042.jpg")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "images/0000042.jpg" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	// No directory holds more than its shards and the entries of a shard
+	bucketPath := filepath.Join(tmpDir, bucketName)
+	filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		entries, _ := os.ReadDir(path)
+		for _, entry := range entries {
+			if entry.IsDir() && !isShardDir(entry.Name()) && !isShardDir(filepath.Base(path)) {
+				t.Errorf("Expected %s to be in a shard directory", filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(entries) > fanout+1 && !isShardDir(filepath.Base(path)) {
+			t.Errorf("Expected at most %d entries in %s, got %d", fanout+1, path, len(entries))
+		}
+		return nil
+	})
+
+	// Deletes clean up the emptied shard directories
+	if err := store.DeleteObject(bucketName, "dir/nested/deep.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if listed, prefixes := listKeys(store, "dir/", "/"); len(listed) != 0 || len(prefixes) != 0 {
+		t.Errorf("Expected nothing left under dir/, got %v %v", listed, prefixes)
+	}
+	if dirPath, err := store.safePath(bucketName, "dir"); err != nil {
+		t.Fatalf("safePath failed: %v", err)
+	} else if _, err := os.Stat(dirPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the directory of dir to be removed, got %v", err)
+	}
+	store.Close()
+
+	t.Run("Recorded", func(t *testing.T) {
+		// The recorded fan-out applies without the option
+		store, err := NewStorage(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to reopen storage: %v", err)
+		}
+		defer store.Close()
+		if listed, _ := listKeys(store, "images/", ""); len(listed) != 200 {
+			t.Errorf("Expected 200 images, got %d", len(listed))
+		}
+		if _, _, err := store.GetObject(bucketName, "a/b"); err != nil {
+			t.Errorf("GetObject failed: %v", err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		if _, err := NewStorage(tmpDir, WithDirectorySharding(2*fanout)); err == nil {
+			t.Error("Expected a different fan-out to be rejected")
+		}
+	})
+
+	t.Run("ExistingBuckets", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewStorage(dir)
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if err := store.CreateBucket(bucketName); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		store.Close()
+		if _, err := NewStorage(dir, WithDirectorySharding(fanout)); err == nil {
+			t.Error("Expected sharding an existing data directory to be rejected")
+		}
+	})
+
+	t.Run("InvalidFanout", func(t *testing.T) {
+		for _, fanout := range []int{1, maxShardFanout + 1} {
+			if _, err := NewStorage(t.TempDir(), WithDirectorySharding(fanout)); err == nil {
+				t.Errorf("Expected a fan-out of %d to be rejected", fanout)
+			}
+		}
+	})
+}
@@ -1,26 +1,41 @@
 package storage
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/wzshiming/s3d/pkg/s3key"
 	bolt "go.etcd.io/bbolt"
 )
 
 const (
 	metaFile   = "meta"
 	uploadsDir = ".uploads"
+	bucketsDir = ".buckets"
 	tempDir    = ".temp"
 	objectsDir = ".objects"
 	refcountDB = "refcount.db"
 	// inlineThreshold is the maximum size (in bytes) for files to be stored inline in metadata
 	// Files smaller than or equal to this size will be embedded in the meta file
 	inlineThreshold = 4096
+	// maxPartNumber is the highest part number of a multipart upload
+	maxPartNumber = 10000
+)
+
+const (
+	// DefaultMaxPartSize is the default maximum size of a single PutObject or UploadPart request (5 GiB)
+	DefaultMaxPartSize int64 = 5 << 30
+	// DefaultMaxObjectSize is the default maximum size of an object, including multipart uploads (5 TiB)
+	DefaultMaxObjectSize int64 = 5 << 40
 )
 
 var (
@@ -29,39 +44,165 @@ var (
 )
 
 var (
-	ErrBucketNotFound      = errors.New("bucket not found")
-	ErrBucketAlreadyExists = errors.New("bucket already exists")
-	ErrObjectNotFound      = errors.New("object not found")
-	ErrInvalidUploadID     = errors.New("invalid upload id")
-	ErrInvalidPartNumber   = errors.New("invalid part number")
-	ErrInvalidBucketName   = errors.New("invalid bucket name")
-	ErrInvalidObjectKey    = errors.New("invalid object key")
-	ErrChecksumMismatch    = errors.New("checksum mismatch")
-	ErrInvalidRange        = errors.New("invalid byte range")
+	ErrBucketNotFound        = errors.New("bucket not found")
+	ErrBucketAlreadyExists   = errors.New("bucket already exists")
+	ErrObjectNotFound        = errors.New("object not found")
+	ErrInvalidUploadID       = errors.New("invalid upload id")
+	ErrInvalidPartNumber     = errors.New("invalid part number")
+	ErrInvalidPart           = errors.New("invalid part")
+	ErrInvalidBucketName     = errors.New("invalid bucket name")
+	ErrInvalidObjectKey      = errors.New("invalid object key")
+	ErrChecksumMismatch      = errors.New("checksum mismatch")
+	ErrInvalidRange          = errors.New("invalid byte range")
+	ErrEntityTooLarge        = errors.New("entity too large")
+	ErrInsufficientStorage   = errors.New("insufficient storage")
+	ErrInvalidComposeSources = errors.New("invalid number of compose sources")
+	ErrInvalidCopyObjects    = errors.New("invalid number of copies")
+	ErrObjectLocked          = errors.New("object is locked")
+	ErrBucketFrozen          = errors.New("bucket is frozen")
+	ErrPreconditionFailed    = errors.New("precondition failed")
+	ErrObjectAlreadyExists   = errors.New("object already exists")
+	ErrTrashEntryNotFound    = errors.New("trash entry not found")
+	ErrLockNotFound          = errors.New("lock not found")
+	ErrLockHeld              = errors.New("lock is held")
+
+	ErrOwnershipControlsNotFound = errors.New("ownership controls not found")
+	ErrPublicAccessBlockNotFound = errors.New("public access block not found")
+	ErrWebsiteNotFound           = errors.New("website configuration not found")
 )
 
 // Storage is the local filesystem storage backend
 type Storage struct {
-	basePath   string
-	tempDir    string
-	objectsDir string
-	refcountDB *bolt.DB
+	basePath      string
+	tempDir       string
+	objectsDir    string
+	refcountDB    *bolt.DB
+	maxPartSize   int64
+	maxObjectSize int64
+	minFreeSpace  int64
+	files         *fileCache
+	metadata      *metadataCache
+	// auditMut serializes appends to audit logs
+	auditMut sync.Mutex
+	// commits serializes the commits of writes to the same object
+	commits commitLocks
+	// bucketMetas serializes the updates of the metadata of the same bucket
+	bucketMetas commitLocks
+	// migrationProgress receives the progress of layout migrations
+	migrationProgress MigrationProgress
+	// lock holds the data directory lock, nil for read replicas
+	lock *os.File
+	// readReplica opens the data directory for reading only
+	readReplica bool
+	// metadataMaxAge is how long cached metadata is trusted before it is reloaded, 0 for no limit
+	metadataMaxAge time.Duration
+	// mirrors are directories holding a copy of every content-addressed object
+	mirrors []string
+	// mirrorLocks hold the locks of the mirror directories
+	mirrorLocks []*os.File
+	// packThreshold is the size up to which object contents are packed into segments, 0 disables packing
+	packThreshold int64
+	// packs appends packed contents
+	packs packWriter
+	// shardFanout is the number of shard directories every directory of object keys is spread over, 0 if not sharded
+	shardFanout int
+	// compress compresses the data of objects with a compressible content type
+	compress bool
+	// events holds the functions subscribed to changes of objects
+	events subscribers
+	// sequences number the changes of objects by bucket
+	sequences bucketSequences
+	// objectsTempDir holds the temporary files of content, on the filesystem of objectsDir
+	objectsTempDir string
+	// probes shares the probe in flight and the result of the last one between callers of Probe
+	probes probeState
+}
+
+// Option is a functional option for configuring Storage
+type Option func(*Storage)
+
+// WithMaxPartSize sets the maximum size of a single PutObject or UploadPart request
+func WithMaxPartSize(size int64) Option {
+	return func(s *Storage) {
+		s.maxPartSize = size
+	}
+}
+
+// WithMaxObjectSize sets the maximum size of an object, including the total size of a multipart upload
+func WithMaxObjectSize(size int64) Option {
+	return func(s *Storage) {
+		s.maxObjectSize = size
+	}
 }
 
 // NewStorage creates a new local storage backend
-func NewStorage(basePath string) (*Storage, error) {
+// The data directory is locked against other writing processes until Close
+func NewStorage(basePath string, opts ...Option) (*Storage, error) {
 	absPath, err := filepath.Abs(basePath)
 	if err != nil {
 		return nil, err
 	}
 
-	tempDir := filepath.Join(absPath, tempDir)
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	s := &Storage{
+		basePath:      absPath,
+		objectsDir:    filepath.Join(absPath, objectsDir),
+		maxPartSize:   DefaultMaxPartSize,
+		maxObjectSize: DefaultMaxObjectSize,
+		files:         newFileCache(DefaultOpenFileCacheSize),
+		metadata:      newMetadataCache(DefaultMetadataCacheSize),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.metadata.maxAge = s.metadataMaxAge
+	if s.tempDir == "" {
+		s.tempDir = filepath.Join(absPath, tempDir)
+	}
+
+	if s.readReplica {
+		// The writing process deletes and recreates files behind the replica's back
+		s.files.revalidate = true
+		if err := s.checkReplicaLayout(); err != nil {
+			return nil, err
+		}
+		if segments, err := s.listSegments(); err != nil {
+			return nil, err
+		} else if len(segments) != 0 {
+			return nil, errPackedReplica
+		}
+		if err := s.initSharding(); err != nil {
+			return nil, err
+		}
+		if err := s.initMirrors(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	if s.packThreshold > 0 && len(s.mirrors) != 0 {
+		return nil, errors.New("packing cannot be combined with mirrors")
+	}
+
+	if err := os.MkdirAll(absPath, 0755); err != nil {
+		return nil, err
+	}
+	s.lock, err = lockDataDir(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.objectsDir, 0755); err != nil {
+		s.Close()
 		return nil, err
 	}
 
-	objectsDir := filepath.Join(absPath, objectsDir)
-	if err := os.MkdirAll(objectsDir, 0755); err != nil {
+	if err := s.initTempDirs(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.initMirrors(); err != nil {
+		s.Close()
 		return nil, err
 	}
 
@@ -69,24 +210,32 @@ func NewStorage(basePath string) (*Storage, error) {
 	dbPath := filepath.Join(absPath, refcountDB)
 	db, err := bolt.Open(dbPath, 0600, nil)
 	if err != nil {
+		s.Close()
 		return nil, err
 	}
+	s.refcountDB = db
 
-	// Create bucket for reference counts
+	// Create buckets for reference counts and packed content locations
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(refcountBucket)
+		if _, err := tx.CreateBucketIfNotExists(refcountBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(packIndexBucket)
 		return err
 	})
 	if err != nil {
-		db.Close()
+		s.Close()
 		return nil, err
 	}
 
-	s := &Storage{
-		basePath:   absPath,
-		tempDir:    tempDir,
-		objectsDir: objectsDir,
-		refcountDB: db,
+	if err := s.migrate(migrations, LayoutVersion); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.initSharding(); err != nil {
+		s.Close()
+		return nil, err
 	}
 
 	return s, nil
@@ -94,16 +243,64 @@ func NewStorage(basePath string) (*Storage, error) {
 
 // Close closes the storage backend and releases resources
 func (s *Storage) Close() error {
+	s.files.close()
+	s.packs.close()
+	var err error
 	if s.refcountDB != nil {
-		return s.refcountDB.Close()
+		err = s.refcountDB.Close()
 	}
-	return nil
+	if s.lock != nil {
+		s.lock.Close()
+	}
+	for _, lock := range s.mirrorLocks {
+		lock.Close()
+	}
+	return err
 }
 
+// tempFile creates a temporary file for data renamed into the data directory once written
 func (s *Storage) tempFile() (*os.File, error) {
 	return os.CreateTemp(s.tempDir, "tmp-*")
 }
 
+// copyLimited copies data to w, failing with ErrEntityTooLarge
+// as soon as more than limit bytes are read
+func copyLimited(w io.Writer, data io.Reader, limit int64) (int64, error) {
+	if limit < 0 {
+		return 0, ErrEntityTooLarge
+	}
+	n, err := io.Copy(w, io.LimitReader(data, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, ErrEntityTooLarge
+	}
+	return n, nil
+}
+
+// contextReader returns a reader of r that fails with the error of ctx once it is done,
+// so copies on behalf of aborted requests stop promptly
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx.Done() == nil {
+		return r
+	}
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+// ctxReader is a reader that checks its context before every read
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 // sanitizeBucketName validates and sanitizes bucket name
 func sanitizeBucketName(bucket string) error {
 	if bucket == "" || bucket == "." || bucket == ".." {
@@ -123,6 +320,9 @@ func sanitizeObjectKey(key string) error {
 	if key == "" || key == "." || key == ".." {
 		return ErrInvalidObjectKey
 	}
+	if err := s3key.Validate(key); err != nil {
+		return ErrInvalidObjectKey
+	}
 	// Check for path traversal attempts
 	if strings.Contains(key, "..") {
 		return ErrInvalidObjectKey
@@ -153,6 +353,9 @@ func (s *Storage) safePath(bucket, key string) (string, error) {
 
 	// Object path is now a directory
 	objectPath := filepath.Join(bucketPath, key)
+	if s.shardFanout > 0 {
+		objectPath = filepath.Join(bucketPath, s.shardedKeyPath(key))
+	}
 
 	// Verify the path is within the bucket
 	absObjectPath, err := filepath.Abs(objectPath)
@@ -187,11 +390,54 @@ type objectMetadata struct {
 	// IsDir indicates if the original key had a trailing slash (S3 directory object)
 	// When true, the key should be reconstructed with a trailing slash
 	IsDir bool
+	// Compression is the codec and frame layout version the content-addressed data is compressed with,
+	// such as zstd/1, empty if it is stored as is
+	// Digest is then the digest of the compressed data, while ETag stays that of the object
+	Compression string
+	// Size is the size of the object before compression, set only for compressed objects
+	Size int64
 }
 
 // uploadMetadata represents multipart upload metadata
 type uploadMetadata struct {
 	Metadata Metadata
+	// ChecksumAlgorithm is the checksum algorithm requested when the upload was initiated
+	ChecksumAlgorithm string
+	// Initiator is the access key that initiated the upload, empty for anonymous uploads
+	Initiator string
+}
+
+// bucketMetadata represents bucket-level configuration
+type bucketMetadata struct {
+	// CreationDate is when the bucket was created
+	// Zero for buckets created before it was recorded, until the layout migration fills it in
+	CreationDate time.Time
+	// ObjectOwnership is the object ownership setting from the bucket ownership controls
+	// Empty means no ownership controls are configured
+	ObjectOwnership string
+	// PublicAccessBlock is the public access block configuration
+	// Nil means no public access block is configured
+	PublicAccessBlock *PublicAccessBlock
+	// Website is the static website hosting configuration
+	// Nil means website hosting is disabled
+	Website *WebsiteConfiguration
+	// AccelerateStatus is the transfer acceleration status ("Enabled" or "Suspended")
+	// Empty means acceleration has never been configured
+	AccelerateStatus string
+	// RequestPayer is who pays for requests ("BucketOwner" or "Requester")
+	// Empty means the default, BucketOwner
+	RequestPayer string
+	// WORMRetention is how long objects are protected from overwrites and deletes after being written
+	// Zero means the bucket is not in WORM mode
+	WORMRetention time.Duration
+	// Frozen rejects all writes and deletes of objects in the bucket
+	Frozen bool
+	// DefaultTTL is how long after being written objects expire and are deleted by ExpireObjects
+	// Zero means objects never expire
+	DefaultTTL time.Duration
+	// TrashRetention is how long deleted objects are kept in the trash of the bucket before being purged
+	// Zero means deletes remove objects at once
+	TrashRetention time.Duration
 }
 
 func metadataEqual(a, b Metadata) bool {
@@ -201,9 +447,15 @@ func metadataEqual(a, b Metadata) bool {
 	if a.ContentDisposition != b.ContentDisposition {
 		return false
 	}
+	if a.ContentEncoding != b.ContentEncoding {
+		return false
+	}
 	if a.ContentType != b.ContentType {
 		return false
 	}
+	if a.WebsiteRedirectLocation != b.WebsiteRedirectLocation {
+		return false
+	}
 	if len(a.XAmzMeta) != len(b.XAmzMeta) {
 		return false
 	}
@@ -212,19 +464,40 @@ func metadataEqual(a, b Metadata) bool {
 			return false
 		}
 	}
+	if len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for key, valA := range a.Tags {
+		if valB, ok := b.Tags[key]; !ok || valA != valB {
+			return false
+		}
+	}
 	return true
 }
 
 // saveObjectMetadata saves object metadata
+// The metadata is written next to path and renamed over it, so concurrent readers and listings
+// see either the old or the new object and never a partially written one
 func saveObjectMetadata(path string, metadata *objectMetadata) error {
-	file, err := os.Create(path)
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
-	encoder := gob.NewEncoder(file)
-	return encoder.Encode(metadata)
+	// Keep the permissions os.Create gave meta files
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(metadata); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
 }
 
 // loadObjectMetadata loads object metadata
@@ -277,6 +550,49 @@ func loadUploadMetadata(path string) (*uploadMetadata, error) {
 	return &metadata, nil
 }
 
+// saveBucketMetadata saves bucket metadata
+// Like object metadata it is written next to path and renamed over it, so a crash or a concurrent
+// reader never sees a truncated configuration
+func saveBucketMetadata(path string, metadata *bucketMetadata) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(metadata); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadBucketMetadata loads bucket metadata
+func loadBucketMetadata(path string) (*bucketMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var metadata bucketMetadata
+	decoder := gob.NewDecoder(file)
+	if err := decoder.Decode(&metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
 // cleanupEmptyDirs removes empty parent directories up to but not including the stopDir
 // This function is best-effort and will not fail the operation if cleanup fails
 func (s *Storage) cleanupEmptyDirs(dir, stopDir string) {
@@ -299,7 +615,7 @@ func (s *Storage) cleanupEmptyDirs(dir, stopDir string) {
 
 		// Ensure current is within stopDir using filepath.Rel
 		rel, err := filepath.Rel(absStopDir, current)
-		if err != nil || strings.HasPrefix(rel, "..") {
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
 			// Current is not within stopDir, stop
 			break
 		}
@@ -339,32 +655,44 @@ func (s *Storage) objectPath(digest string) (string, error) {
 
 // incrementRefCount increments the reference count for a content-addressed object using BoltDB
 func (s *Storage) incrementRefCount(digest string) error {
+	if s.refcountDB == nil {
+		return ErrReadReplica
+	}
 	return s.refcountDB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(refcountBucket)
-		if b == nil {
-			return fmt.Errorf("refcount bucket not found")
-		}
+		return incrementRefCountTx(tx, digest)
+	})
+}
 
-		key := []byte(digest)
+// incrementRefCountTx increments the reference count for a content-addressed object within tx
+func incrementRefCountTx(tx *bolt.Tx, digest string) error {
+	b := tx.Bucket(refcountBucket)
+	if b == nil {
+		return fmt.Errorf("refcount bucket not found")
+	}
 
-		// Get current count
-		var count uint64 = 0
-		if data := b.Get(key); data != nil {
-			count = binary.BigEndian.Uint64(data)
-		}
+	key := []byte(digest)
 
-		// Increment
-		count++
+	// Get current count
+	var count uint64 = 0
+	if data := b.Get(key); data != nil {
+		count = binary.BigEndian.Uint64(data)
+	}
 
-		// Store back
-		buf := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf, count)
-		return b.Put(key, buf)
-	})
+	// Increment
+	count++
+
+	// Store back
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, count)
+	return b.Put(key, buf)
 }
 
 // decrementRefCount decrements the reference count and deletes the object if count reaches 0
 func (s *Storage) decrementRefCount(digest string) error {
+	if s.refcountDB == nil {
+		return ErrReadReplica
+	}
+
 	var shouldDelete bool
 
 	err := s.refcountDB.Update(func(tx *bolt.Tx) error {
@@ -387,8 +715,12 @@ func (s *Storage) decrementRefCount(digest string) error {
 		count := binary.BigEndian.Uint64(data)
 
 		if count <= 1 {
-			// Delete the refcount entry
+			// Delete the refcount entry, along with the location of packed content
+			// in the same transaction so that a concurrent write of the content never references it
 			shouldDelete = true
+			if err := tx.Bucket(packIndexBucket).Delete(key); err != nil {
+				return err
+			}
 			return b.Delete(key)
 		}
 
@@ -416,15 +748,17 @@ func (s *Storage) deleteContentAddressedObject(digest string) error {
 	if err != nil {
 		return err
 	}
+	s.files.evict(objPath)
 	err = os.Remove(objPath)
 	// Ignore "file not found" errors - the desired state is achieved
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return nil
+	return s.removeMirrors(digest)
 }
 
-// storeContentAddressedObject stores data in the .objects directory using SHA256 digest
+// storeContentAddressedObject stores data in the .objects directory using SHA256 digest,
+// or in a pack segment if it is small enough for packing
 // Returns nil error on success
 // If the object already exists, it increments the reference count
 func (s *Storage) storeContentAddressedObject(srcPath string, digest string) error {
@@ -433,18 +767,40 @@ func (s *Storage) storeContentAddressedObject(srcPath string, digest string) err
 		return err
 	}
 
+	if packed, err := s.referencePacked(digest); err != nil || packed {
+		return err
+	}
+
 	// Check if object already exists
 	if _, err := os.Stat(objPath); err == nil {
 		// Object already exists, just increment refcount
+		if err := s.writeMirrors(objPath, digest); err != nil {
+			return err
+		}
 		return s.incrementRefCount(digest)
 	}
 
+	if s.packThreshold > 0 {
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+		if info.Size() <= s.packThreshold {
+			return s.storePacked(srcPath, digest, info.Size())
+		}
+	}
+
+	// Mirrors are written first, so an object is never stored without its copies
+	if err := s.writeMirrors(srcPath, digest); err != nil {
+		return err
+	}
+
 	// Create parent directory
 	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
 		return err
 	}
 
-	err = os.Rename(srcPath, objPath)
+	err = renameFile(srcPath, objPath)
 	if err != nil {
 		return err
 	}
@@ -453,11 +809,46 @@ func (s *Storage) storeContentAddressedObject(srcPath string, digest string) err
 	return s.incrementRefCount(digest)
 }
 
-// getContentAddressedObject opens a content-addressed object for reading
-func (s *Storage) getContentAddressedObject(digest string) (*os.File, error) {
+// contentSize returns the size of a content-addressed object, restoring it from a mirror if it is missing
+func (s *Storage) contentSize(digest string) (int64, error) {
+	loc, err := s.packedLocation(digest)
+	if err != nil {
+		return 0, err
+	}
+	if loc != nil {
+		return loc.Size, nil
+	}
+	objPath, err := s.objectPath(digest)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(objPath)
+	if err != nil && s.heal(digest) == nil {
+		info, err = os.Stat(objPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// getContentAddressedObject opens a content-addressed object for reading, restoring it from a mirror if it is missing
+// The handle is shared with concurrent readers
+func (s *Storage) getContentAddressedObject(digest string) (*sharedFileReader, error) {
+	loc, err := s.packedLocation(digest)
+	if err != nil {
+		return nil, err
+	}
+	if loc != nil {
+		return s.openPacked(digest, loc)
+	}
 	objPath, err := s.objectPath(digest)
 	if err != nil {
 		return nil, err
 	}
-	return os.Open(objPath)
+	file, err := s.files.open(objPath)
+	if err != nil && s.heal(digest) == nil {
+		return s.files.open(objPath)
+	}
+	return file, err
 }
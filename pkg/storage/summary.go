@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// PrefixSummary is the number and total size of the objects under a prefix
+type PrefixSummary struct {
+	Objects int64
+	Size    int64
+	// LastModified is the modification time of the most recently written object, zero if there is none
+	LastModified time.Time
+}
+
+// SummarizePrefix counts the objects of a bucket whose key starts with prefix and adds up their sizes
+// Only the subtrees the prefix can match are walked and no object is held in memory,
+// so a summary costs one walk of the prefix rather than paginating a listing of it
+// Once ctx is done it stops with its error
+func (s *Storage) SummarizePrefix(ctx context.Context, bucket, prefix string) (*PrefixSummary, error) {
+	if !s.BucketExists(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	bucketPath, err := s.safePath(bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	want := func(key string, subtree bool) bool {
+		if !subtree {
+			return strings.HasPrefix(key, prefix)
+		}
+		return strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key)
+	}
+
+	summary := &PrefixSummary{}
+	err = s.walkObjects(bucketPath, "", want, func(objectKey string, metadata *objectMetadata, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(objectKey, prefix) {
+			return nil
+		}
+		size, err := s.objectSize(objectKey, metadata)
+		if err != nil {
+			return err
+		}
+		summary.Objects++
+		summary.Size += size
+		if info.ModTime().After(summary.LastModified) {
+			summary.LastModified = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSummarizePrefix(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	// Mix inline, content-addressed, empty and folder objects
+	objects := map[string]int{
+		"data/":         0,
+		"data/a":        10,
+		"data/b/c":      10000,
+		"data/b/d":      0,
+		"data-other":    5,
+		"other/data/e":  7,
+		"dat":           3,
+		"data/b/nested": 20000,
+	}
+	for key, size := range objects {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(strings.Repeat("x", size)), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		prefix  string
+		objects int64
+		size    int64
+	}{
+		{"", 8, 30025},
+		{"data/", 5, 30010},
+		{"data", 6, 30015},
+		{"data/b/", 3, 30000},
+		{"data/b/c", 1, 10000},
+		{"missing/", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			summary, err := store.SummarizePrefix(context.Background(), bucketName, tt.prefix)
+			if err != nil {
+				t.Fatalf("SummarizePrefix failed: %v", err)
+			}
+			if summary.Objects != tt.objects || summary.Size != tt.size {
+				t.Errorf("Expected %d objects of %d bytes, got %d objects of %d bytes", tt.objects, tt.size, summary.Objects, summary.Size)
+			}
+			if (summary.Objects == 0) != summary.LastModified.IsZero() {
+				t.Errorf("Unexpected last modification time %v for %d objects", summary.LastModified, summary.Objects)
+			}
+		})
+	}
+
+	if _, err := store.SummarizePrefix(context.Background(), "missing-bucket", ""); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
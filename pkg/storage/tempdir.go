@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WithTempDir sets the directory of temporary files, .temp in the data directory by default
+// Uploads are written there before being renamed into place, so it should be on the filesystem of the data directory
+func WithTempDir(dir string) Option {
+	return func(s *Storage) {
+		s.tempDir = dir
+	}
+}
+
+// initTempDirs creates the directories of temporary files
+// Content is renamed into .objects once written, so when .objects is on another filesystem,
+// such as a mount of its own, the temporary files of content are written in .objects instead
+func (s *Storage) initTempDirs() error {
+	if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+		return err
+	}
+	s.objectsTempDir = s.tempDir
+	if !sameFilesystem(s.tempDir, s.objectsDir) {
+		s.objectsTempDir = filepath.Join(s.objectsDir, tempDir)
+	}
+	return os.MkdirAll(s.objectsTempDir, 0755)
+}
+
+// contentTempFile creates a temporary file for content stored in .objects once written
+func (s *Storage) contentTempFile() (*os.File, error) {
+	return os.CreateTemp(s.objectsTempDir, "tmp-*")
+}
+
+// TempFile creates a temporary file in the temporary directory for data spooled before it is stored,
+// failing with ErrInsufficientStorage like writes when the data directory is short of free space
+func (s *Storage) TempFile(pattern string) (*os.File, error) {
+	if err := s.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(s.tempDir, pattern)
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithTempDir(t *testing.T) {
+	dataDir := t.TempDir()
+	temp := filepath.Join(t.TempDir(), "uploads")
+	store, err := NewStorage(dataDir, WithTempDir(temp))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	if store.objectsTempDir != temp {
+		t.Errorf("Expected content to be written in %s, got %s", temp, store.objectsTempDir)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, tempDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary directory in the data directory, got %v", err)
+	}
+
+	if err := store.CreateBucket("bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	content := bytes.Repeat([]byte("data"), inlineThreshold)
+	if _, err := store.PutObject(context.Background(), "bucket", "object", bytes.NewReader(content), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	reader, _, err := store.GetObject("bucket", "object")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, content) {
+		t.Errorf("Expected the object to be stored, got %d bytes", len(data))
+	}
+	if entries, err := os.ReadDir(temp); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no temporary files to be left, got %v (%v)", entries, err)
+	}
+
+	// Data spooled by callers goes to the same directory
+	tmp, err := store.TempFile("spool-*")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if filepath.Dir(tmp.Name()) != temp {
+		t.Errorf("Expected the temporary file in %s, got %s", temp, tmp.Name())
+	}
+}
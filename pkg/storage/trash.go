@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDir holds the objects deleted from buckets with a trash, one directory per bucket
+// Every trashed object is a directory named by its trash ID holding the meta file of the object
+// and a trashEntry, so its data stays referenced until the trash entry is purged
+const trashDir = ".trash"
+
+// trashEntryFile is the file of a trash entry recording where the object came from
+const trashEntryFile = "entry"
+
+// trashEntry records the key of a trashed object and when it was deleted
+type trashEntry struct {
+	Key       string
+	DeletedAt time.Time
+	// PurgeAt is when the object is removed for good, from the trash retention at the time of the delete
+	PurgeAt time.Time
+}
+
+// TrashedObject is an object moved to the trash of its bucket by a delete
+type TrashedObject struct {
+	// ID identifies the object in the trash, several deletes of a key leave several entries
+	ID   string
+	Key  string
+	Size int64
+	ETag string
+	// ModTime is when the object was written
+	ModTime   time.Time
+	DeletedAt time.Time
+	// PurgeAt is when PurgeTrash removes the object for good
+	PurgeAt time.Time
+}
+
+// SetBucketTrashRetention sets how long deleted objects of a bucket are kept in its trash, 0 disables the trash
+// Objects already in the trash keep the retention they were deleted with
+// This is an s3d extension, objects in the trash can be listed with ListTrash and restored with RestoreObject
+func (s *Storage) SetBucketTrashRetention(bucket string, retention time.Duration) error {
+	return s.updateBucketMetadata(bucket, func(metadata *bucketMetadata) {
+		metadata.TrashRetention = retention
+	})
+}
+
+// GetBucketTrashRetention returns how long deleted objects of a bucket are kept in its trash, 0 if it has none
+func (s *Storage) GetBucketTrashRetention(bucket string) (time.Duration, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return metadata.TrashRetention, nil
+}
+
+// bucketTrashPath returns the trash directory of a bucket
+func (s *Storage) bucketTrashPath(bucket string) (string, error) {
+	if err := sanitizeBucketName(bucket); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.basePath, trashDir, bucket), nil
+}
+
+// trashEntryPath returns the directory of the trash entry id of a bucket
+func (s *Storage) trashEntryPath(bucket, id string) (string, error) {
+	trashPath, err := s.bucketTrashPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", ErrTrashEntryNotFound
+	}
+	return filepath.Join(trashPath, id), nil
+}
+
+// newTrashID returns a trash ID sorting in the order of deletion
+func newTrashID(deletedAt time.Time) (string, error) {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%019d-%s", deletedAt.UnixNano(), hex.EncodeToString(suffix[:])), nil
+}
+
+// moveToTrash moves the meta file of an object, and with it the reference to its data, into the trash of its bucket
+func (s *Storage) moveToTrash(bucket, key, metaPath string, retention time.Duration) error {
+	deletedAt := time.Now().UTC()
+	id, err := newTrashID(deletedAt)
+	if err != nil {
+		return err
+	}
+	entryPath, err := s.trashEntryPath(bucket, id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(entryPath, 0755); err != nil {
+		return err
+	}
+
+	entry := &trashEntry{
+		Key:       key,
+		DeletedAt: deletedAt,
+		PurgeAt:   deletedAt.Add(retention),
+	}
+	if err := saveTrashEntry(filepath.Join(entryPath, trashEntryFile), entry); err != nil {
+		os.RemoveAll(entryPath)
+		return err
+	}
+	if err := os.Rename(metaPath, filepath.Join(entryPath, metaFile)); err != nil {
+		os.RemoveAll(entryPath)
+		if os.IsNotExist(err) {
+			return ErrObjectNotFound
+		}
+		return err
+	}
+	s.metadata.invalidate(metaPath)
+	return nil
+}
+
+// ListTrash lists the objects in the trash of a bucket whose key starts with prefix,
+// ordered by key and, for keys deleted several times, by deletion
+func (s *Storage) ListTrash(bucket, prefix string) ([]TrashedObject, error) {
+	if !s.BucketExists(bucket) {
+		return nil, ErrBucketNotFound
+	}
+	trashPath, err := s.bucketTrashPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(trashPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var objects []TrashedObject
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		entryPath := filepath.Join(trashPath, dirEntry.Name())
+		entry, err := loadTrashEntry(filepath.Join(entryPath, trashEntryFile))
+		if err != nil || entry == nil {
+			// Entries being written or purged
+			continue
+		}
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+
+		metaPath := filepath.Join(entryPath, metaFile)
+		info, err := os.Stat(metaPath)
+		if err != nil {
+			continue
+		}
+		metadata, err := loadObjectMetadata(metaPath)
+		if err != nil || metadata == nil {
+			continue
+		}
+		size, err := s.objectSize(entry.Key, metadata)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, TrashedObject{
+			ID:        dirEntry.Name(),
+			Key:       entry.Key,
+			Size:      size,
+			ETag:      metadata.ETag,
+			ModTime:   info.ModTime(),
+			DeletedAt: entry.DeletedAt,
+			PurgeAt:   entry.PurgeAt,
+		})
+	}
+
+	// IDs sort in the order of deletion
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Key != objects[j].Key {
+			return objects[i].Key < objects[j].Key
+		}
+		return objects[i].ID < objects[j].ID
+	})
+	return objects, nil
+}
+
+// RestoreObject moves the object of the trash entry id back to its key, returning the key
+// An object written to the key since the delete is never overwritten, the restore fails with ErrObjectAlreadyExists
+func (s *Storage) RestoreObject(bucket, id string) (string, error) {
+	key, err := s.restoreObject(bucket, id)
+	if err != nil {
+		return "", err
+	}
+	if reader, info, err := s.GetObject(bucket, key); err == nil {
+		reader.Close()
+		s.publishWrite(EventObjectCreated, bucket, info, "", "")
+	}
+	return key, nil
+}
+
+func (s *Storage) restoreObject(bucket, id string) (string, error) {
+	if err := s.checkFrozen(bucket); err != nil {
+		return "", err
+	}
+	entryPath, err := s.trashEntryPath(bucket, id)
+	if err != nil {
+		return "", err
+	}
+	entry, err := loadTrashEntry(filepath.Join(entryPath, trashEntryFile))
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", ErrTrashEntryNotFound
+	}
+
+	objectDir, err := s.safePath(bucket, entry.Key)
+	if err != nil {
+		return "", err
+	}
+	metaPath := filepath.Join(objectDir, metaFile)
+	unlock := s.commits.lock(metaPath)
+	defer unlock()
+
+	if _, err := os.Stat(metaPath); err == nil {
+		return "", ErrObjectAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filepath.Join(entryPath, metaFile), metaPath); err != nil {
+		if os.IsNotExist(err) {
+			// Restored or purged concurrently
+			return "", ErrTrashEntryNotFound
+		}
+		return "", err
+	}
+	s.metadata.invalidate(metaPath)
+
+	if err := os.RemoveAll(entryPath); err != nil {
+		return "", err
+	}
+	return entry.Key, nil
+}
+
+// PurgeTrash removes the objects of all trashes whose retention has passed at now for good,
+// returning the number of objects removed
+// Once ctx is done it stops with its error
+func (s *Storage) PurgeTrash(ctx context.Context, now time.Time) (int, error) {
+	buckets, err := os.ReadDir(filepath.Join(s.basePath, trashDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, bucket := range buckets {
+		if !bucket.IsDir() {
+			continue
+		}
+		n, err := s.purgeBucketTrash(ctx, bucket.Name(), func(entry *trashEntry) bool {
+			return !entry.PurgeAt.After(now)
+		})
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purgeBucketTrash removes the trash entries of a bucket for which purge reports true
+func (s *Storage) purgeBucketTrash(ctx context.Context, bucket string, purge func(entry *trashEntry) bool) (int, error) {
+	trashPath, err := s.bucketTrashPath(bucket)
+	if err != nil {
+		return 0, err
+	}
+	dirEntries, err := os.ReadDir(trashPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, dirEntry := range dirEntries {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		entryPath := filepath.Join(trashPath, dirEntry.Name())
+		entry, err := loadTrashEntry(filepath.Join(entryPath, trashEntryFile))
+		if err != nil || entry == nil || !purge(entry) {
+			continue
+		}
+
+		metaPath := filepath.Join(entryPath, metaFile)
+		metadata, err := loadObjectMetadata(metaPath)
+		if err != nil {
+			return purged, err
+		}
+		// Remove the meta file first, a restore racing the purge either got it or finds nothing
+		if err := os.Remove(metaPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return purged, err
+		}
+		if metadata != nil && metadata.Digest != "" {
+			// Like DeleteObject, a missing reference count must not fail the purge
+			s.decrementRefCount(metadata.Digest)
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	// Drop the directory of an emptied trash, left for buckets without a trash anymore
+	os.Remove(trashPath)
+	return purged, nil
+}
+
+// emptyTrash removes every object in the trash of a bucket for good
+func (s *Storage) emptyTrash(bucket string) error {
+	if _, err := s.purgeBucketTrash(context.Background(), bucket, func(*trashEntry) bool {
+		return true
+	}); err != nil {
+		return err
+	}
+	// Along with entries left unreadable by a crash
+	trashPath, err := s.bucketTrashPath(bucket)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(trashPath)
+}
+
+// saveTrashEntry saves a trash entry
+func saveTrashEntry(path string, entry *trashEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return gob.NewEncoder(file).Encode(entry)
+}
+
+// loadTrashEntry loads a trash entry, nil if it does not exist
+func loadTrashEntry(path string) (*trashEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entry trashEntry
+	if err := gob.NewDecoder(file).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
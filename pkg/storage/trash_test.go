@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	const day = 24 * time.Hour
+	bucketName := "test-bucket"
+	if err := store.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if retention, err := store.GetBucketTrashRetention(bucketName); err != nil || retention != 0 {
+		t.Fatalf("Expected no trash on a new bucket, got %v (%v)", retention, err)
+	}
+	if err := store.SetBucketTrashRetention(bucketName, 7*day); err != nil {
+		t.Fatalf("SetBucketTrashRetention failed: %v", err)
+	}
+
+	// Large enough to be stored by content, so the reference count keeps it alive
+	large := strings.Repeat("large data ", inlineThreshold)
+	put := func(key, data string) *ObjectInfo {
+		info, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(data), Metadata{}, "")
+		if err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+		return info
+	}
+	read := func(key string) (string, error) {
+		reader, _, err := store.GetObject(bucketName, key)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		return string(data), err
+	}
+
+	first := put("dir/large", large)
+	put("dir/large", "second version")
+	put("small", "small")
+
+	// Every delete leaves an entry, even of the same key
+	if err := store.DeleteObject(bucketName, "dir/large"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	put("dir/large", large)
+	if err := store.DeleteObject(bucketName, "dir/large"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if errs, err := store.DeleteObjects(bucketName, []string{"small"}); err != nil || errs[0] != nil {
+		t.Fatalf("DeleteObjects failed: %v %v", errs, err)
+	}
+	if _, err := read("dir/large"); err != ErrObjectNotFound {
+		t.Errorf("Expected the deleted object to be gone, got %v", err)
+	}
+	if objects, _, err := store.ListObjects(bucketName, "", "", "", 0); err != nil || len(objects) != 0 {
+		t.Errorf("Expected trashed objects not to be listed, got %v (%v)", objects, err)
+	}
+
+	trashed, err := store.ListTrash(bucketName, "")
+	if err != nil {
+		t.Fatalf("ListTrash failed: %v", err)
+	}
+	if len(trashed) != 3 {
+		t.Fatalf("Expected 3 trashed objects, got %+v", trashed)
+	}
+	if trashed[0].Key != "dir/large" || trashed[0].Size != int64(len("second version")) ||
+		trashed[1].Key != "dir/large" || trashed[1].Size != int64(len(large)) || trashed[1].ETag != first.ETag ||
+		trashed[2].Key != "small" {
+		t.Errorf("Unexpected trashed objects %+v", trashed)
+	}
+	for _, obj := range trashed {
+		if obj.PurgeAt.Sub(obj.DeletedAt) != 7*day {
+			t.Errorf("Expected %s to be purged after the retention, got %v", obj.Key, obj.PurgeAt.Sub(obj.DeletedAt))
+		}
+	}
+	if filtered, err := store.ListTrash(bucketName, "dir/"); err != nil || len(filtered) != 2 {
+		t.Errorf("Expected 2 trashed objects under dir/, got %d (%v)", len(filtered), err)
+	}
+
+	// Restoring brings back the data and the modification time of the object
+	key, err := store.RestoreObject(bucketName, trashed[1].ID)
+	if err != nil || key != "dir/large" {
+		t.Fatalf("RestoreObject failed: %q %v", key, err)
+	}
+	if data, err := read("dir/large"); err != nil || data != large {
+		t.Errorf("Expected the restored data, got %d bytes (%v)", len(data), err)
+	}
+	if objects, _, err := store.ListObjects(bucketName, "dir/", "", "", 0); err != nil || len(objects) != 1 || !objects[0].ModTime.Equal(trashed[1].ModTime) {
+		t.Errorf("Expected the restored object to keep its modification time, got %+v (%v)", objects, err)
+	}
+	if _, err := store.RestoreObject(bucketName, trashed[1].ID); err != ErrTrashEntryNotFound {
+		t.Errorf("Expected ErrTrashEntryNotFound restoring twice, got %v", err)
+	}
+	// The key is taken by the restored object now
+	if _, err := store.RestoreObject(bucketName, trashed[0].ID); err != ErrObjectAlreadyExists {
+		t.Errorf("Expected ErrObjectAlreadyExists, got %v", err)
+	}
+	for _, id := range []string{"", "missing", "../" + trashed[0].ID, "."} {
+		if _, err := store.RestoreObject(bucketName, id); err != ErrTrashEntryNotFound {
+			t.Errorf("Expected ErrTrashEntryNotFound for %q, got %v", id, err)
+		}
+	}
+
+	// Nothing is purged before its retention has passed
+	if n, err := store.PurgeTrash(context.Background(), time.Now().Add(day)); err != nil || n != 0 {
+		t.Errorf("Expected nothing to be purged yet, got %d (%v)", n, err)
+	}
+	if n, err := store.PurgeTrash(context.Background(), time.Now().Add(8*day)); err != nil || n != 2 {
+		t.Errorf("Expected 2 purged objects, got %d (%v)", n, err)
+	}
+	if trashed, err := store.ListTrash(bucketName, ""); err != nil || len(trashed) != 0 {
+		t.Errorf("Expected an empty trash, got %+v (%v)", trashed, err)
+	}
+	// The restored object still holds a reference to the shared content
+	if data, err := read("dir/large"); err != nil || data != large {
+		t.Errorf("Expected the restored object to survive the purge, got %d bytes (%v)", len(data), err)
+	}
+
+	t.Run("PurgeReleasesContent", func(t *testing.T) {
+		metadata, err := loadObjectMetadata(filepath.Join(tmpDir, bucketName, "dir/large", metaFile))
+		if err != nil || metadata == nil || metadata.Digest == "" {
+			t.Fatalf("Expected the object to be stored by content, got %+v (%v)", metadata, err)
+		}
+		path, err := store.objectPath(metadata.Digest)
+		if err != nil {
+			t.Fatalf("objectPath failed: %v", err)
+		}
+		if err := store.DeleteObject(bucketName, "dir/large"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		trashed, err := store.ListTrash(bucketName, "")
+		if err != nil || len(trashed) != 1 {
+			t.Fatalf("Expected 1 trashed object, got %+v (%v)", trashed, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Expected the content of the trashed object to be kept, got %v", err)
+		}
+		if n, err := store.PurgeTrash(context.Background(), trashed[0].PurgeAt); err != nil || n != 1 {
+			t.Fatalf("Expected 1 purged object, got %d (%v)", n, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected the purge to release the content, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		put("kept", "data")
+		if err := store.DeleteObject(bucketName, "kept"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		if err := store.SetBucketTrashRetention(bucketName, 0); err != nil {
+			t.Fatalf("SetBucketTrashRetention failed: %v", err)
+		}
+		put("gone", "data")
+		if err := store.DeleteObject(bucketName, "gone"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		trashed, err := store.ListTrash(bucketName, "")
+		if err != nil || len(trashed) != 1 || trashed[0].Key != "kept" {
+			t.Fatalf("Expected only the object deleted with the trash enabled, got %+v (%v)", trashed, err)
+		}
+		// Entries keep the retention they were deleted with
+		if _, err := store.RestoreObject(bucketName, trashed[0].ID); err != nil {
+			t.Errorf("RestoreObject failed: %v", err)
+		}
+	})
+
+	t.Run("Frozen", func(t *testing.T) {
+		if err := store.SetBucketTrashRetention(bucketName, day); err != nil {
+			t.Fatalf("SetBucketTrashRetention failed: %v", err)
+		}
+		if err := store.DeleteObject(bucketName, "kept"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		trashed, err := store.ListTrash(bucketName, "kept")
+		if err != nil || len(trashed) != 1 {
+			t.Fatalf("Expected 1 trashed object, got %+v (%v)", trashed, err)
+		}
+		if err := store.FreezeBucket(bucketName, true); err != nil {
+			t.Fatalf("FreezeBucket failed: %v", err)
+		}
+		if _, err := store.RestoreObject(bucketName, trashed[0].ID); err != ErrBucketFrozen {
+			t.Errorf("Expected ErrBucketFrozen, got %v", err)
+		}
+		if err := store.FreezeBucket(bucketName, false); err != nil {
+			t.Fatalf("FreezeBucket failed: %v", err)
+		}
+	})
+
+	t.Run("DeleteBucket", func(t *testing.T) {
+		if err := store.DeleteBucket(bucketName); err != nil {
+			t.Fatalf("DeleteBucket failed: %v", err)
+		}
+		if err := store.CreateBucket(bucketName); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if trashed, err := store.ListTrash(bucketName, ""); err != nil || len(trashed) != 0 {
+			t.Errorf("Expected a recreated bucket to start with an empty trash, got %+v (%v)", trashed, err)
+		}
+	})
+}
@@ -15,14 +15,62 @@ type ObjectInfo struct {
 type Metadata struct {
 	CacheControl       string
 	ContentDisposition string
+	ContentEncoding    string
 	ContentType        string
 	XAmzMeta           map[string]string
+	// WebsiteRedirectLocation redirects website requests for the object to another object or URL
+	WebsiteRedirectLocation string
+	// Tags is the tag set of the object
+	Tags map[string]string
 }
 
 // BucketInfo contains metadata about a bucket
 type BucketInfo struct {
-	Name    string
-	ModTime time.Time
+	Name         string
+	CreationDate time.Time
+}
+
+// PublicAccessBlock contains the public access block configuration of a bucket
+type PublicAccessBlock struct {
+	BlockPublicAcls       bool
+	IgnorePublicAcls      bool
+	BlockPublicPolicy     bool
+	RestrictPublicBuckets bool
+}
+
+// WebsiteConfiguration contains the static website hosting configuration of a bucket
+type WebsiteConfiguration struct {
+	// IndexDocumentSuffix is appended to requests for directories, e.g. "index.html"
+	IndexDocumentSuffix string
+	// ErrorDocumentKey is the object returned when an error occurs
+	ErrorDocumentKey string
+	// RedirectAllRequestsTo redirects every request to another host when set
+	RedirectAllRequestsTo *WebsiteRedirect
+	// RoutingRules are evaluated in order, the first matching rule wins
+	RoutingRules []WebsiteRoutingRule
+}
+
+// WebsiteRedirect describes where a website request is redirected to
+type WebsiteRedirect struct {
+	HostName string
+	Protocol string
+	// The following fields are only used by routing rules
+	HttpRedirectCode     string
+	ReplaceKeyPrefixWith string
+	ReplaceKeyWith       string
+}
+
+// WebsiteRoutingRule redirects website requests matching Condition
+type WebsiteRoutingRule struct {
+	// Condition is nil when the rule applies to every request
+	Condition *WebsiteRoutingCondition
+	Redirect  WebsiteRedirect
+}
+
+// WebsiteRoutingCondition describes which website requests a routing rule applies to
+type WebsiteRoutingCondition struct {
+	KeyPrefixEquals             string
+	HttpErrorCodeReturnedEquals string
 }
 
 // Multipart represents a part of a multipart upload
@@ -34,10 +82,13 @@ type Multipart struct {
 
 // Part represents a stored part of list parts
 type Part struct {
-	PartNumber int
-	ETag       string
-	Size       int64
-	ModTime    time.Time
+	PartNumber     int
+	ETag           string
+	ChecksumSHA256 string
+	// ChecksumCRC32 is only computed for uploads initiated with the CRC32 checksum algorithm
+	ChecksumCRC32 string
+	Size          int64
+	ModTime       time.Time
 }
 
 // MultipartUpload represents an in-progress multipart upload
@@ -46,4 +97,8 @@ type MultipartUpload struct {
 	Bucket   string
 	Key      string
 	ModTime  time.Time
+	// ChecksumAlgorithm is the algorithm every part must be uploaded with, if any
+	ChecksumAlgorithm string
+	// Initiator is the access key that initiated the upload, empty for anonymous uploads
+	Initiator string
 }
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CreateWORMBucket creates a bucket in write-once-read-many mode
+// Objects of the bucket cannot be overwritten or deleted until retention has passed since they were written,
+// regardless of any object lock settings. The mode cannot be changed after creation
+func (s *Storage) CreateWORMBucket(bucket string, retention time.Duration) error {
+	return s.createBucket(bucket, &bucketMetadata{WORMRetention: retention})
+}
+
+// GetBucketWORMRetention returns the WORM retention period of a bucket, 0 if the bucket is not in WORM mode
+func (s *Storage) GetBucketWORMRetention(bucket string) (time.Duration, error) {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return metadata.WORMRetention, nil
+}
+
+// checkWritable returns ErrBucketFrozen if the bucket is frozen, or ErrObjectLocked if key exists
+// in a WORM bucket and is still within its retention period
+func (s *Storage) checkWritable(bucket, key string) error {
+	metadata, err := s.getBucketMetadata(bucket)
+	if err != nil {
+		return err
+	}
+	if metadata.Frozen {
+		return ErrBucketFrozen
+	}
+	if metadata.WORMRetention <= 0 {
+		return nil
+	}
+
+	objectDir, err := s.safePath(bucket, key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filepath.Join(objectDir, metaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if time.Since(info.ModTime()) < metadata.WORMRetention {
+		return ErrObjectLocked
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWORMBucket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-worm"
+	if err := store.CreateWORMBucket(bucketName, time.Hour); err != nil {
+		t.Fatalf("CreateWORMBucket failed: %v", err)
+	}
+	if err := store.CreateWORMBucket(bucketName, time.Hour); err != ErrBucketAlreadyExists {
+		t.Errorf("Expected ErrBucketAlreadyExists, got %v", err)
+	}
+
+	retention, err := store.GetBucketWORMRetention(bucketName)
+	if err != nil {
+		t.Fatalf("GetBucketWORMRetention failed: %v", err)
+	}
+	if retention != time.Hour {
+		t.Errorf("Expected retention of 1h, got %v", retention)
+	}
+
+	if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("original"), Metadata{}, ""); err != nil {
+		t.Fatalf("First write should be allowed: %v", err)
+	}
+	if _, err := store.PutObject(context.Background(), bucketName, "other", strings.NewReader("other"), Metadata{}, ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	t.Run("Overwrite", func(t *testing.T) {
+		if _, err := store.PutObject(context.Background(), bucketName, "object", strings.NewReader("changed"), Metadata{}, ""); err != ErrObjectLocked {
+			t.Errorf("Expected ErrObjectLocked, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.DeleteObject(bucketName, "object"); err != ErrObjectLocked {
+			t.Errorf("Expected ErrObjectLocked, got %v", err)
+		}
+	})
+
+	t.Run("CopyOnto", func(t *testing.T) {
+		if _, err := store.CopyObject(bucketName, "other", bucketName, "object", nil); err != ErrObjectLocked {
+			t.Errorf("Expected ErrObjectLocked, got %v", err)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		if err := store.RenameObject(bucketName, "object", "renamed"); err != ErrObjectLocked {
+			t.Errorf("Expected ErrObjectLocked, got %v", err)
+		}
+	})
+
+	t.Run("CompleteMultipartUpload", func(t *testing.T) {
+		uploadID, err := store.InitiateMultipartUpload(bucketName, "object", Metadata{}, "")
+		if err != nil {
+			t.Fatalf("InitiateMultipartUpload failed: %v", err)
+		}
+		part, err := store.UploadPart(context.Background(), bucketName, "object", uploadID, 1, strings.NewReader("part"), "")
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		_, err = store.CompleteMultipartUpload(context.Background(), bucketName, "object", uploadID, []Multipart{{PartNumber: 1, ETag: part.ETag}}, "")
+		if err != ErrObjectLocked {
+			t.Errorf("Expected ErrObjectLocked, got %v", err)
+		}
+	})
+
+	t.Run("AfterRetention", func(t *testing.T) {
+		metaPath := filepath.Join(tmpDir, bucketName, "object", metaFile)
+		past := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(metaPath, past, past); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+		if err := store.DeleteObject(bucketName, "object"); err != nil {
+			t.Errorf("Expected delete after retention to succeed, got %v", err)
+		}
+	})
+
+	t.Run("RegularBucket", func(t *testing.T) {
+		if err := store.CreateBucket("test-bucket-regular"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := store.PutObject(context.Background(), "test-bucket-regular", "object", strings.NewReader("a"), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if _, err := store.PutObject(context.Background(), "test-bucket-regular", "object", strings.NewReader("b"), Metadata{}, ""); err != nil {
+			t.Errorf("Expected overwrite in regular bucket to succeed, got %v", err)
+		}
+	})
+}
+
+func TestDeleteObjectsPerKeyErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "storage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	bucketName := "test-bucket-worm-delete"
+	if err := store.CreateWORMBucket(bucketName, time.Hour); err != nil {
+		t.Fatalf("CreateWORMBucket failed: %v", err)
+	}
+	for _, key := range []string{"locked", "expired"} {
+		if _, err := store.PutObject(context.Background(), bucketName, key, strings.NewReader(key), Metadata{}, ""); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpDir, bucketName, "expired", metaFile), past, past); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	errs, err := store.DeleteObjects(bucketName, []string{"locked", "expired", "missing"})
+	if err != nil {
+		t.Fatalf("DeleteObjects failed: %v", err)
+	}
+	if errs[0] != ErrObjectLocked || errs[1] != nil || errs[2] != ErrObjectNotFound {
+		t.Errorf("Unexpected per-key errors: %v", errs)
+	}
+
+	if _, err := store.DeleteObjects("missing-bucket", []string{"a"}); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
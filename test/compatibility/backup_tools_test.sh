@@ -0,0 +1,238 @@
+#!/usr/bin/env bash
+# Run full backup, restore and prune cycles of restic and kopia against s3d
+# BACKUP_TOOLS selects the tools to run, such as "restic" or "kopia"
+# The behaviors they rely on are documented in backup_tools_compatibility.md
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_DIR="$(dirname "${BASH_SOURCE[0]}")"
+REPO_ROOT="$(realpath "${SCRIPT_DIR}/../..")"
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+YELLOW='\033[1;33m'
+NC='\033[0m' # No Color
+
+# Configuration
+export SERVER_PORT="${SERVER_PORT:-9000}"
+export SERVER_ADDR="127.0.0.1:${SERVER_PORT}"
+export SERVER_DATA_DIR="$(mktemp -d)"
+export SERVER_PID=""
+export ACCESS_KEY="backup-access-key"
+export SECRET_KEY="backup-secret-key"
+export TEST_DATA_DIR="$(mktemp -d)"
+export BACKUP_LOG_DIR="${REPO_ROOT}/test/compatibility/backup-tools-logs"
+export BACKUP_TOOLS="${BACKUP_TOOLS:-restic kopia}"
+export REPOSITORY_PASSWORD="s3d-backup-test-password"
+
+# Credentials for both tools
+export AWS_ACCESS_KEY_ID="${ACCESS_KEY}"
+export AWS_SECRET_ACCESS_KEY="${SECRET_KEY}"
+export AWS_DEFAULT_REGION=us-east-1
+
+# Cleanup function
+cleanup() {
+    echo -e "\n${YELLOW}Cleaning up...${NC}"
+    if [ -n "$SERVER_PID" ]; then
+        kill "$SERVER_PID" 2>/dev/null || true
+        wait "$SERVER_PID" 2>/dev/null || true
+    fi
+    rm -rf "${SERVER_DATA_DIR}"
+    rm -rf "${TEST_DATA_DIR}"
+    # Keep log directory for results
+}
+
+# Setup function
+setup() {
+    echo -e "${YELLOW}Starting S3-compatible server for backup tool tests...${NC}"
+    echo "Server address: ${SERVER_ADDR}"
+    echo "Server data directory: ${SERVER_DATA_DIR}"
+    echo "Tools: ${BACKUP_TOOLS}"
+
+    trap cleanup EXIT
+
+    for tool in ${BACKUP_TOOLS}; do
+        if ! command -v "$tool" &> /dev/null; then
+            echo -e "${RED}${tool} is not installed. Please install it first or leave it out of BACKUP_TOOLS.${NC}"
+            exit 1
+        fi
+    done
+
+    mkdir -p "${BACKUP_LOG_DIR}"
+
+    # Build the server
+    echo -e "\n${YELLOW}Building server...${NC}"
+    cd "${REPO_ROOT}"
+    go build -o ./s3d ./cmd/s3d || {
+        echo -e "${RED}Failed to build server${NC}"
+        exit 1
+    }
+    echo -e "${GREEN}Server built successfully${NC}"
+
+    echo -e "\n${YELLOW}Starting server with authentication...${NC}"
+    ./s3d -addr "${SERVER_ADDR}" -data "${SERVER_DATA_DIR}" -credentials "${ACCESS_KEY}:${SECRET_KEY}" > "${BACKUP_LOG_DIR}/s3d.log" 2>&1 &
+    SERVER_PID=$!
+    echo "Server PID: ${SERVER_PID}"
+
+    # Wait for server to start
+    echo "Waiting for server to be ready..."
+    for i in {1..30}; do
+        if curl -s "http://${SERVER_ADDR}" > /dev/null 2>&1; then
+            echo -e "${GREEN}Server is ready${NC}"
+            break
+        fi
+        if [ $i -eq 30 ]; then
+            echo -e "${RED}Server failed to start${NC}"
+            exit 1
+        fi
+        sleep 1
+    done
+}
+
+# fail prints a failure and exits
+fail() {
+    echo -e "${RED}✗ $1${NC}"
+    exit 1
+}
+
+# create_bucket creates a bucket with a signed request, kopia does not create its bucket
+create_bucket() {
+    curl -sf -X PUT --aws-sigv4 "aws:amz:us-east-1:s3" --user "${ACCESS_KEY}:${SECRET_KEY}" "http://${SERVER_ADDR}/$1" > /dev/null || \
+        fail "failed to create bucket $1"
+}
+
+# make_source creates the tree to back up, with duplicated content for deduplication
+# and a file large enough to be split into several blobs
+make_source() {
+    local dir="$1"
+    mkdir -p "${dir}/docs/nested" "${dir}/empty"
+    for i in $(seq 1 50); do
+        echo "document ${i}" > "${dir}/docs/doc-${i}.txt"
+    done
+    echo "nested" > "${dir}/docs/nested/file.txt"
+    cp "${dir}/docs/doc-1.txt" "${dir}/docs/nested/duplicate.txt"
+    : > "${dir}/zero-length"
+    dd if=/dev/urandom of="${dir}/large.bin" bs=1M count=24 2>/dev/null
+    ln -s docs/doc-1.txt "${dir}/link"
+}
+
+# change_source modifies, adds and removes files between snapshots
+change_source() {
+    local dir="$1"
+    echo "changed" > "${dir}/docs/doc-2.txt"
+    rm "${dir}/docs/doc-3.txt"
+    dd if=/dev/urandom of="${dir}/added.bin" bs=1M count=8 2>/dev/null
+}
+
+test_restic() {
+    echo -e "\n${YELLOW}Test: restic backup, restore and prune${NC}"
+    export RESTIC_REPOSITORY="s3:http://${SERVER_ADDR}/restic-test"
+    export RESTIC_PASSWORD="${REPOSITORY_PASSWORD}"
+    local src="${TEST_DATA_DIR}/restic-src"
+    local log="${BACKUP_LOG_DIR}/restic.log"
+    restic version | tee "$log"
+
+    make_source "$src"
+    # restic creates the bucket when it initializes the repository
+    restic init >> "$log" 2>&1 || fail "restic init failed, see $log"
+    restic backup "$src" >> "$log" 2>&1 || fail "restic backup failed, see $log"
+
+    change_source "$src"
+    restic backup "$src" >> "$log" 2>&1 || fail "restic second backup failed, see $log"
+
+    local count
+    count=$(restic snapshots --json | python3 -c "import sys, json; print(len(json.load(sys.stdin)))")
+    [ "$count" -eq 2 ] || fail "expected 2 restic snapshots, got ${count}"
+
+    # Restoring writes the absolute path of the source below the target
+    restic restore latest --target "${TEST_DATA_DIR}/restic-restore" >> "$log" 2>&1 || fail "restic restore failed, see $log"
+    diff -r "$src" "${TEST_DATA_DIR}/restic-restore${src}" || fail "restic restore differs from the source"
+    echo -e "${GREEN}✓ restic restored the latest snapshot${NC}"
+
+    # Pruning the first snapshot rewrites packs and deletes the unused ones
+    restic forget --keep-last 1 --prune >> "$log" 2>&1 || fail "restic forget --prune failed, see $log"
+    restic check --read-data >> "$log" 2>&1 || fail "restic check failed after prune, see $log"
+    rm -rf "${TEST_DATA_DIR}/restic-restore"
+    restic restore latest --target "${TEST_DATA_DIR}/restic-restore" >> "$log" 2>&1 || fail "restic restore after prune failed, see $log"
+    diff -r "$src" "${TEST_DATA_DIR}/restic-restore${src}" || fail "restic restore after prune differs from the source"
+    echo -e "${GREEN}✓ restic pruned and verified the repository${NC}"
+
+    # Every command removed its lock file
+    local locks
+    locks=$(restic list locks --no-lock | wc -l)
+    [ "$locks" -eq 0 ] || fail "expected no restic locks left, got ${locks}"
+    echo -e "${GREEN}✓ restic left no locks behind${NC}"
+}
+
+test_kopia() {
+    echo -e "\n${YELLOW}Test: kopia snapshot, restore and maintenance${NC}"
+    export KOPIA_CONFIG_PATH="${TEST_DATA_DIR}/kopia/repository.config"
+    export KOPIA_CACHE_DIRECTORY="${TEST_DATA_DIR}/kopia/cache"
+    export KOPIA_LOG_DIR="${BACKUP_LOG_DIR}/kopia"
+    export KOPIA_PASSWORD="${REPOSITORY_PASSWORD}"
+    export KOPIA_CHECK_FOR_UPDATES=false
+    local src="${TEST_DATA_DIR}/kopia-src"
+    local log="${BACKUP_LOG_DIR}/kopia.log"
+    kopia --version | tee "$log"
+
+    make_source "$src"
+    create_bucket kopia-test
+    kopia repository create s3 \
+        --bucket kopia-test \
+        --endpoint "${SERVER_ADDR}" \
+        --disable-tls \
+        --access-key "${ACCESS_KEY}" \
+        --secret-access-key "${SECRET_KEY}" \
+        --region us-east-1 >> "$log" 2>&1 || fail "kopia repository create failed, see $log"
+
+    kopia snapshot create "$src" >> "$log" 2>&1 || fail "kopia snapshot failed, see $log"
+    change_source "$src"
+    kopia snapshot create "$src" >> "$log" 2>&1 || fail "kopia second snapshot failed, see $log"
+
+    local snapshots
+    snapshots=$(kopia snapshot list "$src" --json)
+    local count latest first
+    count=$(echo "$snapshots" | python3 -c "import sys, json; print(len(json.load(sys.stdin)))")
+    [ "$count" -eq 2 ] || fail "expected 2 kopia snapshots, got ${count}"
+    first=$(echo "$snapshots" | python3 -c "import sys, json; print(json.load(sys.stdin)[0]['id'])")
+    latest=$(echo "$snapshots" | python3 -c "import sys, json; print(json.load(sys.stdin)[-1]['id'])")
+
+    kopia snapshot restore "$latest" "${TEST_DATA_DIR}/kopia-restore" >> "$log" 2>&1 || fail "kopia restore failed, see $log"
+    diff -r "$src" "${TEST_DATA_DIR}/kopia-restore" || fail "kopia restore differs from the source"
+    echo -e "${GREEN}✓ kopia restored the latest snapshot${NC}"
+
+    # Deleting the first snapshot and running full maintenance without the safety delays
+    # rewrites and deletes blobs, like restic prune
+    kopia snapshot delete "$first" --delete >> "$log" 2>&1 || fail "kopia snapshot delete failed, see $log"
+    kopia maintenance run --full --safety=none >> "$log" 2>&1 || fail "kopia maintenance failed, see $log"
+    kopia snapshot verify --verify-files-percent=100 >> "$log" 2>&1 || fail "kopia verify failed after maintenance, see $log"
+    kopia content verify --full >> "$log" 2>&1 || fail "kopia content verify failed after maintenance, see $log"
+    rm -rf "${TEST_DATA_DIR}/kopia-restore"
+    kopia snapshot restore "$latest" "${TEST_DATA_DIR}/kopia-restore" >> "$log" 2>&1 || fail "kopia restore after maintenance failed, see $log"
+    diff -r "$src" "${TEST_DATA_DIR}/kopia-restore" || fail "kopia restore after maintenance differs from the source"
+    echo -e "${GREEN}✓ kopia ran maintenance and verified the repository${NC}"
+
+    kopia repository disconnect >> "$log" 2>&1 || true
+}
+
+# Main execution
+main() {
+    setup
+
+    for tool in ${BACKUP_TOOLS}; do
+        case "$tool" in
+            restic) test_restic ;;
+            kopia) test_kopia ;;
+            *) fail "unknown backup tool ${tool}" ;;
+        esac
+    done
+
+    echo -e "\n${GREEN}========================================${NC}"
+    echo -e "${GREEN}All backup tool compatibility tests passed!${NC}"
+    echo -e "${GREEN}========================================${NC}"
+}
+
+main
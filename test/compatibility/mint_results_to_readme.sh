@@ -49,6 +49,23 @@ echo "| ❌ FAIL | $FAIL_COUNT |"
 echo "| ⚪ N/A | $((TOTAL_COUNT - PASS_COUNT - FAIL_COUNT)) |"
 echo ""
 
+# Compatibility matrix, one row per SDK
+echo "## Results by SDK"
+echo ""
+echo "| SDK | ✅ PASS | ❌ FAIL | ⚪ N/A |"
+echo "|-----|---------|---------|--------|"
+echo "$TEST_LOG" | awk -F' [|] ' 'NF > 2 {
+    sdk = substr($1, 3)
+    sub(/:.*/, "", sdk)
+    seen[sdk] = 1
+    if ($2 == "PASS") pass[sdk]++
+    else if ($2 == "FAIL") fail[sdk]++
+    else na[sdk]++
+} END {
+    for (sdk in seen) printf "| %s | %d | %d | %d |\n", sdk, pass[sdk], fail[sdk], na[sdk]
+}' | LC_ALL=C sort
+echo ""
+
 echo "## Detailed Results"
 echo ""
 echo "| SDK | Status | Test Function | Arguments | Message |"
@@ -0,0 +1,255 @@
+#!/usr/bin/env bash
+# Run rclone copy, sync, check, listing, server-side copy and modtime flows against s3d
+# The remote settings s3d needs are documented in rclone_compatibility.md
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_DIR="$(dirname "${BASH_SOURCE[0]}")"
+REPO_ROOT="$(realpath "${SCRIPT_DIR}/../..")"
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+YELLOW='\033[1;33m'
+NC='\033[0m' # No Color
+
+# Configuration
+export SERVER_PORT="${SERVER_PORT:-9000}"
+export SERVER_DATA_DIR="$(mktemp -d)"
+export SERVER_PID=""
+export ACCESS_KEY="rclone-access-key"
+export SECRET_KEY="rclone-secret-key"
+export TEST_DATA_DIR="$(mktemp -d)"
+export RCLONE_LOG_DIR="${REPO_ROOT}/test/compatibility/rclone-logs"
+export BUCKET="rclone-test"
+
+# Print modification times in UTC so they compare with the times set below
+export TZ=UTC
+
+# The s3d remote, configured through environment variables instead of a config file
+export RCLONE_CONFIG_S3D_TYPE=s3
+export RCLONE_CONFIG_S3D_PROVIDER=Other
+export RCLONE_CONFIG_S3D_ENDPOINT="http://127.0.0.1:${SERVER_PORT}"
+export RCLONE_CONFIG_S3D_ACCESS_KEY_ID="${ACCESS_KEY}"
+export RCLONE_CONFIG_S3D_SECRET_ACCESS_KEY="${SECRET_KEY}"
+export RCLONE_CONFIG_S3D_REGION=us-east-1
+export RCLONE_CONFIG_S3D_FORCE_PATH_STYLE=true
+export RCLONE_CONFIG_S3D_LIST_VERSION=2
+export RCLONE_CONFIG_S3D_USE_MULTIPART_ETAG=false
+# Small multipart settings so that the larger files go through multipart uploads and copies
+export RCLONE_CONFIG_S3D_UPLOAD_CUTOFF=5M
+export RCLONE_CONFIG_S3D_CHUNK_SIZE=5M
+export RCLONE_CONFIG_S3D_COPY_CUTOFF=5M
+
+# Cleanup function
+cleanup() {
+    echo -e "\n${YELLOW}Cleaning up...${NC}"
+    if [ -n "$SERVER_PID" ]; then
+        kill "$SERVER_PID" 2>/dev/null || true
+        wait "$SERVER_PID" 2>/dev/null || true
+    fi
+    rm -rf "${SERVER_DATA_DIR}"
+    rm -rf "${TEST_DATA_DIR}"
+    # Keep log directory for results
+}
+
+# Setup function
+setup() {
+    echo -e "${YELLOW}Starting S3-compatible server for rclone tests...${NC}"
+    echo "Server port: ${SERVER_PORT}"
+    echo "Server data directory: ${SERVER_DATA_DIR}"
+
+    trap cleanup EXIT
+
+    if ! command -v rclone &> /dev/null; then
+        echo -e "${RED}rclone is not installed. Please install it first.${NC}"
+        echo "See: https://rclone.org/install/"
+        exit 1
+    fi
+    rclone version
+
+    mkdir -p "${RCLONE_LOG_DIR}"
+
+    # Build the server
+    echo -e "\n${YELLOW}Building server...${NC}"
+    cd "${REPO_ROOT}"
+    go build -o ./s3d ./cmd/s3d || {
+        echo -e "${RED}Failed to build server${NC}"
+        exit 1
+    }
+    echo -e "${GREEN}Server built successfully${NC}"
+
+    echo -e "\n${YELLOW}Starting server with authentication...${NC}"
+    ./s3d -addr "127.0.0.1:${SERVER_PORT}" -data "${SERVER_DATA_DIR}" -credentials "${ACCESS_KEY}:${SECRET_KEY}" > "${RCLONE_LOG_DIR}/s3d.log" 2>&1 &
+    SERVER_PID=$!
+    echo "Server PID: ${SERVER_PID}"
+
+    # Wait for server to start
+    echo "Waiting for server to be ready..."
+    for i in {1..30}; do
+        if curl -s "http://127.0.0.1:${SERVER_PORT}" > /dev/null 2>&1; then
+            echo -e "${GREEN}Server is ready${NC}"
+            break
+        fi
+        if [ $i -eq 30 ]; then
+            echo -e "${RED}Server failed to start${NC}"
+            exit 1
+        fi
+        sleep 1
+    done
+}
+
+# fail prints a failure and exits
+fail() {
+    echo -e "${RED}✗ $1${NC}"
+    exit 1
+}
+
+# make_tree creates a local tree with small, nested and multipart-sized files
+make_tree() {
+    local dir="$1"
+    mkdir -p "${dir}/nested/deeper" "${dir}/empty-dir-sibling"
+    for i in $(seq 1 10); do
+        echo "file ${i}" > "${dir}/file-${i}.txt"
+    done
+    echo "nested" > "${dir}/nested/file.txt"
+    echo "deeper" > "${dir}/nested/deeper/file.txt"
+    echo "sibling" > "${dir}/empty-dir-sibling/file.txt"
+    : > "${dir}/zero-length"
+    echo "spaces" > "${dir}/name with spaces.txt"
+    echo "unicode" > "${dir}/ünïcödé-名前.txt"
+    dd if=/dev/urandom of="${dir}/large.bin" bs=1M count=12 2>/dev/null
+}
+
+test_mkdir() {
+    echo -e "\n${YELLOW}Test: mkdir creates the bucket${NC}"
+    rclone mkdir "s3d:${BUCKET}"
+    rclone lsd s3d: | grep -q " ${BUCKET}$" || fail "bucket not listed after mkdir"
+    echo -e "${GREEN}✓ Bucket created${NC}"
+}
+
+test_copy_and_check() {
+    echo -e "\n${YELLOW}Test: copy then check with and without downloading${NC}"
+    make_tree "${TEST_DATA_DIR}/src"
+
+    rclone copy "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/copy" --log-file "${RCLONE_LOG_DIR}/copy.log" -v
+    # s3d ETags are not MD5 sums, so only multipart uploads carry an MD5 (in X-Amz-Meta-Md5chksum)
+    # and the plain check compares sizes for the other files
+    rclone check "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/copy" --one-way || fail "check found differences after copy"
+    rclone check "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/copy" --download || fail "check --download found differences after copy"
+    echo -e "${GREEN}✓ Copied tree matches the source${NC}"
+}
+
+test_sync() {
+    echo -e "\n${YELLOW}Test: sync uploads changes and deletes extraneous objects${NC}"
+    rclone sync "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/sync"
+
+    # An unchanged sync compares sizes and modtimes and transfers nothing
+    rclone sync "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/sync" --stats-one-line -v --log-file "${RCLONE_LOG_DIR}/sync-unchanged.log"
+    if grep -q "Copied (" "${RCLONE_LOG_DIR}/sync-unchanged.log"; then
+        fail "unchanged sync copied files again"
+    fi
+
+    rm "${TEST_DATA_DIR}/src/file-2.txt"
+    echo "changed content" > "${TEST_DATA_DIR}/src/file-3.txt"
+    echo "added" > "${TEST_DATA_DIR}/src/nested/added.txt"
+    rclone sync "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/sync"
+    rclone check "${TEST_DATA_DIR}/src" "s3d:${BUCKET}/sync" --download || fail "check found differences after sync"
+
+    # Syncing back down reproduces the tree
+    rclone sync "s3d:${BUCKET}/sync" "${TEST_DATA_DIR}/down"
+    diff -r "${TEST_DATA_DIR}/src" "${TEST_DATA_DIR}/down" || fail "synced down tree differs from the source"
+    echo -e "${GREEN}✓ sync applied additions, changes and deletions both ways${NC}"
+}
+
+test_fast_list() {
+    echo -e "\n${YELLOW}Test: listings with and without --fast-list agree${NC}"
+    rclone lsf -R "s3d:${BUCKET}" > "${RCLONE_LOG_DIR}/lsf.txt"
+    rclone lsf -R --fast-list "s3d:${BUCKET}" > "${RCLONE_LOG_DIR}/lsf-fast-list.txt"
+    diff "${RCLONE_LOG_DIR}/lsf.txt" "${RCLONE_LOG_DIR}/lsf-fast-list.txt" || fail "--fast-list listing differs"
+
+    # Listing pages of 10 keys exercise continuation tokens
+    rclone lsf -R --fast-list --s3-list-chunk 10 "s3d:${BUCKET}" > "${RCLONE_LOG_DIR}/lsf-chunked.txt"
+    diff "${RCLONE_LOG_DIR}/lsf.txt" "${RCLONE_LOG_DIR}/lsf-chunked.txt" || fail "paginated listing differs"
+
+    rclone size "s3d:${BUCKET}/copy" --fast-list --json > "${RCLONE_LOG_DIR}/size.json"
+    grep -q '"count":17' "${RCLONE_LOG_DIR}/size.json" || fail "expected 17 objects in copy, got $(cat "${RCLONE_LOG_DIR}/size.json")"
+    echo -e "${GREEN}✓ --fast-list and paginated listings agree${NC}"
+}
+
+test_server_side_copy() {
+    echo -e "\n${YELLOW}Test: server-side copy and move${NC}"
+    rclone copyto "s3d:${BUCKET}/copy/file-1.txt" "s3d:${BUCKET}/server-side/file-1.txt" -vv --log-file "${RCLONE_LOG_DIR}/server-side-copy.log"
+    grep -q "server-side copy" "${RCLONE_LOG_DIR}/server-side-copy.log" || fail "copy was not done server-side"
+
+    # Above the copy cutoff rclone copies with UploadPartCopy
+    rclone copyto "s3d:${BUCKET}/copy/large.bin" "s3d:${BUCKET}/server-side/large.bin" -vv --log-file "${RCLONE_LOG_DIR}/server-side-multipart-copy.log"
+    grep -q "server-side copy" "${RCLONE_LOG_DIR}/server-side-multipart-copy.log" || fail "multipart copy was not done server-side"
+    rclone cat "s3d:${BUCKET}/server-side/large.bin" | cmp - "${TEST_DATA_DIR}/src/large.bin" || fail "multipart copy differs from the source"
+
+    rclone moveto "s3d:${BUCKET}/server-side/file-1.txt" "s3d:${BUCKET}/server-side/moved.txt"
+    if rclone lsf "s3d:${BUCKET}/server-side/file-1.txt" | grep -q .; then
+        fail "moved object still exists at the source"
+    fi
+    [ "$(rclone cat "s3d:${BUCKET}/server-side/moved.txt")" = "file 1" ] || fail "moved object has the wrong content"
+    echo -e "${GREEN}✓ Copies and moves were done server-side${NC}"
+}
+
+test_modtime() {
+    echo -e "\n${YELLOW}Test: modification times are preserved and updated${NC}"
+    echo "modtime" > "${TEST_DATA_DIR}/modtime.txt"
+    touch -d "2020-01-02 03:04:05" "${TEST_DATA_DIR}/modtime.txt"
+    rclone copy "${TEST_DATA_DIR}/modtime.txt" "s3d:${BUCKET}/modtime"
+
+    # rclone keeps the modification time in the X-Amz-Meta-Mtime metadata
+    rclone lsl "s3d:${BUCKET}/modtime/modtime.txt" | grep -q "2020-01-02 03:04:05" || fail "modtime not preserved on upload"
+
+    # Updating the modtime copies the object onto itself with replaced metadata
+    rclone touch "s3d:${BUCKET}/modtime/modtime.txt" -t 2021-06-07T08:09:10
+    rclone lsl "s3d:${BUCKET}/modtime/modtime.txt" | grep -q "2021-06-07 08:09:10" || fail "modtime not updated by touch"
+    [ "$(rclone cat "s3d:${BUCKET}/modtime/modtime.txt")" = "modtime" ] || fail "touch changed the content"
+
+    rclone copy "s3d:${BUCKET}/modtime/modtime.txt" "${TEST_DATA_DIR}/modtime-down"
+    [ "$(date -r "${TEST_DATA_DIR}/modtime-down/modtime.txt" "+%Y-%m-%d %H:%M:%S")" = "2021-06-07 08:09:10" ] || fail "modtime not applied on download"
+    echo -e "${GREEN}✓ Modification times round-trip${NC}"
+}
+
+test_info() {
+    echo -e "\n${YELLOW}Test: rclone test info (informational)${NC}"
+    # Reports the characters, lengths and streaming uploads the remote accepts
+    rclone test info --check-control --check-length --check-normalization --check-streaming \
+        --write-json "${RCLONE_LOG_DIR}/info.json" "s3d:${BUCKET}/info" > "${RCLONE_LOG_DIR}/info.txt" 2>&1 || \
+        echo -e "${YELLOW}! rclone test info did not complete, see ${RCLONE_LOG_DIR}/info.txt${NC}"
+    echo "Results written to ${RCLONE_LOG_DIR}/info.json"
+}
+
+test_purge() {
+    echo -e "\n${YELLOW}Test: purge removes the bucket${NC}"
+    rclone purge "s3d:${BUCKET}"
+    if rclone lsd s3d: | grep -q " ${BUCKET}$"; then
+        fail "bucket still exists after purge"
+    fi
+    echo -e "${GREEN}✓ Bucket purged${NC}"
+}
+
+# Main execution
+main() {
+    setup
+
+    test_mkdir
+    test_copy_and_check
+    test_sync
+    test_fast_list
+    test_server_side_copy
+    test_modtime
+    test_info
+    test_purge
+
+    echo -e "\n${GREEN}========================================${NC}"
+    echo -e "${GREEN}All rclone compatibility tests passed!${NC}"
+    echo -e "${GREEN}========================================${NC}"
+}
+
+main
@@ -0,0 +1,214 @@
+#!/usr/bin/env bash
+# Run terraform init, plan and apply against s3d with the S3 backend and its lock file (use_lockfile)
+# TERRAFORM selects the binary, such as "tofu" for OpenTofu 1.10+
+# The backend settings and the behaviors they rely on are documented in terraform_compatibility.md
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_DIR="$(dirname "${BASH_SOURCE[0]}")"
+REPO_ROOT="$(realpath "${SCRIPT_DIR}/../..")"
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+YELLOW='\033[1;33m'
+NC='\033[0m' # No Color
+
+# Configuration
+export SERVER_PORT="${SERVER_PORT:-9000}"
+export SERVER_ADDR="127.0.0.1:${SERVER_PORT}"
+export SERVER_DATA_DIR="$(mktemp -d)"
+export SERVER_PID=""
+export ACCESS_KEY="terraform-access-key"
+export SECRET_KEY="terraform-secret-key"
+export TEST_DATA_DIR="$(mktemp -d)"
+export TERRAFORM_LOG_DIR="${REPO_ROOT}/test/compatibility/terraform-logs"
+export TERRAFORM="${TERRAFORM:-terraform}"
+export BUCKET="terraform-state"
+export STATE_KEY="compat/terraform.tfstate"
+
+# Credentials of the backend
+export AWS_ACCESS_KEY_ID="${ACCESS_KEY}"
+export AWS_SECRET_ACCESS_KEY="${SECRET_KEY}"
+export TF_IN_AUTOMATION=1
+export TF_INPUT=0
+
+# Cleanup function
+cleanup() {
+    echo -e "\n${YELLOW}Cleaning up...${NC}"
+    if [ -n "$SERVER_PID" ]; then
+        kill "$SERVER_PID" 2>/dev/null || true
+        wait "$SERVER_PID" 2>/dev/null || true
+    fi
+    rm -rf "${SERVER_DATA_DIR}"
+    rm -rf "${TEST_DATA_DIR}"
+    # Keep log directory for results
+}
+
+# Setup function
+setup() {
+    echo -e "${YELLOW}Starting S3-compatible server for terraform tests...${NC}"
+    echo "Server address: ${SERVER_ADDR}"
+    echo "Server data directory: ${SERVER_DATA_DIR}"
+
+    trap cleanup EXIT
+
+    if ! command -v "${TERRAFORM}" &> /dev/null; then
+        echo -e "${RED}${TERRAFORM} is not installed. Please install it first.${NC}"
+        exit 1
+    fi
+
+    mkdir -p "${TERRAFORM_LOG_DIR}"
+
+    # Build the server
+    echo -e "\n${YELLOW}Building server...${NC}"
+    cd "${REPO_ROOT}"
+    go build -o ./s3d ./cmd/s3d || {
+        echo -e "${RED}Failed to build server${NC}"
+        exit 1
+    }
+    echo -e "${GREEN}Server built successfully${NC}"
+
+    echo -e "\n${YELLOW}Starting server with authentication...${NC}"
+    ./s3d -addr "${SERVER_ADDR}" -data "${SERVER_DATA_DIR}" -credentials "${ACCESS_KEY}:${SECRET_KEY}" > "${TERRAFORM_LOG_DIR}/s3d.log" 2>&1 &
+    SERVER_PID=$!
+    echo "Server PID: ${SERVER_PID}"
+
+    # Wait for server to start
+    echo "Waiting for server to be ready..."
+    for i in {1..30}; do
+        if curl -s "http://${SERVER_ADDR}" > /dev/null 2>&1; then
+            echo -e "${GREEN}Server is ready${NC}"
+            break
+        fi
+        if [ $i -eq 30 ]; then
+            echo -e "${RED}Server failed to start${NC}"
+            exit 1
+        fi
+        sleep 1
+    done
+}
+
+# fail prints a failure and exits
+fail() {
+    echo -e "${RED}✗ $1${NC}"
+    exit 1
+}
+
+# s3 sends a signed request to s3d, the method first and the path second, remaining arguments go to curl
+s3() {
+    local method="$1" path="$2"
+    shift 2
+    curl -s -X "$method" --aws-sigv4 "aws:amz:us-east-1:s3" --user "${ACCESS_KEY}:${SECRET_KEY}" \
+        -H "x-amz-content-sha256: UNSIGNED-PAYLOAD" "$@" "http://${SERVER_ADDR}/${path}"
+}
+
+# object_status prints the status code of a GET request of an object
+object_status() {
+    s3 GET "${BUCKET}/$1" -o /dev/null -w "%{http_code}"
+}
+
+# write_config writes a configuration holding a value in a terraform_data resource,
+# which is built into terraform so that no provider has to be downloaded
+write_config() {
+    local dir="$1"
+    mkdir -p "$dir"
+    cat > "${dir}/main.tf" <<HCL
+terraform {
+  backend "s3" {
+    bucket       = "${BUCKET}"
+    key          = "${STATE_KEY}"
+    region       = "us-east-1"
+    use_lockfile = true
+
+    endpoints = {
+      s3 = "http://${SERVER_ADDR}"
+    }
+    use_path_style              = true
+    skip_credentials_validation = true
+    skip_requesting_account_id  = true
+    skip_metadata_api_check     = true
+    skip_region_validation      = true
+  }
+}
+
+variable "value" {
+  type    = string
+  default = "first"
+}
+
+resource "terraform_data" "example" {
+  input = var.value
+}
+
+output "value" {
+  value = terraform_data.example.output
+}
+HCL
+}
+
+test_terraform() {
+    echo -e "\n${YELLOW}Test: terraform init, plan and apply with the S3 backend${NC}"
+    local dir="${TEST_DATA_DIR}/config"
+    local log="${TERRAFORM_LOG_DIR}/terraform.log"
+    "${TERRAFORM}" version | tee "$log"
+
+    s3 PUT "${BUCKET}" -f > /dev/null || fail "failed to create bucket ${BUCKET}"
+    write_config "$dir"
+    cd "$dir"
+
+    "${TERRAFORM}" init >> "$log" 2>&1 || fail "terraform init failed, see $log"
+    "${TERRAFORM}" plan -out=plan.out >> "$log" 2>&1 || fail "terraform plan failed, see $log"
+    "${TERRAFORM}" apply -auto-approve plan.out >> "$log" 2>&1 || fail "terraform apply failed, see $log"
+    [ "$(object_status "${STATE_KEY}")" = "200" ] || fail "expected the state to be stored in s3d"
+    [ "$(object_status "${STATE_KEY}.tflock")" = "404" ] || fail "expected apply to remove its lock file"
+    echo -e "${GREEN}✓ terraform applied and stored its state${NC}"
+
+    # A run holding the lock keeps the others out until it is released
+    local lock_info='{"ID":"held-by-test","Operation":"OperationTypeApply","Info":"","Who":"compatibility-test","Version":"1.10.0","Created":"2025-01-01T00:00:00Z","Path":"'"${BUCKET}/${STATE_KEY}"'"}'
+    [ "$(s3 PUT "${BUCKET}/${STATE_KEY}.tflock" -H "If-None-Match: *" -H "Content-Type: application/json" -d "$lock_info" -o /dev/null -w "%{http_code}")" = "200" ] || \
+        fail "failed to create the lock file"
+    [ "$(s3 PUT "${BUCKET}/${STATE_KEY}.tflock" -H "If-None-Match: *" -d "$lock_info" -o /dev/null -w "%{http_code}")" = "412" ] || \
+        fail "expected a second lock file to be rejected"
+    if "${TERRAFORM}" plan -lock-timeout=2s >> "$log" 2>&1; then
+        fail "expected terraform plan to fail while the state is locked"
+    fi
+    grep -q "held-by-test" "$log" || fail "expected terraform to report the holder of the lock, see $log"
+    "${TERRAFORM}" force-unlock -force held-by-test >> "$log" 2>&1 || fail "terraform force-unlock failed, see $log"
+    [ "$(object_status "${STATE_KEY}.tflock")" = "404" ] || fail "expected force-unlock to remove the lock file"
+    echo -e "${GREEN}✓ terraform respected and released the lock file${NC}"
+
+    "${TERRAFORM}" apply -auto-approve -var value=second >> "$log" 2>&1 || fail "terraform apply of a change failed, see $log"
+    [ "$("${TERRAFORM}" output -raw value)" = "second" ] || fail "expected the change to be applied"
+    echo -e "${GREEN}✓ terraform applied a change to the stored state${NC}"
+
+    # Workspaces keep their states under env:/, escaped here since curl signs a raw colon unlike the SDKs
+    "${TERRAFORM}" workspace new dev >> "$log" 2>&1 || fail "terraform workspace new failed, see $log"
+    "${TERRAFORM}" apply -auto-approve -var value=dev >> "$log" 2>&1 || fail "terraform apply in a workspace failed, see $log"
+    [ "$(object_status "env%3A/dev/${STATE_KEY}")" = "200" ] || fail "expected the state of the workspace to be stored in s3d"
+    "${TERRAFORM}" workspace list >> "$log" 2>&1 || fail "terraform workspace list failed, see $log"
+    "${TERRAFORM}" workspace list | grep -q "dev" || fail "expected the workspace to be listed"
+    "${TERRAFORM}" destroy -auto-approve >> "$log" 2>&1 || fail "terraform destroy in a workspace failed, see $log"
+    "${TERRAFORM}" workspace select default >> "$log" 2>&1 || fail "terraform workspace select failed, see $log"
+    "${TERRAFORM}" workspace delete dev >> "$log" 2>&1 || fail "terraform workspace delete failed, see $log"
+    [ "$(object_status "env%3A/dev/${STATE_KEY}")" = "404" ] || fail "expected the state of the deleted workspace to be removed"
+    echo -e "${GREEN}✓ terraform managed workspaces${NC}"
+
+    "${TERRAFORM}" destroy -auto-approve >> "$log" 2>&1 || fail "terraform destroy failed, see $log"
+    [ "$(object_status "${STATE_KEY}.tflock")" = "404" ] || fail "expected no lock file to be left behind"
+    echo -e "${GREEN}✓ terraform destroyed the resources and left no lock behind${NC}"
+}
+
+# Main execution
+main() {
+    setup
+    test_terraform
+
+    echo -e "\n${GREEN}========================================${NC}"
+    echo -e "${GREEN}All terraform compatibility tests passed!${NC}"
+    echo -e "${GREEN}========================================${NC}"
+}
+
+main
@@ -0,0 +1,242 @@
+#!/usr/bin/env python3
+"""E2E tests for boto3 behavior against s3d: managed transfers, checksums,
+pagination and presigned URLs. Run through sdk_tests.sh."""
+
+import argparse
+import base64
+import hashlib
+import os
+import sys
+import urllib.error
+import urllib.request
+
+import boto3
+from boto3.s3.transfer import TransferConfig
+from botocore.config import Config
+from botocore.exceptions import ClientError
+
+MB = 1024 * 1024
+
+# Transfers above 8MB are split into 5MB parts, like the AWS CLI config of sdk_tests.sh
+TRANSFER_CONFIG = TransferConfig(multipart_threshold=8 * MB, multipart_chunksize=5 * MB)
+
+
+def new_client(endpoint_url):
+    # s3d validates SHA256 checksums only, so only send checksums when a test asks for one
+    return boto3.client(
+        "s3",
+        endpoint_url=endpoint_url,
+        config=Config(
+            signature_version="s3v4",
+            s3={"addressing_style": "path"},
+            request_checksum_calculation="when_required",
+            response_checksum_validation="when_required",
+        ),
+    )
+
+
+class OperationCounter:
+    """Counts the requests a client makes per operation."""
+
+    def __init__(self, client):
+        self.counts = {}
+        client.meta.events.register("before-call.s3.*", self._count)
+
+    def _count(self, model, **kwargs):
+        self.counts[model.name] = self.counts.get(model.name, 0) + 1
+
+    def reset(self):
+        self.counts = {}
+
+    def get(self, operation):
+        return self.counts.get(operation, 0)
+
+
+class UnseekableReader:
+    """Reads data like a pipe, without seek or tell."""
+
+    def __init__(self, data):
+        self.data = data
+
+    def read(self, size=-1):
+        if size < 0:
+            size = len(self.data)
+        chunk, self.data = self.data[:size], self.data[size:]
+        return chunk
+
+
+def check(condition, message):
+    if not condition:
+        raise AssertionError(message)
+
+
+def write_random_file(path, size):
+    with open(path, "wb") as f:
+        f.write(os.urandom(size))
+
+
+def file_digest(path):
+    with open(path, "rb") as f:
+        return hashlib.sha256(f.read()).hexdigest()
+
+
+def test_upload_file_threshold(client, counter, bucket, data_dir):
+    small = os.path.join(data_dir, "boto3-small.bin")
+    large = os.path.join(data_dir, "boto3-large.bin")
+    write_random_file(small, 4 * MB)
+    write_random_file(large, 18 * MB)
+
+    counter.reset()
+    client.upload_file(small, bucket, "boto3/small.bin", Config=TRANSFER_CONFIG)
+    check(counter.get("PutObject") == 1, f"expected one PutObject below the threshold, got {counter.counts}")
+    check(counter.get("UploadPart") == 0, f"expected no parts below the threshold, got {counter.counts}")
+
+    counter.reset()
+    client.upload_file(large, bucket, "boto3/large.bin", Config=TRANSFER_CONFIG)
+    check(counter.get("CreateMultipartUpload") == 1, f"expected a multipart upload, got {counter.counts}")
+    check(counter.get("UploadPart") == 4, f"expected 4 parts above the threshold, got {counter.counts}")
+    check(counter.get("CompleteMultipartUpload") == 1, f"expected the upload to complete, got {counter.counts}")
+
+    for name, path in (("small.bin", small), ("large.bin", large)):
+        downloaded = path + ".downloaded"
+        client.download_file(bucket, "boto3/" + name, downloaded, Config=TRANSFER_CONFIG)
+        check(file_digest(path) == file_digest(downloaded), f"downloaded {name} differs from the upload")
+
+    head = client.head_object(Bucket=bucket, Key="boto3/large.bin")
+    check(head["ContentLength"] == 18 * MB, f"expected 18MB, got {head['ContentLength']}")
+
+
+def test_upload_fileobj_stream(client, bucket):
+    # Unseekable streams are buffered into parts by the transfer manager
+    data = os.urandom(12 * MB)
+    client.upload_fileobj(UnseekableReader(data), bucket, "boto3/stream.bin", Config=TRANSFER_CONFIG)
+
+    body = client.get_object(Bucket=bucket, Key="boto3/stream.bin")["Body"].read()
+    check(body == data, "streamed upload differs from the source")
+
+
+def test_managed_copy(client, counter, bucket):
+    counter.reset()
+    client.copy({"Bucket": bucket, "Key": "boto3/large.bin"}, bucket, "boto3/large-copy.bin", Config=TRANSFER_CONFIG)
+    check(counter.get("UploadPartCopy") == 4, f"expected 4 UploadPartCopy requests, got {counter.counts}")
+
+    source = client.get_object(Bucket=bucket, Key="boto3/large.bin")["Body"].read()
+    copy = client.get_object(Bucket=bucket, Key="boto3/large-copy.bin")["Body"].read()
+    check(source == copy, "multipart copy differs from the source")
+
+
+def test_ranged_get(client, bucket):
+    full = client.get_object(Bucket=bucket, Key="boto3/large.bin")["Body"].read()
+    part = client.get_object(Bucket=bucket, Key="boto3/large.bin", Range="bytes=1000-1999")
+    check(part["ContentLength"] == 1000, f"expected 1000 bytes, got {part['ContentLength']}")
+    check(part["Body"].read() == full[1000:2000], "ranged GET returned the wrong bytes")
+
+
+def test_checksums(client, bucket):
+    body = b"boto3 checksum content"
+    expected = base64.b64encode(hashlib.sha256(body).digest()).decode()
+
+    resp = client.put_object(Bucket=bucket, Key="boto3/checksum.txt", Body=body, ChecksumAlgorithm="SHA256")
+    check(resp.get("ChecksumSHA256") == expected, f"expected ChecksumSHA256 {expected}, got {resp.get('ChecksumSHA256')}")
+
+    # botocore validates the returned checksum while the body is read
+    resp = client.get_object(Bucket=bucket, Key="boto3/checksum.txt", ChecksumMode="ENABLED")
+    check(resp["Body"].read() == body, "checksummed GET returned the wrong body")
+    check(resp.get("ChecksumSHA256") == expected, f"expected GetObject ChecksumSHA256 {expected}, got {resp.get('ChecksumSHA256')}")
+
+    wrong = base64.b64encode(hashlib.sha256(b"other content").digest()).decode()
+    try:
+        client.put_object(Bucket=bucket, Key="boto3/bad-checksum.txt", Body=body, ChecksumSHA256=wrong)
+    except ClientError as e:
+        code = e.response["Error"]["Code"]
+        check(code == "BadDigest", f"expected BadDigest, got {code}")
+    else:
+        raise AssertionError("expected a mismatched checksum to be rejected")
+
+
+def test_pagination(client, bucket):
+    for i in range(25):
+        client.put_object(Bucket=bucket, Key=f"boto3/pages/key-{i:02d}.txt", Body=b"page")
+
+    paginator = client.get_paginator("list_objects_v2")
+    pages = list(paginator.paginate(Bucket=bucket, Prefix="boto3/pages/", PaginationConfig={"PageSize": 10}))
+    counts = [page["KeyCount"] for page in pages]
+    check(counts == [10, 10, 5], f"expected pages of 10, 10 and 5 keys, got {counts}")
+    keys = [obj["Key"] for page in pages for obj in page.get("Contents", [])]
+    check(keys == sorted(keys), "expected keys in lexicographic order")
+    check(len(set(keys)) == 25, f"expected 25 distinct keys, got {len(set(keys))}")
+
+    # Delimited listings roll the keys up into common prefixes listed once
+    for i in range(12):
+        client.put_object(Bucket=bucket, Key=f"boto3/dirs/dir-{i:02d}/file.txt", Body=b"dir")
+    pages = list(paginator.paginate(Bucket=bucket, Prefix="boto3/dirs/", Delimiter="/", PaginationConfig={"PageSize": 5}))
+    prefixes = [p["Prefix"] for page in pages for p in page.get("CommonPrefixes", [])]
+    check(len(prefixes) == 12, f"expected 12 common prefixes, got {len(prefixes)}")
+    check(len(set(prefixes)) == 12, "expected common prefixes not to repeat across pages")
+
+    paginator = client.get_paginator("list_objects")
+    keys = [obj["Key"] for page in paginator.paginate(Bucket=bucket, Prefix="boto3/pages/", PaginationConfig={"PageSize": 7}) for obj in page.get("Contents", [])]
+    check(len(keys) == 25, f"expected ListObjects v1 to page through 25 keys, got {len(keys)}")
+
+
+def test_presigned_urls(client, bucket):
+    url = client.generate_presigned_url("put_object", Params={"Bucket": bucket, "Key": "boto3/presigned.txt"}, ExpiresIn=300)
+    req = urllib.request.Request(url, data=b"presigned upload", method="PUT")
+    with urllib.request.urlopen(req) as resp:
+        check(resp.status == 200, f"expected presigned PUT to succeed, got {resp.status}")
+
+    url = client.generate_presigned_url("get_object", Params={"Bucket": bucket, "Key": "boto3/presigned.txt"}, ExpiresIn=300)
+    with urllib.request.urlopen(url) as resp:
+        check(resp.read() == b"presigned upload", "presigned GET returned the wrong body")
+
+    try:
+        urllib.request.urlopen(url.replace("presigned.txt", "other.txt"))
+    except urllib.error.HTTPError as e:
+        check(e.code == 403, f"expected 403 for a tampered URL, got {e.code}")
+    else:
+        raise AssertionError("expected a tampered presigned URL to be rejected")
+
+
+def test_delete_objects(client, bucket):
+    keys = [obj["Key"] for page in client.get_paginator("list_objects_v2").paginate(Bucket=bucket, Prefix="boto3/") for obj in page.get("Contents", [])]
+    for i in range(0, len(keys), 1000):
+        resp = client.delete_objects(Bucket=bucket, Delete={"Objects": [{"Key": key} for key in keys[i:i + 1000]], "Quiet": True})
+        check(not resp.get("Errors"), f"expected no delete errors, got {resp.get('Errors')}")
+
+    resp = client.list_objects_v2(Bucket=bucket, Prefix="boto3/")
+    check(resp["KeyCount"] == 0, f"expected no keys left, got {resp['KeyCount']}")
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__)
+    parser.add_argument("--endpoint-url", required=True)
+    parser.add_argument("--bucket", required=True)
+    parser.add_argument("--data-dir", required=True)
+    args = parser.parse_args()
+
+    client = new_client(args.endpoint_url)
+    counter = OperationCounter(client)
+
+    tests = [
+        ("upload_file multipart threshold", lambda: test_upload_file_threshold(client, counter, args.bucket, args.data_dir)),
+        ("upload_fileobj unseekable stream", lambda: test_upload_fileobj_stream(client, args.bucket)),
+        ("managed multipart copy", lambda: test_managed_copy(client, counter, args.bucket)),
+        ("ranged GET", lambda: test_ranged_get(client, args.bucket)),
+        ("SHA256 checksums", lambda: test_checksums(client, args.bucket)),
+        ("pagination", lambda: test_pagination(client, args.bucket)),
+        ("presigned URLs", lambda: test_presigned_urls(client, args.bucket)),
+        ("delete_objects", lambda: test_delete_objects(client, args.bucket)),
+    ]
+    print(f"boto3 version: {boto3.__version__}")
+    for name, test in tests:
+        try:
+            test()
+        except Exception as e:
+            print(f"\033[0;31m✗ boto3 {name}: {e}\033[0m")
+            return 1
+        print(f"\033[0;32m✓ boto3 {name}\033[0m")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
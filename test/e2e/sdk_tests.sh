@@ -0,0 +1,438 @@
+#!/bin/bash
+# E2E tests for AWS CLI and boto3 behavior: high-level transfers, presigned URLs,
+# checksum validation, multipart thresholds and pagination
+
+set -e
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+YELLOW='\033[1;33m'
+NC='\033[0m' # No Color
+
+# Configuration
+export SDK_SERVER_PORT=9093
+export SDK_SERVER_ADDR="http://localhost:${SDK_SERVER_PORT}"
+export SDK_TEST_BUCKET="test-sdk-bucket-e2e"
+export SDK_TEST_DATA_DIR=$(mktemp -d)
+export SDK_SERVER_DATA_DIR=$(mktemp -d)
+export SDK_SERVER_PID=""
+
+# Set restrictive permissions on temporary directories
+chmod 700 "${SDK_TEST_DATA_DIR}"
+chmod 700 "${SDK_SERVER_DATA_DIR}"
+
+# Test credentials, presigned URLs need a server checking signatures
+export TEST_ACCESS_KEY="test-sdk-access-key"
+export TEST_SECRET_KEY="test-sdk-secret-key"
+
+# Cleanup function
+cleanup_sdk() {
+    echo -e "\n${YELLOW}Cleaning up SDK tests...${NC}"
+    if [ -n "$SDK_SERVER_PID" ]; then
+        kill -TERM "$SDK_SERVER_PID" 2>/dev/null || true
+        sleep 1
+        kill -KILL "$SDK_SERVER_PID" 2>/dev/null || true
+        wait "$SDK_SERVER_PID" 2>/dev/null || true
+    fi
+    rm -rf "${SDK_TEST_DATA_DIR}"
+    rm -rf "${SDK_SERVER_DATA_DIR}"
+}
+
+# Setup function
+setup_sdk() {
+    echo -e "${YELLOW}Starting S3-compatible server for SDK tests...${NC}"
+    echo "Server address: ${SDK_SERVER_ADDR}"
+    echo "Test data directory: ${SDK_TEST_DATA_DIR}"
+    echo "Server data directory: ${SDK_SERVER_DATA_DIR}"
+
+    trap cleanup_sdk EXIT
+
+    # Build the server if not already built
+    if [ ! -f "./s3d" ]; then
+        echo -e "\n${YELLOW}Building server...${NC}"
+        go build -o ./s3d ./cmd/s3d
+        if [ $? -ne 0 ]; then
+            echo -e "${RED}Failed to build server${NC}"
+            exit 1
+        fi
+        echo -e "${GREEN}Server built successfully${NC}"
+    fi
+
+    # Start the server with authentication
+    echo -e "\n${YELLOW}Starting server with authentication...${NC}"
+    ./s3d -addr ":${SDK_SERVER_PORT}" -data "${SDK_SERVER_DATA_DIR}" -credentials "${TEST_ACCESS_KEY}:${TEST_SECRET_KEY}" > /dev/null 2>&1 &
+    SDK_SERVER_PID=$!
+    echo "Server PID: ${SDK_SERVER_PID}"
+
+    # Wait for server to start
+    echo "Waiting for server to be ready..."
+    for i in {1..30}; do
+        if curl -s "${SDK_SERVER_ADDR}" > /dev/null 2>&1; then
+            echo -e "${GREEN}Server is ready${NC}"
+            break
+        fi
+        if [ $i -eq 30 ]; then
+            echo -e "${RED}Server failed to start${NC}"
+            exit 1
+        fi
+        sleep 1
+    done
+
+    # Check if AWS CLI is installed
+    if ! command -v aws &> /dev/null; then
+        echo -e "${RED}AWS CLI is not installed. Please install it first.${NC}"
+        echo "See: https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html"
+        exit 1
+    fi
+
+    echo -e "\n${YELLOW}AWS CLI version:${NC}"
+    aws --version
+
+    # Configure AWS CLI with test credentials
+    export AWS_ACCESS_KEY_ID=${TEST_ACCESS_KEY}
+    export AWS_SECRET_ACCESS_KEY=${TEST_SECRET_KEY}
+    export AWS_DEFAULT_REGION=us-east-1
+
+    # s3d validates SHA256 checksums only, so only send checksums when a test asks for one
+    export AWS_REQUEST_CHECKSUM_CALCULATION=when_required
+    export AWS_RESPONSE_CHECKSUM_VALIDATION=when_required
+
+    # Use a private config file to set the transfer thresholds of the high-level commands
+    export AWS_CONFIG_FILE="${SDK_TEST_DATA_DIR}/config"
+    cat > "${AWS_CONFIG_FILE}" <<EOF
+[default]
+s3 =
+    multipart_threshold = 8MB
+    multipart_chunksize = 5MB
+EOF
+}
+
+# count_operations prints how many requests of an operation a --debug log made
+count_operations() {
+    local log="$1"
+    local operation="$2"
+    grep -c "OperationModel(name=${operation})" "$log" || true
+}
+
+# Test 1: Create bucket for SDK tests
+test_sdk_create_bucket() {
+    echo -e "\n${YELLOW}Test: Create bucket for SDK tests${NC}"
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 mb s3://${SDK_TEST_BUCKET}
+    echo -e "${GREEN}✓ Bucket created${NC}"
+}
+
+# Test 2: cp below and above the multipart threshold
+test_sdk_cp_multipart_threshold() {
+    echo -e "\n${YELLOW}Test: cp below and above the multipart threshold${NC}"
+
+    dd if=/dev/urandom of="${SDK_TEST_DATA_DIR}/small.bin" bs=1M count=4 2>/dev/null
+    dd if=/dev/urandom of="${SDK_TEST_DATA_DIR}/large.bin" bs=1M count=18 2>/dev/null
+
+    # A file below the threshold is sent with a single PutObject
+    aws --endpoint-url="${SDK_SERVER_ADDR}" --debug s3 cp "${SDK_TEST_DATA_DIR}/small.bin" s3://${SDK_TEST_BUCKET}/small.bin 2> "${SDK_TEST_DATA_DIR}/small.log"
+    PARTS=$(count_operations "${SDK_TEST_DATA_DIR}/small.log" UploadPart)
+    if [ "$PARTS" -ne 0 ]; then
+        echo -e "${RED}✗ Expected a single PutObject below the threshold, got ${PARTS} parts${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Small file uploaded with a single PutObject${NC}"
+
+    # A file above the threshold is split into 5MB parts
+    aws --endpoint-url="${SDK_SERVER_ADDR}" --debug s3 cp "${SDK_TEST_DATA_DIR}/large.bin" s3://${SDK_TEST_BUCKET}/large.bin 2> "${SDK_TEST_DATA_DIR}/large.log"
+    PARTS=$(count_operations "${SDK_TEST_DATA_DIR}/large.log" UploadPart)
+    if [ "$PARTS" -ne 4 ]; then
+        echo -e "${RED}✗ Expected 4 parts above the threshold, got ${PARTS}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Large file uploaded in ${PARTS} parts${NC}"
+
+    # Ranged parallel downloads must reassemble the same bytes
+    for name in small.bin large.bin; do
+        aws --endpoint-url="${SDK_SERVER_ADDR}" s3 cp s3://${SDK_TEST_BUCKET}/${name} "${SDK_TEST_DATA_DIR}/${name}.downloaded" --quiet
+        if ! cmp -s "${SDK_TEST_DATA_DIR}/${name}" "${SDK_TEST_DATA_DIR}/${name}.downloaded"; then
+            echo -e "${RED}✗ Downloaded ${name} differs from the upload${NC}"
+            exit 1
+        fi
+    done
+    echo -e "${GREEN}✓ Downloads match the uploads${NC}"
+
+    # A copy above the threshold goes through UploadPartCopy
+    aws --endpoint-url="${SDK_SERVER_ADDR}" --debug s3 cp s3://${SDK_TEST_BUCKET}/large.bin s3://${SDK_TEST_BUCKET}/large-copy.bin 2> "${SDK_TEST_DATA_DIR}/copy.log"
+    PARTS=$(count_operations "${SDK_TEST_DATA_DIR}/copy.log" UploadPartCopy)
+    if [ "$PARTS" -eq 0 ]; then
+        echo -e "${RED}✗ Expected the copy to use UploadPartCopy${NC}"
+        exit 1
+    fi
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 cp s3://${SDK_TEST_BUCKET}/large-copy.bin "${SDK_TEST_DATA_DIR}/large-copy.bin" --quiet
+    if ! cmp -s "${SDK_TEST_DATA_DIR}/large.bin" "${SDK_TEST_DATA_DIR}/large-copy.bin"; then
+        echo -e "${RED}✗ Multipart copy differs from the source${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Multipart copy used ${PARTS} UploadPartCopy requests${NC}"
+}
+
+# Test 3: sync uploads, skips unchanged files and deletes with --delete
+test_sdk_sync() {
+    echo -e "\n${YELLOW}Test: sync uploads, skips unchanged files and deletes${NC}"
+
+    SYNC_DIR="${SDK_TEST_DATA_DIR}/sync"
+    mkdir -p "${SYNC_DIR}/nested/deeper"
+    for i in 1 2 3; do
+        echo "sync file ${i}" > "${SYNC_DIR}/file${i}.txt"
+    done
+    echo "nested" > "${SYNC_DIR}/nested/deeper/file.txt"
+
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 sync "${SYNC_DIR}" s3://${SDK_TEST_BUCKET}/sync/
+    COUNT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls s3://${SDK_TEST_BUCKET}/sync/ --recursive | wc -l)
+    if [ "$COUNT" -ne 4 ]; then
+        echo -e "${RED}✗ Expected 4 synced objects, got ${COUNT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Initial sync uploaded 4 objects${NC}"
+
+    # A second sync compares sizes and modification times and has nothing to do
+    OUTPUT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 sync "${SYNC_DIR}" s3://${SDK_TEST_BUCKET}/sync/)
+    if [ -n "$OUTPUT" ]; then
+        echo -e "${RED}✗ Expected an unchanged sync to transfer nothing${NC}"
+        echo "$OUTPUT"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Unchanged sync transferred nothing${NC}"
+
+    rm "${SYNC_DIR}/file2.txt"
+    echo "changed content" > "${SYNC_DIR}/file3.txt"
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 sync "${SYNC_DIR}" s3://${SDK_TEST_BUCKET}/sync/ --delete
+    if aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls s3://${SDK_TEST_BUCKET}/sync/file2.txt > /dev/null 2>&1; then
+        echo -e "${RED}✗ Expected sync --delete to remove file2.txt${NC}"
+        exit 1
+    fi
+    CONTENT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 cp s3://${SDK_TEST_BUCKET}/sync/file3.txt -)
+    if [ "$CONTENT" != "changed content" ]; then
+        echo -e "${RED}✗ Expected sync to upload the changed file3.txt, got: ${CONTENT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ sync --delete removed and updated objects${NC}"
+
+    # Syncing back down reproduces the local tree
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 sync s3://${SDK_TEST_BUCKET}/sync/ "${SDK_TEST_DATA_DIR}/sync-down"
+    if ! diff -r "${SYNC_DIR}" "${SDK_TEST_DATA_DIR}/sync-down"; then
+        echo -e "${RED}✗ Downloaded tree differs from the local tree${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ sync down reproduced the local tree${NC}"
+}
+
+# Test 4: presigned URLs are accepted and tampering is rejected
+test_sdk_presign() {
+    echo -e "\n${YELLOW}Test: presigned URLs${NC}"
+
+    echo "presigned content" | aws --endpoint-url="${SDK_SERVER_ADDR}" s3 cp - s3://${SDK_TEST_BUCKET}/presigned.txt
+    URL=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 presign s3://${SDK_TEST_BUCKET}/presigned.txt --expires-in 300)
+
+    CONTENT=$(curl -sf "$URL")
+    if [ "$CONTENT" != "presigned content" ]; then
+        echo -e "${RED}✗ Presigned GET failed, got: ${CONTENT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Presigned GET returned the object${NC}"
+
+    # Pointing the signed URL at another key must break the signature
+    TAMPERED="${URL/presigned.txt/other.txt}"
+    STATUS=$(curl -s -o /dev/null -w "%{http_code}" "$TAMPERED")
+    if [ "$STATUS" != "403" ]; then
+        echo -e "${RED}✗ Expected 403 for a tampered presigned URL, got ${STATUS}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Tampered presigned URL rejected${NC}"
+}
+
+# Test 5: SHA256 checksums are validated on upload and returned on download
+test_sdk_checksums() {
+    echo -e "\n${YELLOW}Test: SHA256 checksum validation${NC}"
+
+    echo "checksum content" > "${SDK_TEST_DATA_DIR}/checksum.txt"
+    EXPECTED=$(openssl dgst -sha256 -binary "${SDK_TEST_DATA_DIR}/checksum.txt" | base64)
+
+    OUTPUT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3api put-object \
+        --bucket ${SDK_TEST_BUCKET} \
+        --key checksum.txt \
+        --body "${SDK_TEST_DATA_DIR}/checksum.txt" \
+        --checksum-sha256 "${EXPECTED}" \
+        --query ChecksumSHA256 --output text)
+    if [ "$OUTPUT" != "$EXPECTED" ]; then
+        echo -e "${RED}✗ Expected ChecksumSHA256 ${EXPECTED}, got ${OUTPUT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ PutObject accepted and echoed the SHA256 checksum${NC}"
+
+    # The CLI validates the returned checksum against the downloaded body
+    OUTPUT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3api get-object \
+        --bucket ${SDK_TEST_BUCKET} \
+        --key checksum.txt \
+        --checksum-mode ENABLED \
+        --query ChecksumSHA256 --output text \
+        "${SDK_TEST_DATA_DIR}/checksum.downloaded")
+    if [ "$OUTPUT" != "$EXPECTED" ]; then
+        echo -e "${RED}✗ Expected GetObject to return ChecksumSHA256 ${EXPECTED}, got ${OUTPUT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ GetObject returned a checksum matching the body${NC}"
+
+    # A checksum not matching the body must be rejected and nothing stored
+    WRONG=$(echo "other content" | openssl dgst -sha256 -binary | base64)
+    if OUTPUT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3api put-object \
+        --bucket ${SDK_TEST_BUCKET} \
+        --key bad-checksum.txt \
+        --body "${SDK_TEST_DATA_DIR}/checksum.txt" \
+        --checksum-sha256 "${WRONG}" 2>&1); then
+        echo -e "${RED}✗ Expected a mismatched checksum to be rejected${NC}"
+        exit 1
+    fi
+    if ! echo "$OUTPUT" | grep -q "BadDigest"; then
+        echo -e "${RED}✗ Expected BadDigest for a mismatched checksum${NC}"
+        echo "$OUTPUT"
+        exit 1
+    fi
+    if aws --endpoint-url="${SDK_SERVER_ADDR}" s3api head-object --bucket ${SDK_TEST_BUCKET} --key bad-checksum.txt > /dev/null 2>&1; then
+        echo -e "${RED}✗ Object with a mismatched checksum was stored${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Mismatched checksum rejected with BadDigest${NC}"
+}
+
+# Test 6: list-objects-v2 pagination through max-keys and continuation tokens
+test_sdk_pagination() {
+    echo -e "\n${YELLOW}Test: list-objects-v2 pagination${NC}"
+
+    for i in $(seq -w 1 25); do
+        echo "page ${i}" | aws --endpoint-url="${SDK_SERVER_ADDR}" s3 cp - s3://${SDK_TEST_BUCKET}/pages/key-${i}.txt --quiet
+    done
+
+    # Walk the pages by hand and check each one
+    TOKEN=""
+    PAGES=0
+    TOTAL=0
+    while true; do
+        ARGS=(--bucket ${SDK_TEST_BUCKET} --prefix pages/ --max-keys 10 --no-paginate)
+        if [ -n "$TOKEN" ]; then
+            ARGS+=(--continuation-token "$TOKEN")
+        fi
+        PAGE=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3api list-objects-v2 "${ARGS[@]}")
+        KEYS=$(echo "$PAGE" | python3 -c "import sys, json; print(json.load(sys.stdin)['KeyCount'])")
+        TOKEN=$(echo "$PAGE" | python3 -c "import sys, json; print(json.load(sys.stdin).get('NextContinuationToken', ''))")
+        PAGES=$((PAGES + 1))
+        TOTAL=$((TOTAL + KEYS))
+        if [ -z "$TOKEN" ]; then
+            break
+        fi
+        if [ "$KEYS" -ne 10 ]; then
+            echo -e "${RED}✗ Expected a truncated page to hold 10 keys, got ${KEYS}${NC}"
+            exit 1
+        fi
+    done
+    if [ "$PAGES" -ne 3 ] || [ "$TOTAL" -ne 25 ]; then
+        echo -e "${RED}✗ Expected 25 keys over 3 pages, got ${TOTAL} over ${PAGES}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Manual pagination returned 25 keys over 3 pages${NC}"
+
+    # The CLI paginator must follow the tokens on its own
+    COUNT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3api list-objects-v2 \
+        --bucket ${SDK_TEST_BUCKET} --prefix pages/ --page-size 7 \
+        --query 'length(Contents)' --output text)
+    if [ "$COUNT" -ne 25 ]; then
+        echo -e "${RED}✗ Expected the paginator to return 25 keys, got ${COUNT}${NC}"
+        exit 1
+    fi
+    COUNT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls s3://${SDK_TEST_BUCKET}/pages/ --page-size 4 | wc -l)
+    if [ "$COUNT" -ne 25 ]; then
+        echo -e "${RED}✗ Expected s3 ls to list 25 keys, got ${COUNT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ CLI paginators listed all 25 keys${NC}"
+}
+
+# Test 7: rm removes single objects and prefixes
+test_sdk_rm() {
+    echo -e "\n${YELLOW}Test: rm single objects and prefixes${NC}"
+
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 rm s3://${SDK_TEST_BUCKET}/small.bin
+    if aws --endpoint-url="${SDK_SERVER_ADDR}" s3api head-object --bucket ${SDK_TEST_BUCKET} --key small.bin > /dev/null 2>&1; then
+        echo -e "${RED}✗ small.bin still exists${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Single object removed${NC}"
+
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 rm s3://${SDK_TEST_BUCKET}/pages/ --recursive --exclude "*" --include "*-1*"
+    COUNT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls s3://${SDK_TEST_BUCKET}/pages/ | wc -l)
+    if [ "$COUNT" -ne 15 ]; then
+        echo -e "${RED}✗ Expected 15 keys left after a filtered rm, got ${COUNT}${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Filtered recursive rm removed 10 keys${NC}"
+
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 rm s3://${SDK_TEST_BUCKET}/ --recursive
+    COUNT=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls s3://${SDK_TEST_BUCKET}/ --recursive | wc -l)
+    if [ "$COUNT" -ne 0 ]; then
+        echo -e "${RED}✗ Expected an empty bucket after rm --recursive, got ${COUNT} keys${NC}"
+        exit 1
+    fi
+    echo -e "${GREEN}✓ Recursive rm emptied the bucket${NC}"
+}
+
+# Test 8: boto3 high-level transfers, skipped when boto3 is not installed
+test_sdk_boto3() {
+    echo -e "\n${YELLOW}Test: boto3 transfers${NC}"
+
+    if ! python3 -c "import boto3" > /dev/null 2>&1; then
+        echo -e "${YELLOW}! boto3 is not installed, skipping (install with: pip install boto3)${NC}"
+        return 0
+    fi
+
+    python3 "${SCRIPT_DIR}/boto3_tests.py" \
+        --endpoint-url "${SDK_SERVER_ADDR}" \
+        --bucket ${SDK_TEST_BUCKET} \
+        --data-dir "${SDK_TEST_DATA_DIR}"
+    echo -e "${GREEN}✓ boto3 tests passed${NC}"
+}
+
+# Cleanup test: Delete the bucket
+test_sdk_cleanup() {
+    echo -e "\n${YELLOW}Test: Cleanup SDK test bucket${NC}"
+    aws --endpoint-url="${SDK_SERVER_ADDR}" s3 rb s3://${SDK_TEST_BUCKET} --force
+
+    BUCKETS=$(aws --endpoint-url="${SDK_SERVER_ADDR}" s3 ls)
+    if ! echo "$BUCKETS" | grep -q "${SDK_TEST_BUCKET}"; then
+        echo -e "${GREEN}✓ Cleanup successful${NC}"
+    else
+        echo -e "${RED}✗ Bucket still exists${NC}"
+        exit 1
+    fi
+}
+
+# Main execution
+main() {
+    setup_sdk
+
+    test_sdk_create_bucket
+    test_sdk_cp_multipart_threshold
+    test_sdk_sync
+    test_sdk_presign
+    test_sdk_checksums
+    test_sdk_pagination
+    test_sdk_rm
+    test_sdk_boto3
+    test_sdk_cleanup
+
+    echo -e "\n${GREEN}========================================${NC}"
+    echo -e "${GREEN}All AWS CLI and boto3 e2e tests passed!${NC}"
+    echo -e "${GREEN}========================================${NC}"
+}
+
+# Run if executed directly
+if [ "${BASH_SOURCE[0]}" == "${0}" ]; then
+    main
+fi
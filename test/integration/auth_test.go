@@ -704,3 +704,119 @@ func TestMultipleCredentials(t *testing.T) {
 		})
 	}
 }
+
+func TestMultipartUploadInitiator(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "s3d-initiator-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := storage.NewStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	authenticator := auth.NewAWS4Authenticator()
+	authenticator.AddCredentials("user1-key", "user1-secret")
+	authenticator.AddCredentials("user2-key", "user2-secret")
+
+	s3Handler := server.NewS3Handler(store, server.WithRegion("us-east-1"))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	addr := listener.Addr().String()
+	srv := &http.Server{Handler: authenticator.AuthMiddleware(s3Handler)}
+
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	ctx := context.Background()
+	newClient := func(accessKey, secretKey string) *s3.Client {
+		cfg, err := config.LoadDefaultConfig(ctx,
+			config.WithRegion("us-east-1"),
+			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+			config.WithEndpointResolver(aws.EndpointResolverFunc(
+				func(service, region string) (aws.Endpoint, error) {
+					return aws.Endpoint{
+						URL:               "http://" + addr,
+						SigningRegion:     "us-east-1",
+						HostnameImmutable: true,
+					}, nil
+				}),
+			),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create config: %v", err)
+		}
+		return s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = true
+		})
+	}
+	user1 := newClient("user1-key", "user1-secret")
+	user2 := newClient("user2-key", "user2-secret")
+
+	bucketName := "initiator-bucket"
+	if _, err := user1.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucketName)}); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	initiated := map[string]string{}
+	for accessKey, client := range map[string]*s3.Client{"user1-key": user1, "user2-key": user2} {
+		output, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String("object"),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create multipart upload as %s: %v", accessKey, err)
+		}
+		initiated[aws.ToString(output.UploadId)] = accessKey
+	}
+
+	t.Run("ListMultipartUploads", func(t *testing.T) {
+		output, err := user1.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{Bucket: aws.String(bucketName)})
+		if err != nil {
+			t.Fatalf("Failed to list multipart uploads: %v", err)
+		}
+		if len(output.Uploads) != len(initiated) {
+			t.Fatalf("Expected %d uploads, got %d", len(initiated), len(output.Uploads))
+		}
+		initiatorIDs := map[string]bool{}
+		for _, upload := range output.Uploads {
+			want := initiated[aws.ToString(upload.UploadId)]
+			if got := aws.ToString(upload.Initiator.DisplayName); got != want {
+				t.Errorf("Expected initiator %q, got %q", want, got)
+			}
+			initiatorIDs[aws.ToString(upload.Initiator.ID)] = true
+			if got := aws.ToString(upload.Owner.ID); got == "" || got == aws.ToString(upload.Initiator.ID) {
+				t.Errorf("Expected the upload of %q to be owned by the bucket owner, got %q", want, got)
+			}
+		}
+		if len(initiatorIDs) != len(initiated) {
+			t.Errorf("Expected a canonical ID for each initiator, got %v", initiatorIDs)
+		}
+	})
+
+	t.Run("ListParts", func(t *testing.T) {
+		for uploadID, want := range initiated {
+			output, err := user1.ListParts(ctx, &s3.ListPartsInput{
+				Bucket:   aws.String(bucketName),
+				Key:      aws.String("object"),
+				UploadId: aws.String(uploadID),
+			})
+			if err != nil {
+				t.Fatalf("Failed to list parts: %v", err)
+			}
+			if got := aws.ToString(output.Initiator.DisplayName); got != want {
+				t.Errorf("Expected initiator %q, got %q", want, got)
+			}
+			if got := aws.ToString(output.Owner.ID); got == "" || got == aws.ToString(output.Initiator.ID) {
+				t.Errorf("Expected the upload of %q to be owned by the bucket owner, got %q", want, got)
+			}
+		}
+	})
+}
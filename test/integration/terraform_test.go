@@ -0,0 +1,159 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// terraformLockInfo is the lock info Terraform writes to the .tflock file next to a state
+type terraformLockInfo struct {
+	ID        string
+	Operation string
+	Who       string
+}
+
+// TestTerraformStateLocking follows the requests of the S3 backend of Terraform 1.10+ with use_lockfile,
+// which locks a state by creating <key>.tflock with If-None-Match and unlocks it by deleting the file
+func TestTerraformStateLocking(t *testing.T) {
+	bucketName := "test-terraform-state"
+	stateKey := "network/terraform.tfstate"
+	lockKey := stateKey + ".tflock"
+
+	_, err := ts.client.CreateBucket(ts.ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// lock creates the lock file like the backend does, failing if it exists
+	lock := func(info terraformLockInfo) error {
+		body, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		_, err = ts.client.PutObject(ts.ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(lockKey),
+			Body:              bytes.NewReader(body),
+			ContentType:       aws.String("application/json"),
+			IfNoneMatch:       aws.String("*"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		return err
+	}
+	// lockInfo reads the lock file, as the backend does to report the holder and before unlocking
+	lockInfo := func() (*terraformLockInfo, error) {
+		output, err := ts.client.GetObject(ts.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(lockKey),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer output.Body.Close()
+		var info terraformLockInfo
+		if err := json.NewDecoder(output.Body).Decode(&info); err != nil {
+			return nil, err
+		}
+		return &info, nil
+	}
+	// unlock deletes the lock file if it holds the lock of id
+	unlock := func(id string) error {
+		info, err := lockInfo()
+		if err != nil {
+			return err
+		}
+		if info.ID != id {
+			return fmt.Errorf("lock ID %q does not match existing lock ID %q", id, info.ID)
+		}
+		_, err = ts.client.DeleteObject(ts.ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(lockKey),
+		})
+		return err
+	}
+
+	// Concurrent runs race for the lock, exactly one wins and the others learn who holds it
+	const runs = 8
+	var wg sync.WaitGroup
+	errs := make([]error, runs)
+	for i := range runs {
+		wg.Go(func() {
+			errs[i] = lock(terraformLockInfo{ID: fmt.Sprintf("run-%d", i), Operation: "OperationTypeApply", Who: "ci"})
+		})
+	}
+	wg.Wait()
+	holder := ""
+	for i, err := range errs {
+		switch {
+		case err == nil && holder == "":
+			holder = fmt.Sprintf("run-%d", i)
+		case err == nil:
+			t.Fatalf("Expected a single run to hold the lock, %s and run-%d do", holder, i)
+		case !strings.Contains(err.Error(), "PreconditionFailed"):
+			t.Errorf("Expected PreconditionFailed for run-%d, got %v", i, err)
+		}
+	}
+	if holder == "" {
+		t.Fatal("Expected a run to hold the lock")
+	}
+	info, err := lockInfo()
+	if err != nil || info.ID != holder {
+		t.Fatalf("Expected the lock info of %s, got %+v (%v)", holder, info, err)
+	}
+
+	t.Run("State", func(t *testing.T) {
+		state := `{"version": 4, "serial": 1, "resources": []}`
+		_, err := ts.client.PutObject(ts.ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(bucketName),
+			Key:               aws.String(stateKey),
+			Body:              strings.NewReader(state),
+			ContentType:       aws.String("application/json"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		if err != nil {
+			t.Fatalf("Failed to write the state: %v", err)
+		}
+		output, err := ts.client.GetObject(ts.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(stateKey),
+		})
+		if err != nil {
+			t.Fatalf("Failed to read the state: %v", err)
+		}
+		defer output.Body.Close()
+		if data, _ := io.ReadAll(output.Body); string(data) != state {
+			t.Errorf("Expected the state to be read back, got %s", data)
+		}
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		if err := unlock("other-run"); err == nil {
+			t.Fatal("Expected unlocking with another lock ID to fail")
+		}
+		if err := unlock(holder); err != nil {
+			t.Fatalf("Failed to unlock: %v", err)
+		}
+		if _, err := lockInfo(); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+			t.Errorf("Expected the lock file to be gone, got %v", err)
+		}
+
+		// The next run locks right after the delete
+		if err := lock(terraformLockInfo{ID: "next-run", Operation: "OperationTypePlan", Who: "ci"}); err != nil {
+			t.Fatalf("Expected the lock to be acquired after the unlock, got %v", err)
+		}
+		if err := unlock("next-run"); err != nil {
+			t.Fatalf("Failed to unlock: %v", err)
+		}
+	})
+}